@@ -40,6 +40,7 @@ var k8sMaxage, destroyedenvsMaxage, eventlogsMaxage time.Duration
 func init() {
 	cleanupCmd.Flags().DurationVar(&k8sMaxage, "k8s-objs-max-age", 14*24*time.Hour, "Maximum age for orphaned k8s objects")
 	cleanupCmd.Flags().DurationVar(&destroyedenvsMaxage, "destroyed-envs-max-age", 30*24*time.Hour, "Maximum age for destroyed environment DB records")
+	cleanupCmd.Flags().StringVar(&k8sClustersJSON, "k8s-clusters-json", "", "optional JSON-encoded list of clusters to clean up in addition to the default cluster (same format as the server flag)")
 	cleanupCmd.Flags().DurationVar(&eventlogsMaxage, "event-logs-max-age", 30*24*time.Hour, "Maximum age for event log DB records")
 	RootCmd.AddCommand(cleanupCmd)
 }
@@ -77,7 +78,11 @@ func cleanup(cmd *cobra.Command, args []string) {
 
 	cleaner.Clean()
 
-	ci, err := metahelm.NewChartInstaller(nil, dl, nil, nil, k8sConfig.GroupBindings, k8sConfig.PrivilegedRepoWhitelist, k8sConfig.SecretInjections, k8sClientConfig.JWTPath, true, helmClientConfig)
+	if err := k8sConfig.ProcessClusters(k8sClustersJSON); err != nil {
+		log.Fatalf("error in k8s clusters: %v", err)
+	}
+
+	ci, err := metahelm.NewChartInstaller(nil, dl, nil, nil, k8sConfig, k8sClientConfig.JWTPath, true, helmClientConfig)
 	if err != nil {
 		log.Fatalf("error getting metahelm chart installer: %v", err)
 	}
@@ -199,7 +199,7 @@ func generateLocalMetaGetter(dl persistence.DataLayer, scb ghclient.StatusCallba
 	if triggeringRepoUsesWorkingTree {
 		ri.HeadSHA = repoRefOverrides[ri.GitHubRepoName]
 	}
-	return &meta.DataGetter{RepoRefOverrides: repoRefOverrides, RC: lw, FS: osfs.New("")}, ri, wd, ctx
+	return &meta.DataGetter{RepoRefOverrides: repoRefOverrides, RC: lw, FS: osfs.New(""), CF: meta.NewCachingHelmChartFetcher(nil)}, ri, wd, ctx
 }
 
 func configCheck(cmd *cobra.Command, args []string) {
@@ -233,12 +233,12 @@ func configCheck(cmd *cobra.Command, args []string) {
 		perr("error creating temp file: %v", err)
 		return
 	}
-	cl, err := mg.FetchCharts(ctx, rc, tempd)
+	cl, err := mg.FetchCharts(ctx, rc, tempd, false)
 	if err != nil {
 		perr("error fetching charts: %v", err)
 		return
 	}
-	ci, err := metahelm.NewChartInstallerWithClientsetFromContext(nil, persistence.NewFakeDataLayer(), osfs.New(""), &metrics.FakeCollector{}, k8sConfig.GroupBindings, k8sConfig.PrivilegedRepoWhitelist, k8sConfig.SecretInjections, testEnvCfg.kubeCfgPath, helmClientConfig)
+	ci, err := metahelm.NewChartInstallerWithClientsetFromContext(nil, persistence.NewFakeDataLayer(), osfs.New(""), &metrics.FakeCollector{}, k8sConfig, testEnvCfg.kubeCfgPath, helmClientConfig)
 	if err != nil {
 		perr("error creating chart installer: %v", err)
 		return
@@ -569,6 +569,10 @@ func displayInfoTerminal(rc *models.RepoConfig, err error, mg meta.Getter) int {
 			addRow("Type:", "chart_path")
 		case d.AppMetadata.ChartRepoPath != "":
 			addRow("Type:", "chart_repo_path")
+		case d.AppMetadata.ChartRepo != nil:
+			addRow("Type:", "chart_repo")
+		case d.AppMetadata.ChartOCI != nil:
+			addRow("Type:", "chart_oci")
 		default:
 			addRow("Type:", "[red::]unknown[-::]")
 		}
@@ -593,9 +597,14 @@ func displayInfoTerminal(rc *models.RepoConfig, err error, mg meta.Getter) int {
 		addRow("Chart Environment Name Value:", d.AppMetadata.EnvNameValue)
 
 		var cp string
-		if d.AppMetadata.ChartPath != "" {
+		switch {
+		case d.AppMetadata.ChartPath != "":
 			cp = d.AppMetadata.ChartPath
-		} else {
+		case d.AppMetadata.ChartRepo != nil:
+			cp = d.AppMetadata.ChartRepo.String()
+		case d.AppMetadata.ChartOCI != nil:
+			cp = d.AppMetadata.ChartOCI.String()
+		default:
 			cp = d.AppMetadata.ChartRepoPath
 		}
 		addRow("Chart:", cp)
@@ -765,12 +774,12 @@ func displayInfoTerminal(rc *models.RepoConfig, err error, mg meta.Getter) int {
 
 	go func() {
 		pages.ShowPage("modal")
-		cl, err := mg.FetchCharts(ctx, rc, tempd)
+		cl, err := mg.FetchCharts(ctx, rc, tempd, false)
 		if err != nil {
 			errorModal("Error Processing Charts", "Check your chart configuration.", err)
 			return
 		}
-		ci, err := metahelm.NewChartInstallerWithClientsetFromContext(nil, persistence.NewFakeDataLayer(), osfs.New(""), &metrics.FakeCollector{}, k8sConfig.GroupBindings, k8sConfig.PrivilegedRepoWhitelist, k8sConfig.SecretInjections, testEnvCfg.kubeCfgPath, helmClientConfig)
+		ci, err := metahelm.NewChartInstallerWithClientsetFromContext(nil, persistence.NewFakeDataLayer(), osfs.New(""), &metrics.FakeCollector{}, k8sConfig, testEnvCfg.kubeCfgPath, helmClientConfig)
 		if err != nil {
 			errorModal("Error Instantiating Chart Installer", "Bug!", err)
 			return
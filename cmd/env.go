@@ -0,0 +1,448 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/dollarshaveclub/acyl/pkg/apiclient"
+	"github.com/google/uuid"
+	"github.com/mitchellh/go-homedir"
+	"github.com/spf13/cobra"
+)
+
+// envCmd represents the env command
+var envCmd = &cobra.Command{
+	Use:   "env",
+	Short: "manage environments on a remote Acyl server",
+	Long: `env and subcommands query and manage environments on a remote Acyl server using the v2 API.
+
+Requests are authenticated with an API key, which can be created in the Acyl UI. The server URL and API key are read
+from the config file (--config), which is YAML in the following format:
+
+api_url: https://acyl.example.com
+api_key: <API key>
+
+The environment variables ACYL_API_URL and ACYL_API_KEY override the config file, and the --api-url and --api-key flags override both.`,
+}
+
+var envListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "list environments",
+	Long: `List the environments visible to the API key matching the search flags.
+If no search flags are provided, environments with status "success" are listed.`,
+	Args: cobra.NoArgs,
+	Run:  envList,
+}
+
+var envGetCmd = &cobra.Command{
+	Use:   "get NAME",
+	Short: "get environment details",
+	Args:  cobra.ExactArgs(1),
+	Run:   envGet,
+}
+
+var envLogsCmd = &cobra.Command{
+	Use:   "logs NAME",
+	Short: "get the event log of an environment",
+	Long: `Get the log of the most recent event (create, update or destroy) of an environment, or the event given by --event.
+With --follow, new log lines are printed as they are written until the event finishes. The command exits with a non-zero status if the event failed.`,
+	Args: cobra.ExactArgs(1),
+	Run:  envLogs,
+}
+
+var envStatusCmd = &cobra.Command{
+	Use:   "status NAME",
+	Short: "get the event status of an environment",
+	Long:  `Get the status of the most recent event (create, update or destroy) of an environment, or the event given by --event.`,
+	Args:  cobra.ExactArgs(1),
+	Run:   envStatus,
+}
+
+var envRebuildCmd = &cobra.Command{
+	Use:   "rebuild NAME",
+	Short: "rebuild an environment",
+	Long: `Rebuild an environment at its current revision. With --full the environment is torn down and recreated, otherwise it is synchronized.
+The API key owner must have write access to the environment repo.`,
+	Args: cobra.ExactArgs(1),
+	Run:  envRebuild,
+}
+
+var envDestroyCmd = &cobra.Command{
+	Use:   "destroy NAME",
+	Short: "destroy an environment",
+	Long:  `Destroy an environment. The API key owner must have write access to the environment repo.`,
+	Args:  cobra.ExactArgs(1),
+	Run:   envDestroy,
+}
+
+var envPodsCmd = &cobra.Command{
+	Use:   "pods NAME",
+	Short: "list the pods of an environment",
+	Args:  cobra.ExactArgs(1),
+	Run:   envPods,
+}
+
+var envPodLogsCmd = &cobra.Command{
+	Use:   "pod-logs NAME POD",
+	Short: "get the logs of a pod in an environment",
+	Long:  `Get the most recent logs of a pod in an environment. Output is always plain text.`,
+	Args:  cobra.ExactArgs(2),
+	Run:   envPodLogs,
+}
+
+var envClientCfg struct {
+	configPath, apiURL, apiKey, output string
+	timeout                            time.Duration
+}
+
+var envSearch apiclient.SearchParameters
+
+var envEventCfg struct {
+	eventID        string
+	follow, full   bool
+	followInterval time.Duration
+}
+
+var envPodLogsCfg struct {
+	container string
+	lines     uint
+}
+
+func init() {
+	hd, err := homedir.Dir()
+	if err != nil {
+		log.Printf("error getting home directory: %v", err)
+	}
+	envCmd.PersistentFlags().StringVar(&envClientCfg.configPath, "config", filepath.Join(hd, ".acyl", "config.yml"), "path to client config file")
+	envCmd.PersistentFlags().StringVar(&envClientCfg.apiURL, "api-url", "", "Acyl server URL (overrides config file and ACYL_API_URL)")
+	envCmd.PersistentFlags().StringVar(&envClientCfg.apiKey, "api-key", "", "Acyl API key (overrides config file and ACYL_API_KEY)")
+	envCmd.PersistentFlags().StringVarP(&envClientCfg.output, "output", "o", "table", "output format (one of: table, json)")
+	envCmd.PersistentFlags().DurationVar(&envClientCfg.timeout, "timeout", apiclient.DefaultTimeout, "timeout for each API request")
+
+	// list
+	envListCmd.Flags().StringVar(&envSearch.Repo, "repo", "", "repo name (ex: acme/widgets)")
+	envListCmd.Flags().UintVar(&envSearch.PullRequest, "pr", 0, "pull request number (requires --repo)")
+	envListCmd.Flags().StringVar(&envSearch.SourceSHA, "source-sha", "", "source commit SHA")
+	envListCmd.Flags().StringVar(&envSearch.SourceBranch, "source-branch", "", "source branch")
+	envListCmd.Flags().StringVar(&envSearch.User, "user", "", "GitHub user that triggered the environment")
+	envListCmd.Flags().StringVar(&envSearch.Status, "status", "", "environment status (one of: spawned, success, failure, updating, suspended, cancelled, destroyed)")
+	envListCmd.Flags().StringVar(&envSearch.TrackingRef, "tracking-ref", "", "tracking ref (requires --repo)")
+
+	// logs & status
+	for _, c := range []*cobra.Command{envLogsCmd, envStatusCmd} {
+		c.Flags().StringVar(&envEventCfg.eventID, "event", "", "event log ID (default: the most recent event of the environment)")
+	}
+
+	// logs, rebuild & destroy
+	for _, c := range []*cobra.Command{envLogsCmd, envRebuildCmd, envDestroyCmd} {
+		c.Flags().BoolVarP(&envEventCfg.follow, "follow", "f", false, "follow the event log until the event finishes (output is always plain text)")
+		c.Flags().DurationVar(&envEventCfg.followInterval, "follow-interval", 2*time.Second, "how often to poll for new event log lines when following")
+	}
+	envRebuildCmd.Flags().BoolVar(&envEventCfg.full, "full", false, "tear down and recreate the environment instead of synchronizing it")
+
+	// pod-logs
+	envPodLogsCmd.Flags().StringVar(&envPodLogsCfg.container, "container", "", "container name (required if the pod has more than one container)")
+	envPodLogsCmd.Flags().UintVar(&envPodLogsCfg.lines, "lines", 0, "number of lines to get (default: server default)")
+
+	envCmd.AddCommand(envListCmd, envGetCmd, envLogsCmd, envStatusCmd, envRebuildCmd, envDestroyCmd, envPodsCmd, envPodLogsCmd)
+	RootCmd.AddCommand(envCmd)
+}
+
+// envClient returns an API client from the config file, environment and flags
+func envClient() *apiclient.Client {
+	switch envClientCfg.output {
+	case "table", "json":
+	default:
+		clierr("invalid output format: %v", envClientCfg.output)
+	}
+	cfg, err := apiclient.LoadConfig(envClientCfg.configPath)
+	if err != nil {
+		clierr("error loading config: %v", err)
+	}
+	if envClientCfg.apiURL != "" {
+		cfg.APIURL = envClientCfg.apiURL
+	}
+	if envClientCfg.apiKey != "" {
+		cfg.APIKey = envClientCfg.apiKey
+	}
+	if cfg.APIURL == "" {
+		clierr("api url is required (set in config file, %v or --api-url)", apiclient.APIURLEnvVar)
+	}
+	if cfg.APIKey == "" {
+		clierr("api key is required (set in config file, %v or --api-key)", apiclient.APIKeyEnvVar)
+	}
+	return apiclient.NewClient(cfg.APIURL, cfg.APIKey, envClientCfg.timeout)
+}
+
+// envContext returns a context that is cancelled on interrupt
+func envContext() (context.Context, context.CancelFunc) {
+	return signal.NotifyContext(context.Background(), os.Interrupt)
+}
+
+// envOutput writes v to stdout as JSON, or calls table with a tabwriter if the output format is table
+func envOutput(v interface{}, table func(w io.Writer)) {
+	if envClientCfg.output == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(v); err != nil {
+			clierr("error marshaling output: %v", err)
+		}
+		return
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	table(tw)
+	tw.Flush()
+}
+
+func timeOrDash(t *time.Time) string {
+	if t == nil || t.IsZero() {
+		return "-"
+	}
+	return t.Local().Format(time.RFC3339)
+}
+
+// envRevision returns the PR number or ref an environment was created from
+func envRevision(env apiclient.Environment) string {
+	switch {
+	case env.PullRequest > 0:
+		return fmt.Sprintf("#%v", env.PullRequest)
+	case env.SourceRef != "":
+		return env.SourceRef
+	default:
+		return env.SourceBranch
+	}
+}
+
+func envList(cmd *cobra.Command, args []string) {
+	c := envClient()
+	ctx, cf := envContext()
+	defer cf()
+	sp := envSearch
+	if sp == (apiclient.SearchParameters{}) {
+		sp.Status = "success"
+	}
+	envs, err := c.SearchEnvs(ctx, sp)
+	if err != nil {
+		clierr("error listing environments: %v", err)
+	}
+	sort.Slice(envs, func(i, j int) bool { return envs[i].Created.After(envs[j].Created) })
+	envOutput(envs, func(w io.Writer) {
+		fmt.Fprintln(w, "NAME\tREPO\tREVISION\tSTATUS\tUSER\tCREATED\tEXPIRES")
+		for _, env := range envs {
+			fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\t%v\n", env.Name, env.Repo, envRevision(env), env.Status, env.User, timeOrDash(&env.Created), timeOrDash(env.Expires))
+		}
+	})
+}
+
+func envGet(cmd *cobra.Command, args []string) {
+	c := envClient()
+	ctx, cf := envContext()
+	defer cf()
+	env, err := c.GetEnv(ctx, args[0])
+	if err != nil {
+		clierr("error getting environment: %v", err)
+	}
+	envOutput(env, func(w io.Writer) {
+		fmt.Fprintf(w, "Name:\t%v\n", env.Name)
+		fmt.Fprintf(w, "Repo:\t%v\n", env.Repo)
+		fmt.Fprintf(w, "Revision:\t%v\n", envRevision(env))
+		fmt.Fprintf(w, "Source:\t%v (%v)\n", env.SourceBranch, env.SourceSHA)
+		fmt.Fprintf(w, "Base:\t%v (%v)\n", env.BaseBranch, env.BaseSHA)
+		fmt.Fprintf(w, "Status:\t%v\n", env.Status)
+		fmt.Fprintf(w, "User:\t%v\n", env.User)
+		fmt.Fprintf(w, "Namespace:\t%v\n", env.Namespace)
+		fmt.Fprintf(w, "Created:\t%v\n", timeOrDash(&env.Created))
+		fmt.Fprintf(w, "Last activity:\t%v\n", timeOrDash(&env.LastActivity))
+		fmt.Fprintf(w, "Expires:\t%v\n", timeOrDash(env.Expires))
+		fmt.Fprintf(w, "Pinned until:\t%v\n", timeOrDash(env.PinnedUntil))
+		repos := make([]string, 0, len(env.RefMap))
+		for repo := range env.RefMap {
+			repos = append(repos, repo)
+		}
+		sort.Strings(repos)
+		fmt.Fprintln(w, "\nREPO\tREF\tSHA")
+		for _, repo := range repos {
+			fmt.Fprintf(w, "%v\t%v\t%v\n", repo, env.RefMap[repo], env.CommitSHAMap[repo])
+		}
+		fmt.Fprintln(w, "\nTIME\tEVENT")
+		for _, e := range env.Events {
+			fmt.Fprintf(w, "%v\t%v\n", timeOrDash(&e.Timestamp), e.Message)
+		}
+	})
+}
+
+// envEventID returns the event ID from the --event flag, or the most recent event of the environment
+func envEventID(ctx context.Context, c *apiclient.Client, name string) uuid.UUID {
+	if envEventCfg.eventID != "" {
+		id, err := uuid.Parse(envEventCfg.eventID)
+		if err != nil {
+			clierr("invalid event ID: %v", err)
+		}
+		return id
+	}
+	events, err := c.GetEnvEvents(ctx, name)
+	if err != nil {
+		clierr("error getting environment events: %v", err)
+	}
+	if len(events) == 0 {
+		clierr("environment has no events")
+	}
+	id, err := uuid.Parse(events[0].EventID)
+	if err != nil {
+		clierr("invalid event ID from server: %v", err)
+	}
+	return id
+}
+
+// envFollow prints the event log as it is written and exits with an error if the event did not succeed
+func envFollow(ctx context.Context, c *apiclient.Client, id uuid.UUID) {
+	es, err := c.FollowEventLog(ctx, id, envEventCfg.followInterval, func(line string) { fmt.Println(line) })
+	if err != nil {
+		clierr("error following event log: %v", err)
+	}
+	if es.Config.Status != "done" {
+		clierr("event %v: %v", es.Config.Status, id)
+	}
+}
+
+func envLogs(cmd *cobra.Command, args []string) {
+	c := envClient()
+	ctx, cf := envContext()
+	defer cf()
+	id := envEventID(ctx, c, args[0])
+	if envEventCfg.follow {
+		envFollow(ctx, c, id)
+		return
+	}
+	el, err := c.GetEventLog(ctx, id)
+	if err != nil {
+		clierr("error getting event log: %v", err)
+	}
+	if envClientCfg.output == "json" {
+		envOutput(el, nil)
+		return
+	}
+	// log lines are printed directly so that any tabs are not aligned as table cells
+	for _, line := range el.Log {
+		fmt.Println(line)
+	}
+}
+
+func envStatus(cmd *cobra.Command, args []string) {
+	c := envClient()
+	ctx, cf := envContext()
+	defer cf()
+	es, err := c.GetEventStatus(ctx, envEventID(ctx, c, args[0]))
+	if err != nil {
+		clierr("error getting event status: %v", err)
+	}
+	envOutput(es, func(w io.Writer) {
+		fmt.Fprintf(w, "Type:\t%v\n", es.Config.Type)
+		fmt.Fprintf(w, "Status:\t%v\n", es.Config.Status)
+		fmt.Fprintf(w, "Environment:\t%v\n", es.Config.EnvName)
+		fmt.Fprintf(w, "Namespace:\t%v\n", es.Config.K8sNamespace)
+		fmt.Fprintf(w, "Repo:\t%v\n", es.Config.TriggeringRepo)
+		fmt.Fprintf(w, "Pull request:\t%v\n", es.Config.PullRequest)
+		fmt.Fprintf(w, "User:\t%v\n", es.Config.GitHubUser)
+		fmt.Fprintf(w, "Revision:\t%v (%v)\n", es.Config.Branch, es.Config.Revision)
+		fmt.Fprintf(w, "Started:\t%v\n", timeOrDash(es.Config.Started))
+		fmt.Fprintf(w, "Completed:\t%v\n", timeOrDash(es.Config.Completed))
+		fmt.Fprintf(w, "Processing time:\t%v\n", es.Config.ProcessingTime)
+		names := make([]string, 0, len(es.Tree))
+		for name := range es.Tree {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		fmt.Fprintln(w, "\nDEPENDENCY\tPARENT\tIMAGE\tCHART")
+		for _, name := range names {
+			n := es.Tree[name]
+			image := "-"
+			switch {
+			case n.Image == nil:
+			case n.Image.Error:
+				image = "error"
+			case n.Image.Completed != nil:
+				image = "built"
+			default:
+				image = "building"
+			}
+			parent := n.Parent
+			if parent == "" {
+				parent = "-"
+			}
+			fmt.Fprintf(w, "%v\t%v\t%v\t%v\n", name, parent, image, n.Chart.Status)
+		}
+	})
+}
+
+// envWriteResult outputs the event log ID of an asynchronous operation, or follows its event log
+func envWriteResult(ctx context.Context, c *apiclient.Client, id uuid.UUID) {
+	if envEventCfg.follow {
+		envFollow(ctx, c, id)
+		return
+	}
+	envOutput(map[string]uuid.UUID{"event_log_id": id}, func(w io.Writer) {
+		fmt.Fprintf(w, "Event log ID:\t%v\n", id)
+	})
+}
+
+func envRebuild(cmd *cobra.Command, args []string) {
+	c := envClient()
+	ctx, cf := envContext()
+	defer cf()
+	id, err := c.RebuildEnv(ctx, args[0], envEventCfg.full)
+	if err != nil {
+		clierr("error rebuilding environment: %v", err)
+	}
+	envWriteResult(ctx, c, id)
+}
+
+func envDestroy(cmd *cobra.Command, args []string) {
+	c := envClient()
+	ctx, cf := envContext()
+	defer cf()
+	id, err := c.DestroyEnv(ctx, args[0])
+	if err != nil {
+		clierr("error destroying environment: %v", err)
+	}
+	envWriteResult(ctx, c, id)
+}
+
+func envPods(cmd *cobra.Command, args []string) {
+	c := envClient()
+	ctx, cf := envContext()
+	defer cf()
+	pods, err := c.GetEnvPods(ctx, args[0])
+	if err != nil {
+		clierr("error getting pods: %v", err)
+	}
+	envOutput(pods, func(w io.Writer) {
+		fmt.Fprintln(w, "NAME\tREADY\tSTATUS\tRESTARTS\tAGE")
+		for _, p := range pods {
+			fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\n", p.Name, p.Ready, p.Status, p.Restarts, p.Age)
+		}
+	})
+}
+
+func envPodLogs(cmd *cobra.Command, args []string) {
+	c := envClient()
+	ctx, cf := envContext()
+	defer cf()
+	rc, err := c.GetPodLogs(ctx, args[0], args[1], envPodLogsCfg.container, envPodLogsCfg.lines)
+	if err != nil {
+		clierr("error getting pod logs: %v", err)
+	}
+	defer rc.Close()
+	if _, err := io.Copy(os.Stdout, rc); err != nil {
+		clierr("error reading pod logs: %v", err)
+	}
+}
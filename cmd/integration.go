@@ -99,7 +99,7 @@ func integration(cmd *cobra.Command, args []string) {
 		// this is only used to create valid payload signatures
 		ge := ghevent.NewGitHubEventWebhook(nil, integrationcfg.appHookSecret, "", dl2)
 
-		prh := func(ctx context.Context, action string, rrd models.RepoRevisionData) error {
+		prh := func(ctx context.Context, action string, rrd models.RepoRevisionData, details ghapp.PREventDetails) error {
 			switch action {
 			case "opened":
 				_, err := nmgr2.Create(ctx, rrd)
@@ -115,7 +115,7 @@ func integration(cmd *cobra.Command, args []string) {
 			}
 		}
 
-		gha, err := ghapp.NewGitHubApp([]byte(integrationcfg.PrivateKeyPEM), uint(appid), integrationcfg.appHookSecret, []string{"opened", "closed", "synchronize"}, prh, dl2)
+		gha, err := ghapp.NewGitHubApp([]byte(integrationcfg.PrivateKeyPEM), uint(appid), integrationcfg.appHookSecret, []string{"opened", "closed", "synchronize"}, prh, nil, nil, nil, dl2)
 		if err != nil {
 			return errors.Wrap(err, "error creating GitHub app")
 		}
@@ -186,9 +186,9 @@ func setupNitro(dl persistence.DataLayer, useGHToken bool) (spawner.EnvironmentS
 		return &notifier.MultiRouter{Backends: []notifier.Backend{sb}}
 	}
 	fs := osfs.New("")
-	mg := &meta.DataGetter{RC: rc, FS: fs}
+	mg := &meta.DataGetter{RC: rc, FS: fs, CF: meta.NewCachingHelmChartFetcher(nil)}
 	ib := &images.FakeImageBuilder{BatchCompletedFunc: func(envname, repo string) (bool, error) { return true, nil }}
-	ci, err := metahelm.NewChartInstaller(ib, dl, fs, mc, map[string]string{}, []string{}, map[string]config.K8sSecret{}, k8sClientConfig.JWTPath, false, helmClientConfig)
+	ci, err := metahelm.NewChartInstaller(ib, dl, fs, mc, config.K8sConfig{}, k8sClientConfig.JWTPath, false, helmClientConfig)
 	if err != nil {
 		return nil, nil, errors.Wrap(err, "error getting metahelm chart installer")
 	}
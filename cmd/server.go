@@ -15,11 +15,13 @@ import (
 	"os/signal"
 	"path/filepath"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
 	"github.com/dollarshaveclub/acyl/pkg/api"
 	"github.com/dollarshaveclub/acyl/pkg/config"
+	"github.com/dollarshaveclub/acyl/pkg/eviction"
 	"github.com/dollarshaveclub/acyl/pkg/ghclient"
 	"github.com/dollarshaveclub/acyl/pkg/ghevent"
 	"github.com/dollarshaveclub/acyl/pkg/locker"
@@ -37,6 +39,7 @@ import (
 	"github.com/dollarshaveclub/acyl/pkg/slacknotifier"
 	furan "github.com/dollarshaveclub/furan/v2/pkg/client"
 	"github.com/nlopes/slack"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/spf13/cobra"
 	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/tracer"
 	"gopkg.in/src-d/go-billy.v4/osfs"
@@ -47,7 +50,7 @@ var githubConfig config.GithubConfig
 var slackConfig config.SlackConfig
 
 var k8sConfig config.K8sConfig
-var k8sGroupBindingsStr, k8sSecretsStr, k8sPrivilegedReposStr string
+var k8sGroupBindingsStr, k8sSecretsStr, k8sPrivilegedReposStr, k8sNamespaceGuardrailsJSON, k8sRBACProfilesFile, k8sSecretScopesJSON, k8sClustersJSON, environmentQuotasJSON string
 
 var pgConfig config.PGConfig
 var logger *log.Logger
@@ -123,15 +126,39 @@ func init() {
 	serverCmd.PersistentFlags().UintVar(&serverConfig.ReaperIntervalSecs, "cleanup-interval", 600, "Approximate interval between cleanup runs in seconds (set to 0 to disable)")
 	serverCmd.PersistentFlags().UintVar(&serverConfig.EventRateLimitPerSecond, "event-rate-limit", 25, "Event rate limit in events per second (any in excess will be dropped)")
 	serverCmd.PersistentFlags().UintVar(&serverConfig.GlobalEnvironmentLimit, "global-environment-limit", 0, "Maximum number of running environments (set to zero for no limit)")
+	serverCmd.PersistentFlags().StringVar(&environmentQuotasJSON, "environment-quotas-json", "", `optional JSON-encoded maximum number of running environments per repo, org and user, with defaults for those not listed (zero is unlimited). New environments that would exceed a quota are rejected, or if "evict" is set, environments within the quota scope chosen by the eviction policy are destroyed (ex: {"repos":{"acme/busy":10},"default_org":20,"default_user":3,"evict":false})`)
+	serverCmd.PersistentFlags().StringVar(&serverConfig.EvictionPolicy, "eviction-policy", eviction.ActivityPolicyName, `Policy that chooses environments to destroy when the global limit or an evicting quota is exceeded: "activity" (failed, then suspended, then least recently active environments) or "oldest" (oldest running environments). Pinned environments are never destroyed.`)
+	serverCmd.PersistentFlags().DurationVar(&serverConfig.MaxPinDuration, "max-pin-duration", 7*24*time.Hour, "Maximum duration an environment can be pinned (exempt from eviction and idle suspension)")
+	serverCmd.PersistentFlags().StringVar(&serverConfig.PinLabel, "pin-label", models.DefaultPinLabel, "PR label that pins the environment for the maximum pin duration (removing the label unpins it)")
+	serverCmd.PersistentFlags().DurationVar(&serverConfig.EnvironmentLifetimes.Default, "default-environment-lifetime", 0, "Lifetime of environments for repos that do not define lifetime in acyl.yml, after which they are destroyed (ex: 72h, set to zero for no expiry)")
+	serverCmd.PersistentFlags().DurationVar(&serverConfig.EnvironmentLifetimes.Min, "min-environment-lifetime", 0, "Minimum environment lifetime (shorter lifetimes in acyl.yml are raised to the minimum)")
+	serverCmd.PersistentFlags().DurationVar(&serverConfig.EnvironmentLifetimes.Max, "max-environment-lifetime", 0, "Maximum environment lifetime and lifetime extension (set to zero for no maximum). If set, all environments expire.")
+	serverCmd.PersistentFlags().DurationVar(&serverConfig.EnvironmentLifetimes.Extension, "environment-lifetime-extension", 72*time.Hour, "Duration the environment lifetime is extended by the extend label or comment command")
+	serverCmd.PersistentFlags().DurationVar(&serverConfig.EnvironmentLifetimes.MaxTotal, "max-environment-total-lifetime", 0, "Maximum total environment lifetime from creation that lifetime extensions may extend to (set to zero for no maximum)")
+	serverCmd.PersistentFlags().DurationVar(&serverConfig.EnvironmentLifetimes.Warning, "environment-expiry-warning", 24*time.Hour, "Send the expiry warning notification this long before an environment expires (set to zero to disable)")
+	serverCmd.PersistentFlags().DurationVar(&serverConfig.EnvironmentLifetimes.AdHoc, "ad-hoc-environment-lifetime", 72*time.Hour, "Lifetime of ad-hoc environments (created from a branch, tag or commit via the API) that would otherwise not expire (set to zero for no expiry)")
+	serverCmd.PersistentFlags().StringVar(&serverConfig.ExtendLabel, "extend-label", models.DefaultExtendLabel, "PR label that extends the environment lifetime (the label is removed once processed)")
+	serverCmd.PersistentFlags().DurationVar(&serverConfig.SuspendIdleEnvironments, "suspend-idle-environments", 0, "Suspend (scale to zero) successful environments with no activity for longer than this duration (ex: 12h, set to zero to disable)")
+	serverCmd.PersistentFlags().StringSliceVar(&serverConfig.DefaultTriggerLabels, "default-trigger-labels", []string{models.DefaultTriggerLabel}, "PR labels that trigger environment creation for repos that do not define trigger_labels in acyl.yml (comma-separated)")
+	serverCmd.PersistentFlags().BoolVar(&serverConfig.DefaultAutoCreate, "default-auto-create", false, "Create environments for all opened PRs targeting target_branches for repos that do not define auto_create in acyl.yml")
+	serverCmd.PersistentFlags().BoolVar(&serverConfig.DefaultSkipDraftPRs, "default-auto-create-skip-drafts", false, "Delay auto-created environments for draft PRs until they are ready for review for repos that do not define auto_create in acyl.yml")
 	serverCmd.PersistentFlags().StringVar(&serverConfig.HostnameTemplate, "hostname-template", "{{ .Name }}.qa.shave.io", "Environment hostname")
 	serverCmd.PersistentFlags().BoolVar(&serverConfig.DebugEndpoints, "debug-endpoints", false, "Enable debugging HTTP endpoints (pprof)")
 	serverCmd.PersistentFlags().StringArrayVar(&serverConfig.DebugEndpointsIPWhitelists, "debug-endpoints-ip-whitelists", []string{"10.10.0.0/16", "127.0.0.1/32"}, "IP CIDR ranges to allow access to debug endpoints")
 	serverCmd.PersistentFlags().StringVar(&serverConfig.NotificationsDefaultsJSON, "nitro-notifications-defaults-json", "{}", "JSON-encoded notifications defaults for Nitro")
+	serverCmd.PersistentFlags().BoolVar(&serverConfig.WebhookNotifications, "nitro-webhook-notifications", false, "Enable signed webhook notifications to URLs in acyl.yml and notifications defaults (requires the notifications/webhook_secret secret) (Nitro)")
+	serverCmd.PersistentFlags().StringSliceVar(&serverConfig.ChartRepoAllowedHosts, "nitro-chart-repo-allowed-hosts", []string{}, "Hostnames or wildcard domains (ex: *.example.com) of the Helm chart repositories and OCI registries that acyl.yml chart sources may use, including chart URLs in repository indices (comma-separated). If empty, any host is allowed except for fork PR environments, which may not use chart repositories or OCI registries. (Nitro)")
+	serverCmd.PersistentFlags().StringSliceVar(&serverConfig.WebhookAllowedHosts, "nitro-webhook-allowed-hosts", []string{}, "Hostnames or wildcard domains (ex: *.example.com) that webhook notification URLs may use (comma-separated). Webhooks to other hosts are refused. (Nitro)")
 	serverCmd.PersistentFlags().StringVar(&k8sGroupBindingsStr, "k8s-group-bindings", "", "optional k8s RBAC group bindings (comma-separated) for new environment namespaces in GROUP1=CLUSTER_ROLE1,GROUP2=CLUSTER_ROLE2 format (ex: users=edit) (Nitro)")
 	serverCmd.PersistentFlags().StringVar(&k8sSecretsStr, "k8s-secret-injections", "", "optional k8s secret injections (comma-separated) for new environment namespaces in SECRET_NAME=VAULT_ID (Vault path using secrets mapping) format. Secret value in Vault must be a JSON-encoded object with two keys: 'data' (map of string to base64-encoded bytes), 'type' (string). (Nitro)")
+	serverCmd.PersistentFlags().StringVar(&k8sSecretScopesJSON, "k8s-secret-injection-scopes-json", "", `optional JSON-encoded map of secret injection name to scope, restricting the secret to repos ("owner/name", "owner/*" or "*") that declare it in acyl.yml (unless "always" is set), with optional data key renames (ex: {"aws-creds":{"repos":["acme/*"],"always":false,"key_renames":{"key":"AWS_ACCESS_KEY_ID"}}}). Secrets without a scope are injected into every environment namespace. (Nitro)`)
+	serverCmd.PersistentFlags().StringVar(&k8sNamespaceGuardrailsJSON, "k8s-namespace-guardrails-json", "", `optional JSON-encoded ResourceQuota, LimitRange and NetworkPolicy defaults for new environment namespaces and ceilings for acyl.yml overrides (ex: {"defaults":{"resource_quota":{"hard":{"requests.cpu":"4"}}},"ceilings":{"resource_quota":{"requests.cpu":"8"},"allowed_egress_cidrs":["10.0.0.0/8"]}}); acyl.yml network policy exceptions outside of the ceilings allowed_ingress_namespaces and allowed_egress_cidrs are rejected (Nitro)`)
+	serverCmd.PersistentFlags().StringVar(&k8sRBACProfilesFile, "k8s-rbac-profiles-file", "", "optional path to a YAML or JSON file defining named RBAC profiles for environment service accounts and the repos allowed to use them (if empty, service accounts are granted all permissions within the environment namespace) (Nitro)")
+	serverCmd.PersistentFlags().StringVar(&k8sClustersJSON, "k8s-clusters-json", "", `optional JSON-encoded list of clusters that environments are placed in, with optional kubeconfig path and context (if both are empty, the default cluster is used), capacity (maximum environments, zero is unlimited), labels and repo affinity patterns (ex: [{"name":"qa-east","kubeconfig_path":"/etc/acyl/kubeconfig","kube_context":"qa-east","capacity":50,"labels":{"region":"us-east-1"},"repos":["acme/*"]}]). If empty, all environments are created in the default cluster. (Nitro)`)
 	serverCmd.PersistentFlags().StringVar(&k8sPrivilegedReposStr, "k8s-privileged-repo-whitelist", "dollarshaveclub/acyl", "optional comma-separated whitelist of GitHub repositories whose environment service accounts will be allowed cluster-admin privileges (Nitro)")
 	serverCmd.PersistentFlags().StringVarP(&dogstatsdAddr, "dogstatsd-addr", "q", "127.0.0.1:8125", "Address of dogstatsd for metrics (set to empty string to disable)")
 	serverCmd.PersistentFlags().StringVar(&dogstatsdTags, "dogstatsd-tags", "", "Comma-separated list of tags to add to dogstatsd metrics (TAG:VALUE)")
+	serverCmd.PersistentFlags().BoolVar(&serverConfig.PrometheusMetrics, "prometheus-metrics", false, "Expose Prometheus metrics at /metrics, in addition to pushing them to dogstatsd if enabled (dogstatsd tags are added as labels)")
 	serverCmd.PersistentFlags().StringVar(&datadogTracingAgentAddr, "datadog-tracing-agent-addr", "127.0.0.1:8126", "Address of datadog tracing agent (set to empty string to disable)")
 	serverCmd.PersistentFlags().StringVar(&datadogServiceName, "datadog-service-name", "acyl", "Default service name to be used for Datadog APM")
 	serverCmd.PersistentFlags().DurationVar(&serverConfig.OperationTimeoutOverride, "operation-timeout-override", 0, "Override for operation timeout (ex: 10m)")
@@ -141,6 +168,20 @@ func init() {
 	RootCmd.AddCommand(serverCmd)
 }
 
+// webhookURLs returns the unique webhook URLs from a notifications config
+func webhookURLs(whs []models.WebhookNotification) []string {
+	seen := make(map[string]struct{}, len(whs))
+	var out []string
+	for _, wh := range whs {
+		if _, ok := seen[wh.URL]; ok || wh.URL == "" {
+			continue
+		}
+		seen[wh.URL] = struct{}{}
+		out = append(out, wh.URL)
+	}
+	return out
+}
+
 func setupServerLogger() {
 	logger = log.New(os.Stderr, "", log.LstdFlags)
 }
@@ -165,14 +206,30 @@ func startDatadogTracer() {
 func server(cmd *cobra.Command, args []string) {
 	var err error
 
-	var mc metrics.Collector
-	if dogstatsdAddr == "" {
-		mc = &metrics.FakeCollector{}
-	} else {
-		mc, err = metrics.NewDatadogCollector(dogstatsdAddr, logger)
+	// metrics are sent to dogstatsd and/or exposed to Prometheus
+	mcs := []metrics.Collector{}
+	if dogstatsdAddr != "" {
+		ddc, err := metrics.NewDatadogCollector(dogstatsdAddr, logger)
 		if err != nil {
 			log.Fatalf("instantiating datadog: %v", err)
 		}
+		mcs = append(mcs, ddc)
+	}
+	if serverConfig.PrometheusMetrics {
+		pc, err := metrics.NewPrometheusCollector(prometheus.DefaultRegisterer)
+		if err != nil {
+			log.Fatalf("instantiating prometheus collector: %v", err)
+		}
+		mcs = append(mcs, pc)
+	}
+	var mc metrics.Collector
+	switch len(mcs) {
+	case 0:
+		mc = &metrics.FakeCollector{}
+	case 1:
+		mc = mcs[0]
+	default:
+		mc = &metrics.MultiCollector{Collectors: mcs}
 	}
 
 	if datadogTracingAgentAddr != "" {
@@ -187,6 +244,23 @@ func server(cmd *cobra.Command, args []string) {
 	defer dl.Close()
 
 	rc := ghclient.NewGitHubClient(githubConfig.Token)
+	// PR comments are written as the token user, and only comments by that user are edited.
+	// The user is resolved when first needed and retried until it succeeds, so a GitHub error at startup doesn't break PR comments.
+	var ghuserMtx sync.Mutex
+	var ghuser string
+	getGHUser := func() (string, error) {
+		ghuserMtx.Lock()
+		defer ghuserMtx.Unlock()
+		if ghuser != "" {
+			return ghuser, nil
+		}
+		u, err := rc.GetAuthenticatedUser(context.Background())
+		if err != nil {
+			return "", err
+		}
+		ghuser = u
+		return u, nil
+	}
 	ng, err := namegen.NewWordnetNameGenerator(serverConfig.WordnetPath, logger)
 	if err != nil {
 		log.Fatalf("error opening wordnet file: %v", err)
@@ -205,14 +279,25 @@ func server(cmd *cobra.Command, args []string) {
 	slackapi := slack.New(slackConfig.Token)
 	mapper := slacknotifier.NewRepoBackedSlackUsernameMapper(rc, slackConfig.MapperRepo, slackConfig.MapperMapPath, slackConfig.MapperRepoRef, time.Duration(slackConfig.MapperUpdateIntervalSeconds)*time.Second)
 
-	var nmc nitrometrics.Collector
-	if dogstatsdAddr == "" {
-		nmc = &nitrometrics.FakeCollector{}
-	} else {
-		nmc, err = nitrometrics.NewDatadogCollector("acyl.nitro.", dogstatsdAddr, strings.Split(dogstatsdTags, ","))
+	nmcs := []nitrometrics.Collector{}
+	if dogstatsdAddr != "" {
+		ddc, err := nitrometrics.NewDatadogCollector("acyl.nitro.", dogstatsdAddr, strings.Split(dogstatsdTags, ","))
 		if err != nil {
 			log.Fatalf("error setting up nitro metrics collector: %v", err)
 		}
+		nmcs = append(nmcs, ddc)
+	}
+	if serverConfig.PrometheusMetrics {
+		nmcs = append(nmcs, nitrometrics.NewPrometheusCollector("acyl.nitro.", prometheus.DefaultRegisterer, strings.Split(dogstatsdTags, ","), logger))
+	}
+	var nmc nitrometrics.Collector
+	switch len(nmcs) {
+	case 0:
+		nmc = &nitrometrics.FakeCollector{}
+	case 1:
+		nmc = nmcs[0]
+	default:
+		nmc = &nitrometrics.MultiCollector{Collectors: nmcs}
 	}
 
 	// Furan 2
@@ -243,6 +328,15 @@ func server(cmd *cobra.Command, args []string) {
 	if err := k8sConfig.ProcessGroupBindings(k8sGroupBindingsStr); err != nil {
 		log.Fatalf("error in k8s group bindings: %v", err)
 	}
+	if err := k8sConfig.ProcessNamespaceGuardrails(k8sNamespaceGuardrailsJSON); err != nil {
+		log.Fatalf("error in k8s namespace guardrails: %v", err)
+	}
+	if err := k8sConfig.LoadRBACProfiles(k8sRBACProfilesFile); err != nil {
+		log.Fatalf("error in k8s rbac profiles: %v", err)
+	}
+	if err := k8sConfig.ProcessClusters(k8sClustersJSON); err != nil {
+		log.Fatalf("error in k8s clusters: %v", err)
+	}
 	sc, err := getSecretClient()
 	if err != nil {
 		log.Fatalf("error getting secrets client: %v", err)
@@ -250,16 +344,29 @@ func server(cmd *cobra.Command, args []string) {
 	if err := k8sConfig.ProcessSecretInjections(sc, k8sSecretsStr); err != nil {
 		log.Fatalf("error in k8s secret injections: %v", err)
 	}
-	ci, err := metahelm.NewChartInstaller(ib, dl, fs, nmc, k8sConfig.GroupBindings, k8sConfig.PrivilegedRepoWhitelist, k8sConfig.SecretInjections, k8sClientConfig.JWTPath, true, helmClientConfig)
+	if err := k8sConfig.ProcessSecretInjectionScopes(k8sSecretScopesJSON); err != nil {
+		log.Fatalf("error in k8s secret injection scopes: %v", err)
+	}
+	ci, err := metahelm.NewChartInstaller(ib, dl, fs, nmc, k8sConfig, k8sClientConfig.JWTPath, true, helmClientConfig)
 	if err != nil {
 		log.Fatalf("error getting metahelm chart installer: %v", err)
 	}
-	mg := &meta.DataGetter{RC: rc, FS: fs}
+	mg := &meta.DataGetter{RC: rc, FS: fs, CF: meta.NewCachingHelmChartFetcher(serverConfig.ChartRepoAllowedHosts)}
 	ncfg := models.Notifications{}
 	if err := json.Unmarshal([]byte(serverConfig.NotificationsDefaultsJSON), &ncfg); err != nil {
 		log.Printf("error unmarshaling notifications defaults: %v", err)
 	}
 	ncfg.FillMissingTemplates()
+	if err := serverConfig.ProcessEnvironmentQuotas(environmentQuotasJSON); err != nil {
+		log.Fatalf("error in environment quotas: %v", err)
+	}
+	if err := serverConfig.EnvironmentLifetimes.Validate(); err != nil {
+		log.Fatalf("error in environment lifetimes: %v", err)
+	}
+	evictionPolicy, err := eviction.NewPolicy(serverConfig.EvictionPolicy)
+	if err != nil {
+		log.Fatalf("error in eviction policy: %v", err)
+	}
 	ncfg.Slack.Channels = &[]string{slackConfig.Channel}
 	nitromgr := &nitroenv.Manager{
 		NF: func(lf func(string, ...interface{}), notifications models.Notifications, user string) notifier.Router {
@@ -282,11 +389,36 @@ func server(cmd *cobra.Command, args []string) {
 					sb.Users = append(sb.Users, sluser)
 				}
 			}
-			return &notifier.MultiRouter{Backends: []notifier.Backend{sb}}
+			backends := []notifier.Backend{sb}
+			if notifications.GitHub.PRComments {
+				if u, err := getGHUser(); err != nil {
+					lf("error getting GitHub token user, skipping PR comment: %v", err)
+				} else {
+					backends = append(backends, &notifier.GitHubBackend{
+						API:       rc,
+						User:      u,
+						Templates: notifications.GitHub.PRCommentTemplates,
+					})
+				}
+			}
+			if serverConfig.WebhookNotifications {
+				urls, trusted := webhookURLs(notifications.Webhooks), webhookURLs(ncfg.Webhooks)
+				if len(urls) > 0 || len(trusted) > 0 {
+					backends = append(backends, &notifier.WebhookBackend{
+						URLs:         urls,
+						TrustedURLs:  trusted,
+						Secret:       serverConfig.WebhookNotificationsSecret,
+						AllowedHosts: serverConfig.WebhookAllowedHosts,
+						LogFunc:      lf,
+					})
+				}
+			}
+			return &notifier.MultiRouter{Backends: backends}
 		},
 		DefaultNotifications: ncfg,
 		DL:                   dl,
 		RC:                   rc,
+		CR:                   rc,
 		MC:                   nmc,
 		NG:                   ng,
 		FS:                   fs,
@@ -294,6 +426,9 @@ func server(cmd *cobra.Command, args []string) {
 		CI:                   ci,
 		PLF:                  plf,
 		GlobalLimit:          serverConfig.GlobalEnvironmentLimit,
+		Quotas:               serverConfig.EnvironmentQuotas,
+		EvictionPolicy:       evictionPolicy,
+		Lifetimes:            serverConfig.EnvironmentLifetimes,
 		UIBaseURL:            serverConfig.UIBaseURL,
 	}
 	nitromgr.OperationTimeout = serverConfig.OperationTimeoutOverride // Zero means use default defined in pkg/nitro/env
@@ -301,7 +436,7 @@ func server(cmd *cobra.Command, args []string) {
 
 	if serverConfig.ReaperIntervalSecs > 0 {
 		log.Printf("starting reaper: %v sec interval", serverConfig.ReaperIntervalSecs)
-		reaper := reap.NewReaper(lp, dl, nitromgr, rc, mc, serverConfig.GlobalEnvironmentLimit, logger, reaperLockKey)
+		reaper := reap.NewReaper(lp, dl, nitromgr, rc, mc, serverConfig.GlobalEnvironmentLimit, serverConfig.EnvironmentQuotas, evictionPolicy, serverConfig.SuspendIdleEnvironments, serverConfig.EnvironmentLifetimes.Warning, logger, reaperLockKey)
 		ticker := time.NewTicker(time.Duration(serverConfig.ReaperIntervalSecs) * time.Second)
 		go func() {
 			var delta int64
@@ -36,6 +36,8 @@ require (
 	github.com/nlopes/slack v0.1.0
 	github.com/palantir/go-githubapp v0.9.2-0.20210830144646-08ca97a77f90
 	github.com/pkg/errors v0.9.1
+	github.com/prometheus/client_golang v1.14.0
+	github.com/prometheus/client_model v0.3.0
 	github.com/rivo/tview v0.0.0-20190113120821-e5e361b9d790
 	github.com/rs/zerolog v1.18.0
 	github.com/shurcooL/githubv4 v0.0.0-20191127044304-8f68eb5628d0
@@ -170,8 +172,6 @@ require (
 	github.com/peterbourgon/diskv v2.0.1+incompatible // indirect
 	github.com/philhofer/fwd v1.0.0 // indirect
 	github.com/pierrec/lz4 v2.6.0+incompatible // indirect
-	github.com/prometheus/client_golang v1.14.0 // indirect
-	github.com/prometheus/client_model v0.3.0 // indirect
 	github.com/prometheus/common v0.37.0 // indirect
 	github.com/prometheus/procfs v0.8.0 // indirect
 	github.com/rcrowley/go-metrics v0.0.0-20200313005456-10cdbea86bc0 // indirect
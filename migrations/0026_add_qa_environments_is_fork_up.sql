@@ -0,0 +1,2 @@
+-- environments for PRs from forks are built without privileges or secret injections
+ALTER TABLE qa_environments ADD COLUMN is_fork boolean NOT NULL DEFAULT false;
@@ -0,0 +1,2 @@
+DROP TRIGGER notify_event_log_update ON event_logs;
+DROP FUNCTION trigger_notify_event_log_update();
@@ -0,0 +1,14 @@
+-- Trigger to notify listeners (streaming API endpoints) of event log changes
+
+CREATE OR REPLACE FUNCTION trigger_notify_event_log_update()
+RETURNS TRIGGER AS $$
+BEGIN
+  PERFORM pg_notify('event_log_updates', NEW.id::text);
+  RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER notify_event_log_update
+AFTER INSERT OR UPDATE ON event_logs
+FOR EACH ROW
+EXECUTE PROCEDURE trigger_notify_event_log_update();
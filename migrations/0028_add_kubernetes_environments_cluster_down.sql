@@ -0,0 +1 @@
+ALTER TABLE kubernetes_environments DROP COLUMN IF EXISTS cluster;
@@ -0,0 +1,2 @@
+-- name of the cluster the environment is placed in (empty for the default cluster)
+ALTER TABLE kubernetes_environments ADD COLUMN cluster text NOT NULL DEFAULT '';
@@ -0,0 +1,2 @@
+ALTER TABLE qa_environments DROP COLUMN IF EXISTS pinned_until;
+ALTER TABLE qa_environments DROP COLUMN IF EXISTS last_accessed;
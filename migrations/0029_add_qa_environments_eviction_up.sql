@@ -0,0 +1,4 @@
+-- environments pinned until a time in the future are exempt from eviction
+ALTER TABLE qa_environments ADD COLUMN pinned_until timestamptz;
+-- most recent access of the environment through the UI or API
+ALTER TABLE qa_environments ADD COLUMN last_accessed timestamptz;
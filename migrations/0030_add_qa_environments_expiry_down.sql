@@ -0,0 +1,2 @@
+ALTER TABLE qa_environments DROP COLUMN IF EXISTS expires;
+ALTER TABLE qa_environments DROP COLUMN IF EXISTS expiry_warned;
@@ -0,0 +1,4 @@
+-- environments are destroyed by the reaper after this time (null environments never expire)
+ALTER TABLE qa_environments ADD COLUMN expires timestamptz;
+-- whether the expiry warning notification has been sent for the current expiry
+ALTER TABLE qa_environments ADD COLUMN expiry_warned boolean NOT NULL DEFAULT false;
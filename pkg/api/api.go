@@ -1,22 +1,32 @@
 package api
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"net"
 	"net/http"
 	"os"
 	"sync"
+	"time"
 
 	"github.com/dollarshaveclub/acyl/pkg/config"
+	"github.com/dollarshaveclub/acyl/pkg/eventlogger"
+	"github.com/dollarshaveclub/acyl/pkg/ghapp"
 	"github.com/dollarshaveclub/acyl/pkg/ghclient"
 	"github.com/dollarshaveclub/acyl/pkg/ghevent"
 	"github.com/dollarshaveclub/acyl/pkg/models"
+	ncontext "github.com/dollarshaveclub/acyl/pkg/nitro/context"
+	nitroerrors "github.com/dollarshaveclub/acyl/pkg/nitro/errors"
 	"github.com/dollarshaveclub/acyl/pkg/nitro/metahelm"
 	"github.com/dollarshaveclub/acyl/pkg/persistence"
 	"github.com/dollarshaveclub/acyl/pkg/spawner"
+	"github.com/google/uuid"
 	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	muxtrace "gopkg.in/DataDog/dd-trace-go.v1/contrib/gorilla/mux"
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/ext"
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/tracer"
 )
 
 const (
@@ -55,6 +65,130 @@ func (api *apiBase) forbiddenError(w http.ResponseWriter, msg string) {
 	api.httpError(w, fmt.Errorf("forbidden: %v", msg), http.StatusForbidden)
 }
 
+// startRebuild starts an asynchronous update of qae, returning the eventlog ID of the operation.
+// If full is true, the config signature is cleared first so the environment is rebuilt from scratch.
+// Any GitHub app clients present in parent are used for the operation.
+func (api *apiBase) startRebuild(parent context.Context, dl persistence.DataLayer, es spawner.EnvironmentSpawner, qae *models.QAEnvironment, full bool) (uuid.UUID, error) {
+	// check for full rebuild
+	messaging := "synchronize"
+	if full {
+		messaging = "rebuild"
+		if err := dl.UpdateK8sEnvConfigSignature(parent, qae.Name, [32]byte{}); err != nil {
+			return uuid.Nil, errors.Wrap(err, "error updating config signature")
+		}
+	}
+	rrd := qae.RepoRevisionDataFromQA()
+	return api.startOperation(parent, dl, *rrd, qae.Name, messaging+" update", func(ctx context.Context) (string, error) {
+		return es.Update(ctx, *rrd)
+	})
+}
+
+// startOperation runs f asynchronously for rrd with a new event logger, returning the eventlog ID of the operation.
+// envName may be empty if the environment doesn't exist yet. f returns the name of the environment operated upon.
+// Any GitHub app clients present in parent are used for the operation.
+func (api *apiBase) startOperation(parent context.Context, dl persistence.DataLayer, rrd models.RepoRevisionData, envName, operation string, f func(ctx context.Context) (string, error)) (uuid.UUID, error) {
+	// setup logger
+	id, err := uuid.NewRandom()
+	if err != nil {
+		return uuid.Nil, errors.Wrap(err, "error getting random UUID")
+	}
+	elogger := &eventlogger.Logger{
+		ID:         id,
+		DeliveryID: uuid.Nil,
+		DL:         dl,
+		Sink:       os.Stdout,
+	}
+	if err := elogger.Init([]byte{}, rrd.Repo, rrd.PullRequest); err != nil {
+		return uuid.Nil, errors.Wrap(err, "error initializing event logger")
+	}
+	if envName != "" {
+		if err := elogger.SetEnvName(envName); err != nil {
+			return uuid.Nil, errors.Wrap(err, "error setting event logger name")
+		}
+	}
+
+	// setup context
+	ctx := ghapp.CloneGitHubClientContext(context.Background(), parent)
+	ctx = eventlogger.NewEventLoggerContext(ctx, elogger)
+	ctx = ncontext.NewCancelFuncContext(context.WithCancel(ctx))
+	span := tracer.StartSpan("api_action")
+	span.SetTag(ext.SamplingPriority, ext.PriorityUserKeep)
+	setTagsForGithubWebhookHandler(span, rrd)
+	ctx = tracer.ContextWithSpan(ctx, span)
+	logger := eventlogger.GetLogger(ctx).Printf
+
+	logger("starting async processing for %v", operation)
+	api.wg.Add(1)
+	go func() {
+		var err error
+		defer func() { span.Finish(tracer.WithError(err)) }()
+		defer api.wg.Done()
+		ctx, cf := context.WithTimeout(ctx, MaxAsyncActionTimeout)
+		defer cf() // guarantee that any goroutines created with the ctx are cancelled
+		name, err := f(ctx)
+		if err != nil {
+			logger("finished processing %v with error: %v", operation, err)
+			return
+		}
+		logger("success processing %v (env: %q); done", operation, name)
+	}()
+	return id, nil
+}
+
+// pinEnv pins qae for d, or unpins it if d is zero, recording user in the environment events.
+// It returns the time the environment is pinned until, or nil if it was unpinned.
+func (api *apiBase) pinEnv(ctx context.Context, dl persistence.DataLayer, qae *models.QAEnvironment, d time.Duration, user string) (*time.Time, error) {
+	var until *time.Time
+	msg := "unpinned by " + user
+	if d > 0 {
+		t := time.Now().UTC().Add(d)
+		until = &t
+		msg = fmt.Sprintf("pinned until %v by %v (exempt from eviction)", t.Format(time.RFC3339), user)
+	}
+	if err := dl.SetQAEnvironmentPinnedUntil(ctx, qae.Name, until); err != nil {
+		return nil, errors.Wrap(err, "error setting pinned until")
+	}
+	if err := dl.AddEvent(ctx, qae.Name, msg); err != nil {
+		api.logger.Printf("error adding pin event: %v", err)
+	}
+	return until, nil
+}
+
+// extendEnv extends the lifetime of qae so that it expires no earlier than d from now, returning the new expiry.
+// The expiry is never moved earlier, and environments that don't expire can't be extended.
+// If maxTotal is greater than zero, the expiry is limited to maxTotal after the environment was created.
+func (api *apiBase) extendEnv(ctx context.Context, dl persistence.DataLayer, qae *models.QAEnvironment, d, maxTotal time.Duration, user string) (*time.Time, error) {
+	if qae.Expires == nil {
+		return nil, nitroerrors.User(errors.New("environment does not expire"))
+	}
+	expires := time.Now().UTC().Add(d)
+	if maxTotal > 0 {
+		if limit := qae.Created.Add(maxTotal).UTC(); expires.After(limit) {
+			if !qae.Expires.Before(limit) {
+				return nil, nitroerrors.User(fmt.Errorf("environment has reached the maximum total lifetime (%v)", maxTotal))
+			}
+			expires = limit
+		}
+	}
+	if qae.Expires.After(expires) {
+		return qae.Expires, nil
+	}
+	if err := dl.SetQAEnvironmentExpires(ctx, qae.Name, &expires); err != nil {
+		return nil, errors.Wrap(err, "error setting expires")
+	}
+	if err := dl.AddEvent(ctx, qae.Name, fmt.Sprintf("lifetime extended until %v by %v", expires.Format(time.RFC3339), user)); err != nil {
+		api.logger.Printf("error adding extend event: %v", err)
+	}
+	return &expires, nil
+}
+
+// recordAccess records UI/API access of an environment, which counts as activity for eviction and idle suspension
+func (api *apiBase) recordAccess(ctx context.Context, dl persistence.DataLayer, name string) {
+	if err := dl.SetQAEnvironmentLastAccessed(ctx, name, time.Now().UTC()); err != nil {
+		api.logger.Printf("error recording environment access: %v: %v", name, err)
+	}
+}
+
 type routeLogger struct {
 	route  string
 	logger *log.Logger
@@ -255,6 +389,9 @@ func (d *Dispatcher) RegisterVersions(deps *Dependencies, ro ...RegisterOption)
 
 	r := muxtrace.NewRouter(muxtrace.WithServiceName(deps.DatadogServiceName))
 	r.HandleFunc("/health", d.healthHandler).Methods("GET")
+	if deps.ServerConfig.PrometheusMetrics {
+		r.Handle("/metrics", promhttp.Handler()).Methods("GET")
+	}
 
 	apiv0, err := newV0API(deps.DataLayer, deps.GitHubEventWebhook, deps.EnvironmentSpawner, deps.RepoClient, ropts.ghConfig, deps.ServerConfig, deps.Logger)
 	if err != nil {
@@ -281,6 +418,7 @@ func (d *Dispatcher) RegisterVersions(deps *Dependencies, ro ...RegisterOption)
 		deps.GitHubEventWebhook,
 		deps.EnvironmentSpawner,
 		deps.Furan2Client,
+		deps.RepoClient,
 		deps.ServerConfig,
 		oauthcfg,
 		deps.Logger,
@@ -786,6 +786,7 @@ type failureReportTmplData struct {
 	EnvName, EventID, PullRequestURL string
 	StartedTime, FailedTime          time.Time
 	FailedResources                  mh.ChartError
+	FailedHealthChecks               []models.FailedHealthCheck
 }
 
 func (api *uiapi) failureReportHandler(w http.ResponseWriter, r *http.Request) {
@@ -817,13 +818,14 @@ func (api *uiapi) failureReportHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	td := failureReportTmplData{
-		BaseTemplateData: api.defaultBaseTemplateData(&uis),
-		EnvName:          elog.EnvName,
-		EventID:          id,
-		PullRequestURL:   fmt.Sprintf("https://github.com/%v/pull/%v", elog.Repo, elog.PullRequest),
-		StartedTime:      elog.Status.Config.Started,
-		FailedTime:       elog.Status.Config.Completed,
-		FailedResources:  elog.Status.Config.FailedResources,
+		BaseTemplateData:   api.defaultBaseTemplateData(&uis),
+		EnvName:            elog.EnvName,
+		EventID:            id,
+		PullRequestURL:     fmt.Sprintf("https://github.com/%v/pull/%v", elog.Repo, elog.PullRequest),
+		StartedTime:        elog.Status.Config.Started,
+		FailedTime:         elog.Status.Config.Completed,
+		FailedResources:    elog.Status.Config.FailedResources,
+		FailedHealthChecks: elog.Status.FailedHealthChecks(),
 	}
 	api.render(w, "failure_report", &td)
 }
@@ -30,6 +30,7 @@ import (
 	muxtrace "gopkg.in/DataDog/dd-trace-go.v1/contrib/gorilla/mux"
 	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/ext"
 	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/tracer"
+	yaml "gopkg.in/yaml.v2"
 )
 
 // API output schema
@@ -87,6 +88,7 @@ type v0api struct {
 	sc  config.ServerConfig
 	gha *ghapp.GitHubApp
 	rc  ghclient.RepoClient
+	lc  ghclient.PRLabelClient
 }
 
 func newV0API(dl persistence.DataLayer, ge *ghevent.GitHubEventWebhook, es spawner.EnvironmentSpawner, rc ghclient.RepoClient, ghc config.GithubConfig, sc config.ServerConfig, logger *stdlog.Logger) (*v0api, error) {
@@ -100,7 +102,11 @@ func newV0API(dl persistence.DataLayer, ge *ghevent.GitHubEventWebhook, es spawn
 		sc: sc,
 		rc: rc,
 	}
-	gha, err := ghapp.NewGitHubApp(ghc.PrivateKeyPEM, ghc.AppID, ghc.AppHookSecret, []string{"labeled", "closed", "synchronize", "unlabeled"}, api.processWebhook, dl)
+	// the label client is optional, fork PR approval labels can't be added or revoked automatically without it
+	if lc, ok := rc.(ghclient.PRLabelClient); ok {
+		api.lc = lc
+	}
+	gha, err := ghapp.NewGitHubApp(ghc.PrivateKeyPEM, ghc.AppID, ghc.AppHookSecret, []string{"opened", "reopened", "ready_for_review", "labeled", "closed", "synchronize", "unlabeled"}, api.processPR, api.processPush, api.processCheckRunRerequest, api.processCommentCommand, dl)
 	if err != nil {
 		return nil, errors.Wrap(err, "error creating GitHub app")
 	}
@@ -160,7 +166,11 @@ func setTagsForGithubWebhookHandler(span tracer.Span, rd models.RepoRevisionData
 	span.SetTag("user", rd.User)
 }
 
-func (api *v0api) processWebhook(ctx context.Context, action string, rrd models.RepoRevisionData) error {
+// processWebhook processes an environment event for rrd.
+// If details is non-nil, action is a PR webhook action and is first evaluated against the repo trigger labels and auto-create policy.
+// Events for PRs from forks are evaluated against the fork PR policy of the base branch instead.
+// It returns the reason the event was ignored, or the empty string if the event was accepted for processing.
+func (api *v0api) processWebhook(ctx context.Context, action string, rrd models.RepoRevisionData, details *ghapp.PREventDetails) (string, error) {
 	// As of 03/13/2019, Datadog seems to only allow monitors to be setup based
 	// off the root level span of a trace.
 	// While we could connect this with span found in r.Context(), this would
@@ -189,20 +199,20 @@ func (api *v0api) processWebhook(ctx context.Context, action string, rrd models.
 	// (eg, repos that are dependencies of other repos that do contain acyl.yml)
 	// therefore if acyl.yml is not found, ignore the event and return nil error so we don't set an error commit status on that repo
 	log("checking for triggering repo acyl.yml in %v@%v", rrd.Repo, rrd.SourceSHA)
-	if _, err := api.rc.GetFileContents(ctx, rrd.Repo, "acyl.yml", rrd.SourceSHA); err != nil {
+	acylyml, err := api.rc.GetFileContents(ctx, rrd.Repo, "acyl.yml", rrd.SourceSHA)
+	if err != nil {
 		if strings.Contains(err.Error(), "404 Not Found") { // this is also returned if permissions are incorrect
 			log("acyl.yml is missing for repo, ignoring event")
-			return nil
+			return "acyl.yml is missing for the repo", nil
 		}
 		log("error checking existence of acyl.yml: %v", err)
-		return errors.Wrap(err, "error checking existence of acyl.yml")
+		return "", errors.Wrap(err, "error checking existence of acyl.yml")
 	}
 	log("acyl.yml found, continuing to process event")
 
 	setTagsForGithubWebhookHandler(span, rrd)
 	ctx = tracer.ContextWithSpan(ctx, span)
 
-	var err error
 	finishWithError := func() {
 		if nitroerrors.IsCancelledError(err) {
 			err = nil
@@ -210,6 +220,68 @@ func (api *v0api) processWebhook(ctx context.Context, action string, rrd models.
 		span.Finish(tracer.WithError(err))
 	}
 
+	if details != nil && (action == "labeled" || action == "unlabeled") && api.sc.PinLabel != "" && details.Label == api.sc.PinLabel {
+		api.processPinLabel(ctx, action == "labeled", rrd, *details)
+		finishWithError()
+		return "", nil
+	}
+
+	if details != nil && (action == "labeled" || action == "unlabeled") && api.sc.ExtendLabel != "" && details.Label == api.sc.ExtendLabel {
+		// the label is removed once processed, so removals are ignored
+		if action == "labeled" {
+			api.processExtendLabel(ctx, rrd, *details)
+		}
+		finishWithError()
+		return "", nil
+	}
+
+	if rrd.IsFork {
+		fp, err := api.forkPRPolicy(ctx, rrd)
+		if err != nil {
+			log("error getting fork PR policy: %v", err)
+			finishWithError()
+			return "", errors.Wrap(err, "error getting fork PR policy")
+		}
+		if details != nil {
+			if action == "synchronize" {
+				api.revokeForkApproval(ctx, rrd, fp, *details)
+			}
+			ta := forkTriggerAction(action, fp, *details)
+			if ta == "" {
+				log("%v event for fork PR not relevant to approval label %v (sender: %v, write access: %v), ignoring", action, fp.Label(), details.Sender, details.SenderCanWrite)
+				finishWithError()
+				return fmt.Sprintf("event is not relevant to the approval label %v", fp.Label()), nil
+			}
+			log("%v event for fork PR processed as %v", action, ta)
+			action = ta
+		}
+		// environments for fork PRs can always be destroyed
+		if !fp.Enabled && action != "closed" {
+			log("environments for fork PRs are not enabled in the base branch acyl.yml, ignoring event")
+			finishWithError()
+			return "environments for PRs from forks are not enabled for this repo", nil
+		}
+	} else if details != nil {
+		rc := models.RepoConfig{}
+		if err := yaml.Unmarshal(acylyml, &rc); err != nil {
+			log("error unmarshaling acyl.yml, ignoring event: %v", err)
+			finishWithError()
+			return fmt.Sprintf("error parsing acyl.yml: %v", err), nil
+		}
+		rc.SetTriggerDefaults(api.sc.DefaultTriggerLabels, models.AutoCreatePolicy{
+			Enabled:    api.sc.DefaultAutoCreate,
+			SkipDrafts: api.sc.DefaultSkipDraftPRs,
+		})
+		ta := prTriggerAction(action, rc, rrd, *details)
+		if ta == "" {
+			log("%v event not relevant to trigger labels (%v) or auto-create policy (%+v), ignoring", action, rc.TriggerLabels, *rc.AutoCreate)
+			finishWithError()
+			return "event is not relevant to the trigger labels or auto-create policy", nil
+		}
+		log("%v event processed as %v", action, ta)
+		action = ta
+	}
+
 	switch action {
 	case "labeled":
 		log("starting async processing for %v", action)
@@ -241,6 +313,33 @@ func (api *v0api) processWebhook(ctx context.Context, action string, rrd models.
 			}
 			log("success processing update event (env: %q); done", name)
 		}()
+	case "push":
+		rc := models.RepoConfig{}
+		if err := yaml.Unmarshal(acylyml, &rc); err != nil {
+			log("error unmarshaling acyl.yml, ignoring push: %v", err)
+			finishWithError()
+			return fmt.Sprintf("error parsing acyl.yml: %v", err), nil
+		}
+		if !rc.TracksBranch(rrd.SourceBranch) {
+			log("branch is not tracked, ignoring push: %v", rrd.SourceBranch)
+			finishWithError()
+			return fmt.Sprintf("branch is not tracked: %v", rrd.SourceBranch), nil
+		}
+		log("starting async processing for %v to tracked branch %v", action, rrd.SourceBranch)
+		api.wg.Add(1)
+		go func() {
+			defer finishWithError()
+			defer api.wg.Done()
+			ctx, cf := context.WithTimeout(ctx, MaxAsyncActionTimeout)
+			defer cf() // guarantee that any goroutines created with the ctx are cancelled
+			// Update creates the environment if one doesn't already exist for the branch
+			name, err := api.es.Update(ctx, rrd)
+			if err != nil {
+				log("finished processing push with error: %v", err)
+				return
+			}
+			log("success processing push event (env: %q); done", name)
+		}()
 	case "closed", "unlabeled":
 		log("starting async processing for %v", action)
 		api.wg.Add(1)
@@ -260,10 +359,307 @@ func (api *v0api) processWebhook(ctx context.Context, action string, rrd models.
 		log("unknown action type: %v", action)
 		err = fmt.Errorf("unknown action type: %v (event_log_id: %v)", action, eventlogger.GetLogger(ctx).ID.String())
 		finishWithError()
-		return err
+		return "", err
 	}
 
-	return nil
+	return "", nil
+}
+
+// processPinLabel pins the extant environment for the PR for the maximum pin duration when the pin label is added, or unpins it when the label is removed
+func (api *v0api) processPinLabel(ctx context.Context, pin bool, rrd models.RepoRevisionData, details ghapp.PREventDetails) {
+	log := eventlogger.GetLogger(ctx).Printf
+	if pin && api.sc.MaxPinDuration <= 0 {
+		log("pinning is disabled, ignoring pin label")
+		return
+	}
+	envs, err := api.dl.GetExtantQAEnvironments(ctx, rrd.Repo, rrd.PullRequest)
+	if err != nil {
+		log("error getting extant environments: %v", err)
+		return
+	}
+	if len(envs) != 1 {
+		log("expected exactly one extant environment to pin but there are %v, ignoring pin label", len(envs))
+		return
+	}
+	var d time.Duration
+	if pin {
+		d = api.sc.MaxPinDuration
+	}
+	until, err := api.pinEnv(ctx, api.dl, &envs[0], d, details.Sender)
+	if err != nil {
+		log("error pinning environment: %v", err)
+		return
+	}
+	log("pin label %v processed for %v (pinned until: %v)", api.sc.PinLabel, envs[0].Name, until)
+}
+
+// processExtendLabel extends the lifetime of the extant environment for the PR when the extend label is added, and then removes the label so that it can be added again
+func (api *v0api) processExtendLabel(ctx context.Context, rrd models.RepoRevisionData, details ghapp.PREventDetails) {
+	log := eventlogger.GetLogger(ctx).Printf
+	env, expires, err := api.extendPREnv(ctx, rrd, details.Sender)
+	if err != nil {
+		log("error extending environment: %v", err)
+	} else {
+		log("extend label %v processed for %v (expires: %v)", api.sc.ExtendLabel, env.Name, expires)
+	}
+	if api.lc == nil {
+		log("label client not available, unable to remove extend label")
+		return
+	}
+	if err := api.lc.RemovePRLabel(ctx, rrd.Repo, rrd.PullRequest, api.sc.ExtendLabel); err != nil {
+		log("error removing extend label: %v", err)
+	}
+}
+
+// extendPREnv extends the lifetime of the extant environment for the PR by the lifetime extension (up to the maximum lifetime and maximum total lifetime)
+func (api *v0api) extendPREnv(ctx context.Context, rrd models.RepoRevisionData, user string) (*models.QAEnvironment, *time.Time, error) {
+	envs, err := api.dl.GetExtantQAEnvironments(ctx, rrd.Repo, rrd.PullRequest)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "error getting extant environments")
+	}
+	if len(envs) != 1 {
+		return nil, nil, nitroerrors.User(fmt.Errorf("expected exactly one extant environment to extend but there are %v", len(envs)))
+	}
+	lt := api.sc.EnvironmentLifetimes
+	d := lt.Extension
+	if lt.Max > 0 && d > lt.Max {
+		d = lt.Max
+	}
+	if d <= 0 {
+		return nil, nil, nitroerrors.User(errors.New("lifetime extensions are disabled"))
+	}
+	expires, err := api.extendEnv(ctx, api.dl, &envs[0], d, lt.MaxTotal, user)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &envs[0], expires, nil
+}
+
+// prTriggerAction returns the environment action ("labeled" for create, "synchronize" for update or "closed" for destroy)
+// for a PR webhook action according to the repo trigger labels and auto-create policy, or the empty string if the event should be ignored
+func prTriggerAction(action string, rc models.RepoConfig, rrd models.RepoRevisionData, details ghapp.PREventDetails) string {
+	labeled := rc.HasTriggerLabel(details.Labels...)
+	auto := rc.AutoCreates(rrd.BaseBranch, details.Draft)
+	switch action {
+	case "labeled":
+		if rc.HasTriggerLabel(details.Label) {
+			return "labeled"
+		}
+	case "unlabeled":
+		// removing a trigger label only destroys the environment if it isn't still wanted
+		if rc.HasTriggerLabel(details.Label) && !labeled && !auto {
+			return "closed"
+		}
+	case "opened":
+		// labels present when the PR is opened generate separate labeled events
+		if auto {
+			return "labeled"
+		}
+	case "reopened":
+		if auto || labeled {
+			return "labeled"
+		}
+	case "ready_for_review":
+		// only drafts that were skipped need an environment, the others already have one
+		if auto && rc.AutoCreate.SkipDrafts && !labeled {
+			return "labeled"
+		}
+	case "synchronize":
+		if auto || labeled {
+			return "synchronize"
+		}
+	case "closed":
+		return "closed"
+	}
+	return ""
+}
+
+// forkPRPolicy returns the fork PR policy from the acyl.yml of the PR base branch (never the fork), or the zero (disabled) policy if it's missing
+func (api *v0api) forkPRPolicy(ctx context.Context, rrd models.RepoRevisionData) (models.ForkPRPolicy, error) {
+	acylyml, err := api.rc.GetFileContents(ctx, rrd.Repo, "acyl.yml", rrd.BaseSHA)
+	if err != nil {
+		if strings.Contains(err.Error(), "404 Not Found") {
+			return models.ForkPRPolicy{}, nil
+		}
+		return models.ForkPRPolicy{}, errors.Wrap(err, "error getting base branch acyl.yml")
+	}
+	rc := models.RepoConfig{}
+	if err := yaml.Unmarshal(acylyml, &rc); err != nil {
+		return models.ForkPRPolicy{}, errors.Wrap(err, "error unmarshaling base branch acyl.yml")
+	}
+	return rc.ForkPRs, nil
+}
+
+// forkTriggerAction returns the environment action for a PR webhook action on a PR from a fork, or the empty string if the event should be ignored
+// Environments are only created or updated when a user with write access adds the approval label. Trigger labels and the auto-create policy don't apply.
+func forkTriggerAction(action string, fp models.ForkPRPolicy, details ghapp.PREventDetails) string {
+	switch action {
+	case "labeled":
+		if details.Label == fp.Label() && details.SenderCanWrite {
+			return "synchronize"
+		}
+	case "closed":
+		return "closed"
+	}
+	return ""
+}
+
+// revokeForkApproval removes the approval label from a fork PR so that new commits aren't built until they are approved again
+func (api *v0api) revokeForkApproval(ctx context.Context, rrd models.RepoRevisionData, fp models.ForkPRPolicy, details ghapp.PREventDetails) {
+	log := eventlogger.GetLogger(ctx).Printf
+	var approved bool
+	for _, l := range details.Labels {
+		if l == fp.Label() {
+			approved = true
+		}
+	}
+	if !approved {
+		return
+	}
+	if api.lc == nil {
+		log("label client not available, unable to remove approval label from fork PR")
+		return
+	}
+	if err := api.lc.RemovePRLabel(ctx, rrd.Repo, rrd.PullRequest, fp.Label()); err != nil {
+		log("error removing approval label from fork PR: %v", err)
+		return
+	}
+	log("new commits pushed to fork PR, approval label %v removed", fp.Label())
+}
+
+// approveForkPR adds the approval label to a fork PR and creates or updates the environment
+func (api *v0api) approveForkPR(ctx context.Context, rrd models.RepoRevisionData) (string, error) {
+	fp, err := api.forkPRPolicy(ctx, rrd)
+	if err != nil {
+		return "", errors.Wrap(err, "error getting fork PR policy")
+	}
+	if !fp.Enabled {
+		return "environments for PRs from forks are not enabled for this repo.", nil
+	}
+	if api.lc != nil {
+		// the resulting labeled event is sent by the app and is ignored
+		if err := api.lc.AddPRLabel(ctx, rrd.Repo, rrd.PullRequest, fp.Label()); err != nil {
+			return "", errors.Wrap(err, "error adding approval label")
+		}
+	}
+	ignored, err := api.processWebhook(ctx, "synchronize", rrd, nil)
+	if err != nil {
+		return "", err
+	}
+	if ignored != "" {
+		return fmt.Sprintf("approved %v, but the environment was not built: %v.", rrd.SourceSHA, ignored), nil
+	}
+	return fmt.Sprintf("approved %v, building environment (new commits must be approved again). [Status](%v/ui/event/status?id=%v)", rrd.SourceSHA, api.sc.UIBaseURL, eventlogger.GetLogger(ctx).ID.String()), nil
+}
+
+// processPR handles PR events from the GitHub app
+func (api *v0api) processPR(ctx context.Context, action string, rrd models.RepoRevisionData, details ghapp.PREventDetails) error {
+	_, err := api.processWebhook(ctx, action, rrd, &details)
+	return err
+}
+
+// processPush handles branch push events, creating or updating the environment for the branch if it's listed in track_branches
+func (api *v0api) processPush(ctx context.Context, rrd models.RepoRevisionData) error {
+	_, err := api.processWebhook(ctx, "push", rrd, nil)
+	return err
+}
+
+// processCheckRunRerequest rebuilds the environment associated with the operation identified by eventLogID
+func (api *v0api) processCheckRunRerequest(ctx context.Context, eventLogID uuid.UUID) (uuid.UUID, error) {
+	elog, err := api.dl.GetEventLogByID(eventLogID)
+	if err != nil {
+		return uuid.Nil, errors.Wrap(err, "error getting event log")
+	}
+	if elog == nil || elog.EnvName == "" {
+		return uuid.Nil, fmt.Errorf("no environment found for event log: %v", eventLogID)
+	}
+	qae, err := api.dl.GetQAEnvironment(ctx, elog.EnvName)
+	if err != nil {
+		return uuid.Nil, errors.Wrap(err, "error getting environment")
+	}
+	if qae == nil || qae.Status == models.Destroyed {
+		return uuid.Nil, fmt.Errorf("environment not found or destroyed: %v", elog.EnvName)
+	}
+	return api.startRebuild(ctx, api.dl, api.es, qae, false)
+}
+
+// processCommentCommand handles PR comment commands, returning the body of the reply comment
+func (api *v0api) processCommentCommand(ctx context.Context, command string, rrd models.RepoRevisionData) (string, error) {
+	statusURL := func(id uuid.UUID) string {
+		return fmt.Sprintf("%v/ui/event/status?id=%v", api.sc.UIBaseURL, id.String())
+	}
+	elid := eventlogger.GetLogger(ctx).ID
+	switch command {
+	case ghapp.ApproveCommand:
+		if !rrd.IsFork {
+			return "approval is only required for PRs from forks.", nil
+		}
+		return api.approveForkPR(ctx, rrd)
+	case ghapp.RebuildCommand:
+		// a maintainer rebuilding a fork PR approves the current commit
+		if rrd.IsFork {
+			return api.approveForkPR(ctx, rrd)
+		}
+		// update creates the environment if one doesn't exist
+		ignored, err := api.processWebhook(ctx, "synchronize", rrd, nil)
+		if err != nil {
+			return "", err
+		}
+		if ignored != "" {
+			return fmt.Sprintf("not rebuilding environment: %v.", ignored), nil
+		}
+		return fmt.Sprintf("rebuilding environment for %v. [Status](%v)", rrd.SourceSHA, statusURL(elid)), nil
+	case ghapp.DestroyCommand:
+		ignored, err := api.processWebhook(ctx, "closed", rrd, nil)
+		if err != nil {
+			return "", err
+		}
+		if ignored != "" {
+			return fmt.Sprintf("not destroying environment: %v.", ignored), nil
+		}
+		return fmt.Sprintf("destroying environment. [Status](%v)", statusURL(elid)), nil
+	case ghapp.ExtendCommand:
+		env, expires, err := api.extendPREnv(ctx, rrd, "PR comment command")
+		if err != nil {
+			if nitroerrors.IsUserError(err) {
+				return fmt.Sprintf("unable to extend environment: %v.", err), nil
+			}
+			return "", err
+		}
+		return fmt.Sprintf("environment `%v` now expires at %v.", env.Name, expires.UTC().Format(time.RFC3339)), nil
+	case ghapp.StatusCommand:
+		envs, err := api.dl.GetQAEnvironmentsByRepoAndPR(ctx, rrd.Repo, rrd.PullRequest)
+		if err != nil {
+			return "", errors.Wrap(err, "error getting environments")
+		}
+		var env *models.QAEnvironment
+		for i := range envs {
+			if envs[i].Status != models.Destroyed {
+				env = &envs[i]
+				break
+			}
+		}
+		if env == nil {
+			return "no active environment found for this PR.", nil
+		}
+		msg := fmt.Sprintf("environment `%v` is **%v** at %v.", env.Name, env.Status.String(), env.SourceSHA)
+		elogs, err := api.dl.GetEventLogsByEnvName(env.Name)
+		if err != nil {
+			return "", errors.Wrap(err, "error getting event logs")
+		}
+		var latest *models.EventLog
+		for i := range elogs {
+			if elogs[i].ID != elid && (latest == nil || elogs[i].Created.After(latest.Created)) {
+				latest = &elogs[i]
+			}
+		}
+		if latest != nil {
+			msg += fmt.Sprintf(" [Latest event status](%v)", statusURL(latest.ID))
+		}
+		return msg, nil
+	default:
+		return "", fmt.Errorf("unknown command: %v", command)
+	}
 }
 
 // legacyGithubWebhookHandler serves the legacy (manually set up) GitHook webhook endpoint
@@ -341,7 +737,7 @@ func (api *v0api) legacyGithubWebhookHandler(w http.ResponseWriter, r *http.Requ
 		action = "unknown"
 	}
 
-	err = api.processWebhook(ctx, action, *out.RRD)
+	_, err = api.processWebhook(ctx, action, *out.RRD, nil)
 	if err != nil {
 		api.internalError(w, err)
 		return
@@ -3,15 +3,22 @@ package api
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"io/ioutil"
 	"log"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/dollarshaveclub/acyl/pkg/config"
+	"github.com/dollarshaveclub/acyl/pkg/ghapp"
 	"github.com/dollarshaveclub/acyl/pkg/ghclient"
 	"github.com/dollarshaveclub/acyl/pkg/models"
+	nitroerrors "github.com/dollarshaveclub/acyl/pkg/nitro/errors"
+	"github.com/dollarshaveclub/acyl/pkg/persistence"
+	"github.com/dollarshaveclub/acyl/pkg/spawner"
 	"github.com/dollarshaveclub/acyl/pkg/testhelper/testdatalayer"
 	muxtrace "gopkg.in/DataDog/dd-trace-go.v1/contrib/gorilla/mux"
 )
@@ -541,3 +548,204 @@ func TestAPIv0EnvDetailsUserAPIKeyForbidden(t *testing.T) {
 		t.Fatalf("should have failed: %v: %v", resp.StatusCode, bb)
 	}
 }
+
+func TestPRTriggerAction(t *testing.T) {
+	labelrc := models.RepoConfig{TriggerLabels: []string{"preview"}, AutoCreate: &models.AutoCreatePolicy{}}
+	autorc := models.RepoConfig{TargetBranches: []string{"main"}, TriggerLabels: []string{"preview"}, AutoCreate: &models.AutoCreatePolicy{Enabled: true, SkipDrafts: true}}
+	rrd := models.RepoRevisionData{Repo: "foo/bar", PullRequest: 1, BaseBranch: "main"}
+	otherrrd := rrd
+	otherrrd.BaseBranch = "release"
+	tests := []struct {
+		name    string
+		action  string
+		rc      models.RepoConfig
+		rrd     models.RepoRevisionData
+		details ghapp.PREventDetails
+		want    string
+	}{
+		{"trigger label added", "labeled", labelrc, rrd, ghapp.PREventDetails{Label: "preview", Labels: []string{"preview"}}, "labeled"},
+		{"other label added", "labeled", labelrc, rrd, ghapp.PREventDetails{Label: "bug", Labels: []string{"bug"}}, ""},
+		{"trigger label removed", "unlabeled", labelrc, rrd, ghapp.PREventDetails{Label: "preview"}, "closed"},
+		{"trigger label removed with auto-create", "unlabeled", autorc, rrd, ghapp.PREventDetails{Label: "preview"}, ""},
+		{"opened without auto-create", "opened", labelrc, rrd, ghapp.PREventDetails{}, ""},
+		{"opened with auto-create", "opened", autorc, rrd, ghapp.PREventDetails{}, "labeled"},
+		{"opened with auto-create non-target branch", "opened", autorc, otherrrd, ghapp.PREventDetails{}, ""},
+		{"draft opened with auto-create", "opened", autorc, rrd, ghapp.PREventDetails{Draft: true}, ""},
+		{"ready for review with auto-create", "ready_for_review", autorc, rrd, ghapp.PREventDetails{}, "labeled"},
+		{"labeled ready for review with auto-create", "ready_for_review", autorc, rrd, ghapp.PREventDetails{Labels: []string{"preview"}}, ""},
+		{"reopened labeled", "reopened", labelrc, rrd, ghapp.PREventDetails{Labels: []string{"preview"}}, "labeled"},
+		{"synchronize labeled", "synchronize", labelrc, rrd, ghapp.PREventDetails{Labels: []string{"preview"}}, "synchronize"},
+		{"synchronize unlabeled", "synchronize", labelrc, rrd, ghapp.PREventDetails{}, ""},
+		{"synchronize draft with auto-create", "synchronize", autorc, rrd, ghapp.PREventDetails{Draft: true}, ""},
+		{"synchronize with auto-create", "synchronize", autorc, rrd, ghapp.PREventDetails{}, "synchronize"},
+		{"closed", "closed", labelrc, rrd, ghapp.PREventDetails{}, "closed"},
+		{"edited", "edited", autorc, rrd, ghapp.PREventDetails{}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := prTriggerAction(tt.action, tt.rc, tt.rrd, tt.details); got != tt.want {
+				t.Errorf("prTriggerAction() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestForkTriggerAction(t *testing.T) {
+	fp := models.ForkPRPolicy{Enabled: true}
+	tests := []struct {
+		name    string
+		action  string
+		details ghapp.PREventDetails
+		want    string
+	}{
+		{"approved", "labeled", ghapp.PREventDetails{Label: models.DefaultForkApprovalLabel, Sender: "maintainer", SenderCanWrite: true}, "synchronize"},
+		{"approved without write access", "labeled", ghapp.PREventDetails{Label: models.DefaultForkApprovalLabel, Sender: "contributor"}, ""},
+		{"trigger label", "labeled", ghapp.PREventDetails{Label: models.DefaultTriggerLabel, Sender: "maintainer", SenderCanWrite: true}, ""},
+		{"synchronize while approved", "synchronize", ghapp.PREventDetails{Labels: []string{models.DefaultForkApprovalLabel}}, ""},
+		{"opened", "opened", ghapp.PREventDetails{}, ""},
+		{"unlabeled", "unlabeled", ghapp.PREventDetails{Label: models.DefaultForkApprovalLabel}, ""},
+		{"closed", "closed", ghapp.PREventDetails{}, "closed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := forkTriggerAction(tt.action, fp, tt.details); got != tt.want {
+				t.Errorf("forkTriggerAction() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProcessCommentCommand(t *testing.T) {
+	var acylyml bool
+	var updated, destroyed int
+	api := &v0api{
+		apiBase: apiBase{logger: testlogger},
+		dl:      persistence.NewFakeDataLayer(),
+		sc:      config.ServerConfig{UIBaseURL: "https://acyl.example.com"},
+		rc: &ghclient.FakeRepoClient{GetFileContentsFunc: func(ctx context.Context, repo string, path string, ref string) ([]byte, error) {
+			if !acylyml {
+				return nil, errors.New("404 Not Found")
+			}
+			return []byte("version: 2\n"), nil
+		}},
+		es: &spawner.FakeEnvironmentSpawner{
+			UpdateFunc: func(ctx context.Context, rd models.RepoRevisionData) (string, error) {
+				updated++
+				return "foo-bar", nil
+			},
+			DestroyFunc: func(ctx context.Context, rd models.RepoRevisionData, reason models.QADestroyReason) error {
+				destroyed++
+				return nil
+			},
+		},
+	}
+	rrd := models.RepoRevisionData{Repo: "acme/foo", PullRequest: 1, SourceSHA: "asdf", SourceBranch: "feature"}
+	tests := []struct {
+		command, want string
+	}{
+		{ghapp.RebuildCommand, "not rebuilding environment: acyl.yml is missing for the repo."},
+		{ghapp.DestroyCommand, "not destroying environment: acyl.yml is missing for the repo."},
+	}
+	for _, tt := range tests {
+		reply, err := api.processCommentCommand(context.Background(), tt.command, rrd)
+		if err != nil {
+			t.Fatalf("%v should have succeeded: %v", tt.command, err)
+		}
+		if reply != tt.want {
+			t.Fatalf("bad reply for ignored %v: %v", tt.command, reply)
+		}
+	}
+	acylyml = true
+	reply, err := api.processCommentCommand(context.Background(), ghapp.RebuildCommand, rrd)
+	if err != nil {
+		t.Fatalf("rebuild should have succeeded: %v", err)
+	}
+	api.wg.Wait()
+	if !strings.HasPrefix(reply, "rebuilding environment for asdf. [Status](https://acyl.example.com/ui/event/status?id=") || updated != 1 || destroyed != 0 {
+		t.Fatalf("bad reply for accepted rebuild: %v (updated: %v, destroyed: %v)", reply, updated, destroyed)
+	}
+}
+
+func TestProcessPinLabel(t *testing.T) {
+	dl := persistence.NewFakeDataLayer()
+	dl.CreateQAEnvironment(context.Background(), &models.QAEnvironment{Name: "foo-bar", Repo: "acme/foo", PullRequest: 1, Status: models.Success})
+	api := &v0api{
+		apiBase: apiBase{logger: testlogger},
+		dl:      dl,
+		sc:      config.ServerConfig{PinLabel: models.DefaultPinLabel, MaxPinDuration: time.Hour},
+	}
+	rrd := models.RepoRevisionData{Repo: "acme/foo", PullRequest: 1}
+	details := ghapp.PREventDetails{Label: models.DefaultPinLabel, Sender: "alice"}
+	api.processPinLabel(context.Background(), true, rrd, details)
+	qae, _ := dl.GetQAEnvironment(context.Background(), "foo-bar")
+	if !qae.Pinned(time.Now()) {
+		t.Fatalf("env should have been pinned")
+	}
+	if qae.Pinned(time.Now().Add(2 * time.Hour)) {
+		t.Fatalf("env should only be pinned for the maximum pin duration: %v", qae.PinnedUntil)
+	}
+	api.processPinLabel(context.Background(), false, rrd, details)
+	qae, _ = dl.GetQAEnvironment(context.Background(), "foo-bar")
+	if qae.PinnedUntil != nil {
+		t.Fatalf("env should have been unpinned: %v", qae.PinnedUntil)
+	}
+}
+
+func TestProcessExtendLabel(t *testing.T) {
+	dl := persistence.NewFakeDataLayer()
+	expires := time.Now().UTC().Add(time.Hour)
+	dl.CreateQAEnvironment(context.Background(), &models.QAEnvironment{Name: "foo-bar", Repo: "acme/foo", PullRequest: 1, Status: models.Success, Expires: &expires, ExpiryWarned: true})
+	dl.CreateQAEnvironment(context.Background(), &models.QAEnvironment{Name: "foo-baz", Repo: "acme/foo", PullRequest: 2, Status: models.Success})
+	api := &v0api{
+		apiBase: apiBase{logger: testlogger},
+		dl:      dl,
+		sc: config.ServerConfig{
+			ExtendLabel:          models.DefaultExtendLabel,
+			EnvironmentLifetimes: config.EnvironmentLifetimes{Extension: 72 * time.Hour, Max: 48 * time.Hour},
+		},
+	}
+	details := ghapp.PREventDetails{Label: models.DefaultExtendLabel, Sender: "alice"}
+	api.processExtendLabel(context.Background(), models.RepoRevisionData{Repo: "acme/foo", PullRequest: 1}, details)
+	qae, _ := dl.GetQAEnvironment(context.Background(), "foo-bar")
+	if qae.Expires == nil || qae.Expired(time.Now().Add(47*time.Hour)) {
+		t.Fatalf("env lifetime should have been extended: %v", qae.Expires)
+	}
+	if !qae.Expired(time.Now().Add(49 * time.Hour)) {
+		t.Fatalf("env should only be extended by the maximum lifetime: %v", qae.Expires)
+	}
+	if qae.ExpiryWarned {
+		t.Fatalf("expiry warning should have been reset")
+	}
+	// environments that don't expire are left alone
+	api.processExtendLabel(context.Background(), models.RepoRevisionData{Repo: "acme/foo", PullRequest: 2}, details)
+	qae, _ = dl.GetQAEnvironment(context.Background(), "foo-baz")
+	if qae.Expires != nil {
+		t.Fatalf("env should not expire: %v", qae.Expires)
+	}
+}
+
+func TestExtendPREnvMaxTotal(t *testing.T) {
+	dl := persistence.NewFakeDataLayer()
+	now := time.Now().UTC()
+	created := now.Add(-90 * time.Hour)
+	expires := now.Add(time.Hour)
+	dl.CreateQAEnvironment(context.Background(), &models.QAEnvironment{Name: "foo-bar", Repo: "acme/foo", PullRequest: 1, Status: models.Success, Created: created, Expires: &expires})
+	api := &v0api{
+		apiBase: apiBase{logger: testlogger},
+		dl:      dl,
+		sc: config.ServerConfig{
+			EnvironmentLifetimes: config.EnvironmentLifetimes{Extension: 72 * time.Hour, MaxTotal: 96 * time.Hour},
+		},
+	}
+	rrd := models.RepoRevisionData{Repo: "acme/foo", PullRequest: 1}
+	_, ext, err := api.extendPREnv(context.Background(), rrd, "alice")
+	if err != nil {
+		t.Fatalf("should have succeeded: %v", err)
+	}
+	if !ext.Equal(created.Add(96 * time.Hour)) {
+		t.Fatalf("extension should have been limited to the maximum total lifetime: %v", ext)
+	}
+	if _, _, err := api.extendPREnv(context.Background(), rrd, "alice"); err == nil || !nitroerrors.IsUserError(err) {
+		t.Fatalf("extension beyond the maximum total lifetime should have failed with a user error: %v", err)
+	}
+}
@@ -4,18 +4,23 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"hash/fnv"
 	"io"
 	"log"
 	"net/http"
 	"os"
+	"reflect"
+	"sort"
 	"strconv"
 	"time"
 
 	"github.com/dollarshaveclub/acyl/pkg/config"
 	"github.com/dollarshaveclub/acyl/pkg/eventlogger"
+	"github.com/dollarshaveclub/acyl/pkg/ghclient"
 	"github.com/dollarshaveclub/acyl/pkg/ghevent"
 	"github.com/dollarshaveclub/acyl/pkg/models"
 	ncontext "github.com/dollarshaveclub/acyl/pkg/nitro/context"
+	nitroerrors "github.com/dollarshaveclub/acyl/pkg/nitro/errors"
 	"github.com/dollarshaveclub/acyl/pkg/nitro/metahelm"
 	"github.com/dollarshaveclub/acyl/pkg/persistence"
 	"github.com/dollarshaveclub/acyl/pkg/spawner"
@@ -52,6 +57,9 @@ type v2QAEnvironment struct {
 	AminoServiceToPort       map[string]int64            `json:"amino_service_to_port"`
 	AminoKubernetesNamespace string                      `json:"amino_kubernetes_namespace"`
 	AminoEnvironmentID       int                         `json:"amino_environment_id"`
+	PinnedUntil              *time.Time                  `json:"pinned_until"`
+	LastActivity             time.Time                   `json:"last_activity"`
+	Expires                  *time.Time                  `json:"expires"`
 }
 
 func v2QAEnvironmentFromQAEnvironment(qae *models.QAEnvironment) *v2QAEnvironment {
@@ -76,6 +84,9 @@ func v2QAEnvironmentFromQAEnvironment(qae *models.QAEnvironment) *v2QAEnvironmen
 		AminoServiceToPort:       qae.AminoServiceToPort,
 		AminoKubernetesNamespace: qae.AminoKubernetesNamespace,
 		AminoEnvironmentID:       qae.AminoEnvironmentID,
+		PinnedUntil:              qae.PinnedUntil,
+		LastActivity:             qae.LastActivity(),
+		Expires:                  qae.Expires,
 	}
 }
 
@@ -120,6 +131,10 @@ func statusSummaryType(t models.EventStatusType) string {
 		return "update"
 	case models.DestroyEvent:
 		return "destroy"
+	case models.SuspendEvent:
+		return "suspend"
+	case models.ResumeEvent:
+		return "resume"
 	default:
 		return "default"
 	}
@@ -187,6 +202,7 @@ type V2EventStatusTreeNodeImage struct {
 	Name      string     `json:"name"`
 	BuildID   string     `json:"build_id"`
 	Error     bool       `json:"error"`
+	Cached    bool       `json:"cached"`
 	Completed *time.Time `json:"completed"`
 	Started   *time.Time `json:"started"`
 }
@@ -209,15 +225,55 @@ func statusImageOrNil(image models.EventStatusTreeNodeImage) *V2EventStatusTreeN
 		Name:      image.Name,
 		BuildID:   id,
 		Error:     image.Error,
+		Cached:    image.Cached,
 		Completed: timeOrNil(image.Completed),
 		Started:   timeOrNil(image.Started),
 	}
 }
 
+type V2EventStatusTreeNodeHealthCheck struct {
+	Type      string     `json:"type"`
+	Status    string     `json:"status"`
+	Message   string     `json:"message"`
+	Started   *time.Time `json:"started"`
+	Completed *time.Time `json:"completed"`
+}
+
+func statusHealthCheckStatus(hc models.EventStatusTreeNodeHealthCheck) string {
+	switch {
+	case hc.Error:
+		return "failed"
+	case !hc.Completed.IsZero():
+		return "passed"
+	case !hc.Started.IsZero():
+		return "running"
+	default:
+		return "waiting"
+	}
+}
+
+func statusHealthChecksOrNil(hcs map[string]models.EventStatusTreeNodeHealthCheck) map[string]V2EventStatusTreeNodeHealthCheck {
+	if len(hcs) == 0 {
+		return nil
+	}
+	out := make(map[string]V2EventStatusTreeNodeHealthCheck, len(hcs))
+	for k, v := range hcs {
+		out[k] = V2EventStatusTreeNodeHealthCheck{
+			Type:      v.Type,
+			Status:    statusHealthCheckStatus(v),
+			Message:   v.Message,
+			Started:   timeOrNil(v.Started),
+			Completed: timeOrNil(v.Completed),
+		}
+	}
+	return out
+}
+
 type V2EventStatusTreeNode struct {
-	Parent string                      `json:"parent"`
-	Image  *V2EventStatusTreeNodeImage `json:"image"`
-	Chart  V2EventStatusTreeNodeChart  `json:"chart"`
+	Parent       string                                      `json:"parent"`
+	Image        *V2EventStatusTreeNodeImage                 `json:"image"`
+	Chart        V2EventStatusTreeNodeChart                  `json:"chart"`
+	HealthChecks map[string]V2EventStatusTreeNodeHealthCheck `json:"health_checks,omitempty"`
 }
 
 type V2EventStatusSummary struct {
@@ -236,6 +292,7 @@ func v2EventStatusTreeFromTree(tree map[string]models.EventStatusTreeNode) map[s
 				Completed: timeOrNil(v.Chart.Completed),
 				Started:   timeOrNil(v.Chart.Started),
 			},
+			HealthChecks: statusHealthChecksOrNil(v.HealthChecks),
 		}
 	}
 	return out
@@ -285,10 +342,14 @@ type v2api struct {
 	oauth OAuthConfig
 	kr    metahelm.KubernetesReporter
 	fc    Furan2Client
+	rc    ghclient.RepoClient
+	pc    ghclient.RepoPermissionClient
+	prc   ghclient.PRClient
+	rfc   ghclient.RefClient
 }
 
-func newV2API(dl persistence.DataLayer, ge *ghevent.GitHubEventWebhook, es spawner.EnvironmentSpawner, fc Furan2Client, sc config.ServerConfig, oauth OAuthConfig, logger *log.Logger, kr metahelm.KubernetesReporter) (*v2api, error) {
-	return &v2api{
+func newV2API(dl persistence.DataLayer, ge *ghevent.GitHubEventWebhook, es spawner.EnvironmentSpawner, fc Furan2Client, rc ghclient.RepoClient, sc config.ServerConfig, oauth OAuthConfig, logger *log.Logger, kr metahelm.KubernetesReporter) (*v2api, error) {
+	api := &v2api{
 		apiBase: apiBase{
 			logger: logger,
 		},
@@ -299,7 +360,19 @@ func newV2API(dl persistence.DataLayer, ge *ghevent.GitHubEventWebhook, es spawn
 		oauth: oauth,
 		kr:    kr,
 		fc:    fc,
-	}, nil
+		rc:    rc,
+	}
+	// the permission, PR and ref clients are optional, API key writes are limited to admin keys and the corresponding kind of environment can't be created without them
+	if pc, ok := rc.(ghclient.RepoPermissionClient); ok {
+		api.pc = pc
+	}
+	if prc, ok := rc.(ghclient.PRClient); ok {
+		api.prc = prc
+	}
+	if rfc, ok := rc.(ghclient.RefClient); ok {
+		api.rfc = rfc
+	}
+	return api, nil
 }
 
 func (api *v2api) Close() {
@@ -318,13 +391,28 @@ func (api *v2api) register(r *muxtrace.Router) error {
 	r.HandleFunc("/v2/envs/_search", middlewareChain(authMiddleware.tokenAuth(authMiddleware.authorize(api.envSearchHandler), models.ReadOnlyPermission))).Methods("GET")
 	r.HandleFunc("/v2/envs/{name}", middlewareChain(authMiddleware.tokenAuth(authMiddleware.authorizeEnv(api.envDetailHandler), models.ReadOnlyPermission))).Methods("GET")
 	r.HandleFunc("/v2/eventlog/{id}", middlewareChain(authMiddleware.tokenAuth(authMiddleware.authorizeEventLog(api.eventLogHandler), models.ReadOnlyPermission))).Methods("GET")
+	r.HandleFunc("/v2/eventlog/{id}/status", middlewareChain(authMiddleware.tokenAuth(authMiddleware.authorizeEventLog(api.eventLogStatusHandler), models.ReadOnlyPermission))).Methods("GET")
+	r.HandleFunc("/v2/envs/{name}/events", middlewareChain(authMiddleware.tokenAuth(authMiddleware.authorizeEnv(api.envEventsHandler), models.ReadOnlyPermission))).Methods("GET")
+	r.HandleFunc("/v2/envs/{name}/pods", middlewareChain(authMiddleware.tokenAuth(authMiddleware.authorizeEnv(api.envPodsHandler), models.ReadOnlyPermission))).Methods("GET")
+	r.HandleFunc("/v2/envs/{name}/pods/{pod}/logs", middlewareChain(authMiddleware.tokenAuth(authMiddleware.authorizeEnv(api.envPodLogsHandler), models.ReadOnlyPermission))).Methods("GET")
+	r.HandleFunc("/v2/envs", middlewareChain(authMiddleware.tokenAuth(authMiddleware.authorize(api.envCreateHandler), models.WritePermission))).Methods("POST")
+	r.HandleFunc("/v2/envs/{name}", middlewareChain(authMiddleware.tokenAuth(authMiddleware.authorize(api.envDestroyHandler), models.WritePermission))).Methods("DELETE")
+	r.HandleFunc("/v2/envs/{name}/actions/rebuild", middlewareChain(authMiddleware.tokenAuth(authMiddleware.authorize(api.envActionsRebuildHandler), models.WritePermission))).Methods("POST")
+	r.HandleFunc("/v2/envs/{name}/actions/update", middlewareChain(authMiddleware.tokenAuth(authMiddleware.authorize(api.envActionsUpdateHandler), models.WritePermission))).Methods("POST")
 
 	// Session auth
 	r.HandleFunc("/v2/event/{id}/status", middlewareChain(api.eventStatusHandler, sessionAuthMiddleware.sessionAuth)).Methods("GET")
 	r.HandleFunc("/v2/event/{id}/logs", middlewareChain(api.logsHandler, sessionAuthMiddleware.sessionAuth)).Methods("GET")
+	r.HandleFunc("/v2/event/{id}/status/stream", middlewareChain(api.eventStatusStreamHandler, sessionAuthMiddleware.sessionAuth)).Methods("GET")
+	r.HandleFunc("/v2/event/{id}/logs/stream", middlewareChain(api.logsStreamHandler, sessionAuthMiddleware.sessionAuth)).Methods("GET")
 	r.HandleFunc("/v2/userenvs", middlewareChain(api.userEnvsHandler, sessionAuthMiddleware.sessionAuth)).Methods("GET")
 	r.HandleFunc("/v2/userenvs/{name}", middlewareChain(api.userEnvDetailHandler, sessionAuthMiddleware.sessionAuth)).Methods("GET")
 	r.HandleFunc("/v2/userenvs/{name}/actions/rebuild", middlewareChain(api.userEnvActionsRebuildHandler, sessionAuthMiddleware.sessionAuth)).Methods("POST")
+	r.HandleFunc("/v2/userenvs/{name}/actions/suspend", middlewareChain(api.userEnvActionsSuspendHandler, sessionAuthMiddleware.sessionAuth)).Methods("POST")
+	r.HandleFunc("/v2/userenvs/{name}/actions/resume", middlewareChain(api.userEnvActionsResumeHandler, sessionAuthMiddleware.sessionAuth)).Methods("POST")
+	r.HandleFunc("/v2/userenvs/{name}/actions/pin", middlewareChain(api.userEnvActionsPinHandler, sessionAuthMiddleware.sessionAuth)).Methods("POST")
+	r.HandleFunc("/v2/userenvs/{name}/actions/unpin", middlewareChain(api.userEnvActionsUnpinHandler, sessionAuthMiddleware.sessionAuth)).Methods("POST")
+	r.HandleFunc("/v2/userenvs/{name}/actions/extend", middlewareChain(api.userEnvActionsExtendHandler, sessionAuthMiddleware.sessionAuth)).Methods("POST")
 	r.HandleFunc("/v2/userenvs/{name}/namespace/pods", middlewareChain(api.userEnvNamePodsHandler, sessionAuthMiddleware.sessionAuth)).Methods("GET")
 	r.HandleFunc("/v2/userenvs/{name}/namespace/pod/{pod}/containers", middlewareChain(api.userEnvPodContainersHandler, sessionAuthMiddleware.sessionAuth)).Methods("GET")
 	r.HandleFunc("/v2/userenvs/{name}/namespace/pod/{pod}/logs", middlewareChain(api.userEnvPodLogsHandler, sessionAuthMiddleware.sessionAuth)).Methods("GET")
@@ -360,6 +448,7 @@ func (api *v2api) envDetailHandler(w http.ResponseWriter, r *http.Request) {
 		api.internalError(w, fmt.Errorf("unexpected qa env type from context: %T", qa))
 		return
 	}
+	api.recordAccess(r.Context(), api.dl, qa.Name)
 	output := v2QAEnvironmentFromQAEnvironment(&qa)
 	j, err := json.Marshal(output)
 	if err != nil {
@@ -370,6 +459,262 @@ func (api *v2api) envDetailHandler(w http.ResponseWriter, r *http.Request) {
 	w.Write(j)
 }
 
+// envEventsHandler returns summaries of the events for the environment authorized by API key, newest first
+func (api *v2api) envEventsHandler(w http.ResponseWriter, r *http.Request) {
+	qa, ok := r.Context().Value(qaEnvCtxKey).(models.QAEnvironment)
+	if !ok {
+		api.internalError(w, fmt.Errorf("unexpected qa env type from context: %T", qa))
+		return
+	}
+	elogs, err := api.dl.GetEventLogsByEnvName(qa.Name)
+	if err != nil {
+		api.internalError(w, errors.Wrap(err, "error getting event logs"))
+		return
+	}
+	sort.Slice(elogs, func(i, j int) bool { return elogs[i].Created.After(elogs[j].Created) })
+	j, err := json.Marshal(V2EventSummariesFromEventLogs(elogs))
+	if err != nil {
+		api.internalError(w, errors.Wrap(err, "error marshaling events"))
+		return
+	}
+	w.Header().Add("Content-Type", "application/json")
+	w.Write(j)
+}
+
+// v2EnvCreateRequest describes an environment to create from either a pull request or a ref (branch, tag or commit SHA)
+type v2EnvCreateRequest struct {
+	Repo        string `json:"repo"`
+	PullRequest uint   `json:"pull_request"`
+	Ref         string `json:"ref"`
+}
+
+// v2EventLogIDResponse is returned by write operations, which are processed asynchronously
+type v2EventLogIDResponse struct {
+	EventLogID string `json:"event_log_id"`
+}
+
+func (api *v2api) writeEventLogID(w http.ResponseWriter, id uuid.UUID) {
+	j, err := json.Marshal(v2EventLogIDResponse{EventLogID: id.String()})
+	if err != nil {
+		api.internalError(w, fmt.Errorf("error marshaling response: %v", err))
+		return
+	}
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	w.Write(j)
+}
+
+// apiKeyUser returns the name recorded for operations performed with apikey
+func apiKeyUser(apikey models.APIKey) string {
+	if apikey.GitHubUser == "" {
+		return "API admin key"
+	}
+	return apikey.GitHubUser
+}
+
+// canWriteRepo returns whether the GitHub user that owns apikey has write access to repo. Admin keys may write to any repo.
+func (api *v2api) canWriteRepo(ctx context.Context, apikey models.APIKey, repo string) (bool, error) {
+	if apikey.PermissionLevel == models.AdminPermission {
+		return true, nil
+	}
+	if api.pc == nil || apikey.GitHubUser == "" {
+		return false, nil
+	}
+	perm, err := api.pc.GetRepoPermission(ctx, repo, apikey.GitHubUser)
+	if err != nil {
+		return false, errors.Wrap(err, "error getting repo permission")
+	}
+	return ghclient.CanWrite(perm), nil
+}
+
+// writableEnv returns the environment named in the request if the API key owner has write access to its repo.
+// If ok is false, an error response has already been written.
+func (api *v2api) writableEnv(w http.ResponseWriter, r *http.Request) (qae *models.QAEnvironment, apikey models.APIKey, ok bool) {
+	apikey, ok = r.Context().Value(apiKeyCtxKey).(models.APIKey)
+	if !ok {
+		api.internalError(w, fmt.Errorf("unexpected api key type from context: %T", apikey))
+		return nil, apikey, false
+	}
+	qae, err := api.dl.GetQAEnvironment(r.Context(), mux.Vars(r)["name"])
+	if err != nil {
+		api.internalError(w, fmt.Errorf("error getting environment: %v", err))
+		return nil, apikey, false
+	}
+	if qae == nil {
+		api.notfoundError(w)
+		return nil, apikey, false
+	}
+	if qae.Status == models.Destroyed {
+		api.badRequestError(w, fmt.Errorf("environment is destroyed: %v", qae.Name))
+		return nil, apikey, false
+	}
+	canWrite, err := api.canWriteRepo(r.Context(), apikey, qae.Repo)
+	if err != nil {
+		api.internalError(w, err)
+		return nil, apikey, false
+	}
+	if !canWrite {
+		api.forbiddenError(w, "write access to the environment repo is required")
+		return nil, apikey, false
+	}
+	return qae, apikey, true
+}
+
+// envCreateHandler creates an environment for an open pull request, or an ad-hoc environment for a branch, tag or commit SHA if ref is supplied
+func (api *v2api) envCreateHandler(w http.ResponseWriter, r *http.Request) {
+	apikey, ok := r.Context().Value(apiKeyCtxKey).(models.APIKey)
+	if !ok {
+		api.internalError(w, fmt.Errorf("unexpected api key type from context: %T", apikey))
+		return
+	}
+	req := v2EnvCreateRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		api.badRequestError(w, fmt.Errorf("error unmarshaling request: %v", err))
+		return
+	}
+	if req.Repo == "" {
+		api.badRequestError(w, fmt.Errorf("repo is required"))
+		return
+	}
+	if (req.PullRequest == 0) == (req.Ref == "") {
+		api.badRequestError(w, fmt.Errorf("exactly one of pull_request or ref is required"))
+		return
+	}
+	canWrite, err := api.canWriteRepo(r.Context(), apikey, req.Repo)
+	if err != nil {
+		api.internalError(w, err)
+		return
+	}
+	if !canWrite {
+		api.forbiddenError(w, "write access to the repo is required")
+		return
+	}
+	var rrd models.RepoRevisionData
+	if req.PullRequest != 0 {
+		if api.prc == nil {
+			api.internalError(w, fmt.Errorf("pull request client is unavailable"))
+			return
+		}
+		pr, err := api.prc.GetPR(r.Context(), req.Repo, req.PullRequest)
+		if err != nil {
+			api.badRequestError(w, err)
+			return
+		}
+		if pr.State != "open" {
+			api.badRequestError(w, fmt.Errorf("pull request is not open: %v", pr.State))
+			return
+		}
+		rrd = models.RepoRevisionData{
+			BaseBranch:   pr.BaseBranch,
+			BaseSHA:      pr.BaseSHA,
+			PullRequest:  pr.Number,
+			Repo:         req.Repo,
+			SourceBranch: pr.HeadBranch,
+			SourceRef:    pr.HeadBranch,
+			SourceSHA:    pr.HeadSHA,
+			User:         pr.User,
+			IsFork:       pr.IsFork,
+		}
+	} else {
+		if api.rfc == nil {
+			api.internalError(w, fmt.Errorf("ref client is unavailable"))
+			return
+		}
+		ri, err := api.rfc.ResolveRef(r.Context(), req.Repo, req.Ref)
+		if err != nil {
+			api.badRequestError(w, err)
+			return
+		}
+		db, err := api.rc.GetBranch(r.Context(), req.Repo, ri.DefaultBranch)
+		if err != nil {
+			api.internalError(w, fmt.Errorf("error getting default branch: %v", err))
+			return
+		}
+		// ad-hoc environments are not associated with a PR, so the ref is used as the source branch for dependency branch matching,
+		// falling back to the repo default branch
+		rrd = models.RepoRevisionData{
+			BaseBranch:   ri.DefaultBranch,
+			BaseSHA:      db.SHA,
+			Repo:         req.Repo,
+			SourceBranch: req.Ref,
+			SourceRef:    req.Ref,
+			SourceSHA:    ri.SHA,
+			User:         apiKeyUser(apikey),
+		}
+	}
+	id, err := api.startOperation(r.Context(), api.dl, rrd, "", "create", func(ctx context.Context) (string, error) {
+		eventlogger.GetLogger(ctx).Printf("create requested via API by %v", apiKeyUser(apikey))
+		return api.es.Create(ctx, rrd)
+	})
+	if err != nil {
+		api.internalError(w, fmt.Errorf("error starting create: %v", err))
+		return
+	}
+	api.writeEventLogID(w, id)
+}
+
+// envActionsRebuildHandler rebuilds the environment with a standard synchronize or (full) rebuild
+func (api *v2api) envActionsRebuildHandler(w http.ResponseWriter, r *http.Request) {
+	qae, apikey, ok := api.writableEnv(w, r)
+	if !ok {
+		return
+	}
+	api.dl.AddEvent(r.Context(), qae.Name, fmt.Sprintf("rebuild requested via API by %v", apiKeyUser(apikey)))
+	id, err := api.startRebuild(r.Context(), api.dl, api.es, qae, r.URL.Query().Get("full") == "true")
+	if err != nil {
+		api.internalError(w, fmt.Errorf("error starting rebuild: %v", err))
+		return
+	}
+	api.writeEventLogID(w, id)
+}
+
+// envActionsUpdateHandler updates the environment to the commit sha, which must exist in the environment repo
+func (api *v2api) envActionsUpdateHandler(w http.ResponseWriter, r *http.Request) {
+	qae, apikey, ok := api.writableEnv(w, r)
+	if !ok {
+		return
+	}
+	sha := r.URL.Query().Get("sha")
+	if sha == "" {
+		api.badRequestError(w, fmt.Errorf("sha is required"))
+		return
+	}
+	if api.rc != nil {
+		if _, err := api.rc.GetCommitMessage(r.Context(), qae.Repo, sha); err != nil {
+			api.badRequestError(w, fmt.Errorf("error getting commit: %v", err))
+			return
+		}
+	}
+	rrd := qae.RepoRevisionDataFromQA()
+	rrd.SourceSHA = sha
+	api.dl.AddEvent(r.Context(), qae.Name, fmt.Sprintf("update to %v requested via API by %v", sha, apiKeyUser(apikey)))
+	id, err := api.startOperation(r.Context(), api.dl, *rrd, qae.Name, "update", func(ctx context.Context) (string, error) {
+		return api.es.Update(ctx, *rrd)
+	})
+	if err != nil {
+		api.internalError(w, fmt.Errorf("error starting update: %v", err))
+		return
+	}
+	api.writeEventLogID(w, id)
+}
+
+// envDestroyHandler destroys the environment
+func (api *v2api) envDestroyHandler(w http.ResponseWriter, r *http.Request) {
+	qae, apikey, ok := api.writableEnv(w, r)
+	if !ok {
+		return
+	}
+	api.dl.AddEvent(r.Context(), qae.Name, fmt.Sprintf("destroy requested via API by %v", apiKeyUser(apikey)))
+	id, err := api.startOperation(r.Context(), api.dl, *qae.RepoRevisionDataFromQA(), qae.Name, "destroy", func(ctx context.Context) (string, error) {
+		return qae.Name, api.es.DestroyExplicitly(ctx, qae, models.DestroyApiRequest)
+	})
+	if err != nil {
+		api.internalError(w, fmt.Errorf("error starting destroy: %v", err))
+		return
+	}
+	api.writeEventLogID(w, id)
+}
+
 func (api *v2api) envSearchHandler(w http.ResponseWriter, r *http.Request) {
 	apikey, ok := r.Context().Value(apiKeyCtxKey).(models.APIKey)
 	if !ok {
@@ -475,6 +820,21 @@ func (api *v2api) eventStatusHandler(w http.ResponseWriter, r *http.Request) {
 		api.badRequestError(w, errors.Wrap(err, "error parsing id"))
 		return
 	}
+	api.writeEventStatus(w, id)
+}
+
+// eventLogStatusHandler returns the status of the event authorized by API key
+func (api *v2api) eventLogStatusHandler(w http.ResponseWriter, r *http.Request) {
+	el, ok := r.Context().Value(eventLogCtxKey).(models.EventLog)
+	if !ok {
+		api.internalError(w, fmt.Errorf("unexpected event log type from context: %T", el))
+		return
+	}
+	api.writeEventStatus(w, el.ID)
+}
+
+// writeEventStatus writes the status summary of the event id
+func (api *v2api) writeEventStatus(w http.ResponseWriter, id uuid.UUID) {
 	es, err := api.dl.GetEventStatus(id)
 	if err != nil {
 		api.internalError(w, errors.Wrap(err, "error fetching event status"))
@@ -493,54 +853,265 @@ func (api *v2api) eventStatusHandler(w http.ResponseWriter, r *http.Request) {
 	w.Write(j)
 }
 
+// V2EventStatusDelta is the changed portion of an event status summary. Config is omitted if unchanged and Tree only includes changed nodes.
+type V2EventStatusDelta struct {
+	Config *V2EventStatusSummaryConfig      `json:"config,omitempty"`
+	Tree   map[string]V2EventStatusTreeNode `json:"tree,omitempty"`
+}
+
+// version returns an opaque identifier for the content of the summary
+func (s *V2EventStatusSummary) version() (string, error) {
+	j, err := json.Marshal(s)
+	if err != nil {
+		return "", errors.Wrap(err, "error marshaling status")
+	}
+	h := fnv.New64a()
+	h.Write(j)
+	return strconv.FormatUint(h.Sum64(), 16), nil
+}
+
+// v2EventStatusDelta returns the changes from prev to cur, or nil if there are none. If the changes can't be represented
+// as a delta (tree nodes were removed) ok is false.
+func v2EventStatusDelta(prev, cur *V2EventStatusSummary) (delta *V2EventStatusDelta, ok bool) {
+	for k := range prev.Tree {
+		if _, exists := cur.Tree[k]; !exists {
+			return nil, false
+		}
+	}
+	delta = &V2EventStatusDelta{}
+	if !reflect.DeepEqual(prev.Config, cur.Config) {
+		delta.Config = &cur.Config
+	}
+	for k, v := range cur.Tree {
+		if pv, exists := prev.Tree[k]; !exists || !reflect.DeepEqual(pv, v) {
+			if delta.Tree == nil {
+				delta.Tree = make(map[string]V2EventStatusTreeNode)
+			}
+			delta.Tree[k] = v
+		}
+	}
+	if delta.Config == nil && delta.Tree == nil {
+		return nil, true
+	}
+	return delta, true
+}
+
+// eventStatusStreamHandler streams the event status as server-sent events for the UI and scripts
+// A "status" event with the full status summary is sent first, followed by a "delta" event (see V2EventStatusDelta) each time the status changes.
+// A "done" event is sent and the stream is closed when the event is completed.
+// Event IDs identify the status content: if a reconnecting client sends a Last-Event-ID that matches the current status, the initial "status" event is omitted.
+func (api *v2api) eventStatusStreamHandler(w http.ResponseWriter, r *http.Request) {
+	idstr := mux.Vars(r)["id"]
+	id, err := uuid.Parse(idstr)
+	if err != nil {
+		api.badRequestError(w, errors.Wrap(err, "error parsing id"))
+		return
+	}
+	updates, err := api.dl.SubscribeEventLog(r.Context(), id)
+	if err != nil {
+		api.internalError(w, errors.Wrap(err, "error subscribing to event log"))
+		return
+	}
+	es, err := api.dl.GetEventStatus(id)
+	if err != nil {
+		api.internalError(w, errors.Wrap(err, "error fetching event status"))
+		return
+	}
+	if es == nil {
+		api.notfoundError(w)
+		return
+	}
+	sw, err := newSSEWriter(w)
+	if err != nil {
+		api.internalError(w, err)
+		return
+	}
+	var prev *V2EventStatusSummary
+	lastid := r.Header.Get("Last-Event-ID")
+	err = streamEventLogUpdates(sw, updates, func() (bool, error) {
+		if es == nil {
+			var err error
+			es, err = api.dl.GetEventStatus(id)
+			if err != nil {
+				return false, errors.Wrap(err, "error fetching event status")
+			}
+			if es == nil {
+				// event log was deleted
+				return true, nil
+			}
+		}
+		cur := V2EventStatusSummaryFromEventStatusSummary(es)
+		es = nil
+		ver, err := cur.version()
+		if err != nil {
+			return false, err
+		}
+		switch {
+		case prev == nil && ver == lastid:
+			// client is resuming and is up to date
+		case prev == nil:
+			if err := sw.event(ver, "status", cur); err != nil {
+				return false, err
+			}
+		default:
+			delta, ok := v2EventStatusDelta(prev, cur)
+			switch {
+			case !ok:
+				err = sw.event(ver, "status", cur)
+			case delta != nil:
+				err = sw.event(ver, "delta", delta)
+			}
+			if err != nil {
+				return false, err
+			}
+		}
+		prev = cur
+		if cur.Config.Completed != nil {
+			return true, sw.event("", "done", struct{}{})
+		}
+		return false, nil
+	})
+	if err != nil {
+		api.logger.Printf("error streaming event status: %v", err)
+	}
+}
+
 // logsHandler is an unauthenticated event log API endpoint for the UI that only returns event log lines
 // and not the full EventLog object
 // Instead of a global API token (like /v2/eventlog/{id}) it requires an event-scoped
 // log key (UUID) passed in the "Acyl-Log-Key" request header
 func (api *v2api) logsHandler(w http.ResponseWriter, r *http.Request) {
-	lk := r.Header.Get("Acyl-Log-Key")
+	elog := api.getEventLogWithLogKey(w, r, r.Header.Get("Acyl-Log-Key"))
+	if elog == nil {
+		return
+	}
+	j, err := json.Marshal(&elog.Log)
+	if err != nil {
+		api.logger.Printf("error serving event logs: error marshaling log: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Add("Content-Type", "application/json")
+	w.Write(j)
+}
+
+// getEventLogWithLogKey returns the event log for the request if lk is the log key for the event log.
+// If the event log can't be returned, the appropriate status code is written to w and nil is returned.
+func (api *v2api) getEventLogWithLogKey(w http.ResponseWriter, r *http.Request, lk string) *models.EventLog {
 	if lk == "" {
 		api.logger.Printf("error serving event logs: missing log key")
 		w.WriteHeader(http.StatusUnauthorized)
-		return
+		return nil
 	}
 	lkuuid, err := uuid.Parse(lk)
 	if err != nil {
 		api.logger.Printf("error serving event logs: bad log key: %v", err)
 		w.WriteHeader(http.StatusUnauthorized)
-		return
+		return nil
 	}
 	idstr := mux.Vars(r)["id"]
 	id, err := uuid.Parse(idstr)
 	if err != nil {
 		api.logger.Printf("error serving event logs: bad event id: %v", err)
 		w.WriteHeader(http.StatusBadRequest)
-		return
+		return nil
 	}
 	elog, err := api.dl.GetEventLogByID(id)
 	if err != nil {
 		api.logger.Printf("error serving event logs: error getting event log: %v", err)
 		w.WriteHeader(http.StatusInternalServerError)
-		return
+		return nil
 	}
 	if elog == nil {
 		api.logger.Printf("error serving event logs: missing event log")
 		w.WriteHeader(http.StatusNotFound)
-		return
+		return nil
 	}
 	if elog.LogKey != lkuuid {
 		api.logger.Printf("error serving event logs: mismatched log key")
 		w.WriteHeader(http.StatusUnauthorized)
+		return nil
+	}
+	return elog
+}
+
+// streamEventLogUpdates calls update once and then again every time a value is received from updates, writing keepalive comments to sw while idle.
+// It returns when update returns true or an error, or when updates is closed (the client disconnected).
+func streamEventLogUpdates(sw *sseWriter, updates <-chan struct{}, update func() (bool, error)) error {
+	t := time.NewTicker(sseKeepAliveInterval)
+	defer t.Stop()
+	for {
+		done, err := update()
+		if err != nil || done {
+			return err
+		}
+	wait:
+		for {
+			select {
+			case _, ok := <-updates:
+				if !ok {
+					return nil
+				}
+				break wait
+			case <-t.C:
+				if err := sw.comment("keepalive"); err != nil {
+					return err
+				}
+			}
+		}
+	}
+}
+
+// logsStreamHandler streams event log lines as server-sent events for the UI and scripts
+// It requires the event log key in the Acyl-Log-Key header like logsHandler. The key is never accepted in the URL, which may end up in logs and browser history.
+// Each line is sent as a "log" event with the line number as the event ID, so reconnecting clients that send Last-Event-ID only get the lines they haven't seen.
+// A "done" event is sent and the stream is closed when the event is completed.
+func (api *v2api) logsStreamHandler(w http.ResponseWriter, r *http.Request) {
+	elog := api.getEventLogWithLogKey(w, r, r.Header.Get("Acyl-Log-Key"))
+	if elog == nil {
 		return
 	}
-	j, err := json.Marshal(&elog.Log)
+	id := elog.ID
+	updates, err := api.dl.SubscribeEventLog(r.Context(), id)
 	if err != nil {
-		api.logger.Printf("error serving event logs: error marshaling log: %v", err)
-		w.WriteHeader(http.StatusInternalServerError)
+		api.internalError(w, errors.Wrap(err, "error subscribing to event log"))
 		return
 	}
-	w.Header().Add("Content-Type", "application/json")
-	w.Write(j)
+	sw, err := newSSEWriter(w)
+	if err != nil {
+		api.internalError(w, err)
+		return
+	}
+	sent, _ := strconv.Atoi(r.Header.Get("Last-Event-ID"))
+	if sent < 0 {
+		sent = 0
+	}
+	err = streamEventLogUpdates(sw, updates, func() (bool, error) {
+		if elog == nil {
+			var err error
+			elog, err = api.dl.GetEventLogByID(id)
+			if err != nil {
+				return false, errors.Wrap(err, "error getting event log")
+			}
+			if elog == nil {
+				// event log was deleted
+				return true, nil
+			}
+		}
+		for ; sent < len(elog.Log); sent++ {
+			if err := sw.event(strconv.Itoa(sent+1), "log", elog.Log[sent]); err != nil {
+				return false, err
+			}
+		}
+		if !elog.Status.Config.Completed.IsZero() {
+			return true, sw.event("", "done", struct{}{})
+		}
+		elog = nil
+		return false, nil
+	})
+	if err != nil {
+		api.logger.Printf("error streaming event logs: %v", err)
+	}
 }
 
 type V2UserEnv struct {
@@ -565,6 +1136,8 @@ func v2UserEnvFromQAEnvironment(qa models.QAEnvironment) V2UserEnv {
 		out.Status = "success"
 	case models.Failure:
 		out.Status = "failed"
+	case models.Suspended:
+		out.Status = "suspended"
 	case models.Spawned:
 		fallthrough
 	case models.Updating:
@@ -602,6 +1175,7 @@ func (api *v2api) userEnvsHandler(w http.ResponseWriter, r *http.Request) {
 		models.Spawned,
 		models.Updating,
 		models.Failure,
+		models.Suspended,
 	}
 	if incd := r.URL.Query().Get("include_destroyed"); incd == "true" {
 		statuses = append(statuses, models.Destroyed)
@@ -677,17 +1251,26 @@ type V2EnvDetail struct {
 	V2UserEnv
 	GitHubUser   string           `json:"github_user"`
 	PRHeadBranch string           `json:"pr_head_branch"`
+	SourceRef    string           `json:"source_ref"`
 	K8sNamespace string           `json:"k8s_namespace"`
+	PinnedUntil  *time.Time       `json:"pinned_until"`
+	Expires      *time.Time       `json:"expires"`
 	Events       []V2EventSummary `json:"events"`
 }
 
 func V2EnvDetailFromQAEnvAndK8sEnv(qae models.QAEnvironment, k8senv models.KubernetesEnvironment) V2EnvDetail {
-	return V2EnvDetail{
+	out := V2EnvDetail{
 		V2UserEnv:    v2UserEnvFromQAEnvironment(qae),
 		GitHubUser:   qae.User,
 		PRHeadBranch: qae.SourceBranch,
+		SourceRef:    qae.SourceRef,
 		K8sNamespace: k8senv.Namespace,
+		Expires:      qae.Expires,
 	}
+	if qae.Pinned(time.Now().UTC()) {
+		out.PinnedUntil = qae.PinnedUntil
+	}
+	return out
 }
 
 // userEnvDetailHandler gets environment detail for the UI
@@ -747,6 +1330,7 @@ func (api *v2api) userEnvDetailHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	api.recordAccess(r.Context(), api.dl, qae.Name)
 	ed := V2EnvDetailFromQAEnvAndK8sEnv(*qae, *k8senv)
 	ed.Events = V2EventSummariesFromEventLogs(elogs)
 	w.Header().Add("Content-Type", "application/json")
@@ -802,6 +1386,67 @@ func (api *v2api) userEnvActionsRebuildHandler(w http.ResponseWriter, r *http.Re
 		w.WriteHeader(http.StatusNotFound)
 		return
 	}
+	if _, err := api.startRebuild(context.Background(), api.dl, api.es, qae, r.URL.Query().Get("full") == "true"); err != nil {
+		api.rlogger(r).Logf("error starting rebuild: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+}
+
+// userEnvActionsSuspendHandler scales all workloads in the environment to zero from the UI
+func (api *v2api) userEnvActionsSuspendHandler(w http.ResponseWriter, r *http.Request) {
+	api.userEnvScaleAction(w, r, "suspend", api.es.Suspend)
+}
+
+// userEnvActionsResumeHandler restores all workloads in a suspended environment from the UI
+func (api *v2api) userEnvActionsResumeHandler(w http.ResponseWriter, r *http.Request) {
+	api.userEnvScaleAction(w, r, "resume", api.es.Resume)
+}
+
+// userEnvScaleAction checks that the session user has write permissions for the environment repo and asynchronously executes action
+func (api *v2api) userEnvScaleAction(w http.ResponseWriter, r *http.Request, name string, action func(context.Context, models.RepoRevisionData) error) {
+	uis, err := getSessionFromContext(r.Context())
+	if err != nil {
+		api.rlogger(r).Logf("session missing from context")
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	envname := mux.Vars(r)["name"]
+	if envname == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	qae, err := api.dl.GetQAEnvironment(r.Context(), envname)
+	if err != nil {
+		api.rlogger(r).Logf("error getting qa env from db: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if qae == nil {
+		api.rlogger(r).Logf("qa env not found")
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	repos, err := userPermissionsClient(api.oauth, qae.Repo).GetUserWritableRepos(r.Context(), uis)
+	if err != nil {
+		api.rlogger(r).Logf("error getting user writable repos: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if _, ok := repos[qae.Repo]; !ok {
+		api.rlogger(r).Logf("user writable repo not found")
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	switch {
+	case name == "suspend" && qae.Status != models.Success:
+		api.badRequestError(w, fmt.Errorf("only successful environments can be suspended"))
+		return
+	case name == "resume" && qae.Status != models.Suspended:
+		api.badRequestError(w, fmt.Errorf("environment is not suspended"))
+		return
+	}
 	rrd := qae.RepoRevisionDataFromQA()
 
 	// setup logger
@@ -831,26 +1476,13 @@ func (api *v2api) userEnvActionsRebuildHandler(w http.ResponseWriter, r *http.Re
 	// setup context
 	ctx := eventlogger.NewEventLoggerContext(context.Background(), elogger)
 	ctx = ncontext.NewCancelFuncContext(context.WithCancel(ctx))
-	span := tracer.StartSpan("actions_rebuilder")
+	span := tracer.StartSpan("actions_" + name)
 	span.SetTag(ext.SamplingPriority, ext.PriorityUserKeep)
 	setTagsForGithubWebhookHandler(span, *rrd)
 	ctx = tracer.ContextWithSpan(ctx, span)
 	logger := eventlogger.GetLogger(ctx).Printf
 
-	// check for full rebuild
-	messaging := "synchronize"
-	if r.URL.Query().Get("full") == "true" {
-		messaging = "rebuild"
-		err = api.dl.UpdateK8sEnvConfigSignature(ctx, qae.Name, [32]byte{})
-		if err != nil {
-			api.rlogger(r).Logf("error updating config signature: %v", err)
-			w.WriteHeader(http.StatusInternalServerError)
-			return
-		}
-	}
-
-	// update environment
-	logger("starting async processing for %v rebuild update", messaging)
+	logger("starting async processing for %v", name)
 	api.wg.Add(1)
 	go func() {
 		var err error
@@ -858,14 +1490,165 @@ func (api *v2api) userEnvActionsRebuildHandler(w http.ResponseWriter, r *http.Re
 		defer api.wg.Done()
 		ctx, cf := context.WithTimeout(ctx, MaxAsyncActionTimeout)
 		defer cf() // guarantee that any goroutines created with the ctx are cancelled
-		name, err := api.es.Update(ctx, *rrd)
+		err = action(ctx, *rrd)
 		if err != nil {
-			logger("finished processing %v update with error: %v", messaging, err)
+			logger("finished processing %v with error: %v", name, err)
 			return
 		}
-		logger("success processing %v update event (env: %q); done", messaging, name)
+		logger("success processing %v (env: %q); done", name, qae.Name)
 	}()
+	w.Header().Add("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
+	w.Write([]byte(fmt.Sprintf(`{"event_log_id": "%v"}`, id.String())))
+}
+
+// userEnvActionsPinHandler pins the environment from the UI, exempting it from eviction for the requested duration (the maximum pin duration by default)
+func (api *v2api) userEnvActionsPinHandler(w http.ResponseWriter, r *http.Request) {
+	api.userEnvPinAction(w, r, true)
+}
+
+// userEnvActionsUnpinHandler unpins the environment from the UI
+func (api *v2api) userEnvActionsUnpinHandler(w http.ResponseWriter, r *http.Request) {
+	api.userEnvPinAction(w, r, false)
+}
+
+// userEnvPinAction checks that the session user has write permissions for the environment repo and pins or unpins the environment
+func (api *v2api) userEnvPinAction(w http.ResponseWriter, r *http.Request, pin bool) {
+	uis, err := getSessionFromContext(r.Context())
+	if err != nil {
+		api.rlogger(r).Logf("session missing from context")
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	envname := mux.Vars(r)["name"]
+	if envname == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	qae, err := api.dl.GetQAEnvironment(r.Context(), envname)
+	if err != nil {
+		api.rlogger(r).Logf("error getting qa env from db: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if qae == nil {
+		api.rlogger(r).Logf("qa env not found")
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	repos, err := userPermissionsClient(api.oauth, qae.Repo).GetUserWritableRepos(r.Context(), uis)
+	if err != nil {
+		api.rlogger(r).Logf("error getting user writable repos: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if _, ok := repos[qae.Repo]; !ok {
+		api.rlogger(r).Logf("user writable repo not found")
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	var d time.Duration
+	if pin {
+		if qae.Status == models.Destroyed {
+			api.badRequestError(w, fmt.Errorf("destroyed environments cannot be pinned"))
+			return
+		}
+		d = api.sc.MaxPinDuration
+		if ds := r.URL.Query().Get("duration"); ds != "" {
+			d, err = time.ParseDuration(ds)
+			if err != nil {
+				api.badRequestError(w, fmt.Errorf("invalid duration: %v", err))
+				return
+			}
+		}
+		if d <= 0 || d > api.sc.MaxPinDuration {
+			api.badRequestError(w, fmt.Errorf("pin duration must be greater than zero and no more than the maximum pin duration (%v)", api.sc.MaxPinDuration))
+			return
+		}
+	}
+	until, err := api.pinEnv(r.Context(), api.dl, qae, d, uis.GitHubUser)
+	if err != nil {
+		api.rlogger(r).Logf("error pinning env: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Add("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(struct {
+		PinnedUntil *time.Time `json:"pinned_until"`
+	}{PinnedUntil: until}); err != nil {
+		api.rlogger(r).Logf("error marshaling pin response: %v", err)
+	}
+}
+
+// userEnvActionsExtendHandler checks that the session user has write permissions for the environment repo and extends the environment lifetime
+// by the requested duration from now (the lifetime extension by default), up to the maximum lifetime
+func (api *v2api) userEnvActionsExtendHandler(w http.ResponseWriter, r *http.Request) {
+	uis, err := getSessionFromContext(r.Context())
+	if err != nil {
+		api.rlogger(r).Logf("session missing from context")
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	envname := mux.Vars(r)["name"]
+	if envname == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	qae, err := api.dl.GetQAEnvironment(r.Context(), envname)
+	if err != nil {
+		api.rlogger(r).Logf("error getting qa env from db: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if qae == nil {
+		api.rlogger(r).Logf("qa env not found")
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	repos, err := userPermissionsClient(api.oauth, qae.Repo).GetUserWritableRepos(r.Context(), uis)
+	if err != nil {
+		api.rlogger(r).Logf("error getting user writable repos: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if _, ok := repos[qae.Repo]; !ok {
+		api.rlogger(r).Logf("user writable repo not found")
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	if qae.Status == models.Destroyed {
+		api.badRequestError(w, fmt.Errorf("destroyed environments cannot be extended"))
+		return
+	}
+	lt := api.sc.EnvironmentLifetimes
+	d := lt.Extension
+	if ds := r.URL.Query().Get("duration"); ds != "" {
+		d, err = time.ParseDuration(ds)
+		if err != nil {
+			api.badRequestError(w, fmt.Errorf("invalid duration: %v", err))
+			return
+		}
+	}
+	if d <= 0 || (lt.Max > 0 && d > lt.Max) {
+		api.badRequestError(w, fmt.Errorf("extension must be greater than zero and no more than the maximum lifetime (%v)", lt.Max))
+		return
+	}
+	expires, err := api.extendEnv(r.Context(), api.dl, qae, d, lt.MaxTotal, uis.GitHubUser)
+	if err != nil {
+		if nitroerrors.IsUserError(err) {
+			api.badRequestError(w, err)
+			return
+		}
+		api.rlogger(r).Logf("error extending env: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Add("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(struct {
+		Expires *time.Time `json:"expires"`
+	}{Expires: expires}); err != nil {
+		api.rlogger(r).Logf("error marshaling extend response: %v", err)
+	}
 }
 
 type V2EnvNamePods struct {
@@ -878,7 +1661,6 @@ type V2EnvNamePods struct {
 
 // userEnvNamePodsHandler returns curated kubernetes pod data by the environment name
 func (api *v2api) userEnvNamePodsHandler(w http.ResponseWriter, r *http.Request) {
-	v2enp := []V2EnvNamePods{}
 	uis, err := getSessionFromContext(r.Context())
 	if err != nil {
 		api.rlogger(r).Logf("session missing from context")
@@ -911,6 +1693,12 @@ func (api *v2api) userEnvNamePodsHandler(w http.ResponseWriter, r *http.Request)
 		w.WriteHeader(http.StatusForbidden)
 		return
 	}
+	api.writeEnvPods(w, r, envname)
+}
+
+// writeEnvPods writes the curated kubernetes pod data for the environment envname
+func (api *v2api) writeEnvPods(w http.ResponseWriter, r *http.Request, envname string) {
+	v2enp := []V2EnvNamePods{}
 	k8senv, err := api.dl.GetK8sEnv(r.Context(), envname)
 	if err != nil {
 		api.rlogger(r).Logf("error getting k8s env from db: %v", err)
@@ -957,6 +1745,31 @@ func (api *v2api) userEnvNamePodsHandler(w http.ResponseWriter, r *http.Request)
 	}
 }
 
+// envPodsHandler returns curated kubernetes pod data for the environment authorized by API key
+func (api *v2api) envPodsHandler(w http.ResponseWriter, r *http.Request) {
+	qa, ok := r.Context().Value(qaEnvCtxKey).(models.QAEnvironment)
+	if !ok {
+		api.internalError(w, fmt.Errorf("unexpected qa env type from context: %T", qa))
+		return
+	}
+	api.writeEnvPods(w, r, qa.Name)
+}
+
+// envPodLogsHandler returns logs for the specified pod in the environment authorized by API key
+func (api *v2api) envPodLogsHandler(w http.ResponseWriter, r *http.Request) {
+	qa, ok := r.Context().Value(qaEnvCtxKey).(models.QAEnvironment)
+	if !ok {
+		api.internalError(w, fmt.Errorf("unexpected qa env type from context: %T", qa))
+		return
+	}
+	podname := mux.Vars(r)["pod"]
+	if podname == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	api.writeEnvPodLogs(w, r, qa.Name, podname)
+}
+
 type V2EnvNamePodContainers struct {
 	Name       string   `json:"name"`
 	Containers []string `json:"containers"`
@@ -1072,6 +1885,11 @@ func (api *v2api) userEnvPodLogsHandler(w http.ResponseWriter, r *http.Request)
 		w.WriteHeader(http.StatusForbidden)
 		return
 	}
+	api.writeEnvPodLogs(w, r, envname, podname)
+}
+
+// writeEnvPodLogs writes the logs for the pod podname in the environment envname
+func (api *v2api) writeEnvPodLogs(w http.ResponseWriter, r *http.Request, envname, podname string) {
 	k8senv, err := api.dl.GetK8sEnv(r.Context(), envname)
 	if err != nil {
 		api.rlogger(r).Logf("error getting k8s env from db: %v", err)
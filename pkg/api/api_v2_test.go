@@ -6,11 +6,13 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"strings"
 	"testing"
 	"time"
 
@@ -24,6 +26,7 @@ import (
 	"github.com/dollarshaveclub/acyl/pkg/config"
 	"github.com/dollarshaveclub/acyl/pkg/ghclient"
 	"github.com/dollarshaveclub/acyl/pkg/models"
+	"github.com/dollarshaveclub/acyl/pkg/persistence"
 	"github.com/dollarshaveclub/acyl/pkg/testhelper/testdatalayer"
 )
 
@@ -35,7 +38,7 @@ func TestAPIv2SearchByTrackingRef(t *testing.T) {
 	defer tdl.TearDown()
 
 	sc := config.ServerConfig{APIKeys: []string{"foo", "bar", "baz"}}
-	apiv2, err := newV2API(dl, nil, nil, nil, sc, OAuthConfig{}, testlogger, nil)
+	apiv2, err := newV2API(dl, nil, nil, nil, nil, sc, OAuthConfig{}, testlogger, nil)
 	if err != nil {
 		t.Fatalf("error creating api: %v", err)
 	}
@@ -79,7 +82,7 @@ func TestAPIv2SearchByTrackingRefUserAPIKey(t *testing.T) {
 	defer tdl.TearDown()
 
 	sc := config.ServerConfig{APIKeys: []string{"foo", "bar", "baz"}}
-	apiv2, err := newV2API(dl, nil, nil, nil, sc, OAuthConfig{}, testlogger, nil)
+	apiv2, err := newV2API(dl, nil, nil, nil, nil, sc, OAuthConfig{}, testlogger, nil)
 	if err != nil {
 		t.Fatalf("error creating api: %v", err)
 	}
@@ -129,7 +132,7 @@ func TestAPIv2SearchByTrackingRefUserAPIKeyEmpty(t *testing.T) {
 	defer tdl.TearDown()
 
 	sc := config.ServerConfig{APIKeys: []string{"foo", "bar", "baz"}}
-	apiv2, err := newV2API(dl, nil, nil, nil, sc, OAuthConfig{}, testlogger, nil)
+	apiv2, err := newV2API(dl, nil, nil, nil, nil, sc, OAuthConfig{}, testlogger, nil)
 	if err != nil {
 		t.Fatalf("error creating api: %v", err)
 	}
@@ -175,7 +178,7 @@ func TestAPIv2EnvDetails(t *testing.T) {
 	defer tdl.TearDown()
 
 	sc := config.ServerConfig{APIKeys: []string{"foo", "bar", "baz"}}
-	apiv2, err := newV2API(dl, nil, nil, nil, sc, OAuthConfig{}, testlogger, nil)
+	apiv2, err := newV2API(dl, nil, nil, nil, nil, sc, OAuthConfig{}, testlogger, nil)
 	if err != nil {
 		t.Fatalf("error creating api: %v", err)
 	}
@@ -217,7 +220,7 @@ func TestAPIv2EnvDetailsUserAPIKey(t *testing.T) {
 	defer tdl.TearDown()
 
 	sc := config.ServerConfig{APIKeys: []string{"foo", "bar", "baz"}}
-	apiv2, err := newV2API(dl, nil, nil, nil, sc, OAuthConfig{}, testlogger, nil)
+	apiv2, err := newV2API(dl, nil, nil, nil, nil, sc, OAuthConfig{}, testlogger, nil)
 	if err != nil {
 		t.Fatalf("error creating api: %v", err)
 	}
@@ -263,7 +266,7 @@ func TestAPIv2EnvDetailsUserAPIKeyForbidden(t *testing.T) {
 	defer tdl.TearDown()
 
 	sc := config.ServerConfig{APIKeys: []string{"foo", "bar", "baz"}}
-	apiv2, err := newV2API(dl, nil, nil, nil, sc, OAuthConfig{}, testlogger, nil)
+	apiv2, err := newV2API(dl, nil, nil, nil, nil, sc, OAuthConfig{}, testlogger, nil)
 	if err != nil {
 		t.Fatalf("error creating api: %v", err)
 	}
@@ -301,7 +304,7 @@ func TestAPIv2EnvDetailsUserAPIKeyUnauthorized(t *testing.T) {
 	defer tdl.TearDown()
 
 	sc := config.ServerConfig{APIKeys: []string{"foo", "bar", "baz"}}
-	apiv2, err := newV2API(dl, nil, nil, nil, sc, OAuthConfig{}, testlogger, nil)
+	apiv2, err := newV2API(dl, nil, nil, nil, nil, sc, OAuthConfig{}, testlogger, nil)
 	if err != nil {
 		t.Fatalf("error creating api: %v", err)
 	}
@@ -338,7 +341,7 @@ func TestAPIv2HealthCheck(t *testing.T) {
 	}
 	defer tdl.TearDown()
 
-	apiv2, err := newV2API(dl, nil, nil, nil, config.ServerConfig{APIKeys: []string{"foo"}}, OAuthConfig{}, testlogger, nil)
+	apiv2, err := newV2API(dl, nil, nil, nil, nil, config.ServerConfig{APIKeys: []string{"foo"}}, OAuthConfig{}, testlogger, nil)
 	if err != nil {
 		t.Fatalf("error creating api: %v", err)
 	}
@@ -383,7 +386,7 @@ func TestAPIv2EventLog(t *testing.T) {
 	defer tdl.TearDown()
 
 	sc := config.ServerConfig{APIKeys: []string{"foo", "bar", "baz"}}
-	apiv2, err := newV2API(dl, nil, nil, nil, sc, OAuthConfig{}, testlogger, nil)
+	apiv2, err := newV2API(dl, nil, nil, nil, nil, sc, OAuthConfig{}, testlogger, nil)
 	if err != nil {
 		t.Fatalf("error creating api: %v", err)
 	}
@@ -446,7 +449,7 @@ func TestAPIv2EventStatus(t *testing.T) {
 	}
 	defer tdl.TearDown()
 
-	apiv2, err := newV2API(dl, nil, nil, nil, config.ServerConfig{APIKeys: []string{"foo"}}, OAuthConfig{}, testlogger, nil)
+	apiv2, err := newV2API(dl, nil, nil, nil, nil, config.ServerConfig{APIKeys: []string{"foo"}}, OAuthConfig{}, testlogger, nil)
 	if err != nil {
 		t.Fatalf("error creating api: %v", err)
 	}
@@ -518,7 +521,7 @@ func TestAPIv2UserEnvs(t *testing.T) {
 	}
 	defer tdl.TearDown()
 
-	apiv2, err := newV2API(dl, nil, nil, nil, config.ServerConfig{APIKeys: []string{"foo"}}, OAuthConfig{Enforce: true}, testlogger, nil)
+	apiv2, err := newV2API(dl, nil, nil, nil, nil, config.ServerConfig{APIKeys: []string{"foo"}}, OAuthConfig{Enforce: true}, testlogger, nil)
 	if err != nil {
 		t.Fatalf("error creating api: %v", err)
 	}
@@ -575,7 +578,7 @@ func TestAPIv2UserEnvDetail(t *testing.T) {
 		},
 	}
 	copy(oauthcfg.UserTokenEncKey[:], []byte("00000000000000000000000000000000"))
-	apiv2, err := newV2API(dl, nil, nil, nil, config.ServerConfig{APIKeys: []string{"foo"}}, oauthcfg, logger, nil)
+	apiv2, err := newV2API(dl, nil, nil, nil, nil, config.ServerConfig{APIKeys: []string{"foo"}}, oauthcfg, logger, nil)
 	if err != nil {
 		t.Fatalf("error creating api: %v", err)
 	}
@@ -648,7 +651,7 @@ func TestAPIv2UserEnvActionsRebuild(t *testing.T) {
 	uf := func(ctx context.Context, rd models.RepoRevisionData) (string, error) {
 		return "updated environment", nil
 	}
-	apiv2, err := newV2API(dl, nil, &spawner.FakeEnvironmentSpawner{UpdateFunc: uf}, nil, config.ServerConfig{APIKeys: []string{"foo"}}, oauthcfg, logger, nil)
+	apiv2, err := newV2API(dl, nil, &spawner.FakeEnvironmentSpawner{UpdateFunc: uf}, nil, nil, config.ServerConfig{APIKeys: []string{"foo"}}, oauthcfg, logger, nil)
 	if err != nil {
 		t.Fatalf("error creating api: %v", err)
 	}
@@ -670,6 +673,453 @@ func TestAPIv2UserEnvActionsRebuild(t *testing.T) {
 	}
 }
 
+func TestAPIv2UserEnvActionsSuspendResume(t *testing.T) {
+	dl, tdl := testdatalayer.New(testlogger, t)
+	if err := tdl.Setup(testDataPath); err != nil {
+		t.Fatalf("error setting up test database: %v", err)
+	}
+	defer tdl.TearDown()
+
+	logger := log.New(os.Stdout, "", log.LstdFlags)
+	oauthcfg := OAuthConfig{
+		AppGHClientFactoryFunc: func(_ string) ghclient.GitHubAppInstallationClient {
+			return &ghclient.FakeRepoClient{
+				GetUserAppRepoPermissionsFunc: func(_ context.Context, _ int64) (map[string]ghclient.AppRepoPermissions, error) {
+					return map[string]ghclient.AppRepoPermissions{
+						"dollarshaveclub/foo-bar": ghclient.AppRepoPermissions{
+							Repo: "dollarshaveclub/foo-bar",
+							Pull: true,
+							Push: true,
+						},
+					}, nil
+				},
+			}
+		},
+	}
+	copy(oauthcfg.UserTokenEncKey[:], []byte("00000000000000000000000000000000"))
+	sf := func(ctx context.Context, rd models.RepoRevisionData) error {
+		return nil
+	}
+	apiv2, err := newV2API(dl, nil, &spawner.FakeEnvironmentSpawner{SuspendFunc: sf, ResumeFunc: sf}, nil, nil, config.ServerConfig{APIKeys: []string{"foo"}}, oauthcfg, logger, nil)
+	if err != nil {
+		t.Fatalf("error creating api: %v", err)
+	}
+
+	uis := models.UISession{
+		Authenticated: true,
+		GitHubUser:    "bobsmith",
+	}
+	uis.EncryptandSetUserToken([]byte("foo"), oauthcfg.UserTokenEncKey)
+
+	// foo-bar is not suspended, so resume should be rejected
+	req, _ := http.NewRequest("POST", "https://foo.com/v2/userenvs/foo-bar/actions/resume", nil)
+	req = mux.SetURLVars(req, map[string]string{"name": "foo-bar"})
+	req = req.Clone(withSession(req.Context(), uis))
+	rc := httptest.NewRecorder()
+	apiv2.userEnvActionsResumeHandler(rc, req)
+	if res := rc.Result(); res.StatusCode != http.StatusBadRequest {
+		t.Fatalf("resume: bad status code: %v", res.StatusCode)
+	}
+
+	req, _ = http.NewRequest("POST", "https://foo.com/v2/userenvs/foo-bar/actions/suspend", nil)
+	req = mux.SetURLVars(req, map[string]string{"name": "foo-bar"})
+	req = req.Clone(withSession(req.Context(), uis))
+	rc = httptest.NewRecorder()
+	apiv2.userEnvActionsSuspendHandler(rc, req)
+	if res := rc.Result(); res.StatusCode != http.StatusCreated {
+		t.Fatalf("suspend: bad status code: %v", res.StatusCode)
+	}
+}
+
+func TestAPIv2UserEnvActionsPin(t *testing.T) {
+	dl := persistence.NewFakeDataLayer()
+	dl.CreateQAEnvironment(context.Background(), &models.QAEnvironment{Name: "foo-bar", Repo: "dollarshaveclub/foo-bar", PullRequest: 1, Status: models.Success})
+	dl.CreateQAEnvironment(context.Background(), &models.QAEnvironment{Name: "foo-bar-old", Repo: "dollarshaveclub/foo-bar", PullRequest: 2, Status: models.Destroyed})
+
+	logger := log.New(os.Stdout, "", log.LstdFlags)
+	oauthcfg := OAuthConfig{
+		AppGHClientFactoryFunc: func(_ string) ghclient.GitHubAppInstallationClient {
+			return &ghclient.FakeRepoClient{
+				GetUserAppRepoPermissionsFunc: func(_ context.Context, _ int64) (map[string]ghclient.AppRepoPermissions, error) {
+					return map[string]ghclient.AppRepoPermissions{
+						"dollarshaveclub/foo-bar": ghclient.AppRepoPermissions{
+							Repo: "dollarshaveclub/foo-bar",
+							Pull: true,
+							Push: true,
+						},
+					}, nil
+				},
+			}
+		},
+	}
+	copy(oauthcfg.UserTokenEncKey[:], []byte("00000000000000000000000000000000"))
+	apiv2, err := newV2API(dl, nil, nil, nil, nil, config.ServerConfig{APIKeys: []string{"foo"}, MaxPinDuration: 48 * time.Hour}, oauthcfg, logger, nil)
+	if err != nil {
+		t.Fatalf("error creating api: %v", err)
+	}
+
+	uis := models.UISession{
+		Authenticated: true,
+		GitHubUser:    "bobsmith",
+	}
+	uis.EncryptandSetUserToken([]byte("foo"), oauthcfg.UserTokenEncKey)
+	do := func(action, name, query string) *http.Response {
+		req, _ := http.NewRequest("POST", "https://foo.com/v2/userenvs/"+name+"/actions/"+action+query, nil)
+		req = mux.SetURLVars(req, map[string]string{"name": name})
+		req = req.Clone(withSession(req.Context(), uis))
+		rc := httptest.NewRecorder()
+		if action == "pin" {
+			apiv2.userEnvActionsPinHandler(rc, req)
+		} else {
+			apiv2.userEnvActionsUnpinHandler(rc, req)
+		}
+		return rc.Result()
+	}
+
+	if res := do("pin", "foo-bar", "?duration=72h"); res.StatusCode != http.StatusBadRequest {
+		t.Fatalf("pin beyond the maximum duration: bad status code: %v", res.StatusCode)
+	}
+	if res := do("pin", "foo-bar-old", ""); res.StatusCode != http.StatusBadRequest {
+		t.Fatalf("pin of destroyed env: bad status code: %v", res.StatusCode)
+	}
+	res := do("pin", "foo-bar", "?duration=24h")
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("pin: bad status code: %v", res.StatusCode)
+	}
+	out := struct {
+		PinnedUntil *time.Time `json:"pinned_until"`
+	}{}
+	if err := json.NewDecoder(res.Body).Decode(&out); err != nil {
+		t.Fatalf("error decoding response: %v", err)
+	}
+	if out.PinnedUntil == nil || out.PinnedUntil.Before(time.Now().Add(23*time.Hour)) {
+		t.Fatalf("bad pinned until: %v", out.PinnedUntil)
+	}
+	qae, _ := dl.GetQAEnvironment(context.Background(), "foo-bar")
+	if !qae.Pinned(time.Now()) {
+		t.Fatalf("env should have been pinned")
+	}
+	if res := do("unpin", "foo-bar", ""); res.StatusCode != http.StatusOK {
+		t.Fatalf("unpin: bad status code: %v", res.StatusCode)
+	}
+	qae, _ = dl.GetQAEnvironment(context.Background(), "foo-bar")
+	if qae.Pinned(time.Now()) {
+		t.Fatalf("env should have been unpinned")
+	}
+}
+
+func TestAPIv2UserEnvActionsExtend(t *testing.T) {
+	dl := persistence.NewFakeDataLayer()
+	expires := time.Now().UTC().Add(time.Hour)
+	dl.CreateQAEnvironment(context.Background(), &models.QAEnvironment{Name: "foo-bar", Repo: "dollarshaveclub/foo-bar", PullRequest: 1, Status: models.Success, Expires: &expires})
+	dl.CreateQAEnvironment(context.Background(), &models.QAEnvironment{Name: "foo-bar-forever", Repo: "dollarshaveclub/foo-bar", PullRequest: 2, Status: models.Success})
+	dl.CreateQAEnvironment(context.Background(), &models.QAEnvironment{Name: "foo-bar-old", Repo: "dollarshaveclub/foo-bar", PullRequest: 3, Status: models.Destroyed, Expires: &expires})
+
+	logger := log.New(os.Stdout, "", log.LstdFlags)
+	oauthcfg := OAuthConfig{
+		AppGHClientFactoryFunc: func(_ string) ghclient.GitHubAppInstallationClient {
+			return &ghclient.FakeRepoClient{
+				GetUserAppRepoPermissionsFunc: func(_ context.Context, _ int64) (map[string]ghclient.AppRepoPermissions, error) {
+					return map[string]ghclient.AppRepoPermissions{
+						"dollarshaveclub/foo-bar": ghclient.AppRepoPermissions{
+							Repo: "dollarshaveclub/foo-bar",
+							Pull: true,
+							Push: true,
+						},
+					}, nil
+				},
+			}
+		},
+	}
+	copy(oauthcfg.UserTokenEncKey[:], []byte("00000000000000000000000000000000"))
+	sc := config.ServerConfig{APIKeys: []string{"foo"}, EnvironmentLifetimes: config.EnvironmentLifetimes{Extension: 24 * time.Hour, Max: 72 * time.Hour}}
+	apiv2, err := newV2API(dl, nil, nil, nil, nil, sc, oauthcfg, logger, nil)
+	if err != nil {
+		t.Fatalf("error creating api: %v", err)
+	}
+
+	uis := models.UISession{
+		Authenticated: true,
+		GitHubUser:    "bobsmith",
+	}
+	uis.EncryptandSetUserToken([]byte("foo"), oauthcfg.UserTokenEncKey)
+	do := func(name, query string) *http.Response {
+		req, _ := http.NewRequest("POST", "https://foo.com/v2/userenvs/"+name+"/actions/extend"+query, nil)
+		req = mux.SetURLVars(req, map[string]string{"name": name})
+		req = req.Clone(withSession(req.Context(), uis))
+		rc := httptest.NewRecorder()
+		apiv2.userEnvActionsExtendHandler(rc, req)
+		return rc.Result()
+	}
+
+	if res := do("foo-bar", "?duration=96h"); res.StatusCode != http.StatusBadRequest {
+		t.Fatalf("extend beyond the maximum lifetime: bad status code: %v", res.StatusCode)
+	}
+	if res := do("foo-bar-old", ""); res.StatusCode != http.StatusBadRequest {
+		t.Fatalf("extend of destroyed env: bad status code: %v", res.StatusCode)
+	}
+	if res := do("foo-bar-forever", ""); res.StatusCode != http.StatusBadRequest {
+		t.Fatalf("extend of env without expiry: bad status code: %v", res.StatusCode)
+	}
+	res := do("foo-bar", "")
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("extend: bad status code: %v", res.StatusCode)
+	}
+	out := struct {
+		Expires *time.Time `json:"expires"`
+	}{}
+	if err := json.NewDecoder(res.Body).Decode(&out); err != nil {
+		t.Fatalf("error decoding response: %v", err)
+	}
+	if out.Expires == nil || out.Expires.Before(time.Now().Add(23*time.Hour)) {
+		t.Fatalf("bad expires: %v", out.Expires)
+	}
+	// a shorter extension never moves the expiry earlier
+	if res := do("foo-bar", "?duration=2h"); res.StatusCode != http.StatusOK {
+		t.Fatalf("short extend: bad status code: %v", res.StatusCode)
+	}
+	qae, _ := dl.GetQAEnvironment(context.Background(), "foo-bar")
+	if qae.Expires == nil || !qae.Expires.Equal(*out.Expires) {
+		t.Fatalf("expiry should not have changed: %v (wanted %v)", qae.Expires, out.Expires)
+	}
+}
+
+func TestAPIv2EnvCreate(t *testing.T) {
+	dl := persistence.NewFakeDataLayer()
+	var created []models.RepoRevisionData
+	es := &spawner.FakeEnvironmentSpawner{
+		CreateFunc: func(ctx context.Context, rd models.RepoRevisionData) (string, error) {
+			created = append(created, rd)
+			return "foo-bar", nil
+		},
+	}
+	rc := &ghclient.FakeRepoClient{
+		GetRepoPermissionFunc: func(ctx context.Context, repo, user string) (string, error) {
+			if user == "bobsmith" {
+				return "write", nil
+			}
+			return "read", nil
+		},
+		GetPRFunc: func(ctx context.Context, repo string, pr uint) (ghclient.PRInfo, error) {
+			if pr == 2 {
+				return ghclient.PRInfo{Number: pr, State: "closed"}, nil
+			}
+			return ghclient.PRInfo{Number: pr, User: "alice", State: "open", BaseBranch: "master", BaseSHA: "aaaa", HeadBranch: "feature", HeadSHA: "bbbb"}, nil
+		},
+		GetBranchFunc: func(ctx context.Context, repo, branch string) (ghclient.BranchInfo, error) {
+			return ghclient.BranchInfo{Name: branch, SHA: "dddd"}, nil
+		},
+		ResolveRefFunc: func(ctx context.Context, repo, ref string) (ghclient.RefInfo, error) {
+			if ref == "v1.0.0" {
+				return ghclient.RefInfo{Kind: ghclient.TagRef, SHA: "eeee", DefaultBranch: "master"}, nil
+			}
+			return ghclient.RefInfo{Kind: ghclient.BranchRef, SHA: "cccc", DefaultBranch: "master"}, nil
+		},
+	}
+	apiv2, err := newV2API(dl, nil, es, nil, rc, config.ServerConfig{APIKeys: []string{"foo"}}, OAuthConfig{}, testlogger, nil)
+	if err != nil {
+		t.Fatalf("error creating api: %v", err)
+	}
+	do := func(user, body string) *http.Response {
+		req, _ := http.NewRequest("POST", "https://foo.com/v2/envs", strings.NewReader(body))
+		req = req.Clone(context.WithValue(req.Context(), apiKeyCtxKey, models.APIKey{PermissionLevel: models.WritePermission, GitHubUser: user}))
+		rc := httptest.NewRecorder()
+		apiv2.envCreateHandler(rc, req)
+		apiv2.wg.Wait()
+		return rc.Result()
+	}
+
+	if res := do("bobsmith", `{"repo":"foo/bar"}`); res.StatusCode != http.StatusBadRequest {
+		t.Fatalf("missing PR and ref: bad status code: %v", res.StatusCode)
+	}
+	if res := do("bobsmith", `{"repo":"foo/bar","pull_request":1,"ref":"master"}`); res.StatusCode != http.StatusBadRequest {
+		t.Fatalf("both PR and ref: bad status code: %v", res.StatusCode)
+	}
+	if res := do("johndoe", `{"repo":"foo/bar","pull_request":1}`); res.StatusCode != http.StatusForbidden {
+		t.Fatalf("read-only user: bad status code: %v", res.StatusCode)
+	}
+	if res := do("bobsmith", `{"repo":"foo/bar","pull_request":2}`); res.StatusCode != http.StatusBadRequest {
+		t.Fatalf("closed PR: bad status code: %v", res.StatusCode)
+	}
+	res := do("bobsmith", `{"repo":"foo/bar","pull_request":1}`)
+	if res.StatusCode != http.StatusAccepted {
+		t.Fatalf("create from PR: bad status code: %v", res.StatusCode)
+	}
+	out := v2EventLogIDResponse{}
+	if err := json.NewDecoder(res.Body).Decode(&out); err != nil {
+		t.Fatalf("error decoding response: %v", err)
+	}
+	if _, err := uuid.Parse(out.EventLogID); err != nil {
+		t.Fatalf("bad event log id: %v: %v", out.EventLogID, err)
+	}
+	if res := do("bobsmith", `{"repo":"foo/bar","ref":"release"}`); res.StatusCode != http.StatusAccepted {
+		t.Fatalf("create from ref: bad status code: %v", res.StatusCode)
+	}
+	if res := do("bobsmith", `{"repo":"foo/bar","ref":"v1.0.0"}`); res.StatusCode != http.StatusAccepted {
+		t.Fatalf("create from tag: bad status code: %v", res.StatusCode)
+	}
+	if len(created) != 3 {
+		t.Fatalf("expected three creates: %v", len(created))
+	}
+	if rd := created[0]; rd.PullRequest != 1 || rd.SourceSHA != "bbbb" || rd.User != "alice" {
+		t.Fatalf("bad PR revision data: %+v", rd)
+	}
+	if rd := created[1]; rd.PullRequest != 0 || rd.SourceBranch != "release" || rd.SourceSHA != "cccc" || rd.BaseBranch != "master" || rd.BaseSHA != "dddd" || rd.User != "bobsmith" {
+		t.Fatalf("bad ref revision data: %+v", rd)
+	}
+	if rd := created[2]; rd.PullRequest != 0 || rd.SourceRef != "v1.0.0" || rd.SourceSHA != "eeee" || rd.BaseBranch != "master" {
+		t.Fatalf("bad tag revision data: %+v", rd)
+	}
+}
+
+func TestAPIv2EnvWriteActions(t *testing.T) {
+	dl := persistence.NewFakeDataLayer()
+	dl.CreateQAEnvironment(context.Background(), &models.QAEnvironment{Name: "foo-bar", Repo: "foo/bar", PullRequest: 1, SourceSHA: "aaaa", User: "alice", Status: models.Success})
+	dl.CreateQAEnvironment(context.Background(), &models.QAEnvironment{Name: "foo-bar-old", Repo: "foo/bar", PullRequest: 2, Status: models.Destroyed})
+	dl.CreateK8sEnv(context.Background(), &models.KubernetesEnvironment{EnvName: "foo-bar", Namespace: "nitro-foo-bar"})
+	var updated []models.RepoRevisionData
+	var destroyed []string
+	es := &spawner.FakeEnvironmentSpawner{
+		UpdateFunc: func(ctx context.Context, rd models.RepoRevisionData) (string, error) {
+			updated = append(updated, rd)
+			return "foo-bar", nil
+		},
+		DestroyExplicitlyFunc: func(ctx context.Context, env *models.QAEnvironment, reason models.QADestroyReason) error {
+			destroyed = append(destroyed, env.Name)
+			return nil
+		},
+	}
+	rc := &ghclient.FakeRepoClient{
+		GetRepoPermissionFunc: func(ctx context.Context, repo, user string) (string, error) {
+			if user == "bobsmith" {
+				return "admin", nil
+			}
+			return "none", nil
+		},
+		GetCommitMessageFunc: func(ctx context.Context, repo, sha string) (string, error) {
+			if sha != "bbbb" {
+				return "", fmt.Errorf("commit not found")
+			}
+			return "", nil
+		},
+	}
+	apiv2, err := newV2API(dl, nil, es, nil, rc, config.ServerConfig{APIKeys: []string{"foo"}}, OAuthConfig{}, testlogger, nil)
+	if err != nil {
+		t.Fatalf("error creating api: %v", err)
+	}
+	do := func(f http.HandlerFunc, name, query, user string) *http.Response {
+		req, _ := http.NewRequest("POST", "https://foo.com/v2/envs/"+name+query, nil)
+		req = mux.SetURLVars(req, map[string]string{"name": name})
+		req = req.Clone(context.WithValue(req.Context(), apiKeyCtxKey, models.APIKey{PermissionLevel: models.WritePermission, GitHubUser: user}))
+		rc := httptest.NewRecorder()
+		f(rc, req)
+		apiv2.wg.Wait()
+		return rc.Result()
+	}
+
+	// writes are authorized by repo permissions, not environment ownership
+	if res := do(apiv2.envActionsRebuildHandler, "foo-bar", "", "alice"); res.StatusCode != http.StatusForbidden {
+		t.Fatalf("rebuild without write access: bad status code: %v", res.StatusCode)
+	}
+	if res := do(apiv2.envActionsRebuildHandler, "foo-bar-old", "", "bobsmith"); res.StatusCode != http.StatusBadRequest {
+		t.Fatalf("rebuild of destroyed env: bad status code: %v", res.StatusCode)
+	}
+	if res := do(apiv2.envActionsRebuildHandler, "missing", "", "bobsmith"); res.StatusCode != http.StatusNotFound {
+		t.Fatalf("rebuild of missing env: bad status code: %v", res.StatusCode)
+	}
+	if res := do(apiv2.envActionsRebuildHandler, "foo-bar", "?full=true", "bobsmith"); res.StatusCode != http.StatusAccepted {
+		t.Fatalf("rebuild: bad status code: %v", res.StatusCode)
+	}
+	if res := do(apiv2.envActionsUpdateHandler, "foo-bar", "", "bobsmith"); res.StatusCode != http.StatusBadRequest {
+		t.Fatalf("update without sha: bad status code: %v", res.StatusCode)
+	}
+	if res := do(apiv2.envActionsUpdateHandler, "foo-bar", "?sha=zzzz", "bobsmith"); res.StatusCode != http.StatusBadRequest {
+		t.Fatalf("update to unknown sha: bad status code: %v", res.StatusCode)
+	}
+	if res := do(apiv2.envActionsUpdateHandler, "foo-bar", "?sha=bbbb", "bobsmith"); res.StatusCode != http.StatusAccepted {
+		t.Fatalf("update: bad status code: %v", res.StatusCode)
+	}
+	if res := do(apiv2.envDestroyHandler, "foo-bar", "", "bobsmith"); res.StatusCode != http.StatusAccepted {
+		t.Fatalf("destroy: bad status code: %v", res.StatusCode)
+	}
+	if len(updated) != 2 || updated[0].SourceSHA != "aaaa" || updated[1].SourceSHA != "bbbb" {
+		t.Fatalf("bad updates: %+v", updated)
+	}
+	if len(destroyed) != 1 || destroyed[0] != "foo-bar" {
+		t.Fatalf("bad destroys: %v", destroyed)
+	}
+}
+
+func TestAPIv2EnvReadActions(t *testing.T) {
+	dl := persistence.NewFakeDataLayer()
+	qae := models.QAEnvironment{Name: "foo-bar", Repo: "foo/bar", PullRequest: 1, User: "alice", Status: models.Success}
+	dl.CreateQAEnvironment(context.Background(), &qae)
+	dl.CreateK8sEnv(context.Background(), &models.KubernetesEnvironment{EnvName: "foo-bar", Namespace: "nitro-foo-bar"})
+	id := uuid.Must(uuid.NewRandom())
+	el := models.EventLog{ID: id, EnvName: "foo-bar", Repo: "foo/bar", PullRequest: 1}
+	dl.CreateEventLog(&el)
+	dl.SetEventStatus(id, models.EventStatusSummary{Config: models.EventStatusSummaryConfig{Type: models.CreateEvent, Status: models.DoneStatus, EnvName: "foo-bar"}})
+	apiv2, err := newV2API(dl, nil, nil, nil, nil, config.ServerConfig{APIKeys: []string{"foo"}}, OAuthConfig{}, testlogger, metahelm.FakeKubernetesReporter{FakePodLogFilePath: "../nitro/metahelm/testdata/pod_logs.log"})
+	if err != nil {
+		t.Fatalf("error creating api: %v", err)
+	}
+	do := func(f http.HandlerFunc, vars map[string]string, ctxkey interface{}, ctxval interface{}) *http.Response {
+		req, _ := http.NewRequest("GET", "https://foo.com/", nil)
+		req = mux.SetURLVars(req, vars)
+		req = req.Clone(context.WithValue(req.Context(), ctxkey, ctxval))
+		rc := httptest.NewRecorder()
+		f(rc, req)
+		return rc.Result()
+	}
+
+	res := do(apiv2.envPodsHandler, map[string]string{"name": "foo-bar"}, qaEnvCtxKey, qae)
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("pods: bad status code: %v", res.StatusCode)
+	}
+	pods := []V2EnvNamePods{}
+	if err := json.NewDecoder(res.Body).Decode(&pods); err != nil {
+		t.Fatalf("error decoding pods: %v", err)
+	}
+	if len(pods) != 2 {
+		t.Fatalf("expected 2 pods, got %v", len(pods))
+	}
+
+	if res := do(apiv2.envPodLogsHandler, map[string]string{"name": "foo-bar"}, qaEnvCtxKey, qae); res.StatusCode != http.StatusBadRequest {
+		t.Fatalf("pod logs without pod: bad status code: %v", res.StatusCode)
+	}
+	res = do(apiv2.envPodLogsHandler, map[string]string{"name": "foo-bar", "pod": "foo-app-abc123"}, qaEnvCtxKey, qae)
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("pod logs: bad status code: %v", res.StatusCode)
+	}
+	if b, _ := ioutil.ReadAll(res.Body); len(b) == 0 {
+		t.Fatalf("expected pod logs")
+	}
+
+	res = do(apiv2.envEventsHandler, map[string]string{"name": "foo-bar"}, qaEnvCtxKey, qae)
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("events: bad status code: %v", res.StatusCode)
+	}
+	events := []V2EventSummary{}
+	if err := json.NewDecoder(res.Body).Decode(&events); err != nil {
+		t.Fatalf("error decoding events: %v", err)
+	}
+	if len(events) != 1 || events[0].EventID != id.String() || events[0].Status != "done" {
+		t.Fatalf("bad events: %+v", events)
+	}
+
+	res = do(apiv2.eventLogStatusHandler, map[string]string{"id": id.String()}, eventLogCtxKey, el)
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("event status: bad status code: %v", res.StatusCode)
+	}
+	summary := V2EventStatusSummary{}
+	if err := json.NewDecoder(res.Body).Decode(&summary); err != nil {
+		t.Fatalf("error decoding event status: %v", err)
+	}
+	if summary.Config.Status != "done" || summary.Config.EnvName != "foo-bar" {
+		t.Fatalf("bad event status: %+v", summary.Config)
+	}
+}
+
 func TestAPIv2UserEnvNamePods(t *testing.T) {
 	dl, tdl := testdatalayer.New(testlogger, t)
 	if err := tdl.Setup(testDataPath); err != nil {
@@ -704,7 +1154,7 @@ func TestAPIv2UserEnvNamePods(t *testing.T) {
 		},
 	}
 	copy(oauthcfg.UserTokenEncKey[:], []byte("00000000000000000000000000000000"))
-	apiv2, err := newV2API(dl, nil, nil, nil, config.ServerConfig{APIKeys: []string{"foo"}}, oauthcfg, logger, metahelm.FakeKubernetesReporter{})
+	apiv2, err := newV2API(dl, nil, nil, nil, nil, config.ServerConfig{APIKeys: []string{"foo"}}, oauthcfg, logger, metahelm.FakeKubernetesReporter{})
 	if err != nil {
 		t.Fatalf("error creating api: %v", err)
 	}
@@ -775,7 +1225,7 @@ func TestAPIv2UserEnvNamePodContainers(t *testing.T) {
 		},
 	}
 	copy(oauthcfg.UserTokenEncKey[:], []byte("00000000000000000000000000000000"))
-	apiv2, err := newV2API(dl, nil, nil, nil, config.ServerConfig{APIKeys: []string{"foo"}}, oauthcfg, logger, metahelm.FakeKubernetesReporter{})
+	apiv2, err := newV2API(dl, nil, nil, nil, nil, config.ServerConfig{APIKeys: []string{"foo"}}, oauthcfg, logger, metahelm.FakeKubernetesReporter{})
 	if err != nil {
 		t.Fatalf("error creating api: %v", err)
 	}
@@ -857,7 +1307,7 @@ func TestAPIv2UserEnvNamePodLogs(t *testing.T) {
 		},
 	}
 	copy(oauthcfg.UserTokenEncKey[:], []byte("00000000000000000000000000000000"))
-	apiv2, err := newV2API(dl, nil, nil, nil, config.ServerConfig{APIKeys: []string{"foo"}}, oauthcfg, logger, metahelm.FakeKubernetesReporter{FakePodLogFilePath: "../nitro/metahelm/testdata/pod_logs.log"})
+	apiv2, err := newV2API(dl, nil, nil, nil, nil, config.ServerConfig{APIKeys: []string{"foo"}}, oauthcfg, logger, metahelm.FakeKubernetesReporter{FakePodLogFilePath: "../nitro/metahelm/testdata/pod_logs.log"})
 	if err != nil {
 		t.Fatalf("error creating api: %v", err)
 	}
@@ -940,7 +1390,7 @@ func TestAPIv2UserTokenCreate(t *testing.T) {
 		},
 	}
 	copy(oauthcfg.UserTokenEncKey[:], []byte("00000000000000000000000000000000"))
-	apiv2, err := newV2API(dl, nil, nil, nil, config.ServerConfig{APIKeys: []string{"foo"}}, oauthcfg, logger, metahelm.FakeKubernetesReporter{})
+	apiv2, err := newV2API(dl, nil, nil, nil, nil, config.ServerConfig{APIKeys: []string{"foo"}}, oauthcfg, logger, metahelm.FakeKubernetesReporter{})
 	if err != nil {
 		t.Fatalf("error creating api: %v", err)
 	}
@@ -1017,7 +1467,7 @@ func TestAPIv2UserTokenCreateAdminDenied(t *testing.T) {
 		},
 	}
 	copy(oauthcfg.UserTokenEncKey[:], []byte("00000000000000000000000000000000"))
-	apiv2, err := newV2API(dl, nil, nil, nil, config.ServerConfig{APIKeys: []string{"foo"}}, oauthcfg, logger, metahelm.FakeKubernetesReporter{})
+	apiv2, err := newV2API(dl, nil, nil, nil, nil, config.ServerConfig{APIKeys: []string{"foo"}}, oauthcfg, logger, metahelm.FakeKubernetesReporter{})
 	if err != nil {
 		t.Fatalf("error creating api: %v", err)
 	}
@@ -1067,7 +1517,7 @@ func TestAPIv2UserTokenCreateLimit(t *testing.T) {
 		},
 	}
 	copy(oauthcfg.UserTokenEncKey[:], []byte("00000000000000000000000000000000"))
-	apiv2, err := newV2API(dl, nil, nil, nil, config.ServerConfig{APIKeys: []string{"foo"}}, oauthcfg, logger, metahelm.FakeKubernetesReporter{})
+	apiv2, err := newV2API(dl, nil, nil, nil, nil, config.ServerConfig{APIKeys: []string{"foo"}}, oauthcfg, logger, metahelm.FakeKubernetesReporter{})
 	if err != nil {
 		t.Fatalf("error creating api: %v", err)
 	}
@@ -1189,7 +1639,7 @@ func TestAPIv2UserTokens(t *testing.T) {
 		},
 	}
 	copy(oauthcfg.UserTokenEncKey[:], []byte("00000000000000000000000000000000"))
-	apiv2, err := newV2API(dl, nil, nil, nil, config.ServerConfig{APIKeys: []string{"foo"}}, oauthcfg, logger, metahelm.FakeKubernetesReporter{})
+	apiv2, err := newV2API(dl, nil, nil, nil, nil, config.ServerConfig{APIKeys: []string{"foo"}}, oauthcfg, logger, metahelm.FakeKubernetesReporter{})
 	if err != nil {
 		t.Fatalf("error creating api: %v", err)
 	}
@@ -1299,7 +1749,7 @@ func TestAPIv2UserTokenDestroy(t *testing.T) {
 		},
 	}
 	copy(oauthcfg.UserTokenEncKey[:], []byte("00000000000000000000000000000000"))
-	apiv2, err := newV2API(dl, nil, nil, nil, config.ServerConfig{APIKeys: []string{"foo"}}, oauthcfg, logger, metahelm.FakeKubernetesReporter{})
+	apiv2, err := newV2API(dl, nil, nil, nil, nil, config.ServerConfig{APIKeys: []string{"foo"}}, oauthcfg, logger, metahelm.FakeKubernetesReporter{})
 	if err != nil {
 		t.Fatalf("error creating api: %v", err)
 	}
@@ -1355,3 +1805,213 @@ func TestAPIv2UserTokenDestroy(t *testing.T) {
 		t.Fatalf("bad status code: %v", res.StatusCode)
 	}
 }
+
+type testSSEEvent struct {
+	id, event, data string
+}
+
+// readSSEEvents parses server-sent events from r and sends them to out until r is closed
+func readSSEEvents(r io.Reader, out chan<- testSSEEvent) {
+	defer close(out)
+	scanner := bufio.NewScanner(r)
+	ev := testSSEEvent{}
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case line == "":
+			if ev.event != "" {
+				out <- ev
+			}
+			ev = testSSEEvent{}
+		case strings.HasPrefix(line, "id: "):
+			ev.id = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "event: "):
+			ev.event = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			ev.data = strings.TrimPrefix(line, "data: ")
+		}
+	}
+}
+
+func nextSSEEvent(t *testing.T, events <-chan testSSEEvent) testSSEEvent {
+	select {
+	case ev, ok := <-events:
+		if !ok {
+			t.Fatalf("stream closed")
+		}
+		return ev
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timeout waiting for event")
+	}
+	return testSSEEvent{}
+}
+
+func TestAPIv2EventStatusStream(t *testing.T) {
+	dl := persistence.NewFakeDataLayer()
+	id := uuid.Must(uuid.NewRandom())
+	dl.CreateEventLog(&models.EventLog{
+		ID:   id,
+		Repo: "acme/widgets",
+		Status: models.EventStatusSummary{
+			Config: models.EventStatusSummaryConfig{
+				Type:   models.CreateEvent,
+				Status: models.PendingStatus,
+			},
+			Tree: map[string]models.EventStatusTreeNode{
+				"widgets": models.EventStatusTreeNode{
+					Image: models.EventStatusTreeNodeImage{Name: "acme/widgets"},
+				},
+				"database": models.EventStatusTreeNode{
+					Parent: "widgets",
+				},
+			},
+		},
+	})
+
+	apiv2, err := newV2API(dl, nil, nil, nil, nil, config.ServerConfig{APIKeys: []string{"foo"}}, OAuthConfig{}, testlogger, nil)
+	if err != nil {
+		t.Fatalf("error creating api: %v", err)
+	}
+	r := muxtrace.NewRouter()
+	apiv2.register(r)
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/v2/event/" + id.String() + "/status/stream")
+	if err != nil {
+		t.Fatalf("error executing request: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("bad status code: %v", resp.StatusCode)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("bad content type: %v", ct)
+	}
+	events := make(chan testSSEEvent)
+	go readSSEEvents(resp.Body, events)
+
+	ev := nextSSEEvent(t, events)
+	if ev.event != "status" || ev.id == "" {
+		t.Fatalf("expected initial status event: %+v", ev)
+	}
+	status := V2EventStatusSummary{}
+	if err := json.Unmarshal([]byte(ev.data), &status); err != nil {
+		t.Fatalf("error unmarshaling status: %v", err)
+	}
+	if len(status.Tree) != 2 || status.Config.Status != "pending" {
+		t.Fatalf("bad status: %+v", status)
+	}
+	lastid := ev.id
+
+	dl.SetEventStatusImageStarted(id, "widgets")
+	ev = nextSSEEvent(t, events)
+	if ev.event != "delta" || ev.id == lastid {
+		t.Fatalf("expected delta event: %+v", ev)
+	}
+	delta := V2EventStatusDelta{}
+	if err := json.Unmarshal([]byte(ev.data), &delta); err != nil {
+		t.Fatalf("error unmarshaling delta: %v", err)
+	}
+	if delta.Config != nil || len(delta.Tree) != 1 || delta.Tree["widgets"].Image == nil || delta.Tree["widgets"].Image.Started == nil {
+		t.Fatalf("bad delta: %+v", delta)
+	}
+
+	dl.SetEventStatusCompleted(id, models.DoneStatus)
+	ev = nextSSEEvent(t, events)
+	if ev.event != "delta" {
+		t.Fatalf("expected delta event: %+v", ev)
+	}
+	delta = V2EventStatusDelta{}
+	if err := json.Unmarshal([]byte(ev.data), &delta); err != nil {
+		t.Fatalf("error unmarshaling delta: %v", err)
+	}
+	if delta.Config == nil || delta.Config.Status != "done" || delta.Tree != nil {
+		t.Fatalf("bad delta: %+v", delta)
+	}
+	lastid = ev.id
+	if ev = nextSSEEvent(t, events); ev.event != "done" {
+		t.Fatalf("expected done event: %+v", ev)
+	}
+	if _, ok := <-events; ok {
+		t.Fatalf("stream should have been closed")
+	}
+
+	// resuming with an up to date Last-Event-ID skips the initial status
+	req, _ := http.NewRequest("GET", ts.URL+"/v2/event/"+id.String()+"/status/stream", nil)
+	req.Header.Set("Last-Event-ID", lastid)
+	resp2, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("error executing request: %v", err)
+	}
+	defer resp2.Body.Close()
+	events = make(chan testSSEEvent)
+	go readSSEEvents(resp2.Body, events)
+	if ev = nextSSEEvent(t, events); ev.event != "done" {
+		t.Fatalf("expected done event: %+v", ev)
+	}
+}
+
+func TestAPIv2LogsStream(t *testing.T) {
+	dl := persistence.NewFakeDataLayer()
+	id := uuid.Must(uuid.NewRandom())
+	lk := uuid.Must(uuid.NewRandom())
+	dl.CreateEventLog(&models.EventLog{
+		ID:     id,
+		LogKey: lk,
+		Log:    []string{"line 1", "line 2"},
+	})
+
+	apiv2, err := newV2API(dl, nil, nil, nil, nil, config.ServerConfig{APIKeys: []string{"foo"}}, OAuthConfig{}, testlogger, nil)
+	if err != nil {
+		t.Fatalf("error creating api: %v", err)
+	}
+	r := muxtrace.NewRouter()
+	apiv2.register(r)
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/v2/event/" + id.String() + "/logs/stream")
+	if err != nil {
+		t.Fatalf("error executing request: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("missing log key should have been unauthorized: %v", resp.StatusCode)
+	}
+
+	resp, err = http.Get(ts.URL + "/v2/event/" + id.String() + "/logs/stream?log_key=" + lk.String())
+	if err != nil {
+		t.Fatalf("error executing request: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("log key in query should have been unauthorized: %v", resp.StatusCode)
+	}
+
+	req, _ := http.NewRequest("GET", ts.URL+"/v2/event/"+id.String()+"/logs/stream", nil)
+	req.Header.Set("Acyl-Log-Key", lk.String())
+	req.Header.Set("Last-Event-ID", "1")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("error executing request: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("bad status code: %v", resp.StatusCode)
+	}
+	events := make(chan testSSEEvent)
+	go readSSEEvents(resp.Body, events)
+
+	if ev := nextSSEEvent(t, events); ev.event != "log" || ev.id != "2" || ev.data != `"line 2"` {
+		t.Fatalf("expected second line only: %+v", ev)
+	}
+	dl.AppendToEventLog(id, "line 3")
+	if ev := nextSSEEvent(t, events); ev.event != "log" || ev.id != "3" || ev.data != `"line 3"` {
+		t.Fatalf("expected third line: %+v", ev)
+	}
+	dl.SetEventStatusCompleted(id, models.DoneStatus)
+	if ev := nextSSEEvent(t, events); ev.event != "done" {
+		t.Fatalf("expected done event: %+v", ev)
+	}
+}
@@ -0,0 +1,63 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// sseKeepAliveInterval is how often a comment is written to idle event streams so that proxies and load balancers don't close the connection
+var sseKeepAliveInterval = 15 * time.Second
+
+// sseWriter writes server-sent events (https://html.spec.whatwg.org/multipage/server-sent-events.html) to a streaming response
+type sseWriter struct {
+	w http.ResponseWriter
+	f http.Flusher
+}
+
+// newSSEWriter writes the event stream response headers and returns an sseWriter, or an error if w doesn't support streaming
+func newSSEWriter(w http.ResponseWriter) (*sseWriter, error) {
+	f, ok := w.(http.Flusher)
+	if !ok {
+		return nil, errors.New("response writer does not support streaming")
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no") // disable nginx response buffering
+	w.WriteHeader(http.StatusOK)
+	f.Flush()
+	return &sseWriter{w: w, f: f}, nil
+}
+
+// event writes an event named event with data encoded as JSON. If id is not empty it is sent as the event ID,
+// which the client will send back in the Last-Event-ID header when reconnecting.
+func (sw *sseWriter) event(id, event string, data interface{}) error {
+	j, err := json.Marshal(data)
+	if err != nil {
+		return errors.Wrap(err, "error marshaling event data")
+	}
+	var b strings.Builder
+	if id != "" {
+		fmt.Fprintf(&b, "id: %v\n", id)
+	}
+	fmt.Fprintf(&b, "event: %v\ndata: %s\n\n", event, j)
+	if _, err := sw.w.Write([]byte(b.String())); err != nil {
+		return errors.Wrap(err, "error writing event")
+	}
+	sw.f.Flush()
+	return nil
+}
+
+// comment writes a comment line, which is ignored by clients
+func (sw *sseWriter) comment(msg string) error {
+	if _, err := fmt.Fprintf(sw.w, ": %v\n\n", msg); err != nil {
+		return errors.Wrap(err, "error writing comment")
+	}
+	sw.f.Flush()
+	return nil
+}
@@ -0,0 +1,351 @@
+// Package apiclient is a client for the API key authenticated routes of the Acyl v2 API
+package apiclient
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+)
+
+const apiKeyHeader = "API-Key"
+
+// DefaultTimeout is the default timeout for individual API requests
+const DefaultTimeout = 30 * time.Second
+
+// Client talks to a remote Acyl server using an API key
+type Client struct {
+	hc     *http.Client
+	apiURL string
+	apiKey string
+}
+
+// NewClient returns a Client for the Acyl server at apiURL (eg, "https://acyl.example.com") authenticating with apiKey
+func NewClient(apiURL, apiKey string, timeout time.Duration) *Client {
+	return &Client{
+		hc:     &http.Client{Timeout: timeout},
+		apiURL: strings.TrimRight(apiURL, "/"),
+		apiKey: apiKey,
+	}
+}
+
+// APIError is returned when the server responds with a non-2xx status code
+type APIError struct {
+	StatusCode int
+	Details    string
+}
+
+func (e APIError) Error() string {
+	if e.Details == "" {
+		return fmt.Sprintf("server returned %v %v", e.StatusCode, http.StatusText(e.StatusCode))
+	}
+	return fmt.Sprintf("server returned %v %v: %v", e.StatusCode, http.StatusText(e.StatusCode), e.Details)
+}
+
+// IsNotFound returns if err is an APIError with a 404 status code
+func IsNotFound(err error) bool {
+	aerr, ok := errors.Cause(err).(APIError)
+	return ok && aerr.StatusCode == http.StatusNotFound
+}
+
+// do performs the request and returns the response body, which the caller must close
+func (c *Client) do(ctx context.Context, method, path string, query url.Values) (io.ReadCloser, error) {
+	u := c.apiURL + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "error creating request")
+	}
+	req.Header.Set(apiKeyHeader, c.apiKey)
+	resp, err := c.hc.Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "error performing request")
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		defer resp.Body.Close()
+		b, _ := ioutil.ReadAll(resp.Body)
+		aerr := APIError{StatusCode: resp.StatusCode}
+		ed := struct {
+			Details string `json:"error_details"`
+		}{}
+		if json.Unmarshal(b, &ed) == nil {
+			aerr.Details = ed.Details
+		} else {
+			aerr.Details = strings.TrimSpace(string(b))
+		}
+		return nil, aerr
+	}
+	return resp.Body, nil
+}
+
+// getJSON performs the request and decodes the JSON response into out
+func (c *Client) getJSON(ctx context.Context, method, path string, query url.Values, out interface{}) error {
+	rc, err := c.do(ctx, method, path, query)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	if err := json.NewDecoder(rc).Decode(out); err != nil {
+		return errors.Wrap(err, "error decoding response (client/server version mismatch?)")
+	}
+	return nil
+}
+
+// EnvironmentEvent is a message in the history of an environment
+type EnvironmentEvent struct {
+	Timestamp time.Time `json:"created"`
+	Message   string    `json:"message"`
+}
+
+// Environment models an environment as returned by the API
+type Environment struct {
+	Name         string             `json:"name"`
+	Created      time.Time          `json:"created"`
+	Events       []EnvironmentEvent `json:"events"`
+	User         string             `json:"user"`
+	Repo         string             `json:"repo"`
+	PullRequest  uint               `json:"pull_request"`
+	SourceSHA    string             `json:"source_sha"`
+	BaseSHA      string             `json:"base_sha"`
+	SourceBranch string             `json:"source_branch"`
+	BaseBranch   string             `json:"base_branch"`
+	SourceRef    string             `json:"source_ref"`
+	Status       string             `json:"status"`
+	RefMap       map[string]string  `json:"ref_map"`
+	CommitSHAMap map[string]string  `json:"commit_sha_map"`
+	Namespace    string             `json:"amino_kubernetes_namespace"`
+	PinnedUntil  *time.Time         `json:"pinned_until"`
+	LastActivity time.Time          `json:"last_activity"`
+	Expires      *time.Time         `json:"expires"`
+}
+
+// SearchParameters are the filters for SearchEnvs. At least one must be set.
+type SearchParameters struct {
+	Repo         string
+	PullRequest  uint
+	SourceSHA    string
+	SourceBranch string
+	User         string
+	Status       string
+	TrackingRef  string
+}
+
+func (sp SearchParameters) query() url.Values {
+	q := url.Values{}
+	set := func(k, v string) {
+		if v != "" {
+			q.Set(k, v)
+		}
+	}
+	set("repo", sp.Repo)
+	if sp.PullRequest > 0 {
+		q.Set("pr", strconv.Itoa(int(sp.PullRequest)))
+	}
+	set("source_sha", sp.SourceSHA)
+	set("source_branch", sp.SourceBranch)
+	set("user", sp.User)
+	set("status", sp.Status)
+	set("tracking_ref", sp.TrackingRef)
+	return q
+}
+
+// SearchEnvs returns the environments matching sp that are visible to the API key
+func (c *Client) SearchEnvs(ctx context.Context, sp SearchParameters) ([]Environment, error) {
+	q := sp.query()
+	if len(q) == 0 {
+		return nil, errors.New("at least one search parameter is required")
+	}
+	out := []Environment{}
+	return out, c.getJSON(ctx, "GET", "/v2/envs/_search", q, &out)
+}
+
+// GetEnv returns the environment named name
+func (c *Client) GetEnv(ctx context.Context, name string) (Environment, error) {
+	out := Environment{}
+	return out, c.getJSON(ctx, "GET", "/v2/envs/"+url.PathEscape(name), nil, &out)
+}
+
+// EventSummary summarizes an event (create, update or destroy) of an environment
+type EventSummary struct {
+	EventID string    `json:"event_id"`
+	Started time.Time `json:"started"`
+	Type    string    `json:"type"`
+	Status  string    `json:"status"`
+}
+
+// GetEnvEvents returns the events of the environment named name, newest first
+func (c *Client) GetEnvEvents(ctx context.Context, name string) ([]EventSummary, error) {
+	out := []EventSummary{}
+	return out, c.getJSON(ctx, "GET", "/v2/envs/"+url.PathEscape(name)+"/events", nil, &out)
+}
+
+// EventLog is the log of an event
+type EventLog struct {
+	ID          uuid.UUID `json:"id"`
+	Created     time.Time `json:"created"`
+	EnvName     string    `json:"env_name"`
+	Repo        string    `json:"repo"`
+	PullRequest uint      `json:"pull_request"`
+	Log         []string  `json:"log"`
+}
+
+// GetEventLog returns the event log with id
+func (c *Client) GetEventLog(ctx context.Context, id uuid.UUID) (EventLog, error) {
+	out := EventLog{}
+	return out, c.getJSON(ctx, "GET", "/v2/eventlog/"+id.String(), nil, &out)
+}
+
+// EventStatusConfig is the overall status of an event
+type EventStatusConfig struct {
+	Type           string            `json:"type"`
+	Status         string            `json:"status"`
+	EnvName        string            `json:"env_name"`
+	K8sNamespace   string            `json:"k8s_ns"`
+	TriggeringRepo string            `json:"triggering_repo"`
+	PullRequest    uint              `json:"pull_request"`
+	GitHubUser     string            `json:"github_user"`
+	Branch         string            `json:"branch"`
+	Revision       string            `json:"revision"`
+	ProcessingTime string            `json:"processing_time"`
+	Started        *time.Time        `json:"started"`
+	Completed      *time.Time        `json:"completed"`
+	RefMap         map[string]string `json:"ref_map"`
+}
+
+// EventStatusChart is the install/upgrade status of a chart within an event
+type EventStatusChart struct {
+	Status    string     `json:"status"`
+	Started   *time.Time `json:"started"`
+	Completed *time.Time `json:"completed"`
+}
+
+// EventStatusImage is the build status of an image within an event
+type EventStatusImage struct {
+	Name      string     `json:"name"`
+	Error     bool       `json:"error"`
+	Completed *time.Time `json:"completed"`
+}
+
+// EventStatusTreeNode is the status of a dependency within an event
+type EventStatusTreeNode struct {
+	Parent string            `json:"parent"`
+	Image  *EventStatusImage `json:"image"`
+	Chart  EventStatusChart  `json:"chart"`
+}
+
+// EventStatus is the status of an event
+type EventStatus struct {
+	Config EventStatusConfig              `json:"config"`
+	Tree   map[string]EventStatusTreeNode `json:"tree"`
+}
+
+// Finished returns if the event is no longer in progress
+func (es EventStatus) Finished() bool {
+	switch es.Config.Status {
+	case "done", "failed", "cancelled":
+		return true
+	default:
+		return false
+	}
+}
+
+// GetEventStatus returns the status of the event with id
+func (c *Client) GetEventStatus(ctx context.Context, id uuid.UUID) (EventStatus, error) {
+	out := EventStatus{}
+	return out, c.getJSON(ctx, "GET", "/v2/eventlog/"+id.String()+"/status", nil, &out)
+}
+
+// FollowEventLog calls f with each line of the event log with id as it is written, polling every interval until the event finishes
+// or ctx is cancelled. It returns the final status of the event.
+func (c *Client) FollowEventLog(ctx context.Context, id uuid.UUID, interval time.Duration, f func(line string)) (EventStatus, error) {
+	var n int
+	emit := func() error {
+		el, err := c.GetEventLog(ctx, id)
+		if err != nil {
+			return errors.Wrap(err, "error getting event log")
+		}
+		for ; n < len(el.Log); n++ {
+			f(el.Log[n])
+		}
+		return nil
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		// fetch the status before the log so that no lines written before completion are missed
+		es, err := c.GetEventStatus(ctx, id)
+		if err != nil {
+			return EventStatus{}, errors.Wrap(err, "error getting event status")
+		}
+		if err := emit(); err != nil {
+			return EventStatus{}, err
+		}
+		if es.Finished() {
+			return es, nil
+		}
+		select {
+		case <-ctx.Done():
+			return es, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// eventLogIDResponse is returned by write operations, which are processed asynchronously
+type eventLogIDResponse struct {
+	EventLogID uuid.UUID `json:"event_log_id"`
+}
+
+// RebuildEnv starts a rebuild of the environment named name and returns the event log ID of the operation.
+// If full is set, the environment is torn down and recreated rather than synchronized.
+func (c *Client) RebuildEnv(ctx context.Context, name string, full bool) (uuid.UUID, error) {
+	out := eventLogIDResponse{}
+	q := url.Values{"full": []string{strconv.FormatBool(full)}}
+	return out.EventLogID, c.getJSON(ctx, "POST", "/v2/envs/"+url.PathEscape(name)+"/actions/rebuild", q, &out)
+}
+
+// DestroyEnv starts destroying the environment named name and returns the event log ID of the operation
+func (c *Client) DestroyEnv(ctx context.Context, name string) (uuid.UUID, error) {
+	out := eventLogIDResponse{}
+	return out.EventLogID, c.getJSON(ctx, "DELETE", "/v2/envs/"+url.PathEscape(name), nil, &out)
+}
+
+// Pod is a summary of a Kubernetes pod in an environment
+type Pod struct {
+	Name     string `json:"name"`
+	Ready    string `json:"ready"`
+	Status   string `json:"status"`
+	Restarts string `json:"restarts"`
+	Age      string `json:"age"`
+}
+
+// GetEnvPods returns the pods in the namespace of the environment named name
+func (c *Client) GetEnvPods(ctx context.Context, name string) ([]Pod, error) {
+	out := []Pod{}
+	return out, c.getJSON(ctx, "GET", "/v2/envs/"+url.PathEscape(name)+"/pods", nil, &out)
+}
+
+// GetPodLogs returns the last lines of the logs of container in pod within the environment named name.
+// If container is empty the pod must have a single container. If lines is zero the server default is used.
+// The caller must close the returned reader.
+func (c *Client) GetPodLogs(ctx context.Context, name, pod, container string, lines uint) (io.ReadCloser, error) {
+	q := url.Values{}
+	if container != "" {
+		q.Set("container", container)
+	}
+	if lines > 0 {
+		q.Set("lines", strconv.Itoa(int(lines)))
+	}
+	return c.do(ctx, "GET", "/v2/envs/"+url.PathEscape(name)+"/pods/"+url.PathEscape(pod)+"/logs", q)
+}
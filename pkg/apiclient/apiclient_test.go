@@ -0,0 +1,171 @@
+package apiclient
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func testServer(t *testing.T, h http.HandlerFunc) *Client {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(apiKeyHeader) != "foo" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		h(w, r)
+	}))
+	t.Cleanup(ts.Close)
+	return NewClient(ts.URL+"/", "foo", DefaultTimeout)
+}
+
+func TestClientSearchEnvs(t *testing.T) {
+	c := testServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v2/envs/_search" {
+			t.Errorf("bad path: %v", r.URL.Path)
+		}
+		if q := r.URL.Query(); q.Get("repo") != "foo/bar" || q.Get("pr") != "2" || q.Get("status") != "" {
+			t.Errorf("bad query: %v", r.URL.RawQuery)
+		}
+		w.Write([]byte(`[{"name":"foo-bar","repo":"foo/bar","pull_request":2,"status":"success"}]`))
+	})
+	if _, err := c.SearchEnvs(context.Background(), SearchParameters{}); err == nil {
+		t.Fatalf("search without parameters should have failed")
+	}
+	envs, err := c.SearchEnvs(context.Background(), SearchParameters{Repo: "foo/bar", PullRequest: 2})
+	if err != nil {
+		t.Fatalf("error searching: %v", err)
+	}
+	if len(envs) != 1 || envs[0].Name != "foo-bar" || envs[0].Status != "success" {
+		t.Fatalf("bad envs: %+v", envs)
+	}
+}
+
+func TestClientErrors(t *testing.T) {
+	c := testServer(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v2/envs/missing":
+			w.WriteHeader(http.StatusNotFound)
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error_details":"env is destroyed"}`))
+		}
+	})
+	_, err := c.GetEnv(context.Background(), "missing")
+	if !IsNotFound(err) {
+		t.Fatalf("expected not found: %v", err)
+	}
+	_, err = c.RebuildEnv(context.Background(), "foo-bar", true)
+	if err == nil || !strings.Contains(err.Error(), "env is destroyed") {
+		t.Fatalf("expected error details: %v", err)
+	}
+	c.apiKey = "bar"
+	if _, err := c.GetEnv(context.Background(), "foo-bar"); err == nil || err.(APIError).StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected unauthorized: %v", err)
+	}
+}
+
+func TestClientWriteActions(t *testing.T) {
+	id := uuid.Must(uuid.NewRandom())
+	var calls []string
+	c := testServer(t, func(w http.ResponseWriter, r *http.Request) {
+		calls = append(calls, r.Method+" "+r.URL.Path+" "+r.URL.RawQuery)
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(map[string]string{"event_log_id": id.String()})
+	})
+	if eid, err := c.RebuildEnv(context.Background(), "foo-bar", true); err != nil || eid != id {
+		t.Fatalf("bad rebuild: %v: %v", eid, err)
+	}
+	if eid, err := c.DestroyEnv(context.Background(), "foo-bar"); err != nil || eid != id {
+		t.Fatalf("bad destroy: %v: %v", eid, err)
+	}
+	if len(calls) != 2 || calls[0] != "POST /v2/envs/foo-bar/actions/rebuild full=true" || calls[1] != "DELETE /v2/envs/foo-bar " {
+		t.Fatalf("bad calls: %v", calls)
+	}
+}
+
+func TestClientPodLogs(t *testing.T) {
+	c := testServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v2/envs/foo-bar/pods/foo-app-abc123/logs" || r.URL.Query().Get("container") != "app" || r.URL.Query().Get("lines") != "10" {
+			t.Errorf("bad request: %v", r.URL)
+		}
+		w.Write([]byte("line 1\nline 2\n"))
+	})
+	rc, err := c.GetPodLogs(context.Background(), "foo-bar", "foo-app-abc123", "app", 10)
+	if err != nil {
+		t.Fatalf("error getting pod logs: %v", err)
+	}
+	defer rc.Close()
+	b, _ := ioutil.ReadAll(rc)
+	if string(b) != "line 1\nline 2\n" {
+		t.Fatalf("bad logs: %v", string(b))
+	}
+}
+
+func TestClientFollowEventLog(t *testing.T) {
+	id := uuid.Must(uuid.NewRandom())
+	var polls int
+	c := testServer(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v2/eventlog/" + id.String() + "/status":
+			polls++
+			status := "pending"
+			if polls == 3 {
+				status = "done"
+			}
+			fmt.Fprintf(w, `{"config":{"status":"%v"}}`, status)
+		case "/v2/eventlog/" + id.String():
+			log := []string{}
+			for i := 0; i < polls; i++ {
+				log = append(log, fmt.Sprintf("line %v", i))
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{"id": id, "log": log})
+		default:
+			t.Errorf("bad path: %v", r.URL.Path)
+		}
+	})
+	var lines []string
+	es, err := c.FollowEventLog(context.Background(), id, time.Millisecond, func(line string) { lines = append(lines, line) })
+	if err != nil {
+		t.Fatalf("error following event log: %v", err)
+	}
+	if !es.Finished() || es.Config.Status != "done" {
+		t.Fatalf("bad final status: %+v", es.Config)
+	}
+	if strings.Join(lines, ",") != "line 0,line 1,line 2" {
+		t.Fatalf("bad lines: %v", lines)
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yml")
+	if err := os.WriteFile(path, []byte("api_url: https://acyl.example.com\napi_key: foo\n"), 0600); err != nil {
+		t.Fatalf("error writing config: %v", err)
+	}
+	t.Setenv(APIURLEnvVar, "")
+	t.Setenv(APIKeyEnvVar, "")
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("error loading config: %v", err)
+	}
+	if cfg.APIURL != "https://acyl.example.com" || cfg.APIKey != "foo" {
+		t.Fatalf("bad config: %+v", cfg)
+	}
+	t.Setenv(APIKeyEnvVar, "bar")
+	cfg, err = LoadConfig(filepath.Join(t.TempDir(), "missing.yml"))
+	if err != nil {
+		t.Fatalf("missing config file should not be an error: %v", err)
+	}
+	if cfg.APIURL != "" || cfg.APIKey != "bar" {
+		t.Fatalf("bad config from env: %+v", cfg)
+	}
+}
@@ -0,0 +1,48 @@
+package apiclient
+
+import (
+	"io/ioutil"
+	"os"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+// Environment variables that override the values in the config file
+const (
+	APIURLEnvVar = "ACYL_API_URL"
+	APIKeyEnvVar = "ACYL_API_KEY"
+)
+
+// Config is the client configuration, read from a YAML file like:
+//
+//	api_url: https://acyl.example.com
+//	api_key: 00000000-0000-0000-0000-000000000000
+type Config struct {
+	APIURL string `yaml:"api_url"`
+	APIKey string `yaml:"api_key"`
+}
+
+// LoadConfig reads the config file at path, if it exists, and applies any overrides from the environment
+func LoadConfig(path string) (Config, error) {
+	cfg := Config{}
+	if path != "" {
+		b, err := ioutil.ReadFile(path)
+		switch {
+		case err == nil:
+			if err := yaml.Unmarshal(b, &cfg); err != nil {
+				return cfg, errors.Wrap(err, "error parsing config file")
+			}
+		case os.IsNotExist(err):
+		default:
+			return cfg, errors.Wrap(err, "error reading config file")
+		}
+	}
+	if v := os.Getenv(APIURLEnvVar); v != "" {
+		cfg.APIURL = v
+	}
+	if v := os.Getenv(APIKeyEnvVar); v != "" {
+		cfg.APIKey = v
+	}
+	return cfg, nil
+}
@@ -4,11 +4,17 @@ import (
 	"crypto/tls"
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
+	"net"
 	"strings"
 	"time"
 
+	"github.com/dollarshaveclub/acyl/pkg/models"
 	"github.com/dollarshaveclub/pvc"
+	"github.com/ghodss/yaml"
 	"github.com/pkg/errors"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 )
 
 type ServerConfig struct {
@@ -24,12 +30,27 @@ type ServerConfig struct {
 	ReaperIntervalSecs         uint
 	EventRateLimitPerSecond    uint
 	GlobalEnvironmentLimit     uint
+	EnvironmentQuotas          EnvironmentQuotas
+	EvictionPolicy             string
+	MaxPinDuration             time.Duration
+	PinLabel                   string
+	EnvironmentLifetimes       EnvironmentLifetimes
+	ExtendLabel                string
+	SuspendIdleEnvironments    time.Duration
+	DefaultTriggerLabels       []string
+	DefaultAutoCreate          bool
+	DefaultSkipDraftPRs        bool
 	HostnameTemplate           string
 	DatadogServiceName         string
 	DebugEndpoints             bool
 	DebugEndpointsIPWhitelists []string
+	PrometheusMetrics          bool
 	NitroFeatureFlag           bool
 	NotificationsDefaultsJSON  string
+	WebhookNotifications       bool
+	WebhookNotificationsSecret []byte
+	WebhookAllowedHosts        []string
+	ChartRepoAllowedHosts      []string
 	OperationTimeoutOverride   time.Duration
 	UIBaseURL                  string
 	UIPath                     string
@@ -37,6 +58,160 @@ type ServerConfig struct {
 	UIBrandingJSON             string
 }
 
+// EnvironmentLifetimes models the server default and bounds for environment lifetimes (see the acyl.yml lifetime setting)
+type EnvironmentLifetimes struct {
+	// Default is the lifetime of environments that don't set one (zero means they don't expire unless Max is set)
+	Default time.Duration
+	// Min and Max bound the lifetime set in acyl.yml and lifetime extensions (zero is unbounded)
+	Min time.Duration
+	Max time.Duration
+	// Extension is how long the lifetime is extended by the extend label or comment command
+	Extension time.Duration
+	// MaxTotal is the maximum total lifetime, measured from creation, that extensions may extend an environment to (zero is unbounded)
+	MaxTotal time.Duration
+	// Warning is how long before expiry the expiry warning notification is sent (zero disables the warning)
+	Warning time.Duration
+	// AdHoc is the lifetime of ad-hoc environments that would otherwise not expire, as they aren't destroyed when a PR is closed (zero means they don't expire)
+	AdHoc time.Duration
+}
+
+// Lifetime returns the lifetime for an environment that requests lifetime (zero if unset) within the bounds, or zero if the environment doesn't expire
+func (el EnvironmentLifetimes) Lifetime(requested time.Duration) time.Duration {
+	d := requested
+	if d == 0 {
+		d = el.Default
+	}
+	if el.Max > 0 && (d == 0 || d > el.Max) {
+		d = el.Max
+	}
+	if d > 0 && d < el.Min {
+		d = el.Min
+	}
+	return d
+}
+
+// AdHocLifetime returns the lifetime for an ad-hoc environment that requests lifetime (zero if unset), falling back to the ad-hoc lifetime if it otherwise wouldn't expire
+func (el EnvironmentLifetimes) AdHocLifetime(requested time.Duration) time.Duration {
+	if d := el.Lifetime(requested); d > 0 {
+		return d
+	}
+	if el.AdHoc > 0 && el.AdHoc < el.Min {
+		return el.Min
+	}
+	return el.AdHoc
+}
+
+// Validate returns an error if the bounds are inconsistent
+func (el EnvironmentLifetimes) Validate() error {
+	if el.Default < 0 || el.Min < 0 || el.Max < 0 || el.Extension < 0 || el.MaxTotal < 0 || el.Warning < 0 || el.AdHoc < 0 {
+		return errors.New("lifetimes must not be negative")
+	}
+	if el.Max > 0 && el.Min > el.Max {
+		return fmt.Errorf("minimum lifetime (%v) exceeds maximum lifetime (%v)", el.Min, el.Max)
+	}
+	if el.MaxTotal > 0 && el.MaxTotal < el.Max {
+		return fmt.Errorf("maximum total lifetime (%v) is less than maximum lifetime (%v)", el.MaxTotal, el.Max)
+	}
+	return nil
+}
+
+// QuotaScope is the kind of scope an environment quota applies to
+type QuotaScope string
+
+const (
+	QuotaScopeRepo QuotaScope = "repo"
+	QuotaScopeOrg  QuotaScope = "org"
+	QuotaScopeUser QuotaScope = "user"
+)
+
+// EnvironmentQuotas models the maximum number of running environments per GitHub repository, organization and user
+type EnvironmentQuotas struct {
+	// Repos, Orgs and Users are maps of repository ("owner/name"), organization and user to quota (zero is unlimited)
+	Repos map[string]uint `json:"repos"`
+	Orgs  map[string]uint `json:"orgs"`
+	Users map[string]uint `json:"users"`
+	// DefaultRepo, DefaultOrg and DefaultUser are the quotas for repositories, organizations and users without an entry in the maps (zero is unlimited)
+	DefaultRepo uint `json:"default_repo"`
+	DefaultOrg  uint `json:"default_org"`
+	DefaultUser uint `json:"default_user"`
+	// Evict destroys the oldest running environments within the scope when a new environment would exceed a quota, instead of rejecting the new environment
+	Evict bool `json:"evict"`
+}
+
+// EnvironmentQuota is the quota for a single repository, organization or user
+type EnvironmentQuota struct {
+	Scope QuotaScope
+	Name  string
+	Limit uint
+}
+
+func (eq EnvironmentQuota) String() string {
+	return string(eq.Scope) + " " + eq.Name
+}
+
+// Matches returns whether env is within the scope of the quota
+func (eq EnvironmentQuota) Matches(env models.QAEnvironment) bool {
+	switch eq.Scope {
+	case QuotaScopeRepo:
+		return env.Repo == eq.Name
+	case QuotaScopeOrg:
+		return repoOrg(env.Repo) == eq.Name
+	case QuotaScopeUser:
+		return env.User == eq.Name
+	default:
+		return false
+	}
+}
+
+func repoOrg(repo string) string {
+	return strings.SplitN(repo, "/", 2)[0]
+}
+
+// Quotas returns the limited quotas that apply to an environment for repo created by user
+func (eqs EnvironmentQuotas) Quotas(repo, user string) []EnvironmentQuota {
+	out := []EnvironmentQuota{}
+	add := func(scope QuotaScope, name string, m map[string]uint, dflt uint) {
+		if name == "" {
+			return
+		}
+		limit, ok := m[name]
+		if !ok {
+			limit = dflt
+		}
+		if limit > 0 {
+			out = append(out, EnvironmentQuota{Scope: scope, Name: name, Limit: limit})
+		}
+	}
+	add(QuotaScopeRepo, repo, eqs.Repos, eqs.DefaultRepo)
+	add(QuotaScopeOrg, repoOrg(repo), eqs.Orgs, eqs.DefaultOrg)
+	add(QuotaScopeUser, user, eqs.Users, eqs.DefaultUser)
+	return out
+}
+
+// ProcessEnvironmentQuotas takes a JSON-encoded EnvironmentQuotas and populates the EnvironmentQuotas field
+func (sc *ServerConfig) ProcessEnvironmentQuotas(jsonstr string) error {
+	sc.EnvironmentQuotas = EnvironmentQuotas{}
+	if jsonstr == "" {
+		return nil
+	}
+	var eqs EnvironmentQuotas
+	if err := json.Unmarshal([]byte(jsonstr), &eqs); err != nil {
+		return errors.Wrap(err, "error unmarshaling environment quotas")
+	}
+	for r := range eqs.Repos {
+		if rsl := strings.Split(r, "/"); len(rsl) != 2 || rsl[0] == "" || rsl[1] == "" {
+			return fmt.Errorf("malformed repo: %v", r)
+		}
+	}
+	for o := range eqs.Orgs {
+		if o == "" || strings.Contains(o, "/") {
+			return fmt.Errorf("malformed org: %v", o)
+		}
+	}
+	sc.EnvironmentQuotas = eqs
+	return nil
+}
+
 type PGConfig struct {
 	PostgresURI            string
 	PostgresMigrationsPath string
@@ -54,6 +229,60 @@ type K8sClientConfig struct {
 type K8sSecret struct {
 	Data map[string][]byte `json:"data"`
 	Type string            `json:"type"`
+	// Scope restricts which repos the secret is injected for. If nil, the secret is injected into every environment namespace.
+	Scope *SecretInjectionScope `json:"-"`
+}
+
+// SecretInjectionScope models the repos a secret injection is permitted for and how it is injected
+type SecretInjectionScope struct {
+	// Repos are the GitHub repositories permitted to use the secret: "owner/name", "owner/*" (all repos in an org) or "*"
+	Repos []string `json:"repos"`
+	// Always injects the secret for permitted repos even if acyl.yml doesn't declare it
+	Always bool `json:"always"`
+	// KeyRenames is a map of secret data key to the key it is injected as
+	KeyRenames map[string]string `json:"key_renames"`
+}
+
+// InjectedData returns the secret data with any keys renamed according to the scope
+func (ks K8sSecret) InjectedData() map[string][]byte {
+	if ks.Scope == nil || len(ks.Scope.KeyRenames) == 0 {
+		return ks.Data
+	}
+	out := make(map[string][]byte, len(ks.Data))
+	for k, v := range ks.Data {
+		if nk, ok := ks.Scope.KeyRenames[k]; ok {
+			k = nk
+		}
+		out[k] = v
+	}
+	return out
+}
+
+// Permits returns whether repo is permitted to use the secret
+func (sis *SecretInjectionScope) Permits(repo string) bool {
+	if sis == nil {
+		return true
+	}
+	return matchesRepoPattern(sis.Repos, repo)
+}
+
+// matchesRepoPattern returns whether repo matches any of the repo patterns: "owner/name", "owner/*" or "*"
+func matchesRepoPattern(patterns []string, repo string) bool {
+	for _, r := range patterns {
+		switch {
+		case r == "*", r == repo:
+			return true
+		case strings.HasSuffix(r, "/*") && strings.HasPrefix(repo, strings.TrimSuffix(r, "*")):
+			return true
+		}
+	}
+	return false
+}
+
+// validRepoPattern returns whether r is a valid repo pattern
+func validRepoPattern(r string) bool {
+	rsl := strings.Split(r, "/")
+	return r == "*" || (len(rsl) == 2 && rsl[0] != "" && rsl[1] != "")
 }
 
 type K8sConfig struct {
@@ -63,6 +292,207 @@ type K8sConfig struct {
 	PrivilegedRepoWhitelist []string
 	// SecretInjections is a map of secret name to value that will be injected into each environment namespace
 	SecretInjections map[string]K8sSecret
+	// NamespaceGuardrails are the ResourceQuota, LimitRange and NetworkPolicy settings for each environment namespace
+	NamespaceGuardrails NamespaceGuardrails
+	// RBACProfiles are the named sets of RBAC rules that may be granted to environment service accounts
+	RBACProfiles RBACProfiles
+	// Clusters are the clusters that environments may be placed in. If empty, all environments are created in the default cluster.
+	Clusters K8sClusters
+}
+
+// K8sCluster models a Kubernetes cluster that environments may be placed in
+type K8sCluster struct {
+	// Name uniquely identifies the cluster and is recorded for each environment placed in it
+	Name string `json:"name"`
+	// KubeconfigPath and KubeContext select the cluster credentials. If both are empty, the default cluster client is used.
+	KubeconfigPath string `json:"kubeconfig_path"`
+	KubeContext    string `json:"kube_context"`
+	// Capacity is the maximum number of environments in the cluster (zero is unlimited)
+	Capacity uint `json:"capacity"`
+	// Labels may be used to select the cluster in acyl.yml
+	Labels map[string]string `json:"labels"`
+	// Repos are the repo patterns ("owner/name", "owner/*" or "*") with affinity for the cluster.
+	// Repos with affinity for any cluster are only placed in those clusters, other repos are placed in clusters without any repos.
+	Repos []string `json:"repos"`
+}
+
+// Default returns whether the cluster uses the default cluster client
+func (kc K8sCluster) Default() bool {
+	return kc.KubeconfigPath == "" && kc.KubeContext == ""
+}
+
+// HasAffinity returns whether repo has affinity for the cluster
+func (kc K8sCluster) HasAffinity(repo string) bool {
+	return matchesRepoPattern(kc.Repos, repo)
+}
+
+// HasLabels returns whether the cluster has all of labels
+func (kc K8sCluster) HasLabels(labels map[string]string) bool {
+	for k, v := range labels {
+		if cv, ok := kc.Labels[k]; !ok || cv != v {
+			return false
+		}
+	}
+	return true
+}
+
+// K8sClusters is the list of clusters that environments may be placed in
+type K8sClusters []K8sCluster
+
+// Get returns the cluster named name and whether it exists
+func (kcs K8sClusters) Get(name string) (K8sCluster, bool) {
+	for _, c := range kcs {
+		if c.Name == name {
+			return c, true
+		}
+	}
+	return K8sCluster{}, false
+}
+
+// ProcessClusters takes a JSON-encoded list of K8sCluster and populates the Clusters field
+func (kc *K8sConfig) ProcessClusters(jsonstr string) error {
+	kc.Clusters = nil
+	if jsonstr == "" {
+		return nil
+	}
+	var clusters K8sClusters
+	if err := json.Unmarshal([]byte(jsonstr), &clusters); err != nil {
+		return errors.Wrap(err, "error unmarshaling clusters")
+	}
+	names := make(map[string]struct{}, len(clusters))
+	for i, c := range clusters {
+		if c.Name == "" {
+			return fmt.Errorf("cluster at offset %v: name is required", i)
+		}
+		if _, ok := names[c.Name]; ok {
+			return fmt.Errorf("duplicate cluster name: %v", c.Name)
+		}
+		names[c.Name] = struct{}{}
+		for j, r := range c.Repos {
+			if !validRepoPattern(r) {
+				return fmt.Errorf("cluster %v: malformed repo pattern at offset %v: %v", c.Name, j, r)
+			}
+		}
+	}
+	kc.Clusters = clusters
+	return nil
+}
+
+// RBACProfiles models the named RBAC profiles for environment service accounts and the repos that may use them
+type RBACProfiles struct {
+	// Profiles is a map of profile name to profile
+	Profiles map[string]RBACProfile `json:"profiles"`
+	// Repos is a map of GitHub repository to the names of the profiles that it may request in acyl.yml. The first is used if none is requested.
+	Repos map[string][]string `json:"repos"`
+	// Default is the profile used for repos that aren't present in Repos. If empty, those environments get the default namespace-wide Role.
+	Default string `json:"default"`
+}
+
+// RBACProfile models the RBAC rules granted to an environment service account
+type RBACProfile struct {
+	// Rules are granted within the environment namespace (via a Role)
+	Rules []rbacv1.PolicyRule `json:"rules"`
+	// ClusterRules are granted cluster-wide (via a ClusterRole) and are never granted to untrusted environments
+	ClusterRules []rbacv1.PolicyRule `json:"cluster_rules"`
+}
+
+// LoadRBACProfiles reads the YAML or JSON-encoded RBACProfiles from the file at path and populates the RBACProfiles field
+func (kc *K8sConfig) LoadRBACProfiles(path string) error {
+	kc.RBACProfiles = RBACProfiles{}
+	if path == "" {
+		return nil
+	}
+	d, err := ioutil.ReadFile(path)
+	if err != nil {
+		return errors.Wrap(err, "error reading RBAC profiles file")
+	}
+	return kc.ProcessRBACProfiles(d)
+}
+
+// ProcessRBACProfiles takes YAML or JSON-encoded RBACProfiles, validates them and populates the RBACProfiles field
+func (kc *K8sConfig) ProcessRBACProfiles(data []byte) error {
+	kc.RBACProfiles = RBACProfiles{}
+	var rp RBACProfiles
+	if err := yaml.Unmarshal(data, &rp); err != nil {
+		return errors.Wrap(err, "error unmarshaling RBAC profiles")
+	}
+	for name, p := range rp.Profiles {
+		if name == "" {
+			return errors.New("empty profile name")
+		}
+		for i, r := range append(append([]rbacv1.PolicyRule{}, p.Rules...), p.ClusterRules...) {
+			if len(r.Verbs) == 0 {
+				return fmt.Errorf("profile %v: rule at offset %v: verbs are required", name, i)
+			}
+			if len(r.Resources) == 0 && len(r.NonResourceURLs) == 0 {
+				return fmt.Errorf("profile %v: rule at offset %v: resources or non-resource URLs are required", name, i)
+			}
+		}
+	}
+	if _, ok := rp.Profiles[rp.Default]; rp.Default != "" && !ok {
+		return fmt.Errorf("unknown default profile: %v", rp.Default)
+	}
+	for repo, pl := range rp.Repos {
+		if rsl := strings.Split(repo, "/"); len(rsl) != 2 {
+			return fmt.Errorf("malformed repo: %v", repo)
+		}
+		if len(pl) == 0 {
+			return fmt.Errorf("repo %v: at least one profile is required", repo)
+		}
+		for _, p := range pl {
+			if _, ok := rp.Profiles[p]; !ok {
+				return fmt.Errorf("repo %v: unknown profile: %v", repo, p)
+			}
+		}
+	}
+	kc.RBACProfiles = rp
+	return nil
+}
+
+// NamespaceGuardrails models the server defaults for environment namespace guardrails and the ceilings for per-repo overrides in acyl.yml
+type NamespaceGuardrails struct {
+	Defaults models.NamespaceConfig `json:"defaults"`
+	Ceilings NamespaceCeilings      `json:"ceilings"`
+}
+
+// NamespaceCeilings models the maximum values that acyl.yml may set for namespace guardrails. Repo quantities that exceed a ceiling are reduced to it,
+// while network policy exceptions outside of the allowed namespaces and CIDRs are rejected.
+type NamespaceCeilings struct {
+	// ResourceQuota is a map of resource name to the maximum ResourceQuota hard limit
+	ResourceQuota map[string]string `json:"resource_quota"`
+	// LimitRange is a map of resource name to the maximum LimitRange default, default request and max values
+	LimitRange map[string]string `json:"limit_range"`
+	// NetworkPolicyRequired prevents acyl.yml from disabling the default network policy
+	NetworkPolicyRequired bool `json:"network_policy_required"`
+	// AllowedIngressNamespaces are the namespaces that acyl.yml may allow ingress from. If empty, acyl.yml may not allow ingress from any namespace.
+	AllowedIngressNamespaces []string `json:"allowed_ingress_namespaces"`
+	// AllowedEgressCIDRs are the IP ranges that acyl.yml may allow egress to (repo CIDRs must be contained within one of them). If empty, acyl.yml may not allow egress to any CIDR.
+	AllowedEgressCIDRs []string `json:"allowed_egress_cidrs"`
+}
+
+// ProcessNamespaceGuardrails takes a JSON-encoded NamespaceGuardrails and populates the NamespaceGuardrails field
+func (kc *K8sConfig) ProcessNamespaceGuardrails(jsonstr string) error {
+	kc.NamespaceGuardrails = NamespaceGuardrails{}
+	if jsonstr == "" {
+		return nil
+	}
+	if err := json.Unmarshal([]byte(jsonstr), &kc.NamespaceGuardrails); err != nil {
+		return errors.Wrap(err, "error unmarshaling namespace guardrails")
+	}
+	ng := kc.NamespaceGuardrails
+	for _, rl := range []map[string]string{ng.Ceilings.ResourceQuota, ng.Ceilings.LimitRange} {
+		for k, v := range rl {
+			if _, err := resource.ParseQuantity(v); err != nil {
+				return fmt.Errorf("invalid ceiling quantity for %v: %v: %w", k, v, err)
+			}
+		}
+	}
+	for _, cidr := range ng.Ceilings.AllowedEgressCIDRs {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			return fmt.Errorf("invalid allowed egress CIDR: %v: %w", cidr, err)
+		}
+	}
+	return nil
 }
 
 // ProcessPrivilegedRepos takes a comma-separated list of repositories and populates the PrivilegedRepoWhitelist field
@@ -127,9 +557,57 @@ func (kc *K8sConfig) ProcessSecretInjections(sf SecretFetcher, injstr string) er
 	return nil
 }
 
+// ProcessSecretInjectionScopes takes a JSON-encoded map of secret name to SecretInjectionScope and sets the scope of each secret injection.
+// It must be called after ProcessSecretInjections.
+func (kc *K8sConfig) ProcessSecretInjectionScopes(jsonstr string) error {
+	if jsonstr == "" {
+		return nil
+	}
+	scopes := map[string]SecretInjectionScope{}
+	if err := json.Unmarshal([]byte(jsonstr), &scopes); err != nil {
+		return errors.Wrap(err, "error unmarshaling secret injection scopes")
+	}
+	for name, scope := range scopes {
+		secret, ok := kc.SecretInjections[name]
+		if !ok {
+			return fmt.Errorf("scope for unknown secret injection: %v", name)
+		}
+		for i, r := range scope.Repos {
+			if !validRepoPattern(r) {
+				return fmt.Errorf("secret %v: malformed repo pattern at offset %v: %v", name, i, r)
+			}
+		}
+		for k, v := range scope.KeyRenames {
+			if _, ok := secret.Data[k]; !ok {
+				return fmt.Errorf("secret %v: key rename for missing key: %v", name, k)
+			}
+			if v == "" {
+				return fmt.Errorf("secret %v: empty key rename for key: %v", name, k)
+			}
+		}
+		// each key must be injected under a unique name, otherwise one value would silently replace another
+		targets := make(map[string]string, len(secret.Data))
+		for k := range secret.Data {
+			tk := k
+			if nk, ok := scope.KeyRenames[k]; ok {
+				tk = nk
+			}
+			if other, ok := targets[tk]; ok {
+				return fmt.Errorf("secret %v: keys %v and %v are both injected as: %v", name, other, k, tk)
+			}
+			targets[tk] = k
+		}
+		scope := scope
+		secret.Scope = &scope
+		kc.SecretInjections[name] = secret
+	}
+	return nil
+}
+
 type HelmClientConfig struct {
-	HelmDriver  string
-	KubeContext string
+	HelmDriver     string
+	KubeconfigPath string
+	KubeContext    string
 }
 
 type ConsulConfig struct {
@@ -80,6 +80,17 @@ func (l *Logger) SetInitialStatus(rc *models.RepoConfig, processingTime time.Dur
 		}
 		tree[dep.Name] = node
 	}
+	for name, hcs := range rc.HealthChecks() {
+		node, ok := tree[name]
+		if !ok {
+			continue
+		}
+		node.HealthChecks = make(map[string]models.EventStatusTreeNodeHealthCheck, len(hcs))
+		for _, hc := range hcs {
+			node.HealthChecks[hc.Name] = models.EventStatusTreeNodeHealthCheck{Type: hc.Type()}
+		}
+		tree[name] = node
+	}
 	if err := l.DL.SetEventStatusTree(l.ID, tree); err != nil {
 		l.Printf("error setting event status tree: %v", err)
 	}
@@ -112,6 +123,13 @@ func (l *Logger) SetImageCompleted(name string, err bool) {
 	}
 }
 
+// SetImageCached marks the image for the named dependency as completed without a build because the image tag already exists (name is assumed to exist)
+func (l *Logger) SetImageCached(name string) {
+	if err := l.DL.SetEventStatusImageCached(l.ID, name); err != nil {
+		l.Printf("error setting image status to cached: %v: %v", name, err)
+	}
+}
+
 // SetChartStarted marks the chart install/upgrade for the named dependency to started (name is assumed to exist) with status
 func (l *Logger) SetChartStarted(name string, status models.NodeChartStatus) {
 	if err := l.DL.SetEventStatusChartStarted(l.ID, name, status); err != nil {
@@ -126,6 +144,24 @@ func (l *Logger) SetChartCompleted(name string, status models.NodeChartStatus) {
 	}
 }
 
+// SetHealthCheckStarted marks the health check for the named dependency as started (name is assumed to exist)
+func (l *Logger) SetHealthCheckStarted(name, check string) {
+	if err := l.DL.SetEventStatusHealthCheckStarted(l.ID, name, check); err != nil {
+		l.Printf("error setting health check status to started: %v: %v: %v", name, check, err)
+	}
+}
+
+// SetHealthCheckCompleted marks the health check for the named dependency as completed (name is assumed to exist), failed if err is not nil
+func (l *Logger) SetHealthCheckCompleted(name, check string, err error) {
+	var msg string
+	if err != nil {
+		msg = err.Error()
+	}
+	if err2 := l.DL.SetEventStatusHealthCheckCompleted(l.ID, name, check, err != nil, msg); err2 != nil {
+		l.Printf("error setting health check status to completed: %v: %v: %v", name, check, err2)
+	}
+}
+
 // SetCompletedStatus marks the entire event as completed with status. This is intended to be called once at the end of event processing.
 func (l *Logger) SetCompletedStatus(status models.EventStatus) {
 	if err := l.DL.SetEventStatusCompleted(l.ID, status); err != nil {
@@ -1,6 +1,7 @@
 package eventlogger
 
 import (
+	"fmt"
 	"os"
 	"testing"
 	"time"
@@ -332,3 +333,51 @@ func TestSetNewStatusUnknown(t *testing.T) {
 		t.Fatalf("expected comparison to match:\nRsp: %+v\nExp: %+v", el2, exp)
 	}
 }
+
+func TestSetHealthCheckCompleted(t *testing.T) {
+	dl := persistence.NewFakeDataLayer()
+	id, _ := uuid.NewRandom()
+	elog := Logger{DL: dl, ID: id, Sink: os.Stderr}
+	elog.Init([]byte{}, "foo/bar", 99)
+
+	rrd := models.RepoRevisionData{Repo: "foo/bar", PullRequest: 12, User: "john.doe", SourceBranch: "feature-foo", SourceSHA: "asdf"}
+	elog.SetNewStatus(models.CreateEvent, "some-name", rrd)
+
+	rc := testRC
+	rc.Dependencies.Direct = append([]models.RepoConfigDependency{}, testRC.Dependencies.Direct...)
+	rc.Dependencies.Direct[0].HealthChecks = []models.HealthCheck{
+		models.HealthCheck{Name: "web", HTTP: &models.HTTPHealthCheck{URL: "http://something"}},
+		models.HealthCheck{Name: "db", TCP: &models.TCPHealthCheck{Address: "db:5432"}},
+	}
+	elog.SetInitialStatus(&rc, 10*time.Millisecond)
+
+	el2, err := dl.GetEventStatus(id)
+	if err != nil {
+		t.Fatalf("error getting event status: %v", err)
+	}
+	if hc := el2.Tree["something"].HealthChecks["web"]; hc.Type != "http" || !hc.Started.IsZero() {
+		t.Fatalf("bad initial health check: %+v", hc)
+	}
+
+	elog.SetHealthCheckStarted("something", "web")
+	elog.SetHealthCheckStarted("something", "db")
+	elog.SetHealthCheckCompleted("something", "web", nil)
+	elog.SetHealthCheckCompleted("something", "db", fmt.Errorf("connection refused"))
+
+	el2, err = dl.GetEventStatus(id)
+	if err != nil {
+		t.Fatalf("error getting event status: %v", err)
+	}
+	web := el2.Tree["something"].HealthChecks["web"]
+	if web.Started.IsZero() || web.Completed.IsZero() || web.Error {
+		t.Fatalf("bad web health check: %+v", web)
+	}
+	db := el2.Tree["something"].HealthChecks["db"]
+	if db.Completed.IsZero() || !db.Error || db.Message != "connection refused" {
+		t.Fatalf("bad db health check: %+v", db)
+	}
+	fhcs := el2.FailedHealthChecks()
+	if len(fhcs) != 1 || fhcs[0].Node != "something" || fhcs[0].Name != "db" {
+		t.Fatalf("bad failed health checks: %+v", fhcs)
+	}
+}
@@ -0,0 +1,211 @@
+package eviction
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/dollarshaveclub/acyl/pkg/models"
+)
+
+const (
+	// ActivityPolicyName is the name of the default policy, which evicts failed and suspended environments first and then the least recently active
+	ActivityPolicyName = "activity"
+	// OldestPolicyName is the name of the policy that evicts the oldest running environments first
+	OldestPolicyName = "oldest"
+)
+
+// Victim is an environment chosen for eviction along with the reason it was chosen
+type Victim struct {
+	Env    models.QAEnvironment
+	Reason string
+}
+
+// Policy describes an object that chooses which environments to evict when a limit is exceeded.
+// Pinned environments are never chosen.
+type Policy interface {
+	// Name returns the policy name, which is included in logs and notifications
+	Name() string
+	// Statuses returns the environment statuses that are eligible for eviction. Only running environments count towards limits.
+	Statuses() []models.EnvironmentStatus
+	// Victims returns up to n environments from envs in eviction order
+	Victims(envs []models.QAEnvironment, n int, now time.Time) []Victim
+}
+
+// NewPolicy returns the policy named name, or the activity policy if name is empty
+func NewPolicy(name string) (Policy, error) {
+	switch name {
+	case "", ActivityPolicyName:
+		return ActivityPolicy{}, nil
+	case OldestPolicyName:
+		return OldestPolicy{}, nil
+	default:
+		return nil, fmt.Errorf("unknown eviction policy: %v", name)
+	}
+}
+
+// RunningStatuses are the statuses of running environments, which count towards global limits and quotas
+var RunningStatuses = []models.EnvironmentStatus{models.Spawned, models.Success, models.Updating}
+
+// Running returns if status is the status of a running environment
+func Running(status models.EnvironmentStatus) bool {
+	for _, s := range RunningStatuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+// CountRunning returns the number of running environments in envs
+func CountRunning(envs []models.QAEnvironment) int {
+	var n int
+	for _, e := range envs {
+		if Running(e.Status) {
+			n++
+		}
+	}
+	return n
+}
+
+// Select returns the victims chosen by p from envs in order to evict n running environments, along with the number of running victims (which is less than n if not enough are eligible).
+// Environments that aren't running but are ranked ahead of them by the policy (eg, failed environments) are also returned.
+func Select(p Policy, envs []models.QAEnvironment, n int, now time.Time) ([]Victim, int) {
+	out := []Victim{}
+	var running int
+	for _, v := range p.Victims(envs, len(envs), now) {
+		if running >= n {
+			break
+		}
+		out = append(out, v)
+		if Running(v.Env.Status) {
+			running++
+		}
+	}
+	return out, running
+}
+
+// unpinned returns a copy of envs without the environments that are pinned at now
+func unpinned(envs []models.QAEnvironment, now time.Time) []models.QAEnvironment {
+	out := make([]models.QAEnvironment, 0, len(envs))
+	for _, e := range envs {
+		if !e.Pinned(now) {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+// OldestPolicy evicts running environments in ascending order of creation
+type OldestPolicy struct{}
+
+var _ Policy = OldestPolicy{}
+
+func (OldestPolicy) Name() string { return OldestPolicyName }
+
+func (OldestPolicy) Statuses() []models.EnvironmentStatus { return RunningStatuses }
+
+func (OldestPolicy) Victims(envs []models.QAEnvironment, n int, now time.Time) []Victim {
+	candidates := unpinned(envs, now)
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].Created.Before(candidates[j].Created) })
+	out := []Victim{}
+	for i := 0; i < n && i < len(candidates); i++ {
+		out = append(out, Victim{Env: candidates[i], Reason: fmt.Sprintf("oldest environment (created %v)", candidates[i].Created.Format(time.RFC3339))})
+	}
+	return out
+}
+
+// ActivityPolicy evicts failed environments first, then suspended environments, then running environments in ascending order of last activity (events such as pushes, and UI/API access)
+type ActivityPolicy struct{}
+
+var _ Policy = ActivityPolicy{}
+
+func (ActivityPolicy) Name() string { return ActivityPolicyName }
+
+// Statuses includes failed and suspended environments, as they still hold a namespace, but they don't count towards limits
+func (ActivityPolicy) Statuses() []models.EnvironmentStatus {
+	return append([]models.EnvironmentStatus{models.Failure, models.Suspended}, RunningStatuses...)
+}
+
+func (ActivityPolicy) Victims(envs []models.QAEnvironment, n int, now time.Time) []Victim {
+	rank := func(e models.QAEnvironment) int {
+		switch e.Status {
+		case models.Failure:
+			return 0
+		case models.Suspended:
+			return 1
+		default:
+			return 2
+		}
+	}
+	candidates := unpinned(envs, now)
+	sort.SliceStable(candidates, func(i, j int) bool {
+		ri, rj := rank(candidates[i]), rank(candidates[j])
+		if ri != rj {
+			return ri < rj
+		}
+		li, lj := candidates[i].LastActivity(), candidates[j].LastActivity()
+		if !li.Equal(lj) {
+			return li.Before(lj)
+		}
+		return candidates[i].Created.Before(candidates[j].Created)
+	})
+	out := []Victim{}
+	for i := 0; i < n && i < len(candidates); i++ {
+		e := candidates[i]
+		var reason string
+		switch e.Status {
+		case models.Failure:
+			reason = "failed environment"
+		case models.Suspended:
+			reason = "suspended environment"
+		default:
+			reason = "least recently active environment"
+		}
+		out = append(out, Victim{Env: e, Reason: fmt.Sprintf("%v (last activity %v)", reason, e.LastActivity().Format(time.RFC3339))})
+	}
+	return out
+}
+
+// StatusGetter describes an object that returns environments by status
+type StatusGetter interface {
+	GetQAEnvironmentsByStatus(ctx context.Context, status string) ([]models.QAEnvironment, error)
+}
+
+// Candidates returns the environments that are eligible for eviction under p, in ascending order of creation
+func Candidates(ctx context.Context, sg StatusGetter, p Policy) ([]models.QAEnvironment, error) {
+	out := []models.QAEnvironment{}
+	for _, s := range p.Statuses() {
+		envs, err := sg.GetQAEnvironmentsByStatus(ctx, s.String())
+		if err != nil {
+			return nil, fmt.Errorf("error getting environments with status %v: %w", s, err)
+		}
+		out = append(out, envs...)
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].Created.Before(out[j].Created) })
+	return out, nil
+}
+
+// Eviction describes why an environment was evicted
+type Eviction struct {
+	Policy string
+	Reason string
+}
+
+func (e Eviction) String() string {
+	return fmt.Sprintf("evicted by %v policy: %v", e.Policy, e.Reason)
+}
+
+type evictionCtxKey struct{}
+
+// NewEvictionContext returns a context containing e, which is used to explain the eviction in notifications
+func NewEvictionContext(ctx context.Context, e Eviction) context.Context {
+	return context.WithValue(ctx, evictionCtxKey{}, e)
+}
+
+// GetEviction returns the eviction stored in ctx, if any
+func GetEviction(ctx context.Context) (Eviction, bool) {
+	e, ok := ctx.Value(evictionCtxKey{}).(Eviction)
+	return e, ok
+}
@@ -0,0 +1,136 @@
+package eviction
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/dollarshaveclub/acyl/pkg/models"
+	"github.com/dollarshaveclub/acyl/pkg/persistence"
+)
+
+func testEnvs(now time.Time) []models.QAEnvironment {
+	pinned := now.Add(time.Hour)
+	expired := now.Add(-time.Hour)
+	accessed := now.Add(-10 * time.Minute)
+	return []models.QAEnvironment{
+		// the oldest environment is a long-running demo that is pinned
+		{Name: "demo", Status: models.Success, Created: now.Add(-30 * 24 * time.Hour), PinnedUntil: &pinned},
+		{Name: "old-but-active", Status: models.Success, Created: now.Add(-10 * 24 * time.Hour), LastAccessed: &accessed},
+		{Name: "idle", Status: models.Success, Created: now.Add(-5 * 24 * time.Hour), PinnedUntil: &expired},
+		{Name: "pushed", Status: models.Updating, Created: now.Add(-6 * 24 * time.Hour), Events: []models.QAEnvironmentEvent{{Timestamp: now.Add(-time.Hour)}}},
+		{Name: "suspended", Status: models.Suspended, Created: now.Add(-2 * 24 * time.Hour)},
+		{Name: "failed", Status: models.Failure, Created: now.Add(-time.Hour)},
+	}
+}
+
+func victimNames(victims []Victim) []string {
+	out := []string{}
+	for _, v := range victims {
+		out = append(out, v.Env.Name)
+	}
+	return out
+}
+
+func TestActivityPolicyVictims(t *testing.T) {
+	now := time.Now().UTC()
+	victims := ActivityPolicy{}.Victims(testEnvs(now), 10, now)
+	want := []string{"failed", "suspended", "idle", "pushed", "old-but-active"}
+	if got := victimNames(victims); len(got) != len(want) {
+		t.Fatalf("bad victims: %v (wanted %v)", got, want)
+	} else {
+		for i := range want {
+			if got[i] != want[i] {
+				t.Fatalf("bad victims: %v (wanted %v)", got, want)
+			}
+		}
+	}
+	if victims[0].Reason == "" {
+		t.Fatalf("victims should have a reason")
+	}
+	if n := len(ActivityPolicy{}.Victims(testEnvs(now), 2, now)); n != 2 {
+		t.Fatalf("should have returned two victims: %v", n)
+	}
+}
+
+func TestSelect(t *testing.T) {
+	now := time.Now().UTC()
+	envs := testEnvs(now)
+	if n := CountRunning(envs); n != 4 {
+		t.Fatalf("bad running count: %v", n)
+	}
+	// failed and suspended environments are preferred but don't count towards the running environments to evict
+	victims, running := Select(ActivityPolicy{}, envs, 1, now)
+	if got := victimNames(victims); running != 1 || len(got) != 3 || got[0] != "failed" || got[1] != "suspended" || got[2] != "idle" {
+		t.Fatalf("bad victims: %v (running: %v)", got, running)
+	}
+	// not enough running environments are eligible (the demo is pinned)
+	victims, running = Select(ActivityPolicy{}, envs, 4, now)
+	if running != 3 || len(victims) != 5 {
+		t.Fatalf("bad victims: %v (running: %v)", victimNames(victims), running)
+	}
+	if victims, running := Select(ActivityPolicy{}, envs, 0, now); running != 0 || len(victims) != 0 {
+		t.Fatalf("should have selected no victims: %v", victimNames(victims))
+	}
+}
+
+func TestOldestPolicyVictims(t *testing.T) {
+	now := time.Now().UTC()
+	victims := OldestPolicy{}.Victims(testEnvs(now), 2, now)
+	got := victimNames(victims)
+	if len(got) != 2 || got[0] != "old-but-active" || got[1] != "pushed" {
+		t.Fatalf("bad victims: %v", got)
+	}
+}
+
+func TestNewPolicy(t *testing.T) {
+	for name, want := range map[string]string{"": ActivityPolicyName, "activity": ActivityPolicyName, "oldest": OldestPolicyName} {
+		p, err := NewPolicy(name)
+		if err != nil {
+			t.Fatalf("should have succeeded: %v", err)
+		}
+		if p.Name() != want {
+			t.Fatalf("bad policy for %q: %v", name, p.Name())
+		}
+	}
+	if _, err := NewPolicy("random"); err == nil {
+		t.Fatalf("should have failed")
+	}
+}
+
+func TestCandidates(t *testing.T) {
+	dl := persistence.NewFakeDataLayer()
+	now := time.Now().UTC()
+	for _, e := range append(testEnvs(now), models.QAEnvironment{Name: "destroyed", Status: models.Destroyed, Created: now}) {
+		e := e
+		dl.CreateQAEnvironment(context.Background(), &e)
+	}
+	envs, err := Candidates(context.Background(), dl, ActivityPolicy{})
+	if err != nil {
+		t.Fatalf("should have succeeded: %v", err)
+	}
+	if len(envs) != 6 {
+		t.Fatalf("bad activity candidates: %v", len(envs))
+	}
+	if envs[0].Name != "demo" {
+		t.Fatalf("candidates should be sorted by creation: %v", envs[0].Name)
+	}
+	envs, err = Candidates(context.Background(), dl, OldestPolicy{})
+	if err != nil {
+		t.Fatalf("should have succeeded: %v", err)
+	}
+	if len(envs) != 4 {
+		t.Fatalf("bad oldest candidates: %v", len(envs))
+	}
+}
+
+func TestEvictionContext(t *testing.T) {
+	if _, ok := GetEviction(context.Background()); ok {
+		t.Fatalf("empty context should not have an eviction")
+	}
+	ctx := NewEvictionContext(context.Background(), Eviction{Policy: ActivityPolicyName, Reason: "failed environment"})
+	e, ok := GetEviction(ctx)
+	if !ok || e.Policy != ActivityPolicyName {
+		t.Fatalf("bad eviction: %+v", e)
+	}
+}
@@ -2,13 +2,22 @@ package ghapp
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
 
+	"github.com/google/go-github/github"
+	"github.com/google/uuid"
 	"github.com/palantir/go-githubapp/githubapp"
+	"github.com/pkg/errors"
 )
 
-// checksEventHandler handles check events, stubbed out for now
+// checksEventHandler handles check events
+// Check runs are created by Acyl for each environment operation with the eventlog ID as the external ID.
+// When a user re-requests one of these check runs, the callback is executed to rebuild the environment.
 type checksEventHandler struct {
 	githubapp.ClientCreator
+	RerequestCallback CheckRunCallback
 }
 
 func (ch *checksEventHandler) Handles() []string {
@@ -16,5 +25,53 @@ func (ch *checksEventHandler) Handles() []string {
 }
 
 func (ch *checksEventHandler) Handle(ctx context.Context, eventType, deliveryID string, payload []byte) error {
+
+	// response is used when a non-default response is needed
+	response := func(status int, msg string, ctype string) {
+		githubapp.SetResponder(ctx, func(w http.ResponseWriter, r *http.Request) {
+			if ctype != "" {
+				w.Header().Add("Content-Type", ctype)
+			}
+			w.WriteHeader(status)
+			w.Write([]byte(msg))
+		})
+	}
+
+	// check suites are ignored
+	if eventType != "check_run" {
+		return nil
+	}
+
+	var event github.CheckRunEvent
+	if err := json.Unmarshal(payload, &event); err != nil {
+		response(http.StatusBadRequest, fmt.Sprintf("error unmarshaling event: %v", err), "")
+		return errors.Wrap(err, "error unmarshaling event")
+	}
+
+	if event.GetAction() != "rerequested" {
+		response(http.StatusOK, "action not relevant: "+event.GetAction(), "")
+		return nil
+	}
+
+	if ch.RerequestCallback == nil {
+		response(http.StatusOK, "check run rerequests not supported", "")
+		return nil
+	}
+
+	// check runs not created by us won't have a valid eventlog ID
+	elid, err := uuid.Parse(event.GetCheckRun().GetExternalID())
+	if err != nil {
+		response(http.StatusOK, "check run not relevant", "")
+		return nil
+	}
+
+	ctx = NewGitHubClientContext(ctx, event.GetInstallation().GetID(), ch)
+
+	id, err := ch.RerequestCallback(ctx, elid)
+	if err != nil {
+		response(http.StatusInternalServerError, fmt.Sprintf(`{"error_details":"%v"}`, err), "application/json")
+		return err
+	}
+	response(http.StatusAccepted, fmt.Sprintf(`{"event_log_id": "%v"}`, id.String()), "application/json")
 	return nil
 }
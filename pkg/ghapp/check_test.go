@@ -0,0 +1,154 @@
+package ghapp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/google/go-github/github"
+	"github.com/google/uuid"
+	"github.com/palantir/go-githubapp/githubapp"
+)
+
+func Test_checksEventHandler_Handle(t *testing.T) {
+	str := func(s string) *string { return &s }
+	elid := uuid.Must(uuid.NewRandom())
+	basee := github.CheckRunEvent{
+		Action: str("rerequested"),
+		CheckRun: &github.CheckRun{
+			ExternalID: str(elid.String()),
+		},
+		Repo: &github.Repository{
+			FullName: str("foo/bar"),
+		},
+	}
+	createde := basee
+	createde.Action = str("created")
+	foreigne := basee
+	foreigne.CheckRun = &github.CheckRun{ExternalID: str("some-other-ci")}
+	var called bool
+	basecb := func(ctx context.Context, eventLogID uuid.UUID) (uuid.UUID, error) {
+		called = true
+		if eventLogID != elid {
+			return uuid.Nil, fmt.Errorf("bad event log id: %v", eventLogID)
+		}
+		if GetGitHubInstallationClient(ctx, nil) == nil {
+			return uuid.Nil, fmt.Errorf("missing installation client")
+		}
+		return uuid.NewRandom()
+	}
+	errcb := func(ctx context.Context, eventLogID uuid.UUID) (uuid.UUID, error) {
+		called = true
+		return uuid.Nil, fmt.Errorf("env not found")
+	}
+	type args struct {
+		eventType string
+		payload   github.CheckRunEvent
+		raw       []byte
+		cb        CheckRunCallback
+	}
+	tests := []struct {
+		name       string
+		args       args
+		wantCalled bool
+		wantErr    bool
+		wantErrStr string
+	}{
+		{
+			name: "rerequested",
+			args: args{
+				eventType: "check_run",
+				payload:   basee,
+				cb:        basecb,
+			},
+			wantCalled: true,
+		},
+		{
+			name: "created",
+			args: args{
+				eventType: "check_run",
+				payload:   createde,
+				cb:        basecb,
+			},
+		},
+		{
+			name: "foreign check run",
+			args: args{
+				eventType: "check_run",
+				payload:   foreigne,
+				cb:        basecb,
+			},
+		},
+		{
+			name: "check suite",
+			args: args{
+				eventType: "check_suite",
+				raw:       []byte(`{"action":"rerequested"}`),
+				cb:        basecb,
+			},
+		},
+		{
+			name: "no callback",
+			args: args{
+				eventType: "check_run",
+				payload:   basee,
+			},
+		},
+		{
+			name: "callback error",
+			args: args{
+				eventType: "check_run",
+				payload:   basee,
+				cb:        errcb,
+			},
+			wantCalled: true,
+			wantErr:    true,
+			wantErrStr: "env not found",
+		},
+		{
+			name: "invalid payload",
+			args: args{
+				eventType: "check_run",
+				raw:       []byte("invalid"),
+				cb:        basecb,
+			},
+			wantErr:    true,
+			wantErrStr: "error unmarshaling event",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			called = false
+			c := githubapp.Config{}
+			c.App.IntegrationID = 10
+			c.App.PrivateKey = key
+			cc, _ := githubapp.NewDefaultCachingClientCreator(c)
+			ch := &checksEventHandler{
+				ClientCreator:     cc,
+				RerequestCallback: tt.args.cb,
+			}
+			p := tt.args.raw
+			if p == nil {
+				j, err := json.Marshal(&tt.args.payload)
+				if err != nil {
+					t.Fatalf("error marshaling payload: %v", err)
+				}
+				p = j
+			}
+			ctx := githubapp.InitializeResponder(context.Background())
+			err := ch.Handle(ctx, tt.args.eventType, uuid.Must(uuid.NewRandom()).String(), p)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("checksEventHandler.Handle() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr && err != nil && !strings.Contains(err.Error(), tt.wantErrStr) {
+				t.Errorf("unexpected error: %v (wanted containing %v)", err, tt.wantErrStr)
+			}
+			if called != tt.wantCalled {
+				t.Errorf("callback called: %v, wanted %v", called, tt.wantCalled)
+			}
+		})
+	}
+}
@@ -0,0 +1,46 @@
+package ghapp
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/google/go-github/v38/github"
+	"github.com/pkg/errors"
+)
+
+// splitRepo splits a repo name like "owner/repo" into owner and repo
+func splitRepo(repo string) (string, string, error) {
+	rs := strings.Split(repo, "/")
+	if len(rs) != 2 {
+		return "", "", fmt.Errorf("malformed repo: %v", repo)
+	}
+	return rs[0], rs[1], nil
+}
+
+// userCanWrite returns whether user has write (or admin) access to repo
+func userCanWrite(ctx context.Context, ghc *github.Client, repo, user string) (bool, error) {
+	owner, name, err := splitRepo(repo)
+	if err != nil {
+		return false, err
+	}
+	perm, _, err := ghc.Repositories.GetPermissionLevel(ctx, owner, name, user)
+	if err != nil {
+		return false, errors.Wrap(err, "error getting user permission")
+	}
+	switch perm.GetPermission() {
+	case "admin", "write":
+		return true, nil
+	}
+	return false, nil
+}
+
+// createComment posts a new comment with body on issue or PR number in repo
+func createComment(ctx context.Context, ghc *github.Client, repo string, number int, body string) error {
+	owner, name, err := splitRepo(repo)
+	if err != nil {
+		return err
+	}
+	_, _, err = ghc.Issues.CreateComment(ctx, owner, name, number, &github.IssueComment{Body: &body})
+	return err
+}
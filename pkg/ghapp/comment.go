@@ -0,0 +1,235 @@
+package ghapp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/dollarshaveclub/acyl/pkg/eventlogger"
+	"github.com/dollarshaveclub/acyl/pkg/models"
+	"github.com/dollarshaveclub/acyl/pkg/persistence"
+	"github.com/google/go-github/github"
+	"github.com/google/uuid"
+	"github.com/palantir/go-githubapp/githubapp"
+	"github.com/pkg/errors"
+)
+
+// CommentCommandPrefix is the prefix of PR comment lines that are parsed as commands
+const CommentCommandPrefix = "/acyl"
+
+// Supported PR comment commands
+const (
+	RebuildCommand = "rebuild"
+	DestroyCommand = "destroy"
+	StatusCommand  = "status"
+	// ApproveCommand approves building an environment for a PR from a fork
+	ApproveCommand = "approve"
+	// ExtendCommand extends the lifetime of the environment
+	ExtendCommand = "extend"
+)
+
+var commentCommands = map[string]struct{}{
+	RebuildCommand: struct{}{},
+	DestroyCommand: struct{}{},
+	StatusCommand:  struct{}{},
+	ApproveCommand: struct{}{},
+	ExtendCommand:  struct{}{},
+}
+
+var commentCommandUsage = fmt.Sprintf("Supported commands: `%[1]v %[2]v`, `%[1]v %[3]v`, `%[1]v %[4]v`, `%[1]v %[5]v`, `%[1]v %[6]v`", CommentCommandPrefix, RebuildCommand, DestroyCommand, StatusCommand, ApproveCommand, ExtendCommand)
+
+// parseCommentCommand returns the command from the first comment line beginning with CommentCommandPrefix
+// If no line begins with the prefix, ok is false. If the command is missing or unknown, command is empty and ok is true.
+func parseCommentCommand(body string) (command string, ok bool) {
+	for _, line := range strings.Split(body, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || fields[0] != CommentCommandPrefix {
+			continue
+		}
+		if len(fields) < 2 {
+			return "", true
+		}
+		cmd := strings.ToLower(fields[1])
+		if _, valid := commentCommands[cmd]; !valid {
+			return "", true
+		}
+		return cmd, true
+	}
+	return "", false
+}
+
+// issueCommentEventHandler handles PR comment commands
+type issueCommentEventHandler struct {
+	githubapp.ClientCreator
+	dl              persistence.DataLayer
+	CommandCallback CommentCommandCallback
+}
+
+// Handles specifies the type of events handled
+func (ich *issueCommentEventHandler) Handles() []string {
+	return []string{"issue_comment"}
+}
+
+// Handle is called by the handler when an event is received
+// Newly created PR comments are parsed for commands. If the commenter has write access to the repo, the PR details are fetched,
+// the context is set up with an eventlogger and GH client factory, the callback is executed and the result is posted as a reply comment.
+func (ich *issueCommentEventHandler) Handle(ctx context.Context, eventType, deliveryID string, payload []byte) error {
+
+	// response is used when a non-default response is needed
+	response := func(status int, msg string, ctype string) {
+		githubapp.SetResponder(ctx, func(w http.ResponseWriter, r *http.Request) {
+			if ctype != "" {
+				w.Header().Add("Content-Type", ctype)
+			}
+			w.WriteHeader(status)
+			w.Write([]byte(msg))
+		})
+	}
+
+	if eventType != "issue_comment" {
+		return errors.New("not an issue comment event")
+	}
+
+	var event github.IssueCommentEvent
+	if err := json.Unmarshal(payload, &event); err != nil {
+		response(http.StatusBadRequest, fmt.Sprintf("error unmarshaling event: %v", err), "")
+		return errors.Wrap(err, "error unmarshaling event")
+	}
+
+	if event.GetAction() != "created" {
+		response(http.StatusOK, "action not relevant: "+event.GetAction(), "")
+		return nil
+	}
+
+	// comments on issues don't have the pull request links
+	if event.GetIssue().GetPullRequestLinks() == nil {
+		response(http.StatusOK, "not a pull request comment", "")
+		return nil
+	}
+
+	// ignore bots (including ourselves) to avoid reply loops
+	if event.GetSender().GetType() == "Bot" {
+		response(http.StatusOK, "ignoring comment from bot", "")
+		return nil
+	}
+
+	command, ok := parseCommentCommand(event.GetComment().GetBody())
+	if !ok {
+		response(http.StatusOK, "no command found", "")
+		return nil
+	}
+
+	if ich.CommandCallback == nil {
+		response(http.StatusOK, "comment commands not supported", "")
+		return nil
+	}
+
+	did, err := uuid.Parse(deliveryID)
+	if err != nil {
+		response(http.StatusBadRequest, fmt.Sprintf("malformed delivery id: %v", err), "")
+		return errors.Wrap(err, "malformed delivery id")
+	}
+
+	repo := event.GetRepo().GetFullName()
+	rs := strings.Split(repo, "/")
+	if len(rs) != 2 {
+		response(http.StatusBadRequest, "malformed repo: "+repo, "")
+		return fmt.Errorf("malformed repo: %v", repo)
+	}
+	user := event.GetComment().GetUser().GetLogin()
+	prnum := event.GetIssue().GetNumber()
+
+	ctx = NewGitHubClientContext(ctx, event.GetInstallation().GetID(), ich)
+	ghc := GetGitHubInstallationClient(ctx, nil)
+	if ghc == nil {
+		response(http.StatusInternalServerError, `{"error_details":"error getting installation client"}`, "application/json")
+		return errors.New("error getting installation client")
+	}
+
+	reply := func(body string) error {
+		body = fmt.Sprintf("@%v %v", user, body)
+		return errors.Wrap(createComment(ctx, ghc, repo, prnum, body), "error creating reply comment")
+	}
+
+	if command == "" {
+		if err := reply(commentCommandUsage); err != nil {
+			response(http.StatusInternalServerError, fmt.Sprintf(`{"error_details":"%v"}`, err), "application/json")
+			return err
+		}
+		response(http.StatusOK, "unknown command", "")
+		return nil
+	}
+
+	canWrite, err := userCanWrite(ctx, ghc, repo, user)
+	if err != nil {
+		response(http.StatusInternalServerError, fmt.Sprintf(`{"error_details":"%v"}`, err), "application/json")
+		return err
+	}
+	if !canWrite {
+		if err := reply(fmt.Sprintf("you need write access to %v to run `%v %v`", repo, CommentCommandPrefix, command)); err != nil {
+			response(http.StatusInternalServerError, fmt.Sprintf(`{"error_details":"%v"}`, err), "application/json")
+			return err
+		}
+		response(http.StatusOK, "insufficient permissions", "")
+		return nil
+	}
+
+	pr, _, err := ghc.PullRequests.Get(ctx, rs[0], rs[1], prnum)
+	if err != nil {
+		response(http.StatusInternalServerError, fmt.Sprintf(`{"error_details":"error getting pull request: %v"}`, err), "application/json")
+		return errors.Wrap(err, "error getting pull request")
+	}
+
+	rrd := models.RepoRevisionData{
+		BaseBranch:   pr.GetBase().GetRef(),
+		BaseSHA:      pr.GetBase().GetSHA(),
+		PullRequest:  uint(pr.GetNumber()),
+		Repo:         pr.GetBase().GetRepo().GetFullName(),
+		SourceBranch: pr.GetHead().GetRef(),
+		SourceRef:    pr.GetHead().GetRef(),
+		SourceSHA:    pr.GetHead().GetSHA(),
+		User:         pr.GetUser().GetLogin(),
+		IsFork:       pr.GetHead().GetRepo().GetFork(),
+	}
+
+	elogger, err := ich.getlogger(payload, did, rrd.Repo, rrd.PullRequest)
+	if err != nil {
+		return errors.Wrap(err, "error getting event logger")
+	}
+	ctx = eventlogger.NewEventLoggerContext(ctx, elogger)
+	elogger.Printf("processing PR comment command from %v: %v", user, command)
+
+	msg, err := ich.CommandCallback(ctx, command, rrd)
+	if err != nil {
+		msg = fmt.Sprintf("error processing `%v %v`: %v", CommentCommandPrefix, command, err)
+	}
+	if err2 := reply(msg); err2 != nil {
+		elogger.Printf("error posting reply comment: %v", err2)
+	}
+	if err != nil {
+		response(http.StatusInternalServerError, fmt.Sprintf(`{"error_details":"%v"}`, err), "application/json")
+	} else {
+		response(http.StatusAccepted, fmt.Sprintf(`{"event_log_id": "%v"}`, elogger.ID.String()), "application/json")
+	}
+	return err
+}
+
+func (ich *issueCommentEventHandler) getlogger(body []byte, deliveryID uuid.UUID, repo string, pr uint) (*eventlogger.Logger, error) {
+	id, err := uuid.NewRandom()
+	if err != nil {
+		return nil, errors.Wrap(err, "error getting random UUID")
+	}
+	logger := &eventlogger.Logger{
+		ID:         id,
+		DeliveryID: deliveryID,
+		DL:         ich.dl,
+		Sink:       os.Stdout,
+	}
+	if err := logger.Init(body, repo, pr); err != nil {
+		return nil, errors.Wrap(err, "error initializing event logger")
+	}
+	return logger, nil
+}
@@ -0,0 +1,258 @@
+package ghapp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/dollarshaveclub/acyl/pkg/eventlogger"
+	"github.com/dollarshaveclub/acyl/pkg/models"
+	"github.com/dollarshaveclub/acyl/pkg/persistence"
+	"github.com/google/go-github/github"
+	"github.com/google/uuid"
+	"github.com/palantir/go-githubapp/githubapp"
+)
+
+func TestParseCommentCommand(t *testing.T) {
+	tests := []struct {
+		body    string
+		wantCmd string
+		wantOK  bool
+	}{
+		{"/acyl rebuild", RebuildCommand, true},
+		{"looks good\n\n  /acyl DESTROY please", DestroyCommand, true},
+		{"/acyl status\n/acyl destroy", StatusCommand, true},
+		{"/acyl approve", ApproveCommand, true},
+		{"/acyl extend", ExtendCommand, true},
+		{"/acyl", "", true},
+		{"/acyl frobnicate", "", true},
+		{"please run /acyl rebuild", "", false},
+		{"/acylrebuild", "", false},
+		{"", "", false},
+	}
+	for _, tt := range tests {
+		cmd, ok := parseCommentCommand(tt.body)
+		if cmd != tt.wantCmd || ok != tt.wantOK {
+			t.Errorf("parseCommentCommand(%q) = %q, %v (wanted %q, %v)", tt.body, cmd, ok, tt.wantCmd, tt.wantOK)
+		}
+	}
+}
+
+// fakeGitHubAPI returns a test server implementing the subset of the GitHub API used by the issue comment handler
+// Reply comments are sent to the returned channel
+func fakeGitHubAPI(t *testing.T, permission string, fork bool) (*httptest.Server, chan string) {
+	replies := make(chan string, 10)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/app/installations/1/access_tokens", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"token":"asdf","expires_at":"2100-01-01T00:00:00Z"}`))
+	})
+	mux.HandleFunc("/repos/foo/bar/collaborators/john.doe/permission", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(fmt.Sprintf(`{"permission":"%v"}`, permission)))
+	})
+	mux.HandleFunc("/repos/foo/bar/pulls/1", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(fmt.Sprintf(`{"number":1,"user":{"login":"jane.doe"},"base":{"ref":"main","sha":"5678","repo":{"full_name":"foo/bar"}},"head":{"ref":"feature","sha":"1234","repo":{"fork":%v}}}`, fork)))
+	})
+	mux.HandleFunc("/repos/foo/bar/issues/1/comments", func(w http.ResponseWriter, r *http.Request) {
+		ic := github.IssueComment{}
+		if err := json.NewDecoder(r.Body).Decode(&ic); err != nil {
+			t.Errorf("error decoding comment: %v", err)
+		}
+		replies <- ic.GetBody()
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{}`))
+	})
+	return httptest.NewServer(mux), replies
+}
+
+func Test_issueCommentEventHandler_Handle(t *testing.T) {
+	str := func(s string) *string { return &s }
+	i64 := func(i int64) *int64 { return &i }
+	num := func(i int) *int { return &i }
+	basee := github.IssueCommentEvent{
+		Action: str("created"),
+		Issue: &github.Issue{
+			Number:           num(1),
+			PullRequestLinks: &github.PullRequestLinks{URL: str("https://api.github.com/repos/foo/bar/pulls/1")},
+		},
+		Comment: &github.IssueComment{
+			Body: str("/acyl rebuild"),
+			User: &github.User{Login: str("john.doe")},
+		},
+		Repo:         &github.Repository{FullName: str("foo/bar")},
+		Sender:       &github.User{Login: str("john.doe"), Type: str("User")},
+		Installation: &github.Installation{ID: i64(1)},
+	}
+	issuee := basee
+	issuee.Issue = &github.Issue{Number: num(1)}
+	edite := basee
+	edite.Action = str("edited")
+	bote := basee
+	bote.Sender = &github.User{Login: str("acyl[bot]"), Type: str("Bot")}
+	nocmde := basee
+	nocmde.Comment = &github.IssueComment{Body: str("looks good"), User: &github.User{Login: str("john.doe")}}
+	unknowne := basee
+	unknowne.Comment = &github.IssueComment{Body: str("/acyl frobnicate"), User: &github.User{Login: str("john.doe")}}
+	var called bool
+	basecb := func(ctx context.Context, command string, rrd models.RepoRevisionData) (string, error) {
+		called = true
+		if command != RebuildCommand {
+			return "", fmt.Errorf("bad command: %v", command)
+		}
+		if rrd.Repo != "foo/bar" || rrd.PullRequest != 1 {
+			return "", fmt.Errorf("bad repo/pr: %+v", rrd)
+		}
+		if rrd.SourceBranch != "feature" || rrd.SourceSHA != "1234" || rrd.BaseBranch != "main" || rrd.BaseSHA != "5678" {
+			return "", fmt.Errorf("bad revision: %+v", rrd)
+		}
+		if rrd.User != "jane.doe" {
+			return "", fmt.Errorf("bad user: %v", rrd.User)
+		}
+		if eventlogger.GetLogger(ctx).ID == uuid.Nil {
+			return "", fmt.Errorf("missing eventlogger")
+		}
+		return "rebuilding", nil
+	}
+	forkcb := func(ctx context.Context, command string, rrd models.RepoRevisionData) (string, error) {
+		called = true
+		if !rrd.IsFork {
+			return "", fmt.Errorf("should be a fork")
+		}
+		return "approved", nil
+	}
+	approvee := basee
+	approvee.Comment = &github.IssueComment{Body: str("/acyl approve"), User: &github.User{Login: str("john.doe")}}
+	errcb := func(ctx context.Context, command string, rrd models.RepoRevisionData) (string, error) {
+		called = true
+		return "", fmt.Errorf("something bad happened")
+	}
+	tests := []struct {
+		name       string
+		event      github.IssueCommentEvent
+		permission string
+		fork       bool
+		cb         CommentCommandCallback
+		wantCalled bool
+		wantReply  string
+		wantErr    bool
+	}{
+		{
+			name:       "rebuild",
+			event:      basee,
+			permission: "write",
+			cb:         basecb,
+			wantCalled: true,
+			wantReply:  "@john.doe rebuilding",
+		},
+		{
+			name:       "callback error",
+			event:      basee,
+			permission: "admin",
+			cb:         errcb,
+			wantCalled: true,
+			wantReply:  "something bad happened",
+			wantErr:    true,
+		},
+		{
+			name:       "insufficient permission",
+			event:      basee,
+			permission: "read",
+			cb:         basecb,
+			wantReply:  "you need write access",
+		},
+		{
+			name:       "fork approval",
+			event:      approvee,
+			permission: "write",
+			fork:       true,
+			cb:         forkcb,
+			wantCalled: true,
+			wantReply:  "@john.doe approved",
+		},
+		{
+			name:       "fork approval insufficient permission",
+			event:      approvee,
+			permission: "triage",
+			fork:       true,
+			cb:         forkcb,
+			wantReply:  "you need write access",
+		},
+		{
+			name:       "unknown command",
+			event:      unknowne,
+			permission: "write",
+			cb:         basecb,
+			wantReply:  "Supported commands",
+		},
+		{
+			name:       "issue comment",
+			event:      issuee,
+			permission: "write",
+			cb:         basecb,
+		},
+		{
+			name:       "edited",
+			event:      edite,
+			permission: "write",
+			cb:         basecb,
+		},
+		{
+			name:       "bot",
+			event:      bote,
+			permission: "write",
+			cb:         basecb,
+		},
+		{
+			name:       "no command",
+			event:      nocmde,
+			permission: "write",
+			cb:         basecb,
+		},
+		{
+			name:       "no callback",
+			event:      basee,
+			permission: "write",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			called = false
+			srv, replies := fakeGitHubAPI(t, tt.permission, tt.fork)
+			defer srv.Close()
+			c := githubapp.Config{}
+			c.V3APIURL = srv.URL + "/"
+			c.App.IntegrationID = 10
+			c.App.PrivateKey = key
+			cc, _ := githubapp.NewDefaultCachingClientCreator(c, githubapp.WithTransport(http.DefaultTransport))
+			ich := &issueCommentEventHandler{
+				ClientCreator:   cc,
+				dl:              persistence.NewFakeDataLayer(),
+				CommandCallback: tt.cb,
+			}
+			p, err := json.Marshal(&tt.event)
+			if err != nil {
+				t.Fatalf("error marshaling payload: %v", err)
+			}
+			ctx := githubapp.InitializeResponder(context.Background())
+			err = ich.Handle(ctx, "issue_comment", uuid.Must(uuid.NewRandom()).String(), p)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("issueCommentEventHandler.Handle() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if called != tt.wantCalled {
+				t.Errorf("callback called: %v, wanted %v", called, tt.wantCalled)
+			}
+			close(replies)
+			reply := <-replies
+			if tt.wantReply == "" && reply != "" {
+				t.Errorf("unexpected reply: %v", reply)
+			}
+			if !strings.Contains(reply, tt.wantReply) {
+				t.Errorf("reply %q should contain %q", reply, tt.wantReply)
+			}
+		})
+	}
+}
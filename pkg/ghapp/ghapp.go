@@ -10,23 +10,61 @@ import (
 	"github.com/dollarshaveclub/acyl/pkg/models"
 
 	"github.com/dollarshaveclub/acyl/pkg/persistence"
+	"github.com/google/uuid"
 	"github.com/palantir/go-githubapp/githubapp"
 	"github.com/pkg/errors"
 )
 
+// PREventDetails contains the PR webhook event details needed to evaluate the repo trigger labels and auto-create policy
+type PREventDetails struct {
+	// Label is the label that was added or removed ("labeled" and "unlabeled" actions only)
+	Label string
+	// Labels are the current labels of the PR
+	Labels []string
+	// Draft is whether the PR is a draft
+	Draft bool
+	// Sender is the user that triggered the event
+	Sender string
+	// SenderCanWrite is whether Sender has write access to the repo (only checked for "labeled" actions on PRs from forks)
+	SenderCanWrite bool
+}
+
 // PRCallback is a function that gets called when a validated, parsed PR webhook event is received
 // - action is the PR webhook action string ("opened", "closed", "labeled", etc), see https://developer.github.com/v3/activity/events/types/#pullrequestevent
 // - rrd is the parsed repo/revision information from the webhook payload
+// - details are the PR labels and draft status, the callback is responsible for determining whether the event is relevant to the repo
 // - ctx is pre-populated with an eventlogger and authenticated GitHub clients (app and installation)
 // If the callback returns a non-nil error, the webhook request client will be returned a 500 error with the error details in the body
 // If the error is nil, the webhook client will be returned a 202 Accepted response with the eventlog ID
-type PRCallback func(ctx context.Context, action string, rrd models.RepoRevisionData) error
+type PRCallback func(ctx context.Context, action string, rrd models.RepoRevisionData, details PREventDetails) error
+
+// PushCallback is a function that gets called when a validated, parsed branch push webhook event is received
+// - rrd is the parsed repo/revision information from the webhook payload, with the pushed branch as both source and base and no PR number
+// - ctx is pre-populated with an eventlogger and authenticated GitHub clients (app and installation)
+// Error handling and responses are the same as PRCallback
+type PushCallback func(ctx context.Context, rrd models.RepoRevisionData) error
+
+// CheckRunCallback is a function that gets called when a user re-requests a check run created by Acyl
+// - eventLogID is the external ID of the check run, which is the eventlog ID of the environment operation that created it
+// - ctx is pre-populated with authenticated GitHub clients (app and installation)
+// It returns the eventlog ID of the new operation, which is returned to the webhook client with a 202 Accepted response
+type CheckRunCallback func(ctx context.Context, eventLogID uuid.UUID) (uuid.UUID, error)
+
+// CommentCommandCallback is a function that gets called when a user with write access to the repo posts a command on a PR (see CommentCommandPrefix)
+// - command is one of RebuildCommand, DestroyCommand, StatusCommand, ApproveCommand or ExtendCommand
+// - rrd is the repo/revision information of the PR, fetched when the comment is received
+// - ctx is pre-populated with an eventlogger and authenticated GitHub clients (app and installation)
+// It returns the markdown body of the reply comment that is posted on the PR.
+// If the callback returns a non-nil error, the error is posted as the reply instead and the webhook client will be returned a 500 error
+type CommentCommandCallback func(ctx context.Context, command string, rrd models.RepoRevisionData) (string, error)
 
 // GitHubApp implements a GitHub app
 type GitHubApp struct {
 	cfg githubapp.Config
 	prh *prEventHandler
+	ph  *pushEventHandler
 	ch  *checksEventHandler
+	ich *issueCommentEventHandler
 }
 
 var (
@@ -37,7 +75,10 @@ var (
 // NewGitHubApp returns a GitHubApp with the given private key, app ID and webhook secret, or error
 // supportedPRActions is at least one PR webhook action that is supported (prcallback will be executed).
 // Any unsupported actions will be ignored and the webhook request will be responded with 200 OK, "action not relevant".
-func NewGitHubApp(privateKeyPEM []byte, appID uint, webhookSecret string, supportedPRActions []string, prcb PRCallback, dl persistence.DataLayer) (*GitHubApp, error) {
+// pushcb is optional and is executed for branch push events (if nil, push events will be ignored).
+// crcb is optional and is executed when a check run is re-requested (if nil, check run events will be ignored).
+// cmdcb is optional and is executed for PR comment commands (if nil, issue comment events will be ignored).
+func NewGitHubApp(privateKeyPEM []byte, appID uint, webhookSecret string, supportedPRActions []string, prcb PRCallback, pushcb PushCallback, crcb CheckRunCallback, cmdcb CommentCommandCallback, dl persistence.DataLayer) (*GitHubApp, error) {
 	if len(privateKeyPEM) == 0 {
 		return nil, errors.New("invalid private key")
 	}
@@ -85,8 +126,19 @@ func NewGitHubApp(privateKeyPEM []byte, appID uint, webhookSecret string, suppor
 			supportedPRActions: sa,
 			RRDCallback:        prcb,
 		},
-		ch: &checksEventHandler{
+		ph: &pushEventHandler{
 			ClientCreator: cc,
+			dl:            dl,
+			PushCallback:  pushcb,
+		},
+		ch: &checksEventHandler{
+			ClientCreator:     cc,
+			RerequestCallback: crcb,
+		},
+		ich: &issueCommentEventHandler{
+			ClientCreator:   cc,
+			dl:              dl,
+			CommandCallback: cmdcb,
 		},
 		cfg: c,
 	}, nil
@@ -95,7 +147,7 @@ func NewGitHubApp(privateKeyPEM []byte, appID uint, webhookSecret string, suppor
 // Handler returns the http.Handler that should handle the webhook HTTP endpoint
 func (gha *GitHubApp) Handler() http.Handler {
 	return githubapp.NewEventDispatcher(
-		[]githubapp.EventHandler{gha.prh, gha.ch},
+		[]githubapp.EventHandler{gha.prh, gha.ph, gha.ch, gha.ich},
 		gha.cfg.App.WebhookSecret,
 		githubapp.WithErrorCallback(func(w http.ResponseWriter, r *http.Request, err error) {
 			w.WriteHeader(http.StatusInternalServerError)
@@ -75,38 +75,19 @@ func (prh *prEventHandler) Handle(ctx context.Context, eventType, deliveryID str
 	}
 	action := event.GetAction()
 
-	if rrd.IsFork {
-		response(http.StatusOK, "ignoring event from forked HEAD repo", "")
-		return nil
-	}
-
 	_, ok := prh.supportedPRActions[action]
 	if !ok {
 		response(http.StatusOK, "action not relevant: "+action, "")
 		return nil
 	}
 
-	// Check if label is "acyl"
-	switch action {
-	case "labeled", "unlabeled":
-		label := event.Label.GetName()
-		if label != "acyl" {
-			response(http.StatusOK, "label not relevant: "+label, "")
-			return nil
-		}
-	case "synchronize":
-		labels := event.PullRequest.Labels
-		acyl := false
-		for _, label := range labels {
-			if label.GetName() == "acyl" {
-				acyl = true
-				break
-			}
-		}
-		if !acyl {
-			response(http.StatusOK, "not synchronizing acyl untagged repo", "")
-			return nil
-		}
+	details := PREventDetails{
+		Label:  event.GetLabel().GetName(),
+		Draft:  event.GetPullRequest().GetDraft(),
+		Sender: event.GetSender().GetLogin(),
+	}
+	for _, l := range event.GetPullRequest().Labels {
+		details.Labels = append(details.Labels, l.GetName())
 	}
 
 	elogger, err := prh.getlogger(payload, did, rrd.Repo, rrd.PullRequest)
@@ -118,7 +99,21 @@ func (prh *prEventHandler) Handle(ctx context.Context, eventType, deliveryID str
 	ctx = eventlogger.NewEventLoggerContext(ctx, elogger)
 	ctx = NewGitHubClientContext(ctx, event.GetInstallation().GetID(), prh)
 
-	err = prh.RRDCallback(ctx, action, rrd)
+	// labels on fork PRs are only trusted if they were added by a user with write access (bots, including ourselves, are never trusted)
+	if rrd.IsFork && action == "labeled" && event.GetSender().GetType() != "Bot" {
+		ghc := GetGitHubInstallationClient(ctx, nil)
+		if ghc == nil {
+			response(http.StatusInternalServerError, `{"error_details":"error getting installation client"}`, "application/json")
+			return errors.New("error getting installation client")
+		}
+		details.SenderCanWrite, err = userCanWrite(ctx, ghc, rrd.Repo, details.Sender)
+		if err != nil {
+			response(http.StatusInternalServerError, fmt.Sprintf(`{"error_details":"%v"}`, err), "application/json")
+			return err
+		}
+	}
+
+	err = prh.RRDCallback(ctx, action, rrd, details)
 	if err != nil {
 		response(http.StatusInternalServerError, fmt.Sprintf(`{"error_details":"%v"}`, err), "application/json")
 	} else {
@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"net/http"
 	"strings"
 	"testing"
 
@@ -88,7 +89,7 @@ func Test_prEventHandler_Handle(t *testing.T) {
 	forkp := basep
 	forkp.PullRequest.Head.Repo.FullName = str("someotherowner/bar")
 	forkp.PullRequest.Head.Repo.Fork = boolp(true)
-	basecb := func(ctx context.Context, action string, rrd models.RepoRevisionData) error {
+	basecb := func(ctx context.Context, action string, rrd models.RepoRevisionData, details PREventDetails) error {
 		if rrd.Repo != "foo/bar" {
 			return fmt.Errorf("bad repo: %v", rrd.Repo)
 		}
@@ -122,11 +123,11 @@ func Test_prEventHandler_Handle(t *testing.T) {
 			args: args{
 				deliveryID: uuid.Must(uuid.NewRandom()).String(),
 				payload:    basep,
-				cb: func(ctx context.Context, action string, rrd models.RepoRevisionData) error {
+				cb: func(ctx context.Context, action string, rrd models.RepoRevisionData, details PREventDetails) error {
 					if action != "opened" {
 						return fmt.Errorf("bad action: %v", action)
 					}
-					return basecb(ctx, action, rrd)
+					return basecb(ctx, action, rrd, details)
 				},
 			},
 		},
@@ -135,11 +136,11 @@ func Test_prEventHandler_Handle(t *testing.T) {
 			args: args{
 				deliveryID: uuid.Must(uuid.NewRandom()).String(),
 				payload:    reopenedp,
-				cb: func(ctx context.Context, action string, rrd models.RepoRevisionData) error {
+				cb: func(ctx context.Context, action string, rrd models.RepoRevisionData, details PREventDetails) error {
 					if action != "reopened" {
 						return fmt.Errorf("bad action: %v", action)
 					}
-					return basecb(ctx, action, rrd)
+					return basecb(ctx, action, rrd, details)
 				},
 			},
 		},
@@ -148,11 +149,11 @@ func Test_prEventHandler_Handle(t *testing.T) {
 			args: args{
 				deliveryID: uuid.Must(uuid.NewRandom()).String(),
 				payload:    updatep,
-				cb: func(ctx context.Context, action string, rrd models.RepoRevisionData) error {
+				cb: func(ctx context.Context, action string, rrd models.RepoRevisionData, details PREventDetails) error {
 					if action != "synchronize" {
 						return fmt.Errorf("bad action: %v", action)
 					}
-					return basecb(ctx, action, rrd)
+					return basecb(ctx, action, rrd, details)
 				},
 			},
 		},
@@ -161,11 +162,11 @@ func Test_prEventHandler_Handle(t *testing.T) {
 			args: args{
 				deliveryID: uuid.Must(uuid.NewRandom()).String(),
 				payload:    closedp,
-				cb: func(ctx context.Context, action string, rrd models.RepoRevisionData) error {
+				cb: func(ctx context.Context, action string, rrd models.RepoRevisionData, details PREventDetails) error {
 					if action != "closed" {
 						return fmt.Errorf("bad action: %v", action)
 					}
-					return basecb(ctx, action, rrd)
+					return basecb(ctx, action, rrd, details)
 				},
 			},
 		},
@@ -228,3 +229,141 @@ func Test_prEventHandler_Handle(t *testing.T) {
 		})
 	}
 }
+
+func Test_prEventHandler_HandleDetails(t *testing.T) {
+	str := func(s string) *string { return &s }
+	intp := func(i int) *int { return &i }
+	boolp := func(b bool) *bool { return &b }
+	event := github.PullRequestEvent{
+		Action: str("labeled"),
+		Number: intp(1),
+		Label:  &github.Label{Name: str("preview")},
+		PullRequest: &github.PullRequest{
+			Number: intp(1),
+			Draft:  boolp(true),
+			Labels: []*github.Label{&github.Label{Name: str("preview")}, &github.Label{Name: str("bug")}},
+			User:   &github.User{Login: str("john.doe")},
+			Head: &github.PullRequestBranch{
+				Repo: &github.Repository{FullName: str("foo/bar"), Fork: boolp(false)},
+				Ref:  str("feature"),
+				SHA:  str("1234"),
+			},
+			Base: &github.PullRequestBranch{
+				Repo: &github.Repository{FullName: str("foo/bar")},
+				Ref:  str("main"),
+				SHA:  str("5678"),
+			},
+		},
+	}
+	var got *PREventDetails
+	c := githubapp.Config{}
+	c.App.IntegrationID = 10
+	c.App.PrivateKey = key
+	cc, _ := githubapp.NewDefaultCachingClientCreator(c)
+	prh := &prEventHandler{
+		ClientCreator:      cc,
+		dl:                 persistence.NewFakeDataLayer(),
+		supportedPRActions: map[string]struct{}{"labeled": struct{}{}},
+		RRDCallback: func(ctx context.Context, action string, rrd models.RepoRevisionData, details PREventDetails) error {
+			got = &details
+			return nil
+		},
+	}
+	p, err := json.Marshal(&event)
+	if err != nil {
+		t.Fatalf("error marshaling payload: %v", err)
+	}
+	ctx := githubapp.InitializeResponder(context.Background())
+	if err := prh.Handle(ctx, "pull_request", uuid.Must(uuid.NewRandom()).String(), p); err != nil {
+		t.Fatalf("should have succeeded: %v", err)
+	}
+	if got == nil {
+		t.Fatalf("callback should have been called")
+	}
+	if got.Label != "preview" || !got.Draft || len(got.Labels) != 2 || got.Labels[0] != "preview" || got.Labels[1] != "bug" {
+		t.Errorf("bad details: %+v", *got)
+	}
+}
+
+func Test_prEventHandler_HandleForkSender(t *testing.T) {
+	str := func(s string) *string { return &s }
+	intp := func(i int) *int { return &i }
+	boolp := func(b bool) *bool { return &b }
+	i64 := func(i int64) *int64 { return &i }
+	basee := github.PullRequestEvent{
+		Action: str("labeled"),
+		Number: intp(1),
+		Label:  &github.Label{Name: str("acyl-approved")},
+		PullRequest: &github.PullRequest{
+			Number: intp(1),
+			User:   &github.User{Login: str("jane.doe")},
+			Head: &github.PullRequestBranch{
+				Repo: &github.Repository{FullName: str("jane.doe/bar"), Fork: boolp(true)},
+				Ref:  str("feature"),
+				SHA:  str("1234"),
+			},
+			Base: &github.PullRequestBranch{
+				Repo: &github.Repository{FullName: str("foo/bar")},
+				Ref:  str("main"),
+				SHA:  str("5678"),
+			},
+		},
+		Sender:       &github.User{Login: str("john.doe"), Type: str("User")},
+		Installation: &github.Installation{ID: i64(1)},
+	}
+	bote := basee
+	bote.Sender = &github.User{Login: str("acyl[bot]"), Type: str("Bot")}
+	tests := []struct {
+		name         string
+		event        github.PullRequestEvent
+		permission   string
+		wantCanWrite bool
+	}{
+		{"maintainer", basee, "write", true},
+		{"contributor", basee, "read", false},
+		{"bot", bote, "admin", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, _ := fakeGitHubAPI(t, tt.permission, true)
+			defer srv.Close()
+			c := githubapp.Config{}
+			c.V3APIURL = srv.URL + "/"
+			c.App.IntegrationID = 10
+			c.App.PrivateKey = key
+			cc, _ := githubapp.NewDefaultCachingClientCreator(c, githubapp.WithTransport(http.DefaultTransport))
+			var got *PREventDetails
+			var gotrrd models.RepoRevisionData
+			prh := &prEventHandler{
+				ClientCreator:      cc,
+				dl:                 persistence.NewFakeDataLayer(),
+				supportedPRActions: map[string]struct{}{"labeled": struct{}{}},
+				RRDCallback: func(ctx context.Context, action string, rrd models.RepoRevisionData, details PREventDetails) error {
+					got = &details
+					gotrrd = rrd
+					return nil
+				},
+			}
+			p, err := json.Marshal(&tt.event)
+			if err != nil {
+				t.Fatalf("error marshaling payload: %v", err)
+			}
+			ctx := githubapp.InitializeResponder(context.Background())
+			if err := prh.Handle(ctx, "pull_request", uuid.Must(uuid.NewRandom()).String(), p); err != nil {
+				t.Fatalf("should have succeeded: %v", err)
+			}
+			if got == nil {
+				t.Fatalf("callback should have been called")
+			}
+			if !gotrrd.IsFork {
+				t.Errorf("rrd should be a fork")
+			}
+			if got.Sender != tt.event.GetSender().GetLogin() {
+				t.Errorf("bad sender: %v", got.Sender)
+			}
+			if got.SenderCanWrite != tt.wantCanWrite {
+				t.Errorf("SenderCanWrite: %v, wanted %v", got.SenderCanWrite, tt.wantCanWrite)
+			}
+		})
+	}
+}
@@ -0,0 +1,127 @@
+package ghapp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/dollarshaveclub/acyl/pkg/eventlogger"
+	"github.com/dollarshaveclub/acyl/pkg/models"
+	"github.com/dollarshaveclub/acyl/pkg/persistence"
+	"github.com/google/go-github/github"
+	"github.com/google/uuid"
+	"github.com/palantir/go-githubapp/githubapp"
+	"github.com/pkg/errors"
+)
+
+const branchRefPrefix = "refs/heads/"
+
+// pushEventHandler is a ClientCreator that handles push webhook events
+type pushEventHandler struct {
+	githubapp.ClientCreator
+	dl           persistence.DataLayer
+	PushCallback PushCallback
+}
+
+// Handles specifies the type of events handled
+func (ph *pushEventHandler) Handles() []string {
+	return []string{"push"}
+}
+
+// Handle is called by the handler when an event is received
+// The push event handler ignores tag pushes and branch deletions. Otherwise it validates the webhook,
+// sets up the context with appropriate eventlogger and GH client factory and then executes the callback
+func (ph *pushEventHandler) Handle(ctx context.Context, eventType, deliveryID string, payload []byte) error {
+
+	// response is used when a non-default response is needed
+	response := func(status int, msg string, ctype string) {
+		githubapp.SetResponder(ctx, func(w http.ResponseWriter, r *http.Request) {
+			if ctype != "" {
+				w.Header().Add("Content-Type", ctype)
+			}
+			w.WriteHeader(status)
+			w.Write([]byte(msg))
+		})
+	}
+
+	if eventType != "push" {
+		return errors.New("not a push event")
+	}
+
+	var event github.PushEvent
+	if err := json.Unmarshal(payload, &event); err != nil {
+		response(http.StatusBadRequest, fmt.Sprintf("error unmarshaling event: %v", err), "")
+		return errors.Wrap(err, "error unmarshaling event")
+	}
+
+	did, err := uuid.Parse(deliveryID)
+	if err != nil {
+		response(http.StatusBadRequest, fmt.Sprintf("malformed delivery id: %v", err), "")
+		return errors.Wrap(err, "malformed delivery id")
+	}
+
+	if ph.PushCallback == nil {
+		response(http.StatusOK, "push events not supported", "")
+		return nil
+	}
+
+	if !strings.HasPrefix(event.GetRef(), branchRefPrefix) {
+		response(http.StatusOK, "ref not relevant: "+event.GetRef(), "")
+		return nil
+	}
+
+	if event.GetDeleted() {
+		response(http.StatusOK, "ignoring branch deletion", "")
+		return nil
+	}
+
+	branch := strings.TrimPrefix(event.GetRef(), branchRefPrefix)
+
+	// tracked branch environments are not associated with a PR, so base and source refer to the same branch
+	rrd := models.RepoRevisionData{
+		BaseBranch:   branch,
+		BaseSHA:      event.GetAfter(),
+		Repo:         event.GetRepo().GetFullName(),
+		SourceBranch: branch,
+		SourceRef:    branch,
+		SourceSHA:    event.GetAfter(),
+		User:         event.GetSender().GetLogin(),
+	}
+
+	elogger, err := ph.getlogger(payload, did, rrd.Repo)
+	if err != nil {
+		return errors.Wrap(err, "error getting event logger")
+	}
+
+	// set up context
+	ctx = eventlogger.NewEventLoggerContext(ctx, elogger)
+	ctx = NewGitHubClientContext(ctx, event.GetInstallation().GetID(), ph)
+
+	err = ph.PushCallback(ctx, rrd)
+	if err != nil {
+		response(http.StatusInternalServerError, fmt.Sprintf(`{"error_details":"%v"}`, err), "application/json")
+	} else {
+		response(http.StatusAccepted, fmt.Sprintf(`{"event_log_id": "%v"}`, eventlogger.GetLogger(ctx).ID.String()), "application/json")
+	}
+	return err
+}
+
+func (ph *pushEventHandler) getlogger(body []byte, deliveryID uuid.UUID, repo string) (*eventlogger.Logger, error) {
+	id, err := uuid.NewRandom()
+	if err != nil {
+		return nil, errors.Wrap(err, "error getting random UUID")
+	}
+	logger := &eventlogger.Logger{
+		ID:         id,
+		DeliveryID: deliveryID,
+		DL:         ph.dl,
+		Sink:       os.Stdout,
+	}
+	if err := logger.Init(body, repo, 0); err != nil {
+		return nil, errors.Wrap(err, "error initializing event logger")
+	}
+	return logger, nil
+}
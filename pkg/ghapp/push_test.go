@@ -0,0 +1,161 @@
+package ghapp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/dollarshaveclub/acyl/pkg/eventlogger"
+	"github.com/dollarshaveclub/acyl/pkg/models"
+	"github.com/dollarshaveclub/acyl/pkg/persistence"
+	"github.com/google/go-github/github"
+	"github.com/google/uuid"
+	"github.com/palantir/go-githubapp/githubapp"
+)
+
+func Test_pushEventHandler_Handle(t *testing.T) {
+	str := func(s string) *string { return &s }
+	boolp := func(b bool) *bool { return &b }
+	basep := github.PushEvent{
+		Ref:   str("refs/heads/main"),
+		After: str("1234"),
+		Repo: &github.PushEventRepository{
+			FullName: str("foo/bar"),
+		},
+		Sender: &github.User{
+			Login: str("john.doe"),
+		},
+	}
+	tagp := basep
+	tagp.Ref = str("refs/tags/v1.0.0")
+	deletedp := basep
+	deletedp.Deleted = boolp(true)
+	var called bool
+	basecb := func(ctx context.Context, rrd models.RepoRevisionData) error {
+		called = true
+		if rrd.Repo != "foo/bar" {
+			return fmt.Errorf("bad repo: %v", rrd.Repo)
+		}
+		if rrd.PullRequest != 0 {
+			return fmt.Errorf("bad pr: %v", rrd.PullRequest)
+		}
+		if rrd.SourceBranch != "main" || rrd.BaseBranch != "main" || rrd.SourceRef != "main" {
+			return fmt.Errorf("bad branch: %+v", rrd)
+		}
+		if rrd.SourceSHA != "1234" {
+			return fmt.Errorf("bad sha: %v", rrd.SourceSHA)
+		}
+		if rrd.User != "john.doe" {
+			return fmt.Errorf("bad user: %v", rrd.User)
+		}
+		if eventlogger.GetLogger(ctx).ID == uuid.Nil {
+			return fmt.Errorf("missing eventlogger")
+		}
+		if GetGitHubInstallationClient(ctx, nil) == nil {
+			return fmt.Errorf("missing installation client")
+		}
+		return nil
+	}
+	type args struct {
+		deliveryID string
+		payload    github.PushEvent
+		raw        []byte
+		cb         PushCallback
+	}
+	tests := []struct {
+		name       string
+		args       args
+		wantCalled bool
+		wantErr    bool
+		wantErrStr string
+	}{
+		{
+			name: "branch push",
+			args: args{
+				deliveryID: uuid.Must(uuid.NewRandom()).String(),
+				payload:    basep,
+				cb:         basecb,
+			},
+			wantCalled: true,
+		},
+		{
+			name: "tag push",
+			args: args{
+				deliveryID: uuid.Must(uuid.NewRandom()).String(),
+				payload:    tagp,
+				cb:         basecb,
+			},
+		},
+		{
+			name: "branch deleted",
+			args: args{
+				deliveryID: uuid.Must(uuid.NewRandom()).String(),
+				payload:    deletedp,
+				cb:         basecb,
+			},
+		},
+		{
+			name: "no callback",
+			args: args{
+				deliveryID: uuid.Must(uuid.NewRandom()).String(),
+				payload:    basep,
+			},
+		},
+		{
+			name: "invalid payload",
+			args: args{
+				deliveryID: uuid.Must(uuid.NewRandom()).String(),
+				raw:        []byte("invalid"),
+				cb:         basecb,
+			},
+			wantErr:    true,
+			wantErrStr: "error unmarshaling event",
+		},
+		{
+			name: "invalid delivery id",
+			args: args{
+				deliveryID: "asdf",
+				payload:    basep,
+				cb:         basecb,
+			},
+			wantErr:    true,
+			wantErrStr: "malformed delivery id",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			called = false
+			c := githubapp.Config{}
+			c.App.IntegrationID = 10
+			c.App.PrivateKey = key
+			cc, _ := githubapp.NewDefaultCachingClientCreator(c)
+			ph := &pushEventHandler{
+				ClientCreator: cc,
+				dl:            persistence.NewFakeDataLayer(),
+				PushCallback:  tt.args.cb,
+			}
+			p := tt.args.raw
+			if p == nil {
+				j, err := json.Marshal(&tt.args.payload)
+				if err != nil {
+					t.Fatalf("error marshaling payload: %v", err)
+				}
+				p = j
+			}
+			ctx := githubapp.InitializeResponder(context.Background())
+			err := ph.Handle(ctx, "push", tt.args.deliveryID, p)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("pushEventHandler.Handle() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr && err != nil && !strings.Contains(err.Error(), tt.wantErrStr) {
+				t.Errorf("unexpected error: %v (wanted containing %v)", err, tt.wantErrStr)
+			}
+			if called != tt.wantCalled {
+				t.Errorf("callback called: %v, wanted %v", called, tt.wantCalled)
+			}
+		})
+	}
+}
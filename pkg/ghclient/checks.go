@@ -0,0 +1,146 @@
+package ghclient
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v38/github"
+)
+
+// CheckRunClient describes a GitHub client that can publish check runs
+// Check runs can only be created using GitHub App installation credentials
+type CheckRunClient interface {
+	SetCheckRun(ctx context.Context, repo string, cr *CheckRun) error
+}
+
+// CheckRun describes a check run associated with a commit
+// Check runs are identified by the combination of HeadSHA, Name and ExternalID
+type CheckRun struct {
+	Name        string
+	HeadSHA     string
+	ExternalID  string
+	DetailsURL  string
+	Status      string // "queued", "in_progress" or "completed"
+	Conclusion  string // required if Status is "completed": "success", "failure", "cancelled", etc
+	Title       string
+	Summary     string // markdown
+	Text        string // markdown
+	Annotations []CheckRunAnnotation
+}
+
+// CheckRunAnnotation describes an annotation on a file line in the repo
+type CheckRunAnnotation struct {
+	Path               string
+	StartLine, EndLine int
+	Level              string // "notice", "warning" or "failure"
+	Title              string
+	Message            string
+	RawDetails         string
+}
+
+// MaxCheckRunAnnotations is the maximum number of annotations that may be submitted in a single request
+const MaxCheckRunAnnotations = 50
+
+var _ CheckRunClient = &GitHubClient{}
+
+func (cr *CheckRun) output() *github.CheckRunOutput {
+	out := &github.CheckRunOutput{
+		Title:   github.String(cr.Title),
+		Summary: github.String(cr.Summary),
+	}
+	if cr.Text != "" {
+		out.Text = github.String(cr.Text)
+	}
+	for i, a := range cr.Annotations {
+		if i >= MaxCheckRunAnnotations {
+			break
+		}
+		ann := &github.CheckRunAnnotation{
+			Path:            github.String(a.Path),
+			StartLine:       github.Int(a.StartLine),
+			EndLine:         github.Int(a.EndLine),
+			AnnotationLevel: github.String(a.Level),
+			Message:         github.String(a.Message),
+		}
+		if a.Title != "" {
+			ann.Title = github.String(a.Title)
+		}
+		if a.RawDetails != "" {
+			ann.RawDetails = github.String(a.RawDetails)
+		}
+		out.Annotations = append(out.Annotations, ann)
+	}
+	return out
+}
+
+// SetCheckRun creates or updates a check run on repo.
+// If a check run already exists for the commit with the same name and external ID it is updated, otherwise a new one is created.
+func (ghc *GitHubClient) SetCheckRun(ctx context.Context, repo string, cr *CheckRun) error {
+	rs := strings.Split(repo, "/")
+	if len(rs) != 2 {
+		return fmt.Errorf("malformed repo: %v", repo)
+	}
+	if cr == nil {
+		return fmt.Errorf("check run is nil")
+	}
+	ctx, cf := context.WithTimeout(ctx, ghTimeout)
+	defer cf()
+	client := ghc.getClient(ctx)
+	res, _, err := client.Checks.ListCheckRunsForRef(ctx, rs[0], rs[1], cr.HeadSHA, &github.ListCheckRunsOptions{
+		CheckName:   github.String(cr.Name),
+		Filter:      github.String("all"),
+		ListOptions: github.ListOptions{PerPage: 100},
+	})
+	if err != nil {
+		return fmt.Errorf("error listing check runs: %v", err)
+	}
+	var id int64
+	for _, r := range res.CheckRuns {
+		if r.GetExternalID() == cr.ExternalID {
+			id = r.GetID()
+			break
+		}
+	}
+	var completed *github.Timestamp
+	var conclusion *string
+	if cr.Status == "completed" {
+		completed = &github.Timestamp{Time: time.Now().UTC()}
+		conclusion = github.String(cr.Conclusion)
+	}
+	var durl *string
+	if cr.DetailsURL != "" {
+		durl = github.String(cr.DetailsURL)
+	}
+	if id == 0 {
+		_, _, err = client.Checks.CreateCheckRun(ctx, rs[0], rs[1], github.CreateCheckRunOptions{
+			Name:        cr.Name,
+			HeadSHA:     cr.HeadSHA,
+			DetailsURL:  durl,
+			ExternalID:  github.String(cr.ExternalID),
+			Status:      github.String(cr.Status),
+			Conclusion:  conclusion,
+			StartedAt:   &github.Timestamp{Time: time.Now().UTC()},
+			CompletedAt: completed,
+			Output:      cr.output(),
+		})
+		if err != nil {
+			return fmt.Errorf("error creating check run: %v", err)
+		}
+		return nil
+	}
+	_, _, err = client.Checks.UpdateCheckRun(ctx, rs[0], rs[1], id, github.UpdateCheckRunOptions{
+		Name:        cr.Name,
+		DetailsURL:  durl,
+		ExternalID:  github.String(cr.ExternalID),
+		Status:      github.String(cr.Status),
+		Conclusion:  conclusion,
+		CompletedAt: completed,
+		Output:      cr.output(),
+	})
+	if err != nil {
+		return fmt.Errorf("error updating check run: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package ghclient
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/google/go-github/v38/github"
+)
+
+// PRCommentClient describes a GitHub client that can read and write PR comments
+type PRCommentClient interface {
+	ListPRComments(ctx context.Context, repo string, pr uint) ([]PRComment, error)
+	CreatePRComment(ctx context.Context, repo string, pr uint, body string) (PRComment, error)
+	EditPRComment(ctx context.Context, repo string, id int64, body string) error
+}
+
+// PRComment models a comment on a pull request
+type PRComment struct {
+	ID   int64
+	User string
+	Body string
+}
+
+var _ PRCommentClient = &GitHubClient{}
+
+// ListPRComments returns all comments on the PR (in ascending order of creation)
+func (ghc *GitHubClient) ListPRComments(ctx context.Context, repo string, pr uint) ([]PRComment, error) {
+	rs := strings.Split(repo, "/")
+	if len(rs) != 2 {
+		return nil, fmt.Errorf("malformed repo: %v", repo)
+	}
+	output := []PRComment{}
+	lopt := &github.IssueListCommentsOptions{ListOptions: github.ListOptions{PerPage: 100}}
+	for {
+		ctx, cf := context.WithTimeout(ctx, ghTimeout)
+		cmts, resp, err := ghc.getClient(ctx).Issues.ListComments(ctx, rs[0], rs[1], int(pr), lopt)
+		cf()
+		if err != nil {
+			return nil, fmt.Errorf("error listing comments: %v", err)
+		}
+		for _, c := range cmts {
+			output = append(output, PRComment{
+				ID:   c.GetID(),
+				User: c.GetUser().GetLogin(),
+				Body: c.GetBody(),
+			})
+		}
+		if resp.NextPage == 0 {
+			break
+		}
+		lopt.Page = resp.NextPage
+	}
+	return output, nil
+}
+
+// CreatePRComment adds a new comment to the PR
+func (ghc *GitHubClient) CreatePRComment(ctx context.Context, repo string, pr uint, body string) (PRComment, error) {
+	rs := strings.Split(repo, "/")
+	if len(rs) != 2 {
+		return PRComment{}, fmt.Errorf("malformed repo: %v", repo)
+	}
+	ctx, cf := context.WithTimeout(ctx, ghTimeout)
+	defer cf()
+	c, _, err := ghc.getClient(ctx).Issues.CreateComment(ctx, rs[0], rs[1], int(pr), &github.IssueComment{Body: &body})
+	if err != nil {
+		return PRComment{}, fmt.Errorf("error creating comment: %v", err)
+	}
+	return PRComment{ID: c.GetID(), User: c.GetUser().GetLogin(), Body: c.GetBody()}, nil
+}
+
+// EditPRComment replaces the body of an existing comment
+func (ghc *GitHubClient) EditPRComment(ctx context.Context, repo string, id int64, body string) error {
+	rs := strings.Split(repo, "/")
+	if len(rs) != 2 {
+		return fmt.Errorf("malformed repo: %v", repo)
+	}
+	ctx, cf := context.WithTimeout(ctx, ghTimeout)
+	defer cf()
+	if _, _, err := ghc.getClient(ctx).Issues.EditComment(ctx, rs[0], rs[1], id, &github.IssueComment{Body: &body}); err != nil {
+		return fmt.Errorf("error editing comment: %v", err)
+	}
+	return nil
+}
+
+// GetAuthenticatedUser returns the login of the user the client is authenticated as, which is the author of comments it creates
+func (ghc *GitHubClient) GetAuthenticatedUser(ctx context.Context) (string, error) {
+	ctx, cf := context.WithTimeout(ctx, ghTimeout)
+	defer cf()
+	u, _, err := ghc.getClient(ctx).Users.Get(ctx, "")
+	if err != nil {
+		return "", fmt.Errorf("error getting authenticated user: %v", err)
+	}
+	return u.GetLogin(), nil
+}
@@ -17,10 +17,25 @@ type FakeRepoClient struct {
 	GetUserFunc                   func(ctx context.Context) (string, error)
 	GetUserAppRepoPermissionsFunc func(ctx context.Context, instID int64) (map[string]AppRepoPermissions, error)
 	GetRepoArchiveFunc            func(ctx context.Context, repo, ref string) (string, error)
+	ListPRCommentsFunc            func(ctx context.Context, repo string, pr uint) ([]PRComment, error)
+	CreatePRCommentFunc           func(ctx context.Context, repo string, pr uint, body string) (PRComment, error)
+	EditPRCommentFunc             func(ctx context.Context, repo string, id int64, body string) error
+	SetCheckRunFunc               func(ctx context.Context, repo string, cr *CheckRun) error
+	AddPRLabelFunc                func(ctx context.Context, repo string, pr uint, label string) error
+	RemovePRLabelFunc             func(ctx context.Context, repo string, pr uint, label string) error
+	GetRepoPermissionFunc         func(ctx context.Context, repo, user string) (string, error)
+	GetPRFunc                     func(ctx context.Context, repo string, pr uint) (PRInfo, error)
+	ResolveRefFunc                func(ctx context.Context, repo, ref string) (RefInfo, error)
 }
 
 var _ RepoClient = &FakeRepoClient{}
 var _ GitHubAppInstallationClient = &FakeRepoClient{}
+var _ PRCommentClient = &FakeRepoClient{}
+var _ CheckRunClient = &FakeRepoClient{}
+var _ PRLabelClient = &FakeRepoClient{}
+var _ RepoPermissionClient = &FakeRepoClient{}
+var _ PRClient = &FakeRepoClient{}
+var _ RefClient = &FakeRepoClient{}
 
 func (frc *FakeRepoClient) GetBranch(ctx context.Context, repo string, branch string) (BranchInfo, error) {
 	if frc.GetBranchFunc != nil {
@@ -106,6 +121,69 @@ func (frc *FakeRepoClient) GetRepoArchive(ctx context.Context, repo, ref string)
 	return "foo.tar.gz", nil
 }
 
+func (frc *FakeRepoClient) ListPRComments(ctx context.Context, repo string, pr uint) ([]PRComment, error) {
+	if frc.ListPRCommentsFunc != nil {
+		return frc.ListPRCommentsFunc(ctx, repo, pr)
+	}
+	return []PRComment{}, nil
+}
+
+func (frc *FakeRepoClient) CreatePRComment(ctx context.Context, repo string, pr uint, body string) (PRComment, error) {
+	if frc.CreatePRCommentFunc != nil {
+		return frc.CreatePRCommentFunc(ctx, repo, pr, body)
+	}
+	return PRComment{ID: 1, Body: body}, nil
+}
+
+func (frc *FakeRepoClient) EditPRComment(ctx context.Context, repo string, id int64, body string) error {
+	if frc.EditPRCommentFunc != nil {
+		return frc.EditPRCommentFunc(ctx, repo, id, body)
+	}
+	return nil
+}
+
+func (frc *FakeRepoClient) SetCheckRun(ctx context.Context, repo string, cr *CheckRun) error {
+	if frc.SetCheckRunFunc != nil {
+		return frc.SetCheckRunFunc(ctx, repo, cr)
+	}
+	return nil
+}
+
+func (frc *FakeRepoClient) AddPRLabel(ctx context.Context, repo string, pr uint, label string) error {
+	if frc.AddPRLabelFunc != nil {
+		return frc.AddPRLabelFunc(ctx, repo, pr, label)
+	}
+	return nil
+}
+
+func (frc *FakeRepoClient) RemovePRLabel(ctx context.Context, repo string, pr uint, label string) error {
+	if frc.RemovePRLabelFunc != nil {
+		return frc.RemovePRLabelFunc(ctx, repo, pr, label)
+	}
+	return nil
+}
+
+func (frc *FakeRepoClient) GetRepoPermission(ctx context.Context, repo, user string) (string, error) {
+	if frc.GetRepoPermissionFunc != nil {
+		return frc.GetRepoPermissionFunc(ctx, repo, user)
+	}
+	return "", nil
+}
+
+func (frc *FakeRepoClient) GetPR(ctx context.Context, repo string, pr uint) (PRInfo, error) {
+	if frc.GetPRFunc != nil {
+		return frc.GetPRFunc(ctx, repo, pr)
+	}
+	return PRInfo{}, nil
+}
+
+func (frc *FakeRepoClient) ResolveRef(ctx context.Context, repo, ref string) (RefInfo, error) {
+	if frc.ResolveRefFunc != nil {
+		return frc.ResolveRefFunc(ctx, repo, ref)
+	}
+	return RefInfo{}, nil
+}
+
 type FakeRepoAppClient struct {
 	GetInstallationTokenForRepoFunc func(ctx context.Context, instID int64, reponame string) (string, error)
 }
@@ -0,0 +1,48 @@
+package ghclient
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// PRLabelClient describes a GitHub client that can add and remove PR labels
+type PRLabelClient interface {
+	AddPRLabel(ctx context.Context, repo string, pr uint, label string) error
+	RemovePRLabel(ctx context.Context, repo string, pr uint, label string) error
+}
+
+var _ PRLabelClient = &GitHubClient{}
+
+// AddPRLabel adds label to the PR, creating the label in the repo if necessary
+func (ghc *GitHubClient) AddPRLabel(ctx context.Context, repo string, pr uint, label string) error {
+	rs := strings.Split(repo, "/")
+	if len(rs) != 2 {
+		return fmt.Errorf("malformed repo: %v", repo)
+	}
+	ctx, cf := context.WithTimeout(ctx, ghTimeout)
+	defer cf()
+	if _, _, err := ghc.getClient(ctx).Issues.AddLabelsToIssue(ctx, rs[0], rs[1], int(pr), []string{label}); err != nil {
+		return fmt.Errorf("error adding label: %v", err)
+	}
+	return nil
+}
+
+// RemovePRLabel removes label from the PR. It is not an error if the PR doesn't have the label.
+func (ghc *GitHubClient) RemovePRLabel(ctx context.Context, repo string, pr uint, label string) error {
+	rs := strings.Split(repo, "/")
+	if len(rs) != 2 {
+		return fmt.Errorf("malformed repo: %v", repo)
+	}
+	ctx, cf := context.WithTimeout(ctx, ghTimeout)
+	defer cf()
+	resp, err := ghc.getClient(ctx).Issues.RemoveLabelForIssue(ctx, rs[0], rs[1], int(pr), label)
+	if err != nil {
+		if resp != nil && resp.StatusCode == http.StatusNotFound {
+			return nil
+		}
+		return fmt.Errorf("error removing label: %v", err)
+	}
+	return nil
+}
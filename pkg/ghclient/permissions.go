@@ -0,0 +1,38 @@
+package ghclient
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// RepoPermissionClient describes a GitHub client that can get the permission level of a user on a repo
+type RepoPermissionClient interface {
+	GetRepoPermission(ctx context.Context, repo, user string) (string, error)
+}
+
+var _ RepoPermissionClient = &GitHubClient{}
+
+// GetRepoPermission returns the permission level of user on repo ("admin", "write", "read" or "none")
+func (ghc *GitHubClient) GetRepoPermission(ctx context.Context, repo, user string) (string, error) {
+	rs := strings.Split(repo, "/")
+	if len(rs) != 2 {
+		return "", fmt.Errorf("malformed repo: %v", repo)
+	}
+	ctx, cf := context.WithTimeout(ctx, ghTimeout)
+	defer cf()
+	perm, _, err := ghc.getClient(ctx).Repositories.GetPermissionLevel(ctx, rs[0], rs[1], user)
+	if err != nil {
+		return "", fmt.Errorf("error getting permission level: %v", err)
+	}
+	return perm.GetPermission(), nil
+}
+
+// CanWrite returns whether a permission level returned by GetRepoPermission allows pushing to the repo
+func CanWrite(permission string) bool {
+	switch permission {
+	case "admin", "write":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,50 @@
+package ghclient
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// PRInfo models the details of a pull request needed to create an environment
+type PRInfo struct {
+	Number     uint
+	User       string
+	State      string
+	BaseBranch string
+	BaseSHA    string
+	HeadBranch string
+	HeadSHA    string
+	IsFork     bool
+}
+
+// PRClient describes a GitHub client that can get pull request details
+type PRClient interface {
+	GetPR(ctx context.Context, repo string, pr uint) (PRInfo, error)
+}
+
+var _ PRClient = &GitHubClient{}
+
+// GetPR returns the details of a pull request
+func (ghc *GitHubClient) GetPR(ctx context.Context, repo string, pr uint) (PRInfo, error) {
+	rs := strings.Split(repo, "/")
+	if len(rs) != 2 {
+		return PRInfo{}, fmt.Errorf("malformed repo: %v", repo)
+	}
+	ctx, cf := context.WithTimeout(ctx, ghTimeout)
+	defer cf()
+	pullreq, _, err := ghc.getClient(ctx).PullRequests.Get(ctx, rs[0], rs[1], int(pr))
+	if err != nil {
+		return PRInfo{}, fmt.Errorf("error getting pull request: %v", err)
+	}
+	return PRInfo{
+		Number:     uint(pullreq.GetNumber()),
+		User:       pullreq.GetUser().GetLogin(),
+		State:      pullreq.GetState(),
+		BaseBranch: pullreq.GetBase().GetRef(),
+		BaseSHA:    pullreq.GetBase().GetSHA(),
+		HeadBranch: pullreq.GetHead().GetRef(),
+		HeadSHA:    pullreq.GetHead().GetSHA(),
+		IsFork:     pullreq.GetHead().GetRepo().GetFork(),
+	}, nil
+}
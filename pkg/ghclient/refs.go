@@ -0,0 +1,85 @@
+package ghclient
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/google/go-github/v38/github"
+)
+
+// RefKind is the type of git ref a RefInfo was resolved from
+type RefKind string
+
+const (
+	BranchRef RefKind = "branch"
+	TagRef    RefKind = "tag"
+	CommitRef RefKind = "commit"
+)
+
+// RefInfo models a git ref resolved to a commit
+type RefInfo struct {
+	Kind RefKind
+	// SHA is the commit the ref points to. For annotated tags this is the tagged commit, not the tag object.
+	SHA string
+	// DefaultBranch is the default branch of the repo
+	DefaultBranch string
+}
+
+// RefClient describes a GitHub client that can resolve arbitrary refs
+type RefClient interface {
+	ResolveRef(ctx context.Context, repo, ref string) (RefInfo, error)
+}
+
+var _ RefClient = &GitHubClient{}
+
+func notFound(resp *github.Response) bool {
+	return resp != nil && resp.StatusCode == http.StatusNotFound
+}
+
+// ResolveRef resolves ref, which may be a branch, a tag or a commit SHA (in that order of precedence), to a commit
+func (ghc *GitHubClient) ResolveRef(ctx context.Context, repo, ref string) (RefInfo, error) {
+	rs := strings.Split(repo, "/")
+	if len(rs) != 2 {
+		return RefInfo{}, fmt.Errorf("malformed repo: %v", repo)
+	}
+	ctx, cf := context.WithTimeout(ctx, ghTimeout)
+	defer cf()
+	c := ghc.getClient(ctx)
+	r, _, err := c.Repositories.Get(ctx, rs[0], rs[1])
+	if err != nil {
+		return RefInfo{}, fmt.Errorf("error getting repo: %v", err)
+	}
+	out := RefInfo{DefaultBranch: r.GetDefaultBranch()}
+	b, resp, err := c.Repositories.GetBranch(ctx, rs[0], rs[1], ref, false)
+	if err == nil {
+		out.Kind, out.SHA = BranchRef, b.GetCommit().GetSHA()
+		return out, nil
+	}
+	if !notFound(resp) {
+		return RefInfo{}, fmt.Errorf("error getting branch: %v", err)
+	}
+	tr, resp, err := c.Git.GetRef(ctx, rs[0], rs[1], "tags/"+ref)
+	if err == nil {
+		out.Kind, out.SHA = TagRef, tr.GetObject().GetSHA()
+		// annotated tags point to a tag object rather than a commit
+		if tr.GetObject().GetType() == "tag" {
+			t, _, err := c.Git.GetTag(ctx, rs[0], rs[1], out.SHA)
+			if err != nil {
+				return RefInfo{}, fmt.Errorf("error getting annotated tag: %v", err)
+			}
+			out.SHA = t.GetObject().GetSHA()
+		}
+		return out, nil
+	}
+	if !notFound(resp) {
+		return RefInfo{}, fmt.Errorf("error getting tag: %v", err)
+	}
+	rc, _, err := c.Repositories.GetCommit(ctx, rs[0], rs[1], ref, &github.ListOptions{})
+	if err != nil {
+		return RefInfo{}, fmt.Errorf("ref not found as a branch, tag or commit: %v: %v", ref, err)
+	}
+	out.Kind, out.SHA = CommitRef, rc.GetSHA()
+	return out, nil
+}
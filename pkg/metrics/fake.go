@@ -23,6 +23,7 @@ func (fc *FakeCollector) ContainerBuildAllDuration(name, repo, ref string, durat
 func (fc *FakeCollector) ContainerBuildDuration(name, repo, ref, depRepo, depRef string, duration time.Duration, err error) {
 }
 func (fc *FakeCollector) EnvironmentCount(repo string, status models.EnvironmentStatus, num uint) {}
+func (fc *FakeCollector) ResetEnvironmentCounts()                                                 {}
 func (fc *FakeCollector) Pruned(count int)                                                        {}
 func (fc *FakeCollector) Reaped(name, repo string, reason models.QADestroyReason, err error)      {}
 func (fc *FakeCollector) TimeContainerBuildAll(name, repo, ref string, err *error) func() {
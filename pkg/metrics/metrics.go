@@ -25,6 +25,7 @@ type Collector interface {
 	ContainerBuildAllDuration(name, repo, ref string, duration time.Duration, err error)
 	ContainerBuildDuration(name, repo, ref, depRepo, depRef string, duration time.Duration, err error)
 	EnvironmentCount(repo string, status models.EnvironmentStatus, num uint)
+	ResetEnvironmentCounts()
 	Pruned(count int)
 	Reaped(name, repo string, reason models.QADestroyReason, err error)
 	TimeContainerBuildAll(name, repo, ref string, err *error) func()
@@ -182,6 +183,9 @@ func (dc *DatadogCollector) EnvironmentCount(repo string, status models.Environm
 	dc.gauge("environments.count", float64(num), tags)
 }
 
+// ResetEnvironmentCounts is a no-op since Datadog gauges are only reported when set
+func (dc *DatadogCollector) ResetEnvironmentCounts() {}
+
 func (dc *DatadogCollector) Pruned(count int) {
 	dc.count("reaper.pruned", int64(count), []string{})
 }
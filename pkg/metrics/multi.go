@@ -0,0 +1,123 @@
+package metrics
+
+import (
+	"time"
+
+	"github.com/dollarshaveclub/acyl/pkg/models"
+)
+
+// MultiCollector is a collector that sends metrics to all configured collectors (eg, Datadog and Prometheus)
+type MultiCollector struct {
+	Collectors []Collector
+}
+
+var _ Collector = &MultiCollector{}
+
+func (mc *MultiCollector) Success(name, repo, ref string) {
+	for _, c := range mc.Collectors {
+		c.Success(name, repo, ref)
+	}
+}
+
+func (mc *MultiCollector) Failure(name, repo, ref string) {
+	for _, c := range mc.Collectors {
+		c.Failure(name, repo, ref)
+	}
+}
+
+func (mc *MultiCollector) EventRateLimitDropped(name string) {
+	for _, c := range mc.Collectors {
+		c.EventRateLimitDropped(name)
+	}
+}
+
+func (mc *MultiCollector) EventCountExceededDropped(name, repo, ref string) {
+	for _, c := range mc.Collectors {
+		c.EventCountExceededDropped(name, repo, ref)
+	}
+}
+
+func (mc *MultiCollector) Operation(op, name, repo, ref string, err error) {
+	for _, c := range mc.Collectors {
+		c.Operation(op, name, repo, ref, err)
+	}
+}
+
+func (mc *MultiCollector) ProvisioningDuration(name, repo, ref string, duration time.Duration, err error) {
+	for _, c := range mc.Collectors {
+		c.ProvisioningDuration(name, repo, ref, duration, err)
+	}
+}
+
+func (mc *MultiCollector) ContainerBuildAllDuration(name, repo, ref string, duration time.Duration, err error) {
+	for _, c := range mc.Collectors {
+		c.ContainerBuildAllDuration(name, repo, ref, duration, err)
+	}
+}
+
+func (mc *MultiCollector) ContainerBuildDuration(name, repo, ref, depRepo, depRef string, duration time.Duration, err error) {
+	for _, c := range mc.Collectors {
+		c.ContainerBuildDuration(name, repo, ref, depRepo, depRef, duration, err)
+	}
+}
+
+func (mc *MultiCollector) EnvironmentCount(repo string, status models.EnvironmentStatus, num uint) {
+	for _, c := range mc.Collectors {
+		c.EnvironmentCount(repo, status, num)
+	}
+}
+
+func (mc *MultiCollector) ResetEnvironmentCounts() {
+	for _, c := range mc.Collectors {
+		c.ResetEnvironmentCounts()
+	}
+}
+
+func (mc *MultiCollector) Pruned(count int) {
+	for _, c := range mc.Collectors {
+		c.Pruned(count)
+	}
+}
+
+func (mc *MultiCollector) Reaped(name, repo string, reason models.QADestroyReason, err error) {
+	for _, c := range mc.Collectors {
+		c.Reaped(name, repo, reason, err)
+	}
+}
+
+// timers starts a timer with each collector using f and returns a function that stops all of them
+func (mc *MultiCollector) timers(f func(c Collector) func()) func() {
+	stops := make([]func(), len(mc.Collectors))
+	for i, c := range mc.Collectors {
+		stops[i] = f(c)
+	}
+	return func() {
+		for _, stop := range stops {
+			stop()
+		}
+	}
+}
+
+func (mc *MultiCollector) TimeContainerBuildAll(name, repo, ref string, err *error) func() {
+	return mc.timers(func(c Collector) func() { return c.TimeContainerBuildAll(name, repo, ref, err) })
+}
+
+func (mc *MultiCollector) TimeProvisioning(name, repo, ref string, err *error) func() {
+	return mc.timers(func(c Collector) func() { return c.TimeProvisioning(name, repo, ref, err) })
+}
+
+func (mc *MultiCollector) TimeContainerBuild(name, repo, ref, depRepo, depRef string, err *error) func() {
+	return mc.timers(func(c Collector) func() { return c.TimeContainerBuild(name, repo, ref, depRepo, depRef, err) })
+}
+
+func (mc *MultiCollector) AminoDeployTimedOut(name, repo, ref string) {
+	for _, c := range mc.Collectors {
+		c.AminoDeployTimedOut(name, repo, ref)
+	}
+}
+
+func (mc *MultiCollector) ImageBuildFailed(name, repo, ref string) {
+	for _, c := range mc.Collectors {
+		c.ImageBuildFailed(name, repo, ref)
+	}
+}
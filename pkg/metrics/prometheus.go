@@ -0,0 +1,180 @@
+package metrics
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/dollarshaveclub/acyl/pkg/models"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	promNamespace = "acyl"
+)
+
+// PrometheusCollector represents a collector that exposes metrics to be scraped by Prometheus
+type PrometheusCollector struct {
+	envResults        *prometheus.CounterVec
+	eventsDropped     *prometheus.CounterVec
+	operations        *prometheus.CounterVec
+	provisioning      *prometheus.HistogramVec
+	containerBuildAll *prometheus.HistogramVec
+	containerBuild    *prometheus.HistogramVec
+	envCount          *prometheus.GaugeVec
+	pruned            prometheus.Counter
+	reaped            *prometheus.CounterVec
+}
+
+var _ Collector = &PrometheusCollector{}
+
+// durationBuckets are histogram buckets (in seconds) suitable for environment provisioning and image builds, which take from seconds to an hour
+var durationBuckets = []float64{5, 15, 30, 60, 120, 300, 600, 900, 1200, 1800, 2700, 3600}
+
+// NewPrometheusCollector returns a PrometheusCollector with all metrics registered with reg
+func NewPrometheusCollector(reg prometheus.Registerer) (*PrometheusCollector, error) {
+	pc := &PrometheusCollector{
+		envResults: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: promNamespace,
+			Name:      "environment_results_total",
+			Help:      "Environment results by outcome",
+		}, []string{"repo", "result"}),
+		eventsDropped: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: promNamespace,
+			Name:      "environment_events_dropped_total",
+			Help:      "Environment events dropped by reason",
+		}, []string{"reason"}),
+		operations: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: promNamespace,
+			Name:      "operations_total",
+			Help:      "Environment operations by operation and status",
+		}, []string{"operation", "repo", "status"}),
+		provisioning: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: promNamespace,
+			Name:      "provisioning_duration_seconds",
+			Help:      "Total environment provisioning duration",
+			Buckets:   durationBuckets,
+		}, []string{"repo", "status"}),
+		containerBuildAll: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: promNamespace,
+			Name:      "container_build_all_duration_seconds",
+			Help:      "Duration of building all container images for an environment",
+			Buckets:   durationBuckets,
+		}, []string{"repo", "status"}),
+		containerBuild: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: promNamespace,
+			Name:      "container_build_duration_seconds",
+			Help:      "Duration of building a single container image",
+			Buckets:   durationBuckets,
+		}, []string{"repo", "deprepo", "status"}),
+		envCount: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: promNamespace,
+			Name:      "environments",
+			Help:      "Number of environments by repo and status",
+		}, []string{"repo", "status"}),
+		pruned: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: promNamespace,
+			Name:      "reaper_pruned_total",
+			Help:      "Destroyed environment records pruned by the reaper",
+		}),
+		reaped: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: promNamespace,
+			Name:      "reaper_reaps_total",
+			Help:      "Environments reaped by reason and status",
+		}, []string{"repo", "reason", "status"}),
+	}
+	for _, c := range []prometheus.Collector{pc.envResults, pc.eventsDropped, pc.operations, pc.provisioning, pc.containerBuildAll, pc.containerBuild, pc.envCount, pc.pruned, pc.reaped} {
+		if err := reg.Register(c); err != nil {
+			return nil, fmt.Errorf("error registering metric: %w", err)
+		}
+	}
+	return pc, nil
+}
+
+func (pc *PrometheusCollector) statusOfOp(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "success"
+}
+
+func (pc *PrometheusCollector) Success(name, repo, ref string) {
+	pc.envResults.WithLabelValues(repo, "success").Inc()
+}
+
+func (pc *PrometheusCollector) Failure(name, repo, ref string) {
+	pc.envResults.WithLabelValues(repo, "failure").Inc()
+}
+
+func (pc *PrometheusCollector) AminoDeployTimedOut(name, repo, ref string) {
+	pc.envResults.WithLabelValues(repo, "amino_deploy_timed_out").Inc()
+}
+
+func (pc *PrometheusCollector) ImageBuildFailed(name, repo, ref string) {
+	pc.envResults.WithLabelValues(repo, "image_build_failed").Inc()
+}
+
+func (pc *PrometheusCollector) EventRateLimitDropped(name string) {
+	pc.eventsDropped.WithLabelValues("rate_limit").Inc()
+}
+
+func (pc *PrometheusCollector) EventCountExceededDropped(name, repo, ref string) {
+	pc.eventsDropped.WithLabelValues("count_exceeded").Inc()
+}
+
+func (pc *PrometheusCollector) Operation(op, name, repo, ref string, err error) {
+	pc.operations.WithLabelValues(op, repo, pc.statusOfOp(err)).Inc()
+}
+
+func (pc *PrometheusCollector) ProvisioningDuration(name, repo, ref string, duration time.Duration, err error) {
+	pc.provisioning.WithLabelValues(repo, pc.statusOfOp(err)).Observe(duration.Seconds())
+}
+
+func (pc *PrometheusCollector) ContainerBuildAllDuration(name, repo, ref string, duration time.Duration, err error) {
+	pc.containerBuildAll.WithLabelValues(repo, pc.statusOfOp(err)).Observe(duration.Seconds())
+}
+
+func (pc *PrometheusCollector) ContainerBuildDuration(name, repo, ref, depRepo, depRef string, duration time.Duration, err error) {
+	pc.containerBuild.WithLabelValues(repo, depRepo, pc.statusOfOp(err)).Observe(duration.Seconds())
+}
+
+func (pc *PrometheusCollector) EnvironmentCount(repo string, status models.EnvironmentStatus, num uint) {
+	pc.envCount.WithLabelValues(repo, strings.ToLower(status.String())).Set(float64(num))
+}
+
+// ResetEnvironmentCounts removes all environment count series so that repos and statuses with no environments are no longer reported
+func (pc *PrometheusCollector) ResetEnvironmentCounts() {
+	pc.envCount.Reset()
+}
+
+func (pc *PrometheusCollector) Pruned(count int) {
+	pc.pruned.Add(float64(count))
+}
+
+func (pc *PrometheusCollector) Reaped(name, repo string, reason models.QADestroyReason, err error) {
+	pc.reaped.WithLabelValues(repo, reason.String(), pc.statusOfOp(err)).Inc()
+}
+
+func (pc *PrometheusCollector) timeAround(f func(time.Duration)) func() {
+	start := time.Now()
+
+	return func() { f(time.Since(start)) }
+}
+
+func (pc *PrometheusCollector) TimeContainerBuildAll(name, repo, ref string, err *error) func() {
+	return pc.timeAround(func(duration time.Duration) {
+		pc.ContainerBuildAllDuration(name, repo, ref, duration, *err)
+	})
+}
+
+func (pc *PrometheusCollector) TimeProvisioning(name, repo, ref string, err *error) func() {
+	return pc.timeAround(func(duration time.Duration) {
+		pc.ProvisioningDuration(name, repo, ref, duration, *err)
+	})
+}
+
+func (pc *PrometheusCollector) TimeContainerBuild(name, repo, ref, depRepo, depRef string, err *error) func() {
+	return pc.timeAround(func(duration time.Duration) {
+		pc.ContainerBuildDuration(name, repo, ref, depRepo, depRef, duration, *err)
+	})
+}
@@ -0,0 +1,137 @@
+package metrics
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dollarshaveclub/acyl/pkg/models"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// gather returns the gathered metrics from reg as a map of "name{label=value,...}" to value (sample count for histograms)
+func gather(t *testing.T, reg *prometheus.Registry) map[string]float64 {
+	mfs, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("error gathering metrics: %v", err)
+	}
+	out := make(map[string]float64)
+	for _, mf := range mfs {
+		for _, m := range mf.GetMetric() {
+			labels := []string{}
+			for _, lp := range m.GetLabel() {
+				labels = append(labels, lp.GetName()+"="+lp.GetValue())
+			}
+			sort.Strings(labels)
+			key := mf.GetName() + "{" + strings.Join(labels, ",") + "}"
+			switch mf.GetType() {
+			case dto.MetricType_COUNTER:
+				out[key] = m.GetCounter().GetValue()
+			case dto.MetricType_GAUGE:
+				out[key] = m.GetGauge().GetValue()
+			case dto.MetricType_HISTOGRAM:
+				out[key] = float64(m.GetHistogram().GetSampleCount())
+			}
+		}
+	}
+	return out
+}
+
+func TestPrometheusCollector(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	pc, err := NewPrometheusCollector(reg)
+	if err != nil {
+		t.Fatalf("error creating collector: %v", err)
+	}
+
+	pc.Success("foo-bar", "acme/widgets", "master")
+	pc.Failure("foo-bar", "acme/widgets", "master")
+	pc.ImageBuildFailed("foo-bar", "acme/widgets", "master")
+	pc.EventRateLimitDropped("foo-bar")
+	pc.Operation("create", "foo-bar", "acme/widgets", "master", nil)
+	pc.Operation("create", "foo-bar", "acme/widgets", "master", errors.New("boom"))
+	pc.ProvisioningDuration("foo-bar", "acme/widgets", "master", time.Minute, nil)
+	pc.ContainerBuildDuration("foo-bar", "acme/widgets", "master", "acme/other", "master", time.Minute, nil)
+	pc.Pruned(3)
+	pc.Reaped("foo-bar", "acme/widgets", models.ReapPrClosed, nil)
+
+	reason := models.ReapPrClosed.String()
+	got := gather(t, reg)
+	want := map[string]float64{
+		"acyl_environment_results_total{repo=acme/widgets,result=success}":                           1,
+		"acyl_environment_results_total{repo=acme/widgets,result=failure}":                           1,
+		"acyl_environment_results_total{repo=acme/widgets,result=image_build_failed}":                1,
+		"acyl_environment_events_dropped_total{reason=rate_limit}":                                   1,
+		"acyl_operations_total{operation=create,repo=acme/widgets,status=success}":                   1,
+		"acyl_operations_total{operation=create,repo=acme/widgets,status=error}":                     1,
+		"acyl_provisioning_duration_seconds{repo=acme/widgets,status=success}":                       1,
+		"acyl_container_build_duration_seconds{deprepo=acme/other,repo=acme/widgets,status=success}": 1,
+		"acyl_reaper_pruned_total{}": 3,
+		"acyl_reaper_reaps_total{reason=" + reason + ",repo=acme/widgets,status=success}": 1,
+	}
+	if len(got) != len(want) {
+		t.Errorf("bad metric count: %v (wanted %v): %v", len(got), len(want), got)
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("bad value for %v: %v (wanted %v)", k, got[k], v)
+		}
+	}
+}
+
+func TestPrometheusCollectorEnvironmentCount(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	pc, err := NewPrometheusCollector(reg)
+	if err != nil {
+		t.Fatalf("error creating collector: %v", err)
+	}
+
+	// first audit pass
+	pc.ResetEnvironmentCounts()
+	pc.EnvironmentCount("acme/widgets", models.Success, 2)
+	pc.EnvironmentCount("acme/widgets", models.Failure, 1)
+	pc.EnvironmentCount("acme/other", models.Success, 1)
+
+	// second audit pass: the failed env and all envs for acme/other are gone
+	pc.ResetEnvironmentCounts()
+	pc.EnvironmentCount("acme/widgets", models.Success, 3)
+
+	got := gather(t, reg)
+	want := map[string]float64{
+		"acyl_environments{repo=acme/widgets,status=success}": 3,
+		"acyl_reaper_pruned_total{}":                          0,
+	}
+	if len(got) != len(want) {
+		t.Errorf("bad metric count: %v (wanted %v): %v", len(got), len(want), got)
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("bad value for %v: %v (wanted %v)", k, got[k], v)
+		}
+	}
+}
+
+func TestMultiCollector(t *testing.T) {
+	reg1, reg2 := prometheus.NewRegistry(), prometheus.NewRegistry()
+	pc1, err := NewPrometheusCollector(reg1)
+	if err != nil {
+		t.Fatalf("error creating collector: %v", err)
+	}
+	pc2, err := NewPrometheusCollector(reg2)
+	if err != nil {
+		t.Fatalf("error creating collector: %v", err)
+	}
+	mc := &MultiCollector{Collectors: []Collector{pc1, pc2}}
+	mc.Success("foo-bar", "acme/widgets", "master")
+	var opErr error
+	mc.TimeProvisioning("foo-bar", "acme/widgets", "master", &opErr)()
+	for i, reg := range []*prometheus.Registry{reg1, reg2} {
+		got := gather(t, reg)
+		if got["acyl_environment_results_total{repo=acme/widgets,result=success}"] != 1 || got["acyl_provisioning_duration_seconds{repo=acme/widgets,status=success}"] != 1 {
+			t.Errorf("collector %v: bad metrics: %v", i, got)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package models
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+
+	nitroerrors "github.com/dollarshaveclub/acyl/pkg/nitro/errors"
+)
+
+// ChartRepoSource models a chart in a Helm chart repository (HTTP)
+type ChartRepoSource struct {
+	// URL is the chart repository URL (the location of index.yaml)
+	URL string `yaml:"url" json:"url"`
+	// Chart is the name of the chart in the repository
+	Chart string `yaml:"chart" json:"chart"`
+	// Version is a chart version or semver constraint (ex: "17.3.2", "~17.3", ">=12.0.0 <13"). If empty, the latest stable version is used.
+	Version string `yaml:"version" json:"version"`
+}
+
+// Validate indicates whether the source is valid
+func (crs ChartRepoSource) Validate() error {
+	if crs.URL == "" || crs.Chart == "" {
+		return nitroerrors.User(fmt.Errorf("chart_repo: url and chart are required"))
+	}
+	u, err := url.Parse(crs.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return nitroerrors.User(fmt.Errorf("chart_repo: url must be an http or https URL: %v", crs.URL))
+	}
+	return nil
+}
+
+// String returns a string representation of the source
+func (crs ChartRepoSource) String() string {
+	return strings.TrimSuffix(crs.URL, "/") + "/" + crs.Chart + "@" + crs.Version
+}
+
+// ChartOCISource models a chart in an OCI registry
+type ChartOCISource struct {
+	// Ref is the chart reference without a tag (ex: "oci://registry-1.docker.io/bitnamicharts/postgresql")
+	Ref string `yaml:"ref" json:"ref"`
+	// Version is a chart version or semver constraint. If empty, the latest version is used.
+	Version string `yaml:"version" json:"version"`
+}
+
+// Validate indicates whether the source is valid
+func (cos ChartOCISource) Validate() error {
+	if !strings.HasPrefix(cos.Ref, "oci://") || len(cos.Ref) == len("oci://") {
+		return nitroerrors.User(fmt.Errorf("chart_oci: ref must begin with oci://: %v", cos.Ref))
+	}
+	if _, tag := path.Split(cos.Ref); strings.Contains(tag, ":") {
+		return nitroerrors.User(fmt.Errorf("chart_oci: ref must not include a tag (use version): %v", cos.Ref))
+	}
+	return nil
+}
+
+// ChartName returns the chart name (the last element of the reference)
+func (cos ChartOCISource) ChartName() string {
+	return path.Base(cos.Ref)
+}
+
+// String returns a string representation of the source
+func (cos ChartOCISource) String() string {
+	return cos.Ref + "@" + cos.Version
+}
@@ -5,6 +5,7 @@ import (
 	"database/sql/driver"
 	"encoding/json"
 	"fmt"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -96,6 +97,8 @@ const (
 	CreateEvent
 	UpdateEvent
 	DestroyEvent
+	SuspendEvent
+	ResumeEvent
 )
 
 // adapted from https://stackoverflow.com/questions/48050945/how-to-unmarshal-json-into-durations
@@ -162,6 +165,7 @@ type EventStatusTreeNodeImage struct {
 	Name      string     `json:"name"`
 	ID        guuid.UUID `json:"id"`
 	Error     bool       `json:"error"`
+	Cached    bool       `json:"cached"`
 	Completed time.Time  `json:"completed"`
 	Started   time.Time  `json:"started"`
 }
@@ -172,10 +176,20 @@ type EventStatusTreeNodeChart struct {
 	Completed time.Time       `json:"completed"`
 }
 
+// EventStatusTreeNodeHealthCheck is the status of a post-install health check
+type EventStatusTreeNodeHealthCheck struct {
+	Type      string    `json:"type"`
+	Error     bool      `json:"error"`
+	Message   string    `json:"message"`
+	Started   time.Time `json:"started"`
+	Completed time.Time `json:"completed"`
+}
+
 type EventStatusTreeNode struct {
-	Parent string                   `json:"parent"`
-	Image  EventStatusTreeNodeImage `json:"image"`
-	Chart  EventStatusTreeNodeChart `json:"chart"`
+	Parent       string                                    `json:"parent"`
+	Image        EventStatusTreeNodeImage                  `json:"image"`
+	Chart        EventStatusTreeNodeChart                  `json:"chart"`
+	HealthChecks map[string]EventStatusTreeNodeHealthCheck `json:"health_checks,omitempty"`
 }
 
 type EventStatusSummary struct {
@@ -183,6 +197,31 @@ type EventStatusSummary struct {
 	Tree   map[string]EventStatusTreeNode `json:"tree"`
 }
 
+// FailedHealthCheck is a failed health check for a status tree node
+type FailedHealthCheck struct {
+	Node, Name string
+	EventStatusTreeNodeHealthCheck
+}
+
+// FailedHealthChecks returns the failed health checks in the status tree, sorted by node and name
+func (es EventStatusSummary) FailedHealthChecks() []FailedHealthCheck {
+	out := []FailedHealthCheck{}
+	for node, n := range es.Tree {
+		for name, hc := range n.HealthChecks {
+			if hc.Error {
+				out = append(out, FailedHealthCheck{Node: node, Name: name, EventStatusTreeNodeHealthCheck: hc})
+			}
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Node == out[j].Node {
+			return out[i].Name < out[j].Name
+		}
+		return out[i].Node < out[j].Node
+	})
+	return out
+}
+
 // Value implements database/sql/driver Valuer interface.
 func (es EventStatusSummary) Value() (driver.Value, error) {
 	return json.Marshal(es)
@@ -12,11 +12,13 @@ func _() {
 	_ = x[CreateEvent-1]
 	_ = x[UpdateEvent-2]
 	_ = x[DestroyEvent-3]
+	_ = x[SuspendEvent-4]
+	_ = x[ResumeEvent-5]
 }
 
-const _EventStatusType_name = "UnknownEventStatusTypeCreateEventUpdateEventDestroyEvent"
+const _EventStatusType_name = "UnknownEventStatusTypeCreateEventUpdateEventDestroyEventSuspendEventResumeEvent"
 
-var _EventStatusType_index = [...]uint8{0, 22, 33, 44, 56}
+var _EventStatusType_index = [...]uint8{0, 22, 33, 44, 56, 68, 79}
 
 func (i EventStatusType) String() string {
 	if i < 0 || i >= EventStatusType(len(_EventStatusType_index)-1) {
@@ -0,0 +1,166 @@
+package models
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	nitroerrors "github.com/dollarshaveclub/acyl/pkg/nitro/errors"
+)
+
+// HealthCheck models a probe that is run after the environment charts are installed
+// The environment is only considered successful if all health checks pass. Exactly one of HTTP, TCP or Exec must be set.
+type HealthCheck struct {
+	Name string           `yaml:"name" json:"name"`
+	HTTP *HTTPHealthCheck `yaml:"http" json:"http,omitempty"`
+	TCP  *TCPHealthCheck  `yaml:"tcp" json:"tcp,omitempty"`
+	Exec *ExecHealthCheck `yaml:"exec" json:"exec,omitempty"`
+	// TimeoutSeconds is the timeout for each attempt
+	TimeoutSeconds uint `yaml:"timeout_seconds" json:"timeout_seconds"`
+	// Retries is the number of additional attempts after a failure, with IntervalSeconds between attempts
+	Retries         uint `yaml:"retries" json:"retries"`
+	IntervalSeconds uint `yaml:"interval_seconds" json:"interval_seconds"`
+}
+
+// HTTPHealthCheck passes if a GET request to URL returns ExpectedStatus (or any 2xx status if zero)
+// URL is a template with the fields EnvName and K8sNamespace. The host must be a service in the environment namespace (ex: "http://web.{{ .K8sNamespace }}.svc.cluster.local/healthz")
+// and redirects are not followed. For environments placed in a remote cluster, the request is proxied through the cluster API server.
+type HTTPHealthCheck struct {
+	URL            string `yaml:"url" json:"url"`
+	ExpectedStatus int    `yaml:"expected_status" json:"expected_status"`
+}
+
+// TCPHealthCheck passes if a TCP connection can be made to Address (host:port)
+// Address is a template with the fields EnvName and K8sNamespace. The host must be a service in the environment namespace (ex: "db.{{ .K8sNamespace }}.svc:5432").
+// TCP checks always fail for environments placed in a remote cluster, as its services aren't reachable from acyl.
+type TCPHealthCheck struct {
+	Address string `yaml:"address" json:"address"`
+}
+
+// ExecHealthCheck passes if Command exits with status zero when run in a running pod in the environment namespace matching the label Selector
+// If Container is empty the first container in the pod is used.
+type ExecHealthCheck struct {
+	Selector  string   `yaml:"selector" json:"selector"`
+	Container string   `yaml:"container" json:"container"`
+	Command   []string `yaml:"command" json:"command"`
+}
+
+// Health check defaults
+const (
+	DefaultHealthCheckTimeout  = 10 * time.Second
+	DefaultHealthCheckRetries  = 5
+	DefaultHealthCheckInterval = 10 * time.Second
+)
+
+// Type returns the type of health check ("http", "tcp" or "exec"), or an empty string if none or more than one are set
+func (hc HealthCheck) Type() string {
+	var out string
+	var n int
+	if hc.HTTP != nil {
+		out = "http"
+		n++
+	}
+	if hc.TCP != nil {
+		out = "tcp"
+		n++
+	}
+	if hc.Exec != nil {
+		out = "exec"
+		n++
+	}
+	if n != 1 {
+		return ""
+	}
+	return out
+}
+
+// Timeout returns the per-attempt timeout
+func (hc HealthCheck) Timeout() time.Duration {
+	if hc.TimeoutSeconds == 0 {
+		return DefaultHealthCheckTimeout
+	}
+	return time.Duration(hc.TimeoutSeconds) * time.Second
+}
+
+// Attempts returns the maximum number of attempts
+func (hc HealthCheck) Attempts() uint {
+	if hc.Retries == 0 {
+		return DefaultHealthCheckRetries + 1
+	}
+	return hc.Retries + 1
+}
+
+// Interval returns the time to wait between attempts
+func (hc HealthCheck) Interval() time.Duration {
+	if hc.IntervalSeconds == 0 {
+		return DefaultHealthCheckInterval
+	}
+	return time.Duration(hc.IntervalSeconds) * time.Second
+}
+
+// Validate indicates whether the health check is valid
+func (hc HealthCheck) Validate() error {
+	if hc.Name == "" {
+		return nitroerrors.User(fmt.Errorf("name is required"))
+	}
+	switch hc.Type() {
+	case "http":
+		if hc.HTTP.URL == "" {
+			return nitroerrors.User(fmt.Errorf("%v: http url is required", hc.Name))
+		}
+	case "tcp":
+		if hc.TCP.Address == "" {
+			return nitroerrors.User(fmt.Errorf("%v: tcp address is required", hc.Name))
+		}
+	case "exec":
+		if hc.Exec.Selector == "" || len(hc.Exec.Command) == 0 {
+			return nitroerrors.User(fmt.Errorf("%v: exec selector and command are required", hc.Name))
+		}
+	default:
+		return nitroerrors.User(fmt.Errorf("%v: exactly one of http, tcp or exec is required", hc.Name))
+	}
+	return nil
+}
+
+// AllHealthChecks returns the health checks declared for the dependency followed by those declared in the application section of the dependency acyl.yml (if any)
+func (rcd RepoConfigDependency) AllHealthChecks() []HealthCheck {
+	return append(append([]HealthCheck{}, rcd.HealthChecks...), rcd.AppMetadata.HealthChecks...)
+}
+
+// HealthChecks returns all health checks for the environment by status tree node name (application or dependency name)
+// Nodes without health checks are omitted.
+func (rc RepoConfig) HealthChecks() map[string][]HealthCheck {
+	out := map[string][]HealthCheck{}
+	if len(rc.Application.HealthChecks) > 0 {
+		out[GetName(rc.Application.Repo)] = rc.Application.HealthChecks
+	}
+	for _, d := range rc.Dependencies.All() {
+		if hcs := d.AllHealthChecks(); len(hcs) > 0 {
+			out[d.Name] = hcs
+		}
+	}
+	return out
+}
+
+// ValidateHealthChecks indicates whether all health checks are valid and have unique names within each application or dependency
+func (rc RepoConfig) ValidateHealthChecks() error {
+	hcs := rc.HealthChecks()
+	nodes := make([]string, 0, len(hcs))
+	for k := range hcs {
+		nodes = append(nodes, k)
+	}
+	sort.Strings(nodes)
+	for _, n := range nodes {
+		names := map[string]struct{}{}
+		for i, hc := range hcs[n] {
+			if err := hc.Validate(); err != nil {
+				return fmt.Errorf("invalid health check at offset %v for %v: %w", i, n, err)
+			}
+			if _, ok := names[hc.Name]; ok {
+				return nitroerrors.User(fmt.Errorf("duplicate health check name for %v: %v", n, hc.Name))
+			}
+			names[hc.Name] = struct{}{}
+		}
+	}
+	return nil
+}
@@ -31,6 +31,7 @@ const (
 	Destroyed                              // Destroyed means the environment was destroyed explicitly or as part of a PR synchronize or close
 	Updating                               // Updating means an existing env is being updated (replaced behind the scenes)
 	Cancelled                              // Cancelled means an environment has been cancelled via a context.
+	Suspended                              // Suspended means all workloads in the environment namespace have been scaled to zero
 )
 
 // EnvironmentStatusFromString returns the EnvironmentStatus constant for a string or error if unknown
@@ -49,6 +50,8 @@ func EnvironmentStatusFromString(es string) (EnvironmentStatus, error) {
 		return Updating, nil
 	case "cancelled":
 		return Cancelled, nil
+	case "suspended":
+		return Suspended, nil
 	default:
 		return UnknownStatus, fmt.Errorf("unknown status")
 	}
@@ -65,6 +68,9 @@ const (
 	CreateFoundStale                                    // The environment is a stale environment associated with a PR that we are executing a create for
 	DestroyApiRequest                                   // Explicit API destroy request
 	EnvironmentLimitExceeded                            // Environment destroyed by a new environment create request to bring environment count into compliance with the global limit
+	ReapEnvironmentQuotaExceeded                        // Environment destroyed by Reaper to bring environment count into compliance with a repo, org or user quota
+	EnvironmentQuotaExceeded                            // Environment destroyed by a new environment create request to bring environment count into compliance with a repo, org or user quota
+	ReapEnvironmentExpired                              // Environment destroyed by Reaper because its lifetime expired
 )
 
 // RefMap is a mapping of Github repository to a ref.
@@ -102,6 +108,7 @@ type QAEnvironment struct {
 	SourceBranch             string               `json:"source_branch"`
 	BaseBranch               string               `json:"base_branch"`
 	SourceRef                string               `json:"source_ref"`
+	IsFork                   bool                 `json:"is_fork"`
 	RawStatus                string               `json:"status"`
 	Status                   EnvironmentStatus    `json:"status_int"`
 	RefMap                   RefMap               `json:"ref_map"`
@@ -111,6 +118,10 @@ type QAEnvironment struct {
 	AminoKubernetesNamespace string               `json:"amino_kubernetes_namespace"`
 	AminoEnvironmentID       int                  `json:"amino_environment_id"`
 	EventIDs                 []uuid.UUID          `json:"event_ids"`
+	PinnedUntil              *time.Time           `json:"pinned_until"`
+	LastAccessed             *time.Time           `json:"last_accessed"`
+	Expires                  *time.Time           `json:"expires"`
+	ExpiryWarned             bool                 `json:"expiry_warned"`
 
 	rmapHS  hstore.Hstore
 	csmapHS hstore.Hstore
@@ -119,11 +130,11 @@ type QAEnvironment struct {
 
 // Columns returns a comma-separated string of column names suitable for a SELECT
 func (qae QAEnvironment) Columns() string {
-	return "id, name, created, raw_events, hostname, qa_type, username, repo, pull_request, source_sha, base_sha, source_branch, base_branch, source_ref, status, ref_map, commit_sha_map, amino_service_to_port, amino_kubernetes_namespace, amino_environment_id"
+	return "id, name, created, raw_events, hostname, qa_type, username, repo, pull_request, source_sha, base_sha, source_branch, base_branch, source_ref, is_fork, status, ref_map, commit_sha_map, amino_service_to_port, amino_kubernetes_namespace, amino_environment_id, pinned_until, last_accessed, expires, expiry_warned"
 }
 
 func (qae QAEnvironment) InsertColumns() string {
-	return "name, created, raw_events, hostname, qa_type, username, repo, pull_request, source_sha, base_sha, source_branch, base_branch, source_ref, status, ref_map, commit_sha_map, amino_service_to_port, amino_kubernetes_namespace, amino_environment_id"
+	return "name, created, raw_events, hostname, qa_type, username, repo, pull_request, source_sha, base_sha, source_branch, base_branch, source_ref, is_fork, status, ref_map, commit_sha_map, amino_service_to_port, amino_kubernetes_namespace, amino_environment_id, pinned_until, last_accessed, expires, expiry_warned"
 }
 
 // InsertParams returns the query placeholder params for a full model insert
@@ -137,11 +148,11 @@ func (qae QAEnvironment) InsertParams() string {
 
 // ScanValues returns a slice of values suitable for a query Scan()
 func (qae *QAEnvironment) ScanValues() []interface{} {
-	return []interface{}{&qae.ID, &qae.Name, &qae.Created, pq.Array(&qae.RawEvents), &qae.Hostname, &qae.QAType, &qae.User, &qae.Repo, &qae.PullRequest, &qae.SourceSHA, &qae.BaseSHA, &qae.SourceBranch, &qae.BaseBranch, &qae.SourceRef, &qae.Status, qae.RefMapHStore(), qae.CommitSHAMapHStore(), qae.AminoServiceToPortHStore(), &qae.AminoKubernetesNamespace, &qae.AminoEnvironmentID}
+	return []interface{}{&qae.ID, &qae.Name, &qae.Created, pq.Array(&qae.RawEvents), &qae.Hostname, &qae.QAType, &qae.User, &qae.Repo, &qae.PullRequest, &qae.SourceSHA, &qae.BaseSHA, &qae.SourceBranch, &qae.BaseBranch, &qae.SourceRef, &qae.IsFork, &qae.Status, qae.RefMapHStore(), qae.CommitSHAMapHStore(), qae.AminoServiceToPortHStore(), &qae.AminoKubernetesNamespace, &qae.AminoEnvironmentID, &qae.PinnedUntil, &qae.LastAccessed, &qae.Expires, &qae.ExpiryWarned}
 }
 
 func (qae *QAEnvironment) InsertValues() []interface{} {
-	return []interface{}{&qae.Name, &qae.Created, pq.Array(&qae.RawEvents), &qae.Hostname, &qae.QAType, &qae.User, &qae.Repo, &qae.PullRequest, &qae.SourceSHA, &qae.BaseSHA, &qae.SourceBranch, &qae.BaseBranch, &qae.SourceRef, &qae.Status, qae.RefMapHStore(), qae.CommitSHAMapHStore(), qae.AminoServiceToPortHStore(), &qae.AminoKubernetesNamespace, &qae.AminoEnvironmentID}
+	return []interface{}{&qae.Name, &qae.Created, pq.Array(&qae.RawEvents), &qae.Hostname, &qae.QAType, &qae.User, &qae.Repo, &qae.PullRequest, &qae.SourceSHA, &qae.BaseSHA, &qae.SourceBranch, &qae.BaseBranch, &qae.SourceRef, &qae.IsFork, &qae.Status, qae.RefMapHStore(), qae.CommitSHAMapHStore(), qae.AminoServiceToPortHStore(), &qae.AminoKubernetesNamespace, &qae.AminoEnvironmentID, &qae.PinnedUntil, &qae.LastAccessed, &qae.Expires, &qae.ExpiryWarned}
 }
 
 // RefMapHStore returns the HStore struct suitable for scanning during queries
@@ -242,9 +253,34 @@ func (qa QAEnvironment) RepoRevisionDataFromQA() *RepoRevisionData {
 		BaseSHA:      qa.BaseSHA,
 		BaseBranch:   qa.BaseBranch,
 		SourceRef:    qa.SourceRef,
+		IsFork:       qa.IsFork,
 	}
 }
 
+// LastActivity returns the timestamp of the most recent event recorded for the environment or UI/API access, or Created if there are no later events
+func (qa QAEnvironment) LastActivity() time.Time {
+	last := qa.Created
+	for _, e := range qa.Events {
+		if e.Timestamp.After(last) {
+			last = e.Timestamp
+		}
+	}
+	if qa.LastAccessed != nil && qa.LastAccessed.After(last) {
+		last = *qa.LastAccessed
+	}
+	return last
+}
+
+// Pinned returns whether the environment is pinned at time t. Pinned environments are exempt from eviction.
+func (qa QAEnvironment) Pinned(t time.Time) bool {
+	return qa.PinnedUntil != nil && qa.PinnedUntil.After(t)
+}
+
+// Expired returns whether the environment has expired at time t. Environments without an expiry never expire.
+func (qa QAEnvironment) Expired(t time.Time) bool {
+	return qa.Expires != nil && !qa.Expires.After(t)
+}
+
 // QAEnvironments is a slice of QAEnvironment to allow sorting by Created timestamp
 type QAEnvironments []QAEnvironment
 
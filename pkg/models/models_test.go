@@ -3,6 +3,9 @@ package models
 import (
 	"strings"
 	"testing"
+	"time"
+
+	"gopkg.in/yaml.v2"
 )
 
 func TestDependencyDeclarationValidateNames(t *testing.T) {
@@ -219,3 +222,313 @@ func TestRepoConfigRefMap(t *testing.T) {
 		t.Fatalf("biz/baz missing")
 	}
 }
+
+func TestRepoConfigTriggers(t *testing.T) {
+	rc := RepoConfig{TargetBranches: []string{"main"}}
+	rc.SetTriggerDefaults(nil, AutoCreatePolicy{Enabled: true, SkipDrafts: true})
+	if !rc.HasTriggerLabel("bug", DefaultTriggerLabel) {
+		t.Errorf("default trigger label should be set")
+	}
+	if !rc.AutoCreates("main", false) {
+		t.Errorf("should auto-create for target branch")
+	}
+	if rc.AutoCreates("main", true) {
+		t.Errorf("should skip drafts")
+	}
+	if rc.AutoCreates("release", false) {
+		t.Errorf("should not auto-create for other branches")
+	}
+	rc = RepoConfig{TriggerLabels: []string{"preview"}, AutoCreate: &AutoCreatePolicy{}}
+	rc.SetTriggerDefaults([]string{"acyl"}, AutoCreatePolicy{Enabled: true})
+	if rc.HasTriggerLabel("acyl") || !rc.HasTriggerLabel("preview") {
+		t.Errorf("configured trigger labels should not be overridden: %v", rc.TriggerLabels)
+	}
+	if rc.AutoCreates("anything", false) {
+		t.Errorf("configured auto-create policy should not be overridden")
+	}
+}
+
+func TestForkPRPolicyLabel(t *testing.T) {
+	rc := RepoConfig{}
+	if err := yaml.Unmarshal([]byte("version: 2\nfork_prs:\n  enabled: true\n"), &rc); err != nil {
+		t.Fatalf("error unmarshaling: %v", err)
+	}
+	if !rc.ForkPRs.Enabled {
+		t.Errorf("should be enabled")
+	}
+	if rc.ForkPRs.Label() != DefaultForkApprovalLabel {
+		t.Errorf("bad default label: %v", rc.ForkPRs.Label())
+	}
+	rc.ForkPRs.ApprovalLabel = "safe-to-test"
+	if rc.ForkPRs.Label() != "safe-to-test" {
+		t.Errorf("bad label: %v", rc.ForkPRs.Label())
+	}
+}
+
+func TestRepoConfigLifetimeDuration(t *testing.T) {
+	tests := []struct {
+		lifetime string
+		want     time.Duration
+		wantErr  bool
+	}{
+		{"", 0, false},
+		{"72h", 72 * time.Hour, false},
+		{"90m", 90 * time.Minute, false},
+		{"3 days", 0, true},
+		{"-1h", 0, true},
+		{"0s", 0, true},
+	}
+	for _, tt := range tests {
+		rc := RepoConfig{}
+		if err := yaml.Unmarshal([]byte("version: 2\nlifetime: \""+tt.lifetime+"\"\n"), &rc); err != nil {
+			t.Fatalf("error unmarshaling: %v", err)
+		}
+		d, err := rc.LifetimeDuration()
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%q: bad error: %v (wantErr: %v)", tt.lifetime, err, tt.wantErr)
+			continue
+		}
+		if d != tt.want {
+			t.Errorf("%q: bad duration: %v (wanted %v)", tt.lifetime, d, tt.want)
+		}
+	}
+}
+
+func TestRepoConfigDependencyDiff(t *testing.T) {
+	prev := RepoConfig{
+		Dependencies: DependencyDeclaration{
+			Direct: []RepoConfigDependency{
+				RepoConfigDependency{Name: "foo", Repo: "acme/foo"},
+				RepoConfigDependency{Name: "bar", Repo: "acme/bar"},
+			},
+			Environment: []RepoConfigDependency{
+				RepoConfigDependency{Name: "redis", ChartRepoPath: "acme/charts@master:redis"},
+				RepoConfigDependency{Name: "postgres", ChartRepoPath: "acme/charts@master:postgres"},
+			},
+		},
+	}
+	rc := RepoConfig{
+		Dependencies: DependencyDeclaration{
+			Direct: []RepoConfigDependency{
+				RepoConfigDependency{Name: "foo", Repo: "acme/foo", Requires: []string{"redis"}},
+				RepoConfigDependency{Name: "bar", Repo: "acme/bar2"},
+			},
+			Environment: []RepoConfigDependency{
+				RepoConfigDependency{Name: "redis", ChartRepoPath: "acme/charts@master:redis"},
+				RepoConfigDependency{Name: "memcached", ChartRepoPath: "acme/charts@master:memcached"},
+			},
+		},
+	}
+	diff := rc.DependencyDiff(prev)
+	join := func(s []string) string { return strings.Join(s, ",") }
+	if join(diff.Upgrade) != "foo,redis" {
+		t.Errorf("bad upgrade: %v", diff.Upgrade)
+	}
+	if join(diff.Install) != "bar,memcached" {
+		t.Errorf("bad install: %v", diff.Install)
+	}
+	if join(diff.Uninstall) != "bar,postgres" {
+		t.Errorf("bad uninstall: %v", diff.Uninstall)
+	}
+	diff = rc.DependencyDiff(rc)
+	if len(diff.Install) != 0 || len(diff.Uninstall) != 0 || len(diff.Upgrade) != 4 {
+		t.Errorf("identical configs should only upgrade: %+v", diff)
+	}
+}
+
+func TestRepoConfigValidateHealthChecks(t *testing.T) {
+	web := HealthCheck{Name: "web", HTTP: &HTTPHealthCheck{URL: "http://web/healthz"}}
+	cases := []struct {
+		name        string
+		input       string
+		errContains string
+	}{
+		{
+			name: "valid",
+			input: `
+application:
+  health_checks:
+    - name: web
+      http:
+        url: "http://web.{{ .K8sNamespace }}/healthz"
+    - name: db
+      tcp:
+        address: "db:5432"
+dependencies:
+  direct:
+    - name: something
+      chart_path: ".charts/something"
+      health_checks:
+        - name: web
+          exec:
+            selector: "app=something"
+            command: ["true"]
+`,
+		},
+		{
+			name: "missing type",
+			input: `
+application:
+  health_checks:
+    - name: web
+`,
+			errContains: "exactly one of http, tcp or exec is required",
+		},
+		{
+			name: "multiple types",
+			input: `
+application:
+  health_checks:
+    - name: web
+      http:
+        url: "http://web"
+      tcp:
+        address: "web:80"
+`,
+			errContains: "exactly one of http, tcp or exec is required",
+		},
+		{
+			name: "missing exec command",
+			input: `
+application:
+  health_checks:
+    - name: web
+      exec:
+        selector: "app=web"
+`,
+			errContains: "exec selector and command are required",
+		},
+		{
+			name: "duplicate name",
+			input: `
+dependencies:
+  environment:
+    - name: something
+      chart_path: ".charts/something"
+      health_checks:
+        - name: web
+          tcp:
+            address: "web:80"
+        - name: web
+          tcp:
+            address: "web:8080"
+`,
+			errContains: "duplicate health check name for something: web",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			rc := RepoConfig{}
+			if err := yaml.Unmarshal([]byte(c.input), &rc); err != nil {
+				t.Fatalf("error unmarshaling: %v", err)
+			}
+			rc.Application.Repo = "acme/foo"
+			err := rc.ValidateHealthChecks()
+			if c.errContains == "" {
+				if err != nil {
+					t.Fatalf("should have succeeded: %v", err)
+				}
+				hcs := rc.HealthChecks()
+				if len(hcs["acme-foo"]) != 2 || len(hcs["something"]) != 1 {
+					t.Fatalf("bad health checks: %+v", hcs)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), c.errContains) {
+				t.Fatalf("expected error containing %q: %v", c.errContains, err)
+			}
+		})
+	}
+	rcd := RepoConfigDependency{HealthChecks: []HealthCheck{web}, AppMetadata: RepoConfigAppMetadata{HealthChecks: []HealthCheck{web}}}
+	if n := len(rcd.AllHealthChecks()); n != 2 {
+		t.Errorf("bad dependency health check count: %v", n)
+	}
+	if hc := (HealthCheck{}); hc.Attempts() != DefaultHealthCheckRetries+1 || hc.Timeout() != DefaultHealthCheckTimeout || hc.Interval() != DefaultHealthCheckInterval {
+		t.Errorf("bad defaults")
+	}
+}
+
+func TestRepoConfigValidateTests(t *testing.T) {
+	rc := RepoConfig{
+		Application: RepoConfigAppMetadata{Repo: "acme/foo"},
+		Dependencies: DependencyDeclaration{
+			Direct: []RepoConfigDependency{RepoConfigDependency{Name: "postgres"}},
+		},
+	}
+	cases := []struct {
+		name        string
+		tests       RepoConfigTests
+		errContains string
+	}{
+		{
+			name:  "valid",
+			tests: RepoConfigTests{Jobs: []TestJob{TestJob{Name: "integration", Image: "acme/tests"}}, HelmTests: []string{"acme-foo", "postgres"}},
+		},
+		{
+			name:        "missing image",
+			tests:       RepoConfigTests{Jobs: []TestJob{TestJob{Name: "integration"}}},
+			errContains: "name and image are required",
+		},
+		{
+			name:        "invalid name",
+			tests:       RepoConfigTests{Jobs: []TestJob{TestJob{Name: "Integration_Tests", Image: "acme/tests"}}},
+			errContains: "invalid test job name",
+		},
+		{
+			name:        "duplicate name",
+			tests:       RepoConfigTests{Jobs: []TestJob{TestJob{Name: "integration", Image: "acme/tests"}, TestJob{Name: "integration", Image: "acme/tests2"}}},
+			errContains: "duplicate test job name",
+		},
+		{
+			name:        "unknown helm test",
+			tests:       RepoConfigTests{HelmTests: []string{"redis"}},
+			errContains: "unknown application or dependency: redis",
+		},
+		{
+			name:        "environment context",
+			tests:       RepoConfigTests{Context: "Acyl", HelmTests: []string{"postgres"}},
+			errContains: "same as the environment status context",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			rc.Tests = c.tests
+			err := rc.ValidateTests()
+			if c.errContains == "" {
+				if err != nil {
+					t.Fatalf("should have succeeded: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), c.errContains) {
+				t.Fatalf("expected error containing %q: %v", c.errContains, err)
+			}
+		})
+	}
+}
+
+func TestDefaultNotificationTemplatesWithoutPR(t *testing.T) {
+	d := NotificationData{EnvName: "foo-bar", Repo: "foo/bar", SourceBranch: "v1.0.0", SourceRef: "v1.0.0", BaseBranch: "master"}
+	for event, nt := range DefaultNotificationTemplates {
+		rn, err := nt.Render(d)
+		if err != nil {
+			t.Fatalf("error rendering %v: %v", event, err)
+		}
+		for _, s := range rn.Sections {
+			if strings.Contains(s.Text, "PR #") || strings.Contains(s.Text, "/pull/") {
+				t.Fatalf("%v should not refer to a PR: %v", event, s.Text)
+			}
+		}
+		if !strings.Contains(rn.Sections[0].Text, "Ref: v1.0.0") {
+			t.Fatalf("%v should include the ref: %v", event, rn.Sections[0].Text)
+		}
+	}
+	d.PullRequest = 3
+	rn, err := DefaultNotificationTemplates["success"].Render(d)
+	if err != nil {
+		t.Fatalf("error rendering: %v", err)
+	}
+	if !strings.Contains(rn.Sections[1].Text, "https://github.com/foo/bar/pull/3") {
+		t.Fatalf("success should link to the PR: %v", rn.Sections[1].Text)
+	}
+}
@@ -0,0 +1,41 @@
+package models
+
+// NamespaceConfig models the resource guardrails for an environment namespace
+// Server defaults can be overridden per repo in acyl.yml (within the server ceilings).
+// Resource quantities use Kubernetes quantity syntax (ex: "500m", "2Gi").
+type NamespaceConfig struct {
+	ResourceQuota *ResourceQuotaConfig `yaml:"resource_quota" json:"resource_quota,omitempty"`
+	LimitRange    *LimitRangeConfig    `yaml:"limit_range" json:"limit_range,omitempty"`
+	NetworkPolicy *NetworkPolicyConfig `yaml:"network_policy" json:"network_policy,omitempty"`
+}
+
+// ResourceQuotaConfig models the namespace ResourceQuota
+type ResourceQuotaConfig struct {
+	// Hard is a map of resource name (ex: "requests.cpu", "pods") to quantity
+	Hard map[string]string `yaml:"hard" json:"hard"`
+}
+
+// LimitRangeConfig models the namespace LimitRange for containers
+type LimitRangeConfig struct {
+	// Default is the default limit for containers that don't declare one (resource name to quantity)
+	Default map[string]string `yaml:"default" json:"default"`
+	// DefaultRequest is the default request for containers that don't declare one (resource name to quantity)
+	DefaultRequest map[string]string `yaml:"default_request" json:"default_request"`
+	// Max is the maximum limit for any container (resource name to quantity)
+	Max map[string]string `yaml:"max" json:"max"`
+}
+
+// NetworkPolicyConfig models a default-deny NetworkPolicy for the namespace
+// Traffic between pods in the namespace and DNS egress are always allowed.
+type NetworkPolicyConfig struct {
+	Enabled *bool `yaml:"enabled" json:"enabled,omitempty"`
+	// AllowIngressNamespaces are the names of namespaces allowed to connect to pods in the environment namespace
+	AllowIngressNamespaces []string `yaml:"allow_ingress_namespaces" json:"allow_ingress_namespaces"`
+	// AllowEgressCIDRs are the IP ranges that pods in the environment namespace are allowed to connect to
+	AllowEgressCIDRs []string `yaml:"allow_egress_cidrs" json:"allow_egress_cidrs"`
+}
+
+// IsEnabled indicates whether the network policy is enabled
+func (npc *NetworkPolicyConfig) IsEnabled() bool {
+	return npc != nil && npc.Enabled != nil && *npc.Enabled
+}
@@ -20,19 +20,33 @@ type RepoConfigDependency struct {
 	Repo               string                `yaml:"repo" json:"repo"`                       // Repo indicates a GitHub repository which contains a top-level acyl.yml
 	ChartPath          string                `yaml:"chart_path" json:"chart_path"`           // Path to the chart within the triggering repository
 	ChartRepoPath      string                `yaml:"chart_repo_path" json:"chart_repo_path"` // GitHub repo and path to chart (no acyl.yml)
+	ChartRepo          *ChartRepoSource      `yaml:"chart_repo" json:"chart_repo,omitempty"` // Chart in a Helm chart repository
+	ChartOCI           *ChartOCISource       `yaml:"chart_oci" json:"chart_oci,omitempty"`   // Chart in an OCI registry
 	ChartVarsPath      string                `yaml:"chart_vars_path" json:"chart_vars_path"`
 	ChartVarsRepoPath  string                `yaml:"chart_vars_repo_path" json:"chart_vars_repo_path"`
 	DisableBranchMatch bool                  `yaml:"disable_branch_match" json:"disable_branch_match"`
 	DefaultBranch      string                `yaml:"default_branch" json:"default_branch"`
 	Requires           []string              `yaml:"requires" json:"requires"`
 	ValueOverrides     []string              `yaml:"value_overrides" json:"value_overrides"`
+	HealthChecks       []HealthCheck         `yaml:"health_checks" json:"health_checks"`
 	AppMetadata        RepoConfigAppMetadata `yaml:"-" json:"app_metadata"` // set by nitro
 	Parent             string                `yaml:"-" json:"-"`            // Name of the parent dependency if this is a transitive dep, set by nitro
 }
 
 // BranchMatchable indicates whether the depencency can participate in branch matching and can be found in RefMap
 func (rcd RepoConfigDependency) BranchMatchable() bool {
-	return rcd.Repo != "" && rcd.ChartPath == "" && rcd.ChartRepoPath == ""
+	return rcd.Repo != "" && rcd.ChartPath == "" && rcd.ChartRepoPath == "" && rcd.ChartRepo == nil && rcd.ChartOCI == nil
+}
+
+// chartSource returns a string representation of the Helm chart repository or OCI registry chart source, if any
+func (rcd RepoConfigDependency) chartSource() string {
+	switch {
+	case rcd.ChartRepo != nil:
+		return rcd.ChartRepo.String()
+	case rcd.ChartOCI != nil:
+		return rcd.ChartOCI.String()
+	}
+	return ""
 }
 
 func truncateString(s string, n uint) string {
@@ -58,6 +72,8 @@ type RepoConfigAppMetadata struct {
 	Branch            string            `yaml:"-" json:"branch"` // set by nitro
 	ChartPath         string            `yaml:"chart_path" json:"chart_path"`
 	ChartRepoPath     string            `yaml:"chart_repo_path" json:"chart_repo_path"`
+	ChartRepo         *ChartRepoSource  `yaml:"-" json:"chart_repo,omitempty"` // set by nitro (dependencies only)
+	ChartOCI          *ChartOCISource   `yaml:"-" json:"chart_oci,omitempty"`  // set by nitro (dependencies only)
 	ChartVarsPath     string            `yaml:"chart_vars_path" json:"chart_vars_path"`
 	ChartVarsRepoPath string            `yaml:"chart_vars_repo_path" json:"chart_vars_repo_path"`
 	Image             string            `yaml:"image" json:"image"`
@@ -67,6 +83,7 @@ type RepoConfigAppMetadata struct {
 	NamespaceValue    string            `yaml:"namespace_value" json:"namespace_value"`
 	EnvNameValue      string            `yaml:"env_name_value" json:"env_name_value"`
 	ValueOverrides    []string          `yaml:"value_overrides" json:"value_overrides"`
+	HealthChecks      []HealthCheck     `yaml:"health_checks" json:"health_checks"`
 }
 
 const (
@@ -97,9 +114,147 @@ type RepoConfig struct {
 	Version        uint                  `yaml:"version" json:"version"`
 	TargetBranches []string              `yaml:"target_branches" json:"target_branches"`
 	TrackBranches  []string              `json:"track_branches" yaml:"track_branches"`
+	TriggerLabels  []string              `yaml:"trigger_labels" json:"trigger_labels"`
+	AutoCreate     *AutoCreatePolicy     `yaml:"auto_create" json:"auto_create"`
+	ForkPRs        ForkPRPolicy          `yaml:"fork_prs" json:"fork_prs"`
 	Application    RepoConfigAppMetadata `yaml:"application" json:"application"`
 	Dependencies   DependencyDeclaration `yaml:"dependencies" json:"dependencies"`
 	Notifications  Notifications         `yaml:"notifications" json:"notifications"`
+	Tests          RepoConfigTests       `yaml:"tests" json:"tests"`
+	Namespace      NamespaceConfig       `yaml:"namespace" json:"namespace"`
+	// RBACProfile is the name of the server-defined RBAC profile for the environment service account (it must be allowed for the repo)
+	RBACProfile string `yaml:"rbac_profile" json:"rbac_profile"`
+	// Secrets are the names of the server-defined secret injections the environment needs (they must be permitted for the repo)
+	Secrets []string `yaml:"secrets" json:"secrets"`
+	// Placement selects the cluster the environment is created in (if multiple clusters are configured)
+	Placement PlacementConfig `yaml:"placement" json:"placement"`
+	// Lifetime is how long the environment lives before it is destroyed, as a duration string (eg "72h"), within the server bounds.
+	// It is reset by each update, and may be extended with the API, the extend label or the extend comment command.
+	Lifetime string `yaml:"lifetime" json:"lifetime"`
+}
+
+// PlacementConfig models the cluster selection for an environment. If empty, the cluster is chosen by repo affinity and load.
+type PlacementConfig struct {
+	// Cluster is the name of a server-defined cluster
+	Cluster string `yaml:"cluster" json:"cluster"`
+	// Labels are cluster labels that the chosen cluster must have
+	Labels map[string]string `yaml:"labels" json:"labels"`
+}
+
+// AutoCreatePolicy describes when environments are created for PRs that don't have a trigger label
+type AutoCreatePolicy struct {
+	Enabled bool `yaml:"enabled" json:"enabled"`
+	// SkipDrafts delays creation of environments for draft PRs until they are marked ready for review
+	SkipDrafts bool `yaml:"skip_drafts" json:"skip_drafts"`
+}
+
+// ForkPRPolicy describes whether environments are created for PRs from forked repositories
+// It is always read from the acyl.yml of the base branch so that it can't be changed by the fork.
+// Fork PR environments are only built after a user with write access adds the approval label (or comments with the approve command)
+// and the approval is revoked by any subsequent push to the PR.
+type ForkPRPolicy struct {
+	Enabled       bool   `yaml:"enabled" json:"enabled"`
+	ApprovalLabel string `yaml:"approval_label" json:"approval_label"`
+}
+
+// DefaultForkApprovalLabel is the PR label that approves building an environment for a fork PR if no approval label is configured
+const DefaultForkApprovalLabel = "acyl-approved"
+
+// Label returns the approval label
+func (fp ForkPRPolicy) Label() string {
+	if fp.ApprovalLabel == "" {
+		return DefaultForkApprovalLabel
+	}
+	return fp.ApprovalLabel
+}
+
+// DefaultTriggerLabel is the PR label that triggers environment creation if no trigger labels are configured
+const DefaultTriggerLabel = "acyl"
+
+// DefaultPinLabel is the PR label that pins the environment (exempting it from eviction) if no pin label is configured
+const DefaultPinLabel = "acyl-pin"
+
+// DefaultExtendLabel is the PR label that extends the environment lifetime if no extend label is configured
+const DefaultExtendLabel = "acyl-extend"
+
+// SetTriggerDefaults sets the trigger labels and auto-create policy to the supplied defaults if they are not set
+// If labels is empty, DefaultTriggerLabel is used
+func (rc *RepoConfig) SetTriggerDefaults(labels []string, autoCreate AutoCreatePolicy) {
+	if len(rc.TriggerLabels) == 0 {
+		rc.TriggerLabels = labels
+	}
+	if len(rc.TriggerLabels) == 0 {
+		rc.TriggerLabels = []string{DefaultTriggerLabel}
+	}
+	if rc.AutoCreate == nil {
+		rc.AutoCreate = &autoCreate
+	}
+}
+
+// TargetsBranch returns whether branch is one of the configured target branches (or true if there are none)
+func (rc RepoConfig) TargetsBranch(branch string) bool {
+	if len(rc.TargetBranches) == 0 {
+		return true
+	}
+	for _, b := range rc.TargetBranches {
+		if b == branch {
+			return true
+		}
+	}
+	return false
+}
+
+// HasTriggerLabel returns whether any of labels is one of the configured trigger labels
+func (rc RepoConfig) HasTriggerLabel(labels ...string) bool {
+	for _, l := range labels {
+		for _, tl := range rc.TriggerLabels {
+			if l == tl {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// AutoCreates returns whether the auto-create policy applies to a PR with the base branch and draft status
+func (rc RepoConfig) AutoCreates(baseBranch string, draft bool) bool {
+	if rc.AutoCreate == nil || !rc.AutoCreate.Enabled {
+		return false
+	}
+	if draft && rc.AutoCreate.SkipDrafts {
+		return false
+	}
+	return rc.TargetsBranch(baseBranch)
+}
+
+// TracksBranch returns whether branch is one of the configured tracked branches
+func (rc RepoConfig) TracksBranch(branch string) bool {
+	for _, b := range rc.TrackBranches {
+		if b == branch {
+			return true
+		}
+	}
+	return false
+}
+
+// AdHoc returns whether rd describes an ad-hoc environment, created on request from a branch, tag or commit rather than for a PR or a tracked branch
+func (rc RepoConfig) AdHoc(rd RepoRevisionData) bool {
+	return rd.PullRequest == 0 && !rc.TracksBranch(rd.SourceBranch)
+}
+
+// LifetimeDuration returns the parsed environment lifetime, or zero if it isn't set (the server default applies)
+func (rc RepoConfig) LifetimeDuration() (time.Duration, error) {
+	if rc.Lifetime == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(rc.Lifetime)
+	if err != nil {
+		return 0, nitroerrors.User(fmt.Errorf("invalid lifetime: %w", err))
+	}
+	if d <= 0 {
+		return 0, nitroerrors.User(fmt.Errorf("lifetime must be positive: %v", rc.Lifetime))
+	}
+	return d, nil
 }
 
 // RefMap generates RefMap for a particular environment
@@ -230,6 +385,7 @@ func (rc RepoConfig) ConfigSignature() [32]byte {
 		buf.Write([]byte(d.Repo))
 		buf.Write([]byte(d.ChartPath))
 		buf.Write([]byte(d.ChartRepoPath))
+		buf.Write([]byte(d.chartSource()))
 		buf.Write([]byte(strings.Join(d.Requires, "")))
 	}
 	for _, d := range rc.Dependencies.Direct {
@@ -241,6 +397,58 @@ func (rc RepoConfig) ConfigSignature() [32]byte {
 	return sha3.Sum256(buf.Bytes())
 }
 
+// DependencySignatures returns a hash of the configuration of each dependency, by name
+// Unlike ConfigSignature, requirements are not included since they only affect the order of installation.
+func (rc RepoConfig) DependencySignatures() map[string][32]byte {
+	out := map[string][32]byte{}
+	for _, d := range rc.Dependencies.All() {
+		buf := bytes.NewBuffer([]byte{})
+		buf.Write([]byte(d.Name))
+		buf.Write([]byte(d.Repo))
+		buf.Write([]byte(d.ChartPath))
+		buf.Write([]byte(d.ChartRepoPath))
+		buf.Write([]byte(d.chartSource()))
+		out[d.Name] = sha3.Sum256(buf.Bytes())
+	}
+	return out
+}
+
+// DependencyDiff models the changes to the dependencies of an environment between two configs
+// A dependency whose configuration changed is both uninstalled and installed.
+type DependencyDiff struct {
+	// Upgrade are the dependencies that are unchanged and can be upgraded in place
+	Upgrade []string
+	// Install are the dependencies that are new or changed
+	Install []string
+	// Uninstall are the dependencies that were removed or changed
+	Uninstall []string
+}
+
+// DependencyDiff returns the dependency changes required to update an environment with the previous config to rc
+func (rc RepoConfig) DependencyDiff(previous RepoConfig) DependencyDiff {
+	out := DependencyDiff{}
+	prev := previous.DependencySignatures()
+	cur := rc.DependencySignatures()
+	for _, d := range rc.Dependencies.All() {
+		psig, ok := prev[d.Name]
+		switch {
+		case !ok:
+			out.Install = append(out.Install, d.Name)
+		case psig != cur[d.Name]:
+			out.Uninstall = append(out.Uninstall, d.Name)
+			out.Install = append(out.Install, d.Name)
+		default:
+			out.Upgrade = append(out.Upgrade, d.Name)
+		}
+	}
+	for _, d := range previous.Dependencies.All() {
+		if _, ok := cur[d.Name]; !ok {
+			out.Uninstall = append(out.Uninstall, d.Name)
+		}
+	}
+	return out
+}
+
 // KubernetesEnvironment models a single environment in k8s
 type KubernetesEnvironment struct {
 	Created         time.Time   `yaml:"created" json:"created"`
@@ -251,30 +459,32 @@ type KubernetesEnvironment struct {
 	ConfigSignature []byte      `yaml:"config_signature" json:"config_signature"`
 	RefMapJSON      string      `yaml:"ref_map_json" json:"ref_map_json"`
 	Privileged      bool        `yaml:"privileged" json:"privileged"`
+	// Cluster is the name of the Kubernetes cluster the environment is placed in (empty for the default cluster)
+	Cluster string `yaml:"cluster" json:"cluster"`
 }
 
 func (ke KubernetesEnvironment) Columns() string {
-	return strings.Join([]string{"created", "updated", "env_name", "namespace", "repo_config_yaml", "config_signature", "ref_map_json", "privileged"}, ",")
+	return strings.Join([]string{"created", "updated", "env_name", "namespace", "repo_config_yaml", "config_signature", "ref_map_json", "privileged", "cluster"}, ",")
 }
 
 func (ke KubernetesEnvironment) InsertColumns() string {
-	return strings.Join([]string{"env_name", "namespace", "repo_config_yaml", "config_signature", "ref_map_json", "privileged"}, ",")
+	return strings.Join([]string{"env_name", "namespace", "repo_config_yaml", "config_signature", "ref_map_json", "privileged", "cluster"}, ",")
 }
 
 func (ke KubernetesEnvironment) UpdateColumns() string {
-	return strings.Join([]string{"namespace", "repo_config_yaml", "config_signature", "ref_map_json", "privileged"}, ",")
+	return strings.Join([]string{"namespace", "repo_config_yaml", "config_signature", "ref_map_json", "privileged", "cluster"}, ",")
 }
 
 func (ke *KubernetesEnvironment) ScanValues() []interface{} {
-	return []interface{}{&ke.Created, &ke.Updated, &ke.EnvName, &ke.Namespace, &ke.RepoConfigYAML, &ke.ConfigSignature, &ke.RefMapJSON, &ke.Privileged}
+	return []interface{}{&ke.Created, &ke.Updated, &ke.EnvName, &ke.Namespace, &ke.RepoConfigYAML, &ke.ConfigSignature, &ke.RefMapJSON, &ke.Privileged, &ke.Cluster}
 }
 
 func (ke *KubernetesEnvironment) InsertValues() []interface{} {
-	return []interface{}{&ke.EnvName, &ke.Namespace, &ke.RepoConfigYAML, &ke.ConfigSignature, &ke.RefMapJSON, &ke.Privileged}
+	return []interface{}{&ke.EnvName, &ke.Namespace, &ke.RepoConfigYAML, &ke.ConfigSignature, &ke.RefMapJSON, &ke.Privileged, &ke.Cluster}
 }
 
 func (ke *KubernetesEnvironment) UpdateValues() []interface{} {
-	return []interface{}{&ke.Namespace, &ke.RepoConfigYAML, &ke.ConfigSignature, &ke.RefMapJSON, &ke.Privileged}
+	return []interface{}{&ke.Namespace, &ke.RepoConfigYAML, &ke.ConfigSignature, &ke.RefMapJSON, &ke.Privileged, &ke.Cluster}
 }
 
 func (ke KubernetesEnvironment) params(colfunc func() string) string {
@@ -332,6 +542,7 @@ func (hr HelmRelease) InsertParams() string {
 type Notifications struct {
 	Slack     SlackNotifications              `yaml:"slack" json:"slack"`
 	GitHub    GitHubNotifications             `yaml:"github" json:"github"`
+	Webhooks  []WebhookNotification           `yaml:"webhooks" json:"webhooks"`
 	Templates map[string]NotificationTemplate `yaml:"templates" json:"templates"`
 }
 
@@ -345,12 +556,27 @@ func (n *Notifications) FillMissingTemplates() {
 			n.Templates[k] = v
 		}
 	}
+	if n.GitHub.PRCommentTemplates == nil {
+		n.GitHub.PRCommentTemplates = make(map[string]NotificationTemplate)
+	}
+	for k, v := range DefaultPRCommentTemplates {
+		if _, ok := n.GitHub.PRCommentTemplates[k]; !ok {
+			n.GitHub.PRCommentTemplates[k] = v
+		}
+	}
 }
 
 // GitHubNotifications models GitHub notification options
 type GitHubNotifications struct {
-	PRComments     bool           `yaml:"pr_comments" json:"pr_comments"`
-	CommitStatuses CommitStatuses `yaml:"commit_statuses" json:"commit_statuses"`
+	PRComments bool `yaml:"pr_comments" json:"pr_comments"`
+	// PRCommentTemplates are markdown templates used to render the PR comment, keyed the same as Notifications.Templates
+	PRCommentTemplates map[string]NotificationTemplate `yaml:"pr_comment_templates" json:"pr_comment_templates"`
+	CommitStatuses     CommitStatuses                  `yaml:"commit_statuses" json:"commit_statuses"`
+}
+
+// WebhookNotification models a URL that receives signed JSON notifications for all environment events
+type WebhookNotification struct {
+	URL string `yaml:"url" json:"url"`
 }
 
 // SlackNotifications models configuration for slack notifications in acyl.yml v2
@@ -360,6 +586,9 @@ type SlackNotifications struct {
 	Users               []string  `yaml:"users" json:"users"`
 }
 
+// notificationRevisionText describes the repo and PR, or the ref for environments that are not associated with a PR
+const notificationRevisionText = "{{ .Repo }}\n{{ if .PullRequest }}PR #{{ .PullRequest }}: {{ .SourceBranch }} ➡️ {{ .BaseBranch }}{{ else }}Ref: {{ .SourceRef }}{{ end }}"
+
 // DefaultNotificationTemplates are the default notification templates if none are supplied
 var DefaultNotificationTemplates = map[string]NotificationTemplate{
 	"create": NotificationTemplate{
@@ -367,7 +596,7 @@ var DefaultNotificationTemplates = map[string]NotificationTemplate{
 		Sections: []NotificationTemplateSection{
 			NotificationTemplateSection{
 				Title: "{{ .EnvName }}",
-				Text:  "{{ .Repo }}\nPR #{{ .PullRequest }}: {{ .SourceBranch }} ➡️ {{ .BaseBranch }}",
+				Text:  notificationRevisionText,
 				Style: "good",
 			},
 		},
@@ -377,17 +606,17 @@ var DefaultNotificationTemplates = map[string]NotificationTemplate{
 		Sections: []NotificationTemplateSection{
 			NotificationTemplateSection{
 				Title: "{{ .EnvName }}",
-				Text:  "{{ .Repo }}\nPR #{{ .PullRequest }}: {{ .SourceBranch }} ➡️ {{ .BaseBranch }}\nUpdating to commit:\nhttps://github.com/{{ .Repo }}/commit/{{ .SourceSHA }}\n\"{{ .CommitMessage }}\" - {{ .User }}",
+				Text:  notificationRevisionText + "\nUpdating to commit:\nhttps://github.com/{{ .Repo }}/commit/{{ .SourceSHA }}\n\"{{ .CommitMessage }}\" - {{ .User }}",
 				Style: "warning",
 			},
 		},
 	},
 	"destroy": NotificationTemplate{
-		Title: `💣 Destroying Environment{{ if eq .Event "EnvironmentLimitExceeded" }} (Environment Limit Exceeded){{ end }}`,
+		Title: `💣 Destroying Environment{{ if eq .Event "EnvironmentLimitExceeded" }} (Environment Limit Exceeded){{ else if .EvictionPolicy }} (Evicted){{ end }}`,
 		Sections: []NotificationTemplateSection{
 			NotificationTemplateSection{
 				Title: "{{ .EnvName }}",
-				Text:  "{{ .Repo }}\nPR #{{ .PullRequest }}: {{ .SourceBranch }} ➡️ {{ .BaseBranch }}",
+				Text:  notificationRevisionText + "{{ if .EvictionPolicy }}\nEvicted by the {{ .EvictionPolicy }} eviction policy: {{ .EvictionReason }}{{ end }}",
 				Style: "warning",
 			},
 		},
@@ -397,11 +626,11 @@ var DefaultNotificationTemplates = map[string]NotificationTemplate{
 		Sections: []NotificationTemplateSection{
 			NotificationTemplateSection{
 				Title: "{{ .EnvName }}",
-				Text:  "{{ .Repo }}\nPR #{{ .PullRequest }}: {{ .SourceBranch }} ➡️ {{ .BaseBranch }}",
+				Text:  notificationRevisionText,
 				Style: "good",
 			},
 			NotificationTemplateSection{
-				Text:  "https://github.com/{{ .Repo }}/pull/{{ .PullRequest }}\nK8s Namespace: {{ .K8sNamespace }}",
+				Text:  "{{ if .PullRequest }}https://github.com/{{ .Repo }}/pull/{{ .PullRequest }}{{ else }}https://github.com/{{ .Repo }}/tree/{{ .SourceRef }}{{ end }}\nK8s Namespace: {{ .K8sNamespace }}",
 				Style: "good",
 			},
 		},
@@ -411,7 +640,7 @@ var DefaultNotificationTemplates = map[string]NotificationTemplate{
 		Sections: []NotificationTemplateSection{
 			NotificationTemplateSection{
 				Title: "{{ .EnvName }}",
-				Text:  "{{ .Repo }}\nPR #{{ .PullRequest }}: {{ .SourceBranch }} ➡️ {{ .BaseBranch }}",
+				Text:  notificationRevisionText,
 				Style: "danger",
 			},
 			NotificationTemplateSection{
@@ -421,6 +650,72 @@ var DefaultNotificationTemplates = map[string]NotificationTemplate{
 			},
 		},
 	},
+	"expiring": NotificationTemplate{
+		Title: "⏳ Environment Expiring",
+		Sections: []NotificationTemplateSection{
+			NotificationTemplateSection{
+				Title: "{{ .EnvName }}",
+				Text:  notificationRevisionText + "\nThe environment will be destroyed at {{ .Expires }} unless its lifetime is extended.",
+				Style: "warning",
+			},
+		},
+	},
+}
+
+// DefaultPRCommentTemplates are the default markdown templates for PR comment notifications if none are supplied
+var DefaultPRCommentTemplates = map[string]NotificationTemplate{
+	"create": NotificationTemplate{
+		Title: "🛠 Creating Environment",
+		Sections: []NotificationTemplateSection{
+			NotificationTemplateSection{
+				Text: "Environment `{{ .EnvName }}` is being created from commit {{ .SourceSHA }} ({{ .SourceBranch }} ➡️ {{ .BaseBranch }}).",
+			},
+		},
+	},
+	"update": NotificationTemplate{
+		Title: "🚦 Updating Environment",
+		Sections: []NotificationTemplateSection{
+			NotificationTemplateSection{
+				Text: "Environment `{{ .EnvName }}` is being updated to commit {{ .SourceSHA }}:\n\n> {{ .CommitMessage }}",
+			},
+		},
+	},
+	"destroy": NotificationTemplate{
+		Title: `💣 Environment Destroyed{{ if eq .Event "EnvironmentLimitExceeded" }} (Environment Limit Exceeded){{ else if .EvictionPolicy }} (Evicted){{ end }}`,
+		Sections: []NotificationTemplateSection{
+			NotificationTemplateSection{
+				Text: "Environment `{{ .EnvName }}` has been destroyed.{{ if .EvictionPolicy }} It was evicted by the {{ .EvictionPolicy }} eviction policy: {{ .EvictionReason }}.{{ end }}",
+			},
+		},
+	},
+	"success": NotificationTemplate{
+		Title: "🏁 Environment Ready",
+		Sections: []NotificationTemplateSection{
+			NotificationTemplateSection{
+				Text: "Environment `{{ .EnvName }}` is up at commit {{ .SourceSHA }}.\n\n| | |\n|---|---|\n| Branch | {{ .SourceBranch }} ➡️ {{ .BaseBranch }} |\n| K8s Namespace | `{{ .K8sNamespace }}` |",
+			},
+		},
+	},
+	"failure": NotificationTemplate{
+		Title: "❌☠️ Environment Error",
+		Sections: []NotificationTemplateSection{
+			NotificationTemplateSection{
+				Text: "Environment `{{ .EnvName }}` failed at commit {{ .SourceSHA }}.",
+			},
+			NotificationTemplateSection{
+				Title: "Error",
+				Text:  "```\n{{ .ErrorMessage }}\n```",
+			},
+		},
+	},
+	"expiring": NotificationTemplate{
+		Title: "⏳ Environment Expiring",
+		Sections: []NotificationTemplateSection{
+			NotificationTemplateSection{
+				Text: "Environment `{{ .EnvName }}` will be destroyed at {{ .Expires }}. Comment `/acyl extend` to extend its lifetime.",
+			},
+		},
+	},
 }
 
 // NotificationTemplate models a notification template for an event
@@ -431,8 +726,24 @@ type NotificationTemplate struct {
 
 // NotificationData models the data available to notification templates (all events)
 type NotificationData struct {
-	EnvName, Repo, SourceBranch, SourceSHA, BaseBranch, BaseSHA, CommitMessage, ErrorMessage, User, K8sNamespace, Event string
-	PullRequest                                                                                                         uint
+	EnvName       string `json:"env_name"`
+	Repo          string `json:"repo"`
+	SourceBranch  string `json:"source_branch"`
+	SourceRef     string `json:"source_ref"`
+	SourceSHA     string `json:"source_sha"`
+	BaseBranch    string `json:"base_branch"`
+	BaseSHA       string `json:"base_sha"`
+	CommitMessage string `json:"commit_message"`
+	ErrorMessage  string `json:"error_message"`
+	User          string `json:"user"`
+	K8sNamespace  string `json:"k8s_namespace"`
+	Event         string `json:"event"`
+	PullRequest   uint   `json:"pull_request"`
+	// EvictionPolicy and EvictionReason are set for destroy events if the environment was evicted to comply with the global limit or a quota
+	EvictionPolicy string `json:"eviction_policy,omitempty"`
+	EvictionReason string `json:"eviction_reason,omitempty"`
+	// Expires is the time the environment will be destroyed (RFC 3339), if it has a lifetime
+	Expires string `json:"expires,omitempty"`
 }
 
 func (nt NotificationTemplate) Render(d NotificationData) (*RenderedNotification, error) {
@@ -482,11 +793,13 @@ type NotificationTemplateSection struct {
 
 // RenderedNotification models a rendered notification template for an event
 type RenderedNotification struct {
-	Title    string
-	Sections []RenderedNotificationSection
+	Title    string                        `json:"title"`
+	Sections []RenderedNotificationSection `json:"sections"`
 }
 
 // RenderedNotificationSection models a rendered section of a notification
 type RenderedNotificationSection struct {
-	Title, Text, Style string
+	Title string `json:"title"`
+	Text  string `json:"text"`
+	Style string `json:"style"`
 }
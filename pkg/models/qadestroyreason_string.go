@@ -15,11 +15,14 @@ func _() {
 	_ = x[CreateFoundStale-4]
 	_ = x[DestroyApiRequest-5]
 	_ = x[EnvironmentLimitExceeded-6]
+	_ = x[ReapEnvironmentQuotaExceeded-7]
+	_ = x[EnvironmentQuotaExceeded-8]
+	_ = x[ReapEnvironmentExpired-9]
 }
 
-const _QADestroyReason_name = "ReapAgeSpawnedReapAgeFailureReapPrClosedReapEnvironmentLimitExceededCreateFoundStaleDestroyApiRequestEnvironmentLimitExceeded"
+const _QADestroyReason_name = "ReapAgeSpawnedReapAgeFailureReapPrClosedReapEnvironmentLimitExceededCreateFoundStaleDestroyApiRequestEnvironmentLimitExceededReapEnvironmentQuotaExceededEnvironmentQuotaExceededReapEnvironmentExpired"
 
-var _QADestroyReason_index = [...]uint8{0, 14, 28, 40, 68, 84, 101, 125}
+var _QADestroyReason_index = [...]uint8{0, 14, 28, 40, 68, 84, 101, 125, 153, 177, 199}
 
 func (i QADestroyReason) String() string {
 	if i < 0 || i >= QADestroyReason(len(_QADestroyReason_index)-1) {
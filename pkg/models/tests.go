@@ -0,0 +1,97 @@
+package models
+
+import (
+	"fmt"
+	"regexp"
+	"time"
+
+	nitroerrors "github.com/dollarshaveclub/acyl/pkg/nitro/errors"
+)
+
+// RepoConfigTests models post-install tests that are run after each successful environment create or update
+// Test results are reported as a separate GitHub commit status (Context) and don't affect the environment status.
+type RepoConfigTests struct {
+	// Context is the GitHub commit status context
+	Context string `yaml:"context" json:"context"`
+	// TimeoutSeconds is the maximum time for all tests to complete
+	TimeoutSeconds uint `yaml:"timeout_seconds" json:"timeout_seconds"`
+	// Jobs are run as Kubernetes Jobs in the environment namespace
+	Jobs []TestJob `yaml:"jobs" json:"jobs"`
+	// HelmTests are the names of the application (repo name with "/" replaced by "-") or dependencies whose releases are tested with "helm test"
+	HelmTests []string `yaml:"helm_tests" json:"helm_tests"`
+}
+
+// TestJob models a Kubernetes Job that passes if it completes successfully
+// Image and env values are templates with the fields EnvName, K8sNamespace and Ref (the triggering revision SHA).
+type TestJob struct {
+	Name    string            `yaml:"name" json:"name"`
+	Image   string            `yaml:"image" json:"image"`
+	Command []string          `yaml:"command" json:"command"`
+	Args    []string          `yaml:"args" json:"args"`
+	Env     map[string]string `yaml:"env" json:"env"`
+	// BackoffLimit is the number of retries before the job is considered failed
+	BackoffLimit int32 `yaml:"backoff_limit" json:"backoff_limit"`
+	// ServiceAccount is the service account the job pod runs as (defaults to the namespace default)
+	ServiceAccount string `yaml:"service_account" json:"service_account"`
+}
+
+// Test defaults
+const (
+	DefaultTestsContext = "Acyl Tests"
+	DefaultTestsTimeout = 30 * time.Minute
+)
+
+// Enabled indicates whether any tests are declared
+func (rct RepoConfigTests) Enabled() bool {
+	return len(rct.Jobs) > 0 || len(rct.HelmTests) > 0
+}
+
+// StatusContext returns the GitHub commit status context for test results
+func (rct RepoConfigTests) StatusContext() string {
+	if rct.Context == "" {
+		return DefaultTestsContext
+	}
+	return rct.Context
+}
+
+// Timeout returns the maximum time for all tests to complete
+func (rct RepoConfigTests) Timeout() time.Duration {
+	if rct.TimeoutSeconds == 0 {
+		return DefaultTestsTimeout
+	}
+	return time.Duration(rct.TimeoutSeconds) * time.Second
+}
+
+// testJobNameRegex matches valid test job names (DNS-1123 labels, since they are used in Kubernetes object names)
+var testJobNameRegex = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`)
+
+// ValidateTests indicates whether the declared tests are valid: job names must be unique and
+// helm tests must reference the application or a dependency
+func (rc RepoConfig) ValidateTests() error {
+	if rc.Tests.Context == "Acyl" {
+		return nitroerrors.User(fmt.Errorf("tests context cannot be the same as the environment status context"))
+	}
+	names := map[string]struct{}{}
+	for i, tj := range rc.Tests.Jobs {
+		if tj.Name == "" || tj.Image == "" {
+			return nitroerrors.User(fmt.Errorf("test job at offset %v: name and image are required", i))
+		}
+		if !testJobNameRegex.MatchString(tj.Name) {
+			return nitroerrors.User(fmt.Errorf("invalid test job name (must be lowercase alphanumeric characters or '-'): %v", tj.Name))
+		}
+		if _, ok := names[tj.Name]; ok {
+			return nitroerrors.User(fmt.Errorf("duplicate test job name: %v", tj.Name))
+		}
+		names[tj.Name] = struct{}{}
+	}
+	releases := map[string]struct{}{GetName(rc.Application.Repo): struct{}{}}
+	for _, d := range rc.Dependencies.All() {
+		releases[d.Name] = struct{}{}
+	}
+	for _, ht := range rc.Tests.HelmTests {
+		if _, ok := releases[ht]; !ok {
+			return nitroerrors.User(fmt.Errorf("helm test references unknown application or dependency: %v", ht))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,32 @@
+package namegen
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"strings"
+)
+
+// maxRefNamePrefixLength bounds the readable part of a ref name so the environment name stays well within DNS label limits
+const maxRefNamePrefixLength = 30
+
+// RefName returns a deterministic name of the form {repo name}-{ref}-{hash} for an environment that is not associated with a PR.
+// The hash of the full repo and ref disambiguates refs that are identical after removing non-DNS-compliant characters or truncation.
+func RefName(repo, ref string) string {
+	rs := strings.Split(repo, "/")
+	mf := func(r rune) rune {
+		switch {
+		case r == ' ' || r == '_' || r == '/' || r == '.':
+			return '-'
+		case strings.ContainsRune(legalDNSChars, r):
+			return r
+		default:
+			return rune(-1)
+		}
+	}
+	prefix := strings.ToLower(strings.Map(mf, rs[len(rs)-1]+"-"+ref))
+	if len(prefix) > maxRefNamePrefixLength {
+		prefix = prefix[:maxRefNamePrefixLength]
+	}
+	prefix = strings.Trim(prefix, "-")
+	return fmt.Sprintf("%v-%x", prefix, sha1.Sum([]byte(repo+"@"+ref)))[:len(prefix)+7]
+}
@@ -0,0 +1,23 @@
+package namegen
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRefName(t *testing.T) {
+	name := RefName("dollarshaveclub/foo-bar", "release/v1.2.0")
+	if !strings.HasPrefix(name, "foo-bar-release-v1-2-0-") || len(name) != len("foo-bar-release-v1-2-0-")+6 {
+		t.Fatalf("bad name: %v", name)
+	}
+	if RefName("dollarshaveclub/foo-bar", "release/v1.2.0") != name {
+		t.Fatalf("name should be deterministic")
+	}
+	if RefName("dollarshaveclub/foo-bar", "release-v1-2-0") == name {
+		t.Fatalf("refs that are identical after filtering should have different names")
+	}
+	long := RefName("dollarshaveclub/foo-bar", "0123456789abcdef0123456789abcdef01234567")
+	if len(long) != maxRefNamePrefixLength+7 {
+		t.Fatalf("bad length for long ref: %v (%v)", long, len(long))
+	}
+}
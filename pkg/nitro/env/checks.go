@@ -0,0 +1,274 @@
+package env
+
+import (
+	"context"
+	stdliberrors "errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/dollarshaveclub/acyl/pkg/eventlogger"
+	"github.com/dollarshaveclub/acyl/pkg/ghapp"
+	"github.com/dollarshaveclub/acyl/pkg/ghclient"
+	"github.com/dollarshaveclub/acyl/pkg/models"
+	metahelmlib "github.com/dollarshaveclub/metahelm/pkg/metahelm"
+)
+
+// CheckRunName is the name of the GitHub check run published for each environment operation
+const CheckRunName = "Acyl"
+
+const (
+	// maxCheckRunTextLen is the maximum length of check run output text allowed by GitHub
+	maxCheckRunTextLen = 65535
+	// maxCheckRunLogLines is the number of trailing pod log lines included in the failure report for each container
+	maxCheckRunLogLines = 20
+)
+
+// setGithubCheckRun creates or updates the check run for the current operation (identified by the eventlog ID).
+// opErr is the error returned by the operation, if any, and is used to build the failure annotations and report.
+func (m *Manager) setGithubCheckRun(ctx context.Context, rd *models.RepoRevisionData, env *newEnv, ncs models.CommitStatus, opErr error) {
+	if m.CR == nil {
+		return
+	}
+	var err error
+	defer func() {
+		if err != nil {
+			m.log(ctx, "error setting github check run: %v", err)
+		}
+	}()
+	eid := eventlogger.GetLogger(ctx).ID
+	es, err := m.DL.GetEventStatus(eid)
+	if err != nil {
+		err = fmt.Errorf("error getting event status: %w", err)
+		return
+	}
+	if es == nil {
+		es = &models.EventStatusSummary{}
+	}
+	if env != nil && env.env != nil && es.Config.EnvName == "" {
+		es.Config.EnvName = env.env.Name
+	}
+	var ce metahelmlib.ChartError
+	if opErr != nil && stdliberrors.As(opErr, &ce) {
+		es.Config.FailedResources = ce
+	}
+	var turl, rurl string
+	if m.UIBaseURL != "" {
+		turl = fmt.Sprintf("%v/ui/event/status?id=%v", m.UIBaseURL, eid.String())
+		rurl = fmt.Sprintf("%v/ui/event/status/failure_report?id=%v", m.UIBaseURL, eid.String())
+	}
+	cr := checkRunFromStatus(es, ncs, opErr, rurl)
+	cr.Name = CheckRunName
+	cr.HeadSHA = rd.SourceSHA
+	cr.ExternalID = eid.String()
+	cr.DetailsURL = turl
+	ctx2 := eventlogger.NewEventLoggerContext(context.Background(), eventlogger.GetLogger(ctx))
+	ctx2 = ghapp.CloneGitHubClientContext(ctx2, ctx)
+	err = m.CR.SetCheckRun(ctx2, rd.Repo, cr)
+}
+
+// checkRunFromStatus builds the check run status and output from an event status summary.
+// failureReportURL is optional and linked from the failure report if present.
+func checkRunFromStatus(es *models.EventStatusSummary, ncs models.CommitStatus, opErr error, failureReportURL string) *ghclient.CheckRun {
+	cr := &ghclient.CheckRun{}
+	verb := "operation"
+	if es.Config.Type != models.UnknownEventStatusType {
+		verb = strings.ToLower(strings.TrimSuffix(es.Config.Type.String(), "Event"))
+	}
+	switch ncs {
+	case models.CommitStatusPending:
+		cr.Status = "in_progress"
+		cr.Title = fmt.Sprintf("Environment %v in progress", verb)
+	case models.CommitStatusSuccess:
+		cr.Status = "completed"
+		cr.Conclusion = "success"
+		cr.Title = fmt.Sprintf("Environment %v succeeded", verb)
+	default:
+		cr.Status = "completed"
+		cr.Conclusion = "failure"
+		cr.Title = fmt.Sprintf("Environment %v failed", verb)
+	}
+	cr.Summary = checkRunSummary(es)
+	if cr.Conclusion == "failure" {
+		fhcs := es.FailedHealthChecks()
+		cr.Annotations = checkRunAnnotations(es.Config.FailedResources, fhcs, opErr)
+		cr.Text = checkRunFailureReport(es.Config.FailedResources, fhcs, opErr, failureReportURL)
+	}
+	return cr
+}
+
+func imageStatusString(img models.EventStatusTreeNodeImage) string {
+	switch {
+	case img.Name == "":
+		return "-"
+	case img.Error:
+		return "❌ failed"
+	case img.Cached:
+		return "♻️ cached"
+	case !img.Completed.IsZero():
+		return fmt.Sprintf("✅ built (%v)", img.Completed.Sub(img.Started).Round(time.Second))
+	case !img.Started.IsZero():
+		return "⏳ building"
+	default:
+		return "⏸ waiting"
+	}
+}
+
+func chartStatusString(chart models.EventStatusTreeNodeChart) string {
+	switch chart.Status {
+	case models.WaitingChartStatus:
+		return "⏸ waiting"
+	case models.InstallingChartStatus:
+		return "⏳ installing"
+	case models.UpgradingChartStatus:
+		return "⏳ upgrading"
+	case models.DoneChartStatus:
+		if !chart.Completed.IsZero() && !chart.Started.IsZero() {
+			return fmt.Sprintf("✅ done (%v)", chart.Completed.Sub(chart.Started).Round(time.Second))
+		}
+		return "✅ done"
+	case models.FailedChartStatus:
+		return "❌ failed"
+	default:
+		return "unknown"
+	}
+}
+
+// checkRunSummary renders the markdown summary with environment details and per-image and per-chart rows
+func checkRunSummary(es *models.EventStatusSummary) string {
+	b := &strings.Builder{}
+	fmt.Fprintf(b, "**Environment:** `%v`\n", es.Config.EnvName)
+	if es.Config.K8sNamespace != "" {
+		fmt.Fprintf(b, "**Namespace:** `%v`\n", es.Config.K8sNamespace)
+	}
+	fmt.Fprintf(b, "**Repo:** %v (%v @ %v)\n", es.Config.TriggeringRepo, es.Config.Branch, es.Config.Revision)
+	if es.Config.ProcessingTime.Duration != 0 {
+		fmt.Fprintf(b, "**Config processing time:** %v\n", es.Config.ProcessingTime.Round(time.Millisecond))
+	}
+	if len(es.Tree) == 0 {
+		return b.String()
+	}
+	names := make([]string, 0, len(es.Tree))
+	for k := range es.Tree {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	b.WriteString("\n| Name | Image | Image Build | Chart |\n|---|---|---|---|\n")
+	for _, n := range names {
+		node := es.Tree[n]
+		img := node.Image.Name
+		if img == "" {
+			img = "-"
+		} else {
+			img = "`" + img + "`"
+		}
+		fmt.Fprintf(b, "| %v | %v | %v | %v |\n", n, img, imageStatusString(node.Image), chartStatusString(node.Chart))
+	}
+	return b.String()
+}
+
+type failedResource struct {
+	kind, name string
+	pods       []metahelmlib.FailedPod
+}
+
+func failedResources(ce metahelmlib.ChartError) []failedResource {
+	out := []failedResource{}
+	add := func(kind string, m map[string][]metahelmlib.FailedPod) {
+		names := make([]string, 0, len(m))
+		for k := range m {
+			names = append(names, k)
+		}
+		sort.Strings(names)
+		for _, n := range names {
+			out = append(out, failedResource{kind: kind, name: n, pods: m[n]})
+		}
+	}
+	add("Deployment", ce.FailedDeployments)
+	add("Job", ce.FailedJobs)
+	add("DaemonSet", ce.FailedDaemonSets)
+	return out
+}
+
+// checkRunAnnotations returns failure annotations for each failed resource and health check (or the operation error if there are none).
+// Failures are not associated with any particular source line so the annotations are attached to the top of acyl.yml.
+func checkRunAnnotations(ce metahelmlib.ChartError, fhcs []models.FailedHealthCheck, opErr error) []ghclient.CheckRunAnnotation {
+	out := []ghclient.CheckRunAnnotation{}
+	for _, fr := range failedResources(ce) {
+		msgs := make([]string, 0, len(fr.pods))
+		for _, p := range fr.pods {
+			msgs = append(msgs, fmt.Sprintf("pod %v: %v %v %v", p.Name, p.Phase, p.Reason, p.Message))
+		}
+		out = append(out, ghclient.CheckRunAnnotation{
+			Path:      "acyl.yml",
+			StartLine: 1,
+			EndLine:   1,
+			Level:     "failure",
+			Title:     fmt.Sprintf("%v %v failed", fr.kind, fr.name),
+			Message:   strings.Join(msgs, "\n"),
+		})
+	}
+	for _, hc := range fhcs {
+		out = append(out, ghclient.CheckRunAnnotation{
+			Path:      "acyl.yml",
+			StartLine: 1,
+			EndLine:   1,
+			Level:     "failure",
+			Title:     fmt.Sprintf("%v health check %v failed", hc.Node, hc.Name),
+			Message:   hc.Message,
+		})
+	}
+	if len(out) == 0 && opErr != nil {
+		out = append(out, ghclient.CheckRunAnnotation{
+			Path:      "acyl.yml",
+			StartLine: 1,
+			EndLine:   1,
+			Level:     "failure",
+			Title:     "Environment error",
+			Message:   opErr.Error(),
+		})
+	}
+	return out
+}
+
+// checkRunFailureReport renders the failure report (error, failed health checks, failed pods and trailing logs) as markdown
+func checkRunFailureReport(ce metahelmlib.ChartError, fhcs []models.FailedHealthCheck, opErr error, failureReportURL string) string {
+	b := &strings.Builder{}
+	if failureReportURL != "" {
+		fmt.Fprintf(b, "[Full failure report](%v)\n\n", failureReportURL)
+	}
+	if opErr != nil {
+		fmt.Fprintf(b, "### Error\n\n```\n%v\n```\n", opErr)
+	}
+	if len(fhcs) > 0 {
+		b.WriteString("\n### Failed health checks\n\n| Name | Check | Type | Error |\n|---|---|---|---|\n")
+		for _, hc := range fhcs {
+			fmt.Fprintf(b, "| %v | %v | %v | %v |\n", hc.Node, hc.Name, hc.Type, strings.ReplaceAll(strings.ReplaceAll(hc.Message, "\n", " "), "|", "\\|"))
+		}
+	}
+	for _, fr := range failedResources(ce) {
+		fmt.Fprintf(b, "\n### %v: %v\n", fr.kind, fr.name)
+		for _, p := range fr.pods {
+			fmt.Fprintf(b, "\n#### Pod %v\n\n**Phase:** %v  \n**Reason:** %v  \n**Message:** %v\n", p.Name, p.Phase, p.Reason, p.Message)
+			containers := make([]string, 0, len(p.Logs))
+			for k := range p.Logs {
+				containers = append(containers, k)
+			}
+			sort.Strings(containers)
+			for _, c := range containers {
+				lines := strings.Split(strings.TrimRight(string(p.Logs[c]), "\n"), "\n")
+				if len(lines) > maxCheckRunLogLines {
+					lines = lines[len(lines)-maxCheckRunLogLines:]
+				}
+				fmt.Fprintf(b, "\n<details><summary>%v logs</summary>\n\n```\n%v\n```\n</details>\n", c, strings.Join(lines, "\n"))
+			}
+		}
+	}
+	out := b.String()
+	// truncate by rune so that a multibyte character isn't split, which would make the text invalid UTF-8
+	if rs := []rune(out); len(rs) > maxCheckRunTextLen {
+		out = string(rs[:maxCheckRunTextLen-len("\n...")]) + "\n..."
+	}
+	return out
+}
@@ -0,0 +1,247 @@
+package env
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/dollarshaveclub/acyl/pkg/eventlogger"
+	"github.com/dollarshaveclub/acyl/pkg/ghclient"
+	"github.com/dollarshaveclub/acyl/pkg/models"
+	"github.com/dollarshaveclub/acyl/pkg/persistence"
+	metahelmlib "github.com/dollarshaveclub/metahelm/pkg/metahelm"
+	"github.com/google/uuid"
+)
+
+func TestCheckRunFromStatus(t *testing.T) {
+	now := time.Now().UTC()
+	es := &models.EventStatusSummary{
+		Config: models.EventStatusSummaryConfig{
+			Type:           models.CreateEvent,
+			EnvName:        "foo-bar",
+			K8sNamespace:   "nitro-1234-foo-bar",
+			TriggeringRepo: "acme/something",
+			Branch:         "feature-foo",
+			Revision:       "asdf",
+		},
+		Tree: map[string]models.EventStatusTreeNode{
+			"something": models.EventStatusTreeNode{
+				Image: models.EventStatusTreeNodeImage{Name: "acme/something", Started: now.Add(-1 * time.Minute), Completed: now},
+				Chart: models.EventStatusTreeNodeChart{Status: models.DoneChartStatus},
+			},
+			"dependency": models.EventStatusTreeNode{
+				Parent: "something",
+				Chart:  models.EventStatusTreeNodeChart{Status: models.FailedChartStatus},
+			},
+		},
+	}
+	ce := metahelmlib.ChartError{
+		HelmError: fmt.Errorf("timed out"),
+		FailedDeployments: map[string][]metahelmlib.FailedPod{
+			"dependency": []metahelmlib.FailedPod{
+				metahelmlib.FailedPod{
+					Name:   "dependency-1234",
+					Phase:  "Running",
+					Reason: "CrashLoopBackOff",
+					Logs:   map[string][]byte{"app": []byte(strings.Repeat("log line\n", 100) + "last line\n")},
+				},
+			},
+		},
+	}
+	es.Config.FailedResources = ce
+	tests := []struct {
+		name            string
+		ncs             models.CommitStatus
+		opErr           error
+		wantStatus      string
+		wantConclusion  string
+		wantTitle       string
+		wantAnnotations []string
+		wantText        []string
+	}{
+		{
+			name:       "pending",
+			ncs:        models.CommitStatusPending,
+			wantStatus: "in_progress",
+			wantTitle:  "Environment create in progress",
+		},
+		{
+			name:           "success",
+			ncs:            models.CommitStatusSuccess,
+			wantStatus:     "completed",
+			wantConclusion: "success",
+			wantTitle:      "Environment create succeeded",
+		},
+		{
+			name:            "failure",
+			ncs:             models.CommitStatusFailure,
+			opErr:           ce,
+			wantStatus:      "completed",
+			wantConclusion:  "failure",
+			wantTitle:       "Environment create failed",
+			wantAnnotations: []string{"Deployment dependency failed"},
+			wantText:        []string{"[Full failure report](https://acyl.example.com/report)", "### Deployment: dependency", "#### Pod dependency-1234", "last line"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cr := checkRunFromStatus(es, tt.ncs, tt.opErr, "https://acyl.example.com/report")
+			if cr.Status != tt.wantStatus {
+				t.Errorf("bad status: %v (wanted %v)", cr.Status, tt.wantStatus)
+			}
+			if cr.Conclusion != tt.wantConclusion {
+				t.Errorf("bad conclusion: %v (wanted %v)", cr.Conclusion, tt.wantConclusion)
+			}
+			if cr.Title != tt.wantTitle {
+				t.Errorf("bad title: %v (wanted %v)", cr.Title, tt.wantTitle)
+			}
+			for _, s := range []string{"`foo-bar`", "`nitro-1234-foo-bar`", "| dependency | - | - | ❌ failed |", "| something | `acme/something` | ✅ built (1m0s) | ✅ done |"} {
+				if !strings.Contains(cr.Summary, s) {
+					t.Errorf("summary missing %q: %v", s, cr.Summary)
+				}
+			}
+			if strings.Index(cr.Summary, "| dependency |") > strings.Index(cr.Summary, "| something |") {
+				t.Errorf("summary rows should be sorted by name: %v", cr.Summary)
+			}
+			if len(cr.Annotations) != len(tt.wantAnnotations) {
+				t.Fatalf("bad annotation count: %v (wanted %v)", len(cr.Annotations), len(tt.wantAnnotations))
+			}
+			for i, a := range cr.Annotations {
+				if a.Title != tt.wantAnnotations[i] {
+					t.Errorf("bad annotation title: %v (wanted %v)", a.Title, tt.wantAnnotations[i])
+				}
+				if a.Level != "failure" || a.Path != "acyl.yml" {
+					t.Errorf("bad annotation: %+v", a)
+				}
+			}
+			for _, s := range tt.wantText {
+				if !strings.Contains(cr.Text, s) {
+					t.Errorf("text missing %q: %v", s, cr.Text)
+				}
+			}
+			if n := strings.Count(cr.Text, "log line"); n != maxCheckRunLogLines-1 && tt.wantText != nil {
+				t.Errorf("expected %v trailing log lines, got %v", maxCheckRunLogLines-1, n)
+			}
+		})
+	}
+}
+
+func TestCheckRunFailureReportTruncated(t *testing.T) {
+	out := checkRunFailureReport(metahelmlib.ChartError{}, nil, fmt.Errorf("%v", strings.Repeat("x", maxCheckRunTextLen)), "")
+	if len(out) != maxCheckRunTextLen {
+		t.Errorf("bad length: %v", len(out))
+	}
+	if !strings.HasSuffix(out, "\n...") {
+		t.Errorf("expected truncation suffix")
+	}
+}
+
+func TestCheckRunFailureReportTruncatedMultibyte(t *testing.T) {
+	// the cut point falls within a multibyte character if the report is truncated by byte
+	out := checkRunFailureReport(metahelmlib.ChartError{}, nil, fmt.Errorf("%v", strings.Repeat("€", maxCheckRunTextLen)), "")
+	if !utf8.ValidString(out) {
+		t.Fatalf("truncated report should be valid UTF-8")
+	}
+	if n := utf8.RuneCountInString(out); n != maxCheckRunTextLen {
+		t.Errorf("bad length: %v", n)
+	}
+	if !strings.HasSuffix(out, "€\n...") {
+		t.Errorf("expected truncation suffix after a whole character")
+	}
+}
+
+func TestSetGithubCheckRun(t *testing.T) {
+	dl := persistence.NewFakeDataLayer()
+	elogger := &eventlogger.Logger{
+		ID:   uuid.Must(uuid.NewRandom()),
+		DL:   dl,
+		Sink: os.Stdout,
+	}
+	if err := elogger.Init([]byte{}, "acme/something", 1); err != nil {
+		t.Fatalf("error initializing event logger: %v", err)
+	}
+	ctx := eventlogger.NewEventLoggerContext(context.Background(), elogger)
+	var out *ghclient.CheckRun
+	m := &Manager{
+		DL: dl,
+		CR: &ghclient.FakeRepoClient{
+			SetCheckRunFunc: func(ctx context.Context, repo string, cr *ghclient.CheckRun) error {
+				if repo != "acme/something" {
+					return fmt.Errorf("bad repo: %v", repo)
+				}
+				out = cr
+				return nil
+			},
+		},
+		UIBaseURL: "https://foobar.com",
+	}
+	env := &newEnv{env: &models.QAEnvironment{Name: "foo-bar"}}
+	rd := &models.RepoRevisionData{Repo: "acme/something", PullRequest: 1, SourceSHA: "asdf"}
+	m.setGithubCheckRun(ctx, rd, env, models.CommitStatusFailure, fmt.Errorf("something bad happened"))
+	if out == nil {
+		t.Fatalf("check run should have been set")
+	}
+	if out.Name != CheckRunName || out.HeadSHA != "asdf" || out.ExternalID != elogger.ID.String() {
+		t.Errorf("bad check run identity: %+v", out)
+	}
+	if out.DetailsURL != fmt.Sprintf("https://foobar.com/ui/event/status?id=%v", elogger.ID) {
+		t.Errorf("bad details url: %v", out.DetailsURL)
+	}
+	if !strings.Contains(out.Summary, "`foo-bar`") {
+		t.Errorf("summary missing env name: %v", out.Summary)
+	}
+	if len(out.Annotations) != 1 || out.Annotations[0].Message != "something bad happened" {
+		t.Errorf("bad annotations: %+v", out.Annotations)
+	}
+
+	// no client configured
+	out = nil
+	m.CR = nil
+	m.setGithubCheckRun(ctx, rd, env, models.CommitStatusSuccess, nil)
+	if out != nil {
+		t.Errorf("check run should not have been set")
+	}
+}
+
+func TestImageStatusStringCached(t *testing.T) {
+	now := time.Now().UTC()
+	img := models.EventStatusTreeNodeImage{Name: "acme/something", Started: now, Completed: now, Cached: true}
+	if s := imageStatusString(img); s != "♻️ cached" {
+		t.Errorf("bad status: %v", s)
+	}
+}
+
+func TestCheckRunFromStatusHealthChecks(t *testing.T) {
+	now := time.Now().UTC()
+	es := &models.EventStatusSummary{
+		Config: models.EventStatusSummaryConfig{
+			Type:    models.CreateEvent,
+			EnvName: "foo-bar",
+		},
+		Tree: map[string]models.EventStatusTreeNode{
+			"something": models.EventStatusTreeNode{
+				Chart: models.EventStatusTreeNodeChart{Status: models.DoneChartStatus},
+				HealthChecks: map[string]models.EventStatusTreeNodeHealthCheck{
+					"web": models.EventStatusTreeNodeHealthCheck{Type: "http", Started: now, Completed: now},
+					"db":  models.EventStatusTreeNodeHealthCheck{Type: "tcp", Started: now, Completed: now, Error: true, Message: "failed after 6 attempts: connection | refused"},
+				},
+			},
+		},
+	}
+	cr := checkRunFromStatus(es, models.CommitStatusFailure, fmt.Errorf("health checks failed"), "")
+	if len(cr.Annotations) != 1 || cr.Annotations[0].Title != "something health check db failed" {
+		t.Fatalf("bad annotations: %+v", cr.Annotations)
+	}
+	for _, s := range []string{"### Failed health checks", "| something | db | tcp | failed after 6 attempts: connection \\| refused |"} {
+		if !strings.Contains(cr.Text, s) {
+			t.Errorf("text missing %q: %v", s, cr.Text)
+		}
+	}
+	if strings.Contains(cr.Text, "| web |") {
+		t.Errorf("text should not include passing health checks: %v", cr.Text)
+	}
+}
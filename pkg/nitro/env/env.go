@@ -5,11 +5,14 @@ import (
 	stdliberrors "errors"
 	"fmt"
 	"sort"
+	"sync"
 	"time"
 
 	"github.com/dollarshaveclub/acyl/pkg/ghapp"
 
+	"github.com/dollarshaveclub/acyl/pkg/config"
 	"github.com/dollarshaveclub/acyl/pkg/eventlogger"
+	"github.com/dollarshaveclub/acyl/pkg/eviction"
 	"github.com/dollarshaveclub/acyl/pkg/ghclient"
 	"github.com/dollarshaveclub/acyl/pkg/locker"
 	"github.com/dollarshaveclub/acyl/pkg/models"
@@ -22,6 +25,7 @@ import (
 	"github.com/dollarshaveclub/acyl/pkg/nitro/notifier"
 	"github.com/dollarshaveclub/acyl/pkg/persistence"
 	metahelmlib "github.com/dollarshaveclub/metahelm/pkg/metahelm"
+	"github.com/ghodss/yaml"
 	"github.com/google/uuid"
 	"github.com/imdario/mergo"
 	"github.com/pkg/errors"
@@ -45,6 +49,7 @@ type Manager struct {
 	DefaultNotifications models.Notifications
 	DL                   persistence.DataLayer
 	RC                   ghclient.RepoClient
+	CR                   ghclient.CheckRunClient // optional, check runs are not published if nil
 	MC                   metrics.Collector
 	NG                   namegen.NameGenerator
 	FS                   billy.Filesystem
@@ -52,8 +57,15 @@ type Manager struct {
 	CI                   metahelm.Installer
 	PLF                  locker.PreemptiveLockerFactory
 	GlobalLimit          uint
+	Quotas               config.EnvironmentQuotas
+	EvictionPolicy       eviction.Policy // the activity policy is used if nil
+	Lifetimes            config.EnvironmentLifetimes
 	OperationTimeout     time.Duration
 	UIBaseURL            string
+
+	testswg  sync.WaitGroup // tests running in the background after create/update
+	testsmtx sync.Mutex
+	testruns map[string]*testRun // background tests by environment, see cancelTests
 }
 
 var DefaultOperationTimeout = 30 * time.Minute
@@ -111,6 +123,7 @@ func (m *Manager) pushNotification(ctx context.Context, env *newEnv, event notif
 			EnvName:       env.env.Name,
 			Repo:          env.env.Repo,
 			SourceBranch:  env.env.SourceBranch,
+			SourceRef:     env.env.SourceRef,
 			SourceSHA:     env.env.SourceSHA,
 			BaseBranch:    env.env.BaseBranch,
 			BaseSHA:       env.env.BaseSHA,
@@ -121,8 +134,15 @@ func (m *Manager) pushNotification(ctx context.Context, env *newEnv, event notif
 			ErrorMessage:  errmsg,
 			Event:         event.String(),
 		},
-		Event:    event,
-		Template: env.rc.Notifications.Templates[event.Key()],
+		Event:     event,
+		Template:  env.rc.Notifications.Templates[event.Key()],
+		Untrusted: env.env.IsFork,
+	}
+	if ev, ok := eviction.GetEviction(ctx); ok && event == notifier.DestroyEnvironment {
+		n.Data.EvictionPolicy, n.Data.EvictionReason = ev.Policy, ev.Reason
+	}
+	if env.env.Expires != nil {
+		n.Data.Expires = env.env.Expires.UTC().Format(time.RFC3339)
 	}
 	if m.NF == nil {
 		m.log(ctx, "notifier factory is uninitialized")
@@ -163,6 +183,7 @@ func (m *Manager) setGithubCommitStatus(ctx context.Context, rd *models.RepoRevi
 		EnvName:      env.env.Name,
 		Repo:         env.env.Repo,
 		SourceBranch: env.env.SourceBranch,
+		SourceRef:    env.env.SourceRef,
 		SourceSHA:    env.env.SourceSHA,
 		BaseBranch:   env.env.BaseBranch,
 		BaseSHA:      env.env.BaseSHA,
@@ -201,18 +222,36 @@ func (m *Manager) setGithubCommitStatus(ctx context.Context, rd *models.RepoRevi
 	return cs, nil
 }
 
-// lockingOperation sets up the lock and if successful executes f, releasing the lock afterward
-func (m *Manager) lockingOperation(ctx context.Context, repo string, pr uint, f func(ctx context.Context) error) (err error) {
+// lockKey returns the repo and PR identifying the operation lock for rd.
+// Environments without a PR (tracked branches and ad-hoc refs) are locked per ref, so that operations
+// on different refs in the same repo do not preempt each other.
+func lockKey(rd models.RepoRevisionData) (string, uint) {
+	if rd.PullRequest != 0 {
+		return rd.Repo, rd.PullRequest
+	}
+	ref := rd.SourceBranch
+	if ref == "" {
+		ref = rd.SourceRef
+	}
+	return rd.Repo + "@" + ref, 0
+}
+
+// lockingOperation sets up the lock for rd and if successful executes f, releasing the lock afterward
+func (m *Manager) lockingOperation(ctx context.Context, rd models.RepoRevisionData, f func(ctx context.Context) error) (err error) {
 	ctx, cf := context.WithCancel(ctx)
 	defer cf()
+	repo := rd.Repo
+	lrepo, pr := lockKey(rd)
 	end := m.MC.Timing(mpfx+"lock_wait", "triggering_repo:"+repo)
-	lock := m.PLF(repo, pr, "event") // TODO: consider adding more detailed event information
+	lock := m.PLF(lrepo, pr, "event") // TODO: consider adding more detailed event information
 	preempt, err := lock.Lock(ctx)
 	if err != nil {
 		end("success:false")
 		return fmt.Errorf("error getting lock: %w", err)
 	}
 	end("success:true")
+	// any background tests for the environment are stale now
+	m.cancelTests(rd)
 	defer func() {
 		releaseCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 		lock.Release(releaseCtx)
@@ -224,7 +263,7 @@ func (m *Manager) lockingOperation(ctx context.Context, repo string, pr uint, f
 		select {
 		case np := <-preempt: // Lock got preempted, cancel action
 			m.MC.Increment(mpfx+"lock_preempt", "triggering_repo:"+repo)
-			m.log(ctx, "operation preempted: %v: %v, %v", repo, pr, np)
+			m.log(ctx, "operation preempted: %v: %v, %v", lrepo, pr, np)
 		case <-stop:
 		}
 		cf()
@@ -258,7 +297,7 @@ func (m *Manager) lockingOperation(ctx context.Context, repo string, pr uint, f
 			m.log(ctx, "error returned was a ChartError")
 		}
 		eventlogger.GetLogger(ctx).SetFailedStatus(ce)
-		m.log(ctx, "operation error (user: %v, sys: %v): %v: %v: %v", nitroerrors.IsUserError(err), nitroerrors.IsSystemError(err), repo, pr, err)
+		m.log(ctx, "operation error (user: %v, sys: %v): %v: %v: %v", nitroerrors.IsUserError(err), nitroerrors.IsSystemError(err), lrepo, pr, err)
 	}
 	endop(fmt.Sprintf("success:%v", err == nil), fmt.Sprintf("user_error:%v", nitroerrors.IsUserError(err)), fmt.Sprintf("system_error:%v", nitroerrors.IsSystemError(err)))
 	return err
@@ -268,7 +307,7 @@ func (m *Manager) lockingOperation(ctx context.Context, repo string, pr uint, f
 func (m *Manager) Create(ctx context.Context, rd models.RepoRevisionData) (string, error) {
 	var err error
 	var name string
-	err = m.lockingOperation(ctx, rd.Repo, rd.PullRequest, func(ctx context.Context) error {
+	err = m.lockingOperation(ctx, rd, func(ctx context.Context) error {
 		name, err = m.create(ctx, &rd)
 		return err
 	})
@@ -285,41 +324,30 @@ func (m *Manager) Create(ctx context.Context, rd models.RepoRevisionData) (strin
 }
 
 // enforceGlobalLimit checks existing environments against the configured global limit.
-// If necessary, kill oldest environments to bring the environment count into compliance with the limit.
-func (m *Manager) enforceGlobalLimit(ctx context.Context) error {
+// If necessary, evict environments chosen by the eviction policy to bring the environment count into compliance with the limit.
+// Environments sharing a lock with env (the environment being created) are never evicted.
+func (m *Manager) enforceGlobalLimit(ctx context.Context, env *models.QAEnvironment) error {
 	if m.GlobalLimit == 0 {
 		return nil
 	}
 	limit := int(m.GlobalLimit)
-	qae, err := m.DL.GetRunningQAEnvironments(ctx)
+	p := m.evictionPolicy()
+	qae, err := eviction.Candidates(ctx, m.DL, p)
 	if err != nil {
-		return fmt.Errorf("error getting running environments: %v", err)
+		return fmt.Errorf("error getting eviction candidates: %w", err)
 	}
-	extant := len(qae)
+	// only running environments count towards the limit, but the policy may prefer to evict others first
+	extant := eviction.CountRunning(qae)
 	if extant > limit {
 		kill := extant - limit
-		// GetRunningQAEnvironments() returns a sorted list in ascending order of creation timestamp
-		kenvs := qae[0:kill]
-		m.log(ctx, "enforcing global limit: extant: %v, limit: %v, destroying: %v", extant, limit, kill)
-		for _, e := range kenvs {
-			env := e
-			m.log(ctx, "destroying: %v (created %v)", env.Name, env.Created)
-			// we lock around each destroyed environment to preempt any ongoing operations
-			// create a new context as lockingOperation() always cancels the context when finished
-			ctx2, cf := context.WithCancel(ctx)
-			if err := m.Delete(ctx2, e.RepoRevisionDataFromQA(), models.EnvironmentLimitExceeded); err != nil {
-				m.log(ctx, "error destroying environment for exceeding limit: %v", err)
-			}
-			cf()
-			select {
-			case <-ctx.Done():
-				return errors.New("context was cancelled")
-			default:
-			}
+		victims, running := eviction.Select(p, evictable(qae, env), kill, time.Now().UTC())
+		m.log(ctx, "enforcing global limit with %v eviction policy: extant: %v, limit: %v, destroying: %v", p.Name(), extant, limit, len(victims))
+		if running < kill {
+			m.log(ctx, "not enough environments are eligible for eviction (the others are pinned), global limit will remain exceeded")
 		}
-		return nil
+		return m.evict(ctx, p, victims, models.EnvironmentLimitExceeded)
 	}
-	m.log(ctx, "global limit not exceeded: running: %v, limit: %v", extant, limit)
+	m.log(ctx, "global limit not exceeded: extant: %v, limit: %v", extant, limit)
 	return nil
 }
 
@@ -359,6 +387,7 @@ func (m *Manager) generateNewEnv(ctx context.Context, rd *models.RepoRevisionDat
 	if err != nil {
 		return nil, fmt.Errorf("error checking for existing environment record: %w", err)
 	}
+	envs = branchEnvs(rd, envs)
 	if len(envs) > 0 {
 		// environment record exists, reuse the latest one
 		sort.Slice(envs, func(i, j int) bool { return envs[i].Created.Before(envs[j].Created) })
@@ -381,9 +410,19 @@ func (m *Manager) generateNewEnv(ctx context.Context, rd *models.RepoRevisionDat
 		}
 	} else {
 		// no record exists, create a new one
-		name, err := m.NG.New()
-		if err != nil {
-			return nil, fmt.Errorf("error generating name: %w", err)
+		var name string
+		if rd.PullRequest == 0 {
+			// environments without a PR are named after the repo and ref so they can be recognized without a PR link
+			ref := rd.SourceRef
+			if ref == "" {
+				ref = rd.SourceBranch
+			}
+			name = namegen.RefName(rd.Repo, ref)
+		} else {
+			name, err = m.NG.New()
+			if err != nil {
+				return nil, fmt.Errorf("error generating name: %w", err)
+			}
 		}
 		m.log(ctx, "generating new environment record: %v", name)
 		env = &models.QAEnvironment{
@@ -398,6 +437,7 @@ func (m *Manager) generateNewEnv(ctx context.Context, rd *models.RepoRevisionDat
 			SourceBranch: rd.SourceBranch,
 			BaseBranch:   rd.BaseBranch,
 			SourceRef:    rd.SourceRef,
+			IsFork:       rd.IsFork,
 		}
 		if err = m.DL.CreateQAEnvironment(ctx, env); err != nil {
 			return nil, fmt.Errorf("error writing environment to db: %w", err)
@@ -443,7 +483,8 @@ func (m *Manager) processEnvConfig(ctx context.Context, env *models.QAEnvironmen
 	return ne, nil
 }
 
-func (m *Manager) fetchCharts(ctx context.Context, name string, rc *models.RepoConfig) (_ string, _ meta.ChartLocations, err error) {
+// fetchCharts fetches the environment charts into a new temporary directory. untrusted restricts the chart sources (see meta.CachingHelmChartFetcher).
+func (m *Manager) fetchCharts(ctx context.Context, name string, rc *models.RepoConfig, untrusted bool) (_ string, _ meta.ChartLocations, err error) {
 	span, ctx := tracer.StartSpanFromContext(ctx, "fetch_charts")
 	defer func() {
 		span.Finish(tracer.WithError(err))
@@ -453,7 +494,7 @@ func (m *Manager) fetchCharts(ctx context.Context, name string, rc *models.RepoC
 		return "", nil, fmt.Errorf("error generating temp dir: %w", err)
 	}
 	end := m.MC.Timing(mpfx+"fetch_helm_charts", "triggering_repo:"+rc.Application.Repo)
-	cloc, err := m.MG.FetchCharts(ctx, rc, td)
+	cloc, err := m.MG.FetchCharts(ctx, rc, td, untrusted)
 	if err != nil {
 		end("success:false")
 		return "", nil, fmt.Errorf("error fetching charts: %w", err)
@@ -504,14 +545,20 @@ func (m *Manager) create(ctx context.Context, rd *models.RepoRevisionData) (envn
 			errmsg := "error creating: " + err.Error()
 			m.pushNotification(ctx, newenv, notifier.Failure, errmsg)
 			m.setGithubCommitStatus(ctx, rd, newenv, models.CommitStatusFailure, errmsg)
+			m.setGithubCheckRun(ctx, rd, newenv, models.CommitStatusFailure, err)
 			eventlogger.GetLogger(ctx).SetCompletedStatus(models.FailedStatus)
 			m.MC.Increment(mpfx+"create_errors", "triggering_repo:"+rd.Repo)
 			return
 		}
-		// metahelm.Manager sets the success status on QAEnvironment
+		// health checks have passed, so the environment can be marked successful
+		if err := m.DL.SetQAEnvironmentStatus(tracer.ContextWithSpan(context.Background(), span), newenv.env.Name, models.Success); err != nil {
+			m.log(ctx, "error setting environment status to success: %v", err)
+		}
 		m.pushNotification(ctx, newenv, notifier.Success, "")
 		m.setGithubCommitStatus(ctx, rd, newenv, models.CommitStatusSuccess, "")
+		m.setGithubCheckRun(ctx, rd, newenv, models.CommitStatusSuccess, nil)
 		eventlogger.GetLogger(ctx).SetCompletedStatus(models.DoneStatus)
+		m.runTestsAsync(ctx, rd, newenv)
 	}()
 	start := time.Now().UTC()
 	newenv, err = m.processEnvConfig(ctx, env, rd)
@@ -520,6 +567,9 @@ func (m *Manager) create(ctx context.Context, rd *models.RepoRevisionData) (envn
 	}
 	elapsed := time.Since(start)
 	eventlogger.GetLogger(ctx).SetInitialStatus(newenv.rc, elapsed)
+	if err = m.setExpiry(ctx, newenv.env, newenv.rc); err != nil {
+		return "", fmt.Errorf("error setting environment expiry: %w", err)
+	}
 	select {
 	case <-ctx.Done():
 		return "", nitroerrors.User(fmt.Errorf("context was cancelled in create"))
@@ -528,7 +578,8 @@ func (m *Manager) create(ctx context.Context, rd *models.RepoRevisionData) (envn
 	}
 	m.pushNotification(ctx, newenv, notifier.CreateEnvironment, "")
 	m.setGithubCommitStatus(ctx, rd, newenv, models.CommitStatusPending, "")
-	td, cloc, err := m.fetchCharts(ctx, env.Name, newenv.rc)
+	m.setGithubCheckRun(ctx, rd, newenv, models.CommitStatusPending, nil)
+	td, cloc, err := m.fetchCharts(ctx, env.Name, newenv.rc, newenv.env.IsFork)
 	if err != nil {
 		return "", fmt.Errorf("error fetching charts: %w", err)
 	}
@@ -541,7 +592,11 @@ func (m *Manager) create(ctx context.Context, rd *models.RepoRevisionData) (envn
 		}
 	}
 
-	if err = m.enforceGlobalLimit(ctx); err != nil {
+	if err = m.enforceQuotas(ctx, newenv.env, m.Quotas.Evict); err != nil {
+		return "", fmt.Errorf("error enforcing environment quotas: %w", err)
+	}
+
+	if err = m.enforceGlobalLimit(ctx, newenv.env); err != nil {
 		return "", fmt.Errorf("error enforcing global limit: %w", err)
 	}
 
@@ -551,13 +606,29 @@ func (m *Manager) create(ctx context.Context, rd *models.RepoRevisionData) (envn
 		return "", fmt.Errorf("error installing charts: %w", err)
 	}
 	chartSpan.Finish()
+	if err = m.runHealthChecks(ctx, &metahelm.EnvInfo{Env: newenv.env, RC: newenv.rc}); err != nil {
+		return "", err
+	}
 	return newenv.env.Name, nil
 }
 
+// runHealthChecks runs the post-install health checks declared in the environment config
+func (m *Manager) runHealthChecks(ctx context.Context, envinfo *metahelm.EnvInfo) (err error) {
+	span, ctx := tracer.StartSpanFromContext(ctx, "health_checks")
+	defer func() {
+		span.Finish(tracer.WithError(err))
+	}()
+	if err := m.CI.RunHealthChecks(ctx, envinfo); err != nil {
+		m.MC.Increment(mpfx+"health_check_failures", "triggering_repo:"+envinfo.RC.Application.Repo)
+		return fmt.Errorf("error running health checks: %w", err)
+	}
+	return nil
+}
+
 // Delete destroys an environment in k8s and marks it as such in the DB
 func (m *Manager) Delete(ctx context.Context, rd *models.RepoRevisionData, reason models.QADestroyReason) error {
 	var err error
-	err = m.lockingOperation(ctx, rd.Repo, rd.PullRequest, func(ctx context.Context) error {
+	err = m.lockingOperation(ctx, *rd, func(ctx context.Context) error {
 		return m.delete(ctx, rd, reason)
 	})
 	if nitroerrors.IsCancelledError(err) {
@@ -574,12 +645,29 @@ func (m *Manager) Delete(ctx context.Context, rd *models.RepoRevisionData, reaso
 
 var extantEnvsErr = errors.New("did not find exactly one extant environment")
 
+// branchEnvs filters envs to those belonging to the same tracked branch as rd.
+// Tracked branch environments have no PR number, so the branch is what distinguishes them within a repo.
+// If rd is associated with a PR, envs is returned unmodified.
+func branchEnvs(rd *models.RepoRevisionData, envs []models.QAEnvironment) []models.QAEnvironment {
+	if rd.PullRequest != 0 {
+		return envs
+	}
+	out := make([]models.QAEnvironment, 0, len(envs))
+	for _, e := range envs {
+		if e.SourceBranch == rd.SourceBranch {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
 // getenv returns the extant environment for rd or error
 func (m *Manager) getenv(ctx context.Context, rd *models.RepoRevisionData) (*models.QAEnvironment, error) {
 	envs, err := m.DL.GetExtantQAEnvironments(ctx, rd.Repo, rd.PullRequest)
 	if err != nil {
 		return nil, fmt.Errorf("error getting extant environments: %w", err)
 	}
+	envs = branchEnvs(rd, envs)
 	if len(envs) != 1 {
 		m.log(ctx, "expected exactly one extant environment but there are %v", len(envs))
 		return nil, extantEnvsErr
@@ -605,6 +693,7 @@ func (m *Manager) delete(ctx context.Context, rd *models.RepoRevisionData, reaso
 			if err != nil {
 				return fmt.Errorf("error getting environments associated with the repo (%v) and PR (%v): %w", rd.Repo, rd.PullRequest, err)
 			}
+			envs = branchEnvs(rd, envs)
 			if len(envs) > 0 {
 				for _, e := range envs {
 					m.log(ctx, "setting %v to status destroyed", e.Name)
@@ -695,7 +784,7 @@ func (m *Manager) deleteNamespace(ctx context.Context, k8senv *models.Kubernetes
 func (m *Manager) Update(ctx context.Context, rd models.RepoRevisionData) (string, error) {
 	var err error
 	var name string
-	err = m.lockingOperation(ctx, rd.Repo, rd.PullRequest, func(ctx context.Context) error {
+	err = m.lockingOperation(ctx, rd, func(ctx context.Context) error {
 		name, err = m.update(ctx, &rd)
 		return err
 	})
@@ -744,13 +833,19 @@ func (m *Manager) update(ctx context.Context, rd *models.RepoRevisionData) (envn
 			}
 			m.pushNotification(ctx, ne, notifier.Failure, err.Error())
 			m.setGithubCommitStatus(ctx, rd, ne, models.CommitStatusFailure, err.Error())
+			m.setGithubCheckRun(ctx, rd, ne, models.CommitStatusFailure, err)
 			eventlogger.GetLogger(ctx).SetCompletedStatus(models.FailedStatus)
 			return
 		}
-		// metahelm.Manager sets the success status on QAEnvironment
+		// health checks have passed, so the environment can be marked successful
+		if err := m.DL.SetQAEnvironmentStatus(tracer.ContextWithSpan(context.Background(), span), ne.env.Name, models.Success); err != nil {
+			m.log(ctx, "error setting environment status to success: %v", err)
+		}
 		m.pushNotification(ctx, ne, notifier.Success, "")
 		m.setGithubCommitStatus(ctx, rd, ne, models.CommitStatusSuccess, "")
+		m.setGithubCheckRun(ctx, rd, ne, models.CommitStatusSuccess, nil)
 		eventlogger.GetLogger(ctx).SetCompletedStatus(models.DoneStatus)
+		m.runTestsAsync(ctx, rd, ne)
 	}()
 	started := time.Now().UTC()
 	ne, err = m.processEnvConfig(ctx, env, rd)
@@ -759,6 +854,10 @@ func (m *Manager) update(ctx context.Context, rd *models.RepoRevisionData) (envn
 	}
 	elapsed := time.Since(started)
 	eventlogger.GetLogger(ctx).SetInitialStatus(ne.rc, elapsed)
+	// an update resets the lifetime, as the environment is still in use
+	if err = m.setExpiry(ctx, ne.env, ne.rc); err != nil {
+		return "", fmt.Errorf("error setting environment expiry: %w", err)
+	}
 	k8senv, err := m.DL.GetK8sEnv(ctx, env.Name)
 	if err != nil {
 		return "", fmt.Errorf("error getting k8s environment: %w", err)
@@ -774,7 +873,8 @@ func (m *Manager) update(ctx context.Context, rd *models.RepoRevisionData) (envn
 	}
 	m.pushNotification(ctx, ne, notifier.UpdateEnvironment, "")
 	m.setGithubCommitStatus(ctx, rd, ne, models.CommitStatusPending, "")
-	td, cloc, err := m.fetchCharts(ctx, env.Name, ne.rc)
+	m.setGithubCheckRun(ctx, rd, ne, models.CommitStatusPending, nil)
+	td, cloc, err := m.fetchCharts(ctx, env.Name, ne.rc, ne.env.IsFork)
 	if err != nil {
 		return "", fmt.Errorf("error fetching charts: %w", err)
 	}
@@ -792,21 +892,35 @@ func (m *Manager) update(ctx context.Context, rd *models.RepoRevisionData) (envn
 	if ne.rc.ConfigSignature() == sig && env.Status == models.Success {
 		m.log(ctx, "config signature matches previous successful environment: performing helm release upgrades")
 		m.MC.Increment(mpfx+"update_in_place", "triggering_repo:"+rd.Repo)
-		releases, err := m.DL.GetHelmReleasesForEnv(ctx, env.Name)
+		envinfo.Releases, err = m.getReleaseNames(ctx, env.Name)
 		if err != nil {
-			return "", fmt.Errorf("error getting helm releases for env: %w", err)
+			return "", err
 		}
-		rsls := map[string]string{}
-		for _, r := range releases {
-			rsls[r.Name] = r.Release // chart title (dependency name) to release name
-		}
-		envinfo.Releases = rsls
 		if err := m.CI.BuildAndUpgradeCharts(ctx, envinfo, k8senv, mcloc); err != nil {
 			return envinfo.Env.Name, fmt.Errorf("error upgrading charts: %w", nitroerrors.User(err))
 		}
-		return envinfo.Env.Name, nil
+		return envinfo.Env.Name, m.runHealthChecks(ctx, envinfo)
+	}
+	// a zero signature forces a rebuild from scratch
+	if env.Status == models.Success && sig != [32]byte{} && len(k8senv.RepoConfigYAML) > 0 {
+		prc := models.RepoConfig{}
+		if err := yaml.Unmarshal(k8senv.RepoConfigYAML, &prc); err != nil {
+			m.log(ctx, "error unmarshaling previous repo config: %v", err)
+		} else {
+			diff := ne.rc.DependencyDiff(prc)
+			m.log(ctx, "config signature mismatch: performing incremental update (install: %v, uninstall: %v, upgrade: %v)", diff.Install, diff.Uninstall, diff.Upgrade)
+			m.MC.Increment(mpfx+"update_incremental", "triggering_repo:"+rd.Repo)
+			envinfo.Releases, err = m.getReleaseNames(ctx, env.Name)
+			if err != nil {
+				return "", err
+			}
+			if err := m.CI.BuildAndUpdateChartsIncrementally(ctx, envinfo, k8senv, mcloc, diff); err != nil {
+				return envinfo.Env.Name, fmt.Errorf("error updating charts: %w", nitroerrors.User(err))
+			}
+			return envinfo.Env.Name, m.runHealthChecks(ctx, envinfo)
+		}
 	}
-	m.log(ctx, "config signature mismatch or previous environment failed: tearing down namespace and building new env from scratch")
+	m.log(ctx, "previous environment failed or config can't be diffed: tearing down namespace and building new env from scratch")
 	m.MC.Increment(mpfx+"update_tear_down", "triggering_repo:"+rd.Repo)
 
 	go m.deleteNamespace(ctx, k8senv, rd.Repo)
@@ -818,5 +932,135 @@ func (m *Manager) update(ctx context.Context, rd *models.RepoRevisionData) (envn
 	}
 	chartSpan.Finish()
 
-	return envinfo.Env.Name, nil
+	return envinfo.Env.Name, m.runHealthChecks(ctx, &metahelm.EnvInfo{Env: ne.env, RC: ne.rc})
+}
+
+// getReleaseNames returns the helm release names for the environment by chart title (dependency name)
+func (m *Manager) getReleaseNames(ctx context.Context, envname string) (map[string]string, error) {
+	releases, err := m.DL.GetHelmReleasesForEnv(ctx, envname)
+	if err != nil {
+		return nil, fmt.Errorf("error getting helm releases for env: %w", err)
+	}
+	rsls := map[string]string{}
+	for _, r := range releases {
+		rsls[r.Name] = r.Release // chart title (dependency name) to release name
+	}
+	return rsls, nil
+}
+
+// Suspend scales all workloads in an existing environment to zero and marks it as suspended.
+// Suspended environments do not count against the global environment limit or environment quotas.
+func (m *Manager) Suspend(ctx context.Context, rd models.RepoRevisionData) error {
+	return m.lockingOperation(ctx, rd, func(ctx context.Context) error {
+		return m.suspend(ctx, &rd)
+	})
+}
+
+func (m *Manager) suspend(ctx context.Context, rd *models.RepoRevisionData) (err error) {
+	end := m.MC.Timing(mpfx+"suspend", "triggering_repo:"+rd.Repo)
+	span, ctx := tracer.StartSpanFromContext(ctx, "suspend")
+	defer func() {
+		end(fmt.Sprintf("success:%v", err == nil))
+		span.Finish(tracer.WithError(err))
+	}()
+	env, k8senv, err := m.getenvForScale(ctx, rd, models.SuspendEvent)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			eventlogger.GetLogger(ctx).SetCompletedStatus(models.FailedStatus)
+			return
+		}
+		eventlogger.GetLogger(ctx).SetCompletedStatus(models.DoneStatus)
+	}()
+	if env.Status != models.Success {
+		return nitroerrors.User(fmt.Errorf("only successful environments can be suspended (status: %v)", env.Status))
+	}
+	if err := m.CI.SuspendNamespace(ctx, k8senv); err != nil {
+		return fmt.Errorf("error suspending namespace: %w", err)
+	}
+	if err := m.DL.SetQAEnvironmentStatus(tracer.ContextWithSpan(context.Background(), span), env.Name, models.Suspended); err != nil {
+		return fmt.Errorf("error setting environment status: %w", err)
+	}
+	m.log(ctx, "environment suspended: %v", env.Name)
+	return nil
+}
+
+// Resume restores all workloads in a suspended environment to their previous replica counts and marks it as successful
+func (m *Manager) Resume(ctx context.Context, rd models.RepoRevisionData) error {
+	return m.lockingOperation(ctx, rd, func(ctx context.Context) error {
+		return m.resume(ctx, &rd)
+	})
+}
+
+func (m *Manager) resume(ctx context.Context, rd *models.RepoRevisionData) (err error) {
+	end := m.MC.Timing(mpfx+"resume", "triggering_repo:"+rd.Repo)
+	span, ctx := tracer.StartSpanFromContext(ctx, "resume")
+	defer func() {
+		end(fmt.Sprintf("success:%v", err == nil))
+		span.Finish(tracer.WithError(err))
+	}()
+	env, k8senv, err := m.getenvForScale(ctx, rd, models.ResumeEvent)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			eventlogger.GetLogger(ctx).SetCompletedStatus(models.FailedStatus)
+			return
+		}
+		eventlogger.GetLogger(ctx).SetCompletedStatus(models.DoneStatus)
+	}()
+	if env.Status != models.Suspended {
+		return nitroerrors.User(fmt.Errorf("environment is not suspended (status: %v)", env.Status))
+	}
+	if m.GlobalLimit > 0 {
+		// a resumed environment runs its workloads again, so refuse rather than evicting running environments
+		qae, err := m.DL.GetRunningQAEnvironments(ctx)
+		if err != nil {
+			return fmt.Errorf("error getting running environments: %w", err)
+		}
+		if len(qae) >= int(m.GlobalLimit) {
+			return nitroerrors.User(fmt.Errorf("global environment limit reached (running: %v, limit: %v)", len(qae), m.GlobalLimit))
+		}
+	}
+	// likewise, resuming never evicts environments within a quota scope
+	if err := m.enforceQuotas(ctx, env, false); err != nil {
+		return err
+	}
+	if err := m.CI.ResumeNamespace(ctx, k8senv); err != nil {
+		return fmt.Errorf("error resuming namespace: %w", err)
+	}
+	if err := m.DL.SetQAEnvironmentStatus(tracer.ContextWithSpan(context.Background(), span), env.Name, models.Success); err != nil {
+		return fmt.Errorf("error setting environment status: %w", err)
+	}
+	m.log(ctx, "environment resumed: %v", env.Name)
+	return nil
+}
+
+// getenvForScale returns the extant environment and k8s environment for rd and sets the event logger status for a suspend or resume event
+func (m *Manager) getenvForScale(ctx context.Context, rd *models.RepoRevisionData, etype models.EventStatusType) (*models.QAEnvironment, *models.KubernetesEnvironment, error) {
+	env, err := m.getenv(ctx, rd)
+	if err != nil {
+		eventlogger.GetLogger(ctx).SetNewStatus(etype, "<unknown>", *rd)
+		eventlogger.GetLogger(ctx).SetCompletedStatus(models.FailedStatus)
+		if err == extantEnvsErr {
+			return nil, nil, nitroerrors.User(err)
+		}
+		return nil, nil, fmt.Errorf("error getting extant environment: %w", err)
+	}
+	eventlogger.GetLogger(ctx).SetNewStatus(etype, env.Name, *rd)
+	m.setloggername(ctx, env.Name)
+	k8senv, err := m.DL.GetK8sEnv(ctx, env.Name)
+	if err != nil {
+		eventlogger.GetLogger(ctx).SetCompletedStatus(models.FailedStatus)
+		return nil, nil, fmt.Errorf("error getting k8s environment: %w", err)
+	}
+	if k8senv == nil {
+		eventlogger.GetLogger(ctx).SetCompletedStatus(models.FailedStatus)
+		return nil, nil, errors.New("missing k8s environment")
+	}
+	eventlogger.GetLogger(ctx).SetK8sNamespace(k8senv.Namespace)
+	return env, k8senv, nil
 }
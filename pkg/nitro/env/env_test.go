@@ -1,6 +1,7 @@
 package env
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	mathrand "math/rand"
@@ -25,13 +26,82 @@ import (
 	"github.com/dollarshaveclub/acyl/pkg/nitro/notifier"
 	"github.com/dollarshaveclub/acyl/pkg/persistence"
 	metahelmlib "github.com/dollarshaveclub/metahelm/pkg/metahelm"
+	"github.com/ghodss/yaml"
 	"github.com/google/go-cmp/cmp"
 	"github.com/pkg/errors"
+	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	k8sfake "k8s.io/client-go/kubernetes/fake"
 )
 
+func TestLockKey(t *testing.T) {
+	pr1, _ := lockKey(models.RepoRevisionData{Repo: "foo/bar", PullRequest: 1, SourceBranch: "feature-1"})
+	pr2, _ := lockKey(models.RepoRevisionData{Repo: "foo/bar", PullRequest: 1, SourceBranch: "feature-2"})
+	if pr1 != pr2 {
+		t.Fatalf("PR environments should be locked by repo and PR: %v, %v", pr1, pr2)
+	}
+	main, _ := lockKey(models.RepoRevisionData{Repo: "foo/bar", SourceBranch: "main"})
+	release, _ := lockKey(models.RepoRevisionData{Repo: "foo/bar", SourceBranch: "release"})
+	tag, _ := lockKey(models.RepoRevisionData{Repo: "foo/bar", SourceRef: "v1.0.0"})
+	if main == release || main == tag || release == tag {
+		t.Fatalf("environments without a PR should be locked per ref: %v, %v, %v", main, release, tag)
+	}
+	if main2, _ := lockKey(models.RepoRevisionData{Repo: "foo/bar", SourceBranch: "main", SourceRef: "main"}); main2 != main {
+		t.Fatalf("lock should be stable for the same ref: %v, %v", main, main2)
+	}
+}
+
+func TestLockingOperationAdHocRefs(t *testing.T) {
+	plf, err := locker.NewFakePreemptiveLockerFactory(
+		[]locker.LockProviderOption{
+			locker.WithLockTimeout(time.Second),
+		},
+		locker.WithLockDelay(time.Millisecond),
+	)
+	if err != nil {
+		t.Fatalf("error creating new preemptive locker factory: %v", err)
+	}
+	m := Manager{
+		PLF: plf,
+		MC:  &metrics.FakeCollector{},
+		DL:  persistence.NewFakeDataLayer(),
+	}
+	// ad-hoc environments created via the API for two refs in the same repo
+	rd1 := models.RepoRevisionData{Repo: "foo/bar", SourceBranch: "feature-1", SourceRef: "feature-1", BaseBranch: "main"}
+	rd2 := models.RepoRevisionData{Repo: "foo/bar", SourceBranch: "v1.0.0", SourceRef: "v1.0.0", BaseBranch: "main"}
+	e1 := models.QAEnvironment{Name: "foo-bar-1", Repo: rd1.Repo, SourceBranch: rd1.SourceBranch, SourceRef: rd1.SourceRef}
+	e2 := models.QAEnvironment{Name: "foo-bar-2", Repo: rd2.Repo, SourceBranch: rd2.SourceBranch, SourceRef: rd2.SourceRef}
+	if sharesLock(e1, e2) {
+		t.Fatalf("ad-hoc environments for different refs should not share a lock")
+	}
+	el := &eventlogger.Logger{DL: persistence.NewFakeDataLayer()}
+	locked := make(chan struct{})
+	done := make(chan struct{})
+	errs := make(chan error, 1)
+	go func() {
+		ctx := eventlogger.NewEventLoggerContext(context.Background(), el)
+		errs <- m.lockingOperation(ctx, rd1, func(ctx context.Context) error {
+			close(locked)
+			select {
+			case <-done:
+				return nil
+			case <-ctx.Done():
+				return fmt.Errorf("operation for %v was preempted: %w", rd1.SourceRef, ctx.Err())
+			}
+		})
+	}()
+	<-locked
+	ctx := eventlogger.NewEventLoggerContext(context.Background(), el)
+	if err := m.lockingOperation(ctx, rd2, func(ctx context.Context) error { return nil }); err != nil {
+		t.Fatalf("operation for %v should have succeeded while %v is locked: %v", rd2.SourceRef, rd1.SourceRef, err)
+	}
+	close(done)
+	if err := <-errs; err != nil {
+		t.Fatalf("operation should have succeeded: %v", err)
+	}
+}
+
 func TestLockingOperation(t *testing.T) {
 	operationTimeout := 5 * time.Second
 	el := &eventlogger.Logger{DL: persistence.NewFakeDataLayer()}
@@ -83,7 +153,7 @@ func TestLockingOperation(t *testing.T) {
 	}
 
 	ctx := eventlogger.NewEventLoggerContext(context.Background(), el)
-	err = m.lockingOperation(ctx, repo, pr, preemptedFunc)
+	err = m.lockingOperation(ctx, models.RepoRevisionData{Repo: repo, PullRequest: pr}, preemptedFunc)
 	cancelled := nitroerrors.IsCancelledError(err)
 	if err == nil {
 		t.Fatalf("expected preemption error")
@@ -97,7 +167,7 @@ func TestLockingOperation(t *testing.T) {
 
 	pr++
 	ctx2 := eventlogger.NewEventLoggerContext(context.Background(), el)
-	err = m.lockingOperation(ctx2, repo, pr, longOpFunc)
+	err = m.lockingOperation(ctx2, models.RepoRevisionData{Repo: repo, PullRequest: pr}, longOpFunc)
 	if err == nil {
 		t.Fatalf("should have timed out")
 	}
@@ -107,7 +177,7 @@ func TestLockingOperation(t *testing.T) {
 
 	pr++
 	ctx3 := eventlogger.NewEventLoggerContext(context.Background(), el)
-	err = m.lockingOperation(ctx3, repo, pr, hangingOpFunc)
+	err = m.lockingOperation(ctx3, models.RepoRevisionData{Repo: repo, PullRequest: pr}, hangingOpFunc)
 	if err == nil {
 		t.Fatalf("expected error from lockingOperation due to hanging operation function")
 	}
@@ -193,6 +263,17 @@ func TestGenerateNewEnv(t *testing.T) {
 	if env.SourceSHA != "1234" {
 		t.Fatalf("bad sha: %v", env.SourceSHA)
 	}
+	// environments without a PR are named after the ref
+	env, err = m.generateNewEnv(context.Background(), &models.RepoRevisionData{Repo: "foo/bar", User: "foo", BaseBranch: "master", SourceSHA: "5678", SourceBranch: "v1.0.0", SourceRef: "v1.0.0"})
+	if err != nil {
+		t.Fatalf("ad-hoc should have succeeded: %v", err)
+	}
+	if env.Name != namegen.RefName("foo/bar", "v1.0.0") {
+		t.Fatalf("bad ad-hoc name: %v", env.Name)
+	}
+	if env.SourceRef != "v1.0.0" {
+		t.Fatalf("bad ref: %v", env.SourceRef)
+	}
 }
 
 func TestFetchCharts(t *testing.T) {
@@ -200,7 +281,7 @@ func TestFetchCharts(t *testing.T) {
 		GetFunc: func(ctx context.Context, rd models.RepoRevisionData) (*models.RepoConfig, error) {
 			return &models.RepoConfig{}, nil
 		},
-		FetchChartsFunc: func(ctx context.Context, rc *models.RepoConfig, basePath string) (meta.ChartLocations, error) {
+		FetchChartsFunc: func(ctx context.Context, rc *models.RepoConfig, basePath string, untrusted bool) (meta.ChartLocations, error) {
 			return meta.ChartLocations{
 				"foo/bar": meta.ChartLocation{
 					ChartPath:   "/tmp/foo/bar",
@@ -220,7 +301,7 @@ func TestFetchCharts(t *testing.T) {
 		FS: memfs.New(),
 		MC: &metrics.FakeCollector{},
 	}
-	_, _, err := m.fetchCharts(context.Background(), "foo-bar", &models.RepoConfig{})
+	_, _, err := m.fetchCharts(context.Background(), "foo-bar", &models.RepoConfig{}, false)
 	if err != nil {
 		t.Fatalf("should have succeeded: %v", err)
 	}
@@ -475,7 +556,7 @@ func TestCreate(t *testing.T) {
 				GetFunc: func(ctx context.Context, rd models.RepoRevisionData) (*models.RepoConfig, error) {
 					return &c.inputRC, nil
 				},
-				FetchChartsFunc: func(ctx context.Context, rc *models.RepoConfig, basePath string) (meta.ChartLocations, error) {
+				FetchChartsFunc: func(ctx context.Context, rc *models.RepoConfig, basePath string, untrusted bool) (meta.ChartLocations, error) {
 					return c.inputCL, nil
 				},
 			}
@@ -618,6 +699,13 @@ func TestUpdate(t *testing.T) {
 	bm2["foo/postgres"] = []ghclient.BranchInfo{ghclient.BranchInfo{Name: "master", SHA: "9992"}}
 	cir2 := cir
 	cir2["foo-postgres"] = nil
+	rcy, err := yaml.Marshal(&rc)
+	if err != nil {
+		t.Fatalf("error marshaling repo config: %v", err)
+	}
+	k8senv2 := k8senv
+	k8senv2.Namespace = "nitro-5678-" + env.Name
+	k8senv2.RepoConfigYAML = rcy
 	cases := []struct {
 		name               string
 		inputRDD           models.RepoRevisionData
@@ -695,6 +783,36 @@ func TestUpdate(t *testing.T) {
 				}
 			},
 		},
+		{
+			"update incremental", rdd, env, k8senv2, releases, rc2, cl2, bm2, cir2, 0, 0,
+			func(err error, dl persistence.DataLayer, nt *notificationTracker, st *testing.T) {
+				if err != nil {
+					st.Fatalf("should have succeeded: %v", err)
+				}
+				k8senv, err := dl.GetK8sEnv(context.Background(), env.Name)
+				if err != nil || k8senv == nil {
+					st.Fatalf("k8s env should exist: %v", err)
+				}
+				if k8senv.Namespace != k8senv2.Namespace {
+					st.Fatalf("namespace should have been preserved: %v", k8senv.Namespace)
+				}
+				sig := rc2.ConfigSignature()
+				if !bytes.Equal(k8senv.ConfigSignature, sig[:]) {
+					st.Fatalf("config signature should have been updated")
+				}
+				prc := models.RepoConfig{}
+				if err := yaml.Unmarshal(k8senv.RepoConfigYAML, &prc); err != nil {
+					st.Fatalf("error unmarshaling repo config: %v", err)
+				}
+				if len(prc.Dependencies.Direct) != 3 {
+					st.Fatalf("repo config should have been updated: %+v", prc.Dependencies)
+				}
+				rlses, _ := dl.GetHelmReleasesForEnv(context.Background(), env.Name)
+				if len(rlses) != 4 {
+					st.Fatalf("bad release count: %v: %v", len(rlses), rlses)
+				}
+			},
+		},
 		{
 			"missing k8senv", rdd, env, models.KubernetesEnvironment{}, releases, rc, cl, bm, cir, 0, 0,
 			func(err error, dl persistence.DataLayer, nt *notificationTracker, st *testing.T) {
@@ -737,7 +855,7 @@ func TestUpdate(t *testing.T) {
 				GetFunc: func(ctx context.Context, rd models.RepoRevisionData) (*models.RepoConfig, error) {
 					return &c.inputRC, nil
 				},
-				FetchChartsFunc: func(ctx context.Context, rc *models.RepoConfig, basePath string) (meta.ChartLocations, error) {
+				FetchChartsFunc: func(ctx context.Context, rc *models.RepoConfig, basePath string, untrusted bool) (meta.ChartLocations, error) {
 					return c.inputCL, nil
 				},
 			}
@@ -956,6 +1074,107 @@ func TestDelete(t *testing.T) {
 	}
 }
 
+func TestSuspendResume(t *testing.T) {
+	dl := persistence.NewFakeDataLayer()
+	rdd := models.RepoRevisionData{
+		Repo:         "foo/bar",
+		PullRequest:  1,
+		SourceSHA:    "asdf",
+		SourceBranch: "feature-spam",
+		BaseSHA:      "1234",
+		BaseBranch:   "release",
+	}
+	env := models.QAEnvironment{
+		Name:        "some-name",
+		Repo:        rdd.Repo,
+		PullRequest: rdd.PullRequest,
+		Status:      models.Success,
+	}
+	k8senv := models.KubernetesEnvironment{
+		EnvName:   env.Name,
+		Namespace: "nitro-1234-" + env.Name,
+	}
+	dl.CreateQAEnvironment(context.Background(), &env)
+	dl.CreateK8sEnv(context.Background(), &k8senv)
+	replicas := int32(3)
+	kc := k8sfake.NewSimpleClientset(
+		&appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: k8senv.Namespace},
+			Spec:       appsv1.DeploymentSpec{Replicas: &replicas},
+		},
+		&appsv1.StatefulSet{
+			ObjectMeta: metav1.ObjectMeta{Name: "db", Namespace: k8senv.Namespace},
+			Spec:       appsv1.StatefulSetSpec{Replicas: &replicas},
+		},
+	)
+	plf, err := locker.NewFakePreemptiveLockerFactory(
+		[]locker.LockProviderOption{locker.WithLockTimeout(time.Second)},
+		locker.WithLockDelay(time.Millisecond),
+	)
+	if err != nil {
+		t.Fatalf("error creating new preemptive locker factory: %v", err)
+	}
+	m := Manager{
+		DL:          dl,
+		PLF:         plf,
+		NF:          testNF,
+		MC:          &metrics.FakeCollector{},
+		CI:          &metahelm.FakeInstaller{DL: dl, KC: kc},
+		GlobalLimit: 1,
+	}
+	newctx := func() context.Context {
+		el := &eventlogger.Logger{DL: dl}
+		el.Init([]byte{}, rdd.Repo, rdd.PullRequest)
+		return eventlogger.NewEventLoggerContext(context.Background(), el)
+	}
+	checkReplicas := func(n int32) {
+		d, _ := kc.AppsV1().Deployments(k8senv.Namespace).Get(context.Background(), "web", metav1.GetOptions{})
+		if *d.Spec.Replicas != n {
+			t.Fatalf("bad deployment replicas: %v (wanted %v)", *d.Spec.Replicas, n)
+		}
+		s, _ := kc.AppsV1().StatefulSets(k8senv.Namespace).Get(context.Background(), "db", metav1.GetOptions{})
+		if *s.Spec.Replicas != n {
+			t.Fatalf("bad statefulset replicas: %v (wanted %v)", *s.Spec.Replicas, n)
+		}
+	}
+
+	if err := m.Resume(newctx(), rdd); err == nil || !nitroerrors.IsUserError(err) {
+		t.Fatalf("resume of unsuspended env should have failed with user error: %v", err)
+	}
+	if err := m.Suspend(newctx(), rdd); err != nil {
+		t.Fatalf("suspend should have succeeded: %v", err)
+	}
+	checkReplicas(0)
+	e2, _ := dl.GetQAEnvironment(context.Background(), env.Name)
+	if e2.Status != models.Suspended {
+		t.Fatalf("bad status: %v", e2.Status)
+	}
+	running, _ := dl.GetRunningQAEnvironments(context.Background())
+	if len(running) != 0 {
+		t.Fatalf("suspended env should not count as running: %v", len(running))
+	}
+	if err := m.Suspend(newctx(), rdd); err == nil {
+		t.Fatalf("suspend of suspended env should have failed")
+	}
+
+	// another running environment fills the global limit
+	other := models.QAEnvironment{Name: "other-name", Repo: "foo/other", PullRequest: 2, Status: models.Success}
+	dl.CreateQAEnvironment(context.Background(), &other)
+	if err := m.Resume(newctx(), rdd); err == nil || !strings.Contains(err.Error(), "global environment limit") {
+		t.Fatalf("resume should have failed due to global limit: %v", err)
+	}
+	dl.SetQAEnvironmentStatus(context.Background(), other.Name, models.Destroyed)
+
+	if err := m.Resume(newctx(), rdd); err != nil {
+		t.Fatalf("resume should have succeeded: %v", err)
+	}
+	checkReplicas(3)
+	e2, _ = dl.GetQAEnvironment(context.Background(), env.Name)
+	if e2.Status != models.Success {
+		t.Fatalf("bad status: %v", e2.Status)
+	}
+}
+
 var testChartError = metahelmlib.ChartError{
 	HelmError: errors.New("some helm error"),
 	Level:     1,
@@ -1367,6 +1586,125 @@ func TestSetGithubCommitStatus(t *testing.T) {
 	}
 }
 
+func TestCreateHealthCheckStatus(t *testing.T) {
+	rdd := models.RepoRevisionData{
+		Repo:         "foo/bar",
+		PullRequest:  1,
+		SourceSHA:    "asdf",
+		SourceBranch: "feature-spam",
+		BaseSHA:      "1234",
+		BaseBranch:   "release",
+	}
+	rc := models.RepoConfig{
+		Application: models.RepoConfigAppMetadata{
+			Repo:          "foo/bar",
+			Ref:           "asdf",
+			Branch:        "feature-spam",
+			ChartPath:     ".chart/bar",
+			ChartVarsPath: "./chart/vars.yml",
+			Image:         "foo/bar",
+			ChartTagValue: "image.tag",
+			HealthChecks: []models.HealthCheck{
+				models.HealthCheck{Name: "ready", Exec: &models.ExecHealthCheck{Selector: "app=bar", Command: []string{"true"}}},
+			},
+		},
+	}
+	cl := meta.ChartLocations{
+		"foo-bar": meta.ChartLocation{ChartPath: "/tmp/foo/bar", VarFilePath: "/tmp/foo/bar/vars.yml"},
+	}
+	cases := []struct {
+		name       string
+		checkErr   error
+		wantErr    bool
+		wantStatus models.EnvironmentStatus
+	}{
+		{"passing", nil, false, models.Success},
+		{"failing", errors.New("connection refused"), true, models.Failure},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			dl := persistence.NewFakeDataLayer()
+			fg := &meta.FakeGetter{
+				GetFunc: func(ctx context.Context, rd models.RepoRevisionData) (*models.RepoConfig, error) {
+					return &rc, nil
+				},
+				FetchChartsFunc: func(ctx context.Context, rc *models.RepoConfig, basePath string, untrusted bool) (meta.ChartLocations, error) {
+					return cl, nil
+				},
+			}
+			var statuses []string
+			frc := &ghclient.FakeRepoClient{
+				GetBranchesFunc: func(ctx context.Context, name string) ([]ghclient.BranchInfo, error) {
+					return []ghclient.BranchInfo{ghclient.BranchInfo{Name: "feature-spam"}, ghclient.BranchInfo{Name: "release"}}, nil
+				},
+				GetCommitMessageFunc: func(context.Context, string, string) (string, error) { return "", nil },
+				SetStatusFunc: func(ctx context.Context, repo string, sha string, cs *ghclient.CommitStatus) error {
+					statuses = append(statuses, cs.Status)
+					return nil
+				},
+			}
+			var envname string
+			ci := &metahelm.FakeInstaller{
+				ChartInstallFunc: func(repo string, location metahelm.ChartLocation) error { return nil },
+				HealthCheckFunc: func(name string, hc models.HealthCheck) error {
+					env, err := dl.GetQAEnvironment(context.Background(), envname)
+					if err != nil || env == nil {
+						t.Fatalf("error getting environment: %v", err)
+					}
+					if env.Status == models.Success {
+						t.Errorf("environment status should not be success before health checks pass")
+					}
+					for _, s := range statuses {
+						if s == "success" {
+							t.Errorf("commit status should not be success before health checks pass")
+						}
+					}
+					return c.checkErr
+				},
+				DL: dl,
+				KC: k8sfake.NewSimpleClientset(),
+			}
+			plf, err := locker.NewFakePreemptiveLockerFactory(
+				[]locker.LockProviderOption{locker.WithLockTimeout(2 * time.Second)},
+				locker.WithLockDelay(10*time.Millisecond),
+			)
+			if err != nil {
+				t.Fatalf("error creating new preemptive locker factory: %v", err)
+			}
+			ng := &namegen.FakeNameGenerator{}
+			envname, _ = ng.New()
+			m := Manager{
+				DL:  dl,
+				PLF: plf,
+				NF:  testNF,
+				MC:  &metrics.FakeCollector{},
+				NG:  ng,
+				FS:  memfs.New(),
+				MG:  fg,
+				RC:  frc,
+				CI:  ci,
+			}
+			el := &eventlogger.Logger{DL: dl}
+			el.Init([]byte{}, rdd.Repo, rdd.PullRequest)
+			ctx := eventlogger.NewEventLoggerContext(context.Background(), el)
+			name, err := m.Create(ctx, rdd)
+			if c.wantErr != (err != nil) {
+				t.Fatalf("unexpected error result: %v", err)
+			}
+			if name == "" {
+				name = envname
+			}
+			env, err := dl.GetQAEnvironment(context.Background(), name)
+			if err != nil || env == nil {
+				t.Fatalf("error getting environment: %v", err)
+			}
+			if env.Status != c.wantStatus {
+				t.Fatalf("bad status: %v (wanted %v)", env.Status, c.wantStatus)
+			}
+		})
+	}
+}
+
 func TestCreateEnvStatusUnknown(t *testing.T) {
 	dl := persistence.NewFakeDataLayer()
 	id, _ := uuid.NewRandom()
@@ -1429,7 +1767,7 @@ func TestCreateEnvStatusUnknown(t *testing.T) {
 		GetFunc: func(ctx context.Context, rd models.RepoRevisionData) (*models.RepoConfig, error) {
 			return &rc, nil
 		},
-		FetchChartsFunc: func(ctx context.Context, rc *models.RepoConfig, basePath string) (meta.ChartLocations, error) {
+		FetchChartsFunc: func(ctx context.Context, rc *models.RepoConfig, basePath string, untrusted bool) (meta.ChartLocations, error) {
 			return cl, nil
 		},
 	}
@@ -0,0 +1,59 @@
+package env
+
+import (
+	"context"
+
+	"github.com/dollarshaveclub/acyl/pkg/eviction"
+	"github.com/dollarshaveclub/acyl/pkg/models"
+	"github.com/pkg/errors"
+)
+
+func (m *Manager) evictionPolicy() eviction.Policy {
+	if m.EvictionPolicy == nil {
+		return eviction.ActivityPolicy{}
+	}
+	return m.EvictionPolicy
+}
+
+// evictable filters envs to those that may be evicted on behalf of env, which excludes env itself.
+// Environments sharing the operation lock with env (eg, a stale environment for the same PR) are also excluded,
+// since evicting them would preempt the operation in progress.
+func evictable(envs []models.QAEnvironment, env *models.QAEnvironment) []models.QAEnvironment {
+	out := make([]models.QAEnvironment, 0, len(envs))
+	for _, e := range envs {
+		if env != nil && (e.Name == env.Name || sharesLock(e, *env)) {
+			continue
+		}
+		out = append(out, e)
+	}
+	return out
+}
+
+// sharesLock returns if operations on environments e1 and e2 use the same lock
+func sharesLock(e1, e2 models.QAEnvironment) bool {
+	r1, pr1 := lockKey(*e1.RepoRevisionDataFromQA())
+	r2, pr2 := lockKey(*e2.RepoRevisionDataFromQA())
+	return r1 == r2 && pr1 == pr2
+}
+
+// evict destroys victims chosen by p, recording the policy and the reason each was chosen in the environment events and destroy notification
+func (m *Manager) evict(ctx context.Context, p eviction.Policy, victims []eviction.Victim, reason models.QADestroyReason) error {
+	for _, v := range victims {
+		ev := eviction.Eviction{Policy: p.Name(), Reason: v.Reason}
+		m.log(ctx, "destroying: %v (%v)", v.Env.Name, ev)
+		m.DL.AddEvent(ctx, v.Env.Name, ev.String())
+		// we lock around each destroyed environment to preempt any ongoing operations
+		// create a new context as lockingOperation() always cancels the context when finished
+		ctx2, cf := context.WithCancel(eviction.NewEvictionContext(ctx, ev))
+		if err := m.Delete(ctx2, v.Env.RepoRevisionDataFromQA(), reason); err != nil {
+			m.log(ctx, "error destroying evicted environment: %v", err)
+		}
+		cf()
+		select {
+		case <-ctx.Done():
+			return errors.New("context was cancelled")
+		default:
+		}
+	}
+	return nil
+}
@@ -0,0 +1,161 @@
+package env
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dollarshaveclub/acyl/pkg/eventlogger"
+	"github.com/dollarshaveclub/acyl/pkg/eviction"
+	"github.com/dollarshaveclub/acyl/pkg/ghclient"
+	"github.com/dollarshaveclub/acyl/pkg/locker"
+	"github.com/dollarshaveclub/acyl/pkg/models"
+	"github.com/dollarshaveclub/acyl/pkg/nitro/meta"
+	"github.com/dollarshaveclub/acyl/pkg/nitro/metahelm"
+	"github.com/dollarshaveclub/acyl/pkg/nitro/metrics"
+	"github.com/dollarshaveclub/acyl/pkg/nitro/notifier"
+	"github.com/dollarshaveclub/acyl/pkg/persistence"
+)
+
+func TestEnforceGlobalLimitEviction(t *testing.T) {
+	tests := []struct {
+		name      string
+		policy    eviction.Policy
+		destroyed []string
+	}{
+		{
+			name:      "activity",
+			destroyed: []string{"foo-failed", "foo-idle"},
+		},
+		{
+			name:      "oldest",
+			policy:    eviction.OldestPolicy{},
+			destroyed: []string{"foo-active"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dl := persistence.NewFakeDataLayer()
+			now := time.Now().UTC()
+			pinned := now.Add(24 * time.Hour)
+			accessed := now.Add(-time.Minute)
+			existing := []models.QAEnvironment{
+				{Name: "foo-demo", Repo: "foo/demo", PullRequest: 1, Status: models.Success, Created: now.Add(-30 * 24 * time.Hour), PinnedUntil: &pinned},
+				{Name: "foo-active", Repo: "foo/active", PullRequest: 1, Status: models.Success, Created: now.Add(-10 * 24 * time.Hour), LastAccessed: &accessed},
+				{Name: "foo-idle", Repo: "foo/idle", PullRequest: 1, Status: models.Success, Created: now.Add(-5 * 24 * time.Hour)},
+				{Name: "foo-failed", Repo: "foo/failed", PullRequest: 1, Status: models.Failure, Created: now.Add(-time.Hour)},
+			}
+			for i := range existing {
+				dl.CreateQAEnvironment(context.Background(), &existing[i])
+				dl.CreateK8sEnv(context.Background(), &models.KubernetesEnvironment{EnvName: existing[i].Name, Namespace: "nitro-" + existing[i].Name})
+			}
+			newenv := &models.QAEnvironment{Name: "new-env", Repo: "foo/bar", PullRequest: 3, Status: models.Spawned, Created: now}
+			dl.CreateQAEnvironment(context.Background(), newenv)
+			plf, err := locker.NewFakePreemptiveLockerFactory(
+				[]locker.LockProviderOption{locker.WithLockTimeout(time.Second)},
+				locker.WithLockDelay(time.Millisecond),
+			)
+			if err != nil {
+				t.Fatalf("error creating new preemptive locker factory: %v", err)
+			}
+			nt := newNotificationTracker()
+			m := Manager{
+				DL:  dl,
+				PLF: plf,
+				NF:  nt.sender,
+				MC:  &metrics.FakeCollector{},
+				MG: &meta.FakeGetter{GetFunc: func(ctx context.Context, rd models.RepoRevisionData) (*models.RepoConfig, error) {
+					return &models.RepoConfig{}, nil
+				}},
+				RC:             &ghclient.FakeRepoClient{GetCommitMessageFunc: func(context.Context, string, string) (string, error) { return "", nil }},
+				CI:             &metahelm.FakeInstaller{DL: dl},
+				GlobalLimit:    3,
+				EvictionPolicy: tt.policy,
+			}
+			el := &eventlogger.Logger{DL: dl}
+			el.Init([]byte{}, newenv.Repo, newenv.PullRequest)
+			ctx := eventlogger.NewEventLoggerContext(context.Background(), el)
+			if err := m.enforceGlobalLimit(ctx, newenv); err != nil {
+				t.Fatalf("should have succeeded: %v", err)
+			}
+			destroyed := map[string]bool{}
+			for _, name := range tt.destroyed {
+				destroyed[name] = true
+			}
+			for _, e := range append(existing, *newenv) {
+				e2, _ := dl.GetQAEnvironment(context.Background(), e.Name)
+				if (e2.Status == models.Destroyed) != destroyed[e.Name] {
+					t.Fatalf("bad status for %v: %v", e.Name, e2.Status)
+				}
+			}
+			var notified bool
+			for _, n := range nt.get() {
+				if n.Event != notifier.DestroyEnvironment {
+					continue
+				}
+				notified = true
+				if n.Data.EvictionPolicy != m.evictionPolicy().Name() || n.Data.EvictionReason == "" {
+					t.Fatalf("destroy notification should name the eviction policy and reason: %+v", n.Data)
+				}
+				rn, err := models.DefaultNotificationTemplates["destroy"].Render(n.Data)
+				if err != nil {
+					t.Fatalf("error rendering notification: %v", err)
+				}
+				if rn.Title != "💣 Destroying Environment (Evicted)" {
+					t.Fatalf("bad title: %v", rn.Title)
+				}
+			}
+			if !notified {
+				t.Fatalf("expected a destroy notification")
+			}
+		})
+	}
+}
+
+func TestEvictable(t *testing.T) {
+	envs := []models.QAEnvironment{
+		{Name: "foo-pr-1", Repo: "foo/bar", PullRequest: 1},
+		{Name: "foo-pr-1-stale", Repo: "foo/bar", PullRequest: 1},
+		{Name: "foo-pr-2", Repo: "foo/bar", PullRequest: 2},
+		{Name: "foo-main", Repo: "foo/bar", SourceBranch: "main"},
+		{Name: "foo-release", Repo: "foo/bar", SourceBranch: "release"},
+		{Name: "foo-v1", Repo: "foo/bar", SourceBranch: "v1.0.0", SourceRef: "v1.0.0"},
+	}
+	names := func(envs []models.QAEnvironment) []string {
+		out := []string{}
+		for _, e := range envs {
+			out = append(out, e.Name)
+		}
+		return out
+	}
+	tests := []struct {
+		name string
+		env  models.QAEnvironment
+		want []string
+	}{
+		{
+			name: "PR",
+			env:  envs[0],
+			want: []string{"foo-pr-2", "foo-main", "foo-release", "foo-v1"},
+		},
+		{
+			name: "branch",
+			env:  envs[3],
+			want: []string{"foo-pr-1", "foo-pr-1-stale", "foo-pr-2", "foo-release", "foo-v1"},
+		},
+		{
+			name: "ad-hoc ref",
+			env:  models.QAEnvironment{Name: "foo-v2", Repo: "foo/bar", SourceBranch: "v2.0.0", SourceRef: "v2.0.0"},
+			want: []string{"foo-pr-1", "foo-pr-1-stale", "foo-pr-2", "foo-main", "foo-release", "foo-v1"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := names(evictable(envs, &tt.env))
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Fatalf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
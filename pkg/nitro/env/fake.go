@@ -36,3 +36,15 @@ func (fm *FakeManager) Success(context.Context, string) error {
 func (fm *FakeManager) Failure(context.Context, string, string) error {
 	return nil
 }
+
+func (fm *FakeManager) Suspend(context.Context, models.RepoRevisionData) error {
+	return nil
+}
+
+func (fm *FakeManager) Resume(context.Context, models.RepoRevisionData) error {
+	return nil
+}
+
+func (fm *FakeManager) WarnExpiry(context.Context, *models.QAEnvironment) error {
+	return nil
+}
@@ -0,0 +1,63 @@
+package env
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/dollarshaveclub/acyl/pkg/models"
+	"github.com/dollarshaveclub/acyl/pkg/nitro/notifier"
+)
+
+// setExpiry sets the expiry of env to the lifetime requested in rc (bounded by the server lifetimes) from now.
+// An expiry that was extended beyond the new expiry is kept, and the expiry is removed if the environment no longer has a lifetime.
+// Ad-hoc environments fall back to the ad-hoc lifetime, as nothing else destroys them.
+func (m *Manager) setExpiry(ctx context.Context, env *models.QAEnvironment, rc *models.RepoConfig) error {
+	requested, err := rc.LifetimeDuration()
+	if err != nil {
+		return err
+	}
+	d := m.Lifetimes.Lifetime(requested)
+	if rc.AdHoc(*env.RepoRevisionDataFromQA()) {
+		d = m.Lifetimes.AdHocLifetime(requested)
+	}
+	if d == 0 {
+		if env.Expires != nil {
+			if err := m.DL.SetQAEnvironmentExpires(ctx, env.Name, nil); err != nil {
+				return fmt.Errorf("error removing expiry: %w", err)
+			}
+			env.Expires, env.ExpiryWarned = nil, false
+			m.log(ctx, "environment no longer has a lifetime and will not expire")
+		}
+		return nil
+	}
+	if requested != 0 && requested != d {
+		m.log(ctx, "requested lifetime %v is outside of the allowed bounds, using %v", requested, d)
+	}
+	expires := time.Now().UTC().Add(d)
+	if env.Expires != nil && env.Expires.After(expires) {
+		m.log(ctx, "environment lifetime was extended, keeping expiry: %v", env.Expires.Format(time.RFC3339))
+		return nil
+	}
+	if err := m.DL.SetQAEnvironmentExpires(ctx, env.Name, &expires); err != nil {
+		return fmt.Errorf("error setting expiry: %w", err)
+	}
+	env.Expires, env.ExpiryWarned = &expires, false
+	m.log(ctx, "environment expires at %v (lifetime: %v)", expires.Format(time.RFC3339), d)
+	return nil
+}
+
+// WarnExpiry sends the expiry warning notification for env
+func (m *Manager) WarnExpiry(ctx context.Context, env *models.QAEnvironment) error {
+	if env.Expires == nil {
+		return fmt.Errorf("environment does not expire: %v", env.Name)
+	}
+	// the repo config is only needed for the notification settings, so fall back to the defaults if it can't be fetched
+	rc, err := m.getRepoConfig(ctx, env.RepoRevisionDataFromQA())
+	if err != nil {
+		m.log(ctx, "error getting repo config for expiry warning, using default notifications: %v", err)
+		rc = nil
+	}
+	m.pushNotification(ctx, &newEnv{env: env, rc: rc}, notifier.ExpiryWarning, "")
+	return nil
+}
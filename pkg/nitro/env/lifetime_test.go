@@ -0,0 +1,122 @@
+package env
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/dollarshaveclub/acyl/pkg/config"
+	"github.com/dollarshaveclub/acyl/pkg/ghclient"
+	"github.com/dollarshaveclub/acyl/pkg/models"
+	"github.com/dollarshaveclub/acyl/pkg/nitro/meta"
+	"github.com/dollarshaveclub/acyl/pkg/nitro/metrics"
+	"github.com/dollarshaveclub/acyl/pkg/nitro/notifier"
+	"github.com/dollarshaveclub/acyl/pkg/persistence"
+)
+
+func TestSetExpiry(t *testing.T) {
+	now := time.Now().UTC()
+	extended := now.Add(30 * 24 * time.Hour)
+	soon := now.Add(time.Hour)
+	tests := []struct {
+		name      string
+		lifetimes config.EnvironmentLifetimes
+		lifetime  string
+		expires   *time.Time
+		// adHoc environments are created from a ref without a PR, tracked is set if the ref is a tracked branch
+		adHoc, tracked bool
+		// want is the expected lifetime from now, or zero if the environment shouldn't expire
+		want    time.Duration
+		wantErr bool
+	}{
+		{name: "no lifetime"},
+		{name: "server default", lifetimes: config.EnvironmentLifetimes{Default: 48 * time.Hour}, want: 48 * time.Hour},
+		{name: "repo lifetime", lifetimes: config.EnvironmentLifetimes{Default: 48 * time.Hour}, lifetime: "72h", want: 72 * time.Hour},
+		{name: "above max", lifetimes: config.EnvironmentLifetimes{Max: 96 * time.Hour}, lifetime: "720h", want: 96 * time.Hour},
+		{name: "max without default", lifetimes: config.EnvironmentLifetimes{Max: 96 * time.Hour}, want: 96 * time.Hour},
+		{name: "below min", lifetimes: config.EnvironmentLifetimes{Min: 24 * time.Hour}, lifetime: "1h", want: 24 * time.Hour},
+		{name: "update resets expiry", lifetime: "72h", expires: &soon, want: 72 * time.Hour},
+		{name: "extension is kept", lifetime: "72h", expires: &extended, want: extended.Sub(now)},
+		{name: "lifetime removed", expires: &soon},
+		{name: "invalid lifetime", lifetime: "forever", wantErr: true},
+		{name: "ad-hoc fallback", lifetimes: config.EnvironmentLifetimes{AdHoc: 72 * time.Hour}, adHoc: true, want: 72 * time.Hour},
+		{name: "ad-hoc repo lifetime", lifetimes: config.EnvironmentLifetimes{AdHoc: 72 * time.Hour}, lifetime: "24h", adHoc: true, want: 24 * time.Hour},
+		{name: "PR without ad-hoc fallback", lifetimes: config.EnvironmentLifetimes{AdHoc: 72 * time.Hour}},
+		{name: "tracked branch without ad-hoc fallback", lifetimes: config.EnvironmentLifetimes{AdHoc: 72 * time.Hour}, adHoc: true, tracked: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dl := persistence.NewFakeDataLayer()
+			env := &models.QAEnvironment{Name: "foo-bar", Repo: "foo/bar", PullRequest: 1, Status: models.Spawned, Expires: tt.expires, ExpiryWarned: tt.expires != nil}
+			rc := &models.RepoConfig{Lifetime: tt.lifetime}
+			if tt.adHoc {
+				env.PullRequest, env.SourceBranch, env.SourceRef = 0, "release", "release"
+				if tt.tracked {
+					rc.TrackBranches = []string{"release"}
+				}
+			}
+			dl.CreateQAEnvironment(context.Background(), env)
+			m := Manager{DL: dl, Lifetimes: tt.lifetimes}
+			err := m.setExpiry(context.Background(), env, rc)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("bad error: %v (wantErr: %v)", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			qae, _ := dl.GetQAEnvironment(context.Background(), "foo-bar")
+			if tt.want == 0 {
+				if qae.Expires != nil {
+					t.Fatalf("environment should not expire: %v", qae.Expires)
+				}
+				return
+			}
+			if qae.Expires == nil {
+				t.Fatalf("environment should expire")
+			}
+			if d := qae.Expires.Sub(now); d < tt.want || d > tt.want+time.Minute {
+				t.Fatalf("bad lifetime: %v (wanted %v)", d, tt.want)
+			}
+			if qae.ExpiryWarned != (tt.expires == &extended) {
+				t.Fatalf("bad expiry warned: %v", qae.ExpiryWarned)
+			}
+		})
+	}
+}
+
+func TestWarnExpiry(t *testing.T) {
+	dl := persistence.NewFakeDataLayer()
+	expires := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+	env := &models.QAEnvironment{Name: "foo-bar", Repo: "foo/bar", PullRequest: 1, Status: models.Success, Expires: &expires}
+	dl.CreateQAEnvironment(context.Background(), env)
+	nt := newNotificationTracker()
+	m := Manager{
+		DL: dl,
+		NF: nt.sender,
+		MC: &metrics.FakeCollector{},
+		MG: &meta.FakeGetter{GetFunc: func(ctx context.Context, rd models.RepoRevisionData) (*models.RepoConfig, error) {
+			return &models.RepoConfig{}, nil
+		}},
+		RC: &ghclient.FakeRepoClient{GetCommitMessageFunc: func(context.Context, string, string) (string, error) { return "", nil }},
+	}
+	if err := m.WarnExpiry(context.Background(), env); err != nil {
+		t.Fatalf("should have succeeded: %v", err)
+	}
+	ns := nt.get()
+	if len(ns) != 1 || ns[0].Event != notifier.ExpiryWarning {
+		t.Fatalf("expected an expiry warning notification: %+v", ns)
+	}
+	if ns[0].Data.Expires != "2030-01-02T03:04:05Z" {
+		t.Fatalf("bad expires: %v", ns[0].Data.Expires)
+	}
+	rn, err := models.DefaultPRCommentTemplates[ns[0].Event.Key()].Render(ns[0].Data)
+	if err != nil {
+		t.Fatalf("error rendering notification: %v", err)
+	}
+	if rn.Title != "⏳ Environment Expiring" {
+		t.Fatalf("bad title: %v", rn.Title)
+	}
+	if err := m.WarnExpiry(context.Background(), &models.QAEnvironment{Name: "foo-baz"}); err == nil {
+		t.Fatalf("should have failed for an environment that doesn't expire")
+	}
+}
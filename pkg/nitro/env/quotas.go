@@ -0,0 +1,59 @@
+package env
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/dollarshaveclub/acyl/pkg/eviction"
+	"github.com/dollarshaveclub/acyl/pkg/models"
+	nitroerrors "github.com/dollarshaveclub/acyl/pkg/nitro/errors"
+)
+
+// enforceQuotas checks the running environments within each repo, org and user quota scope of env against the configured quotas.
+// If a quota would be exceeded by env and evict is true, environments within that scope chosen by the eviction policy are destroyed to make room. Otherwise, a user error is returned.
+// A user error is also returned if there aren't enough environments eligible for eviction (for example, because they are pinned).
+func (m *Manager) enforceQuotas(ctx context.Context, env *models.QAEnvironment, evict bool) error {
+	quotas := m.Quotas.Quotas(env.Repo, env.User)
+	if len(quotas) == 0 {
+		return nil
+	}
+	p := m.evictionPolicy()
+	qae, err := eviction.Candidates(ctx, m.DL, p)
+	if err != nil {
+		return fmt.Errorf("error getting eviction candidates: %w", err)
+	}
+	destroyed := map[string]struct{}{}
+	for _, q := range quotas {
+		scoped := []models.QAEnvironment{}
+		for _, e := range qae {
+			if _, ok := destroyed[e.Name]; ok || e.Name == env.Name || !q.Matches(e) {
+				continue
+			}
+			scoped = append(scoped, e)
+		}
+		extant := eviction.CountRunning(scoped)
+		if extant < int(q.Limit) {
+			m.log(ctx, "%v quota not exceeded: running: %v, limit: %v", q, extant, q.Limit)
+			continue
+		}
+		kill := extant - int(q.Limit) + 1
+		var victims []eviction.Victim
+		var running int
+		if evict {
+			victims, running = eviction.Select(p, evictable(scoped, env), kill, time.Now().UTC())
+		}
+		if running < kill {
+			m.MC.Increment(mpfx+"quota_exceeded", "triggering_repo:"+env.Repo, "scope:"+string(q.Scope))
+			return nitroerrors.User(fmt.Errorf("environment quota exceeded for %v (running: %v, limit: %v)", q, extant, q.Limit))
+		}
+		m.log(ctx, "enforcing %v quota with %v eviction policy: running: %v, limit: %v, destroying: %v", q, p.Name(), extant, q.Limit, len(victims))
+		if err := m.evict(ctx, p, victims, models.EnvironmentQuotaExceeded); err != nil {
+			return err
+		}
+		for _, v := range victims {
+			destroyed[v.Env.Name] = struct{}{}
+		}
+	}
+	return nil
+}
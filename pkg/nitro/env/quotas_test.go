@@ -0,0 +1,175 @@
+package env
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/dollarshaveclub/acyl/pkg/config"
+	"github.com/dollarshaveclub/acyl/pkg/eventlogger"
+	"github.com/dollarshaveclub/acyl/pkg/ghclient"
+	"github.com/dollarshaveclub/acyl/pkg/locker"
+	"github.com/dollarshaveclub/acyl/pkg/models"
+	nitroerrors "github.com/dollarshaveclub/acyl/pkg/nitro/errors"
+	"github.com/dollarshaveclub/acyl/pkg/nitro/meta"
+	"github.com/dollarshaveclub/acyl/pkg/nitro/metahelm"
+	"github.com/dollarshaveclub/acyl/pkg/nitro/metrics"
+	"github.com/dollarshaveclub/acyl/pkg/persistence"
+)
+
+func TestEnvironmentQuotasQuotas(t *testing.T) {
+	sc := config.ServerConfig{}
+	if err := sc.ProcessEnvironmentQuotas(`{"repos": {"acme/busy": 10, "acme/unlimited": 0}, "orgs": {"other": 5}, "users": {"alice": 1}, "default_repo": 3, "default_user": 2}`); err != nil {
+		t.Fatalf("should have succeeded: %v", err)
+	}
+	tests := []struct {
+		repo, user string
+		want       []string
+	}{
+		{"acme/busy", "bob", []string{"repo acme/busy: 10", "user bob: 2"}},
+		{"acme/unlimited", "alice", []string{"user alice: 1"}},
+		{"other/thing", "", []string{"repo other/thing: 3", "org other: 5"}},
+	}
+	for _, tt := range tests {
+		qs := sc.EnvironmentQuotas.Quotas(tt.repo, tt.user)
+		got := []string{}
+		for _, q := range qs {
+			got = append(got, fmt.Sprintf("%v: %v", q, q.Limit))
+		}
+		if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Fatalf("bad quotas for %v/%v: %v (wanted %v)", tt.repo, tt.user, got, tt.want)
+		}
+	}
+	for _, bad := range []string{`{"repos": {"acme": 1}}`, `{"orgs": {"acme/foo": 1}}`, `{"repos": []}`} {
+		if err := sc.ProcessEnvironmentQuotas(bad); err == nil {
+			t.Fatalf("should have failed: %v", bad)
+		}
+	}
+}
+
+func TestEnforceQuotas(t *testing.T) {
+	tests := []struct {
+		name             string
+		quotas           config.EnvironmentQuotas
+		evict            bool
+		pinned           []string
+		isErr, isUserErr bool
+		destroyed        []string
+	}{
+		{
+			name:   "not exceeded",
+			quotas: config.EnvironmentQuotas{Repos: map[string]uint{"foo/bar": 3}},
+		},
+		{
+			name:      "repo rejected",
+			quotas:    config.EnvironmentQuotas{Repos: map[string]uint{"foo/bar": 2}},
+			isErr:     true,
+			isUserErr: true,
+		},
+		{
+			name:      "repo evicted",
+			quotas:    config.EnvironmentQuotas{Repos: map[string]uint{"foo/bar": 2}},
+			evict:     true,
+			destroyed: []string{"foo-bar-0"},
+		},
+		{
+			name:      "org evicted",
+			quotas:    config.EnvironmentQuotas{DefaultOrg: 2},
+			evict:     true,
+			destroyed: []string{"foo-bar-0", "foo-bar-1"},
+		},
+		{
+			name:      "user evicted",
+			quotas:    config.EnvironmentQuotas{Users: map[string]uint{"alice": 1}},
+			evict:     true,
+			destroyed: []string{"foo-other-0"},
+		},
+		{
+			name:      "pinned exempt",
+			quotas:    config.EnvironmentQuotas{Repos: map[string]uint{"foo/bar": 2}},
+			evict:     true,
+			pinned:    []string{"foo-bar-0"},
+			destroyed: []string{"foo-bar-1"},
+		},
+		{
+			name:      "all pinned",
+			quotas:    config.EnvironmentQuotas{Repos: map[string]uint{"foo/bar": 1}},
+			evict:     true,
+			pinned:    []string{"foo-bar-0", "foo-bar-1"},
+			isErr:     true,
+			isUserErr: true,
+		},
+		{
+			name:   "other user",
+			quotas: config.EnvironmentQuotas{Users: map[string]uint{"bob": 1}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dl := persistence.NewFakeDataLayer()
+			now := time.Now().UTC()
+			running := []models.QAEnvironment{
+				{Name: "foo-bar-0", Repo: "foo/bar", PullRequest: 1, User: "bob", Created: now.Add(-3 * time.Hour)},
+				{Name: "foo-bar-1", Repo: "foo/bar", PullRequest: 2, User: "bob", Created: now.Add(-2 * time.Hour)},
+				{Name: "foo-other-0", Repo: "foo/other", PullRequest: 1, User: "alice", Created: now.Add(-1 * time.Hour)},
+			}
+			pinned := now.Add(time.Hour)
+			for i := range running {
+				running[i].Status = models.Success
+				for _, name := range tt.pinned {
+					if running[i].Name == name {
+						running[i].PinnedUntil = &pinned
+					}
+				}
+				dl.CreateQAEnvironment(context.Background(), &running[i])
+				dl.CreateK8sEnv(context.Background(), &models.KubernetesEnvironment{EnvName: running[i].Name, Namespace: "nitro-" + running[i].Name})
+			}
+			newenv := &models.QAEnvironment{Name: "new-env", Repo: "foo/bar", PullRequest: 3, User: "alice", Status: models.Spawned, Created: now}
+			dl.CreateQAEnvironment(context.Background(), newenv)
+			plf, err := locker.NewFakePreemptiveLockerFactory(
+				[]locker.LockProviderOption{locker.WithLockTimeout(time.Second)},
+				locker.WithLockDelay(time.Millisecond),
+			)
+			if err != nil {
+				t.Fatalf("error creating new preemptive locker factory: %v", err)
+			}
+			m := Manager{
+				DL:  dl,
+				PLF: plf,
+				NF:  testNF,
+				MC:  &metrics.FakeCollector{},
+				MG: &meta.FakeGetter{GetFunc: func(ctx context.Context, rd models.RepoRevisionData) (*models.RepoConfig, error) {
+					return &models.RepoConfig{}, nil
+				}},
+				RC:     &ghclient.FakeRepoClient{GetCommitMessageFunc: func(context.Context, string, string) (string, error) { return "", nil }},
+				CI:     &metahelm.FakeInstaller{DL: dl},
+				Quotas: tt.quotas,
+			}
+			el := &eventlogger.Logger{DL: dl}
+			el.Init([]byte{}, newenv.Repo, newenv.PullRequest)
+			ctx := eventlogger.NewEventLoggerContext(context.Background(), el)
+			err = m.enforceQuotas(ctx, newenv, tt.evict)
+			if err != nil {
+				if !tt.isErr {
+					t.Fatalf("should have succeeded: %v", err)
+				}
+				if tt.isUserErr != nitroerrors.IsUserError(err) {
+					t.Fatalf("unexpected user error value: %v: %v", tt.isUserErr, err)
+				}
+			} else if tt.isErr {
+				t.Fatalf("should have failed")
+			}
+			destroyed := map[string]bool{}
+			for _, name := range tt.destroyed {
+				destroyed[name] = true
+			}
+			for _, e := range running {
+				e2, _ := dl.GetQAEnvironment(context.Background(), e.Name)
+				if (e2.Status == models.Destroyed) != destroyed[e.Name] {
+					t.Fatalf("bad status for %v: %v", e.Name, e2.Status)
+				}
+			}
+		})
+	}
+}
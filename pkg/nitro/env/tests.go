@@ -0,0 +1,125 @@
+package env
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/dollarshaveclub/acyl/pkg/eventlogger"
+	"github.com/dollarshaveclub/acyl/pkg/ghapp"
+	"github.com/dollarshaveclub/acyl/pkg/ghclient"
+	"github.com/dollarshaveclub/acyl/pkg/models"
+	"github.com/dollarshaveclub/acyl/pkg/nitro/metahelm"
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/tracer"
+)
+
+// maxCommitStatusDescriptionLen is the maximum length (in characters) of a commit status description allowed by GitHub
+const maxCommitStatusDescriptionLen = 140
+
+// testsStatusGrace is added to the tests timeout to allow for reporting the tests commit status
+const testsStatusGrace = 1 * time.Minute
+
+// testsKey returns the key identifying the environment for rd in the background tests registry (the same environment as the operation lock)
+func testsKey(rd models.RepoRevisionData) string {
+	repo, pr := lockKey(rd)
+	return fmt.Sprintf("%v#%v", repo, pr)
+}
+
+// testRun is a background tests run that can be cancelled by a newer operation on the same environment
+type testRun struct {
+	cf context.CancelFunc
+}
+
+// cancelTests cancels the background tests running for the environment of rd (if any), so that a stale run doesn't report a commit status
+// for a superseded revision or run against a namespace that is being modified or torn down.
+func (m *Manager) cancelTests(rd models.RepoRevisionData) {
+	k := testsKey(rd)
+	m.testsmtx.Lock()
+	defer m.testsmtx.Unlock()
+	if tr, ok := m.testruns[k]; ok {
+		tr.cf()
+		delete(m.testruns, k)
+	}
+}
+
+// runTestsAsync runs the tests for env in the background so that they don't hold up the completion of the event (or the environment lock).
+// The tests use a context detached from ctx (which is cancelled when the operation returns) that is bounded by the tests timeout
+// and is cancelled by the next operation on the environment.
+func (m *Manager) runTestsAsync(ctx context.Context, rd *models.RepoRevisionData, env *newEnv) {
+	if env == nil || env.rc == nil || !env.rc.Tests.Enabled() {
+		return
+	}
+	tctx := eventlogger.NewEventLoggerContext(context.Background(), eventlogger.GetLogger(ctx))
+	tctx = ghapp.CloneGitHubClientContext(tctx, ctx)
+	tctx, cf := context.WithTimeout(tctx, env.rc.Tests.Timeout()+testsStatusGrace)
+	rdc := *rd
+	k := testsKey(rdc)
+	tr := &testRun{cf: cf}
+	m.testsmtx.Lock()
+	if prev, ok := m.testruns[k]; ok {
+		prev.cf()
+	}
+	if m.testruns == nil {
+		m.testruns = map[string]*testRun{}
+	}
+	m.testruns[k] = tr
+	m.testsmtx.Unlock()
+	m.testswg.Add(1)
+	go func() {
+		defer m.testswg.Done()
+		defer func() {
+			m.testsmtx.Lock()
+			if m.testruns[k] == tr {
+				delete(m.testruns, k)
+			}
+			m.testsmtx.Unlock()
+			cf()
+		}()
+		m.runTests(tctx, &rdc, env)
+	}()
+}
+
+// runTests runs the post-install tests declared in the environment config (if any) and reports the outcome as a separate commit status.
+// Test failures don't affect the environment status.
+func (m *Manager) runTests(ctx context.Context, rd *models.RepoRevisionData, env *newEnv) {
+	if env == nil || env.rc == nil || !env.rc.Tests.Enabled() {
+		return
+	}
+	span, ctx := tracer.StartSpanFromContext(ctx, "tests")
+	m.log(ctx, "running tests")
+	m.setGithubTestsStatus(ctx, rd, env, models.CommitStatusPending, "Running tests")
+	err := m.CI.RunTests(ctx, &metahelm.EnvInfo{Env: env.env, RC: env.rc})
+	span.Finish(tracer.WithError(err))
+	if ctx.Err() == context.Canceled {
+		m.log(ctx, "tests cancelled by a newer operation on the environment")
+		return
+	}
+	if err != nil {
+		m.log(ctx, "tests failed: %v", err)
+		m.MC.Increment(mpfx+"test_failures", "triggering_repo:"+rd.Repo)
+		m.setGithubTestsStatus(ctx, rd, env, models.CommitStatusFailure, "Tests failed: "+err.Error())
+		return
+	}
+	m.log(ctx, "tests passed")
+	m.setGithubTestsStatus(ctx, rd, env, models.CommitStatusSuccess, "All tests passed")
+}
+
+// setGithubTestsStatus sets the commit status for the tests context, linking to the event status page (which includes the test output)
+func (m *Manager) setGithubTestsStatus(ctx context.Context, rd *models.RepoRevisionData, env *newEnv, ncs models.CommitStatus, desc string) {
+	if rs := []rune(desc); len(rs) > maxCommitStatusDescriptionLen {
+		desc = string(rs[:maxCommitStatusDescriptionLen-3]) + "..."
+	}
+	cs := &ghclient.CommitStatus{
+		Context:     env.rc.Tests.StatusContext(),
+		Status:      ncs.Key(),
+		Description: desc,
+	}
+	if m.UIBaseURL != "" {
+		cs.TargetURL = fmt.Sprintf("%v/ui/event/status?id=%v", m.UIBaseURL, eventlogger.GetLogger(ctx).ID.String())
+	}
+	ctx2 := eventlogger.NewEventLoggerContext(context.Background(), eventlogger.GetLogger(ctx))
+	ctx2 = ghapp.CloneGitHubClientContext(ctx2, ctx)
+	if err := m.RC.SetStatus(ctx2, rd.Repo, rd.SourceSHA, cs); err != nil {
+		m.log(ctx, "error setting github tests commit status: %v", err)
+	}
+}
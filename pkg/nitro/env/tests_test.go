@@ -0,0 +1,178 @@
+package env
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/dollarshaveclub/acyl/pkg/eventlogger"
+	"github.com/dollarshaveclub/acyl/pkg/ghclient"
+	"github.com/dollarshaveclub/acyl/pkg/models"
+	"github.com/dollarshaveclub/acyl/pkg/nitro/metahelm"
+	"github.com/dollarshaveclub/acyl/pkg/nitro/metrics"
+	"github.com/dollarshaveclub/acyl/pkg/persistence"
+	"github.com/google/uuid"
+)
+
+func TestRunTests(t *testing.T) {
+	dl := persistence.NewFakeDataLayer()
+	el := &eventlogger.Logger{DL: dl, ID: uuid.Must(uuid.NewRandom())}
+	el.Init([]byte{}, "acme/something", 1)
+	ctx := eventlogger.NewEventLoggerContext(context.Background(), el)
+	rd := &models.RepoRevisionData{Repo: "acme/something", PullRequest: 1, SourceSHA: "asdf"}
+	tests := []struct {
+		name       string
+		tests      models.RepoConfigTests
+		testsErr   error
+		wantStatus []string
+		wantDesc   string
+		wantCtx    string
+	}{
+		{
+			name: "no tests",
+		},
+		{
+			name:       "passed",
+			tests:      models.RepoConfigTests{Jobs: []models.TestJob{models.TestJob{Name: "integration", Image: "acme/tests"}}},
+			wantStatus: []string{"pending", "success"},
+			wantDesc:   "All tests passed",
+			wantCtx:    models.DefaultTestsContext,
+		},
+		{
+			name:       "failed",
+			tests:      models.RepoConfigTests{Context: "integration", HelmTests: []string{"acme-something"}},
+			testsErr:   fmt.Errorf("tests failed: %v", strings.Repeat("x", 200)),
+			wantStatus: []string{"pending", "failure"},
+			wantDesc:   "Tests failed: tests failed: xxx",
+			wantCtx:    "integration",
+		},
+		{
+			name:       "failed multibyte",
+			tests:      models.RepoConfigTests{HelmTests: []string{"acme-something"}},
+			testsErr:   fmt.Errorf("tests failed: %v", strings.Repeat("✗", 200)),
+			wantStatus: []string{"pending", "failure"},
+			wantDesc:   "Tests failed: tests failed: ✗✗✗",
+			wantCtx:    models.DefaultTestsContext,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var statuses []ghclient.CommitStatus
+			m := &Manager{
+				DL: dl,
+				MC: &metrics.FakeCollector{},
+				RC: &ghclient.FakeRepoClient{
+					SetStatusFunc: func(ctx context.Context, repo, sha string, cs *ghclient.CommitStatus) error {
+						if repo != rd.Repo || sha != rd.SourceSHA {
+							return fmt.Errorf("bad repo or sha: %v@%v", repo, sha)
+						}
+						statuses = append(statuses, *cs)
+						return nil
+					},
+				},
+				CI: &metahelm.FakeInstaller{
+					TestsFunc: func(models.RepoConfigTests) error { return tt.testsErr },
+				},
+				UIBaseURL: "https://foobar.com",
+			}
+			env := &newEnv{env: &models.QAEnvironment{Name: "foo-bar"}, rc: &models.RepoConfig{Tests: tt.tests}}
+			m.runTests(ctx, rd, env)
+			if len(statuses) != len(tt.wantStatus) {
+				t.Fatalf("bad status count: %v (wanted %v)", len(statuses), len(tt.wantStatus))
+			}
+			for i, cs := range statuses {
+				if cs.Status != tt.wantStatus[i] || cs.Context != tt.wantCtx {
+					t.Errorf("bad status at offset %v: %+v", i, cs)
+				}
+				if n := utf8.RuneCountInString(cs.Description); n > maxCommitStatusDescriptionLen || !utf8.ValidString(cs.Description) {
+					t.Errorf("description too long or invalid: %v: %v", n, cs.Description)
+				}
+				if cs.TargetURL != fmt.Sprintf("https://foobar.com/ui/event/status?id=%v", el.ID) {
+					t.Errorf("bad target url: %v", cs.TargetURL)
+				}
+			}
+			if n := len(statuses); n > 0 && !strings.HasPrefix(statuses[n-1].Description, tt.wantDesc) {
+				t.Errorf("bad description: %v", statuses[n-1].Description)
+			}
+		})
+	}
+}
+
+func TestRunTestsAsync(t *testing.T) {
+	dl := persistence.NewFakeDataLayer()
+	el := &eventlogger.Logger{DL: dl, ID: uuid.Must(uuid.NewRandom())}
+	el.Init([]byte{}, "acme/something", 1)
+	// the operation context is cancelled as soon as the operation returns, which must not cancel the tests
+	ctx, cf := context.WithCancel(eventlogger.NewEventLoggerContext(context.Background(), el))
+	rd := &models.RepoRevisionData{Repo: "acme/something", PullRequest: 1, SourceSHA: "asdf"}
+	started, finish := make(chan struct{}), make(chan struct{})
+	var statuses []string
+	m := &Manager{
+		DL: dl,
+		MC: &metrics.FakeCollector{},
+		RC: &ghclient.FakeRepoClient{
+			SetStatusFunc: func(ctx context.Context, repo, sha string, cs *ghclient.CommitStatus) error {
+				statuses = append(statuses, cs.Status)
+				return nil
+			},
+		},
+		CI: &metahelm.FakeInstaller{
+			TestsFunc: func(models.RepoConfigTests) error {
+				close(started)
+				<-finish
+				return nil
+			},
+		},
+	}
+	env := &newEnv{env: &models.QAEnvironment{Name: "foo-bar"}, rc: &models.RepoConfig{Tests: models.RepoConfigTests{HelmTests: []string{"acme-something"}}}}
+	m.runTestsAsync(ctx, rd, env)
+	<-started
+	cf()
+	close(finish)
+	m.testswg.Wait()
+	if len(statuses) != 2 || statuses[1] != "success" {
+		t.Fatalf("bad statuses: %v", statuses)
+	}
+}
+
+func TestRunTestsAsyncCancelled(t *testing.T) {
+	dl := persistence.NewFakeDataLayer()
+	el := &eventlogger.Logger{DL: dl, ID: uuid.Must(uuid.NewRandom())}
+	el.Init([]byte{}, "acme/something", 1)
+	ctx := eventlogger.NewEventLoggerContext(context.Background(), el)
+	rd := &models.RepoRevisionData{Repo: "acme/something", PullRequest: 1, SourceSHA: "asdf"}
+	started, finish := make(chan struct{}), make(chan struct{})
+	var statuses []string
+	m := &Manager{
+		DL: dl,
+		MC: &metrics.FakeCollector{},
+		RC: &ghclient.FakeRepoClient{
+			SetStatusFunc: func(ctx context.Context, repo, sha string, cs *ghclient.CommitStatus) error {
+				statuses = append(statuses, cs.Status)
+				return nil
+			},
+		},
+		CI: &metahelm.FakeInstaller{
+			TestsFunc: func(models.RepoConfigTests) error {
+				close(started)
+				<-finish
+				return fmt.Errorf("namespace is gone")
+			},
+		},
+	}
+	env := &newEnv{env: &models.QAEnvironment{Name: "foo-bar"}, rc: &models.RepoConfig{Tests: models.RepoConfigTests{HelmTests: []string{"acme-something"}}}}
+	m.runTestsAsync(ctx, rd, env)
+	<-started
+	// a newer operation on the same environment (ex: a new commit) cancels the running tests
+	m.cancelTests(models.RepoRevisionData{Repo: "acme/something", PullRequest: 1, SourceSHA: "zxcv"})
+	close(finish)
+	m.testswg.Wait()
+	if len(statuses) != 1 || statuses[0] != "pending" {
+		t.Fatalf("cancelled tests should not report a result: %v", statuses)
+	}
+	if len(m.testruns) != 0 {
+		t.Fatalf("test run should have been removed from the registry: %v", m.testruns)
+	}
+}
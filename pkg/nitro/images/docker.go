@@ -16,6 +16,8 @@ import (
 	"github.com/dollarshaveclub/acyl/pkg/ghclient"
 
 	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/registry"
+	"github.com/docker/docker/client"
 	"github.com/docker/docker/pkg/jsonmessage"
 	"github.com/dollarshaveclub/acyl/pkg/persistence"
 	"github.com/mholt/archiver"
@@ -25,6 +27,8 @@ import (
 type DockerClient interface {
 	ImageBuild(ctx context.Context, buildContext io.Reader, options types.ImageBuildOptions) (types.ImageBuildResponse, error)
 	ImagePush(ctx context.Context, image string, options types.ImagePushOptions) (io.ReadCloser, error)
+	ImageInspectWithRaw(ctx context.Context, imageID string) (types.ImageInspect, []byte, error)
+	DistributionInspect(ctx context.Context, image, encodedRegistryAuth string) (registry.DistributionInspect, error)
 }
 
 // DockerBuilderBackend builds images using a Docker Engine
@@ -42,6 +46,64 @@ func (dbb *DockerBuilderBackend) log(ctx context.Context, msg string, args ...in
 	eventlogger.GetLogger(ctx).Printf("docker builder: "+msg, args...)
 }
 
+// registryAuth returns the encoded registry auth for imageRepo
+func (dbb *DockerBuilderBackend) registryAuth(imageRepo string) (string, error) {
+	rsl := strings.Split(imageRepo, "/")
+	var registryURLs []string
+	switch len(rsl) {
+	case 2: // Docker Hub
+		registryURLs = []string{"https://index.docker.io/v1/", "https://index.docker.io/v2/"}
+	case 3: // private registry
+		registryURLs = []string{"https://" + rsl[0]}
+	default:
+		return "", fmt.Errorf("cannot determine base registry URL from %v", imageRepo)
+	}
+	var auth string
+	for _, url := range registryURLs {
+		val, ok := dbb.Auths[url]
+		if ok {
+			j, err := json.Marshal(&val)
+			if err != nil {
+				return "", fmt.Errorf("error marshaling auth: %v", err)
+			}
+			auth = base64.StdEncoding.EncodeToString(j)
+		}
+	}
+	if auth == "" {
+		return "", fmt.Errorf("auth not found for %v", imageRepo)
+	}
+	return auth, nil
+}
+
+// ImageExists checks whether imageRepo:tag exists in the remote registry (if pushing is enabled) or the local Docker Engine
+func (dbb *DockerBuilderBackend) ImageExists(ctx context.Context, githubRepo, imageRepo, tag string) (bool, error) {
+	if dbb.DC == nil {
+		return false, errors.New("docker client is nil")
+	}
+	image := imageRepo + ":" + tag
+	if !dbb.Push {
+		_, _, err := dbb.DC.ImageInspectWithRaw(ctx, image)
+		if err != nil {
+			if client.IsErrNotFound(err) {
+				return false, nil
+			}
+			return false, fmt.Errorf("error inspecting image: %w", err)
+		}
+		return true, nil
+	}
+	auth, err := dbb.registryAuth(imageRepo)
+	if err != nil {
+		return false, err
+	}
+	if _, err := dbb.DC.DistributionInspect(ctx, image, auth); err != nil {
+		if client.IsErrNotFound(err) {
+			return false, nil
+		}
+		return false, fmt.Errorf("error inspecting image in registry: %w", err)
+	}
+	return true, nil
+}
+
 // BuildImage synchronously builds and optionally pushes the image using the Docker Engine, returning when the build completes.
 func (dbb *DockerBuilderBackend) BuildImage(ctx context.Context, envName, depName, githubRepo, imageRepo, ref string, ops BuildOptions) error {
 	if dbb.DC == nil {
@@ -151,29 +213,9 @@ func (dbb *DockerBuilderBackend) BuildImage(ctx context.Context, envName, depNam
 		return fmt.Errorf("error performing build: %w", err)
 	}
 	if dbb.Push {
-		rsl := strings.Split(imageRepo, "/")
-		var registryURLs []string
-		switch len(rsl) {
-		case 2: // Docker Hub
-			registryURLs = []string{"https://index.docker.io/v1/", "https://index.docker.io/v2/"}
-		case 3: // private registry
-			registryURLs = []string{"https://" + rsl[0]}
-		default:
-			return fmt.Errorf("cannot determine base registry URL from %v", imageRepo)
-		}
-		var auth string
-		for _, url := range registryURLs {
-			val, ok := dbb.Auths[url]
-			if ok {
-				j, err := json.Marshal(&val)
-				if err != nil {
-					return fmt.Errorf("error marshaling auth: %v", err)
-				}
-				auth = base64.StdEncoding.EncodeToString(j)
-			}
-		}
-		if auth == "" {
-			return fmt.Errorf("auth not found for %v", imageRepo)
+		auth, err := dbb.registryAuth(imageRepo)
+		if err != nil {
+			return err
 		}
 		opts := types.ImagePushOptions{
 			All:          true,
@@ -14,11 +14,14 @@ import (
 	"github.com/dollarshaveclub/acyl/pkg/persistence"
 
 	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/registry"
 )
 
 type fakeDockerClient struct {
-	ImageBuildFunc func(ctx context.Context, buildContext io.Reader, options types.ImageBuildOptions) (types.ImageBuildResponse, error)
-	ImagePushFunc  func(ctx context.Context, image string, options types.ImagePushOptions) (io.ReadCloser, error)
+	ImageBuildFunc          func(ctx context.Context, buildContext io.Reader, options types.ImageBuildOptions) (types.ImageBuildResponse, error)
+	ImagePushFunc           func(ctx context.Context, image string, options types.ImagePushOptions) (io.ReadCloser, error)
+	ImageInspectWithRawFunc func(ctx context.Context, imageID string) (types.ImageInspect, []byte, error)
+	DistributionInspectFunc func(ctx context.Context, image, encodedRegistryAuth string) (registry.DistributionInspect, error)
 }
 
 func (fdc *fakeDockerClient) ImageBuild(ctx context.Context, buildContext io.Reader, options types.ImageBuildOptions) (types.ImageBuildResponse, error) {
@@ -34,6 +37,76 @@ func (fdc *fakeDockerClient) ImagePush(ctx context.Context, image string, option
 	return ioutil.NopCloser(&bytes.Buffer{}), nil
 }
 
+func (fdc *fakeDockerClient) ImageInspectWithRaw(ctx context.Context, imageID string) (types.ImageInspect, []byte, error) {
+	if fdc.ImageInspectWithRawFunc != nil {
+		return fdc.ImageInspectWithRawFunc(ctx, imageID)
+	}
+	return types.ImageInspect{}, nil, nil
+}
+func (fdc *fakeDockerClient) DistributionInspect(ctx context.Context, image, encodedRegistryAuth string) (registry.DistributionInspect, error) {
+	if fdc.DistributionInspectFunc != nil {
+		return fdc.DistributionInspectFunc(ctx, image, encodedRegistryAuth)
+	}
+	return registry.DistributionInspect{}, nil
+}
+
+type notFoundError struct{}
+
+func (notFoundError) Error() string { return "not found" }
+func (notFoundError) NotFound()     {}
+
+func TestDockerBackendImageExists(t *testing.T) {
+	var local, remote bool
+	var localErr, remoteErr error
+	dbb := DockerBuilderBackend{
+		DC: &fakeDockerClient{
+			ImageInspectWithRawFunc: func(ctx context.Context, imageID string) (types.ImageInspect, []byte, error) {
+				local = true
+				if imageID != "quay.io/acme/widgets:asdf" {
+					return types.ImageInspect{}, nil, errors.New("bad image: " + imageID)
+				}
+				return types.ImageInspect{}, nil, localErr
+			},
+			DistributionInspectFunc: func(ctx context.Context, image, encodedRegistryAuth string) (registry.DistributionInspect, error) {
+				remote = true
+				if encodedRegistryAuth == "" {
+					return registry.DistributionInspect{}, errors.New("missing auth")
+				}
+				return registry.DistributionInspect{}, remoteErr
+			},
+		},
+		Auths: map[string]types.AuthConfig{
+			"https://quay.io": types.AuthConfig{},
+		},
+	}
+	exists, err := dbb.ImageExists(context.Background(), "acme/widgets", "quay.io/acme/widgets", "asdf")
+	if err != nil || !exists || !local || remote {
+		t.Fatalf("local image should exist: %v, %v (local: %v, remote: %v)", exists, err, local, remote)
+	}
+	localErr = notFoundError{}
+	exists, err = dbb.ImageExists(context.Background(), "acme/widgets", "quay.io/acme/widgets", "asdf")
+	if err != nil || exists {
+		t.Fatalf("local image should not exist: %v, %v", exists, err)
+	}
+	dbb.Push = true
+	exists, err = dbb.ImageExists(context.Background(), "acme/widgets", "quay.io/acme/widgets", "asdf")
+	if err != nil || !exists || !remote {
+		t.Fatalf("remote image should exist: %v, %v (remote: %v)", exists, err, remote)
+	}
+	remoteErr = notFoundError{}
+	exists, err = dbb.ImageExists(context.Background(), "acme/widgets", "quay.io/acme/widgets", "asdf")
+	if err != nil || exists {
+		t.Fatalf("remote image should not exist: %v, %v", exists, err)
+	}
+	remoteErr = errors.New("registry unavailable")
+	if _, err := dbb.ImageExists(context.Background(), "acme/widgets", "quay.io/acme/widgets", "asdf"); err == nil {
+		t.Fatalf("should have failed")
+	}
+	if _, err := dbb.ImageExists(context.Background(), "acme/widgets", "privateregistry.io/acme/widgets", "asdf"); err == nil {
+		t.Fatalf("should have failed with missing auth")
+	}
+}
+
 func TestDockerBackendBuild(t *testing.T) {
 	var tname string
 	createtf := func() {
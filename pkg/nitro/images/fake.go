@@ -62,3 +62,7 @@ var _ BuilderBackend = &NoneBackend{}
 func (nb *NoneBackend) BuildImage(ctx context.Context, envName, depName, githubRepo, imageRepo, ref string, ops BuildOptions) error {
 	return nil
 }
+
+func (nb *NoneBackend) ImageExists(ctx context.Context, githubRepo, imageRepo, tag string) (bool, error) {
+	return false, nil
+}
@@ -43,6 +43,27 @@ func NewFuran2BuilderBackend(addr, apikey string, ghappInstID int64, skipVerifyT
 	}, nil
 }
 
+// ImageExists returns whether Furan has previously built and pushed imageRepo:tag from githubRepo.
+// Tags pushed outside of Furan aren't found here but are still skipped by Furan at build time (SkipIfExists).
+func (fib *Furan2BuilderBackend) ImageExists(ctx context.Context, githubRepo, imageRepo, tag string) (bool, error) {
+	for _, state := range []furanrpc.BuildState{furanrpc.BuildState_SUCCESS, furanrpc.BuildState_SKIPPED} {
+		builds, err := fib.rb.ListBuilds(ctx, furanrpc.ListBuildsRequest{
+			WithGithubRepo: githubRepo,
+			WithGithubRef:  tag,
+			WithImageRepo:  imageRepo,
+			WithBuildState: state,
+			Limit:          1,
+		})
+		if err != nil {
+			return false, fmt.Errorf("error listing builds: %w", err)
+		}
+		if len(builds) > 0 {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
 // BuildImage synchronously builds the image using Furan, returning when the build completes.
 func (fib *Furan2BuilderBackend) BuildImage(ctx context.Context, envName, depName, githubRepo, imageRepo, ref string, ops BuildOptions) error {
 	logger := eventlogger.GetLogger(ctx)
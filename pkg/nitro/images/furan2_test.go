@@ -24,6 +24,7 @@ import (
 
 type FakeFuran2Server struct {
 	status *furanrpc.BuildStatusResponse
+	builds []*furanrpc.BuildStatusResponse
 }
 
 var _ furanrpc.FuranExecutorServer = &FakeFuran2Server{}
@@ -46,8 +47,14 @@ func (ffs *FakeFuran2Server) CancelBuild(context.Context, *furanrpc.BuildCancelR
 	return &furanrpc.BuildCancelResponse{}, nil
 }
 
-func (ffs *FakeFuran2Server) ListBuilds(context.Context, *furanrpc.ListBuildsRequest) (*furanrpc.ListBuildsResponse, error) {
-	return &furanrpc.ListBuildsResponse{}, nil
+func (ffs *FakeFuran2Server) ListBuilds(_ context.Context, req *furanrpc.ListBuildsRequest) (*furanrpc.ListBuildsResponse, error) {
+	out := &furanrpc.ListBuildsResponse{}
+	for _, b := range ffs.builds {
+		if b.State == req.WithBuildState && b.BuildRequest.GetBuild().GetGithubRepo() == req.WithGithubRepo && b.BuildRequest.GetBuild().GetRef() == req.WithGithubRef {
+			out.Builds = append(out.Builds, b)
+		}
+	}
+	return out, nil
 }
 
 func (ffs *FakeFuran2Server) GetBuildEvents(_ context.Context, req *furanrpc.BuildStatusRequest) (*furanrpc.BuildEventsResponse, error) {
@@ -133,3 +140,52 @@ func TestFuran2ImageBackendBuildImage(t *testing.T) {
 		t.Fatalf("failed: %v", err)
 	}
 }
+
+func TestFuran2ImageBackendImageExists(t *testing.T) {
+	cert, err := randomTLSCert()
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	s := grpc.NewServer(grpc.Creds(credentials.NewServerTLSFromCert(cert)))
+	furanrpc.RegisterFuranExecutorServer(s, &FakeFuran2Server{
+		builds: []*furanrpc.BuildStatusResponse{
+			&furanrpc.BuildStatusResponse{
+				State:        furanrpc.BuildState_SKIPPED,
+				BuildRequest: &furanrpc.BuildRequest{Build: &furanrpc.BuildDefinition{GithubRepo: "acme/foo", Ref: "asdf"}},
+			},
+			&furanrpc.BuildStatusResponse{
+				State:        furanrpc.BuildState_FAILURE,
+				BuildRequest: &furanrpc.BuildRequest{Build: &furanrpc.BuildDefinition{GithubRepo: "acme/foo", Ref: "1234"}},
+			},
+		},
+	})
+	go func() {
+		s.Serve(l)
+	}()
+	defer s.Stop()
+
+	fbb, err := NewFuran2BuilderBackend(l.Addr().String(), "asdf", 1, true, persistence.NewFakeDataLayer(), &ghclient.FakeRepoAppClient{}, &metrics.FakeCollector{})
+	if err != nil {
+		t.Fatalf("error creating backend: %v", err)
+	}
+
+	exists, err := fbb.ImageExists(context.Background(), "acme/foo", "quay.io/foo/bar", "asdf")
+	if err != nil {
+		t.Fatalf("should have succeeded: %v", err)
+	}
+	if !exists {
+		t.Fatalf("image should exist")
+	}
+	exists, err = fbb.ImageExists(context.Background(), "acme/foo", "quay.io/foo/bar", "1234")
+	if err != nil {
+		t.Fatalf("should have succeeded: %v", err)
+	}
+	if exists {
+		t.Fatalf("image should not exist for failed build")
+	}
+}
@@ -25,6 +25,8 @@ type BuildOptions struct {
 // BuilderBackend describes the object that actually does image builds
 type BuilderBackend interface {
 	BuildImage(ctx context.Context, envName, depName, githubRepo, imageRepo, ref string, ops BuildOptions) error
+	// ImageExists returns whether the image imageRepo:tag (built from githubRepo) already exists and doesn't need to be built
+	ImageExists(ctx context.Context, githubRepo, imageRepo, tag string) (bool, error)
 }
 
 // Builder describes an object that builds a set of container images
@@ -48,14 +50,24 @@ type lockingOutcomes struct {
 
 var _ Builder = &ImageBuilder{}
 
+// sharedBuild is an in-progress image build that may be awaited by multiple environments
+type sharedBuild struct {
+	done chan struct{}
+	err  error
+}
+
 // ImageBuilder is an object that builds images using imageBuilderBackend
 // it is intended to be a singleton instance shared among multiple concurrent environment
-// creation procedures. Consequently, build IDs contain the name of the environment to avoid collisions
+// creation procedures. Consequently, build IDs contain the name of the environment to avoid collisions.
+// Builds of the same image and tag are shared among environments so each is only built once at a time.
 type ImageBuilder struct {
 	Backend      BuilderBackend
 	BuildTimeout time.Duration
 	DL           persistence.DataLayer
 	MC           metrics.Collector
+
+	inflightmtx sync.Mutex
+	inflight    map[string]*sharedBuild
 }
 
 var DefaultBuildTimeout = 1 * time.Hour
@@ -96,6 +108,45 @@ func (b *BuildBatch) Stop() {
 	b.stopf()
 }
 
+// sharedBuildImage builds imageRepo:ref using build, or if a build of the same image is already in progress (for another environment),
+// waits for that build to finish and returns its result. If the in-progress build was aborted and ctx is still valid, the build is retried.
+func (b *ImageBuilder) sharedBuildImage(ctx context.Context, imageRepo, ref string, build func() error) error {
+	key := imageRepo + ":" + ref
+	for {
+		b.inflightmtx.Lock()
+		if b.inflight == nil {
+			b.inflight = make(map[string]*sharedBuild)
+		}
+		sb, ok := b.inflight[key]
+		if !ok {
+			sb = &sharedBuild{done: make(chan struct{})}
+			b.inflight[key] = sb
+			b.inflightmtx.Unlock()
+			sb.err = build()
+			b.inflightmtx.Lock()
+			delete(b.inflight, key)
+			b.inflightmtx.Unlock()
+			close(sb.done)
+			return sb.err
+		}
+		b.inflightmtx.Unlock()
+		eventlogger.GetLogger(ctx).Printf("image build for %v already in progress, waiting for it to complete", key)
+		select {
+		case <-ctx.Done():
+			return errors.Wrap(ctx.Err(), "context was cancelled waiting for in-progress build")
+		case <-sb.done:
+		}
+		if isContextErr(sb.err) && ctx.Err() == nil {
+			continue
+		}
+		return sb.err
+	}
+}
+
+func isContextErr(err error) bool {
+	return err != nil && (errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded))
+}
+
 // StartBuilds begins asynchronously building all container images according to rm, pushing to image repositories specified in rc.
 func (b *ImageBuilder) StartBuilds(ctx context.Context, envname string, rc *models.RepoConfig) (Batch, error) {
 	batch := &BuildBatch{outcomes: &lockingOutcomes{started: make(map[string]struct{}), completed: make(map[string]error)}}
@@ -110,17 +161,36 @@ func (b *ImageBuilder) StartBuilds(ctx context.Context, envname string, rc *mode
 		batch.outcomes.started[buildid(envname, name)] = struct{}{}
 		batch.outcomes.Unlock()
 
+		complete := func(err error) {
+			batch.outcomes.Lock()
+			batch.outcomes.completed[buildid(envname, name)] = nitroerrors.User(err)
+			batch.outcomes.Unlock()
+		}
+
+		exists, err := b.Backend.ImageExists(ctx, repo, image, ref)
+		if err != nil {
+			eventlogger.GetLogger(ctx).Printf("error checking if image exists (building anyway): %v:%v: %v", image, ref, err)
+		}
+		if exists {
+			eventlogger.GetLogger(ctx).Printf("image already exists, skipping build: %v:%v", image, ref)
+			eventlogger.GetLogger(ctx).SetImageCached(name)
+			b.MC.Increment("images.build_cached", "repo:"+repo, "triggering_repo:"+rc.Application.Repo)
+			complete(nil)
+			return
+		}
+
 		eventlogger.GetLogger(ctx).SetImageStarted(name)
 
-		end := b.MC.Timing("images.build", "repo:"+repo, "triggering_repo:"+rc.Application.Repo)
-		err := b.Backend.BuildImage(ctx, envname, name, repo, image, ref, BuildOptions{DockerfilePath: dockerfilepath, BuildArgs: buildargs})
-		end(fmt.Sprintf("success:%v", err == nil))
+		err = b.sharedBuildImage(ctx, image, ref, func() error {
+			end := b.MC.Timing("images.build", "repo:"+repo, "triggering_repo:"+rc.Application.Repo)
+			err := b.Backend.BuildImage(ctx, envname, name, repo, image, ref, BuildOptions{DockerfilePath: dockerfilepath, BuildArgs: buildargs})
+			end(fmt.Sprintf("success:%v", err == nil))
+			return err
+		})
 
 		eventlogger.GetLogger(ctx).SetImageCompleted(name, err != nil)
 
-		batch.outcomes.Lock()
-		batch.outcomes.completed[buildid(envname, name)] = nitroerrors.User(err)
-		batch.outcomes.Unlock()
+		complete(err)
 	}
 	cfs := []context.CancelFunc{}
 	ctx2, cf := context.WithTimeout(ctx, b.BuildTimeout)
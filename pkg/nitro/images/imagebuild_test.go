@@ -3,22 +3,34 @@ package images
 import (
 	"context"
 	"errors"
+	"os"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/dollarshaveclub/acyl/pkg/eventlogger"
 	"github.com/dollarshaveclub/acyl/pkg/models"
 	"github.com/dollarshaveclub/acyl/pkg/nitro/metrics"
 	"github.com/dollarshaveclub/acyl/pkg/persistence"
+	"github.com/google/uuid"
 )
 
 type testImageBuildBackend struct {
-	f func(ctx context.Context, envName, repo, imagerepo, ref string, ops BuildOptions) error
+	f       func(ctx context.Context, envName, repo, imagerepo, ref string, ops BuildOptions) error
+	existsf func(ctx context.Context, repo, imagerepo, tag string) (bool, error)
 }
 
 func (tib *testImageBuildBackend) BuildImage(ctx context.Context, envName, depName, githubRepo, imageRepo, ref string, ops BuildOptions) error {
 	return tib.f(ctx, envName, githubRepo, imageRepo, ref, ops)
 }
 
+func (tib *testImageBuildBackend) ImageExists(ctx context.Context, githubRepo, imageRepo, tag string) (bool, error) {
+	if tib.existsf != nil {
+		return tib.existsf(ctx, githubRepo, imageRepo, tag)
+	}
+	return false, nil
+}
+
 var _ BuilderBackend = &testImageBuildBackend{}
 
 func newTestBuilder(f func(ctx context.Context, envName, repo, imagerepo, ref string, ops BuildOptions) error) *ImageBuilder {
@@ -179,3 +191,109 @@ func TestImageBuilderStartBuildsDelay(t *testing.T) {
 		t.Fatalf("build should have succeeded: %v", err)
 	}
 }
+
+func TestImageBuilderStartBuildsCached(t *testing.T) {
+	var built bool
+	f := func(ctx context.Context, envName, repo, imagerepo, ref string, ops BuildOptions) error {
+		built = true
+		return nil
+	}
+	ib := newTestBuilder(f)
+	ib.Backend.(*testImageBuildBackend).existsf = func(ctx context.Context, repo, imagerepo, tag string) (bool, error) {
+		if imagerepo != "quay.io/foo/bar" || tag != "abcdef" {
+			return false, errors.New("bad image")
+		}
+		return true, nil
+	}
+	rc := &models.RepoConfig{
+		Application: models.RepoConfigAppMetadata{
+			Repo:   "foo/bar",
+			Ref:    "abcdef",
+			Branch: "master",
+			Image:  "quay.io/foo/bar",
+		},
+	}
+	elogger := &eventlogger.Logger{ID: uuid.Must(uuid.NewRandom()), DL: ib.DL, Sink: os.Stderr}
+	if err := elogger.Init([]byte{}, "foo/bar", 1); err != nil {
+		t.Fatalf("error initializing event logger: %v", err)
+	}
+	if err := ib.DL.SetEventStatus(elogger.ID, models.EventStatusSummary{
+		Tree: map[string]models.EventStatusTreeNode{"foo-bar": models.EventStatusTreeNode{}},
+	}); err != nil {
+		t.Fatalf("error setting event status: %v", err)
+	}
+	envname := "this-is-a-name"
+	b, err := ib.StartBuilds(eventlogger.NewEventLoggerContext(context.Background(), elogger), envname, rc)
+	if err != nil {
+		t.Fatalf("should have succeeded: %v", err)
+	}
+	defer b.Stop()
+	time.Sleep(5 * time.Millisecond)
+	done, err := b.Completed(envname, "foo-bar")
+	if !done {
+		t.Fatalf("should be done")
+	}
+	if err != nil {
+		t.Fatalf("build should have succeeded: %v", err)
+	}
+	if built {
+		t.Fatalf("image should not have been built")
+	}
+	es, err := ib.DL.GetEventStatus(elogger.ID)
+	if err != nil {
+		t.Fatalf("error getting event status: %v", err)
+	}
+	if !es.Tree["foo-bar"].Image.Cached {
+		t.Fatalf("image should have been marked as cached")
+	}
+}
+
+func TestImageBuilderStartBuildsShared(t *testing.T) {
+	var mtx sync.Mutex
+	var builds int
+	bc := make(chan struct{})
+	f := func(ctx context.Context, envName, repo, imagerepo, ref string, ops BuildOptions) error {
+		mtx.Lock()
+		builds++
+		mtx.Unlock()
+		<-bc
+		return nil
+	}
+	ib := newTestBuilder(f)
+	rc := &models.RepoConfig{
+		Application: models.RepoConfigAppMetadata{
+			Repo:   "foo/bar",
+			Ref:    "abcdef",
+			Branch: "master",
+			Image:  "quay.io/foo/bar",
+		},
+	}
+	b1, err := ib.StartBuilds(context.Background(), "env-1", rc)
+	if err != nil {
+		t.Fatalf("should have succeeded: %v", err)
+	}
+	defer b1.Stop()
+	b2, err := ib.StartBuilds(context.Background(), "env-2", rc)
+	if err != nil {
+		t.Fatalf("should have succeeded: %v", err)
+	}
+	defer b2.Stop()
+	time.Sleep(5 * time.Millisecond)
+	if done, _ := b2.Completed("env-2", "foo-bar"); done {
+		t.Fatalf("env-2 should be waiting on the shared build")
+	}
+	close(bc)
+	time.Sleep(5 * time.Millisecond)
+	for env, b := range map[string]Batch{"env-1": b1, "env-2": b2} {
+		done, err := b.Completed(env, "foo-bar")
+		if !done {
+			t.Fatalf("%v should be done", env)
+		}
+		if err != nil {
+			t.Fatalf("%v build should have succeeded: %v", env, err)
+		}
+	}
+	if builds != 1 {
+		t.Fatalf("expected exactly one build: %v", builds)
+	}
+}
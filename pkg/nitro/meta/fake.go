@@ -8,7 +8,7 @@ import (
 
 type FakeGetter struct {
 	GetFunc         func(ctx context.Context, rd models.RepoRevisionData) (*models.RepoConfig, error)
-	FetchChartsFunc func(ctx context.Context, rc *models.RepoConfig, basePath string) (ChartLocations, error)
+	FetchChartsFunc func(ctx context.Context, rc *models.RepoConfig, basePath string, untrusted bool) (ChartLocations, error)
 	GetAcylYAMLFunc func(ctx context.Context, rc *models.RepoConfig, repo, ref string) (err error)
 }
 
@@ -21,9 +21,9 @@ func (fg *FakeGetter) Get(ctx context.Context, rd models.RepoRevisionData) (*mod
 	return &models.RepoConfig{}, nil
 }
 
-func (fg *FakeGetter) FetchCharts(ctx context.Context, rc *models.RepoConfig, basePath string) (ChartLocations, error) {
+func (fg *FakeGetter) FetchCharts(ctx context.Context, rc *models.RepoConfig, basePath string, untrusted bool) (ChartLocations, error) {
 	if fg.FetchChartsFunc != nil {
-		return fg.FetchChartsFunc(ctx, rc, basePath)
+		return fg.FetchChartsFunc(ctx, rc, basePath, untrusted)
 	}
 	return ChartLocations{}, nil
 }
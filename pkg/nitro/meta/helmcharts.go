@@ -0,0 +1,320 @@
+package meta
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dollarshaveclub/acyl/pkg/models"
+	nitroerrors "github.com/dollarshaveclub/acyl/pkg/nitro/errors"
+	"github.com/ghodss/yaml"
+	"github.com/pkg/errors"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/registry"
+	"helm.sh/helm/v3/pkg/repo"
+)
+
+// HelmChartFetcher describes an object that fetches packaged charts from Helm chart repositories and OCI registries
+// untrusted indicates that the source is from the acyl.yml of an untrusted environment (a PR from a fork).
+type HelmChartFetcher interface {
+	// FetchRepoChart returns the chart archive (.tgz) and version of the newest chart in the repository matching the source version constraint
+	FetchRepoChart(ctx context.Context, src models.ChartRepoSource, untrusted bool) ([]byte, string, error)
+	// FetchOCIChart returns the chart archive (.tgz) and version of the newest chart in the registry matching the source version constraint
+	FetchOCIChart(ctx context.Context, src models.ChartOCISource, untrusted bool) ([]byte, string, error)
+}
+
+var _ HelmChartFetcher = &CachingHelmChartFetcher{}
+
+const (
+	// DefaultChartIndexTTL is how long chart repository indices and registry tag lists are cached
+	DefaultChartIndexTTL = 5 * time.Minute
+	// maxCachedCharts is the maximum number of chart archives that are cached
+	maxCachedCharts = 100
+	// maxChartSize is the maximum size of a chart archive or repository index
+	maxChartSize = 20 * 1024 * 1024
+)
+
+type cachedIndex struct {
+	index   *repo.IndexFile
+	tags    []string
+	fetched time.Time
+}
+
+// CachingHelmChartFetcher fetches charts from Helm chart repositories (via HTTP) and OCI registries, caching repository indices/tags for IndexTTL
+// and chart archives by version (which are immutable) in memory
+type CachingHelmChartFetcher struct {
+	HTTPClient *http.Client
+	IndexTTL   time.Duration
+	// AllowedHosts are the hostnames (or wildcard domains like "*.example.com") of the chart repositories and OCI registries that charts may be fetched from,
+	// including the chart URLs in repository indices and redirects. If empty, charts may be fetched from any host for trusted environments and none for untrusted environments.
+	AllowedHosts []string
+	mtx          sync.Mutex
+	indices      map[string]cachedIndex
+	charts       map[string][]byte
+	rcl          *registry.Client
+}
+
+// NewCachingHelmChartFetcher returns a CachingHelmChartFetcher with default settings that fetches charts from allowedHosts (see AllowedHosts)
+func NewCachingHelmChartFetcher(allowedHosts []string) *CachingHelmChartFetcher {
+	return &CachingHelmChartFetcher{
+		HTTPClient:   &http.Client{Timeout: 1 * time.Minute},
+		IndexTTL:     DefaultChartIndexTTL,
+		AllowedHosts: allowedHosts,
+	}
+}
+
+// allowed returns a user error if charts may not be fetched from the host of rawurl (see AllowedHosts)
+func (hcf *CachingHelmChartFetcher) allowed(rawurl string, untrusted bool) error {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nitroerrors.User(fmt.Errorf("malformed chart url: %w", err))
+	}
+	host := strings.ToLower(u.Hostname())
+	if host == "" {
+		return nitroerrors.User(fmt.Errorf("chart url has no host: %v", rawurl))
+	}
+	if len(hcf.AllowedHosts) == 0 {
+		if untrusted {
+			return nitroerrors.User(fmt.Errorf("charts from chart repositories and OCI registries are not allowed for untrusted environments: %v", host))
+		}
+		return nil
+	}
+	for _, ah := range hcf.AllowedHosts {
+		ah = strings.ToLower(ah)
+		if host == ah || (strings.HasPrefix(ah, "*.") && strings.HasSuffix(host, ah[1:])) {
+			return nil
+		}
+	}
+	return nitroerrors.User(fmt.Errorf("chart host is not allowed by the server: %v", host))
+}
+
+func (hcf *CachingHelmChartFetcher) getIndex(key string) (cachedIndex, bool) {
+	hcf.mtx.Lock()
+	defer hcf.mtx.Unlock()
+	ci, ok := hcf.indices[key]
+	if !ok || time.Since(ci.fetched) > hcf.IndexTTL {
+		return cachedIndex{}, false
+	}
+	return ci, true
+}
+
+func (hcf *CachingHelmChartFetcher) putIndex(key string, ci cachedIndex) {
+	hcf.mtx.Lock()
+	defer hcf.mtx.Unlock()
+	if hcf.indices == nil {
+		hcf.indices = make(map[string]cachedIndex)
+	}
+	ci.fetched = time.Now().UTC()
+	hcf.indices[key] = ci
+}
+
+func (hcf *CachingHelmChartFetcher) getChart(key string) ([]byte, bool) {
+	hcf.mtx.Lock()
+	defer hcf.mtx.Unlock()
+	b, ok := hcf.charts[key]
+	return b, ok
+}
+
+func (hcf *CachingHelmChartFetcher) putChart(key string, b []byte) {
+	hcf.mtx.Lock()
+	defer hcf.mtx.Unlock()
+	if hcf.charts == nil {
+		hcf.charts = make(map[string][]byte)
+	}
+	// evict an arbitrary chart if the cache is full
+	if len(hcf.charts) >= maxCachedCharts {
+		for k := range hcf.charts {
+			delete(hcf.charts, k)
+			break
+		}
+	}
+	hcf.charts[key] = b
+}
+
+func (hcf *CachingHelmChartFetcher) get(ctx context.Context, url string, untrusted bool) ([]byte, error) {
+	if err := hcf.allowed(url, untrusted); err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error creating request: %w", err)
+	}
+	hc := http.Client{}
+	if hcf.HTTPClient != nil {
+		hc = *hcf.HTTPClient
+	}
+	// redirects must be to allowed hosts as well
+	hc.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		if len(via) >= 10 {
+			return errors.New("stopped after 10 redirects")
+		}
+		return hcf.allowed(req.URL.String(), untrusted)
+	}
+	resp, err := hc.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error performing request: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code: %v: %v", url, resp.StatusCode)
+	}
+	b, err := io.ReadAll(io.LimitReader(resp.Body, maxChartSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("error reading response body: %w", err)
+	}
+	if len(b) > maxChartSize {
+		return nil, fmt.Errorf("response exceeds maximum size: %v", url)
+	}
+	return b, nil
+}
+
+// FetchRepoChart fetches a chart from a Helm chart repository
+func (hcf *CachingHelmChartFetcher) FetchRepoChart(ctx context.Context, src models.ChartRepoSource, untrusted bool) ([]byte, string, error) {
+	base := strings.TrimSuffix(src.URL, "/")
+	if err := hcf.allowed(base, untrusted); err != nil {
+		return nil, "", err
+	}
+	ci, ok := hcf.getIndex(base)
+	if !ok {
+		b, err := hcf.get(ctx, base+"/index.yaml", untrusted)
+		if err != nil {
+			return nil, "", fmt.Errorf("error getting repository index: %w", err)
+		}
+		idx := repo.NewIndexFile()
+		if err := yaml.Unmarshal(b, idx); err != nil {
+			return nil, "", fmt.Errorf("error unmarshaling repository index: %w", err)
+		}
+		idx.SortEntries()
+		ci = cachedIndex{index: idx}
+		hcf.putIndex(base, ci)
+	}
+	cv, err := ci.index.Get(src.Chart, src.Version)
+	if err != nil {
+		return nil, "", nitroerrors.User(fmt.Errorf("no chart version matching %v found for chart %v in %v: %w", src.Version, src.Chart, base, err))
+	}
+	if len(cv.URLs) == 0 {
+		return nil, "", fmt.Errorf("chart version has no URLs: %v-%v", cv.Name, cv.Version)
+	}
+	// the index may point to charts on any host
+	curl, err := repo.ResolveReferenceURL(base, cv.URLs[0])
+	if err != nil {
+		return nil, "", fmt.Errorf("error resolving chart URL: %w", err)
+	}
+	if err := hcf.allowed(curl, untrusted); err != nil {
+		return nil, "", err
+	}
+	key := base + "/" + cv.Name + "@" + cv.Version
+	if b, ok := hcf.getChart(key); ok {
+		return b, cv.Version, nil
+	}
+	b, err := hcf.get(ctx, curl, untrusted)
+	if err != nil {
+		return nil, "", fmt.Errorf("error getting chart archive: %w", err)
+	}
+	hcf.putChart(key, b)
+	return b, cv.Version, nil
+}
+
+func (hcf *CachingHelmChartFetcher) registryClient() (*registry.Client, error) {
+	hcf.mtx.Lock()
+	defer hcf.mtx.Unlock()
+	if hcf.rcl != nil {
+		return hcf.rcl, nil
+	}
+	rcl, err := registry.NewClient()
+	if err != nil {
+		return nil, err
+	}
+	hcf.rcl = rcl
+	return rcl, nil
+}
+
+// FetchOCIChart fetches a chart from an OCI registry
+func (hcf *CachingHelmChartFetcher) FetchOCIChart(ctx context.Context, src models.ChartOCISource, untrusted bool) ([]byte, string, error) {
+	if err := hcf.allowed(src.Ref, untrusted); err != nil {
+		return nil, "", err
+	}
+	ref := strings.TrimPrefix(src.Ref, "oci://")
+	rcl, err := hcf.registryClient()
+	if err != nil {
+		return nil, "", fmt.Errorf("error getting registry client: %w", err)
+	}
+	ci, ok := hcf.getIndex(src.Ref)
+	if !ok {
+		tags, err := rcl.Tags(ref)
+		if err != nil {
+			return nil, "", fmt.Errorf("error getting tags: %w", err)
+		}
+		ci = cachedIndex{tags: tags}
+		hcf.putIndex(src.Ref, ci)
+	}
+	version, err := registry.GetTagMatchingVersionOrConstraint(ci.tags, src.Version)
+	if err != nil {
+		return nil, "", nitroerrors.User(fmt.Errorf("no chart version matching %v found for %v: %w", src.Version, src.Ref, err))
+	}
+	key := src.Ref + "@" + version
+	if b, ok := hcf.getChart(key); ok {
+		return b, version, nil
+	}
+	pr, err := rcl.Pull(ref + ":" + version)
+	if err != nil {
+		return nil, "", fmt.Errorf("error pulling chart: %w", err)
+	}
+	if pr.Chart == nil || len(pr.Chart.Data) == 0 {
+		return nil, "", fmt.Errorf("pulled chart is empty: %v", key)
+	}
+	hcf.putChart(key, pr.Chart.Data)
+	return pr.Chart.Data, version, nil
+}
+
+// fetchPackagedChart fetches the chart for a dependency with a Helm chart repository or OCI registry source and extracts it into the filesystem at cd
+func (g DataGetter) fetchPackagedChart(ctx context.Context, d models.RepoConfigDependency, cd string, untrusted bool) error {
+	if g.CF == nil {
+		return errors.New("helm chart fetcher is not configured")
+	}
+	var b []byte
+	var version string
+	var err error
+	switch {
+	case d.AppMetadata.ChartRepo != nil:
+		log(ctx, "fetching chart from repository: %v", d.AppMetadata.ChartRepo.String())
+		b, version, err = g.CF.FetchRepoChart(ctx, *d.AppMetadata.ChartRepo, untrusted)
+	case d.AppMetadata.ChartOCI != nil:
+		log(ctx, "fetching chart from OCI registry: %v", d.AppMetadata.ChartOCI.String())
+		b, version, err = g.CF.FetchOCIChart(ctx, *d.AppMetadata.ChartOCI, untrusted)
+	default:
+		return errors.New("dependency has no chart repository or OCI source")
+	}
+	if err != nil {
+		return fmt.Errorf("error fetching chart: %w", err)
+	}
+	log(ctx, "fetched chart version: %v: %v", d.Name, version)
+	files, err := loader.LoadArchiveFiles(bytes.NewReader(b))
+	if err != nil {
+		return nitroerrors.User(fmt.Errorf("error reading chart archive: %w", err))
+	}
+	for _, bf := range files {
+		fp := path.Join(cd, bf.Name)
+		if err := g.FS.MkdirAll(path.Dir(fp), os.ModePerm); err != nil {
+			return fmt.Errorf("error creating directory: %w", err)
+		}
+		f, err := g.FS.Create(fp)
+		if err != nil {
+			return fmt.Errorf("error creating file: %w", err)
+		}
+		_, err = f.Write(bf.Data)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("error writing to file: %w", err)
+		}
+	}
+	return nil
+}
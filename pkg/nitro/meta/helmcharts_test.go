@@ -0,0 +1,337 @@
+package meta
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/dollarshaveclub/acyl/pkg/ghclient"
+	"github.com/dollarshaveclub/acyl/pkg/memfs"
+	"github.com/dollarshaveclub/acyl/pkg/models"
+	nitroerrors "github.com/dollarshaveclub/acyl/pkg/nitro/errors"
+)
+
+// testChartArchive returns a packaged chart archive containing Chart.yaml and values.yaml
+func testChartArchive(t *testing.T, name, version string) []byte {
+	b := &bytes.Buffer{}
+	gw := gzip.NewWriter(b)
+	tw := tar.NewWriter(gw)
+	files := map[string]string{
+		name + "/Chart.yaml":  fmt.Sprintf("apiVersion: v2\nname: %v\nversion: %v\n", name, version),
+		name + "/values.yaml": "foo: bar\n",
+	}
+	for n, c := range files {
+		if err := tw.WriteHeader(&tar.Header{Name: n, Mode: 0644, Size: int64(len(c)), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatalf("error writing tar header: %v", err)
+		}
+		if _, err := tw.Write([]byte(c)); err != nil {
+			t.Fatalf("error writing tar file: %v", err)
+		}
+	}
+	tw.Close()
+	gw.Close()
+	return b.Bytes()
+}
+
+const testChartIndex = `apiVersion: v1
+entries:
+  redis:
+    - name: redis
+      version: 17.3.1
+      urls: [charts/redis-17.3.1.tgz]
+    - name: redis
+      version: 17.4.0
+      urls: [charts/redis-17.4.0.tgz]
+`
+
+func TestCachingHelmChartFetcherFetchRepoChart(t *testing.T) {
+	reqs := map[string]int{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reqs[r.URL.Path]++
+		switch r.URL.Path {
+		case "/index.yaml":
+			w.Write([]byte(testChartIndex))
+		case "/charts/redis-17.3.1.tgz":
+			w.Write(testChartArchive(t, "redis", "17.3.1"))
+		case "/charts/redis-17.4.0.tgz":
+			w.Write(testChartArchive(t, "redis", "17.4.0"))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+	hcf := NewCachingHelmChartFetcher(nil)
+	for i := 0; i < 2; i++ {
+		b, version, err := hcf.FetchRepoChart(context.Background(), models.ChartRepoSource{URL: srv.URL + "/", Chart: "redis", Version: "~17.3"}, false)
+		if err != nil {
+			t.Fatalf("should have succeeded: %v", err)
+		}
+		if version != "17.3.1" {
+			t.Fatalf("bad version: %v", version)
+		}
+		if len(b) == 0 {
+			t.Fatalf("empty archive")
+		}
+	}
+	if reqs["/index.yaml"] != 1 || reqs["/charts/redis-17.3.1.tgz"] != 1 {
+		t.Fatalf("index and chart should have been cached: %v", reqs)
+	}
+	_, version, err := hcf.FetchRepoChart(context.Background(), models.ChartRepoSource{URL: srv.URL, Chart: "redis"}, false)
+	if err != nil {
+		t.Fatalf("latest should have succeeded: %v", err)
+	}
+	if version != "17.4.0" {
+		t.Fatalf("bad latest version: %v", version)
+	}
+	_, _, err = hcf.FetchRepoChart(context.Background(), models.ChartRepoSource{URL: srv.URL, Chart: "redis", Version: ">=18"}, false)
+	if err == nil || !nitroerrors.IsUserError(err) {
+		t.Fatalf("unmatched version should have failed with a user error: %v", err)
+	}
+}
+
+func TestCachingHelmChartFetcherAllowedHosts(t *testing.T) {
+	// the "other" server is only reachable as localhost, while the repo server is used as 127.0.0.1
+	var otherReqs int
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		otherReqs++
+		w.Write(testChartArchive(t, "redis", "1.0.0"))
+	}))
+	defer other.Close()
+	otherURL := strings.Replace(other.URL, "127.0.0.1", "localhost", 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/index.yaml":
+			w.Write([]byte(testChartIndex))
+		case "/charts/redis-17.3.1.tgz", "/charts/redis-17.4.0.tgz":
+			w.Write(testChartArchive(t, "redis", "17.3.1"))
+		case "/absolute/index.yaml":
+			w.Write([]byte(fmt.Sprintf("apiVersion: v1\nentries:\n  redis:\n    - name: redis\n      version: 1.0.0\n      urls: [%v/redis-1.0.0.tgz]\n", otherURL)))
+		case "/redirect/index.yaml":
+			http.Redirect(w, r, otherURL+"/index.yaml", http.StatusFound)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+	tests := []struct {
+		name         string
+		allowedHosts []string
+		url          string
+		untrusted    bool
+		wantErr      bool
+	}{
+		{"no allowlist", nil, srv.URL, false, false},
+		{"no allowlist untrusted", nil, srv.URL, true, true},
+		{"allowed untrusted", []string{"127.0.0.1"}, srv.URL, true, false},
+		{"repo host not allowed", []string{"*.example.com"}, srv.URL, false, true},
+		{"chart url host not allowed", []string{"127.0.0.1"}, srv.URL + "/absolute", false, true},
+		{"redirect host not allowed", []string{"127.0.0.1"}, srv.URL + "/redirect", false, true},
+		{"chart url host allowed", []string{"127.0.0.1", "localhost"}, srv.URL + "/absolute", true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			otherReqs = 0
+			hcf := NewCachingHelmChartFetcher(tt.allowedHosts)
+			_, _, err := hcf.FetchRepoChart(context.Background(), models.ChartRepoSource{URL: tt.url, Chart: "redis"}, tt.untrusted)
+			if tt.wantErr {
+				if err == nil || !nitroerrors.IsUserError(err) {
+					t.Fatalf("should have failed with a user error: %v", err)
+				}
+				if otherReqs != 0 {
+					t.Fatalf("disallowed host should not have been requested")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("should have succeeded: %v", err)
+			}
+		})
+	}
+	// OCI sources are checked before contacting the registry
+	hcf := NewCachingHelmChartFetcher(nil)
+	if _, _, err := hcf.FetchOCIChart(context.Background(), models.ChartOCISource{Ref: "oci://registry.example.com/charts/redis"}, true); err == nil || !nitroerrors.IsUserError(err) {
+		t.Fatalf("untrusted OCI source should have failed with a user error: %v", err)
+	}
+	hcf = NewCachingHelmChartFetcher([]string{"charts.example.com"})
+	if _, _, err := hcf.FetchOCIChart(context.Background(), models.ChartOCISource{Ref: "oci://registry.example.com/charts/redis"}, false); err == nil || !nitroerrors.IsUserError(err) {
+		t.Fatalf("OCI source with a disallowed host should have failed with a user error: %v", err)
+	}
+}
+
+type fakeHelmChartFetcher struct {
+	archive []byte
+}
+
+func (fhcf *fakeHelmChartFetcher) FetchRepoChart(ctx context.Context, src models.ChartRepoSource, untrusted bool) ([]byte, string, error) {
+	return fhcf.archive, "1.0.0", nil
+}
+
+func (fhcf *fakeHelmChartFetcher) FetchOCIChart(ctx context.Context, src models.ChartOCISource, untrusted bool) ([]byte, string, error) {
+	return fhcf.archive, "1.0.0", nil
+}
+
+func TestMetaGetterFetchChartsPackaged(t *testing.T) {
+	rc := &ghclient.FakeRepoClient{
+		GetDirectoryContentsFunc: func(ctx context.Context, repo string, path string, ref string) (map[string]ghclient.FileContents, error) {
+			if repo != "foo/bar" || path != ".chart/foo" {
+				t.Fatalf("unexpected directory fetch: %v: %v", repo, path)
+			}
+			return map[string]ghclient.FileContents{
+				".chart/foo/Chart.yaml": ghclient.FileContents{Contents: []byte("name: foo")},
+			}, nil
+		},
+		GetFileContentsFunc: func(ctx context.Context, repo string, path string, ref string) ([]byte, error) {
+			if repo != "foo/bar" {
+				t.Fatalf("vars file should have been fetched from the parent repo: %v", repo)
+			}
+			return []byte("asdf"), nil
+		},
+	}
+	bp := "/tmp/foo"
+	mfs := memfs.New()
+	mfs.MkdirAll(bp, os.ModePerm)
+	g := DataGetter{
+		RC: rc,
+		FS: mfs,
+		CF: &fakeHelmChartFetcher{archive: testChartArchive(t, "redis", "1.0.0")},
+	}
+	rcfg := &models.RepoConfig{
+		Application: models.RepoConfigAppMetadata{Repo: "foo/bar", Ref: "aaaa", ChartPath: ".chart/foo"},
+		Dependencies: models.DependencyDeclaration{
+			Direct: []models.RepoConfigDependency{
+				models.RepoConfigDependency{
+					Name: "redis",
+					AppMetadata: models.RepoConfigAppMetadata{
+						Repo:          "foo/bar",
+						Ref:           "aaaa",
+						ChartRepo:     &models.ChartRepoSource{URL: "https://example.com/charts", Chart: "redis"},
+						ChartVarsPath: ".chart/redis-vars.yml",
+					},
+				},
+				models.RepoConfigDependency{
+					Name: "postgres",
+					AppMetadata: models.RepoConfigAppMetadata{
+						Repo:     "foo/bar",
+						Ref:      "aaaa",
+						ChartOCI: &models.ChartOCISource{Ref: "oci://example.com/charts/postgresql"},
+					},
+				},
+			},
+		},
+	}
+	cl, err := g.FetchCharts(context.Background(), rcfg, bp, false)
+	if err != nil {
+		t.Fatalf("should have succeeded: %v", err)
+	}
+	for _, name := range []string{"redis", "postgres"} {
+		loc, ok := cl[name]
+		if !ok {
+			t.Fatalf("missing chart location for %v", name)
+		}
+		f, err := mfs.Open(loc.ChartPath + "/Chart.yaml")
+		if err != nil {
+			t.Fatalf("error opening Chart.yaml for %v: %v", name, err)
+		}
+		b, _ := ioutil.ReadAll(f)
+		f.Close()
+		if !bytes.Contains(b, []byte("name: redis")) {
+			t.Fatalf("unexpected Chart.yaml contents for %v: %v", name, string(b))
+		}
+	}
+	if cl["redis"].VarFilePath == "" {
+		t.Fatalf("redis vars file should have been fetched")
+	}
+	g.CF = nil
+	if _, err := g.FetchCharts(context.Background(), rcfg, bp, false); err == nil {
+		t.Fatalf("should have failed without a chart fetcher")
+	}
+}
+
+func TestMetaGetterGetChartRepoDependency(t *testing.T) {
+	acylyml := func(dep string) []byte {
+		return []byte(`version: 2
+application:
+  chart_path: .charts/app
+  image: acme/app
+dependencies:
+  direct:
+` + dep)
+	}
+	tests := []struct {
+		name, dep, wantName string
+		isErr               bool
+	}{
+		{
+			name:     "chart_repo",
+			dep:      "    - chart_repo:\n        url: https://charts.example.com\n        chart: redis\n        version: ~17.3\n",
+			wantName: "redis",
+		},
+		{
+			name:     "chart_oci",
+			dep:      "    - chart_oci:\n        ref: oci://registry.example.com/charts/postgresql\n",
+			wantName: "postgresql",
+		},
+		{
+			name:  "multiple sources",
+			dep:   "    - chart_path: .charts/redis\n      chart_repo:\n        url: https://charts.example.com\n        chart: redis\n",
+			isErr: true,
+		},
+		{
+			name:  "oci tag",
+			dep:   "    - chart_oci:\n        ref: oci://registry.example.com/charts/postgresql:1.0.0\n",
+			isErr: true,
+		},
+		{
+			name:  "bad url",
+			dep:   "    - chart_repo:\n        url: ftp://charts.example.com\n        chart: redis\n",
+			isErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rc := &ghclient.FakeRepoClient{
+				GetFileContentsFunc: func(ctx context.Context, repo string, path string, ref string) ([]byte, error) {
+					if path == "acyl.yml" {
+						return acylyml(tt.dep), nil
+					}
+					return nil, fmt.Errorf("unexpected path: %v", path)
+				},
+			}
+			g := DataGetter{RC: rc, FS: memfs.New()}
+			out, err := g.Get(context.Background(), models.RepoRevisionData{Repo: "foo/bar", SourceSHA: "aaaa", SourceBranch: "feature"})
+			if err != nil {
+				if !tt.isErr {
+					t.Fatalf("should have succeeded: %v", err)
+				}
+				if !nitroerrors.IsUserError(err) {
+					t.Fatalf("should have been a user error: %v", err)
+				}
+				return
+			}
+			if tt.isErr {
+				t.Fatalf("should have failed")
+			}
+			if len(out.Dependencies.Direct) != 1 {
+				t.Fatalf("bad dependencies: %+v", out.Dependencies.Direct)
+			}
+			d := out.Dependencies.Direct[0]
+			if d.Name != tt.wantName {
+				t.Fatalf("bad name: %v (wanted %v)", d.Name, tt.wantName)
+			}
+			if d.AppMetadata.Repo != "foo/bar" || d.AppMetadata.Ref != "aaaa" {
+				t.Fatalf("app metadata should refer to the parent repo: %+v", d.AppMetadata)
+			}
+			if d.BranchMatchable() {
+				t.Fatalf("dependency should not be branch matchable")
+			}
+		})
+	}
+}
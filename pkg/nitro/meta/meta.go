@@ -27,7 +27,7 @@ import (
 // Getter describes an object that fetches and parses metadata (acyl.yml) from a set of repositories
 type Getter interface {
 	Get(ctx context.Context, rd models.RepoRevisionData) (*models.RepoConfig, error)
-	FetchCharts(ctx context.Context, rc *models.RepoConfig, basePath string) (ChartLocations, error)
+	FetchCharts(ctx context.Context, rc *models.RepoConfig, basePath string, untrusted bool) (ChartLocations, error)
 	GetAcylYAML(ctx context.Context, rc *models.RepoConfig, repo, ref string) (err error)
 }
 
@@ -39,6 +39,8 @@ type DataGetter struct {
 	RepoRefOverrides map[string]string
 	RC               ghclient.RepoClient
 	FS               billy.Filesystem
+	// CF fetches charts for dependencies from Helm chart repositories and OCI registries
+	CF HelmChartFetcher
 }
 
 func log(ctx context.Context, msg string, args ...interface{}) {
@@ -116,6 +118,10 @@ func (g DataGetter) getDependencyChartName(ctx context.Context, d *models.RepoCo
 			return "", fmt.Errorf("error parsing ChartRepoPath for repo dependency: %v: %w", d.Repo, err)
 		}
 		crepo, cref, cpath = rp.repo, rp.ref, rp.path
+	case d.AppMetadata.ChartRepo != nil:
+		return d.AppMetadata.ChartRepo.Chart, nil
+	case d.AppMetadata.ChartOCI != nil:
+		return d.AppMetadata.ChartOCI.ChartName(), nil
 	default:
 		return "", nitroerrors.User(fmt.Errorf("repo dependency lacks ChartPath/ChartRepoPath: %v", d.Repo))
 	}
@@ -217,6 +223,8 @@ func (g DataGetter) Get(ctx context.Context, rd models.RepoRevisionData) (*model
 			break
 		}
 		switch {
+		case (d.ChartRepo != nil || d.ChartOCI != nil) && (d.Repo != "" || d.ChartPath != "" || d.ChartRepoPath != "" || (d.ChartRepo != nil && d.ChartOCI != nil)):
+			return nitroerrors.User(fmt.Errorf("dependency error: %v: only one of Repo, ChartPath, ChartRepoPath, ChartRepo or ChartOCI may be used", d.Name))
 		case d.Repo != "" && (d.ChartPath != "" || d.ChartRepoPath != ""):
 			return nitroerrors.User(fmt.Errorf("dependency error: %v: only one of Repo, ChartPath, or ChartRepoPath may be used", d.Name))
 		case d.ChartPath != "" && d.ChartRepoPath != "":
@@ -301,8 +309,38 @@ func (g DataGetter) Get(ctx context.Context, rd models.RepoRevisionData) (*model
 				}
 				d.Name = name
 			}
+		case d.ChartRepo != nil || d.ChartOCI != nil:
+			if d.DisableBranchMatch || d.DefaultBranch != "" {
+				return nitroerrors.User(fmt.Errorf("branch matching and default branch not available if ChartRepo or ChartOCI is used: %v", d.Name))
+			}
+			if d.ChartRepo != nil {
+				if err := d.ChartRepo.Validate(); err != nil {
+					return fmt.Errorf("dependency error: %v: %w", d.Name, err)
+				}
+			} else {
+				if err := d.ChartOCI.Validate(); err != nil {
+					return fmt.Errorf("dependency error: %v: %w", d.Name, err)
+				}
+			}
+			// chart_vars_path refers to the parent repo
+			d.AppMetadata = models.RepoConfigAppMetadata{
+				Repo:              parent.AppMetadata.Repo,
+				Ref:               parent.AppMetadata.Ref,
+				Branch:            parent.AppMetadata.Branch,
+				ChartRepo:         d.ChartRepo,
+				ChartOCI:          d.ChartOCI,
+				ChartVarsPath:     d.ChartVarsPath,
+				ChartVarsRepoPath: d.ChartVarsRepoPath,
+			}
+			if d.Name == "" {
+				name, err := g.getDependencyChartName(ctx, d)
+				if err != nil {
+					return fmt.Errorf("error getting chart name for dependency: %v: %w", d.Name, err)
+				}
+				d.Name = name
+			}
 		default:
-			return fmt.Errorf("dependency error: %v: exactly one of Repo, ChartPath, or ChartRepoPath must be used", d.Name)
+			return fmt.Errorf("dependency error: %v: exactly one of Repo, ChartPath, ChartRepoPath, ChartRepo or ChartOCI must be used", d.Name)
 		}
 		d.AppMetadata.SetValueDefaults()
 		return nil
@@ -338,6 +376,15 @@ func (g DataGetter) Get(ctx context.Context, rd models.RepoRevisionData) (*model
 	if ok, err := rc.Dependencies.ValidateNames(); !ok {
 		return nil, fmt.Errorf("error validating dependency names: %w", err)
 	}
+	if err := rc.ValidateHealthChecks(); err != nil {
+		return nil, fmt.Errorf("error validating health checks: %w", err)
+	}
+	if err := rc.ValidateTests(); err != nil {
+		return nil, fmt.Errorf("error validating tests: %w", err)
+	}
+	if _, err := rc.LifetimeDuration(); err != nil {
+		return nil, fmt.Errorf("error validating lifetime: %w", err)
+	}
 	return &rc, nil
 }
 
@@ -369,14 +416,19 @@ func (rp *repoPath) parseFromString(crp string) error {
 }
 
 // chartLocation models the location for a chart and the associated vars file
+// If packaged, the chart is fetched from a Helm chart repository or OCI registry instead of GitHub.
 type chartLocation struct {
-	chart repoPath
-	vars  repoPath
+	chart    repoPath
+	vars     repoPath
+	packaged bool
 }
 
 func getChartLocation(d models.RepoConfigDependency) (chartLocation, error) {
 	loc := chartLocation{}
-	if d.AppMetadata.ChartPath == "" {
+	switch {
+	case d.AppMetadata.ChartRepo != nil || d.AppMetadata.ChartOCI != nil:
+		loc.packaged = true
+	case d.AppMetadata.ChartPath == "":
 		if d.AppMetadata.ChartRepoPath == "" {
 			return loc, nitroerrors.User(errors.New("one of ChartPath or ChartRepoPath must be defined"))
 		}
@@ -386,7 +438,7 @@ func getChartLocation(d models.RepoConfigDependency) (chartLocation, error) {
 			return loc, fmt.Errorf("error validating ChartRepoPath: %w", err)
 		}
 		loc.chart = *rp
-	} else {
+	default:
 		loc.chart.repo = d.AppMetadata.Repo
 		loc.chart.path = d.AppMetadata.ChartPath
 		loc.chart.ref = d.AppMetadata.Ref
@@ -417,7 +469,8 @@ type ChartLocation struct {
 type ChartLocations map[string]ChartLocation
 
 // FetchCharts fetches the charts for the repo and all dependencies, writing them to the filesystem g.FS at basePath/[offset]/[name] and returns a map of dependency name to filesystem path
-func (g DataGetter) FetchCharts(ctx context.Context, rc *models.RepoConfig, basePath string) (ChartLocations, error) {
+// untrusted indicates that rc is for an untrusted environment (a PR from a fork), which restricts the chart repositories and OCI registries that charts may be fetched from.
+func (g DataGetter) FetchCharts(ctx context.Context, rc *models.RepoConfig, basePath string, untrusted bool) (ChartLocations, error) {
 	// returns the local filesystem path of the chart and vars file (in order)
 	fetchChartAndVars := func(i int, d models.RepoConfigDependency) (_ *ChartLocation, err error) {
 		defer func() {
@@ -434,37 +487,43 @@ func (g DataGetter) FetchCharts(ctx context.Context, rc *models.RepoConfig, base
 			return nil, fmt.Errorf("error getting chart location: %w", err)
 		}
 		cd := path.Join(basePath, strconv.Itoa(i), d.Name)
-		log(ctx, "getting directory contents: %v@%v: %v", cloc.chart.repo, cloc.chart.ref, cloc.chart.path)
-		dc, err := g.RC.GetDirectoryContents(ctx, cloc.chart.repo, cloc.chart.path, cloc.chart.ref)
-		if err != nil {
-			return nil, fmt.Errorf("error fetching chart contents: %w", err)
-		}
-		for n, c := range dc {
-			n = strings.Replace(n, filepath.Clean(cloc.chart.path), "", -1) // remove chart path
-			fp := path.Join(cd, n)
-			if err = g.FS.MkdirAll(path.Dir(fp), os.ModePerm); err != nil {
-				return nil, fmt.Errorf("error creating directory: %w", err)
-			}
-			if c.Symlink {
-				if err := g.FS.Symlink(c.SymlinkTarget, fp); err != nil {
-					return nil, fmt.Errorf("error creating symlink: %w", err)
-				}
-				continue
+		if cloc.packaged {
+			if err := g.fetchPackagedChart(ctx, d, cd, untrusted); err != nil {
+				return nil, fmt.Errorf("error fetching packaged chart: %w", err)
 			}
-			f, err := g.FS.Create(fp)
+		} else {
+			log(ctx, "getting directory contents: %v@%v: %v", cloc.chart.repo, cloc.chart.ref, cloc.chart.path)
+			dc, err := g.RC.GetDirectoryContents(ctx, cloc.chart.repo, cloc.chart.path, cloc.chart.ref)
 			if err != nil {
-				return nil, fmt.Errorf("error creating file: %w", err)
+				return nil, fmt.Errorf("error fetching chart contents: %w", err)
 			}
-			defer f.Close()
-			var n int
-			for {
-				i, err := f.Write(c.Contents[n:len(c.Contents)])
+			for n, c := range dc {
+				n = strings.Replace(n, filepath.Clean(cloc.chart.path), "", -1) // remove chart path
+				fp := path.Join(cd, n)
+				if err = g.FS.MkdirAll(path.Dir(fp), os.ModePerm); err != nil {
+					return nil, fmt.Errorf("error creating directory: %w", err)
+				}
+				if c.Symlink {
+					if err := g.FS.Symlink(c.SymlinkTarget, fp); err != nil {
+						return nil, fmt.Errorf("error creating symlink: %w", err)
+					}
+					continue
+				}
+				f, err := g.FS.Create(fp)
 				if err != nil {
-					return nil, fmt.Errorf("error writing to file: %w", err)
+					return nil, fmt.Errorf("error creating file: %w", err)
 				}
-				n += i
-				if n == len(c.Contents) {
-					break
+				defer f.Close()
+				var n int
+				for {
+					i, err := f.Write(c.Contents[n:len(c.Contents)])
+					if err != nil {
+						return nil, fmt.Errorf("error writing to file: %w", err)
+					}
+					n += i
+					if n == len(c.Contents) {
+						break
+					}
 				}
 			}
 		}
@@ -282,7 +282,7 @@ func TestMetaGetterFetchCharts(t *testing.T) {
 			},
 		},
 	}
-	d, err := g.FetchCharts(context.Background(), rcfg, bp)
+	d, err := g.FetchCharts(context.Background(), rcfg, bp, false)
 	if err != nil {
 		t.Fatalf("should have succeeded: %v", err)
 	}
@@ -0,0 +1,171 @@
+package metahelm
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/dollarshaveclub/acyl/pkg/config"
+	"github.com/dollarshaveclub/acyl/pkg/models"
+	nitroerrors "github.com/dollarshaveclub/acyl/pkg/nitro/errors"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// clusterClient is the k8s clientset for a configured cluster
+type clusterClient struct {
+	cluster config.K8sCluster
+	kc      kubernetes.Interface
+	rcfg    *rest.Config
+}
+
+// newClusterClients returns a map of cluster name to client for clusters. Clusters without credentials use the default clientset (kc and rcfg).
+func newClusterClients(kc kubernetes.Interface, rcfg *rest.Config, clusters config.K8sClusters) (map[string]clusterClient, error) {
+	out := make(map[string]clusterClient, len(clusters))
+	for _, c := range clusters {
+		if c.Default() {
+			out[c.Name] = clusterClient{cluster: c, kc: kc, rcfg: rcfg}
+			continue
+		}
+		ckc, crcfg, err := NewKubecfgContextK8sClientset(c.KubeconfigPath, c.KubeContext)
+		if err != nil {
+			return nil, fmt.Errorf("error getting k8s client for cluster %v: %w", c.Name, err)
+		}
+		out[c.Name] = clusterClient{cluster: c, kc: ckc, rcfg: crcfg}
+	}
+	return out, nil
+}
+
+// forCluster returns a copy of the ChartInstaller that uses the clientset and helm configuration of the named cluster.
+// Environments created before any clusters were configured have an empty cluster name and use the default clientset.
+func (ci ChartInstaller) forCluster(name string) (ChartInstaller, error) {
+	if name == "" {
+		return ci, nil
+	}
+	cc, ok := ci.clusterclients[name]
+	if !ok {
+		return ci, fmt.Errorf("unknown cluster: %v", name)
+	}
+	ci.kc, ci.rcfg = cc.kc, cc.rcfg
+	if !cc.cluster.Default() {
+		ci.hccfg.KubeconfigPath, ci.hccfg.KubeContext = cc.cluster.KubeconfigPath, cc.cluster.KubeContext
+	}
+	return ci, nil
+}
+
+// isRemoteCluster returns whether the named cluster uses its own credentials rather than the default clientset of the cluster acyl runs in
+func (ci ChartInstaller) isRemoteCluster(name string) bool {
+	cc, ok := ci.clusterclients[name]
+	return ok && !cc.cluster.Default()
+}
+
+// forNamespace returns a copy of the ChartInstaller for the cluster of the environment with namespace ns
+func (ci ChartInstaller) forNamespace(ctx context.Context, ns string) (ChartInstaller, error) {
+	if len(ci.clusterclients) == 0 {
+		return ci, nil
+	}
+	k8senvs, err := ci.dl.GetK8sEnvsByNamespace(ctx, ns)
+	if err != nil {
+		return ci, fmt.Errorf("error getting k8s environments by namespace: %w", err)
+	}
+	if len(k8senvs) == 0 {
+		return ci, nil
+	}
+	return ci.forCluster(k8senvs[0].Cluster)
+}
+
+// allClusters returns a copy of the ChartInstaller for each distinct cluster: the default cluster and every cluster with its own credentials
+func (ci ChartInstaller) allClusters() []ChartInstaller {
+	out := []ChartInstaller{ci}
+	for _, c := range ci.clusters {
+		if c.Default() {
+			continue
+		}
+		if cci, err := ci.forCluster(c.Name); err == nil {
+			out = append(out, cci)
+		}
+	}
+	return out
+}
+
+// lockPlacement acquires the placement lock, which is shared by all copies of the ChartInstaller, and returns a function that releases it.
+// The returned function may be called more than once. Placement isn't serialized if no clusters are configured.
+func (ci ChartInstaller) lockPlacement() func() {
+	if len(ci.clusters) == 0 || ci.placemtx == nil {
+		return func() {}
+	}
+	ci.placemtx.Lock()
+	var once sync.Once
+	return func() { once.Do(ci.placemtx.Unlock) }
+}
+
+// placeEnv chooses the cluster for a new environment, returning the cluster name (empty if no clusters are configured).
+// A cluster selected explicitly in acyl.yml must exist. Otherwise, the candidates are the clusters with affinity for the repo or, if there are none, the clusters without repo affinity.
+// Candidates must have any labels selected in acyl.yml, and the candidate with the fewest environments that isn't at capacity is chosen.
+func (ci ChartInstaller) placeEnv(ctx context.Context, env *EnvInfo) (string, error) {
+	if len(ci.clusters) == 0 {
+		return "", nil
+	}
+	var placement models.PlacementConfig
+	if env.RC != nil {
+		placement = env.RC.Placement
+	}
+	var candidates config.K8sClusters
+	switch {
+	case placement.Cluster != "":
+		c, ok := ci.clusters.Get(placement.Cluster)
+		if !ok {
+			return "", nitroerrors.User(fmt.Errorf("placement: unknown cluster: %v", placement.Cluster))
+		}
+		candidates = config.K8sClusters{c}
+	default:
+		for _, c := range ci.clusters {
+			if c.HasAffinity(env.Env.Repo) {
+				candidates = append(candidates, c)
+			}
+		}
+		if len(candidates) == 0 {
+			for _, c := range ci.clusters {
+				if len(c.Repos) == 0 {
+					candidates = append(candidates, c)
+				}
+			}
+		}
+	}
+	labeled := candidates[:0:0]
+	for _, c := range candidates {
+		if c.HasLabels(placement.Labels) {
+			labeled = append(labeled, c)
+		}
+	}
+	if len(labeled) == 0 {
+		return "", nitroerrors.User(fmt.Errorf("placement: no cluster available for repo %v matches cluster %q and labels %v", env.Env.Repo, placement.Cluster, placement.Labels))
+	}
+	counts, err := ci.dl.GetK8sEnvCountsByCluster(ctx)
+	if err != nil {
+		return "", fmt.Errorf("error getting k8s environment counts: %w", err)
+	}
+	// an existing k8s environment for this env is replaced, so it doesn't count towards capacity
+	k8senv, err := ci.dl.GetK8sEnv(ctx, env.Env.Name)
+	if err != nil {
+		return "", fmt.Errorf("error getting k8s environment: %w", err)
+	}
+	if k8senv != nil && counts[k8senv.Cluster] > 0 {
+		counts[k8senv.Cluster]--
+	}
+	var chosen string
+	var min uint
+	for _, c := range labeled {
+		n := counts[c.Name]
+		if c.Capacity > 0 && n >= c.Capacity {
+			continue
+		}
+		if chosen == "" || n < min {
+			chosen, min = c.Name, n
+		}
+	}
+	if chosen == "" {
+		return "", fmt.Errorf("placement: all candidate clusters are at capacity")
+	}
+	return chosen, nil
+}
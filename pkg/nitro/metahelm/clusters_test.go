@@ -0,0 +1,298 @@
+package metahelm
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/dollarshaveclub/acyl/pkg/config"
+	"github.com/dollarshaveclub/acyl/pkg/models"
+	nitroerrors "github.com/dollarshaveclub/acyl/pkg/nitro/errors"
+	"github.com/dollarshaveclub/acyl/pkg/persistence"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func testClusters(t *testing.T) config.K8sClusters {
+	kc := config.K8sConfig{}
+	clusters := `[
+		{"name": "qa-east", "capacity": 2, "labels": {"region": "us-east-1"}},
+		{"name": "qa-west", "capacity": 3, "labels": {"region": "us-west-2", "gpu": "true"}},
+		{"name": "acme", "repos": ["acme/*"]}
+	]`
+	if err := kc.ProcessClusters(clusters); err != nil {
+		t.Fatalf("error processing clusters: %v", err)
+	}
+	return kc.Clusters
+}
+
+func createTestK8sEnv(t *testing.T, dl persistence.DataLayer, k8senv *models.KubernetesEnvironment) {
+	if err := dl.CreateQAEnvironment(context.Background(), &models.QAEnvironment{Name: k8senv.EnvName}); err != nil {
+		t.Fatalf("error creating env: %v", err)
+	}
+	if err := dl.CreateK8sEnv(context.Background(), k8senv); err != nil {
+		t.Fatalf("error creating k8s env: %v", err)
+	}
+}
+
+func TestPlaceEnv(t *testing.T) {
+	tests := []struct {
+		name, repo       string
+		placement        models.PlacementConfig
+		counts           map[string]int
+		existing         string
+		want             string
+		isErr, isUserErr bool
+	}{
+		{
+			name: "least loaded",
+			repo: "foo/bar",
+			counts: map[string]int{
+				"qa-east": 1,
+			},
+			want: "qa-west",
+		},
+		{
+			name: "affinity",
+			repo: "acme/something",
+			counts: map[string]int{
+				"acme": 10,
+			},
+			want: "acme",
+		},
+		{
+			name:      "explicit",
+			repo:      "foo/bar",
+			placement: models.PlacementConfig{Cluster: "qa-east"},
+			want:      "qa-east",
+		},
+		{
+			name:      "explicit outside affinity",
+			repo:      "acme/something",
+			placement: models.PlacementConfig{Cluster: "qa-west"},
+			want:      "qa-west",
+		},
+		{
+			name:      "labels",
+			repo:      "foo/bar",
+			placement: models.PlacementConfig{Labels: map[string]string{"gpu": "true"}},
+			counts: map[string]int{
+				"qa-west": 2,
+			},
+			want: "qa-west",
+		},
+		{
+			name: "skip full",
+			repo: "foo/bar",
+			counts: map[string]int{
+				"qa-west": 3,
+				"qa-east": 1,
+			},
+			want: "qa-east",
+		},
+		{
+			name: "replaced env doesn't count",
+			repo: "foo/bar",
+			counts: map[string]int{
+				"qa-west": 3,
+				"qa-east": 2,
+			},
+			existing: "qa-east",
+			want:     "qa-east",
+		},
+		{
+			name: "all full",
+			repo: "foo/bar",
+			counts: map[string]int{
+				"qa-west": 3,
+				"qa-east": 2,
+			},
+			isErr: true,
+		},
+		{
+			name:      "unknown cluster",
+			repo:      "foo/bar",
+			placement: models.PlacementConfig{Cluster: "does-not-exist"},
+			isErr:     true,
+			isUserErr: true,
+		},
+		{
+			name:      "label mismatch",
+			repo:      "foo/bar",
+			placement: models.PlacementConfig{Cluster: "qa-east", Labels: map[string]string{"gpu": "true"}},
+			isErr:     true,
+			isUserErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dl := persistence.NewFakeDataLayer()
+			for cluster, n := range tt.counts {
+				for i := 0; i < n; i++ {
+					envname := fmt.Sprintf("%v-%v", cluster, i)
+					if cluster == tt.existing && i == 0 {
+						envname = "foo-bar"
+					}
+					createTestK8sEnv(t, dl, &models.KubernetesEnvironment{EnvName: envname, Namespace: "nitro-" + envname, Cluster: cluster})
+				}
+			}
+			ci := ChartInstaller{dl: dl, clusters: testClusters(t)}
+			env := &EnvInfo{
+				Env: &models.QAEnvironment{Name: "foo-bar", Repo: tt.repo},
+				RC:  &models.RepoConfig{Placement: tt.placement},
+			}
+			cluster, err := ci.placeEnv(context.Background(), env)
+			if err != nil {
+				if !tt.isErr {
+					t.Fatalf("should have succeeded: %v", err)
+				}
+				if tt.isUserErr != nitroerrors.IsUserError(err) {
+					t.Fatalf("unexpected user error value: %v: %v", tt.isUserErr, err)
+				}
+				return
+			}
+			if tt.isErr {
+				t.Fatalf("should have failed")
+			}
+			if cluster != tt.want {
+				t.Fatalf("bad cluster: %v (wanted %v)", cluster, tt.want)
+			}
+		})
+	}
+}
+
+func TestPlaceEnvNoClusters(t *testing.T) {
+	ci := ChartInstaller{dl: persistence.NewFakeDataLayer()}
+	cluster, err := ci.placeEnv(context.Background(), &EnvInfo{Env: &models.QAEnvironment{Name: "foo-bar", Repo: "foo/bar"}})
+	if err != nil {
+		t.Fatalf("should have succeeded: %v", err)
+	}
+	if cluster != "" {
+		t.Fatalf("cluster should have been empty: %v", cluster)
+	}
+}
+
+func TestPlaceEnvConcurrent(t *testing.T) {
+	dl := persistence.NewFakeDataLayer()
+	ci := ChartInstaller{dl: dl, clusters: testClusters(t), placemtx: &sync.Mutex{}}
+	var wg sync.WaitGroup
+	errs := make(chan error, 4)
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+			unlock := ci.lockPlacement()
+			defer unlock()
+			env := &EnvInfo{
+				Env: &models.QAEnvironment{Name: name, Repo: "foo/bar"},
+				RC:  &models.RepoConfig{Placement: models.PlacementConfig{Cluster: "qa-east"}},
+			}
+			cluster, err := ci.placeEnv(context.Background(), env)
+			if err != nil {
+				errs <- err
+				return
+			}
+			if err := dl.CreateQAEnvironment(context.Background(), &models.QAEnvironment{Name: name}); err != nil {
+				errs <- err
+				return
+			}
+			errs <- dl.CreateK8sEnv(context.Background(), &models.KubernetesEnvironment{EnvName: name, Namespace: "nitro-" + name, Cluster: cluster})
+		}(fmt.Sprintf("foo-bar-%v", i))
+	}
+	wg.Wait()
+	close(errs)
+	var failed int
+	for err := range errs {
+		if err != nil {
+			failed++
+		}
+	}
+	if failed != 2 {
+		t.Fatalf("expected 2 placements to fail due to capacity: %v", failed)
+	}
+	counts, err := dl.GetK8sEnvCountsByCluster(context.Background())
+	if err != nil {
+		t.Fatalf("error getting counts: %v", err)
+	}
+	if counts["qa-east"] != 2 {
+		t.Fatalf("bad count for qa-east: %v", counts["qa-east"])
+	}
+}
+
+func TestProcessClustersInvalid(t *testing.T) {
+	tests := []struct {
+		name, clusters string
+	}{
+		{"missing name", `[{"capacity": 1}]`},
+		{"duplicate name", `[{"name": "foo"}, {"name": "foo"}]`},
+		{"malformed repo", `[{"name": "foo", "repos": ["acme"]}]`},
+		{"malformed json", `{"name": "foo"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kc := config.K8sConfig{}
+			if err := kc.ProcessClusters(tt.clusters); err == nil {
+				t.Fatalf("should have failed")
+			}
+		})
+	}
+}
+
+func TestMetahelmClusterRouting(t *testing.T) {
+	dl := persistence.NewFakeDataLayer()
+	defaultkc := fake.NewSimpleClientset(&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "nitro-default"}})
+	eastkc := fake.NewSimpleClientset(
+		&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "nitro-east"}},
+		&v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "foo", Namespace: "nitro-east"}, Spec: v1.PodSpec{Containers: []v1.Container{{Name: "app"}}}},
+	)
+	clusters := config.K8sClusters{{Name: "qa-east", KubeContext: "qa-east"}}
+	ci := ChartInstaller{
+		kc:       defaultkc,
+		dl:       dl,
+		clusters: clusters,
+		clusterclients: map[string]clusterClient{
+			"qa-east": clusterClient{cluster: clusters[0], kc: eastkc},
+		},
+	}
+	east := &models.KubernetesEnvironment{EnvName: "east", Namespace: "nitro-east", Cluster: "qa-east"}
+	legacy := &models.KubernetesEnvironment{EnvName: "default", Namespace: "nitro-default"}
+	createTestK8sEnv(t, dl, east)
+	createTestK8sEnv(t, dl, legacy)
+	pl, err := ci.GetPodList(context.Background(), "nitro-east")
+	if err != nil {
+		t.Fatalf("get pod list should have succeeded: %v", err)
+	}
+	if len(pl) != 1 || pl[0].Name != "foo" {
+		t.Fatalf("pod list should have come from the environment cluster: %+v", pl)
+	}
+	if pc, err := ci.GetPodContainers(context.Background(), "nitro-east", "foo"); err != nil || len(pc.Containers) != 1 {
+		t.Fatalf("get pod containers should have come from the environment cluster: %+v: %v", pc, err)
+	}
+	if err := ci.DeleteNamespace(context.Background(), east); err != nil {
+		t.Fatalf("delete should have succeeded: %v", err)
+	}
+	if _, err := eastkc.CoreV1().Namespaces().Get(context.Background(), "nitro-east", metav1.GetOptions{}); err == nil {
+		t.Fatalf("namespace should have been deleted from the environment cluster")
+	}
+	if err := ci.DeleteNamespace(context.Background(), legacy); err != nil {
+		t.Fatalf("delete should have succeeded: %v", err)
+	}
+	if _, err := defaultkc.CoreV1().Namespaces().Get(context.Background(), "nitro-default", metav1.GetOptions{}); err == nil {
+		t.Fatalf("namespace should have been deleted from the default cluster")
+	}
+	if err := ci.DeleteNamespace(context.Background(), &models.KubernetesEnvironment{EnvName: "other", Namespace: "nitro-other", Cluster: "removed"}); err == nil {
+		t.Fatalf("delete should have failed for an unknown cluster")
+	}
+	cci, err := ci.forCluster("qa-east")
+	if err != nil {
+		t.Fatalf("for cluster should have succeeded: %v", err)
+	}
+	if cci.hccfg.KubeContext != "qa-east" {
+		t.Fatalf("helm config should use the cluster context: %+v", cci.hccfg)
+	}
+	if n := len(ci.allClusters()); n != 2 {
+		t.Fatalf("expected two clusters to clean up: %v", n)
+	}
+}
@@ -11,9 +11,12 @@ import (
 	"strings"
 	"time"
 
+	"github.com/dollarshaveclub/acyl/pkg/eventlogger"
 	"github.com/dollarshaveclub/acyl/pkg/models"
+	nitroerrors "github.com/dollarshaveclub/acyl/pkg/nitro/errors"
 	"github.com/dollarshaveclub/acyl/pkg/persistence"
 	"github.com/dollarshaveclub/metahelm/pkg/metahelm"
+	multierror "github.com/hashicorp/go-multierror"
 	"github.com/pkg/errors"
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/client-go/kubernetes"
@@ -24,9 +27,13 @@ type FakeInstaller struct {
 	// ChartInstallFunc is called for each repo in chartLocations. Return an error to abort.
 	ChartInstallFunc func(repo string, location ChartLocation) error
 	ChartUpgradeFunc func(repo string, k8senv *models.KubernetesEnvironment, location ChartLocation) error
-	DL               persistence.DataLayer
-	KC               kubernetes.Interface
-	HelmReleases     []string
+	// HealthCheckFunc is called for each health check (name is the status tree node name). If nil all health checks pass.
+	HealthCheckFunc func(name string, hc models.HealthCheck) error
+	// TestsFunc is called instead of running the declared tests, if any. If nil all tests pass.
+	TestsFunc    func(tests models.RepoConfigTests) error
+	DL           persistence.DataLayer
+	KC           kubernetes.Interface
+	HelmReleases []string
 }
 
 var _ Installer = &FakeInstaller{}
@@ -47,7 +54,7 @@ func (fi *FakeInstaller) BuildAndInstallCharts(ctx context.Context, newenv *EnvI
 	}
 	if fi.DL != nil {
 		ci := ChartInstaller{dl: fi.DL, kc: fi.KC}
-		if err := ci.writeK8sEnvironment(ctx, newenv, "nitro-1234-"+newenv.Env.Name); err != nil {
+		if err := ci.writeK8sEnvironment(ctx, newenv, "nitro-1234-"+newenv.Env.Name, ""); err != nil {
 			return err
 		}
 		releases := getReleases(chartsLocation)
@@ -69,6 +76,32 @@ func (fi FakeInstaller) BuildAndUpgradeCharts(ctx context.Context, env *EnvInfo,
 	return nil
 }
 
+func (fi FakeInstaller) BuildAndUpdateChartsIncrementally(ctx context.Context, env *EnvInfo, k8senv *models.KubernetesEnvironment, cl ChartLocations, diff models.DependencyDiff) error {
+	insm := map[string]struct{}{}
+	for _, n := range diff.Install {
+		insm[n] = struct{}{}
+	}
+	for k, v := range cl {
+		if _, ok := insm[k]; ok {
+			if err := fi.ChartInstallFunc(k, v); err != nil {
+				return fmt.Errorf("install aborted: %w", err)
+			}
+			continue
+		}
+		if err := fi.ChartUpgradeFunc(k, k8senv, v); err != nil {
+			return fmt.Errorf("upgrade aborted: %w", err)
+		}
+	}
+	if fi.DL != nil {
+		ci := ChartInstaller{dl: fi.DL}
+		if err := ci.writeReleaseNames(ctx, getReleases(cl), k8senv.Namespace, env); err != nil {
+			return err
+		}
+		return ci.updateK8sEnvironment(ctx, env)
+	}
+	return nil
+}
+
 func (fi FakeInstaller) BuildAndInstallChartsIntoExisting(ctx context.Context, newenv *EnvInfo, k8senv *models.KubernetesEnvironment, cl ChartLocations) error {
 	for k, v := range cl {
 		if err := fi.ChartInstallFunc(k, v); err != nil {
@@ -90,6 +123,53 @@ func (fi FakeInstaller) DeleteNamespace(ctx context.Context, k8senv *models.Kube
 	return nil
 }
 
+func (fi FakeInstaller) SuspendNamespace(ctx context.Context, k8senv *models.KubernetesEnvironment) error {
+	if fi.KC != nil {
+		ci := ChartInstaller{kc: fi.KC}
+		return ci.SuspendNamespace(ctx, k8senv)
+	}
+	return nil
+}
+
+func (fi FakeInstaller) ResumeNamespace(ctx context.Context, k8senv *models.KubernetesEnvironment) error {
+	if fi.KC != nil {
+		ci := ChartInstaller{kc: fi.KC}
+		return ci.ResumeNamespace(ctx, k8senv)
+	}
+	return nil
+}
+
+// RunHealthChecks records the health checks in the event status tree, calling HealthCheckFunc (if set) instead of running the probes
+func (fi FakeInstaller) RunHealthChecks(ctx context.Context, env *EnvInfo) error {
+	elog := eventlogger.GetLogger(ctx)
+	var merr *multierror.Error
+	for name, hcs := range env.RC.HealthChecks() {
+		for _, hc := range hcs {
+			elog.SetHealthCheckStarted(name, hc.Name)
+			var err error
+			if fi.HealthCheckFunc != nil {
+				err = fi.HealthCheckFunc(name, hc)
+			}
+			elog.SetHealthCheckCompleted(name, hc.Name, err)
+			if err != nil {
+				merr = multierror.Append(merr, fmt.Errorf("%v: %v: %w", name, hc.Name, err))
+			}
+		}
+	}
+	if err := merr.ErrorOrNil(); err != nil {
+		return nitroerrors.User(fmt.Errorf("health checks failed: %w", err))
+	}
+	return nil
+}
+
+// RunTests calls TestsFunc (if set) instead of running the declared tests
+func (fi FakeInstaller) RunTests(ctx context.Context, env *EnvInfo) error {
+	if !env.RC.Tests.Enabled() || fi.TestsFunc == nil {
+		return nil
+	}
+	return fi.TestsFunc(env.RC.Tests)
+}
+
 // FakeKubernetesReporter satisfies the kubernetes reporter interface but does nothing
 type FakeKubernetesReporter struct {
 	FakePodLogFilePath string
@@ -0,0 +1,355 @@
+package metahelm
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+
+	"github.com/dollarshaveclub/acyl/pkg/config"
+	"github.com/dollarshaveclub/acyl/pkg/models"
+	nitroerrors "github.com/dollarshaveclub/acyl/pkg/nitro/errors"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+// Names of the namespace guardrail objects
+const (
+	guardrailsResourceQuotaName = "acyl"
+	guardrailsLimitRangeName    = "acyl"
+	guardrailsNetworkPolicyName = "acyl-default-deny"
+)
+
+// effectiveNamespaceConfig merges the repo namespace config onto the server defaults, reducing any repo values that exceed the ceilings.
+// If untrusted, repo network policy settings are ignored. The returned warnings describe values that were changed.
+func effectiveNamespaceConfig(ng config.NamespaceGuardrails, rnc models.NamespaceConfig, untrusted bool) (models.NamespaceConfig, []string, error) {
+	var warnings []string
+	out := models.NamespaceConfig{}
+	mergeRL := func(kind string, defaults, repo, ceilings map[string]string) (map[string]string, error) {
+		if len(defaults) == 0 && len(repo) == 0 {
+			return nil, nil
+		}
+		out := make(map[string]string, len(defaults)+len(repo))
+		for k, v := range defaults {
+			out[k] = v
+		}
+		for k, v := range repo {
+			q, err := resource.ParseQuantity(v)
+			if err != nil {
+				return nil, nitroerrors.User(fmt.Errorf("invalid %v quantity for %v: %v: %w", kind, k, v, err))
+			}
+			if c, ok := ceilings[k]; ok {
+				cq, err := resource.ParseQuantity(c)
+				if err != nil {
+					return nil, fmt.Errorf("invalid %v ceiling for %v: %v: %w", kind, k, c, err)
+				}
+				if q.Cmp(cq) > 0 {
+					warnings = append(warnings, fmt.Sprintf("%v %v: %v exceeds the server maximum, using %v", kind, k, v, c))
+					v = c
+				}
+			}
+			out[k] = v
+		}
+		return out, nil
+	}
+	var drq, rrq models.ResourceQuotaConfig
+	if ng.Defaults.ResourceQuota != nil {
+		drq = *ng.Defaults.ResourceQuota
+	}
+	if rnc.ResourceQuota != nil {
+		rrq = *rnc.ResourceQuota
+	}
+	hard, err := mergeRL("resource quota", drq.Hard, rrq.Hard, ng.Ceilings.ResourceQuota)
+	if err != nil {
+		return out, nil, err
+	}
+	if len(hard) > 0 {
+		out.ResourceQuota = &models.ResourceQuotaConfig{Hard: hard}
+	}
+	var dlr, rlr models.LimitRangeConfig
+	if ng.Defaults.LimitRange != nil {
+		dlr = *ng.Defaults.LimitRange
+	}
+	if rnc.LimitRange != nil {
+		rlr = *rnc.LimitRange
+	}
+	lr := models.LimitRangeConfig{}
+	if lr.Default, err = mergeRL("limit range default", dlr.Default, rlr.Default, ng.Ceilings.LimitRange); err != nil {
+		return out, nil, err
+	}
+	if lr.DefaultRequest, err = mergeRL("limit range default request", dlr.DefaultRequest, rlr.DefaultRequest, ng.Ceilings.LimitRange); err != nil {
+		return out, nil, err
+	}
+	if lr.Max, err = mergeRL("limit range max", dlr.Max, rlr.Max, ng.Ceilings.LimitRange); err != nil {
+		return out, nil, err
+	}
+	if len(lr.Default) > 0 || len(lr.DefaultRequest) > 0 || len(lr.Max) > 0 {
+		out.LimitRange = &lr
+	}
+	np := models.NetworkPolicyConfig{}
+	if dnp := ng.Defaults.NetworkPolicy; dnp != nil {
+		np.Enabled = dnp.Enabled
+		np.AllowIngressNamespaces = append(np.AllowIngressNamespaces, dnp.AllowIngressNamespaces...)
+		np.AllowEgressCIDRs = append(np.AllowEgressCIDRs, dnp.AllowEgressCIDRs...)
+	}
+	if rnp := rnc.NetworkPolicy; rnp != nil {
+		switch {
+		case untrusted:
+			warnings = append(warnings, "network policy settings are ignored for untrusted environments")
+		case rnp.Enabled != nil && !*rnp.Enabled && ng.Ceilings.NetworkPolicyRequired:
+			warnings = append(warnings, "network policy is required by the server and cannot be disabled")
+		default:
+			if err := checkNetworkPolicyCeilings(ng.Ceilings, *rnp); err != nil {
+				return out, nil, err
+			}
+			if rnp.Enabled != nil {
+				np.Enabled = rnp.Enabled
+			}
+			np.AllowIngressNamespaces = append(np.AllowIngressNamespaces, rnp.AllowIngressNamespaces...)
+			np.AllowEgressCIDRs = append(np.AllowEgressCIDRs, rnp.AllowEgressCIDRs...)
+		}
+	}
+	if ng.Ceilings.NetworkPolicyRequired {
+		t := true
+		np.Enabled = &t
+	}
+	if np.IsEnabled() {
+		for _, cidr := range np.AllowEgressCIDRs {
+			if _, _, err := net.ParseCIDR(cidr); err != nil {
+				return out, nil, nitroerrors.User(fmt.Errorf("invalid network policy egress CIDR: %v: %w", cidr, err))
+			}
+		}
+		out.NetworkPolicy = &np
+	}
+	return out, warnings, nil
+}
+
+// checkNetworkPolicyCeilings returns a user error if the repo network policy allows ingress from a namespace or egress to a CIDR that isn't permitted by the server
+func checkNetworkPolicyCeilings(nc config.NamespaceCeilings, rnp models.NetworkPolicyConfig) error {
+	allowedNS := make(map[string]struct{}, len(nc.AllowedIngressNamespaces))
+	for _, ns := range nc.AllowedIngressNamespaces {
+		allowedNS[ns] = struct{}{}
+	}
+	for _, ns := range rnp.AllowIngressNamespaces {
+		if _, ok := allowedNS[ns]; !ok {
+			return nitroerrors.User(fmt.Errorf("network policy ingress namespace is not permitted by the server: %v", ns))
+		}
+	}
+	for _, cidr := range rnp.AllowEgressCIDRs {
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nitroerrors.User(fmt.Errorf("invalid network policy egress CIDR: %v: %w", cidr, err))
+		}
+		if !cidrWithin(n, nc.AllowedEgressCIDRs) {
+			return nitroerrors.User(fmt.Errorf("network policy egress CIDR is not permitted by the server: %v", cidr))
+		}
+	}
+	return nil
+}
+
+// cidrWithin indicates whether n is contained in any of the allowed CIDRs
+func cidrWithin(n *net.IPNet, allowed []string) bool {
+	ones, bits := n.Mask.Size()
+	for _, a := range allowed {
+		_, an, err := net.ParseCIDR(a)
+		if err != nil {
+			continue
+		}
+		aones, abits := an.Mask.Size()
+		if abits == bits && aones <= ones && an.Contains(n.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+// applyNamespaceGuardrails creates, updates or deletes the ResourceQuota, LimitRange and NetworkPolicy in namespace ns according to the server defaults and the environment config
+func (ci ChartInstaller) applyNamespaceGuardrails(ctx context.Context, ns string, env *EnvInfo) error {
+	nc, warnings, err := effectiveNamespaceConfig(ci.nsguardrails, env.RC.Namespace, env.Env.IsFork)
+	if err != nil {
+		return fmt.Errorf("error getting namespace guardrails: %w", err)
+	}
+	for _, w := range warnings {
+		ci.log(ctx, "namespace guardrails: %v", w)
+	}
+	if err := ci.applyResourceQuota(ctx, ns, nc.ResourceQuota); err != nil {
+		return fmt.Errorf("error applying resource quota: %w", err)
+	}
+	if err := ci.applyLimitRange(ctx, ns, nc.LimitRange); err != nil {
+		return fmt.Errorf("error applying limit range: %w", err)
+	}
+	if err := ci.applyNetworkPolicy(ctx, ns, nc.NetworkPolicy); err != nil {
+		return fmt.Errorf("error applying network policy: %w", err)
+	}
+	return nil
+}
+
+func resourceList(rl map[string]string) (corev1.ResourceList, error) {
+	if len(rl) == 0 {
+		return nil, nil
+	}
+	out := make(corev1.ResourceList, len(rl))
+	for k, v := range rl {
+		q, err := resource.ParseQuantity(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid quantity for %v: %v: %w", k, v, err)
+		}
+		out[corev1.ResourceName(k)] = q
+	}
+	return out, nil
+}
+
+func (ci ChartInstaller) applyResourceQuota(ctx context.Context, ns string, rqc *models.ResourceQuotaConfig) error {
+	rqs := ci.kc.CoreV1().ResourceQuotas(ns)
+	existing, err := rqs.Get(ctx, guardrailsResourceQuotaName, metav1.GetOptions{})
+	if err != nil && !k8serrors.IsNotFound(err) {
+		return fmt.Errorf("error getting resource quota: %w", err)
+	}
+	exists := err == nil
+	if rqc == nil {
+		if exists {
+			ci.log(ctx, "deleting resource quota")
+			return rqs.Delete(ctx, guardrailsResourceQuotaName, metav1.DeleteOptions{})
+		}
+		return nil
+	}
+	hard, err := resourceList(rqc.Hard)
+	if err != nil {
+		return err
+	}
+	rq := &corev1.ResourceQuota{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      guardrailsResourceQuotaName,
+			Namespace: ns,
+			Labels:    map[string]string{objLabelKey: objLabelValue},
+		},
+		Spec: corev1.ResourceQuotaSpec{Hard: hard},
+	}
+	if exists {
+		ci.log(ctx, "updating resource quota")
+		rq.ResourceVersion = existing.ResourceVersion
+		_, err = rqs.Update(ctx, rq, metav1.UpdateOptions{})
+		return err
+	}
+	ci.log(ctx, "creating resource quota")
+	_, err = rqs.Create(ctx, rq, metav1.CreateOptions{})
+	return err
+}
+
+func (ci ChartInstaller) applyLimitRange(ctx context.Context, ns string, lrc *models.LimitRangeConfig) error {
+	lrs := ci.kc.CoreV1().LimitRanges(ns)
+	existing, err := lrs.Get(ctx, guardrailsLimitRangeName, metav1.GetOptions{})
+	if err != nil && !k8serrors.IsNotFound(err) {
+		return fmt.Errorf("error getting limit range: %w", err)
+	}
+	exists := err == nil
+	if lrc == nil {
+		if exists {
+			ci.log(ctx, "deleting limit range")
+			return lrs.Delete(ctx, guardrailsLimitRangeName, metav1.DeleteOptions{})
+		}
+		return nil
+	}
+	item := corev1.LimitRangeItem{Type: corev1.LimitTypeContainer}
+	if item.Default, err = resourceList(lrc.Default); err != nil {
+		return err
+	}
+	if item.DefaultRequest, err = resourceList(lrc.DefaultRequest); err != nil {
+		return err
+	}
+	if item.Max, err = resourceList(lrc.Max); err != nil {
+		return err
+	}
+	lr := &corev1.LimitRange{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      guardrailsLimitRangeName,
+			Namespace: ns,
+			Labels:    map[string]string{objLabelKey: objLabelValue},
+		},
+		Spec: corev1.LimitRangeSpec{Limits: []corev1.LimitRangeItem{item}},
+	}
+	if exists {
+		ci.log(ctx, "updating limit range")
+		lr.ResourceVersion = existing.ResourceVersion
+		_, err = lrs.Update(ctx, lr, metav1.UpdateOptions{})
+		return err
+	}
+	ci.log(ctx, "creating limit range")
+	_, err = lrs.Create(ctx, lr, metav1.CreateOptions{})
+	return err
+}
+
+// networkPolicy returns a default-deny network policy that allows traffic within the namespace, DNS egress and the allowlisted namespaces and CIDRs
+func networkPolicy(ns string, npc models.NetworkPolicyConfig) *networkingv1.NetworkPolicy {
+	samens := networkingv1.NetworkPolicyPeer{PodSelector: &metav1.LabelSelector{}}
+	ingress := networkingv1.NetworkPolicyIngressRule{From: []networkingv1.NetworkPolicyPeer{samens}}
+	ins := append([]string{}, npc.AllowIngressNamespaces...)
+	sort.Strings(ins)
+	for _, n := range ins {
+		ingress.From = append(ingress.From, networkingv1.NetworkPolicyPeer{
+			NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"kubernetes.io/metadata.name": n}},
+		})
+	}
+	udp, tcp := corev1.ProtocolUDP, corev1.ProtocolTCP
+	dnsport := intstr.FromInt(53)
+	egress := []networkingv1.NetworkPolicyEgressRule{
+		networkingv1.NetworkPolicyEgressRule{To: []networkingv1.NetworkPolicyPeer{samens}},
+		networkingv1.NetworkPolicyEgressRule{
+			To: []networkingv1.NetworkPolicyPeer{networkingv1.NetworkPolicyPeer{NamespaceSelector: &metav1.LabelSelector{}}},
+			Ports: []networkingv1.NetworkPolicyPort{
+				networkingv1.NetworkPolicyPort{Protocol: &udp, Port: &dnsport},
+				networkingv1.NetworkPolicyPort{Protocol: &tcp, Port: &dnsport},
+			},
+		},
+	}
+	if len(npc.AllowEgressCIDRs) > 0 {
+		cidrs := networkingv1.NetworkPolicyEgressRule{}
+		for _, c := range npc.AllowEgressCIDRs {
+			cidrs.To = append(cidrs.To, networkingv1.NetworkPolicyPeer{IPBlock: &networkingv1.IPBlock{CIDR: c}})
+		}
+		egress = append(egress, cidrs)
+	}
+	return &networkingv1.NetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      guardrailsNetworkPolicyName,
+			Namespace: ns,
+			Labels:    map[string]string{objLabelKey: objLabelValue},
+		},
+		Spec: networkingv1.NetworkPolicySpec{
+			PodSelector: metav1.LabelSelector{},
+			PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress, networkingv1.PolicyTypeEgress},
+			Ingress:     []networkingv1.NetworkPolicyIngressRule{ingress},
+			Egress:      egress,
+		},
+	}
+}
+
+func (ci ChartInstaller) applyNetworkPolicy(ctx context.Context, ns string, npc *models.NetworkPolicyConfig) error {
+	nps := ci.kc.NetworkingV1().NetworkPolicies(ns)
+	existing, err := nps.Get(ctx, guardrailsNetworkPolicyName, metav1.GetOptions{})
+	if err != nil && !k8serrors.IsNotFound(err) {
+		return fmt.Errorf("error getting network policy: %w", err)
+	}
+	exists := err == nil
+	if npc == nil {
+		if exists {
+			ci.log(ctx, "deleting network policy")
+			return nps.Delete(ctx, guardrailsNetworkPolicyName, metav1.DeleteOptions{})
+		}
+		return nil
+	}
+	np := networkPolicy(ns, *npc)
+	if exists {
+		ci.log(ctx, "updating network policy")
+		np.ResourceVersion = existing.ResourceVersion
+		_, err = nps.Update(ctx, np, metav1.UpdateOptions{})
+		return err
+	}
+	ci.log(ctx, "creating network policy")
+	_, err = nps.Create(ctx, np, metav1.CreateOptions{})
+	return err
+}
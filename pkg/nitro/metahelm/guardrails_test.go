@@ -0,0 +1,262 @@
+package metahelm
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/dollarshaveclub/acyl/pkg/config"
+	"github.com/dollarshaveclub/acyl/pkg/models"
+	nitroerrors "github.com/dollarshaveclub/acyl/pkg/nitro/errors"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func boolPtr(b bool) *bool { return &b }
+
+func TestEffectiveNamespaceConfig(t *testing.T) {
+	ng := config.NamespaceGuardrails{
+		Defaults: models.NamespaceConfig{
+			ResourceQuota: &models.ResourceQuotaConfig{Hard: map[string]string{"requests.cpu": "4", "pods": "50"}},
+			LimitRange:    &models.LimitRangeConfig{DefaultRequest: map[string]string{"cpu": "100m"}},
+			NetworkPolicy: &models.NetworkPolicyConfig{Enabled: boolPtr(true), AllowIngressNamespaces: []string{"ingress-nginx"}},
+		},
+		Ceilings: config.NamespaceCeilings{
+			ResourceQuota:            map[string]string{"requests.cpu": "8"},
+			LimitRange:               map[string]string{"cpu": "2"},
+			AllowedIngressNamespaces: []string{"ingress-nginx", "monitoring"},
+			AllowedEgressCIDRs:       []string{"10.0.0.0/8", "fd00::/8"},
+		},
+	}
+	tests := []struct {
+		name         string
+		ng           config.NamespaceGuardrails
+		rnc          models.NamespaceConfig
+		untrusted    bool
+		verifyFunc   func(t *testing.T, nc models.NamespaceConfig)
+		wantWarnings int
+		wantUserErr  bool
+	}{
+		{
+			name: "empty",
+			verifyFunc: func(t *testing.T, nc models.NamespaceConfig) {
+				if nc.ResourceQuota != nil || nc.LimitRange != nil || nc.NetworkPolicy != nil {
+					t.Errorf("expected empty config: %+v", nc)
+				}
+			},
+		},
+		{
+			name: "defaults",
+			ng:   ng,
+			verifyFunc: func(t *testing.T, nc models.NamespaceConfig) {
+				if nc.ResourceQuota.Hard["requests.cpu"] != "4" || nc.ResourceQuota.Hard["pods"] != "50" {
+					t.Errorf("bad resource quota: %+v", nc.ResourceQuota)
+				}
+				if nc.LimitRange.DefaultRequest["cpu"] != "100m" {
+					t.Errorf("bad limit range: %+v", nc.LimitRange)
+				}
+				if !nc.NetworkPolicy.IsEnabled() {
+					t.Errorf("network policy should be enabled")
+				}
+			},
+		},
+		{
+			name: "overrides within and above ceilings",
+			ng:   ng,
+			rnc: models.NamespaceConfig{
+				ResourceQuota: &models.ResourceQuotaConfig{Hard: map[string]string{"requests.cpu": "16", "requests.memory": "32Gi"}},
+				LimitRange:    &models.LimitRangeConfig{Max: map[string]string{"cpu": "1500m"}, Default: map[string]string{"cpu": "4"}},
+				NetworkPolicy: &models.NetworkPolicyConfig{AllowEgressCIDRs: []string{"10.0.0.0/8"}},
+			},
+			wantWarnings: 2,
+			verifyFunc: func(t *testing.T, nc models.NamespaceConfig) {
+				if nc.ResourceQuota.Hard["requests.cpu"] != "8" || nc.ResourceQuota.Hard["requests.memory"] != "32Gi" || nc.ResourceQuota.Hard["pods"] != "50" {
+					t.Errorf("bad resource quota: %+v", nc.ResourceQuota)
+				}
+				if nc.LimitRange.Max["cpu"] != "1500m" || nc.LimitRange.Default["cpu"] != "2" || nc.LimitRange.DefaultRequest["cpu"] != "100m" {
+					t.Errorf("bad limit range: %+v", nc.LimitRange)
+				}
+				if len(nc.NetworkPolicy.AllowIngressNamespaces) != 1 || len(nc.NetworkPolicy.AllowEgressCIDRs) != 1 {
+					t.Errorf("bad network policy: %+v", nc.NetworkPolicy)
+				}
+			},
+		},
+		{
+			name: "disable network policy",
+			ng:   ng,
+			rnc:  models.NamespaceConfig{NetworkPolicy: &models.NetworkPolicyConfig{Enabled: boolPtr(false)}},
+			verifyFunc: func(t *testing.T, nc models.NamespaceConfig) {
+				if nc.NetworkPolicy != nil {
+					t.Errorf("network policy should be disabled")
+				}
+			},
+		},
+		{
+			name: "network policy required",
+			ng: config.NamespaceGuardrails{
+				Ceilings: config.NamespaceCeilings{NetworkPolicyRequired: true},
+			},
+			rnc:          models.NamespaceConfig{NetworkPolicy: &models.NetworkPolicyConfig{Enabled: boolPtr(false)}},
+			wantWarnings: 1,
+			verifyFunc: func(t *testing.T, nc models.NamespaceConfig) {
+				if !nc.NetworkPolicy.IsEnabled() {
+					t.Errorf("network policy should be enabled")
+				}
+			},
+		},
+		{
+			name:         "untrusted",
+			ng:           ng,
+			rnc:          models.NamespaceConfig{NetworkPolicy: &models.NetworkPolicyConfig{Enabled: boolPtr(false), AllowEgressCIDRs: []string{"0.0.0.0/0"}}},
+			untrusted:    true,
+			wantWarnings: 1,
+			verifyFunc: func(t *testing.T, nc models.NamespaceConfig) {
+				if !nc.NetworkPolicy.IsEnabled() || len(nc.NetworkPolicy.AllowEgressCIDRs) != 0 {
+					t.Errorf("bad network policy: %+v", nc.NetworkPolicy)
+				}
+			},
+		},
+		{
+			name:        "invalid quantity",
+			ng:          ng,
+			rnc:         models.NamespaceConfig{ResourceQuota: &models.ResourceQuotaConfig{Hard: map[string]string{"pods": "lots"}}},
+			wantUserErr: true,
+		},
+		{
+			name: "network policy exceptions within ceilings",
+			ng:   ng,
+			rnc:  models.NamespaceConfig{NetworkPolicy: &models.NetworkPolicyConfig{AllowIngressNamespaces: []string{"monitoring"}, AllowEgressCIDRs: []string{"10.1.0.0/16", "fd00:1::/32"}}},
+			verifyFunc: func(t *testing.T, nc models.NamespaceConfig) {
+				if len(nc.NetworkPolicy.AllowIngressNamespaces) != 2 || len(nc.NetworkPolicy.AllowEgressCIDRs) != 2 {
+					t.Errorf("bad network policy: %+v", nc.NetworkPolicy)
+				}
+			},
+		},
+		{
+			name:        "egress cidr outside ceilings",
+			ng:          ng,
+			rnc:         models.NamespaceConfig{NetworkPolicy: &models.NetworkPolicyConfig{AllowEgressCIDRs: []string{"0.0.0.0/0"}}},
+			wantUserErr: true,
+		},
+		{
+			name:        "egress cidr wider than ceiling",
+			ng:          ng,
+			rnc:         models.NamespaceConfig{NetworkPolicy: &models.NetworkPolicyConfig{AllowEgressCIDRs: []string{"10.0.0.0/7"}}},
+			wantUserErr: true,
+		},
+		{
+			name:        "ingress namespace outside ceilings",
+			ng:          ng,
+			rnc:         models.NamespaceConfig{NetworkPolicy: &models.NetworkPolicyConfig{AllowIngressNamespaces: []string{"kube-system"}}},
+			wantUserErr: true,
+		},
+		{
+			name: "no network policy ceilings",
+			ng: config.NamespaceGuardrails{
+				Defaults: models.NamespaceConfig{NetworkPolicy: &models.NetworkPolicyConfig{Enabled: boolPtr(true)}},
+			},
+			rnc:         models.NamespaceConfig{NetworkPolicy: &models.NetworkPolicyConfig{AllowEgressCIDRs: []string{"10.0.0.0/8"}}},
+			wantUserErr: true,
+		},
+		{
+			name:        "invalid cidr",
+			ng:          ng,
+			rnc:         models.NamespaceConfig{NetworkPolicy: &models.NetworkPolicyConfig{AllowEgressCIDRs: []string{"10.0.0.0"}}},
+			wantUserErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			nc, warnings, err := effectiveNamespaceConfig(tt.ng, tt.rnc, tt.untrusted)
+			if tt.wantUserErr {
+				if err == nil || !nitroerrors.IsUserError(err) {
+					t.Fatalf("expected user error: %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("should have succeeded: %v", err)
+			}
+			if len(warnings) != tt.wantWarnings {
+				t.Errorf("bad warnings: %v", warnings)
+			}
+			tt.verifyFunc(t, nc)
+		})
+	}
+}
+
+func TestApplyNamespaceGuardrails(t *testing.T) {
+	ns := "nitro-1234-foo-bar"
+	fkc := fake.NewSimpleClientset()
+	ci := ChartInstaller{
+		kc: fkc,
+		nsguardrails: config.NamespaceGuardrails{
+			Defaults: models.NamespaceConfig{
+				ResourceQuota: &models.ResourceQuotaConfig{Hard: map[string]string{"requests.cpu": "4"}},
+				LimitRange:    &models.LimitRangeConfig{Default: map[string]string{"memory": "512Mi"}},
+			},
+			Ceilings: config.NamespaceCeilings{
+				AllowedIngressNamespaces: []string{"ingress-nginx"},
+				AllowedEgressCIDRs:       []string{"10.0.0.0/8"},
+			},
+		},
+	}
+	env := &EnvInfo{
+		Env: &models.QAEnvironment{Name: "foo-bar"},
+		RC: &models.RepoConfig{
+			Namespace: models.NamespaceConfig{
+				NetworkPolicy: &models.NetworkPolicyConfig{Enabled: boolPtr(true), AllowIngressNamespaces: []string{"ingress-nginx"}, AllowEgressCIDRs: []string{"10.0.0.0/8"}},
+			},
+		},
+	}
+	ctx := context.Background()
+	if err := ci.applyNamespaceGuardrails(ctx, ns, env); err != nil {
+		t.Fatalf("should have succeeded: %v", err)
+	}
+	rq, err := fkc.CoreV1().ResourceQuotas(ns).Get(ctx, guardrailsResourceQuotaName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("error getting resource quota: %v", err)
+	}
+	if q := rq.Spec.Hard[corev1.ResourceName("requests.cpu")]; q.String() != "4" {
+		t.Errorf("bad resource quota: %v", rq.Spec.Hard)
+	}
+	lr, err := fkc.CoreV1().LimitRanges(ns).Get(ctx, guardrailsLimitRangeName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("error getting limit range: %v", err)
+	}
+	if q := lr.Spec.Limits[0].Default[corev1.ResourceMemory]; q.String() != "512Mi" || lr.Spec.Limits[0].Type != corev1.LimitTypeContainer {
+		t.Errorf("bad limit range: %+v", lr.Spec)
+	}
+	np, err := fkc.NetworkingV1().NetworkPolicies(ns).Get(ctx, guardrailsNetworkPolicyName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("error getting network policy: %v", err)
+	}
+	if len(np.Spec.PolicyTypes) != 2 || len(np.Spec.Ingress[0].From) != 2 || len(np.Spec.Egress) != 3 {
+		t.Errorf("bad network policy: %+v", np.Spec)
+	}
+	if np.Spec.Egress[2].To[0].IPBlock.CIDR != "10.0.0.0/8" || !strings.Contains(np.Spec.Ingress[0].From[1].NamespaceSelector.String(), "ingress-nginx") {
+		t.Errorf("bad network policy allowlists: %+v", np.Spec)
+	}
+
+	// reapply with changes
+	env.RC.Namespace = models.NamespaceConfig{ResourceQuota: &models.ResourceQuotaConfig{Hard: map[string]string{"requests.cpu": "2"}}}
+	ci.nsguardrails.Defaults.LimitRange = nil
+	if err := ci.applyNamespaceGuardrails(ctx, ns, env); err != nil {
+		t.Fatalf("reapply should have succeeded: %v", err)
+	}
+	rq, err = fkc.CoreV1().ResourceQuotas(ns).Get(ctx, guardrailsResourceQuotaName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("error getting resource quota: %v", err)
+	}
+	if q := rq.Spec.Hard[corev1.ResourceName("requests.cpu")]; q.String() != "2" {
+		t.Errorf("resource quota should have been updated: %v", rq.Spec.Hard)
+	}
+	if _, err := fkc.CoreV1().LimitRanges(ns).Get(ctx, guardrailsLimitRangeName, metav1.GetOptions{}); !k8serrors.IsNotFound(err) {
+		t.Errorf("limit range should have been deleted: %v", err)
+	}
+	if _, err := fkc.NetworkingV1().NetworkPolicies(ns).Get(ctx, guardrailsNetworkPolicyName, metav1.GetOptions{}); !k8serrors.IsNotFound(err) {
+		t.Errorf("network policy should have been deleted: %v", err)
+	}
+}
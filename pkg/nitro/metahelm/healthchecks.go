@@ -0,0 +1,345 @@
+package metahelm
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/dollarshaveclub/acyl/pkg/eventlogger"
+	"github.com/dollarshaveclub/acyl/pkg/models"
+	nitroerrors "github.com/dollarshaveclub/acyl/pkg/nitro/errors"
+	multierror "github.com/hashicorp/go-multierror"
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/remotecommand"
+)
+
+// maxHealthCheckOutput is the maximum length of command output included in exec health check errors
+const maxHealthCheckOutput = 1024
+
+// healthCheckClientTimeout bounds HTTP health check requests regardless of the per-attempt timeout in acyl.yml
+const healthCheckClientTimeout = 5 * time.Minute
+
+// HealthCheckTemplateData is the data available to health check URL and address templates
+type HealthCheckTemplateData struct {
+	EnvName      string
+	K8sNamespace string
+}
+
+// healthCheckRunner runs environment health checks
+type healthCheckRunner struct {
+	hc       *http.Client
+	dialf    func(ctx context.Context, network, addr string) (net.Conn, error)
+	execf    func(ctx context.Context, ns string, ehc models.ExecHealthCheck) error
+	servicef func(ctx context.Context, ns, name string) (*corev1.Service, error)
+	// proxyf is set for environments in remote clusters, whose services aren't reachable from the acyl pod.
+	// HTTP checks are proxied through the API server of the cluster instead and TCP checks aren't supported.
+	proxyf func(ctx context.Context, ns, svc string, u *url.URL) (int, error)
+}
+
+// RunHealthChecks runs the health checks declared in the environment config and records the results in the event status tree.
+// An error is returned if any health check fails. For environments in a remote cluster (one with its own credentials), HTTP checks are proxied
+// through the cluster API server and TCP checks fail, since cluster DNS names and service IPs are only reachable from within the home cluster.
+func (ci ChartInstaller) RunHealthChecks(ctx context.Context, env *EnvInfo) error {
+	hcs := env.RC.HealthChecks()
+	if len(hcs) == 0 {
+		return nil
+	}
+	k8senv, err := ci.dl.GetK8sEnv(ctx, env.Env.Name)
+	if err != nil {
+		return fmt.Errorf("error getting k8s environment: %w", err)
+	}
+	if k8senv == nil {
+		return errors.New("missing k8s environment")
+	}
+	if ci, err = ci.forCluster(k8senv.Cluster); err != nil {
+		return fmt.Errorf("error getting cluster for k8s environment: %w", err)
+	}
+	hcr := healthCheckRunner{
+		hc: &http.Client{
+			Timeout: healthCheckClientTimeout,
+			// redirects could point anywhere, so the redirect response is checked like any other
+			CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+		},
+		dialf: (&net.Dialer{}).DialContext,
+		execf: ci.execHealthCheck,
+		servicef: func(ctx context.Context, ns, name string) (*corev1.Service, error) {
+			return ci.kc.CoreV1().Services(ns).Get(ctx, name, metav1.GetOptions{})
+		},
+	}
+	if ci.isRemoteCluster(k8senv.Cluster) {
+		hcr.proxyf = ci.proxyHealthCheck
+	}
+	return hcr.run(ctx, hcs, HealthCheckTemplateData{EnvName: env.Env.Name, K8sNamespace: k8senv.Namespace})
+}
+
+// run runs all health checks concurrently (hcs is a map of status tree node name to health checks)
+func (hcr healthCheckRunner) run(ctx context.Context, hcs map[string][]models.HealthCheck, td HealthCheckTemplateData) error {
+	elog := eventlogger.GetLogger(ctx)
+	var mtx sync.Mutex
+	var merr *multierror.Error
+	var wg sync.WaitGroup
+	for node, checks := range hcs {
+		for _, hc := range checks {
+			wg.Add(1)
+			go func(node string, hc models.HealthCheck) {
+				defer wg.Done()
+				elog.SetHealthCheckStarted(node, hc.Name)
+				err := hcr.check(ctx, hc, td)
+				elog.SetHealthCheckCompleted(node, hc.Name, err)
+				if err != nil {
+					elog.Printf("health check failed: %v: %v: %v", node, hc.Name, err)
+					mtx.Lock()
+					merr = multierror.Append(merr, fmt.Errorf("%v: %v: %w", node, hc.Name, err))
+					mtx.Unlock()
+					return
+				}
+				elog.Printf("health check passed: %v: %v", node, hc.Name)
+			}(node, hc)
+		}
+	}
+	wg.Wait()
+	if err := merr.ErrorOrNil(); err != nil {
+		return nitroerrors.User(fmt.Errorf("health checks failed: %w", err))
+	}
+	return nil
+}
+
+// check runs hc until it passes or the maximum attempts are reached
+func (hcr healthCheckRunner) check(ctx context.Context, hc models.HealthCheck, td HealthCheckTemplateData) error {
+	if hc.Type() == "tcp" && hcr.proxyf != nil {
+		return errors.New("tcp health checks are not supported for environments in remote clusters (use an http or exec health check)")
+	}
+	var err error
+	for i := uint(0); i < hc.Attempts(); i++ {
+		if i > 0 {
+			select {
+			case <-ctx.Done():
+				return fmt.Errorf("context cancelled after %v attempts: %w", i, err)
+			case <-time.After(hc.Interval()):
+			}
+		}
+		actx, cf := context.WithTimeout(ctx, hc.Timeout())
+		err = hcr.attempt(actx, hc, td)
+		cf()
+		if err == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("failed after %v attempts: %w", hc.Attempts(), err)
+}
+
+// attempt runs hc once
+func (hcr healthCheckRunner) attempt(ctx context.Context, hc models.HealthCheck, td HealthCheckTemplateData) error {
+	switch hc.Type() {
+	case "http":
+		u, err := renderHealthCheckTemplate(hc.HTTP.URL, td)
+		if err != nil {
+			return fmt.Errorf("error rendering url: %w", err)
+		}
+		pu, err := url.Parse(u)
+		if err != nil {
+			return fmt.Errorf("malformed url: %w", err)
+		}
+		if pu.Scheme != "http" && pu.Scheme != "https" {
+			return fmt.Errorf("%v: unsupported scheme: %v", u, pu.Scheme)
+		}
+		if err := hcr.checkTarget(ctx, td.K8sNamespace, pu.Hostname()); err != nil {
+			return err
+		}
+		var code int
+		if hcr.proxyf != nil {
+			code, err = hcr.proxyf(ctx, td.K8sNamespace, strings.SplitN(pu.Hostname(), ".", 2)[0], pu)
+			if err != nil {
+				return fmt.Errorf("error performing request via the cluster API server: %w", err)
+			}
+		} else {
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+			if err != nil {
+				return fmt.Errorf("error creating request: %w", err)
+			}
+			req.Header.Set("User-Agent", "acyl-health-check")
+			resp, err := hcr.hc.Do(req)
+			if err != nil {
+				return fmt.Errorf("error performing request: %w", err)
+			}
+			io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 1024*1024))
+			resp.Body.Close()
+			code = resp.StatusCode
+		}
+		if hc.HTTP.ExpectedStatus != 0 {
+			if code != hc.HTTP.ExpectedStatus {
+				return fmt.Errorf("%v: unexpected status code: %v (expected %v)", u, code, hc.HTTP.ExpectedStatus)
+			}
+			return nil
+		}
+		if code < 200 || code > 299 {
+			return fmt.Errorf("%v: unexpected status code: %v", u, code)
+		}
+		return nil
+	case "tcp":
+		addr, err := renderHealthCheckTemplate(hc.TCP.Address, td)
+		if err != nil {
+			return fmt.Errorf("error rendering address: %w", err)
+		}
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			return fmt.Errorf("malformed address: %w", err)
+		}
+		if err := hcr.checkTarget(ctx, td.K8sNamespace, host); err != nil {
+			return err
+		}
+		conn, err := hcr.dialf(ctx, "tcp", addr)
+		if err != nil {
+			return fmt.Errorf("error connecting: %w", err)
+		}
+		conn.Close()
+		return nil
+	case "exec":
+		return hcr.execf(ctx, td.K8sNamespace, *hc.Exec)
+	default:
+		return fmt.Errorf("invalid health check")
+	}
+}
+
+// checkTarget returns an error unless host is the DNS name of a service in the environment namespace ns ("<service>.<ns>", optionally followed by ".svc" or ".svc.cluster.local").
+// Health checks come from acyl.yml, which may belong to an untrusted fork, so requests are never made to arbitrary hosts. ExternalName services and services without
+// a selector are refused as well, since they can point outside of the namespace.
+func (hcr healthCheckRunner) checkTarget(ctx context.Context, ns, host string) error {
+	parts := strings.SplitN(strings.TrimSuffix(strings.ToLower(host), "."), ".", 3)
+	if ns == "" || len(parts) < 2 || parts[1] != ns || (len(parts) == 3 && parts[2] != "svc" && parts[2] != "svc.cluster.local") {
+		return fmt.Errorf("%v is not a service in the environment namespace (expected <service>.%v.svc.cluster.local)", host, ns)
+	}
+	if hcr.servicef == nil {
+		return errors.New("service lookup is unavailable")
+	}
+	svc, err := hcr.servicef(ctx, ns, parts[0])
+	if err != nil {
+		return fmt.Errorf("error getting service %v: %w", parts[0], err)
+	}
+	if svc.Spec.Type == corev1.ServiceTypeExternalName || len(svc.Spec.Selector) == 0 {
+		return fmt.Errorf("service %v must have a selector and can't be an ExternalName service", parts[0])
+	}
+	return nil
+}
+
+// proxyHealthCheck performs a GET request for u to service svc in namespace ns through the API server service proxy, returning the response status code
+// As with direct requests, redirects are not followed.
+func (ci ChartInstaller) proxyHealthCheck(ctx context.Context, ns, svc string, u *url.URL) (int, error) {
+	if ci.rcfg == nil {
+		return 0, errors.New("missing k8s rest config")
+	}
+	rt, err := rest.TransportFor(ci.rcfg)
+	if err != nil {
+		return 0, fmt.Errorf("error getting k8s transport: %w", err)
+	}
+	hc := &http.Client{
+		Transport:     rt,
+		Timeout:       healthCheckClientTimeout,
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+	port := u.Port()
+	if port == "" {
+		port = "80"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+	}
+	name := svc + ":" + port
+	if u.Scheme == "https" {
+		name = "https:" + name
+	}
+	pu := ci.kc.CoreV1().RESTClient().Get().
+		Namespace(ns).
+		Resource("services").
+		Name(name).
+		SubResource("proxy").
+		Suffix(strings.TrimPrefix(u.Path, "/")).
+		URL()
+	pu.RawQuery = u.RawQuery
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, pu.String(), nil)
+	if err != nil {
+		return 0, fmt.Errorf("error creating request: %w", err)
+	}
+	req.Header.Set("User-Agent", "acyl-health-check")
+	resp, err := hc.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 1024*1024))
+	return resp.StatusCode, nil
+}
+
+func renderHealthCheckTemplate(s string, td HealthCheckTemplateData) (string, error) {
+	tmpl, err := template.New("health-check").Parse(s)
+	if err != nil {
+		return "", fmt.Errorf("error parsing template: %w", err)
+	}
+	b := &strings.Builder{}
+	if err := tmpl.Execute(b, &td); err != nil {
+		return "", fmt.Errorf("error executing template: %w", err)
+	}
+	return b.String(), nil
+}
+
+// execHealthCheck runs the command for ehc in the first running pod matching the selector in namespace ns
+func (ci ChartInstaller) execHealthCheck(ctx context.Context, ns string, ehc models.ExecHealthCheck) error {
+	if ci.rcfg == nil {
+		return errors.New("exec health checks are not available (missing k8s rest config)")
+	}
+	pl, err := ci.kc.CoreV1().Pods(ns).List(ctx, metav1.ListOptions{LabelSelector: ehc.Selector})
+	if err != nil {
+		return fmt.Errorf("error listing pods: %w", err)
+	}
+	var pod *corev1.Pod
+	for i := range pl.Items {
+		if pl.Items[i].Status.Phase == corev1.PodRunning {
+			pod = &pl.Items[i]
+			break
+		}
+	}
+	if pod == nil {
+		return fmt.Errorf("no running pods found for selector: %v", ehc.Selector)
+	}
+	container := ehc.Container
+	if container == "" && len(pod.Spec.Containers) > 0 {
+		container = pod.Spec.Containers[0].Name
+	}
+	req := ci.kc.CoreV1().RESTClient().Post().
+		Resource("pods").
+		Namespace(ns).
+		Name(pod.Name).
+		SubResource("exec").
+		VersionedParams(&corev1.PodExecOptions{
+			Container: container,
+			Command:   ehc.Command,
+			Stdout:    true,
+			Stderr:    true,
+		}, scheme.ParameterCodec)
+	exec, err := remotecommand.NewSPDYExecutor(ci.rcfg, http.MethodPost, req.URL())
+	if err != nil {
+		return fmt.Errorf("error creating executor: %w", err)
+	}
+	out := &bytes.Buffer{}
+	if err := exec.StreamWithContext(ctx, remotecommand.StreamOptions{Stdout: out, Stderr: out}); err != nil {
+		output := out.String()
+		if len(output) > maxHealthCheckOutput {
+			output = output[len(output)-maxHealthCheckOutput:]
+		}
+		return fmt.Errorf("command failed in pod %v: %w: %v", pod.Name, err, strings.TrimSpace(output))
+	}
+	return nil
+}
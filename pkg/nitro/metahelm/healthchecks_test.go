@@ -0,0 +1,273 @@
+package metahelm
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/dollarshaveclub/acyl/pkg/eventlogger"
+	"github.com/dollarshaveclub/acyl/pkg/models"
+	nitroerrors "github.com/dollarshaveclub/acyl/pkg/nitro/errors"
+	"github.com/dollarshaveclub/acyl/pkg/persistence"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/rest"
+)
+
+func TestHealthCheckRunnerCheck(t *testing.T) {
+	var reqs uint32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/nitro-1234-foo-bar/healthz" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		// fail the first attempt
+		if atomic.AddUint32(&reqs, 1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error listening: %v", err)
+	}
+	defer l.Close()
+	_, tcpport, _ := net.SplitHostPort(l.Addr().String())
+	_, httpport, _ := net.SplitHostPort(strings.TrimPrefix(srv.URL, "http://"))
+	// service DNS names are resolved to the local listeners
+	dialf := func(ctx context.Context, network, addr string) (net.Conn, error) {
+		_, port, _ := net.SplitHostPort(addr)
+		return (&net.Dialer{}).DialContext(ctx, network, "127.0.0.1:"+port)
+	}
+	ns := "nitro-1234-foo-bar"
+	fkc := fake.NewSimpleClientset(
+		&corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: ns}, Spec: corev1.ServiceSpec{Selector: map[string]string{"app": "web"}}},
+		&corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "db", Namespace: ns}, Spec: corev1.ServiceSpec{Selector: map[string]string{"app": "db"}}},
+		&corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "metadata", Namespace: ns}, Spec: corev1.ServiceSpec{Type: corev1.ServiceTypeExternalName, ExternalName: "metadata.google.internal"}},
+		&corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "manual", Namespace: ns}},
+		&corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "nitro-5678-other"}, Spec: corev1.ServiceSpec{Selector: map[string]string{"app": "web"}}},
+	)
+	hcr := healthCheckRunner{
+		hc:    &http.Client{Transport: &http.Transport{DialContext: dialf}},
+		dialf: dialf,
+		servicef: func(ctx context.Context, ns, name string) (*corev1.Service, error) {
+			return fkc.CoreV1().Services(ns).Get(ctx, name, metav1.GetOptions{})
+		},
+		execf: func(ctx context.Context, ns string, ehc models.ExecHealthCheck) error {
+			if ns != "nitro-1234-foo-bar" {
+				return fmt.Errorf("bad namespace: %v", ns)
+			}
+			if ehc.Command[0] != "true" {
+				return fmt.Errorf("command failed")
+			}
+			return nil
+		},
+	}
+	td := HealthCheckTemplateData{EnvName: "foo-bar", K8sNamespace: ns}
+	tests := []struct {
+		name        string
+		hc          models.HealthCheck
+		wantErr     bool
+		errContains string
+	}{
+		{
+			name: "http retry",
+			hc:   models.HealthCheck{Name: "web", HTTP: &models.HTTPHealthCheck{URL: "http://web.{{ .K8sNamespace }}.svc.cluster.local:" + httpport + "/{{ .K8sNamespace }}/healthz"}, Retries: 1, IntervalSeconds: 1},
+		},
+		{
+			name:        "http expected status",
+			hc:          models.HealthCheck{Name: "web", HTTP: &models.HTTPHealthCheck{URL: "http://web.{{ .K8sNamespace }}:" + httpport + "/{{ .EnvName }}", ExpectedStatus: 200}, Retries: 1, IntervalSeconds: 1},
+			wantErr:     true,
+			errContains: "unexpected status code: 404 (expected 200)",
+		},
+		{
+			name: "tcp",
+			hc:   models.HealthCheck{Name: "db", TCP: &models.TCPHealthCheck{Address: "db.{{ .K8sNamespace }}.svc:" + tcpport}},
+		},
+		{
+			name:        "ip address",
+			hc:          models.HealthCheck{Name: "web", HTTP: &models.HTTPHealthCheck{URL: srv.URL}, Retries: 1, IntervalSeconds: 1},
+			wantErr:     true,
+			errContains: "is not a service in the environment namespace",
+		},
+		{
+			name:        "other namespace",
+			hc:          models.HealthCheck{Name: "web", HTTP: &models.HTTPHealthCheck{URL: "http://web.nitro-5678-other.svc:" + httpport}, Retries: 1, IntervalSeconds: 1},
+			wantErr:     true,
+			errContains: "is not a service in the environment namespace",
+		},
+		{
+			name:        "external name service",
+			hc:          models.HealthCheck{Name: "metadata", TCP: &models.TCPHealthCheck{Address: "metadata.{{ .K8sNamespace }}:80"}, Retries: 1, IntervalSeconds: 1},
+			wantErr:     true,
+			errContains: "can't be an ExternalName service",
+		},
+		{
+			name:        "service without selector",
+			hc:          models.HealthCheck{Name: "manual", TCP: &models.TCPHealthCheck{Address: "manual.{{ .K8sNamespace }}:" + tcpport}, Retries: 1, IntervalSeconds: 1},
+			wantErr:     true,
+			errContains: "must have a selector",
+		},
+		{
+			name:        "missing service",
+			hc:          models.HealthCheck{Name: "missing", TCP: &models.TCPHealthCheck{Address: "missing.{{ .K8sNamespace }}:" + tcpport}, Retries: 1, IntervalSeconds: 1},
+			wantErr:     true,
+			errContains: "error getting service missing",
+		},
+		{
+			name:    "bad template",
+			hc:      models.HealthCheck{Name: "db", TCP: &models.TCPHealthCheck{Address: "{{ .Foo }}"}, Retries: 1, IntervalSeconds: 1},
+			wantErr: true,
+		},
+		{
+			name: "exec",
+			hc:   models.HealthCheck{Name: "migrations", Exec: &models.ExecHealthCheck{Selector: "app=web", Command: []string{"true"}}},
+		},
+		{
+			name:        "exec failure",
+			hc:          models.HealthCheck{Name: "migrations", Exec: &models.ExecHealthCheck{Selector: "app=web", Command: []string{"false"}}, Retries: 1, IntervalSeconds: 1},
+			wantErr:     true,
+			errContains: "failed after 2 attempts",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := hcr.check(context.Background(), tt.hc, td)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("unexpected error result: %v (wanted error: %v)", err, tt.wantErr)
+			}
+			if err != nil && !strings.Contains(err.Error(), tt.errContains) {
+				t.Fatalf("error missing %q: %v", tt.errContains, err)
+			}
+		})
+	}
+}
+
+func TestHealthCheckRunnerRun(t *testing.T) {
+	dl := persistence.NewFakeDataLayer()
+	el := &eventlogger.Logger{DL: dl}
+	el.Init([]byte{}, "acme/something", 99)
+	rc := &models.RepoConfig{
+		Application: models.RepoConfigAppMetadata{
+			Repo:         "acme/something",
+			Ref:          "asdf",
+			Branch:       "master",
+			HealthChecks: []models.HealthCheck{models.HealthCheck{Name: "ok", Exec: &models.ExecHealthCheck{Selector: "app=something", Command: []string{"true"}}}},
+		},
+		Dependencies: models.DependencyDeclaration{
+			Direct: []models.RepoConfigDependency{
+				models.RepoConfigDependency{
+					Name:         "dep",
+					HealthChecks: []models.HealthCheck{models.HealthCheck{Name: "broken", Exec: &models.ExecHealthCheck{Selector: "app=dep", Command: []string{"false"}}, Retries: 1, IntervalSeconds: 1}},
+				},
+			},
+		},
+	}
+	el.SetNewStatus(models.CreateEvent, "foo-bar", models.RepoRevisionData{Repo: "acme/something"})
+	el.SetInitialStatus(rc, 0)
+	ctx := eventlogger.NewEventLoggerContext(context.Background(), el)
+	hcr := healthCheckRunner{
+		execf: func(ctx context.Context, ns string, ehc models.ExecHealthCheck) error {
+			if ehc.Command[0] != "true" {
+				return fmt.Errorf("command failed")
+			}
+			return nil
+		},
+	}
+	err := hcr.run(ctx, rc.HealthChecks(), HealthCheckTemplateData{EnvName: "foo-bar", K8sNamespace: "nitro-1234-foo-bar"})
+	if err == nil {
+		t.Fatalf("should have failed")
+	}
+	if !nitroerrors.IsUserError(err) {
+		t.Errorf("should have been a user error: %v", err)
+	}
+	if !strings.Contains(err.Error(), "dep: broken") || strings.Contains(err.Error(), "acme-something: ok") {
+		t.Errorf("unexpected error: %v", err)
+	}
+	es, err := dl.GetEventStatus(el.ID)
+	if err != nil {
+		t.Fatalf("error getting event status: %v", err)
+	}
+	if hc := es.Tree["acme-something"].HealthChecks["ok"]; hc.Completed.IsZero() || hc.Error {
+		t.Errorf("bad ok health check status: %+v", hc)
+	}
+	if hc := es.Tree["dep"].HealthChecks["broken"]; hc.Completed.IsZero() || !hc.Error || !strings.Contains(hc.Message, "command failed") {
+		t.Errorf("bad broken health check status: %+v", hc)
+	}
+}
+
+func TestHealthCheckRunnerRemoteCluster(t *testing.T) {
+	ns := "nitro-1234-foo-bar"
+	fkc := fake.NewSimpleClientset(
+		&corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: ns}, Spec: corev1.ServiceSpec{Selector: map[string]string{"app": "web"}}},
+		&corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "db", Namespace: ns}, Spec: corev1.ServiceSpec{Selector: map[string]string{"app": "db"}}},
+	)
+	var proxied []string
+	hcr := healthCheckRunner{
+		// service DNS names of remote clusters aren't reachable, so direct connections must not be made
+		hc: &http.Client{Transport: &http.Transport{DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			t.Errorf("http check should have been proxied: %v", addr)
+			return nil, fmt.Errorf("unreachable")
+		}}},
+		dialf: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			t.Errorf("tcp check should not have been attempted: %v", addr)
+			return nil, fmt.Errorf("unreachable")
+		},
+		servicef: func(ctx context.Context, ns, name string) (*corev1.Service, error) {
+			return fkc.CoreV1().Services(ns).Get(ctx, name, metav1.GetOptions{})
+		},
+		proxyf: func(ctx context.Context, ns, svc string, u *url.URL) (int, error) {
+			proxied = append(proxied, ns+"/"+svc+u.Path)
+			return http.StatusOK, nil
+		},
+	}
+	td := HealthCheckTemplateData{EnvName: "foo-bar", K8sNamespace: ns}
+	hc := models.HealthCheck{Name: "web", HTTP: &models.HTTPHealthCheck{URL: "http://web.{{ .K8sNamespace }}.svc.cluster.local:8080/healthz"}}
+	if err := hcr.check(context.Background(), hc, td); err != nil {
+		t.Fatalf("http check should have succeeded: %v", err)
+	}
+	if len(proxied) != 1 || proxied[0] != ns+"/web/healthz" {
+		t.Fatalf("bad proxied requests: %v", proxied)
+	}
+	hc = models.HealthCheck{Name: "db", TCP: &models.TCPHealthCheck{Address: "db.{{ .K8sNamespace }}.svc:5432"}}
+	if err := hcr.check(context.Background(), hc, td); err == nil || !strings.Contains(err.Error(), "not supported for environments in remote clusters") {
+		t.Fatalf("tcp check should have failed: %v", err)
+	}
+}
+
+func TestProxyHealthCheck(t *testing.T) {
+	var path, query string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path, query = r.URL.Path, r.URL.RawQuery
+		// redirects are returned rather than followed
+		http.Redirect(w, r, "http://169.254.169.254/", http.StatusFound)
+	}))
+	defer srv.Close()
+	rcfg := &rest.Config{Host: srv.URL}
+	kc, err := kubernetes.NewForConfig(rcfg)
+	if err != nil {
+		t.Fatalf("error getting clientset: %v", err)
+	}
+	ci := ChartInstaller{kc: kc, rcfg: rcfg}
+	u, _ := url.Parse("https://web.nitro-foo.svc.cluster.local/healthz?verbose=1")
+	code, err := ci.proxyHealthCheck(context.Background(), "nitro-foo", "web", u)
+	if err != nil {
+		t.Fatalf("should have succeeded: %v", err)
+	}
+	if code != http.StatusFound {
+		t.Fatalf("bad status code: %v", code)
+	}
+	if path != "/api/v1/namespaces/nitro-foo/services/https:web:443/proxy/healthz" || query != "verbose=1" {
+		t.Fatalf("bad proxy request: %v?%v", path, query)
+	}
+}
@@ -8,7 +8,9 @@ import (
 	"io"
 	"math/big"
 	"net/http"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	kubernetestrace "gopkg.in/DataDog/dd-trace-go.v1/contrib/k8s.io/client-go/kubernetes"
@@ -59,7 +61,12 @@ type Installer interface {
 	BuildAndInstallCharts(ctx context.Context, newenv *EnvInfo, cl ChartLocations) error
 	BuildAndInstallChartsIntoExisting(ctx context.Context, newenv *EnvInfo, k8senv *models.KubernetesEnvironment, cl ChartLocations) error
 	BuildAndUpgradeCharts(ctx context.Context, env *EnvInfo, k8senv *models.KubernetesEnvironment, cl ChartLocations) error
+	BuildAndUpdateChartsIncrementally(ctx context.Context, env *EnvInfo, k8senv *models.KubernetesEnvironment, cl ChartLocations, diff models.DependencyDiff) error
 	DeleteNamespace(ctx context.Context, k8senv *models.KubernetesEnvironment) error
+	SuspendNamespace(ctx context.Context, k8senv *models.KubernetesEnvironment) error
+	ResumeNamespace(ctx context.Context, k8senv *models.KubernetesEnvironment) error
+	RunHealthChecks(ctx context.Context, env *EnvInfo) error
+	RunTests(ctx context.Context, env *EnvInfo) error
 }
 
 // KubernetesReporter describes an object that returns k8s environment data
@@ -95,6 +102,11 @@ type ChartInstaller struct {
 	k8sgroupbindings map[string]string
 	k8srepowhitelist []string
 	k8ssecretinjs    map[string]config.K8sSecret
+	nsguardrails     config.NamespaceGuardrails
+	rbacprofiles     config.RBACProfiles
+	clusters         config.K8sClusters
+	clusterclients   map[string]clusterClient
+	placemtx         *sync.Mutex
 	mhmf             MetahelmManagerFactoryFunc
 	hccfg            config.HelmClientConfig
 }
@@ -102,32 +114,29 @@ type ChartInstaller struct {
 var _ Installer = &ChartInstaller{}
 
 // NewChartInstaller returns a ChartInstaller configured with an in-cluster K8s clientset
-func NewChartInstaller(ib images.Builder, dl persistence.DataLayer, fs billy.Filesystem, mc metrics.Collector, k8sGroupBindings map[string]string, k8sRepoWhitelist []string, k8sSecretInjs map[string]config.K8sSecret, k8sJWTPath string, enableK8sTracing bool, hccfg config.HelmClientConfig) (*ChartInstaller, error) {
+func NewChartInstaller(ib images.Builder, dl persistence.DataLayer, fs billy.Filesystem, mc metrics.Collector, k8scfg config.K8sConfig, k8sJWTPath string, enableK8sTracing bool, hccfg config.HelmClientConfig) (*ChartInstaller, error) {
 	kc, rcfg, err := NewInClusterK8sClientset(k8sJWTPath, enableK8sTracing)
 	if err != nil {
 		return nil, fmt.Errorf("error getting k8s client: %w", err)
 	}
-	return &ChartInstaller{
-		ib:               ib,
-		kc:               kc,
-		rcfg:             rcfg,
-		dl:               dl,
-		fs:               fs,
-		mc:               mc,
-		k8sgroupbindings: k8sGroupBindings,
-		k8srepowhitelist: k8sRepoWhitelist,
-		k8ssecretinjs:    k8sSecretInjs,
-		mhmf:             NewInClusterHelmConfiguration,
-		hccfg:            hccfg,
-	}, nil
+	return newChartInstaller(ib, dl, fs, mc, kc, rcfg, k8scfg, hccfg)
 }
 
 // NewChartInstallerWithClientsetFromContext returns a ChartInstaller configured with a K8s clientset from the current kubeconfig context
-func NewChartInstallerWithClientsetFromContext(ib images.Builder, dl persistence.DataLayer, fs billy.Filesystem, mc metrics.Collector, k8sGroupBindings map[string]string, k8sRepoWhitelist []string, k8sSecretInjs map[string]config.K8sSecret, kubeconfigpath string, hccfg config.HelmClientConfig) (*ChartInstaller, error) {
+func NewChartInstallerWithClientsetFromContext(ib images.Builder, dl persistence.DataLayer, fs billy.Filesystem, mc metrics.Collector, k8scfg config.K8sConfig, kubeconfigpath string, hccfg config.HelmClientConfig) (*ChartInstaller, error) {
 	kc, rcfg, err := NewKubecfgContextK8sClientset(kubeconfigpath, hccfg.KubeContext)
 	if err != nil {
 		return nil, fmt.Errorf("error getting k8s client: %w", err)
 	}
+	return newChartInstaller(ib, dl, fs, mc, kc, rcfg, k8scfg, hccfg)
+}
+
+// newChartInstaller returns a ChartInstaller using the default cluster clientset kc and the environment settings in k8scfg
+func newChartInstaller(ib images.Builder, dl persistence.DataLayer, fs billy.Filesystem, mc metrics.Collector, kc kubernetes.Interface, rcfg *rest.Config, k8scfg config.K8sConfig, hccfg config.HelmClientConfig) (*ChartInstaller, error) {
+	ccs, err := newClusterClients(kc, rcfg, k8scfg.Clusters)
+	if err != nil {
+		return nil, fmt.Errorf("error getting cluster clients: %w", err)
+	}
 	return &ChartInstaller{
 		ib:               ib,
 		kc:               kc,
@@ -135,9 +144,14 @@ func NewChartInstallerWithClientsetFromContext(ib images.Builder, dl persistence
 		dl:               dl,
 		fs:               fs,
 		mc:               mc,
-		k8sgroupbindings: k8sGroupBindings,
-		k8srepowhitelist: k8sRepoWhitelist,
-		k8ssecretinjs:    k8sSecretInjs,
+		k8sgroupbindings: k8scfg.GroupBindings,
+		k8srepowhitelist: k8scfg.PrivilegedRepoWhitelist,
+		k8ssecretinjs:    k8scfg.SecretInjections,
+		nsguardrails:     k8scfg.NamespaceGuardrails,
+		rbacprofiles:     k8scfg.RBACProfiles,
+		clusters:         k8scfg.Clusters,
+		clusterclients:   ccs,
+		placemtx:         &sync.Mutex{},
 		mhmf:             NewInClusterHelmConfiguration,
 		hccfg:            hccfg,
 	}, nil
@@ -232,9 +246,12 @@ type restClientGetter struct {
 
 var _ genericclioptions.RESTClientGetter = &restClientGetter{}
 
-func newRestClientGetter(namespace, kctx string) (*restClientGetter, error) {
+func newRestClientGetter(namespace, kubecfgpath, kctx string) (*restClientGetter, error) {
 	rules := clientcmd.NewDefaultClientConfigLoadingRules()
 	rules.DefaultClientConfig = &clientcmd.DefaultClientConfig
+	if kubecfgpath != "" {
+		rules.ExplicitPath = kubecfgpath
+	}
 	overrides := &clientcmd.ConfigOverrides{ClusterDefaults: clientcmd.ClusterDefaults}
 	if kctx != "" {
 		overrides.CurrentContext = kctx
@@ -268,7 +285,7 @@ func NewInClusterHelmConfiguration(ctx context.Context, kc kubernetes.Interface,
 	if hccfg.HelmDriver == "" {
 		hccfg.HelmDriver = DefaultHelmDriver
 	}
-	getter, err := newRestClientGetter(namespace, hccfg.KubeContext)
+	getter, err := newRestClientGetter(namespace, hccfg.KubeconfigPath, hccfg.KubeContext)
 	if err != nil {
 		return nil, fmt.Errorf("error getting kube client: %w", err)
 	}
@@ -335,6 +352,11 @@ func (ci ChartInstaller) BuildAndInstallChartsIntoExisting(ctx context.Context,
 
 func (ci ChartInstaller) installOrUpgradeIntoExisting(ctx context.Context, env *EnvInfo, k8senv *models.KubernetesEnvironment, cl ChartLocations, upgrade bool) (err error) {
 	span, ctx := tracer.StartSpanFromContext(ctx, "chart_installer.install_or_upgrade")
+	if k8senv != nil {
+		if ci, err = ci.forCluster(k8senv.Cluster); err != nil {
+			return fmt.Errorf("error getting cluster for k8s environment: %w", err)
+		}
+	}
 	if ci.kc == nil {
 		return errors.New("k8s client is nil")
 	}
@@ -342,7 +364,7 @@ func (ci ChartInstaller) installOrUpgradeIntoExisting(ctx context.Context, env *
 	defer func() {
 		if err != nil {
 			// clean up namespace on error
-			err2 := ci.cleanUpNamespace(ctx, k8senv.Namespace, env.Env.Name, ci.isRepoPrivileged(env.Env.Repo))
+			err2 := ci.cleanUpNamespace(ctx, k8senv.Namespace, env.Env.Name, ci.hasClusterBinding(env))
 			if err2 != nil {
 				ci.log(ctx, "error cleaning up namespace: %v", err2)
 			}
@@ -350,8 +372,8 @@ func (ci ChartInstaller) installOrUpgradeIntoExisting(ctx context.Context, env *
 			span.Finish(tracer.WithError(err))
 			return
 		}
+		// success status is set by the caller once health checks pass
 		span.Finish()
-		ci.dl.SetQAEnvironmentStatus(tracer.ContextWithSpan(context.Background(), span), env.Env.Name, models.Success)
 	}()
 	csl, err := ci.GenerateCharts(ctx, k8senv.Namespace, env, cl)
 	if err != nil {
@@ -366,20 +388,206 @@ func (ci ChartInstaller) installOrUpgradeIntoExisting(ctx context.Context, env *
 		err = fmt.Errorf("no extant k8s environment for env: %v", env.Env.Name)
 		return err
 	}
+	if err = ci.applyNamespaceGuardrails(ctx, k8senv.Namespace, env); err != nil {
+		return fmt.Errorf("error applying namespace guardrails: %w", err)
+	}
+	if err = ci.applyRBAC(ctx, env.Env.Name, env.Env.Repo, env.RC.RBACProfile, k8senv.Namespace, env.Env.IsFork); err != nil {
+		return fmt.Errorf("error applying rbac profile: %w", err)
+	}
+	if err = ci.applySecrets(ctx, env.Env.Repo, k8senv.Namespace, env.RC.Secrets, env.Env.IsFork); err != nil {
+		return fmt.Errorf("error applying secrets: %w", err)
+	}
 	err = ci.installOrUpgradeCharts(ctx, k8senv.Namespace, csl, env, b, upgrade)
 	return err
 }
 
+// BuildAndUpdateChartsIncrementally builds images for the environment and updates the existing namespace according to diff without tearing it down:
+// releases for removed or changed dependencies are uninstalled, new or changed dependencies are installed and unchanged dependencies (and the triggering repo) are upgraded in place.
+// env.Releases must contain the existing release names for the unchanged and removed dependencies.
+func (ci ChartInstaller) BuildAndUpdateChartsIncrementally(ctx context.Context, env *EnvInfo, k8senv *models.KubernetesEnvironment, cl ChartLocations, diff models.DependencyDiff) (err error) {
+	span, ctx := tracer.StartSpanFromContext(ctx, "chart_installer.update_incrementally")
+	if ci.kc == nil {
+		return errors.New("k8s client is nil")
+	}
+	if k8senv == nil {
+		return fmt.Errorf("no extant k8s environment for env: %v", env.Env.Name)
+	}
+	if ci, err = ci.forCluster(k8senv.Cluster); err != nil {
+		return fmt.Errorf("error getting cluster for k8s environment: %w", err)
+	}
+	ci.dl.SetQAEnvironmentStatus(tracer.ContextWithSpan(context.Background(), span), env.Env.Name, models.Updating)
+	defer func() {
+		if err != nil {
+			// clean up namespace on error
+			err2 := ci.cleanUpNamespace(ctx, k8senv.Namespace, env.Env.Name, ci.hasClusterBinding(env))
+			if err2 != nil {
+				ci.log(ctx, "error cleaning up namespace: %v", err2)
+			}
+			ci.dl.SetQAEnvironmentStatus(tracer.ContextWithSpan(context.Background(), span), env.Env.Name, models.Failure)
+			span.Finish(tracer.WithError(err))
+			return
+		}
+		// success status is set by the caller once health checks pass
+		span.Finish()
+	}()
+	csl, err := ci.GenerateCharts(ctx, k8senv.Namespace, env, cl)
+	if err != nil {
+		return fmt.Errorf("error generating metahelm charts: %w", err)
+	}
+	b, err := ci.ib.StartBuilds(ctx, env.Env.Name, env.RC)
+	if err != nil {
+		return fmt.Errorf("error starting image builds: %w", err)
+	}
+	defer b.Stop()
+	if err := ci.applyNamespaceGuardrails(ctx, k8senv.Namespace, env); err != nil {
+		return fmt.Errorf("error applying namespace guardrails: %w", err)
+	}
+	if err := ci.applyRBAC(ctx, env.Env.Name, env.Env.Repo, env.RC.RBACProfile, k8senv.Namespace, env.Env.IsFork); err != nil {
+		return fmt.Errorf("error applying rbac profile: %w", err)
+	}
+	if err := ci.applySecrets(ctx, env.Env.Repo, k8senv.Namespace, env.RC.Secrets, env.Env.IsFork); err != nil {
+		return fmt.Errorf("error applying secrets: %w", err)
+	}
+	if err := ci.updateChartsIncrementally(ctx, k8senv.Namespace, csl, env, b, diff); err != nil {
+		return err
+	}
+	return ci.updateK8sEnvironment(ctx, env)
+}
+
+// splitCharts partitions csl into the charts to install and to upgrade, removing any requirements that cross partitions
+// Requirements on charts in the upgrade partition are already satisfied since they're running, and charts to install are installed before any upgrades.
+func splitCharts(csl []metahelm.Chart, install []string) (ins, upg []metahelm.Chart) {
+	insm := map[string]struct{}{}
+	for _, n := range install {
+		insm[n] = struct{}{}
+	}
+	filterDeps := func(c metahelm.Chart, wantInstall bool) metahelm.Chart {
+		deps := []string{}
+		for _, d := range c.DependencyList {
+			if _, ok := insm[d]; ok == wantInstall {
+				deps = append(deps, d)
+			}
+		}
+		c.DependencyList = deps
+		return c
+	}
+	for _, c := range csl {
+		if _, ok := insm[c.Title]; ok {
+			ins = append(ins, filterDeps(c, true))
+			continue
+		}
+		upg = append(upg, filterDeps(c, false))
+	}
+	return ins, upg
+}
+
+func (ci ChartInstaller) updateChartsIncrementally(ctx context.Context, namespace string, csl []metahelm.Chart, env *EnvInfo, b images.Batch, diff models.DependencyDiff) error {
+	defer ci.mc.Timing(mpfx+"update_incrementally", "triggering_repo:"+env.Env.Repo)()
+	eventlogger.GetLogger(ctx).SetK8sNamespace(namespace)
+	mhm, err := ci.mhmf(ctx, ci.kc, ci.hccfg, namespace)
+	if err != nil || mhm == nil {
+		return fmt.Errorf("error getting helm client configuration: %w", err)
+	}
+	ctx, cf := context.WithTimeout(ctx, 30*time.Minute)
+	defer cf()
+	for _, name := range diff.Uninstall {
+		release, ok := env.Releases[name]
+		if !ok {
+			ci.log(ctx, "no release found for removed dependency, skipping uninstall: %v", name)
+			continue
+		}
+		ci.log(ctx, "uninstalling release for removed or changed dependency: %v (%v)", name, release)
+		if _, err := action.NewUninstall(mhm.HCfg).Run(release); err != nil {
+			return fmt.Errorf("error uninstalling release: %v: %w", release, err)
+		}
+	}
+	ins, upg := splitCharts(csl, diff.Install)
+	rm := metahelm.ReleaseMap{}
+	for _, c := range upg {
+		release, ok := env.Releases[c.Title]
+		if !ok {
+			return fmt.Errorf("release missing for unchanged chart: %v", c.Title)
+		}
+		rm[c.Title] = release
+	}
+	if len(ins) > 0 {
+		imageReady, builderr := ci.imageReadyCallback(ctx, env, b, false)
+		relmap, err := mhm.Install(ctx, ins, metahelm.WithK8sNamespace(namespace), metahelm.WithInstallCallback(imageReady), metahelm.WithCompletedCallback(func(c metahelm.Chart, err error) { completedCB(ctx, c, err) }), metahelm.WithTimeout(metahelmTimeout))
+		if err != nil {
+			if *builderr != nil {
+				return *builderr
+			}
+			if _, ok := err.(metahelm.ChartError); ok {
+				return err
+			}
+			return fmt.Errorf("error installing new metahelm charts: %w", nitroerrors.User(err))
+		}
+		for title, release := range relmap {
+			rm[title] = release
+		}
+		ci.dl.AddEvent(ctx, env.Env.Name, fmt.Sprintf("new charts installed; release names: %v", relmap))
+	}
+	imageReady, builderr := ci.imageReadyCallback(ctx, env, b, true)
+	if err := mhm.Upgrade(ctx, rm, upg, metahelm.WithK8sNamespace(namespace), metahelm.WithInstallCallback(imageReady), metahelm.WithCompletedCallback(func(c metahelm.Chart, err error) { completedCB(ctx, c, err) }), metahelm.WithTimeout(metahelmTimeout)); err != nil {
+		if *builderr != nil {
+			return *builderr
+		}
+		if _, ok := err.(metahelm.ChartError); ok {
+			return err
+		}
+		return fmt.Errorf("error upgrading metahelm charts: %w", err)
+	}
+	ci.dl.AddEvent(ctx, env.Env.Name, fmt.Sprintf("all charts updated; release names: %v", rm))
+	env.Releases = rm
+	if err := ci.writeReleaseNames(ctx, rm, namespace, env); err != nil {
+		return fmt.Errorf("error writing release names: %w", err)
+	}
+	return nil
+}
+
+// updateK8sEnvironment updates the repo config and signature of the existing k8s environment
+func (ci ChartInstaller) updateK8sEnvironment(ctx context.Context, env *EnvInfo) error {
+	rcy, err := yaml.Marshal(env.RC)
+	if err != nil {
+		return fmt.Errorf("error marshaling RepoConfig YAML: %w", err)
+	}
+	rm, err := env.RC.RefMap()
+	if err != nil {
+		return fmt.Errorf("error generating refmap from repoconfig: %w", err)
+	}
+	rmj, err := json.Marshal(rm)
+	if err != nil {
+		return fmt.Errorf("error marshaling RefMap JSON: %w", err)
+	}
+	if err := ci.dl.UpdateK8sEnvRepoConfig(ctx, env.Env.Name, rcy, string(rmj), env.RC.ConfigSignature()); err != nil {
+		return fmt.Errorf("error updating k8s environment: %w", err)
+	}
+	return nil
+}
+
 // overrideNamespace is used for testing purposes
 var overrideNamespace string
 
 // BuildAndInstallCharts builds images for the environment while simultaneously installing the associated helm charts, returning the k8s namespace or error
 func (ci ChartInstaller) BuildAndInstallCharts(ctx context.Context, newenv *EnvInfo, cl ChartLocations) (err error) {
+	// hold the placement lock until the k8s environment is written so concurrent creates see each other in the cluster counts
+	unlock := ci.lockPlacement()
+	defer unlock()
+	cluster, err := ci.placeEnv(ctx, newenv)
+	if err != nil {
+		return fmt.Errorf("error placing environment: %w", err)
+	}
+	if ci, err = ci.forCluster(cluster); err != nil {
+		return fmt.Errorf("error getting cluster: %w", err)
+	}
 	if ci.kc == nil {
 		return errors.New("k8s client is nil")
 	}
 	var ns string
 	if overrideNamespace == "" {
+		if cluster != "" {
+			ci.log(ctx, "placing environment in cluster: %v", cluster)
+		}
 		ns, err = ci.createNamespace(ctx, newenv.Env.Name)
 		if err != nil {
 			return fmt.Errorf("error creating namespace: %w", err)
@@ -390,18 +598,18 @@ func (ci ChartInstaller) BuildAndInstallCharts(ctx context.Context, newenv *EnvI
 	defer func() {
 		if err != nil {
 			// clean up namespace on error
-			err2 := ci.cleanUpNamespace(ctx, ns, newenv.Env.Name, ci.isRepoPrivileged(newenv.Env.Repo))
+			err2 := ci.cleanUpNamespace(ctx, ns, newenv.Env.Name, ci.hasClusterBinding(newenv))
 			if err2 != nil {
 				ci.log(ctx, "error cleaning up namespace: %v", err2)
 			}
 			ci.dl.SetQAEnvironmentStatus(context.Background(), newenv.Env.Name, models.Failure)
-		} else {
-			ci.dl.SetQAEnvironmentStatus(context.Background(), newenv.Env.Name, models.Success)
 		}
+		// success status is set by the caller once health checks pass
 	}()
-	if err := ci.writeK8sEnvironment(ctx, newenv, ns); err != nil {
+	if err := ci.writeK8sEnvironment(ctx, newenv, ns, cluster); err != nil {
 		return fmt.Errorf("error writing k8s environment: %w", err)
 	}
+	unlock()
 	csl, err := ci.GenerateCharts(ctx, ns, newenv, cl)
 	if err != nil {
 		return fmt.Errorf("error generating metahelm charts: %w", err)
@@ -412,9 +620,12 @@ func (ci ChartInstaller) BuildAndInstallCharts(ctx context.Context, newenv *EnvI
 	}
 	defer b.Stop()
 	endNamespaceSetup := ci.mc.Timing(mpfx+"namespace_setup", "triggering_repo:"+newenv.RC.Application.Repo)
-	if err = ci.setupNamespace(ctx, newenv.Env.Name, newenv.Env.Repo, ns); err != nil {
+	if err = ci.setupNamespace(ctx, newenv.Env.Name, newenv.Env.Repo, newenv.RC.RBACProfile, ns, newenv.RC.Secrets, newenv.Env.IsFork); err != nil {
 		return fmt.Errorf("error setting up namespace: %w", err)
 	}
+	if err = ci.applyNamespaceGuardrails(ctx, ns, newenv); err != nil {
+		return fmt.Errorf("error applying namespace guardrails: %w", err)
+	}
 	endNamespaceSetup()
 	return ci.installOrUpgradeCharts(ctx, ns, csl, newenv, b, false)
 }
@@ -425,6 +636,21 @@ func (ci ChartInstaller) installOrUpgradeCharts(ctx context.Context, namespace s
 	if err != nil || mhm == nil {
 		return fmt.Errorf("error getting helm client configuration: %w", err)
 	}
+	imageReady, builderr := ci.imageReadyCallback(ctx, env, b, upgrade)
+	if upgrade {
+		err = ci.upgrade(ctx, mhm, imageReady, namespace, csl, env)
+	} else {
+		err = ci.install(ctx, mhm, imageReady, namespace, csl, env)
+	}
+	if err != nil && *builderr != nil {
+		return *builderr
+	}
+	return err
+}
+
+// imageReadyCallback returns a metahelm install callback that waits for the image build of each chart to complete before installing or upgrading it,
+// and a pointer to the image build error that caused the install to be aborted, if any
+func (ci ChartInstaller) imageReadyCallback(ctx context.Context, env *EnvInfo, b images.Batch, upgrade bool) (func(c metahelm.Chart) metahelm.InstallCallbackAction, *error) {
 	actStr, actingStr := "install", "install"
 	if upgrade {
 		actStr, actingStr = "upgrade", "upgrad"
@@ -454,15 +680,7 @@ func (ci ChartInstaller) installOrUpgradeCharts(ctx context.Context, namespace s
 		ci.dl.AddEvent(ctx, env.Env.Name, "image build still pending; waiting to "+actStr+" chart for "+c.Title)
 		return metahelm.Wait
 	}
-	if upgrade {
-		err = ci.upgrade(ctx, mhm, imageReady, namespace, csl, env)
-	} else {
-		err = ci.install(ctx, mhm, imageReady, namespace, csl, env)
-	}
-	if err != nil && builderr != nil {
-		return builderr
-	}
-	return err
+	return imageReady, &builderr
 }
 
 var metahelmTimeout = 60 * time.Minute
@@ -511,13 +729,16 @@ func (ci ChartInstaller) upgrade(ctx context.Context, mhm *metahelm.Manager, cb
 	return nil
 }
 
-func (ci ChartInstaller) writeK8sEnvironment(ctx context.Context, env *EnvInfo, ns string) (err error) {
+// writeK8sEnvironment records the k8s environment for env in namespace ns of cluster, replacing any existing k8s environment
+func (ci ChartInstaller) writeK8sEnvironment(ctx context.Context, env *EnvInfo, ns, cluster string) (err error) {
 	k8senv, err := ci.dl.GetK8sEnv(ctx, env.Env.Name)
 	if err != nil {
 		return fmt.Errorf("error checking if k8s env exists: %w", err)
 	}
 	if k8senv != nil {
-		if err := ci.cleanUpNamespace(ctx, k8senv.Namespace, k8senv.EnvName, k8senv.Privileged); err != nil {
+		if eci, err := ci.forCluster(k8senv.Cluster); err != nil {
+			ci.log(ctx, "error getting cluster for existing k8senv: %v", err)
+		} else if err := eci.cleanUpNamespace(ctx, k8senv.Namespace, k8senv.EnvName, k8senv.Privileged); err != nil {
 			ci.log(ctx, "error cleaning up namespace for existing k8senv: %v", err)
 		}
 		if err := ci.dl.DeleteK8sEnv(ctx, env.Env.Name); err != nil {
@@ -543,7 +764,8 @@ func (ci ChartInstaller) writeK8sEnvironment(ctx context.Context, env *EnvInfo,
 		ConfigSignature: sig[:],
 		RefMapJSON:      string(rmj),
 		RepoConfigYAML:  rcy,
-		Privileged:      ci.isRepoPrivileged(env.Env.Repo),
+		Privileged:      ci.hasClusterBinding(env),
+		Cluster:         cluster,
 	}
 	return ci.dl.CreateK8sEnv(ctx, kenv)
 }
@@ -750,8 +972,15 @@ func (ci ChartInstaller) isRepoPrivileged(repo string) bool {
 	return false
 }
 
+// isEnvPrivileged returns whether the environment is for a privileged repo. Environments for PRs from forks are never privileged.
+func (ci ChartInstaller) isEnvPrivileged(env *models.QAEnvironment) bool {
+	return !env.IsFork && ci.isRepoPrivileged(env.Repo)
+}
+
 // setupNamespace prepares the namespace for Tiller and chart installations by creating a service account and any required RBAC settings
-func (ci ChartInstaller) setupNamespace(ctx context.Context, envname, repo, ns string) error {
+// rbacProfile is the RBAC profile requested in acyl.yml (if any), which must be allowed for repo. declaredSecrets are the secret injections declared in acyl.yml.
+// If untrusted (the environment is for a PR from a fork), the namespace is never privileged and secrets are not injected.
+func (ci ChartInstaller) setupNamespace(ctx context.Context, envname, repo, rbacProfile, ns string, declaredSecrets []string, untrusted bool) error {
 	ci.log(ctx, "setting up namespace: %v", ns)
 
 	// create service account
@@ -759,69 +988,8 @@ func (ci ChartInstaller) setupNamespace(ctx context.Context, envname, repo, ns s
 	if _, err := ci.kc.CoreV1().ServiceAccounts(ns).Create(ctx, &corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: serviceAccount}}, metav1.CreateOptions{}); err != nil {
 		return fmt.Errorf("error creating service acount: %w", err)
 	}
-	roleName := "nitro"
-	// create a role for the service account
-	ci.log(ctx, "creating role for service account: %v", roleName)
-	if _, err := ci.kc.RbacV1().Roles(ns).Create(ctx, &rbacv1.Role{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      roleName,
-			Namespace: ns,
-		},
-		Rules: []rbacv1.PolicyRule{
-			rbacv1.PolicyRule{
-				Verbs:     []string{"*"},
-				APIGroups: []string{"*"},
-				Resources: []string{"*"},
-			},
-		},
-	}, metav1.CreateOptions{}); err != nil {
-		return fmt.Errorf("error creating service account role: %w", err)
-	}
-	// bind the service account to the role
-	ci.log(ctx, "binding service account to role")
-	if _, err := ci.kc.RbacV1().RoleBindings(ns).Create(ctx, &rbacv1.RoleBinding{
-		ObjectMeta: metav1.ObjectMeta{
-			Name: "nitro",
-		},
-		Subjects: []rbacv1.Subject{
-			rbacv1.Subject{
-				Kind: "ServiceAccount",
-				Name: serviceAccount,
-			},
-		},
-		RoleRef: rbacv1.RoleRef{
-			APIGroup: "rbac.authorization.k8s.io",
-			Kind:     "Role",
-			Name:     roleName,
-		},
-	}, metav1.CreateOptions{}); err != nil {
-		return fmt.Errorf("error creating service account cluster role binding: %w", err)
-	}
-	// if the repo is privileged, bind the service account to the cluster-admin ClusterRole
-	if ci.isRepoPrivileged(repo) {
-		ci.log(ctx, "creating privileged ClusterRoleBinding: %v", clusterRoleBindingName(envname))
-		if _, err := ci.kc.RbacV1().ClusterRoleBindings().Create(ctx, &rbacv1.ClusterRoleBinding{
-			ObjectMeta: metav1.ObjectMeta{
-				Name: clusterRoleBindingName(envname),
-				Labels: map[string]string{
-					objLabelKey: objLabelValue,
-				},
-			},
-			Subjects: []rbacv1.Subject{
-				rbacv1.Subject{
-					Kind:      "ServiceAccount",
-					Namespace: ns,
-					Name:      serviceAccount,
-				},
-			},
-			RoleRef: rbacv1.RoleRef{
-				APIGroup: "rbac.authorization.k8s.io",
-				Kind:     "ClusterRole",
-				Name:     "cluster-admin",
-			},
-		}, metav1.CreateOptions{}); err != nil {
-			return fmt.Errorf("error creating cluster role binding (privileged repo): %w", err)
-		}
+	if err := ci.applyRBAC(ctx, envname, repo, rbacProfile, ns, untrusted); err != nil {
+		return err
 	}
 	// create optional user group role bindings
 	for group, crole := range ci.k8sgroupbindings {
@@ -847,27 +1015,14 @@ func (ci ChartInstaller) setupNamespace(ctx context.Context, envname, repo, ns s
 		}
 	}
 	// create optional secrets
-	for name, value := range ci.k8ssecretinjs {
-		ci.log(ctx, "injecting secret: %v of type %v (value is from Vault)", name, value.Type)
-		if _, err := ci.kc.CoreV1().Secrets(ns).Create(ctx, &corev1.Secret{
-			ObjectMeta: metav1.ObjectMeta{
-				Name:      name,
-				Namespace: ns,
-			},
-			Data: value.Data,
-			Type: corev1.SecretType(value.Type),
-		}, metav1.CreateOptions{}); err != nil {
-			return fmt.Errorf("error creating secret: %v: %w", name, err)
-		}
-	}
-	return nil
+	return ci.applySecrets(ctx, repo, ns, declaredSecrets, untrusted)
 }
 
 var (
 	serviceAccount = "nitro"
 )
 
-// cleanUpNamespace deletes an environment's namespace and ClusterRoleBinding, if they exist
+// cleanUpNamespace deletes an environment's namespace and ClusterRoleBinding (and ClusterRole, for RBAC profiles), if they exist
 func (ci ChartInstaller) cleanUpNamespace(ctx context.Context, ns, envname string, privileged bool) error {
 	var zero int64
 	// Delete in background so that we can release the lock as soon as possible
@@ -886,6 +1041,9 @@ func (ci ChartInstaller) cleanUpNamespace(ctx context.Context, ns, envname strin
 		if err := ci.kc.RbacV1().ClusterRoleBindings().Delete(ctx2, clusterRoleBindingName(envname), metav1.DeleteOptions{}); err != nil {
 			ci.log(ctx, "error cleaning up cluster role binding (privileged repo): %v", err)
 		}
+		if err := ci.kc.RbacV1().ClusterRoles().Delete(ctx2, clusterRoleBindingName(envname), metav1.DeleteOptions{}); err != nil && !k8serrors.IsNotFound(err) {
+			ci.log(ctx, "error cleaning up cluster role (rbac profile): %v", err)
+		}
 	}
 	return nil
 }
@@ -896,20 +1054,136 @@ func (ci ChartInstaller) DeleteNamespace(ctx context.Context, k8senv *models.Kub
 		ci.log(ctx, "unable to delete namespace because k8s env is nil")
 		return nil
 	}
+	ci, err := ci.forCluster(k8senv.Cluster)
+	if err != nil {
+		return fmt.Errorf("error getting cluster for k8s environment: %w", err)
+	}
 	if err := ci.cleanUpNamespace(ctx, k8senv.Namespace, k8senv.EnvName, k8senv.Privileged); err != nil {
 		return fmt.Errorf("error cleaning up namespace: %w", err)
 	}
 	return ci.dl.DeleteK8sEnv(ctx, k8senv.EnvName)
 }
 
+// suspendedReplicasAnnotation records the replica count of a workload prior to being scaled to zero
+const suspendedReplicasAnnotation = "acyl.dev/suspended-replicas"
+
+// SuspendNamespace scales all Deployments and StatefulSets in the environment namespace to zero replicas,
+// recording the previous replica count in an annotation so that it can be restored by ResumeNamespace
+func (ci ChartInstaller) SuspendNamespace(ctx context.Context, k8senv *models.KubernetesEnvironment) error {
+	if k8senv == nil {
+		return errors.New("k8senv is nil")
+	}
+	ci, err := ci.forCluster(k8senv.Cluster)
+	if err != nil {
+		return fmt.Errorf("error getting cluster for k8s environment: %w", err)
+	}
+	ci.log(ctx, "suspending namespace: %v", k8senv.Namespace)
+	return ci.scaleNamespace(ctx, k8senv.Namespace, func(name string, replicas *int32, annotations map[string]string) (*int32, bool) {
+		var n int32 = 1 // k8s default if replicas is unset
+		if replicas != nil {
+			n = *replicas
+		}
+		if _, ok := annotations[suspendedReplicasAnnotation]; ok && n == 0 {
+			// already suspended
+			return nil, false
+		}
+		// an annotation on a running workload is stale (eg, an upgrade restored the chart replicas), so it is overwritten
+		annotations[suspendedReplicasAnnotation] = strconv.Itoa(int(n))
+		var zero int32
+		ci.log(ctx, "scaling %v from %v to zero replicas", name, n)
+		return &zero, true
+	})
+}
+
+// ResumeNamespace restores the replica counts of all Deployments and StatefulSets in the environment namespace
+// that were previously scaled to zero by SuspendNamespace
+func (ci ChartInstaller) ResumeNamespace(ctx context.Context, k8senv *models.KubernetesEnvironment) error {
+	if k8senv == nil {
+		return errors.New("k8senv is nil")
+	}
+	ci, err := ci.forCluster(k8senv.Cluster)
+	if err != nil {
+		return fmt.Errorf("error getting cluster for k8s environment: %w", err)
+	}
+	ci.log(ctx, "resuming namespace: %v", k8senv.Namespace)
+	return ci.scaleNamespace(ctx, k8senv.Namespace, func(name string, replicas *int32, annotations map[string]string) (*int32, bool) {
+		v, ok := annotations[suspendedReplicasAnnotation]
+		if !ok {
+			return nil, false
+		}
+		delete(annotations, suspendedReplicasAnnotation)
+		if replicas == nil || *replicas != 0 {
+			// scaled up since being suspended (eg, by an upgrade), so only the stale annotation is removed
+			ci.log(ctx, "%v is already running, removing suspended replicas annotation", name)
+			return replicas, true
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			ci.log(ctx, "invalid suspended replicas annotation on %v (%v), defaulting to 1: %v", name, v, err)
+			n = 1
+		}
+		r := int32(n)
+		ci.log(ctx, "scaling %v to %v replicas", name, r)
+		return &r, true
+	})
+}
+
+// scaleFunc is called for each scalable workload in a namespace with the current replicas and (mutable) annotations.
+// It returns the new replicas and whether the object should be updated.
+type scaleFunc func(name string, replicas *int32, annotations map[string]string) (*int32, bool)
+
+// scaleNamespace calls sf for each Deployment and StatefulSet in ns and updates the objects as required
+func (ci ChartInstaller) scaleNamespace(ctx context.Context, ns string, sf scaleFunc) error {
+	dl, err := ci.kc.AppsV1().Deployments(ns).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("error listing deployments: %w", err)
+	}
+	for _, d := range dl.Items {
+		d := d
+		if d.Annotations == nil {
+			d.Annotations = map[string]string{}
+		}
+		r, ok := sf("deployment/"+d.Name, d.Spec.Replicas, d.Annotations)
+		if !ok {
+			continue
+		}
+		d.Spec.Replicas = r
+		if _, err := ci.kc.AppsV1().Deployments(ns).Update(ctx, &d, metav1.UpdateOptions{}); err != nil {
+			return fmt.Errorf("error updating deployment: %v: %w", d.Name, err)
+		}
+	}
+	sl, err := ci.kc.AppsV1().StatefulSets(ns).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("error listing statefulsets: %w", err)
+	}
+	for _, s := range sl.Items {
+		s := s
+		if s.Annotations == nil {
+			s.Annotations = map[string]string{}
+		}
+		r, ok := sf("statefulset/"+s.Name, s.Spec.Replicas, s.Annotations)
+		if !ok {
+			continue
+		}
+		s.Spec.Replicas = r
+		if _, err := ci.kc.AppsV1().StatefulSets(ns).Update(ctx, &s, metav1.UpdateOptions{}); err != nil {
+			return fmt.Errorf("error updating statefulset: %v: %w", s.Name, err)
+		}
+	}
+	return nil
+}
+
 // Cleanup runs various processes to clean up. For example, it removes orphaned k8s resources older than objMaxAge.
 // It is intended to be run periodically via a cronjob.
+// Every configured cluster is cleaned up.
 func (ci ChartInstaller) Cleanup(ctx context.Context, objMaxAge time.Duration) {
-	if err := ci.removeOrphanedNamespaces(ctx, objMaxAge); err != nil {
-		ci.log(ctx, "error cleaning up orphaned namespaces: %v", err)
-	}
-	if err := ci.removeOrphanedCRBs(ctx, objMaxAge); err != nil {
-		ci.log(ctx, "error cleaning up orphaned ClusterRoleBindings: %v", err)
+	for _, cci := range ci.allClusters() {
+		if err := cci.removeOrphanedNamespaces(ctx, objMaxAge); err != nil {
+			cci.log(ctx, "error cleaning up orphaned namespaces: %v", err)
+		}
+		if err := cci.removeOrphanedCRBs(ctx, objMaxAge); err != nil {
+			cci.log(ctx, "error cleaning up orphaned ClusterRoleBindings: %v", err)
+		}
 	}
 }
 
@@ -968,6 +1242,13 @@ func (ci ChartInstaller) removeOrphanedCRBs(ctx context.Context, maxAge time.Dur
 				if err := ci.kc.RbacV1().ClusterRoleBindings().Delete(ctx, crb.ObjectMeta.Name, metav1.DeleteOptions{GracePeriodSeconds: &zero, PropagationPolicy: &bg}); err != nil {
 					return fmt.Errorf("error deleting ClusterRoleBinding: %w", err)
 				}
+				// RBAC profiles with cluster rules have a ClusterRole with the same name
+				if crb.RoleRef.Kind == "ClusterRole" && crb.RoleRef.Name == crb.ObjectMeta.Name {
+					ci.log(ctx, "deleting orphaned ClusterRole: %v", crb.ObjectMeta.Name)
+					if err := ci.kc.RbacV1().ClusterRoles().Delete(ctx, crb.ObjectMeta.Name, metav1.DeleteOptions{GracePeriodSeconds: &zero, PropagationPolicy: &bg}); err != nil && !k8serrors.IsNotFound(err) {
+						return fmt.Errorf("error deleting ClusterRole: %w", err)
+					}
+				}
 			}
 		}
 	}
@@ -983,6 +1264,10 @@ type K8sPod struct {
 
 // GetK8sEnvPodList returns a kubernetes environment pod list for the namespace provided
 func (ci ChartInstaller) GetPodList(ctx context.Context, ns string) (out []K8sPod, err error) {
+	ci, err = ci.forNamespace(ctx, ns)
+	if err != nil {
+		return []K8sPod{}, fmt.Errorf("error getting cluster for namespace: %w", err)
+	}
 	pl, err := ci.kc.CoreV1().Pods(ns).List(ctx, metav1.ListOptions{})
 	if err != nil {
 		return []K8sPod{}, fmt.Errorf("error unable to retrieve pods for namespace %v: %w", ns, err)
@@ -1024,6 +1309,10 @@ type K8sPodContainers struct {
 
 // GetK8sEnvPodContainers returns all container names for the specified pod
 func (ci ChartInstaller) GetPodContainers(ctx context.Context, ns, podname string) (out K8sPodContainers, err error) {
+	ci, err = ci.forNamespace(ctx, ns)
+	if err != nil {
+		return K8sPodContainers{}, fmt.Errorf("error getting cluster for namespace: %w", err)
+	}
 	pod, err := ci.kc.CoreV1().Pods(ns).Get(ctx, podname, metav1.GetOptions{})
 	if err != nil {
 		return K8sPodContainers{}, fmt.Errorf("error unable to retrieve pods for namespace %v: %w", ns, err)
@@ -1048,6 +1337,10 @@ func (ci ChartInstaller) GetPodLogs(ctx context.Context, ns, podname, container
 	if lines > MaxPodContainerLogLines {
 		return nil, errors.Errorf("error line request exceeds limit")
 	}
+	ci, err = ci.forNamespace(ctx, ns)
+	if err != nil {
+		return nil, fmt.Errorf("error getting cluster for namespace: %w", err)
+	}
 	tl := int64(lines)
 	plo := corev1.PodLogOptions{
 		Container: container,
@@ -15,6 +15,7 @@ import (
 	"github.com/dollarshaveclub/acyl/pkg/eventlogger"
 	"github.com/dollarshaveclub/acyl/pkg/match"
 	"github.com/dollarshaveclub/acyl/pkg/models"
+	nitroerrors "github.com/dollarshaveclub/acyl/pkg/nitro/errors"
 	"github.com/dollarshaveclub/acyl/pkg/nitro/images"
 	"github.com/dollarshaveclub/acyl/pkg/nitro/metrics"
 	"github.com/dollarshaveclub/acyl/pkg/persistence"
@@ -859,7 +860,7 @@ func TestMetahelmWriteK8sEnvironment(t *testing.T) {
 	dl.CreateQAEnvironment(context.Background(), newenv.Env)
 	ci := ChartInstaller{dl: dl}
 	ns := "nitro-foo"
-	if err := ci.writeK8sEnvironment(context.Background(), newenv, ns); err != nil {
+	if err := ci.writeK8sEnvironment(context.Background(), newenv, ns, ""); err != nil {
 		t.Fatalf("should have succeeded: %v", err)
 	}
 	k8s, err := dl.GetK8sEnv(context.Background(), name)
@@ -880,7 +881,7 @@ func TestMetahelmWriteK8sEnvironment(t *testing.T) {
 	// test writing an existing record
 	fkc := fake.NewSimpleClientset(&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: ns}})
 	ci.kc = fkc
-	if err := ci.writeK8sEnvironment(context.Background(), newenv, "foo"); err != nil {
+	if err := ci.writeK8sEnvironment(context.Background(), newenv, "foo", ""); err != nil {
 		t.Fatalf("should have succeeded: %v", err)
 	}
 }
@@ -1107,9 +1108,16 @@ func TestMetahelmBuildAndInstallThenUpgradeCharts(t *testing.T) {
 		t.Fatalf("get k8s env should have succeeded: %v", err)
 	}
 	t.Logf("running BuildAndUpgradeCharts...")
+	// secrets declared after the environment was created are injected on upgrade
+	ci.k8ssecretinjs = testSecretInjections(t)
+	nenv.Env.Repo = "acme/something"
+	rc.Secrets = []string{"aws-creds"}
 	if err := ci.BuildAndUpgradeCharts(context.Background(), nenv, k8senv, cl); err != nil {
 		t.Fatalf("should have succeeded: %v", err)
 	}
+	if _, err := fkc.CoreV1().Secrets(ns).Get(context.Background(), "aws-creds", metav1.GetOptions{}); err != nil {
+		t.Fatalf("declared secret should have been injected on upgrade: %v", err)
+	}
 	releases, err := dl.GetHelmReleasesForEnv(context.Background(), nenv.Env.Name)
 	if err != nil {
 		t.Fatalf("get helm releases should have succeeded: %v", err)
@@ -1129,6 +1137,186 @@ func TestMetahelmBuildAndInstallThenUpgradeCharts(t *testing.T) {
 			}
 		}
 	}
+	// removed secrets are deleted on upgrade
+	rc.Secrets = nil
+	if err := ci.BuildAndUpgradeCharts(context.Background(), nenv, k8senv, cl); err != nil {
+		t.Fatalf("should have succeeded: %v", err)
+	}
+	if _, err := fkc.CoreV1().Secrets(ns).Get(context.Background(), "aws-creds", metav1.GetOptions{}); err == nil {
+		t.Fatalf("secret that is no longer declared should have been deleted on upgrade")
+	}
+	if _, err := fkc.CoreV1().Secrets(ns).Get(context.Background(), "org-token", metav1.GetOptions{}); err != nil {
+		t.Fatalf("always injected secret should have been kept: %v", err)
+	}
+	// an undeclared secret fails the upgrade with a user error
+	rc.Secrets = []string{"does-not-exist"}
+	if err := ci.BuildAndUpgradeCharts(context.Background(), nenv, k8senv, cl); err == nil || !nitroerrors.IsUserError(err) {
+		t.Fatalf("should have failed with a user error: %v", err)
+	}
+}
+
+func TestMetahelmBuildAndUpdateChartsIncrementally(t *testing.T) {
+	ns := "nitro-foo-bar"
+	overrideNamespace = ns
+	defer func() { overrideNamespace = "" }()
+	cl := ChartLocations{
+		"foo": ChartLocation{ChartPath: "testdata/chart"},
+		"bar": ChartLocation{ChartPath: "testdata/chart"},
+		"cuz": ChartLocation{ChartPath: "testdata/chart"},
+		"baz": ChartLocation{ChartPath: "testdata/chart"},
+	}
+	charts := []metahelm.Chart{
+		metahelm.Chart{Title: "foo", Location: "testdata/chart", DeploymentHealthIndication: metahelm.AtLeastOnePodHealthy, WaitUntilDeployment: "foo", DependencyList: []string{"bar", "cuz"}},
+		metahelm.Chart{Title: "bar", Location: "testdata/chart", DeploymentHealthIndication: metahelm.AtLeastOnePodHealthy, WaitUntilDeployment: "bar"},
+		metahelm.Chart{Title: "cuz", Location: "testdata/chart", DeploymentHealthIndication: metahelm.AtLeastOnePodHealthy, WaitUntilDeployment: "cuz"},
+		metahelm.Chart{Title: "baz", Location: "testdata/chart", DeploymentHealthIndication: metahelm.AtLeastOnePodHealthy, WaitUntilDeployment: "baz"},
+	}
+	dep := func(name, ref string, requires ...string) models.RepoConfigDependency {
+		return models.RepoConfigDependency{
+			Name:     name,
+			Repo:     name,
+			Requires: requires,
+			AppMetadata: models.RepoConfigAppMetadata{
+				Repo:          name,
+				Ref:           ref,
+				Branch:        "feature-foo",
+				Image:         name,
+				ChartTagValue: "image.tag",
+			},
+		}
+	}
+	rc := &models.RepoConfig{
+		Application: models.RepoConfigAppMetadata{
+			Repo:          "foo",
+			Ref:           "aaaa",
+			Branch:        "feature-foo",
+			Image:         "foo",
+			ChartTagValue: "image.tag",
+		},
+		Dependencies: models.DependencyDeclaration{
+			Direct: []models.RepoConfigDependency{dep("bar", "bbbb"), dep("cuz", "cccc")},
+		},
+	}
+	// cuz is removed, baz is added and required by bar
+	rc2 := &models.RepoConfig{
+		Application: rc.Application,
+		Dependencies: models.DependencyDeclaration{
+			Direct: []models.RepoConfigDependency{dep("bar", "bbbb", "baz"), dep("baz", "dddd")},
+		},
+	}
+	nenv := &EnvInfo{
+		Env: &models.QAEnvironment{Name: "foo-bar"},
+		RC:  rc,
+	}
+	fkc := fake.NewSimpleClientset(gentestobjs(charts, ns)...)
+	ib := &images.FakeImageBuilder{BatchCompletedFunc: func(envname, repo string) (bool, error) { return true, nil }}
+	dl := persistence.NewFakeDataLayer()
+	dl.CreateQAEnvironment(context.Background(), nenv.Env)
+	hcfg := fakeHelmConfiguration(t)
+	ci := ChartInstaller{
+		kc: fkc,
+		dl: dl,
+		ib: ib,
+		mc: &metrics.FakeCollector{},
+		mhmf: func(ctx context.Context, kc kubernetes.Interface, hccfg config.HelmClientConfig, namespace string) (*metahelm.Manager, error) {
+			return &metahelm.Manager{
+				K8c:  fkc,
+				HCfg: hcfg,
+				LogF: metahelm.LogFunc(func(msg string, args ...interface{}) {
+					eventlogger.GetLogger(context.Background()).Printf("metahelm-test: "+msg, args...)
+				}),
+			}, nil
+		},
+	}
+	metahelm.ChartWaitPollInterval = 10 * time.Millisecond
+	if err := ci.BuildAndInstallCharts(context.Background(), nenv, cl); err != nil {
+		t.Fatalf("install should have succeeded: %v", err)
+	}
+	k8senv, err := dl.GetK8sEnv(context.Background(), nenv.Env.Name)
+	if err != nil {
+		t.Fatalf("get k8s env should have succeeded: %v", err)
+	}
+	releases, err := dl.GetHelmReleasesForEnv(context.Background(), nenv.Env.Name)
+	if err != nil {
+		t.Fatalf("get helm releases should have succeeded: %v", err)
+	}
+	// secrets declared after the environment was created are injected on incremental update
+	ci.k8ssecretinjs = testSecretInjections(t)
+	nenv.Env.Repo = "acme/something"
+	rc2.Secrets = []string{"aws-creds"}
+	uenv := &EnvInfo{Env: nenv.Env, RC: rc2, Releases: map[string]string{}}
+	for _, r := range releases {
+		uenv.Releases[r.Name] = r.Release
+	}
+	diff := rc2.DependencyDiff(*rc)
+	if err := ci.BuildAndUpdateChartsIncrementally(context.Background(), uenv, k8senv, cl, diff); err != nil {
+		t.Fatalf("incremental update should have succeeded: %v", err)
+	}
+	rlses, err := hcfg.Releases.ListDeployed()
+	if err != nil {
+		t.Fatalf("error listing deployed releases: %v", err)
+	}
+	deployed := map[string]struct{}{}
+	for _, r := range rlses {
+		deployed[r.Name] = struct{}{}
+	}
+	for _, n := range []string{"foo", "bar", "baz"} {
+		if _, ok := deployed[n]; !ok {
+			t.Errorf("release should be deployed: %v", n)
+		}
+	}
+	if _, ok := deployed["cuz"]; ok {
+		t.Errorf("removed release should have been uninstalled")
+	}
+	releases, err = dl.GetHelmReleasesForEnv(context.Background(), nenv.Env.Name)
+	if err != nil {
+		t.Fatalf("get helm releases should have succeeded: %v", err)
+	}
+	if len(releases) != 3 {
+		t.Fatalf("bad release count: %v", releases)
+	}
+	for _, r := range releases {
+		if r.Name == "baz" && r.RevisionSHA != "dddd" {
+			t.Errorf("bad revision for baz: %v", r.RevisionSHA)
+		}
+	}
+	k8senv, err = dl.GetK8sEnv(context.Background(), nenv.Env.Name)
+	if err != nil {
+		t.Fatalf("get k8s env should have succeeded: %v", err)
+	}
+	sig := rc2.ConfigSignature()
+	if !bytes.Equal(k8senv.ConfigSignature, sig[:]) {
+		t.Errorf("config signature should have been updated")
+	}
+	if k8senv.Namespace != ns {
+		t.Errorf("namespace should have been preserved: %v", k8senv.Namespace)
+	}
+	if _, err := fkc.CoreV1().Secrets(ns).Get(context.Background(), "aws-creds", metav1.GetOptions{}); err != nil {
+		t.Errorf("declared secret should have been injected on incremental update: %v", err)
+	}
+}
+
+func TestSplitCharts(t *testing.T) {
+	csl := []metahelm.Chart{
+		metahelm.Chart{Title: "app", DependencyList: []string{"a", "b", "c"}},
+		metahelm.Chart{Title: "a", DependencyList: []string{"b"}},
+		metahelm.Chart{Title: "b", DependencyList: []string{"c"}},
+		metahelm.Chart{Title: "c"},
+	}
+	ins, upg := splitCharts(csl, []string{"b", "c"})
+	if len(ins) != 2 || len(upg) != 2 {
+		t.Fatalf("bad partitions: %+v, %+v", ins, upg)
+	}
+	im, um := chartMap(ins), chartMap(upg)
+	if d := im["b"].DependencyList; len(d) != 1 || d[0] != "c" {
+		t.Errorf("install requirements within the partition should be kept: %v", d)
+	}
+	if d := um["app"].DependencyList; len(d) != 1 || d[0] != "a" {
+		t.Errorf("requirements on installed charts should be removed: %v", d)
+	}
+	if d := um["a"].DependencyList; len(d) != 0 {
+		t.Errorf("requirements on installed charts should be removed: %v", d)
+	}
 }
 
 func TestMetahelmDeleteNamespace(t *testing.T) {
@@ -1157,6 +1345,48 @@ func TestMetahelmDeleteNamespace(t *testing.T) {
 	}
 }
 
+func TestMetahelmSuspendUpgradeSuspend(t *testing.T) {
+	ns := "nitro-foo"
+	replicas := func(n int32) *int32 { return &n }
+	fkc := fake.NewSimpleClientset(&appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "foo", Namespace: ns},
+		Spec:       appsv1.DeploymentSpec{Replicas: replicas(2)},
+	})
+	ci := ChartInstaller{kc: fkc, dl: persistence.NewFakeDataLayer()}
+	k8senv := &models.KubernetesEnvironment{EnvName: "foo-bar", Namespace: ns}
+	get := func() *appsv1.Deployment {
+		d, err := fkc.AppsV1().Deployments(ns).Get(context.Background(), "foo", metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("error getting deployment: %v", err)
+		}
+		return d
+	}
+	if err := ci.SuspendNamespace(context.Background(), k8senv); err != nil {
+		t.Fatalf("suspend should have succeeded: %v", err)
+	}
+	if d := get(); *d.Spec.Replicas != 0 || d.Annotations[suspendedReplicasAnnotation] != "2" {
+		t.Fatalf("bad suspended deployment: %v: %v", *d.Spec.Replicas, d.Annotations)
+	}
+	// an upgrade restores the chart replicas but leaves the annotation in place
+	d := get()
+	d.Spec.Replicas = replicas(3)
+	if _, err := fkc.AppsV1().Deployments(ns).Update(context.Background(), d, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("error updating deployment: %v", err)
+	}
+	if err := ci.SuspendNamespace(context.Background(), k8senv); err != nil {
+		t.Fatalf("second suspend should have succeeded: %v", err)
+	}
+	if d := get(); *d.Spec.Replicas != 0 || d.Annotations[suspendedReplicasAnnotation] != "3" {
+		t.Fatalf("deployment should have been suspended again: %v: %v", *d.Spec.Replicas, d.Annotations)
+	}
+	if err := ci.ResumeNamespace(context.Background(), k8senv); err != nil {
+		t.Fatalf("resume should have succeeded: %v", err)
+	}
+	if d := get(); *d.Spec.Replicas != 3 || d.Annotations[suspendedReplicasAnnotation] != "" {
+		t.Fatalf("bad resumed deployment: %v: %v", *d.Spec.Replicas, d.Annotations)
+	}
+}
+
 type fakeSecretFetcher struct{}
 
 func (fsf *fakeSecretFetcher) Get(id string) ([]byte, error) { return []byte{}, nil }
@@ -1170,11 +1400,44 @@ func TestMetahelmSetupNamespace(t *testing.T) {
 	k8scfg.ProcessPrivilegedRepos("testdata/chart")
 	k8scfg.ProcessSecretInjections(&fakeSecretFetcher{}, "mysecret=some/vault/path")
 	ci := ChartInstaller{kc: fkc, dl: dl, k8sgroupbindings: k8scfg.GroupBindings, k8srepowhitelist: k8scfg.PrivilegedRepoWhitelist, k8ssecretinjs: k8scfg.SecretInjections}
-	if err := ci.setupNamespace(context.Background(), "some-name", "testdata/chart", ns); err != nil {
+	if err := ci.setupNamespace(context.Background(), "some-name", "testdata/chart", "", ns, nil, false); err != nil {
 		t.Fatalf("should have succeeded: %v", err)
 	}
 }
 
+func TestMetahelmSetupNamespaceUntrusted(t *testing.T) {
+	ns := "nitro-foo"
+	dl := persistence.NewFakeDataLayer()
+	fkc := fake.NewSimpleClientset(&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: ns}})
+	k8scfg := config.K8sConfig{}
+	k8scfg.ProcessPrivilegedRepos("testdata/chart")
+	k8scfg.ProcessSecretInjections(&fakeSecretFetcher{}, "mysecret=some/vault/path")
+	ci := ChartInstaller{kc: fkc, dl: dl, k8srepowhitelist: k8scfg.PrivilegedRepoWhitelist, k8ssecretinjs: k8scfg.SecretInjections}
+	if err := ci.setupNamespace(context.Background(), "some-name", "testdata/chart", "", ns, nil, true); err != nil {
+		t.Fatalf("should have succeeded: %v", err)
+	}
+	secrets, err := fkc.CoreV1().Secrets(ns).List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("error listing secrets: %v", err)
+	}
+	if len(secrets.Items) != 0 {
+		t.Errorf("secrets should not have been injected: %v", secrets.Items)
+	}
+	crbs, err := fkc.RbacV1().ClusterRoleBindings().List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("error listing cluster role bindings: %v", err)
+	}
+	if len(crbs.Items) != 0 {
+		t.Errorf("privileged cluster role binding should not have been created: %v", crbs.Items)
+	}
+	if ci.isEnvPrivileged(&models.QAEnvironment{Repo: "testdata/chart", IsFork: true}) {
+		t.Errorf("fork environment should not be privileged")
+	}
+	if !ci.isEnvPrivileged(&models.QAEnvironment{Repo: "testdata/chart"}) {
+		t.Errorf("environment should be privileged")
+	}
+}
+
 func TestMetahelmCleanup(t *testing.T) {
 	maxAge := 1 * time.Hour
 	expires := time.Now().UTC().Add(-(maxAge + (72 * time.Hour)))
@@ -0,0 +1,210 @@
+package metahelm
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/dollarshaveclub/acyl/pkg/config"
+	nitroerrors "github.com/dollarshaveclub/acyl/pkg/nitro/errors"
+	rbacv1 "k8s.io/api/rbac/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// rbacProfile returns the name and RBAC profile for an environment service account, or a nil profile if the default namespace-wide Role should be used.
+// requested is the profile requested in acyl.yml, which must be allowed for repo. If untrusted, the request is ignored and cluster rules are never granted.
+func (ci ChartInstaller) rbacProfile(repo, requested string, untrusted bool) (string, *config.RBACProfile, error) {
+	if untrusted {
+		requested = ""
+	}
+	allowed, ok := ci.rbacprofiles.Repos[repo]
+	if !ok && ci.rbacprofiles.Default != "" {
+		allowed = []string{ci.rbacprofiles.Default}
+	}
+	name := requested
+	if name == "" {
+		if len(allowed) == 0 {
+			return "", nil, nil
+		}
+		name = allowed[0]
+	}
+	var found bool
+	for _, p := range allowed {
+		if p == name {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return "", nil, nitroerrors.User(fmt.Errorf("rbac profile is not allowed for repo %v: %v", repo, name))
+	}
+	p, ok := ci.rbacprofiles.Profiles[name]
+	if !ok {
+		return "", nil, fmt.Errorf("rbac profile not found: %v", name)
+	}
+	if untrusted {
+		p.ClusterRules = nil
+	}
+	return name, &p, nil
+}
+
+// hasClusterBinding returns whether the environment service account is bound cluster-wide, either because the repo is privileged
+// or because its RBAC profile has cluster rules
+func (ci ChartInstaller) hasClusterBinding(env *EnvInfo) bool {
+	if ci.isEnvPrivileged(env.Env) {
+		return true
+	}
+	if env.RC == nil {
+		return false
+	}
+	_, p, err := ci.rbacProfile(env.Env.Repo, env.RC.RBACProfile, env.Env.IsFork)
+	return err == nil && p != nil && len(p.ClusterRules) > 0
+}
+
+// applyRBAC creates or updates the service account Role, RoleBinding and any cluster bindings in namespace ns according to the RBAC profile
+// (see rbacProfile), removing cluster bindings that no longer apply. It's called when the namespace is set up and on every upgrade so that
+// changes to the requested or allowed profiles take effect without recreating the environment.
+func (ci ChartInstaller) applyRBAC(ctx context.Context, envname, repo, rbacProfile, ns string, untrusted bool) error {
+	pname, profile, err := ci.rbacProfile(repo, rbacProfile, untrusted)
+	if err != nil {
+		return fmt.Errorf("error getting rbac profile: %w", err)
+	}
+	roleName := "nitro"
+	rules := []rbacv1.PolicyRule{
+		rbacv1.PolicyRule{
+			Verbs:     []string{"*"},
+			APIGroups: []string{"*"},
+			Resources: []string{"*"},
+		},
+	}
+	if profile != nil {
+		ci.log(ctx, "using rbac profile: %v", pname)
+		rules = profile.Rules
+	}
+	// create or update the role for the service account
+	role, err := ci.kc.RbacV1().Roles(ns).Get(ctx, roleName, metav1.GetOptions{})
+	switch {
+	case k8serrors.IsNotFound(err):
+		ci.log(ctx, "creating role for service account: %v", roleName)
+		if _, err := ci.kc.RbacV1().Roles(ns).Create(ctx, &rbacv1.Role{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      roleName,
+				Namespace: ns,
+			},
+			Rules: rules,
+		}, metav1.CreateOptions{}); err != nil {
+			return fmt.Errorf("error creating service account role: %w", err)
+		}
+	case err != nil:
+		return fmt.Errorf("error getting service account role: %w", err)
+	default:
+		ci.log(ctx, "updating role for service account: %v", roleName)
+		role.Rules = rules
+		if _, err := ci.kc.RbacV1().Roles(ns).Update(ctx, role, metav1.UpdateOptions{}); err != nil {
+			return fmt.Errorf("error updating service account role: %w", err)
+		}
+	}
+	// bind the service account to the role
+	if _, err := ci.kc.RbacV1().RoleBindings(ns).Get(ctx, "nitro", metav1.GetOptions{}); err != nil {
+		if !k8serrors.IsNotFound(err) {
+			return fmt.Errorf("error getting service account role binding: %w", err)
+		}
+		ci.log(ctx, "binding service account to role")
+		if _, err := ci.kc.RbacV1().RoleBindings(ns).Create(ctx, &rbacv1.RoleBinding{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "nitro",
+			},
+			Subjects: []rbacv1.Subject{
+				rbacv1.Subject{
+					Kind: "ServiceAccount",
+					Name: serviceAccount,
+				},
+			},
+			RoleRef: rbacv1.RoleRef{
+				APIGroup: "rbac.authorization.k8s.io",
+				Kind:     "Role",
+				Name:     roleName,
+			},
+		}, metav1.CreateOptions{}); err != nil {
+			return fmt.Errorf("error creating service account role binding: %w", err)
+		}
+	}
+	// if the repo is privileged, bind the service account to the cluster-admin ClusterRole
+	// otherwise, if the profile has cluster rules, create or update a ClusterRole for the environment and bind the service account to it
+	crname := clusterRoleBindingName(envname)
+	clusterRole := ""
+	switch {
+	case ci.isRepoPrivileged(repo) && !untrusted:
+		clusterRole = "cluster-admin"
+	case profile != nil && len(profile.ClusterRules) > 0:
+		clusterRole = crname
+		cr, err := ci.kc.RbacV1().ClusterRoles().Get(ctx, crname, metav1.GetOptions{})
+		switch {
+		case k8serrors.IsNotFound(err):
+			ci.log(ctx, "creating ClusterRole for rbac profile: %v", crname)
+			if _, err := ci.kc.RbacV1().ClusterRoles().Create(ctx, &rbacv1.ClusterRole{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: crname,
+					Labels: map[string]string{
+						objLabelKey: objLabelValue,
+					},
+				},
+				Rules: profile.ClusterRules,
+			}, metav1.CreateOptions{}); err != nil {
+				return fmt.Errorf("error creating cluster role (rbac profile): %w", err)
+			}
+		case err != nil:
+			return fmt.Errorf("error getting cluster role (rbac profile): %w", err)
+		default:
+			ci.log(ctx, "updating ClusterRole for rbac profile: %v", crname)
+			cr.Rules = profile.ClusterRules
+			if _, err := ci.kc.RbacV1().ClusterRoles().Update(ctx, cr, metav1.UpdateOptions{}); err != nil {
+				return fmt.Errorf("error updating cluster role (rbac profile): %w", err)
+			}
+		}
+	}
+	// the role of a binding can't be changed, so a binding to a different role is replaced
+	crb, err := ci.kc.RbacV1().ClusterRoleBindings().Get(ctx, crname, metav1.GetOptions{})
+	if err != nil && !k8serrors.IsNotFound(err) {
+		return fmt.Errorf("error getting cluster role binding: %w", err)
+	}
+	exists := err == nil
+	if exists && crb.RoleRef.Name != clusterRole {
+		ci.log(ctx, "deleting ClusterRoleBinding that no longer applies: %v (to %v)", crname, crb.RoleRef.Name)
+		if err := ci.kc.RbacV1().ClusterRoleBindings().Delete(ctx, crname, metav1.DeleteOptions{}); err != nil && !k8serrors.IsNotFound(err) {
+			return fmt.Errorf("error deleting cluster role binding: %w", err)
+		}
+		exists = false
+	}
+	if clusterRole != crname {
+		if err := ci.kc.RbacV1().ClusterRoles().Delete(ctx, crname, metav1.DeleteOptions{}); err != nil && !k8serrors.IsNotFound(err) {
+			return fmt.Errorf("error deleting cluster role (rbac profile): %w", err)
+		}
+	}
+	if clusterRole != "" && !exists {
+		ci.log(ctx, "creating ClusterRoleBinding: %v (to %v)", crname, clusterRole)
+		if _, err := ci.kc.RbacV1().ClusterRoleBindings().Create(ctx, &rbacv1.ClusterRoleBinding{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: crname,
+				Labels: map[string]string{
+					objLabelKey: objLabelValue,
+				},
+			},
+			Subjects: []rbacv1.Subject{
+				rbacv1.Subject{
+					Kind:      "ServiceAccount",
+					Namespace: ns,
+					Name:      serviceAccount,
+				},
+			},
+			RoleRef: rbacv1.RoleRef{
+				APIGroup: "rbac.authorization.k8s.io",
+				Kind:     "ClusterRole",
+				Name:     clusterRole,
+			},
+		}, metav1.CreateOptions{}); err != nil {
+			return fmt.Errorf("error creating cluster role binding: %w", err)
+		}
+	}
+	return nil
+}
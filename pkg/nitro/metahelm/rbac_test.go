@@ -0,0 +1,321 @@
+package metahelm
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/dollarshaveclub/acyl/pkg/config"
+	"github.com/dollarshaveclub/acyl/pkg/models"
+	nitroerrors "github.com/dollarshaveclub/acyl/pkg/nitro/errors"
+	"github.com/dollarshaveclub/acyl/pkg/persistence"
+	v1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+var testRBACProfiles = []byte(`
+profiles:
+  read-only:
+    rules:
+      - apiGroups: [""]
+        resources: ["pods", "services"]
+        verbs: ["get", "list", "watch"]
+  operator:
+    rules:
+      - apiGroups: ["*"]
+        resources: ["*"]
+        verbs: ["*"]
+    cluster_rules:
+      - apiGroups: ["apiextensions.k8s.io"]
+        resources: ["customresourcedefinitions"]
+        verbs: ["get", "list", "create"]
+repos:
+  acme/operator: [operator, read-only]
+default: read-only
+`)
+
+func TestRBACProfile(t *testing.T) {
+	kc := config.K8sConfig{}
+	if err := kc.ProcessRBACProfiles(testRBACProfiles); err != nil {
+		t.Fatalf("error processing profiles: %v", err)
+	}
+	tests := []struct {
+		name, repo, requested string
+		untrusted             bool
+		profiles              config.RBACProfiles
+		wantName              string
+		wantClusterRules      bool
+		isErr, isUserErr      bool
+	}{
+		{
+			name:     "no profiles",
+			repo:     "acme/something",
+			profiles: config.RBACProfiles{},
+		},
+		{
+			name:      "no profiles with request",
+			repo:      "acme/something",
+			requested: "read-only",
+			profiles:  config.RBACProfiles{},
+			isErr:     true,
+			isUserErr: true,
+		},
+		{
+			name:     "default",
+			repo:     "acme/something",
+			profiles: kc.RBACProfiles,
+			wantName: "read-only",
+		},
+		{
+			name:      "default requested",
+			repo:      "acme/something",
+			requested: "read-only",
+			profiles:  kc.RBACProfiles,
+			wantName:  "read-only",
+		},
+		{
+			name:      "not allowed",
+			repo:      "acme/something",
+			requested: "operator",
+			profiles:  kc.RBACProfiles,
+			isErr:     true,
+			isUserErr: true,
+		},
+		{
+			name:             "repo default",
+			repo:             "acme/operator",
+			profiles:         kc.RBACProfiles,
+			wantName:         "operator",
+			wantClusterRules: true,
+		},
+		{
+			name:      "repo requested",
+			repo:      "acme/operator",
+			requested: "read-only",
+			profiles:  kc.RBACProfiles,
+			wantName:  "read-only",
+		},
+		{
+			name:      "untrusted ignores request and cluster rules",
+			repo:      "acme/operator",
+			requested: "read-only",
+			untrusted: true,
+			profiles:  kc.RBACProfiles,
+			wantName:  "operator",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ci := ChartInstaller{rbacprofiles: tt.profiles}
+			name, p, err := ci.rbacProfile(tt.repo, tt.requested, tt.untrusted)
+			if err != nil {
+				if !tt.isErr {
+					t.Fatalf("should have succeeded: %v", err)
+				}
+				if tt.isUserErr != nitroerrors.IsUserError(err) {
+					t.Fatalf("unexpected user error value: %v: %v", tt.isUserErr, err)
+				}
+				return
+			}
+			if tt.isErr {
+				t.Fatalf("should have failed")
+			}
+			if name != tt.wantName {
+				t.Fatalf("bad name: %v (wanted %v)", name, tt.wantName)
+			}
+			if tt.wantName == "" {
+				if p != nil {
+					t.Fatalf("profile should have been nil: %+v", p)
+				}
+				return
+			}
+			if p == nil {
+				t.Fatalf("profile should not have been nil")
+			}
+			if (len(p.ClusterRules) > 0) != tt.wantClusterRules {
+				t.Fatalf("unexpected cluster rules: %+v", p.ClusterRules)
+			}
+		})
+	}
+}
+
+func TestProcessRBACProfilesInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+	}{
+		{"unknown default", "default: foo\n"},
+		{"unknown repo profile", "profiles:\n  foo:\n    rules: []\nrepos:\n  acme/something: [bar]\n"},
+		{"malformed repo", "profiles:\n  foo:\n    rules: []\nrepos:\n  something: [foo]\n"},
+		{"missing verbs", "profiles:\n  foo:\n    rules:\n      - resources: [pods]\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kc := config.K8sConfig{}
+			if err := kc.ProcessRBACProfiles([]byte(tt.data)); err == nil {
+				t.Fatalf("should have failed")
+			}
+		})
+	}
+}
+
+func TestMetahelmSetupNamespaceRBACProfile(t *testing.T) {
+	ns := "nitro-foo"
+	kc := config.K8sConfig{}
+	if err := kc.ProcessRBACProfiles(testRBACProfiles); err != nil {
+		t.Fatalf("error processing profiles: %v", err)
+	}
+	fkc := fake.NewSimpleClientset(&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: ns}})
+	ci := ChartInstaller{kc: fkc, dl: persistence.NewFakeDataLayer(), rbacprofiles: kc.RBACProfiles}
+	if err := ci.setupNamespace(context.Background(), "some-name", "acme/operator", "", ns, nil, false); err != nil {
+		t.Fatalf("should have succeeded: %v", err)
+	}
+	role, err := fkc.RbacV1().Roles(ns).Get(context.Background(), "nitro", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("error getting role: %v", err)
+	}
+	if len(role.Rules) != 1 || role.Rules[0].Resources[0] != "*" {
+		t.Fatalf("unexpected role rules: %+v", role.Rules)
+	}
+	cr, err := fkc.RbacV1().ClusterRoles().Get(context.Background(), clusterRoleBindingName("some-name"), metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("error getting cluster role: %v", err)
+	}
+	if len(cr.Rules) != 1 || cr.Rules[0].Resources[0] != "customresourcedefinitions" {
+		t.Fatalf("unexpected cluster role rules: %+v", cr.Rules)
+	}
+	crb, err := fkc.RbacV1().ClusterRoleBindings().Get(context.Background(), clusterRoleBindingName("some-name"), metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("error getting cluster role binding: %v", err)
+	}
+	if crb.RoleRef.Name != cr.Name {
+		t.Fatalf("unexpected cluster role binding role ref: %+v", crb.RoleRef)
+	}
+	if !ci.hasClusterBinding(&EnvInfo{Env: &models.QAEnvironment{Repo: "acme/operator"}, RC: &models.RepoConfig{}}) {
+		t.Fatalf("env should have had a cluster binding")
+	}
+	if ci.hasClusterBinding(&EnvInfo{Env: &models.QAEnvironment{Repo: "acme/operator", IsFork: true}, RC: &models.RepoConfig{}}) {
+		t.Fatalf("untrusted env should not have had a cluster binding")
+	}
+
+	// a restricted profile without cluster rules
+	ns2 := "nitro-bar"
+	fkc = fake.NewSimpleClientset(&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: ns2}})
+	ci.kc = fkc
+	if err := ci.setupNamespace(context.Background(), "other-name", "acme/operator", "read-only", ns2, nil, false); err != nil {
+		t.Fatalf("should have succeeded: %v", err)
+	}
+	role, err = fkc.RbacV1().Roles(ns2).Get(context.Background(), "nitro", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("error getting role: %v", err)
+	}
+	if len(role.Rules) != 1 || len(role.Rules[0].Resources) != 2 {
+		t.Fatalf("unexpected role rules: %+v", role.Rules)
+	}
+	crbs, err := fkc.RbacV1().ClusterRoleBindings().List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("error listing cluster role bindings: %v", err)
+	}
+	if len(crbs.Items) != 0 {
+		t.Fatalf("cluster role binding should not have been created: %v", crbs.Items)
+	}
+
+	// a profile that isn't allowed for the repo
+	if err := ci.setupNamespace(context.Background(), "other-name", "acme/something", "operator", "nitro-baz", nil, false); err == nil || !nitroerrors.IsUserError(err) {
+		t.Fatalf("should have failed with a user error: %v", err)
+	}
+}
+
+func TestMetahelmApplyRBACUpgrade(t *testing.T) {
+	ns := "nitro-foo"
+	kc := config.K8sConfig{}
+	if err := kc.ProcessRBACProfiles(testRBACProfiles); err != nil {
+		t.Fatalf("error processing profiles: %v", err)
+	}
+	fkc := fake.NewSimpleClientset(&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: ns}})
+	ci := ChartInstaller{kc: fkc, dl: persistence.NewFakeDataLayer(), rbacprofiles: kc.RBACProfiles}
+	name := clusterRoleBindingName("some-name")
+	if err := ci.setupNamespace(context.Background(), "some-name", "acme/operator", "operator", ns, nil, false); err != nil {
+		t.Fatalf("should have succeeded: %v", err)
+	}
+	checkCRB := func(wantRole string) {
+		t.Helper()
+		crb, err := fkc.RbacV1().ClusterRoleBindings().Get(context.Background(), name, metav1.GetOptions{})
+		if wantRole == "" {
+			if err == nil {
+				t.Fatalf("cluster role binding should have been deleted: %+v", crb.RoleRef)
+			}
+			return
+		}
+		if err != nil {
+			t.Fatalf("error getting cluster role binding: %v", err)
+		}
+		if crb.RoleRef.Name != wantRole {
+			t.Fatalf("bad cluster role binding role ref: %+v (wanted %v)", crb.RoleRef, wantRole)
+		}
+	}
+	checkCRB(name)
+
+	// upgrading with a profile without cluster rules updates the role and removes the cluster bindings
+	if err := ci.applyRBAC(context.Background(), "some-name", "acme/operator", "read-only", ns, false); err != nil {
+		t.Fatalf("upgrade should have succeeded: %v", err)
+	}
+	role, err := fkc.RbacV1().Roles(ns).Get(context.Background(), "nitro", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("error getting role: %v", err)
+	}
+	if len(role.Rules) != 1 || len(role.Rules[0].Resources) != 2 {
+		t.Fatalf("role rules should have been updated: %+v", role.Rules)
+	}
+	checkCRB("")
+	if _, err := fkc.RbacV1().ClusterRoles().Get(context.Background(), name, metav1.GetOptions{}); err == nil {
+		t.Fatalf("cluster role should have been deleted")
+	}
+
+	// switching back recreates them
+	if err := ci.applyRBAC(context.Background(), "some-name", "acme/operator", "operator", ns, false); err != nil {
+		t.Fatalf("upgrade should have succeeded: %v", err)
+	}
+	checkCRB(name)
+
+	// the repo becoming privileged replaces the binding
+	ci.k8srepowhitelist = []string{"acme/operator"}
+	if err := ci.applyRBAC(context.Background(), "some-name", "acme/operator", "", ns, false); err != nil {
+		t.Fatalf("upgrade should have succeeded: %v", err)
+	}
+	checkCRB("cluster-admin")
+	if _, err := fkc.RbacV1().ClusterRoles().Get(context.Background(), name, metav1.GetOptions{}); err == nil {
+		t.Fatalf("cluster role should have been deleted")
+	}
+
+	// untrusted envs never keep cluster bindings
+	if err := ci.applyRBAC(context.Background(), "some-name", "acme/operator", "operator", ns, true); err != nil {
+		t.Fatalf("upgrade should have succeeded: %v", err)
+	}
+	checkCRB("")
+}
+
+func TestMetahelmCleanupRBACProfileClusterRole(t *testing.T) {
+	expires := metav1.NewTime(time.Now().UTC().Add(-72 * time.Hour))
+	name := clusterRoleBindingName("some-name")
+	labels := map[string]string{objLabelKey: objLabelValue}
+	fkc := fake.NewSimpleClientset(
+		&rbacv1.ClusterRole{ObjectMeta: metav1.ObjectMeta{Name: name, CreationTimestamp: expires, Labels: labels}},
+		&rbacv1.ClusterRoleBinding{
+			ObjectMeta: metav1.ObjectMeta{Name: name, CreationTimestamp: expires, Labels: labels},
+			RoleRef:    rbacv1.RoleRef{APIGroup: "rbac.authorization.k8s.io", Kind: "ClusterRole", Name: name},
+		},
+	)
+	ci := ChartInstaller{kc: fkc, dl: persistence.NewFakeDataLayer()}
+	if err := ci.removeOrphanedCRBs(context.Background(), time.Hour); err != nil {
+		t.Fatalf("should have succeeded: %v", err)
+	}
+	if _, err := fkc.RbacV1().ClusterRoleBindings().Get(context.Background(), name, metav1.GetOptions{}); err == nil {
+		t.Fatalf("cluster role binding should have been deleted")
+	}
+	if _, err := fkc.RbacV1().ClusterRoles().Get(context.Background(), name, metav1.GetOptions{}); err == nil {
+		t.Fatalf("cluster role should have been deleted")
+	}
+}
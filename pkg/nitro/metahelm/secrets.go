@@ -0,0 +1,102 @@
+package metahelm
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/dollarshaveclub/acyl/pkg/config"
+	nitroerrors "github.com/dollarshaveclub/acyl/pkg/nitro/errors"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// secretInjections returns the secrets to inject into the namespace of an environment for repo, given the secrets declared in acyl.yml.
+// Unscoped secrets are always injected. Scoped secrets are injected if they are permitted for repo and either declared or marked as always injected.
+// A declared secret that doesn't exist or isn't permitted for repo is a user error.
+func (ci ChartInstaller) secretInjections(repo string, declared []string) (map[string]config.K8sSecret, error) {
+	out := make(map[string]config.K8sSecret, len(ci.k8ssecretinjs))
+	for name, s := range ci.k8ssecretinjs {
+		if s.Scope == nil || (s.Scope.Always && s.Scope.Permits(repo)) {
+			out[name] = s
+		}
+	}
+	for _, name := range declared {
+		s, ok := ci.k8ssecretinjs[name]
+		if !ok {
+			return nil, nitroerrors.User(fmt.Errorf("declared secret not found: %v", name))
+		}
+		if !s.Scope.Permits(repo) {
+			return nil, nitroerrors.User(fmt.Errorf("declared secret is not permitted for repo %v: %v", repo, name))
+		}
+		out[name] = s
+	}
+	return out, nil
+}
+
+// applySecrets validates the secrets declared in acyl.yml and creates or updates the injected secrets in namespace ns (see secretInjections),
+// deleting previously injected secrets that no longer apply. It's called when the namespace is set up and on every upgrade so that changes
+// to the declared or permitted secrets take effect without recreating the environment.
+// If untrusted, the declarations are validated but no secrets are injected.
+func (ci ChartInstaller) applySecrets(ctx context.Context, repo, ns string, declared []string, untrusted bool) error {
+	// declared secrets are validated for untrusted environments too, so that invalid declarations fail the same way for every PR
+	secrets, err := ci.secretInjections(repo, declared)
+	if err != nil {
+		return fmt.Errorf("error getting secret injections: %w", err)
+	}
+	if untrusted {
+		ci.log(ctx, "untrusted environment, skipping %v secret injections", len(secrets))
+		return nil
+	}
+	sc := ci.kc.CoreV1().Secrets(ns)
+	for name, value := range secrets {
+		secret := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: ns,
+				Labels:    map[string]string{objLabelKey: objLabelValue},
+			},
+			Data: value.InjectedData(),
+			Type: corev1.SecretType(value.Type),
+		}
+		existing, err := sc.Get(ctx, name, metav1.GetOptions{})
+		switch {
+		case k8serrors.IsNotFound(err):
+			ci.log(ctx, "injecting secret: %v of type %v (value is from Vault)", name, value.Type)
+			if _, err := sc.Create(ctx, secret, metav1.CreateOptions{}); err != nil {
+				return fmt.Errorf("error creating secret: %v: %w", name, err)
+			}
+		case err != nil:
+			return fmt.Errorf("error getting secret: %v: %w", name, err)
+		case existing.Type != secret.Type:
+			// the secret type is immutable
+			ci.log(ctx, "replacing secret: %v of type %v (value is from Vault)", name, value.Type)
+			if err := sc.Delete(ctx, name, metav1.DeleteOptions{}); err != nil {
+				return fmt.Errorf("error deleting secret: %v: %w", name, err)
+			}
+			if _, err := sc.Create(ctx, secret, metav1.CreateOptions{}); err != nil {
+				return fmt.Errorf("error creating secret: %v: %w", name, err)
+			}
+		default:
+			ci.log(ctx, "updating secret: %v of type %v (value is from Vault)", name, value.Type)
+			secret.ResourceVersion = existing.ResourceVersion
+			if _, err := sc.Update(ctx, secret, metav1.UpdateOptions{}); err != nil {
+				return fmt.Errorf("error updating secret: %v: %w", name, err)
+			}
+		}
+	}
+	sl, err := sc.List(ctx, metav1.ListOptions{LabelSelector: objLabelKey + "=" + objLabelValue})
+	if err != nil {
+		return fmt.Errorf("error listing injected secrets: %w", err)
+	}
+	for _, s := range sl.Items {
+		if _, ok := secrets[s.Name]; ok {
+			continue
+		}
+		ci.log(ctx, "deleting secret that is no longer injected: %v", s.Name)
+		if err := sc.Delete(ctx, s.Name, metav1.DeleteOptions{}); err != nil && !k8serrors.IsNotFound(err) {
+			return fmt.Errorf("error deleting secret: %v: %w", s.Name, err)
+		}
+	}
+	return nil
+}
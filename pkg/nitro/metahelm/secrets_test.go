@@ -0,0 +1,165 @@
+package metahelm
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/dollarshaveclub/acyl/pkg/config"
+	nitroerrors "github.com/dollarshaveclub/acyl/pkg/nitro/errors"
+	"github.com/dollarshaveclub/acyl/pkg/persistence"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func testSecretInjections(t *testing.T) map[string]config.K8sSecret {
+	kc := config.K8sConfig{
+		SecretInjections: map[string]config.K8sSecret{
+			"image-pull-secret": config.K8sSecret{Data: map[string][]byte{".dockerconfigjson": []byte("{}")}, Type: "kubernetes.io/dockerconfigjson"},
+			"aws-creds":         config.K8sSecret{Data: map[string][]byte{"key": []byte("foo"), "secret": []byte("bar")}, Type: "Opaque"},
+			"org-token":         config.K8sSecret{Data: map[string][]byte{"token": []byte("asdf")}, Type: "Opaque"},
+			"everyone":          config.K8sSecret{Data: map[string][]byte{"token": []byte("asdf")}, Type: "Opaque"},
+		},
+	}
+	scopes := `{
+		"aws-creds": {"repos": ["acme/something"], "key_renames": {"key": "AWS_ACCESS_KEY_ID", "secret": "AWS_SECRET_ACCESS_KEY"}},
+		"org-token": {"repos": ["acme/*"], "always": true},
+		"everyone": {"repos": ["*"]}
+	}`
+	if err := kc.ProcessSecretInjectionScopes(scopes); err != nil {
+		t.Fatalf("error processing scopes: %v", err)
+	}
+	return kc.SecretInjections
+}
+
+func TestSecretInjections(t *testing.T) {
+	tests := []struct {
+		name, repo       string
+		declared         []string
+		want             []string
+		isErr, isUserErr bool
+	}{
+		{
+			name: "none declared",
+			repo: "acme/something",
+			want: []string{"image-pull-secret", "org-token"},
+		},
+		{
+			name: "other org",
+			repo: "other/something",
+			want: []string{"image-pull-secret"},
+		},
+		{
+			name:     "declared",
+			repo:     "acme/something",
+			declared: []string{"aws-creds", "everyone"},
+			want:     []string{"aws-creds", "everyone", "image-pull-secret", "org-token"},
+		},
+		{
+			name:      "not permitted",
+			repo:      "acme/other",
+			declared:  []string{"aws-creds"},
+			isErr:     true,
+			isUserErr: true,
+		},
+		{
+			name:      "missing",
+			repo:      "acme/something",
+			declared:  []string{"does-not-exist"},
+			isErr:     true,
+			isUserErr: true,
+		},
+	}
+	ci := ChartInstaller{k8ssecretinjs: testSecretInjections(t)}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := ci.secretInjections(tt.repo, tt.declared)
+			if err != nil {
+				if !tt.isErr {
+					t.Fatalf("should have succeeded: %v", err)
+				}
+				if tt.isUserErr != nitroerrors.IsUserError(err) {
+					t.Fatalf("unexpected user error value: %v: %v", tt.isUserErr, err)
+				}
+				return
+			}
+			if tt.isErr {
+				t.Fatalf("should have failed")
+			}
+			names := []string{}
+			for k := range out {
+				names = append(names, k)
+			}
+			sort.Strings(names)
+			if strings.Join(names, ",") != strings.Join(tt.want, ",") {
+				t.Fatalf("bad secrets: %v (wanted %v)", names, tt.want)
+			}
+		})
+	}
+}
+
+func TestProcessSecretInjectionScopesInvalid(t *testing.T) {
+	tests := []struct {
+		name, scopes string
+	}{
+		{"unknown secret", `{"foo": {"repos": ["acme/something"]}}`},
+		{"malformed repo", `{"aws-creds": {"repos": ["acme"]}}`},
+		{"missing key", `{"aws-creds": {"repos": ["acme/something"], "key_renames": {"foo": "bar"}}}`},
+		{"duplicate target key", `{"aws-creds": {"repos": ["acme/something"], "key_renames": {"key": "secret"}}}`},
+		{"duplicate renames", `{"aws-creds": {"repos": ["acme/something"], "key_renames": {"key": "token", "secret": "token"}}}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kc := config.K8sConfig{
+				SecretInjections: map[string]config.K8sSecret{
+					"aws-creds": config.K8sSecret{Data: map[string][]byte{"key": []byte("foo"), "secret": []byte("bar")}, Type: "Opaque"},
+				},
+			}
+			if err := kc.ProcessSecretInjectionScopes(tt.scopes); err == nil {
+				t.Fatalf("should have failed")
+			}
+		})
+	}
+}
+
+func TestMetahelmSetupNamespaceDeclaredSecrets(t *testing.T) {
+	ns := "nitro-foo"
+	fkc := fake.NewSimpleClientset(&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: ns}})
+	ci := ChartInstaller{kc: fkc, dl: persistence.NewFakeDataLayer(), k8ssecretinjs: testSecretInjections(t)}
+	if err := ci.setupNamespace(context.Background(), "some-name", "acme/something", "", ns, []string{"aws-creds"}, false); err != nil {
+		t.Fatalf("should have succeeded: %v", err)
+	}
+	s, err := fkc.CoreV1().Secrets(ns).Get(context.Background(), "aws-creds", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("error getting secret: %v", err)
+	}
+	if string(s.Data["AWS_ACCESS_KEY_ID"]) != "foo" || string(s.Data["AWS_SECRET_ACCESS_KEY"]) != "bar" || len(s.Data) != 2 {
+		t.Fatalf("keys should have been renamed: %v", s.Data)
+	}
+	if _, err := fkc.CoreV1().Secrets(ns).Get(context.Background(), "everyone", metav1.GetOptions{}); err == nil {
+		t.Fatalf("undeclared scoped secret should not have been injected")
+	}
+	if err := ci.setupNamespace(context.Background(), "other-name", "other/something", "", "nitro-bar", []string{"aws-creds"}, false); err == nil || !nitroerrors.IsUserError(err) {
+		t.Fatalf("should have failed with a user error: %v", err)
+	}
+
+	// untrusted environments don't get secrets, but declarations are still validated
+	ns2 := "nitro-baz"
+	fkc = fake.NewSimpleClientset(&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: ns2}})
+	ci.kc = fkc
+	if err := ci.setupNamespace(context.Background(), "fork-name", "acme/something", "", ns2, []string{"does-not-exist"}, true); err == nil || !nitroerrors.IsUserError(err) {
+		t.Fatalf("untrusted should have failed with a user error: %v", err)
+	}
+	if err := ci.setupNamespace(context.Background(), "fork-name", "acme/something", "", "nitro-qux", []string{"aws-creds"}, true); err != nil {
+		t.Fatalf("untrusted should have succeeded: %v", err)
+	}
+	sl, err := fkc.CoreV1().Secrets("nitro-qux").List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("error listing secrets: %v", err)
+	}
+	if len(sl.Items) != 0 {
+		t.Fatalf("secrets should not have been injected for untrusted env: %v", len(sl.Items))
+	}
+}
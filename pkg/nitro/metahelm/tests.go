@@ -0,0 +1,304 @@
+package metahelm
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/dollarshaveclub/acyl/pkg/eventlogger"
+	"github.com/dollarshaveclub/acyl/pkg/models"
+	nitroerrors "github.com/dollarshaveclub/acyl/pkg/nitro/errors"
+	"github.com/dollarshaveclub/metahelm/pkg/metahelm"
+	"github.com/google/uuid"
+	multierror "github.com/hashicorp/go-multierror"
+	"github.com/pkg/errors"
+	"helm.sh/helm/v3/pkg/action"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// TestTemplateData is the data available to test job image and env value templates
+type TestTemplateData struct {
+	EnvName      string
+	K8sNamespace string
+	Ref          string
+}
+
+const (
+	testJobLabelKey = "acyl.dev/test-job"
+	// testJobTTL is how long finished test jobs (and their pods) are kept for debugging
+	testJobTTL = int32(3600)
+	// maxTestJobNameLen leaves room for the prefix and random suffix in job names
+	maxTestJobNameLen = 40
+)
+
+// DefaultHelmTestTimeout is the helm test timeout if the context has no deadline
+const DefaultHelmTestTimeout = 10 * time.Minute
+
+// testJobPollInterval is how often test job status is checked
+var testJobPollInterval = 5 * time.Second
+
+// RunTests runs the test jobs and helm tests declared in the environment config, writing test output to the event log.
+// An error is returned if any test fails.
+func (ci ChartInstaller) RunTests(ctx context.Context, env *EnvInfo) error {
+	tests := env.RC.Tests
+	if !tests.Enabled() {
+		return nil
+	}
+	defer ci.mc.Timing(mpfx+"run_tests", "triggering_repo:"+env.Env.Repo)()
+	k8senv, err := ci.dl.GetK8sEnv(ctx, env.Env.Name)
+	if err != nil {
+		return fmt.Errorf("error getting k8s environment: %w", err)
+	}
+	if k8senv == nil {
+		return errors.New("missing k8s environment")
+	}
+	if ci, err = ci.forCluster(k8senv.Cluster); err != nil {
+		return fmt.Errorf("error getting cluster for k8s environment: %w", err)
+	}
+	ctx, cf := context.WithTimeout(ctx, tests.Timeout())
+	defer cf()
+	td := TestTemplateData{EnvName: env.Env.Name, K8sNamespace: k8senv.Namespace, Ref: env.RC.Application.Ref}
+	var mtx sync.Mutex
+	var merr *multierror.Error
+	fail := func(err error) {
+		mtx.Lock()
+		merr = multierror.Append(merr, err)
+		mtx.Unlock()
+	}
+	var wg sync.WaitGroup
+	for _, tj := range tests.Jobs {
+		wg.Add(1)
+		go func(tj models.TestJob) {
+			defer wg.Done()
+			if err := ci.runTestJob(ctx, k8senv.Namespace, tj, td); err != nil {
+				ci.log(ctx, "test job failed: %v: %v", tj.Name, err)
+				fail(fmt.Errorf("job %v: %w", tj.Name, err))
+				return
+			}
+			ci.log(ctx, "test job passed: %v", tj.Name)
+		}(tj)
+	}
+	if len(tests.HelmTests) > 0 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// helm tests are run sequentially since they share a helm client
+			if err := ci.runHelmTests(ctx, k8senv.Namespace, env.Env.Name, tests.HelmTests, fail); err != nil {
+				fail(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if err := merr.ErrorOrNil(); err != nil {
+		return nitroerrors.User(fmt.Errorf("tests failed: %w", err))
+	}
+	return nil
+}
+
+// runHelmTests runs "helm test" for the releases of the named applications/dependencies, calling fail for each failed test.
+// An error is returned if the tests cannot be run.
+func (ci ChartInstaller) runHelmTests(ctx context.Context, ns, envname string, names []string, fail func(error)) error {
+	releases, err := ci.dl.GetHelmReleasesForEnv(ctx, envname)
+	if err != nil {
+		return fmt.Errorf("error getting helm releases for env: %w", err)
+	}
+	rm := make(map[string]string, len(releases))
+	for _, r := range releases {
+		rm[r.Name] = r.Release
+	}
+	mhm, err := ci.mhmf(ctx, ci.kc, ci.hccfg, ns)
+	if err != nil || mhm == nil {
+		return fmt.Errorf("error getting helm client configuration: %w", err)
+	}
+	for _, name := range names {
+		release, ok := rm[name]
+		if !ok {
+			fail(fmt.Errorf("helm test %v: release not found", name))
+			continue
+		}
+		if err := ci.runHelmTest(ctx, mhm, ns, release); err != nil {
+			ci.log(ctx, "helm test failed: %v (%v): %v", name, release, err)
+			fail(fmt.Errorf("helm test %v: %w", name, err))
+			continue
+		}
+		ci.log(ctx, "helm test passed: %v (%v)", name, release)
+	}
+	return nil
+}
+
+// runHelmTest runs the test hooks for release and writes the test pod logs to the event log
+func (ci ChartInstaller) runHelmTest(ctx context.Context, mhm *metahelm.Manager, ns, release string) error {
+	timeout := DefaultHelmTestTimeout
+	if dl, ok := ctx.Deadline(); ok {
+		timeout = time.Until(dl)
+	}
+	if timeout <= 0 {
+		return errors.New("timeout exceeded")
+	}
+	rt := action.NewReleaseTesting(mhm.HCfg)
+	rt.Namespace = ns
+	rt.Timeout = timeout
+	rel, err := rt.Run(release)
+	if rel != nil {
+		b := &bytes.Buffer{}
+		if err := rt.GetPodLogs(b, rel); err != nil {
+			ci.log(ctx, "error getting helm test pod logs: %v: %v", release, err)
+		}
+		logTestOutput(ctx, "helm test "+release, b)
+	}
+	if err != nil {
+		return fmt.Errorf("error running tests: %w", err)
+	}
+	return nil
+}
+
+// runTestJob creates the Kubernetes Job for tj in namespace ns and waits for it to complete, writing the pod logs to the event log
+func (ci ChartInstaller) runTestJob(ctx context.Context, ns string, tj models.TestJob, td TestTemplateData) (err error) {
+	job, err := testJob(tj, td)
+	if err != nil {
+		return fmt.Errorf("error generating job: %w", err)
+	}
+	ci.log(ctx, "creating test job: %v", job.Name)
+	if _, err := ci.kc.BatchV1().Jobs(ns).Create(ctx, job, metav1.CreateOptions{}); err != nil {
+		return fmt.Errorf("error creating job: %w", err)
+	}
+	defer func() {
+		// ctx may be cancelled
+		ctx2 := eventlogger.NewEventLoggerContext(context.Background(), eventlogger.GetLogger(ctx))
+		ci.logTestJobPods(ctx2, ns, job.Name)
+		if err != nil && ctx.Err() != nil {
+			pp := metav1.DeletePropagationBackground
+			if err := ci.kc.BatchV1().Jobs(ns).Delete(ctx2, job.Name, metav1.DeleteOptions{PropagationPolicy: &pp}); err != nil {
+				ci.log(ctx, "error deleting cancelled test job: %v: %v", job.Name, err)
+			}
+		}
+	}()
+	for {
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("timeout or cancellation waiting for job: %w", ctx.Err())
+		case <-time.After(testJobPollInterval):
+		}
+		j, err := ci.kc.BatchV1().Jobs(ns).Get(ctx, job.Name, metav1.GetOptions{})
+		if err != nil {
+			return fmt.Errorf("error getting job: %w", err)
+		}
+		if j.Status.Succeeded > 0 {
+			return nil
+		}
+		for _, c := range j.Status.Conditions {
+			if c.Type == batchv1.JobFailed && c.Status == corev1.ConditionTrue {
+				return fmt.Errorf("job failed: %v: %v", c.Reason, c.Message)
+			}
+		}
+	}
+}
+
+// logTestJobPods writes the logs of all pods for the named test job to the event log
+func (ci ChartInstaller) logTestJobPods(ctx context.Context, ns, jobname string) {
+	pl, err := ci.kc.CoreV1().Pods(ns).List(ctx, metav1.ListOptions{LabelSelector: testJobLabelKey + "=" + jobname})
+	if err != nil {
+		ci.log(ctx, "error listing test job pods: %v: %v", jobname, err)
+		return
+	}
+	for _, p := range pl.Items {
+		for _, c := range p.Spec.Containers {
+			rc, err := ci.GetPodLogs(ctx, ns, p.Name, c.Name, MaxPodContainerLogLines)
+			if err != nil {
+				ci.log(ctx, "error getting test job pod logs: %v: %v", p.Name, err)
+				continue
+			}
+			logTestOutput(ctx, "test job "+p.Name, rc)
+			rc.Close()
+		}
+	}
+}
+
+// logTestOutput writes each line of r to the event log, prefixed by prefix
+func logTestOutput(ctx context.Context, prefix string, r io.Reader) {
+	elog := eventlogger.GetLogger(ctx)
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		elog.Printf("%v: %v", prefix, s.Text())
+	}
+}
+
+// testJob generates the Kubernetes Job for tj
+func testJob(tj models.TestJob, td TestTemplateData) (*batchv1.Job, error) {
+	render := func(s string) (string, error) {
+		tmpl, err := template.New("test-job").Parse(s)
+		if err != nil {
+			return "", fmt.Errorf("error parsing template: %w", err)
+		}
+		b := &strings.Builder{}
+		if err := tmpl.Execute(b, &td); err != nil {
+			return "", fmt.Errorf("error executing template: %w", err)
+		}
+		return b.String(), nil
+	}
+	image, err := render(tj.Image)
+	if err != nil {
+		return nil, fmt.Errorf("error rendering image: %w", err)
+	}
+	keys := make([]string, 0, len(tj.Env))
+	for k := range tj.Env {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	env := make([]corev1.EnvVar, 0, len(keys))
+	for _, k := range keys {
+		v, err := render(tj.Env[k])
+		if err != nil {
+			return nil, fmt.Errorf("error rendering env value: %v: %w", k, err)
+		}
+		env = append(env, corev1.EnvVar{Name: k, Value: v})
+	}
+	jn := tj.Name
+	if len(jn) > maxTestJobNameLen {
+		jn = strings.TrimRight(jn[:maxTestJobNameLen], "-")
+	}
+	name := fmt.Sprintf("acyl-test-%v-%v", jn, uuid.Must(uuid.NewRandom()).String()[:8])
+	labels := map[string]string{
+		objLabelKey:     objLabelValue,
+		testJobLabelKey: name,
+	}
+	backoff := tj.BackoffLimit
+	ttl := testJobTTL
+	return &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   name,
+			Labels: labels,
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit:            &backoff,
+			TTLSecondsAfterFinished: &ttl,
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: labels,
+				},
+				Spec: corev1.PodSpec{
+					RestartPolicy:      corev1.RestartPolicyNever,
+					ServiceAccountName: tj.ServiceAccount,
+					Containers: []corev1.Container{
+						corev1.Container{
+							Name:    "test",
+							Image:   image,
+							Command: tj.Command,
+							Args:    tj.Args,
+							Env:     env,
+						},
+					},
+				},
+			},
+		},
+	}, nil
+}
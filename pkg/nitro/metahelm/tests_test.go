@@ -0,0 +1,145 @@
+package metahelm
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dollarshaveclub/acyl/pkg/eventlogger"
+	"github.com/dollarshaveclub/acyl/pkg/models"
+	"github.com/dollarshaveclub/acyl/pkg/nitro/metrics"
+	"github.com/dollarshaveclub/acyl/pkg/persistence"
+	"github.com/google/uuid"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestTestJob(t *testing.T) {
+	tj := models.TestJob{
+		Name:         "integration",
+		Image:        "quay.io/acme/foo-tests:{{ .Ref }}",
+		Command:      []string{"make"},
+		Args:         []string{"test"},
+		Env:          map[string]string{"TARGET": "http://web.{{ .K8sNamespace }}", "ENV_NAME": "{{ .EnvName }}"},
+		BackoffLimit: 2,
+	}
+	job, err := testJob(tj, TestTemplateData{EnvName: "foo-bar", K8sNamespace: "nitro-1234-foo-bar", Ref: "asdf"})
+	if err != nil {
+		t.Fatalf("should have succeeded: %v", err)
+	}
+	if !strings.HasPrefix(job.Name, "acyl-test-integration-") || len(job.Name) > 63 {
+		t.Errorf("bad name: %v", job.Name)
+	}
+	if job.Spec.Template.Labels[testJobLabelKey] != job.Name {
+		t.Errorf("bad pod labels: %v", job.Spec.Template.Labels)
+	}
+	if *job.Spec.BackoffLimit != 2 || job.Spec.Template.Spec.RestartPolicy != corev1.RestartPolicyNever {
+		t.Errorf("bad job spec: %+v", job.Spec)
+	}
+	c := job.Spec.Template.Spec.Containers[0]
+	if c.Image != "quay.io/acme/foo-tests:asdf" {
+		t.Errorf("bad image: %v", c.Image)
+	}
+	if len(c.Env) != 2 || c.Env[0].Name != "ENV_NAME" || c.Env[0].Value != "foo-bar" || c.Env[1].Value != "http://web.nitro-1234-foo-bar" {
+		t.Errorf("bad env: %+v", c.Env)
+	}
+	tj.Name = strings.Repeat("a", 100)
+	job, err = testJob(tj, TestTemplateData{})
+	if err != nil {
+		t.Fatalf("should have succeeded: %v", err)
+	}
+	if len(job.Name) > 63 {
+		t.Errorf("name too long: %v", job.Name)
+	}
+	tj.Image = "{{ .Foo }}"
+	if _, err := testJob(tj, TestTemplateData{}); err == nil {
+		t.Errorf("should have failed with bad template")
+	}
+}
+
+func TestRunTests(t *testing.T) {
+	ns := "nitro-1234-foo-bar"
+	fkc := fake.NewSimpleClientset()
+	// complete jobs when they're read, failing the job named "broken"
+	fkc.PrependReactor("get", "jobs", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		ga := action.(k8stesting.GetAction)
+		obj, err := fkc.Tracker().Get(batchv1.SchemeGroupVersion.WithResource("jobs"), ga.GetNamespace(), ga.GetName())
+		if err != nil {
+			return true, nil, err
+		}
+		job := obj.(*batchv1.Job).DeepCopy()
+		if strings.HasPrefix(job.Name, "acyl-test-broken-") {
+			job.Status.Conditions = []batchv1.JobCondition{batchv1.JobCondition{Type: batchv1.JobFailed, Status: corev1.ConditionTrue, Reason: "BackoffLimitExceeded", Message: "Job has reached the specified backoff limit"}}
+		} else {
+			job.Status.Succeeded = 1
+		}
+		return true, job, nil
+	})
+	fkc.PrependReactor("create", "jobs", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		job := action.(k8stesting.CreateAction).GetObject().(*batchv1.Job)
+		pod := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: job.Name + "-abcd", Namespace: ns, Labels: job.Spec.Template.Labels},
+			Spec:       job.Spec.Template.Spec,
+		}
+		if err := fkc.Tracker().Add(pod); err != nil {
+			return true, nil, err
+		}
+		return false, nil, nil
+	})
+	dl := persistence.NewFakeDataLayer()
+	dl.CreateQAEnvironment(context.Background(), &models.QAEnvironment{Name: "foo-bar", Repo: "acme/foo"})
+	dl.CreateK8sEnv(context.Background(), &models.KubernetesEnvironment{EnvName: "foo-bar", Namespace: ns})
+	ci := ChartInstaller{kc: fkc, dl: dl, mc: &metrics.FakeCollector{}}
+	testJobPollInterval = 10 * time.Millisecond
+	el := &eventlogger.Logger{DL: dl, ID: uuid.Must(uuid.NewRandom())}
+	el.Init([]byte{}, "acme/foo", 99)
+	ctx := eventlogger.NewEventLoggerContext(context.Background(), el)
+	env := &EnvInfo{
+		Env: &models.QAEnvironment{Name: "foo-bar", Repo: "acme/foo"},
+		RC: &models.RepoConfig{
+			Application: models.RepoConfigAppMetadata{Repo: "acme/foo", Ref: "asdf"},
+			Tests: models.RepoConfigTests{
+				Jobs: []models.TestJob{
+					models.TestJob{Name: "integration", Image: "acme/foo-tests:{{ .Ref }}"},
+				},
+			},
+		},
+	}
+	if err := ci.RunTests(ctx, env); err != nil {
+		t.Fatalf("should have succeeded: %v", err)
+	}
+	jobs, err := fkc.BatchV1().Jobs(ns).List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("error listing jobs: %v", err)
+	}
+	if len(jobs.Items) != 1 || jobs.Items[0].Spec.Template.Spec.Containers[0].Image != "acme/foo-tests:asdf" {
+		t.Fatalf("bad jobs: %+v", jobs.Items)
+	}
+	elog, err := dl.GetEventLogByID(el.ID)
+	if err != nil {
+		t.Fatalf("error getting event log: %v", err)
+	}
+	var found bool
+	for _, l := range elog.Log {
+		if strings.Contains(l, "test job "+jobs.Items[0].Name+"-abcd: fake logs") {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("test job logs missing from event log: %v", elog.Log)
+	}
+
+	env.RC.Tests.Jobs = append(env.RC.Tests.Jobs, models.TestJob{Name: "broken", Image: "acme/foo-tests"})
+	err = ci.RunTests(ctx, env)
+	if err == nil {
+		t.Fatalf("should have failed")
+	}
+	if !strings.Contains(err.Error(), "job broken: job failed: BackoffLimitExceeded") || strings.Contains(err.Error(), "job integration") {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
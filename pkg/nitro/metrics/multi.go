@@ -0,0 +1,35 @@
+package metrics
+
+// MultiCollector is a collector that sends metrics to all configured collectors (eg, Datadog and Prometheus)
+type MultiCollector struct {
+	Collectors []Collector
+}
+
+var _ Collector = &MultiCollector{}
+
+// Timing marks the start of a timed operation with all collectors and returns a function that ends it with all of them
+func (mc *MultiCollector) Timing(name string, tags ...string) (end func(moretags ...string)) {
+	ends := make([]func(moretags ...string), len(mc.Collectors))
+	for i, c := range mc.Collectors {
+		ends[i] = c.Timing(name, tags...)
+	}
+	return func(moretags ...string) {
+		for _, end := range ends {
+			end(moretags...)
+		}
+	}
+}
+
+// Increment increments a counter with all collectors
+func (mc *MultiCollector) Increment(name string, tags ...string) {
+	for _, c := range mc.Collectors {
+		c.Increment(name, tags...)
+	}
+}
+
+// Gauge sets the value of a gauge with all collectors
+func (mc *MultiCollector) Gauge(name string, value float64, tags ...string) {
+	for _, c := range mc.Collectors {
+		c.Gauge(name, value, tags...)
+	}
+}
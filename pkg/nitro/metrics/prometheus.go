@@ -0,0 +1,219 @@
+package metrics
+
+import (
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// PrometheusCollector represents a collector that exposes metrics to be scraped by Prometheus
+// Metrics are created on first use. Prometheus requires a fixed set of labels per metric, so the label names
+// are taken from the tags supplied the first time a metric is used: later missing tags are left empty and unknown tags are dropped (and logged the first time each is seen).
+type PrometheusCollector struct {
+	namespace   string
+	constLabels prometheus.Labels
+	reg         prometheus.Registerer
+	logger      *log.Logger
+
+	sync.Mutex
+	histograms map[string]*promVec
+	counters   map[string]*promVec
+	gauges     map[string]*promVec
+}
+
+type promVec struct {
+	labels  []string
+	dropped map[string]struct{}
+	hv      *prometheus.HistogramVec
+	cv      *prometheus.CounterVec
+	gv      *prometheus.GaugeVec
+}
+
+var _ Collector = &PrometheusCollector{}
+
+// durationBuckets are histogram buckets (in seconds) covering operations that take from under a second to an hour
+var durationBuckets = []float64{0.1, 0.5, 1, 5, 15, 30, 60, 120, 300, 600, 1200, 1800, 3600}
+
+// NewPrometheusCollector returns a PrometheusCollector that registers metrics with reg, using namespace and adding tags to all metrics
+// Tags are strings of the form "[TAG]:[VALUE]"
+func NewPrometheusCollector(namespace string, reg prometheus.Registerer, tags []string, logger *log.Logger) *PrometheusCollector {
+	return &PrometheusCollector{
+		namespace:   sanitizeName(strings.TrimSuffix(namespace, ".")),
+		constLabels: prometheus.Labels(parseTags(tags)),
+		reg:         reg,
+		logger:      logger,
+		histograms:  make(map[string]*promVec),
+		counters:    make(map[string]*promVec),
+		gauges:      make(map[string]*promVec),
+	}
+}
+
+var invalidNameChars = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+
+// sanitizeName converts a dogstatsd-style metric or tag name into a valid Prometheus name
+func sanitizeName(name string) string {
+	return invalidNameChars.ReplaceAllString(name, "_")
+}
+
+// parseTags converts "[TAG]:[VALUE]" strings into labels, ignoring empty or malformed tags
+func parseTags(tags []string) map[string]string {
+	out := make(map[string]string, len(tags))
+	for _, t := range tags {
+		kv := strings.SplitN(t, ":", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			continue
+		}
+		out[sanitizeName(kv[0])] = kv[1]
+	}
+	return out
+}
+
+func (pc *PrometheusCollector) log(msg string, args ...interface{}) {
+	if pc.logger != nil {
+		pc.logger.Printf("prometheus collector: "+msg, args...)
+	}
+}
+
+// vec returns the existing metric vector for name from vecs, or creates and registers a new one with labels using newf
+func (pc *PrometheusCollector) vec(vecs map[string]*promVec, name string, labels map[string]string, newf func(opts prometheus.Opts, labels []string) *promVec) *promVec {
+	pc.Lock()
+	defer pc.Unlock()
+	if v, ok := vecs[name]; ok {
+		return v
+	}
+	lnames := make([]string, 0, len(labels))
+	for k := range labels {
+		lnames = append(lnames, k)
+	}
+	sort.Strings(lnames)
+	v := newf(prometheus.Opts{
+		Namespace:   pc.namespace,
+		Name:        name,
+		Help:        name,
+		ConstLabels: pc.constLabels,
+	}, lnames)
+	v.labels = lnames
+	var c prometheus.Collector
+	switch {
+	case v.hv != nil:
+		c = v.hv
+	case v.cv != nil:
+		c = v.cv
+	default:
+		c = v.gv
+	}
+	if err := pc.reg.Register(c); err != nil {
+		if are, ok := err.(prometheus.AlreadyRegisteredError); ok {
+			// reuse the existing collector (this can happen if multiple collectors share a registry)
+			switch ec := are.ExistingCollector.(type) {
+			case *prometheus.HistogramVec:
+				v.hv = ec
+			case *prometheus.CounterVec:
+				v.cv = ec
+			case *prometheus.GaugeVec:
+				v.gv = ec
+			}
+		} else {
+			pc.log("error registering metric: %v: %v", name, err)
+		}
+	}
+	vecs[name] = v
+	return v
+}
+
+// labelValues returns the values for the labels of v, the vector for metric name, from tags.
+// Tags that aren't labels of v are dropped, which is logged the first time each is seen.
+func (pc *PrometheusCollector) labelValues(name string, v *promVec, tags map[string]string) []string {
+	out := make([]string, len(v.labels))
+	for i, l := range v.labels {
+		out[i] = tags[l]
+	}
+	if countPresent(v.labels, tags) == len(tags) {
+		return out
+	}
+	pc.Lock()
+	defer pc.Unlock()
+	for k := range tags {
+		if hasLabel(v.labels, k) {
+			continue
+		}
+		if _, ok := v.dropped[k]; ok {
+			continue
+		}
+		if v.dropped == nil {
+			v.dropped = make(map[string]struct{})
+		}
+		v.dropped[k] = struct{}{}
+		pc.log("dropping tag %v for metric %v: labels are fixed by the first use (%v)", k, name, strings.Join(v.labels, ", "))
+	}
+	return out
+}
+
+func hasLabel(labels []string, name string) bool {
+	for _, l := range labels {
+		if l == name {
+			return true
+		}
+	}
+	return false
+}
+
+// countPresent returns the number of labels that are present in tags
+func countPresent(labels []string, tags map[string]string) int {
+	var n int
+	for _, l := range labels {
+		if _, ok := tags[l]; ok {
+			n++
+		}
+	}
+	return n
+}
+
+// Timing marks the start of a timed operation and returns a function that should be called when the timed operation is finished
+// Tags is zero or more strings of the form "[TAG]:[VALUE]". Optional additional tags can be provided when end() is called.
+// Durations are recorded in a histogram named [name]_seconds.
+func (pc *PrometheusCollector) Timing(name string, tags ...string) (end func(moretags ...string)) {
+	start := time.Now().UTC()
+	return func(moretags ...string) {
+		since := time.Since(start)
+		labels := parseTags(append(tags, moretags...))
+		mname := sanitizeName(name) + "_seconds"
+		v := pc.vec(pc.histograms, mname, labels, func(opts prometheus.Opts, lnames []string) *promVec {
+			return &promVec{hv: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+				Namespace:   opts.Namespace,
+				Name:        opts.Name,
+				Help:        opts.Help,
+				ConstLabels: opts.ConstLabels,
+				Buckets:     durationBuckets,
+			}, lnames)}
+		})
+		v.hv.WithLabelValues(pc.labelValues(mname, v, labels)...).Observe(since.Seconds())
+	}
+}
+
+// Increment increments a counter named [name]_total.
+// Tags is zero or more strings of the form "[TAG]:[VALUE]"
+func (pc *PrometheusCollector) Increment(name string, tags ...string) {
+	labels := parseTags(tags)
+	mname := sanitizeName(name) + "_total"
+	v := pc.vec(pc.counters, mname, labels, func(opts prometheus.Opts, lnames []string) *promVec {
+		return &promVec{cv: prometheus.NewCounterVec(prometheus.CounterOpts(opts), lnames)}
+	})
+	v.cv.WithLabelValues(pc.labelValues(mname, v, labels)...).Inc()
+}
+
+// Gauge sets the value of a gauge.
+// Tags is zero or more strings of the form "[TAG]:[VALUE]"
+func (pc *PrometheusCollector) Gauge(name string, value float64, tags ...string) {
+	labels := parseTags(tags)
+	mname := sanitizeName(name)
+	v := pc.vec(pc.gauges, mname, labels, func(opts prometheus.Opts, lnames []string) *promVec {
+		return &promVec{gv: prometheus.NewGaugeVec(prometheus.GaugeOpts(opts), lnames)}
+	})
+	v.gv.WithLabelValues(pc.labelValues(mname, v, labels)...).Set(value)
+}
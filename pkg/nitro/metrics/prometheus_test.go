@@ -0,0 +1,95 @@
+package metrics
+
+import (
+	"log"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// gather returns the gathered metrics from reg as a map of "name{label=value,...}" to value (sample count for histograms)
+func gather(t *testing.T, reg *prometheus.Registry) map[string]float64 {
+	mfs, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("error gathering metrics: %v", err)
+	}
+	out := make(map[string]float64)
+	for _, mf := range mfs {
+		for _, m := range mf.GetMetric() {
+			labels := []string{}
+			for _, lp := range m.GetLabel() {
+				labels = append(labels, lp.GetName()+"="+lp.GetValue())
+			}
+			sort.Strings(labels)
+			key := mf.GetName() + "{" + strings.Join(labels, ",") + "}"
+			switch mf.GetType() {
+			case dto.MetricType_COUNTER:
+				out[key] = m.GetCounter().GetValue()
+			case dto.MetricType_GAUGE:
+				out[key] = m.GetGauge().GetValue()
+			case dto.MetricType_HISTOGRAM:
+				out[key] = float64(m.GetHistogram().GetSampleCount())
+			}
+		}
+	}
+	return out
+}
+
+func TestPrometheusCollector(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	pc := NewPrometheusCollector("acyl.nitro.", reg, []string{"env:test", ""}, nil)
+
+	pc.Increment("env.update_in_place", "triggering_repo:acme/widgets")
+	pc.Increment("env.update_in_place", "triggering_repo:acme/widgets")
+	pc.Increment("env.update_in_place", "triggering_repo:acme/other", "unknown:foo")
+	pc.Gauge("env.dependencies", 3, "triggering_repo:acme/widgets")
+	pc.Timing("env.lock_wait", "triggering_repo:acme/widgets")("success:true")
+	pc.Timing("env.lock_wait")()
+
+	got := gather(t, reg)
+	want := map[string]float64{
+		"acyl_nitro_env_update_in_place_total{env=test,triggering_repo=acme/widgets}":          2,
+		"acyl_nitro_env_update_in_place_total{env=test,triggering_repo=acme/other}":            1,
+		"acyl_nitro_env_dependencies{env=test,triggering_repo=acme/widgets}":                   3,
+		"acyl_nitro_env_lock_wait_seconds{env=test,success=true,triggering_repo=acme/widgets}": 1,
+		"acyl_nitro_env_lock_wait_seconds{env=test,success=,triggering_repo=}":                 1,
+	}
+	if len(got) != len(want) {
+		t.Errorf("bad metric count: %v (wanted %v): %v", len(got), len(want), got)
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("bad value for %v: %v (wanted %v)", k, got[k], v)
+		}
+	}
+}
+
+func TestPrometheusCollectorDroppedTags(t *testing.T) {
+	b := &strings.Builder{}
+	pc := NewPrometheusCollector("acyl.nitro.", prometheus.NewRegistry(), nil, log.New(b, "", 0))
+	pc.Increment("env.update_in_place", "triggering_repo:acme/widgets")
+	pc.Increment("env.update_in_place", "triggering_repo:acme/widgets", "unknown:foo")
+	pc.Increment("env.update_in_place", "triggering_repo:acme/widgets", "unknown:bar")
+	if n := strings.Count(b.String(), "dropping tag unknown for metric env_update_in_place_total"); n != 1 {
+		t.Fatalf("expected the dropped tag to be logged once: %v: %v", n, b.String())
+	}
+}
+
+func TestMultiCollector(t *testing.T) {
+	reg1, reg2 := prometheus.NewRegistry(), prometheus.NewRegistry()
+	mc := &MultiCollector{Collectors: []Collector{
+		NewPrometheusCollector("acyl.nitro.", reg1, nil, nil),
+		NewPrometheusCollector("acyl.nitro.", reg2, nil, nil),
+	}}
+	mc.Increment("env.update_in_place", "triggering_repo:acme/widgets")
+	mc.Gauge("env.dependencies", 3, "triggering_repo:acme/widgets")
+	mc.Timing("env.lock_wait", "triggering_repo:acme/widgets")("success:true")
+	for i, reg := range []*prometheus.Registry{reg1, reg2} {
+		if got := gather(t, reg); len(got) != 3 {
+			t.Errorf("collector %v: bad metrics: %v", i, got)
+		}
+	}
+}
@@ -1 +1,88 @@
 package notifier
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/dollarshaveclub/acyl/pkg/ghclient"
+	"github.com/dollarshaveclub/acyl/pkg/models"
+)
+
+// PRCommentMarker is a hidden marker embedded in the PR comment so it can be found and edited by later notifications
+const PRCommentMarker = "<!-- acyl-environment-status -->"
+
+// prCommentTimeout is the maximum amount of time to spend finding and writing the PR comment
+var prCommentTimeout = 30 * time.Second
+
+// GitHubBackend is an object that maintains a single ("sticky") comment on the triggering PR, editing it for each notification
+type GitHubBackend struct {
+	API ghclient.PRCommentClient
+	// User is the GitHub login that API creates comments as. Only comments containing the marker that were written by User are edited,
+	// so that other users cannot hijack the status comment by pasting the marker. It is required, as without it a new comment would be created for every notification.
+	User string
+	// Templates are the markdown templates keyed by event (see NotificationEvent.Key()). If an event is missing, the notification template is used.
+	Templates map[string]models.NotificationTemplate
+}
+
+var _ Backend = &GitHubBackend{}
+
+// Send creates or updates the PR comment with the rendered notification.
+// Environments that are not associated with a PR are ignored.
+func (gb *GitHubBackend) Send(n Notification) error {
+	if n.Data.PullRequest == 0 {
+		return nil
+	}
+	if gb.User == "" {
+		return errors.New("GitHub user is unknown, unable to find the existing PR comment")
+	}
+	body, err := gb.render(n)
+	if err != nil {
+		return fmt.Errorf("error rendering notification: %w", err)
+	}
+	ctx, cf := context.WithTimeout(context.Background(), prCommentTimeout)
+	defer cf()
+	cmts, err := gb.API.ListPRComments(ctx, n.Data.Repo, n.Data.PullRequest)
+	if err != nil {
+		return fmt.Errorf("error listing PR comments: %w", err)
+	}
+	// if there happen to be multiple, edit the most recent
+	for i := len(cmts) - 1; i >= 0; i-- {
+		if cmts[i].User == gb.User && strings.Contains(cmts[i].Body, PRCommentMarker) {
+			if err := gb.API.EditPRComment(ctx, n.Data.Repo, cmts[i].ID, body); err != nil {
+				return fmt.Errorf("error editing PR comment: %w", err)
+			}
+			return nil
+		}
+	}
+	if _, err := gb.API.CreatePRComment(ctx, n.Data.Repo, n.Data.PullRequest, body); err != nil {
+		return fmt.Errorf("error creating PR comment: %w", err)
+	}
+	return nil
+}
+
+func (gb *GitHubBackend) render(n Notification) (string, error) {
+	tmpl, ok := gb.Templates[n.Event.Key()]
+	if !ok {
+		tmpl = n.Template
+	}
+	rn, err := tmpl.Render(n.Data)
+	if err != nil {
+		return "", fmt.Errorf("error rendering template: %w", err)
+	}
+	b := &strings.Builder{}
+	b.WriteString(PRCommentMarker + "\n")
+	b.WriteString("### " + rn.Title + "\n")
+	for _, s := range rn.Sections {
+		b.WriteString("\n")
+		if s.Title != "" {
+			b.WriteString("**" + s.Title + "**\n\n")
+		}
+		if s.Text != "" {
+			b.WriteString(s.Text + "\n")
+		}
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,132 @@
+package notifier
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/dollarshaveclub/acyl/pkg/ghclient"
+	"github.com/dollarshaveclub/acyl/pkg/models"
+)
+
+func TestGitHubBackendSend(t *testing.T) {
+	var comments []ghclient.PRComment
+	var created, edited int
+	frc := &ghclient.FakeRepoClient{
+		ListPRCommentsFunc: func(ctx context.Context, repo string, pr uint) ([]ghclient.PRComment, error) {
+			if repo != "foo/bar" || pr != 1 {
+				t.Errorf("bad repo or pr: %v: %v", repo, pr)
+			}
+			return comments, nil
+		},
+		CreatePRCommentFunc: func(ctx context.Context, repo string, pr uint, body string) (ghclient.PRComment, error) {
+			created++
+			c := ghclient.PRComment{ID: int64(len(comments) + 1), User: "acyl-bot", Body: body}
+			comments = append(comments, c)
+			return c, nil
+		},
+		EditPRCommentFunc: func(ctx context.Context, repo string, id int64, body string) error {
+			edited++
+			for i := range comments {
+				if comments[i].ID == id {
+					comments[i].Body = body
+				}
+			}
+			return nil
+		},
+	}
+	comments = append(comments, ghclient.PRComment{ID: 1, User: "alice", Body: "unrelated comment"})
+	nc := models.Notifications{}
+	nc.FillMissingTemplates()
+	gb := &GitHubBackend{API: frc, User: "acyl-bot", Templates: nc.GitHub.PRCommentTemplates}
+	n := Notification{
+		Event: CreateEnvironment,
+		Data: models.NotificationData{
+			EnvName:     "foo-bar",
+			Repo:        "foo/bar",
+			PullRequest: 1,
+			SourceSHA:   "asdf",
+		},
+		Template: nc.Templates[CreateEnvironment.Key()],
+	}
+	if err := gb.Send(n); err != nil {
+		t.Fatalf("create should have succeeded: %v", err)
+	}
+	if created != 1 || edited != 0 {
+		t.Fatalf("expected a new comment: created: %v, edited: %v", created, edited)
+	}
+	n.Event = Failure
+	n.Data.ErrorMessage = "something broke"
+	if err := gb.Send(n); err != nil {
+		t.Fatalf("failure should have succeeded: %v", err)
+	}
+	if created != 1 || edited != 1 {
+		t.Fatalf("expected existing comment to be edited: created: %v, edited: %v", created, edited)
+	}
+	if len(comments) != 2 {
+		t.Fatalf("bad comment count: %v", len(comments))
+	}
+	if comments[0].Body != "unrelated comment" {
+		t.Fatalf("unrelated comment was modified: %v", comments[0].Body)
+	}
+	body := comments[1].Body
+	if !strings.HasPrefix(body, PRCommentMarker) {
+		t.Fatalf("comment missing marker: %v", body)
+	}
+	if !strings.Contains(body, "something broke") || !strings.Contains(body, "`foo-bar`") {
+		t.Fatalf("unexpected comment body: %v", body)
+	}
+	// environments without a PR should be ignored
+	n.Data.PullRequest = 0
+	frc.ListPRCommentsFunc = func(ctx context.Context, repo string, pr uint) ([]ghclient.PRComment, error) {
+		t.Fatalf("should not have been called")
+		return nil, nil
+	}
+	if err := gb.Send(n); err != nil {
+		t.Fatalf("no PR should have succeeded: %v", err)
+	}
+}
+
+func TestGitHubBackendSendIgnoresOtherUsersMarker(t *testing.T) {
+	comments := []ghclient.PRComment{
+		{ID: 1, User: "acyl-bot", Body: PRCommentMarker + "\nstatus"},
+		{ID: 2, User: "mallory", Body: PRCommentMarker + "\nhijacked"},
+	}
+	var edits []int64
+	var created int
+	frc := &ghclient.FakeRepoClient{
+		ListPRCommentsFunc: func(ctx context.Context, repo string, pr uint) ([]ghclient.PRComment, error) {
+			return comments, nil
+		},
+		CreatePRCommentFunc: func(ctx context.Context, repo string, pr uint, body string) (ghclient.PRComment, error) {
+			created++
+			return ghclient.PRComment{}, nil
+		},
+		EditPRCommentFunc: func(ctx context.Context, repo string, id int64, body string) error {
+			edits = append(edits, id)
+			return nil
+		},
+	}
+	nc := models.Notifications{}
+	nc.FillMissingTemplates()
+	n := Notification{
+		Event:    CreateEnvironment,
+		Data:     models.NotificationData{EnvName: "foo-bar", Repo: "foo/bar", PullRequest: 1},
+		Template: nc.Templates[CreateEnvironment.Key()],
+	}
+	gb := &GitHubBackend{API: frc, User: "acyl-bot", Templates: nc.GitHub.PRCommentTemplates}
+	if err := gb.Send(n); err != nil {
+		t.Fatalf("should have succeeded: %v", err)
+	}
+	if len(edits) != 1 || edits[0] != 1 || created != 0 {
+		t.Fatalf("expected only the comment written by acyl to be edited: edits: %v, created: %v", edits, created)
+	}
+	// without a known user, the existing comment can't be found, so nothing is written rather than creating a duplicate
+	gb.User = ""
+	if err := gb.Send(n); err == nil {
+		t.Fatalf("should have failed without a user")
+	}
+	if len(edits) != 1 || created != 0 {
+		t.Fatalf("expected no comments to be written: edits: %v, created: %v", edits, created)
+	}
+}
@@ -14,11 +14,12 @@ func _() {
 	_ = x[Success-3]
 	_ = x[Failure-4]
 	_ = x[EnvironmentLimitExceeded-5]
+	_ = x[ExpiryWarning-6]
 }
 
-const _NotificationEvent_name = "CreateEnvironmentUpdateEnvironmentDestroyEnvironmentSuccessFailureEnvironmentLimitExceeded"
+const _NotificationEvent_name = "CreateEnvironmentUpdateEnvironmentDestroyEnvironmentSuccessFailureEnvironmentLimitExceededExpiryWarning"
 
-var _NotificationEvent_index = [...]uint8{0, 17, 34, 52, 59, 66, 90}
+var _NotificationEvent_index = [...]uint8{0, 17, 34, 52, 59, 66, 90, 103}
 
 func (i NotificationEvent) String() string {
 	if i < 0 || i >= NotificationEvent(len(_NotificationEvent_index)-1) {
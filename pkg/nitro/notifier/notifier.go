@@ -23,6 +23,8 @@ const (
 	Failure
 	// EnvironmentLimitExceeded occurs when an environment is destroyed due to the environment limit
 	EnvironmentLimitExceeded
+	// ExpiryWarning occurs before an environment is destroyed because its lifetime expires
+	ExpiryWarning
 )
 
 // Key maps NotificationEvents to notification template names
@@ -40,6 +42,8 @@ func (ne NotificationEvent) Key() string {
 		return "failure"
 	case EnvironmentLimitExceeded:
 		return "destroy"
+	case ExpiryWarning:
+		return "expiring"
 	default:
 		return "<unknown>"
 	}
@@ -50,6 +54,8 @@ type Notification struct {
 	Event    NotificationEvent
 	Template models.NotificationTemplate
 	Data     models.NotificationData
+	// Untrusted is set if the environment is for a PR from a fork, whose acyl.yml may not be trusted
+	Untrusted bool
 }
 
 // Backend describes an object that can send a notification somewhere
@@ -0,0 +1,257 @@
+package notifier
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dollarshaveclub/acyl/pkg/models"
+	"github.com/google/uuid"
+	multierror "github.com/hashicorp/go-multierror"
+)
+
+// WebhookEnvelopeVersion is the version of the JSON payload sent by WebhookBackend. It is incremented for incompatible changes.
+const WebhookEnvelopeVersion = 1
+
+// Webhook request headers
+const (
+	WebhookSignatureHeader = "X-Acyl-Signature-256"
+	WebhookEventHeader     = "X-Acyl-Event"
+	WebhookDeliveryHeader  = "X-Acyl-Delivery"
+)
+
+// Webhook delivery defaults
+var (
+	DefaultWebhookMaxAttempts    = 4
+	DefaultWebhookInitialBackoff = 1 * time.Second
+	DefaultWebhookTimeout        = 10 * time.Second
+	// DefaultWebhookDeliveryTimeout bounds the total time spent delivering a notification to a URL, including retries
+	DefaultWebhookDeliveryTimeout = 1 * time.Minute
+)
+
+// WebhookEnvelope is the JSON payload POSTed to each webhook URL
+type WebhookEnvelope struct {
+	Version int    `json:"version"`
+	ID      string `json:"id"`
+	// Event is the notification event name (ex: "Success") and EventKey is the template key for the event (ex: "success")
+	Event     string                      `json:"event"`
+	EventKey  string                      `json:"event_key"`
+	Timestamp time.Time                   `json:"timestamp"`
+	Data      models.NotificationData     `json:"data"`
+	Rendered  models.RenderedNotification `json:"rendered"`
+}
+
+// WebhookBackend is an object that POSTs notifications as signed JSON to one or more URLs
+// Each request body is signed with HMAC-SHA256 using Secret and the hex-encoded signature is sent in WebhookSignatureHeader as "sha256=<signature>".
+// Failed requests (network errors, 429 and 5xx responses) are retried with exponential backoff.
+// Delivery happens asynchronously so that unreachable URLs don't delay other notifications, and only URLs with hosts in AllowedHosts are sent to.
+type WebhookBackend struct {
+	// URLs are supplied by the repo (acyl.yml) and are not sent to for untrusted environments (PRs from forks)
+	URLs []string
+	// TrustedURLs are configured by the server admin (eg, the default notifications) and are sent to for all environments
+	TrustedURLs []string
+	Secret      []byte
+	// AllowedHosts are the hostnames (or wildcard domains like "*.example.com") that URLs may use. URLs with other hosts are refused.
+	AllowedHosts []string
+	// LogFunc is optional and receives asynchronous delivery errors
+	LogFunc func(string, ...interface{})
+	// HTTPClient is optional (if nil a client with DefaultWebhookTimeout is used)
+	HTTPClient *http.Client
+	// MaxAttempts and InitialBackoff are optional (if zero the defaults are used)
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	// DeliveryTimeout is optional (if zero DefaultWebhookDeliveryTimeout is used)
+	DeliveryTimeout time.Duration
+
+	wg sync.WaitGroup
+}
+
+var _ Backend = &WebhookBackend{}
+
+// Send validates the configured URLs and POSTs n to those that are allowed in the background.
+// Notifications for untrusted environments (PRs from forks) are only sent to TrustedURLs, since URLs may come from the fork's acyl.yml.
+// The returned error only describes refused URLs, delivery errors are passed to LogFunc.
+func (wb *WebhookBackend) Send(n Notification) error {
+	candidates := append([]string{}, wb.TrustedURLs...)
+	if n.Untrusted {
+		if len(wb.URLs) > 0 {
+			wb.log("not sending webhooks to repo URLs for untrusted environment: %v", n.Data.EnvName)
+		}
+	} else {
+		candidates = append(candidates, wb.URLs...)
+	}
+	var merr *multierror.Error
+	seen := make(map[string]struct{}, len(candidates))
+	urls := make([]string, 0, len(candidates))
+	for _, u := range candidates {
+		if _, ok := seen[u]; ok {
+			continue
+		}
+		seen[u] = struct{}{}
+		if err := wb.allowed(u); err != nil {
+			merr = multierror.Append(merr, fmt.Errorf("refusing to send webhook to %v: %w", u, err))
+			continue
+		}
+		urls = append(urls, u)
+	}
+	if len(urls) == 0 {
+		return merr.ErrorOrNil()
+	}
+	env, body, err := wb.render(n)
+	if err != nil {
+		return fmt.Errorf("error rendering notification: %w", err)
+	}
+	timeout := wb.DeliveryTimeout
+	if timeout <= 0 {
+		timeout = DefaultWebhookDeliveryTimeout
+	}
+	for _, u := range urls {
+		wb.wg.Add(1)
+		go func(u string) {
+			defer wb.wg.Done()
+			ctx, cf := context.WithTimeout(context.Background(), timeout)
+			defer cf()
+			if err := wb.deliver(ctx, u, env, body); err != nil {
+				wb.log("error sending webhook to %v: %v", u, err)
+			}
+		}(u)
+	}
+	return merr.ErrorOrNil()
+}
+
+// wait blocks until all background deliveries have finished
+func (wb *WebhookBackend) wait() {
+	wb.wg.Wait()
+}
+
+func (wb *WebhookBackend) log(msg string, args ...interface{}) {
+	if wb.LogFunc != nil {
+		wb.LogFunc(msg, args...)
+	}
+}
+
+// allowed returns an error if rawurl isn't an HTTP(S) URL with a host in AllowedHosts
+func (wb *WebhookBackend) allowed(rawurl string) error {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return fmt.Errorf("malformed url: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("unsupported scheme: %v", u.Scheme)
+	}
+	host := strings.ToLower(u.Hostname())
+	for _, ah := range wb.AllowedHosts {
+		ah = strings.ToLower(ah)
+		if host == ah || (strings.HasPrefix(ah, "*.") && strings.HasSuffix(host, ah[1:])) {
+			return nil
+		}
+	}
+	return fmt.Errorf("host not allowed: %v", host)
+}
+
+// render returns the envelope for n along with the encoded request body
+func (wb *WebhookBackend) render(n Notification) (*WebhookEnvelope, []byte, error) {
+	rn, err := n.Template.Render(n.Data)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error rendering template: %w", err)
+	}
+	id, err := uuid.NewRandom()
+	if err != nil {
+		return nil, nil, fmt.Errorf("error generating delivery id: %w", err)
+	}
+	env := &WebhookEnvelope{
+		Version:   WebhookEnvelopeVersion,
+		ID:        id.String(),
+		Event:     n.Event.String(),
+		EventKey:  n.Event.Key(),
+		Timestamp: time.Now().UTC(),
+		Data:      n.Data,
+		Rendered:  *rn,
+	}
+	body, err := json.Marshal(env)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error marshaling envelope: %w", err)
+	}
+	return env, body, nil
+}
+
+// WebhookSignature returns the value of WebhookSignatureHeader for body signed with secret
+func WebhookSignature(secret, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// deliver POSTs body to url, retrying with backoff on retryable failures until ctx is done
+func (wb *WebhookBackend) deliver(ctx context.Context, url string, env *WebhookEnvelope, body []byte) error {
+	hc := wb.HTTPClient
+	if hc == nil {
+		hc = &http.Client{Timeout: DefaultWebhookTimeout}
+	}
+	attempts := wb.MaxAttempts
+	if attempts <= 0 {
+		attempts = DefaultWebhookMaxAttempts
+	}
+	backoff := wb.InitialBackoff
+	if backoff <= 0 {
+		backoff = DefaultWebhookInitialBackoff
+	}
+	sig := WebhookSignature(wb.Secret, body)
+	var err error
+	for i := 0; i < attempts; i++ {
+		if i > 0 {
+			select {
+			case <-ctx.Done():
+				return fmt.Errorf("giving up after %v attempts: %v: %w", i, ctx.Err(), err)
+			case <-time.After(backoff):
+			}
+			backoff *= 2
+		}
+		var retry bool
+		retry, err = wb.post(ctx, hc, url, env.Event, env.ID, sig, body)
+		if err == nil || !retry {
+			return err
+		}
+	}
+	return fmt.Errorf("giving up after %v attempts: %w", attempts, err)
+}
+
+// post performs a single webhook request, returning whether the request should be retried if it failed
+func (wb *WebhookBackend) post(ctx context.Context, hc *http.Client, url, event, id, sig string, body []byte) (bool, error) {
+	ctx, cf := context.WithTimeout(ctx, DefaultWebhookTimeout)
+	defer cf()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return false, fmt.Errorf("error creating request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "acyl-webhook")
+	req.Header.Set(WebhookEventHeader, event)
+	req.Header.Set(WebhookDeliveryHeader, id)
+	req.Header.Set(WebhookSignatureHeader, sig)
+	resp, err := hc.Do(req)
+	if err != nil {
+		return true, fmt.Errorf("error performing request: %w", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 1024*1024))
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return true, fmt.Errorf("unexpected status code: %v", resp.StatusCode)
+	default:
+		return false, fmt.Errorf("unexpected status code: %v", resp.StatusCode)
+	}
+}
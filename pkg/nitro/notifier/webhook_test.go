@@ -0,0 +1,227 @@
+package notifier
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dollarshaveclub/acyl/pkg/models"
+)
+
+func TestWebhookBackendSend(t *testing.T) {
+	secret := []byte("asdf")
+	n := Notification{
+		Event: Success,
+		Template: models.NotificationTemplate{
+			Title: "Environment {{ .EnvName }} is ready",
+			Sections: []models.NotificationTemplateSection{
+				models.NotificationTemplateSection{Title: "Repo", Text: "{{ .Repo }}", Style: "good"},
+			},
+		},
+		Data: models.NotificationData{
+			EnvName:     "foo-bar",
+			Repo:        "acme/widgets",
+			PullRequest: 12,
+		},
+	}
+	var mtx sync.Mutex
+	var calls int
+	var envelope WebhookEnvelope
+	var status []int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mtx.Lock()
+		defer mtx.Unlock()
+		calls++
+		body, _ := ioutil.ReadAll(r.Body)
+		if sig := r.Header.Get(WebhookSignatureHeader); sig != WebhookSignature(secret, body) {
+			t.Errorf("bad signature: %v", sig)
+		}
+		if ev := r.Header.Get(WebhookEventHeader); ev != "Success" {
+			t.Errorf("bad event header: %v", ev)
+		}
+		if err := json.Unmarshal(body, &envelope); err != nil {
+			t.Errorf("error unmarshaling body: %v", err)
+		}
+		if id := r.Header.Get(WebhookDeliveryHeader); id != envelope.ID {
+			t.Errorf("bad delivery header: %v (wanted %v)", id, envelope.ID)
+		}
+		if len(status) > 0 {
+			w.WriteHeader(status[0])
+			status = status[1:]
+		}
+	}))
+	defer srv.Close()
+	var errs []string
+	wb := &WebhookBackend{
+		URLs:           []string{srv.URL},
+		Secret:         secret,
+		AllowedHosts:   []string{"127.0.0.1"},
+		LogFunc:        func(msg string, args ...interface{}) { errs = append(errs, fmt.Sprintf(msg, args...)) },
+		MaxAttempts:    3,
+		InitialBackoff: time.Millisecond,
+	}
+	send := func() error {
+		errs = nil
+		if err := wb.Send(n); err != nil {
+			return err
+		}
+		wb.wait()
+		if len(errs) > 0 {
+			return errors.New(strings.Join(errs, ", "))
+		}
+		return nil
+	}
+
+	if err := send(); err != nil {
+		t.Fatalf("should have succeeded: %v", err)
+	}
+	if calls != 1 {
+		t.Fatalf("expected 1 call: %v", calls)
+	}
+	if envelope.Version != WebhookEnvelopeVersion || envelope.EventKey != "success" {
+		t.Errorf("bad envelope: %+v", envelope)
+	}
+	if envelope.Data.EnvName != "foo-bar" || envelope.Data.PullRequest != 12 {
+		t.Errorf("bad envelope data: %+v", envelope.Data)
+	}
+	if envelope.Rendered.Title != "Environment foo-bar is ready" || len(envelope.Rendered.Sections) != 1 || envelope.Rendered.Sections[0].Text != "acme/widgets" {
+		t.Errorf("bad rendered notification: %+v", envelope.Rendered)
+	}
+
+	// retried until success
+	calls = 0
+	status = []int{http.StatusBadGateway, http.StatusTooManyRequests}
+	if err := send(); err != nil {
+		t.Fatalf("should have succeeded after retries: %v", err)
+	}
+	if calls != 3 {
+		t.Fatalf("expected 3 calls: %v", calls)
+	}
+
+	// all attempts fail
+	calls = 0
+	status = []int{http.StatusInternalServerError, http.StatusInternalServerError, http.StatusInternalServerError}
+	if err := send(); err == nil || !strings.Contains(err.Error(), "giving up after 3 attempts") {
+		t.Fatalf("should have failed: %v", err)
+	}
+
+	// client errors aren't retried
+	calls = 0
+	status = []int{http.StatusBadRequest}
+	if err := send(); err == nil {
+		t.Fatalf("should have failed")
+	}
+	if calls != 1 {
+		t.Fatalf("expected 1 call: %v", calls)
+	}
+}
+
+func TestWebhookBackendRefused(t *testing.T) {
+	var calls int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer srv.Close()
+	n := Notification{
+		Event: Success,
+		Data:  models.NotificationData{EnvName: "foo-bar"},
+	}
+	var errs []string
+	wb := &WebhookBackend{
+		URLs:            []string{"http://169.254.169.254/latest/meta-data", "file:///etc/passwd", "https://evil-example.com/hook", "https://hooks.example.com/acyl", srv.URL},
+		AllowedHosts:    []string{"*.example.com", "127.0.0.1"},
+		LogFunc:         func(msg string, args ...interface{}) { errs = append(errs, fmt.Sprintf(msg, args...)) },
+		MaxAttempts:     100,
+		InitialBackoff:  10 * time.Millisecond,
+		DeliveryTimeout: 50 * time.Millisecond,
+	}
+	for _, u := range wb.URLs {
+		err := wb.allowed(u)
+		switch u {
+		case "https://hooks.example.com/acyl", srv.URL:
+			if err != nil {
+				t.Errorf("%v should have been allowed: %v", u, err)
+			}
+		default:
+			if err == nil {
+				t.Errorf("%v should have been refused", u)
+			}
+		}
+	}
+
+	// repo URLs are never sent for untrusted environments
+	n.Untrusted = true
+	if err := wb.Send(n); err != nil {
+		t.Fatalf("untrusted should have succeeded: %v", err)
+	}
+	wb.wait()
+	if calls != 0 {
+		t.Fatalf("expected no calls: %v", calls)
+	}
+
+	// only allowed URLs are sent to, and retries stop at the delivery timeout
+	wb.URLs = []string{"http://169.254.169.254/latest/meta-data", srv.URL}
+	n.Untrusted = false
+	errs = nil
+	start := time.Now()
+	if err := wb.Send(n); err == nil || !strings.Contains(err.Error(), "host not allowed: 169.254.169.254") {
+		t.Fatalf("should have refused url: %v", err)
+	}
+	wb.wait()
+	if d := time.Since(start); d > time.Second {
+		t.Fatalf("delivery should have been bounded by the timeout: %v", d)
+	}
+	if calls == 0 || calls >= wb.MaxAttempts {
+		t.Fatalf("bad call count: %v", calls)
+	}
+	if len(errs) != 1 || !strings.Contains(errs[0], "context deadline exceeded") {
+		t.Fatalf("bad errors: %v", errs)
+	}
+}
+
+func TestWebhookBackendUntrusted(t *testing.T) {
+	var repoCalls, trustedCalls int32
+	repo := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&repoCalls, 1)
+	}))
+	defer repo.Close()
+	trusted := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&trustedCalls, 1)
+	}))
+	defer trusted.Close()
+	wb := &WebhookBackend{
+		URLs:         []string{repo.URL, trusted.URL},
+		TrustedURLs:  []string{trusted.URL},
+		AllowedHosts: []string{"127.0.0.1"},
+	}
+	n := Notification{
+		Event:     Success,
+		Data:      models.NotificationData{EnvName: "foo-bar"},
+		Untrusted: true,
+	}
+	if err := wb.Send(n); err != nil {
+		t.Fatalf("should have succeeded: %v", err)
+	}
+	wb.wait()
+	if repoCalls != 0 || trustedCalls != 1 {
+		t.Fatalf("only the trusted URL should have been sent to: repo: %v, trusted: %v", repoCalls, trustedCalls)
+	}
+	// trusted environments are sent to all URLs, once each
+	n.Untrusted = false
+	if err := wb.Send(n); err != nil {
+		t.Fatalf("should have succeeded: %v", err)
+	}
+	wb.wait()
+	if repoCalls != 1 || trustedCalls != 2 {
+		t.Fatalf("all URLs should have been sent to: repo: %v, trusted: %v", repoCalls, trustedCalls)
+	}
+}
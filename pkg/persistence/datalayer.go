@@ -34,6 +34,10 @@ type DataLayer interface {
 	SetQAEnvironmentRefMap(context.Context, string, RefMap) error
 	SetQAEnvironmentCommitSHAMap(context.Context, string, RefMap) error
 	SetQAEnvironmentCreated(context.Context, string, time.Time) error
+	SetQAEnvironmentPinnedUntil(ctx context.Context, name string, until *time.Time) error
+	SetQAEnvironmentLastAccessed(ctx context.Context, name string, accessed time.Time) error
+	SetQAEnvironmentExpires(ctx context.Context, name string, expires *time.Time) error
+	SetQAEnvironmentExpiryWarned(ctx context.Context, name string) error
 	GetExtantQAEnvironments(context.Context, string, uint) ([]QAEnvironment, error)
 	SetAminoEnvironmentID(ctx context.Context, name string, did int) error
 	SetAminoServiceToPort(ctx context.Context, name string, serviceToPort map[string]int64) error
@@ -66,6 +70,8 @@ type K8sEnvDataLayer interface {
 	CreateK8sEnv(ctx context.Context, env *models.KubernetesEnvironment) error
 	DeleteK8sEnv(ctx context.Context, name string) error
 	UpdateK8sEnvConfigSignature(ctx context.Context, name string, confSig [32]byte) error
+	UpdateK8sEnvRepoConfig(ctx context.Context, name string, rcyaml []byte, refMapJSON string, confSig [32]byte) error
+	GetK8sEnvCountsByCluster(ctx context.Context) (map[string]uint, error)
 }
 
 // EventLoggerDataLayer desribes an object that stores event log data
@@ -88,11 +94,15 @@ type EventLoggerDataLayer interface {
 	SetEventStatusImageStarted(id uuid.UUID, name string) error
 	SetEventStatusImageBuildID(id uuid.UUID, name string, furanBuildID guuid.UUID) error
 	SetEventStatusImageCompleted(id uuid.UUID, name string, err bool) error
+	SetEventStatusImageCached(id uuid.UUID, name string) error
 	SetEventStatusChartStarted(id uuid.UUID, name string, status models.NodeChartStatus) error
 	SetEventStatusChartCompleted(id uuid.UUID, name string, status models.NodeChartStatus) error
+	SetEventStatusHealthCheckStarted(id uuid.UUID, name, check string) error
+	SetEventStatusHealthCheckCompleted(id uuid.UUID, name, check string, failed bool, message string) error
 	GetEventStatus(id uuid.UUID) (*models.EventStatusSummary, error)
 	SetEventStatusRenderedStatus(id uuid.UUID, rstatus models.RenderedEventStatus) error
 	SetEventStatusFailed(id uuid.UUID, ce metahelm.ChartError) error
+	SubscribeEventLog(ctx context.Context, id uuid.UUID) (<-chan struct{}, error)
 }
 
 type UISessionsDataLayer interface {
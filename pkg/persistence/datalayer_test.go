@@ -375,6 +375,108 @@ func TestDataLayerSetQAEnvironmentCreated(t *testing.T) {
 	}
 }
 
+func TestDataLayerSetQAEnvironmentPinnedUntil(t *testing.T) {
+	dl, tdl := NewTestDataLayer(t)
+	if err := tdl.Setup(testDataPath); err != nil {
+		t.Fatalf("error setting up test database: %v", err)
+	}
+	defer tdl.TearDown()
+	until := time.Now().UTC().Add(24 * time.Hour).Truncate(1 * time.Microsecond)
+	if err := dl.SetQAEnvironmentPinnedUntil(context.Background(), "foo-bar", &until); err != nil {
+		t.Fatalf("set should have succeeded: %v", err)
+	}
+	qae, err := dl.GetQAEnvironmentConsistently(context.Background(), "foo-bar")
+	if err != nil {
+		t.Fatalf("get should have succeeded: %v", err)
+	}
+	if qae.PinnedUntil == nil || !qae.PinnedUntil.Equal(until) {
+		t.Fatalf("wrong pinned until: %v (wanted: %v)", qae.PinnedUntil, until)
+	}
+	if err := dl.SetQAEnvironmentPinnedUntil(context.Background(), "foo-bar", nil); err != nil {
+		t.Fatalf("unset should have succeeded: %v", err)
+	}
+	qae, err = dl.GetQAEnvironmentConsistently(context.Background(), "foo-bar")
+	if err != nil {
+		t.Fatalf("get should have succeeded: %v", err)
+	}
+	if qae.PinnedUntil != nil {
+		t.Fatalf("pinned until should have been nil: %v", qae.PinnedUntil)
+	}
+}
+
+func TestDataLayerSetQAEnvironmentLastAccessed(t *testing.T) {
+	dl, tdl := NewTestDataLayer(t)
+	if err := tdl.Setup(testDataPath); err != nil {
+		t.Fatalf("error setting up test database: %v", err)
+	}
+	defer tdl.TearDown()
+	ts := time.Now().UTC().Truncate(1 * time.Microsecond)
+	if err := dl.SetQAEnvironmentLastAccessed(context.Background(), "foo-bar", ts); err != nil {
+		t.Fatalf("set should have succeeded: %v", err)
+	}
+	// an earlier access must not move the timestamp backwards
+	if err := dl.SetQAEnvironmentLastAccessed(context.Background(), "foo-bar", ts.Add(-1*time.Hour)); err != nil {
+		t.Fatalf("set should have succeeded: %v", err)
+	}
+	qae, err := dl.GetQAEnvironmentConsistently(context.Background(), "foo-bar")
+	if err != nil {
+		t.Fatalf("get should have succeeded: %v", err)
+	}
+	if qae.LastAccessed == nil || !qae.LastAccessed.Equal(ts) {
+		t.Fatalf("wrong last accessed: %v (wanted: %v)", qae.LastAccessed, ts)
+	}
+	if !qae.LastActivity().Equal(ts) {
+		t.Fatalf("last activity should be the last access: %v", qae.LastActivity())
+	}
+}
+
+func TestDataLayerSetQAEnvironmentExpires(t *testing.T) {
+	dl, tdl := NewTestDataLayer(t)
+	if err := tdl.Setup(testDataPath); err != nil {
+		t.Fatalf("error setting up test database: %v", err)
+	}
+	defer tdl.TearDown()
+	expires := time.Now().UTC().Add(72 * time.Hour).Truncate(1 * time.Microsecond)
+	if err := dl.SetQAEnvironmentExpires(context.Background(), "foo-bar", &expires); err != nil {
+		t.Fatalf("set should have succeeded: %v", err)
+	}
+	if err := dl.SetQAEnvironmentExpiryWarned(context.Background(), "foo-bar"); err != nil {
+		t.Fatalf("set warned should have succeeded: %v", err)
+	}
+	qae, err := dl.GetQAEnvironmentConsistently(context.Background(), "foo-bar")
+	if err != nil {
+		t.Fatalf("get should have succeeded: %v", err)
+	}
+	if qae.Expires == nil || !qae.Expires.Equal(expires) {
+		t.Fatalf("wrong expires: %v (wanted: %v)", qae.Expires, expires)
+	}
+	if !qae.ExpiryWarned {
+		t.Fatalf("expiry warned should have been set")
+	}
+	// a new expiry resets the warning
+	expires = expires.Add(24 * time.Hour)
+	if err := dl.SetQAEnvironmentExpires(context.Background(), "foo-bar", &expires); err != nil {
+		t.Fatalf("set should have succeeded: %v", err)
+	}
+	qae, err = dl.GetQAEnvironmentConsistently(context.Background(), "foo-bar")
+	if err != nil {
+		t.Fatalf("get should have succeeded: %v", err)
+	}
+	if qae.Expires == nil || !qae.Expires.Equal(expires) || qae.ExpiryWarned {
+		t.Fatalf("wrong expiry: %v (warned: %v)", qae.Expires, qae.ExpiryWarned)
+	}
+	if err := dl.SetQAEnvironmentExpires(context.Background(), "foo-bar", nil); err != nil {
+		t.Fatalf("unset should have succeeded: %v", err)
+	}
+	qae, err = dl.GetQAEnvironmentConsistently(context.Background(), "foo-bar")
+	if err != nil {
+		t.Fatalf("get should have succeeded: %v", err)
+	}
+	if qae.Expires != nil {
+		t.Fatalf("expires should have been nil: %v", qae.Expires)
+	}
+}
+
 func TestDataLayerGetExtantQAEnvironments(t *testing.T) {
 	dl, tdl := NewTestDataLayer(t)
 	if err := tdl.Setup(testDataPath); err != nil {
@@ -1448,6 +1550,24 @@ func TestDataLayerDeleteK8sEnv(t *testing.T) {
 	}
 }
 
+func TestDataLayerGetK8sEnvCountsByCluster(t *testing.T) {
+	dl, tdl := NewTestDataLayer(t)
+	if err := tdl.Setup(testDataPath); err != nil {
+		t.Fatalf("error setting up test database: %v", err)
+	}
+	defer tdl.TearDown()
+	if err := dl.CreateK8sEnv(context.Background(), &models.KubernetesEnvironment{EnvName: "foo-bar-2", Cluster: "qa-2"}); err != nil {
+		t.Fatalf("create should have succeeded: %v", err)
+	}
+	counts, err := dl.GetK8sEnvCountsByCluster(context.Background())
+	if err != nil {
+		t.Fatalf("should have succeeded: %v", err)
+	}
+	if counts[""] != 1 || counts["qa-2"] != 1 {
+		t.Fatalf("bad counts: %v", counts)
+	}
+}
+
 func TestDataLayerUpdateK8sEnvConfSignature(t *testing.T) {
 	dl, tdl := NewTestDataLayer(t)
 	if err := tdl.Setup(testDataPath); err != nil {
@@ -1468,6 +1588,32 @@ func TestDataLayerUpdateK8sEnvConfSignature(t *testing.T) {
 	}
 }
 
+func TestDataLayerUpdateK8sEnvRepoConfig(t *testing.T) {
+	dl, tdl := NewTestDataLayer(t)
+	if err := tdl.Setup(testDataPath); err != nil {
+		t.Fatalf("error setting up test database: %v", err)
+	}
+	defer tdl.TearDown()
+	var confSig [32]byte
+	copy(confSig[:], []byte("f0o0o0b0a0r0n0e0w0s0i0g0n0a0t0u0"))
+	if err := dl.UpdateK8sEnvRepoConfig(context.Background(), "foo-bar", []byte("version: 2"), `{"foo/bar":"master"}`, confSig); err != nil {
+		t.Fatalf("should have succeeded: %v", err)
+	}
+	env, err := dl.GetK8sEnv(context.Background(), "foo-bar")
+	if err != nil {
+		t.Fatalf("get env should have succeeded: %v", err)
+	}
+	if string(env.RepoConfigYAML) != "version: 2" {
+		t.Fatalf("bad repo config: %v", string(env.RepoConfigYAML))
+	}
+	if env.RefMapJSON != `{"foo/bar":"master"}` {
+		t.Fatalf("bad ref map: %v", env.RefMapJSON)
+	}
+	if compResult := bytes.Compare(env.ConfigSignature, confSig[:]); compResult != 0 {
+		t.Fatalf("bad config signature: %v", env.ConfigSignature)
+	}
+}
+
 func TestDataLayerGetEventLogByID(t *testing.T) {
 	dl, tdl := NewTestDataLayer(t)
 	if err := tdl.Setup(testDataPath); err != nil {
@@ -1932,6 +2078,74 @@ func TestDataLayerSetEventStatusImageCompleted(t *testing.T) {
 	}
 }
 
+func TestDataLayerSetEventStatusImageCached(t *testing.T) {
+	dl, tdl := NewTestDataLayer(t)
+	if err := tdl.Setup(testDataPath); err != nil {
+		t.Fatalf("error setting up test database: %v", err)
+	}
+	defer tdl.TearDown()
+	id := uuid.Must(uuid.Parse("c1e1e229-86d8-4d99-a3d5-62b2f6390bbe"))
+	if err := dl.SetEventStatusImageCached(id, "foo/bar"); err != nil {
+		t.Fatalf("should have succeeded: %v", err)
+	}
+	s, err := dl.GetEventStatus(id)
+	if err != nil {
+		t.Fatalf("get should have succeeded: %v", err)
+	}
+	img := s.Tree["foo/bar"].Image
+	if !img.Cached {
+		t.Fatalf("cached should have been set")
+	}
+	if img.Started.IsZero() || img.Completed.IsZero() {
+		t.Fatalf("started and completed should have been set")
+	}
+	if img.Error {
+		t.Fatalf("error should not have been set")
+	}
+}
+
+func TestDataLayerSubscribeEventLog(t *testing.T) {
+	dl, tdl := NewTestDataLayer(t)
+	if err := tdl.Setup(testDataPath); err != nil {
+		t.Fatalf("error setting up test database: %v", err)
+	}
+	defer tdl.TearDown()
+	id := uuid.Must(uuid.Parse("c1e1e229-86d8-4d99-a3d5-62b2f6390bbe"))
+	ctx, cf := context.WithCancel(context.Background())
+	updates, err := dl.SubscribeEventLog(ctx, id)
+	if err != nil {
+		t.Fatalf("should have succeeded: %v", err)
+	}
+	if err := dl.AppendToEventLog(id, "something happened"); err != nil {
+		t.Fatalf("append should have succeeded: %v", err)
+	}
+	select {
+	case <-updates:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timeout waiting for notification")
+	}
+	if err := dl.SetEventStatusImageStarted(id, "foo/bar"); err != nil {
+		t.Fatalf("set status should have succeeded: %v", err)
+	}
+	select {
+	case <-updates:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timeout waiting for notification")
+	}
+	cf()
+	select {
+	case _, ok := <-updates:
+		if ok {
+			// a pending notification may be delivered before the channel is closed
+			if _, ok := <-updates; ok {
+				t.Fatalf("channel should have been closed")
+			}
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timeout waiting for channel to be closed")
+	}
+}
+
 func TestDataLayerSetEventStatusChartStarted(t *testing.T) {
 	dl, tdl := NewTestDataLayer(t)
 	if err := tdl.Setup(testDataPath); err != nil {
@@ -0,0 +1,127 @@
+package persistence
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+)
+
+// eventLogNotifyChannel is the Postgres notification channel used by the event_logs trigger (see migrations)
+// The notification payload is the event log ID.
+const eventLogNotifyChannel = "event_log_updates"
+
+// eventLogSubscriptions tracks subscribers to event log changes
+// The zero value is ready to use.
+type eventLogSubscriptions struct {
+	sync.Mutex
+	subs map[uuid.UUID]map[chan struct{}]struct{}
+	// listener is only used by PGLayer
+	listener *pq.Listener
+}
+
+// subscribe registers a new subscriber for id that is removed (and the channel closed) when ctx is done
+func (els *eventLogSubscriptions) subscribe(ctx context.Context, id uuid.UUID) <-chan struct{} {
+	c := make(chan struct{}, 1)
+	els.Lock()
+	if els.subs == nil {
+		els.subs = make(map[uuid.UUID]map[chan struct{}]struct{})
+	}
+	if els.subs[id] == nil {
+		els.subs[id] = make(map[chan struct{}]struct{})
+	}
+	els.subs[id][c] = struct{}{}
+	els.Unlock()
+	go func() {
+		<-ctx.Done()
+		els.Lock()
+		defer els.Unlock()
+		delete(els.subs[id], c)
+		if len(els.subs[id]) == 0 {
+			delete(els.subs, id)
+		}
+		close(c)
+	}()
+	return c
+}
+
+// notify signals all subscribers for id without blocking. Pending signals are coalesced.
+func (els *eventLogSubscriptions) notify(id uuid.UUID) {
+	els.Lock()
+	defer els.Unlock()
+	for c := range els.subs[id] {
+		select {
+		case c <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// notifyAll signals all subscribers for all event logs
+func (els *eventLogSubscriptions) notifyAll() {
+	els.Lock()
+	defer els.Unlock()
+	for _, subs := range els.subs {
+		for c := range subs {
+			select {
+			case c <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
+
+// SubscribeEventLog returns a channel that receives a value whenever the event log with id is modified by any acyl instance.
+// Notifications are coalesced so subscribers should re-read the event log each time a value is received. The channel is closed when ctx is done.
+func (pg *PGLayer) SubscribeEventLog(ctx context.Context, id uuid.UUID) (<-chan struct{}, error) {
+	if err := pg.startEventLogListener(); err != nil {
+		return nil, errors.Wrap(err, "error starting event log listener")
+	}
+	return pg.elsubs.subscribe(ctx, id), nil
+}
+
+// startEventLogListener starts the shared event log notification listener if it isn't already running
+func (pg *PGLayer) startEventLogListener() error {
+	pg.elsubs.Lock()
+	defer pg.elsubs.Unlock()
+	if pg.elsubs.listener != nil {
+		return nil
+	}
+	l := pq.NewListener(pg.postgresURI, 10*time.Second, time.Minute, func(ev pq.ListenerEventType, err error) {
+		if err != nil && pg.logger != nil {
+			pg.logger.Printf("event log listener: event: %v: %v", ev, err)
+		}
+	})
+	if err := l.Listen(eventLogNotifyChannel); err != nil {
+		l.Close()
+		return errors.Wrap(err, "error listening on channel")
+	}
+	pg.elsubs.listener = l
+	go func() {
+		for n := range l.Notify {
+			if n == nil {
+				// the connection was re-established and notifications may have been missed
+				pg.elsubs.notifyAll()
+				continue
+			}
+			id, err := uuid.Parse(n.Extra)
+			if err != nil {
+				if pg.logger != nil {
+					pg.logger.Printf("event log listener: bad notification payload: %v: %v", n.Extra, err)
+				}
+				continue
+			}
+			pg.elsubs.notify(id)
+		}
+	}()
+	return nil
+}
+
+// SubscribeEventLog returns a channel that receives a value whenever the event log with id is modified.
+// The channel is closed when ctx is done.
+func (fdl *FakeDataLayer) SubscribeEventLog(ctx context.Context, id uuid.UUID) (<-chan struct{}, error) {
+	return fdl.elsubs.subscribe(ctx, id), nil
+}
@@ -249,6 +249,14 @@ func (pg *PGLayer) SetEventStatusImageCompleted(id uuid.UUID, name string, err b
 	return errors.Wrap(err2, "error setting event status image status to completed")
 }
 
+func (pg *PGLayer) SetEventStatusImageCached(id uuid.UUID, name string) error {
+	q := `UPDATE event_logs SET
+			status = jsonb_set(status, ARRAY['tree',$1,'image'], status->'tree'->$1->'image' || json_build_object('started', $2::text, 'completed', $2::text, 'error', false, 'cached', true)::jsonb)
+		  WHERE id = $3;`
+	_, err := pg.db.Exec(q, name, JSONTime(time.Now().UTC()), id)
+	return errors.Wrap(err, "error setting event status image to cached")
+}
+
 func (pg *PGLayer) SetEventStatusChartStarted(id uuid.UUID, name string, status models.NodeChartStatus) error {
 	q := `UPDATE event_logs SET
 			status = jsonb_set(status, ARRAY['tree',$1,'chart'], status->'tree'->$1->'chart' || json_build_object('status', $2::int, 'started', $3::text)::jsonb)
@@ -269,6 +277,38 @@ func (pg *PGLayer) SetEventStatusChartCompleted(id uuid.UUID, name string, statu
 	return errors.Wrap(err, "error setting event status chart status to completed")
 }
 
+// setEventStatusHealthCheck merges fields into the status of health check check for the tree node name, creating it if necessary
+func (pg *PGLayer) setEventStatusHealthCheck(id uuid.UUID, name, check string, fields map[string]interface{}) error {
+	fj, err := json.Marshal(fields)
+	if err != nil {
+		return errors.Wrap(err, "error marshaling fields")
+	}
+	q := `UPDATE event_logs SET
+			status = jsonb_set(status, ARRAY['tree',$1,'health_checks'], coalesce(status->'tree'->$1->'health_checks', '{}'::jsonb) || jsonb_build_object($2::text, coalesce(status->'tree'->$1->'health_checks'->$2, '{}'::jsonb) || $3::jsonb))
+		  WHERE id = $4;`
+	_, err = pg.db.Exec(q, name, check, string(fj), id)
+	return err
+}
+
+func (pg *PGLayer) SetEventStatusHealthCheckStarted(id uuid.UUID, name, check string) error {
+	err := pg.setEventStatusHealthCheck(id, name, check, map[string]interface{}{
+		"started":   JSONTime(time.Now().UTC()),
+		"completed": JSONTime(time.Time{}),
+		"error":     false,
+		"message":   "",
+	})
+	return errors.Wrap(err, "error setting event status health check to started")
+}
+
+func (pg *PGLayer) SetEventStatusHealthCheckCompleted(id uuid.UUID, name, check string, failed bool, message string) error {
+	err := pg.setEventStatusHealthCheck(id, name, check, map[string]interface{}{
+		"completed": JSONTime(time.Now().UTC()),
+		"error":     failed,
+		"message":   message,
+	})
+	return errors.Wrap(err, "error setting event status health check to completed")
+}
+
 func (pg *PGLayer) GetEventStatus(id uuid.UUID) (*models.EventStatusSummary, error) {
 	out := &models.EventStatusSummary{}
 	q := `SELECT status FROM event_logs WHERE id = $1;`
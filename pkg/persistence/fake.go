@@ -37,6 +37,7 @@ type FakeDataLayer struct {
 	CreateMissingEventLog bool
 	data                  *lockingDataMap
 	delay                 time.Duration
+	elsubs                eventLogSubscriptions
 }
 
 var _ DataLayer = &FakeDataLayer{}
@@ -458,6 +459,7 @@ func (fdl *FakeDataLayer) SetQAEnvironmentRepoData(ctx context.Context, name str
 		v.SourceSHA = rrd.SourceSHA
 		v.SourceBranch = rrd.SourceBranch
 		v.User = rrd.User
+		v.IsFork = v.IsFork || rrd.IsFork
 		return nil
 	}
 	return errors.New("env not found")
@@ -505,6 +507,65 @@ func (fdl *FakeDataLayer) SetQAEnvironmentCreated(ctx context.Context, name stri
 	return errors.New("env not found")
 }
 
+func (fdl *FakeDataLayer) SetQAEnvironmentPinnedUntil(ctx context.Context, name string, until *time.Time) error {
+	if isCancelled(ctx) {
+		return ctx.Err()
+	}
+	fdl.doDelay()
+	fdl.data.Lock()
+	defer fdl.data.Unlock()
+	if v, ok := fdl.data.d[name]; ok {
+		v.PinnedUntil = until
+		return nil
+	}
+	return errors.New("env not found")
+}
+
+func (fdl *FakeDataLayer) SetQAEnvironmentLastAccessed(ctx context.Context, name string, accessed time.Time) error {
+	if isCancelled(ctx) {
+		return ctx.Err()
+	}
+	fdl.doDelay()
+	fdl.data.Lock()
+	defer fdl.data.Unlock()
+	if v, ok := fdl.data.d[name]; ok {
+		if v.LastAccessed == nil || v.LastAccessed.Before(accessed) {
+			v.LastAccessed = &accessed
+		}
+		return nil
+	}
+	return errors.New("env not found")
+}
+
+func (fdl *FakeDataLayer) SetQAEnvironmentExpires(ctx context.Context, name string, expires *time.Time) error {
+	if isCancelled(ctx) {
+		return ctx.Err()
+	}
+	fdl.doDelay()
+	fdl.data.Lock()
+	defer fdl.data.Unlock()
+	if v, ok := fdl.data.d[name]; ok {
+		v.Expires = expires
+		v.ExpiryWarned = false
+		return nil
+	}
+	return errors.New("env not found")
+}
+
+func (fdl *FakeDataLayer) SetQAEnvironmentExpiryWarned(ctx context.Context, name string) error {
+	if isCancelled(ctx) {
+		return ctx.Err()
+	}
+	fdl.doDelay()
+	fdl.data.Lock()
+	defer fdl.data.Unlock()
+	if v, ok := fdl.data.d[name]; ok {
+		v.ExpiryWarned = true
+		return nil
+	}
+	return errors.New("env not found")
+}
+
 func (fdl *FakeDataLayer) SetAminoEnvironmentID(ctx context.Context, name string, did int) error {
 	if isCancelled(ctx) {
 		return ctx.Err()
@@ -877,6 +938,20 @@ func (fdl *FakeDataLayer) DeleteK8sEnv(ctx context.Context, name string) error {
 	return nil
 }
 
+func (fdl *FakeDataLayer) GetK8sEnvCountsByCluster(ctx context.Context) (map[string]uint, error) {
+	if isCancelled(ctx) {
+		return nil, ctx.Err()
+	}
+	fdl.doDelay()
+	fdl.data.RLock()
+	defer fdl.data.RUnlock()
+	out := map[string]uint{}
+	for _, v := range fdl.data.k8s {
+		out[v.Cluster]++
+	}
+	return out, nil
+}
+
 func (fdl *FakeDataLayer) UpdateK8sEnvConfigSignature(ctx context.Context, name string, confSig [32]byte) error {
 	if isCancelled(ctx) {
 		return ctx.Err()
@@ -890,10 +965,31 @@ func (fdl *FakeDataLayer) UpdateK8sEnvConfigSignature(ctx context.Context, name
 	return nil
 }
 
+func (fdl *FakeDataLayer) UpdateK8sEnvRepoConfig(ctx context.Context, name string, rcyaml []byte, refMapJSON string, confSig [32]byte) error {
+	if isCancelled(ctx) {
+		return ctx.Err()
+	}
+	fdl.doDelay()
+	fdl.data.Lock()
+	defer fdl.data.Unlock()
+	if _, ok := fdl.data.k8s[name]; ok {
+		fdl.data.k8s[name].RepoConfigYAML = rcyaml
+		fdl.data.k8s[name].RefMapJSON = refMapJSON
+		fdl.data.k8s[name].ConfigSignature = confSig[:]
+	}
+	return nil
+}
+
 func (fdl *FakeDataLayer) GetEventLogByID(id uuid.UUID) (*models.EventLog, error) {
 	fdl.doDelay()
 	fdl.data.RLock()
 	el, ok := fdl.data.elogs[id]
+	if ok {
+		// copy so callers can read it while it's being modified
+		elc := *el
+		elc.Status = copyEventStatus(el.Status)
+		el = &elc
+	}
 	fdl.data.RUnlock()
 	if !ok {
 		if fdl.CreateMissingEventLog {
@@ -968,6 +1064,7 @@ func (fdl *FakeDataLayer) CreateEventLog(elog *models.EventLog) error {
 	if elog.Created.IsZero() {
 		elog.Created = time.Now().UTC()
 	}
+	defer fdl.elsubs.notify(elog.ID)
 	fdl.data.Lock()
 	defer fdl.data.Unlock()
 	fdl.data.elogs[elog.ID] = elog
@@ -976,6 +1073,7 @@ func (fdl *FakeDataLayer) CreateEventLog(elog *models.EventLog) error {
 
 func (fdl *FakeDataLayer) AppendToEventLog(id uuid.UUID, msg string) error {
 	fdl.doDelay()
+	defer fdl.elsubs.notify(id)
 	fdl.data.Lock()
 	defer fdl.data.Unlock()
 	if fdl.data.elogs[id] == nil {
@@ -987,6 +1085,7 @@ func (fdl *FakeDataLayer) AppendToEventLog(id uuid.UUID, msg string) error {
 
 func (fdl *FakeDataLayer) SetEventLogEnvName(id uuid.UUID, name string) error {
 	fdl.doDelay()
+	defer fdl.elsubs.notify(id)
 	fdl.data.Lock()
 	defer fdl.data.Unlock()
 	if _, ok := fdl.data.d[name]; !ok {
@@ -1041,6 +1140,7 @@ func (fdl *FakeDataLayer) DeleteEventLogsByRepoAndPR(repo string, pr uint) (uint
 
 func (fdl *FakeDataLayer) SetEventStatus(id uuid.UUID, status models.EventStatusSummary) error {
 	fdl.doDelay()
+	defer fdl.elsubs.notify(id)
 	fdl.data.Lock()
 	defer fdl.data.Unlock()
 	elog := fdl.data.elogs[id]
@@ -1053,6 +1153,7 @@ func (fdl *FakeDataLayer) SetEventStatus(id uuid.UUID, status models.EventStatus
 
 func (fdl *FakeDataLayer) SetEventStatusConfig(id uuid.UUID, processingTime time.Duration, refmap map[string]string) error {
 	fdl.doDelay()
+	defer fdl.elsubs.notify(id)
 	fdl.data.Lock()
 	defer fdl.data.Unlock()
 	elog := fdl.data.elogs[id]
@@ -1066,6 +1167,7 @@ func (fdl *FakeDataLayer) SetEventStatusConfig(id uuid.UUID, processingTime time
 
 func (fdl *FakeDataLayer) SetEventStatusConfigK8sNS(id uuid.UUID, ns string) error {
 	fdl.doDelay()
+	defer fdl.elsubs.notify(id)
 	fdl.data.Lock()
 	defer fdl.data.Unlock()
 	elog := fdl.data.elogs[id]
@@ -1078,6 +1180,7 @@ func (fdl *FakeDataLayer) SetEventStatusConfigK8sNS(id uuid.UUID, ns string) err
 
 func (fdl *FakeDataLayer) SetEventStatusTree(id uuid.UUID, tree map[string]models.EventStatusTreeNode) error {
 	fdl.doDelay()
+	defer fdl.elsubs.notify(id)
 	fdl.data.Lock()
 	defer fdl.data.Unlock()
 	elog := fdl.data.elogs[id]
@@ -1090,6 +1193,7 @@ func (fdl *FakeDataLayer) SetEventStatusTree(id uuid.UUID, tree map[string]model
 
 func (fdl *FakeDataLayer) SetEventStatusCompleted(id uuid.UUID, status models.EventStatus) error {
 	fdl.doDelay()
+	defer fdl.elsubs.notify(id)
 	fdl.data.Lock()
 	defer fdl.data.Unlock()
 	elog := fdl.data.elogs[id]
@@ -1103,6 +1207,7 @@ func (fdl *FakeDataLayer) SetEventStatusCompleted(id uuid.UUID, status models.Ev
 
 func (fdl *FakeDataLayer) SetEventStatusFailed(id uuid.UUID, ce metahelm.ChartError) error {
 	fdl.doDelay()
+	defer fdl.elsubs.notify(id)
 	fdl.data.Lock()
 	defer fdl.data.Unlock()
 	elog := fdl.data.elogs[id]
@@ -1117,6 +1222,7 @@ func (fdl *FakeDataLayer) SetEventStatusFailed(id uuid.UUID, ce metahelm.ChartEr
 
 func (fdl *FakeDataLayer) SetEventStatusImageStarted(id uuid.UUID, name string) error {
 	fdl.doDelay()
+	defer fdl.elsubs.notify(id)
 	fdl.data.Lock()
 	defer fdl.data.Unlock()
 	elog := fdl.data.elogs[id]
@@ -1140,6 +1246,7 @@ func (fdl *FakeDataLayer) SetEventStatusImageStarted(id uuid.UUID, name string)
 
 func (fdl *FakeDataLayer) SetEventStatusImageBuildID(id uuid.UUID, name string, furanBuildID guuid.UUID) error {
 	fdl.doDelay()
+	defer fdl.elsubs.notify(id)
 	fdl.data.Lock()
 	defer fdl.data.Unlock()
 	elog := fdl.data.elogs[id]
@@ -1163,6 +1270,7 @@ func (fdl *FakeDataLayer) SetEventStatusImageBuildID(id uuid.UUID, name string,
 
 func (fdl *FakeDataLayer) SetEventStatusImageCompleted(id uuid.UUID, name string, err bool) error {
 	fdl.doDelay()
+	defer fdl.elsubs.notify(id)
 	fdl.data.Lock()
 	defer fdl.data.Unlock()
 	elog := fdl.data.elogs[id]
@@ -1179,8 +1287,31 @@ func (fdl *FakeDataLayer) SetEventStatusImageCompleted(id uuid.UUID, name string
 	return nil
 }
 
+func (fdl *FakeDataLayer) SetEventStatusImageCached(id uuid.UUID, name string) error {
+	fdl.doDelay()
+	defer fdl.elsubs.notify(id)
+	fdl.data.Lock()
+	defer fdl.data.Unlock()
+	elog := fdl.data.elogs[id]
+	if elog == nil {
+		return errors.New("eventlog not found")
+	}
+	tn, ok := elog.Status.Tree[name]
+	if !ok {
+		return fmt.Errorf("%v not found in tree", name)
+	}
+	now := time.Now().UTC()
+	tn.Image.Started = now
+	tn.Image.Completed = now
+	tn.Image.Error = false
+	tn.Image.Cached = true
+	elog.Status.Tree[name] = tn
+	return nil
+}
+
 func (fdl *FakeDataLayer) SetEventStatusChartStarted(id uuid.UUID, name string, status models.NodeChartStatus) error {
 	fdl.doDelay()
+	defer fdl.elsubs.notify(id)
 	fdl.data.Lock()
 	defer fdl.data.Unlock()
 	elog := fdl.data.elogs[id]
@@ -1199,6 +1330,7 @@ func (fdl *FakeDataLayer) SetEventStatusChartStarted(id uuid.UUID, name string,
 
 func (fdl *FakeDataLayer) SetEventStatusChartCompleted(id uuid.UUID, name string, status models.NodeChartStatus) error {
 	fdl.doDelay()
+	defer fdl.elsubs.notify(id)
 	fdl.data.Lock()
 	defer fdl.data.Unlock()
 	elog := fdl.data.elogs[id]
@@ -1215,10 +1347,58 @@ func (fdl *FakeDataLayer) SetEventStatusChartCompleted(id uuid.UUID, name string
 	return nil
 }
 
+// setEventStatusHealthCheck calls f with the status of health check check for the tree node name, creating it if necessary
+func (fdl *FakeDataLayer) setEventStatusHealthCheck(id uuid.UUID, name, check string, f func(hc *models.EventStatusTreeNodeHealthCheck)) error {
+	fdl.doDelay()
+	defer fdl.elsubs.notify(id)
+	fdl.data.Lock()
+	defer fdl.data.Unlock()
+	elog := fdl.data.elogs[id]
+	if elog == nil {
+		return errors.New("eventlog not found")
+	}
+	tn, ok := elog.Status.Tree[name]
+	if !ok {
+		return fmt.Errorf("%v not found in tree", name)
+	}
+	// copy the map since status copies returned to callers share it
+	hcs := make(map[string]models.EventStatusTreeNodeHealthCheck, len(tn.HealthChecks)+1)
+	for k, v := range tn.HealthChecks {
+		hcs[k] = v
+	}
+	hc := hcs[check]
+	f(&hc)
+	hcs[check] = hc
+	tn.HealthChecks = hcs
+	elog.Status.Tree[name] = tn
+	return nil
+}
+
+func (fdl *FakeDataLayer) SetEventStatusHealthCheckStarted(id uuid.UUID, name, check string) error {
+	return fdl.setEventStatusHealthCheck(id, name, check, func(hc *models.EventStatusTreeNodeHealthCheck) {
+		hc.Started = time.Now().UTC()
+		hc.Completed = time.Time{}
+		hc.Error = false
+		hc.Message = ""
+	})
+}
+
+func (fdl *FakeDataLayer) SetEventStatusHealthCheckCompleted(id uuid.UUID, name, check string, failed bool, message string) error {
+	return fdl.setEventStatusHealthCheck(id, name, check, func(hc *models.EventStatusTreeNodeHealthCheck) {
+		hc.Completed = time.Now().UTC()
+		hc.Error = failed
+		hc.Message = message
+	})
+}
+
 func (fdl *FakeDataLayer) GetEventStatus(id uuid.UUID) (*models.EventStatusSummary, error) {
 	fdl.doDelay()
 	fdl.data.RLock()
 	elog := fdl.data.elogs[id]
+	var out models.EventStatusSummary
+	if elog != nil {
+		out = copyEventStatus(elog.Status)
+	}
 	fdl.data.RUnlock()
 	if elog == nil {
 		if fdl.CreateMissingEventLog {
@@ -1227,12 +1407,24 @@ func (fdl *FakeDataLayer) GetEventStatus(id uuid.UUID) (*models.EventStatusSumma
 		}
 		return nil, nil
 	}
-	out := *elog
-	return &out.Status, nil
+	return &out, nil
+}
+
+// copyEventStatus returns a copy of s that doesn't share the tree with s
+func copyEventStatus(s models.EventStatusSummary) models.EventStatusSummary {
+	out := s
+	if s.Tree != nil {
+		out.Tree = make(map[string]models.EventStatusTreeNode, len(s.Tree))
+		for k, v := range s.Tree {
+			out.Tree[k] = v
+		}
+	}
+	return out
 }
 
 func (fdl *FakeDataLayer) SetEventStatusRenderedStatus(id uuid.UUID, rstatus models.RenderedEventStatus) error {
 	fdl.doDelay()
+	defer fdl.elsubs.notify(id)
 	fdl.data.Lock()
 	defer fdl.data.Unlock()
 	elog := fdl.data.elogs[id]
@@ -66,6 +66,39 @@ func (pg *PGLayer) UpdateK8sEnvConfigSignature(ctx context.Context, name string,
 	return errors.Wrap(err, "error updating k8s environment")
 }
 
+// UpdateK8sEnvRepoConfig updates an existing k8s environment repo config, ref map and config signature after an incremental update
+func (pg *PGLayer) UpdateK8sEnvRepoConfig(ctx context.Context, name string, rcyaml []byte, refMapJSON string, confSig [32]byte) error {
+	if isCancelled(ctx) {
+		return errors.Wrap(ctx.Err(), "error updating k8s env repo config")
+	}
+	q := `UPDATE kubernetes_environments SET repo_config_yaml = $1, ref_map_json = $2, config_signature = $3 WHERE env_name = $4;`
+	_, err := pg.db.ExecContext(ctx, q, rcyaml, refMapJSON, confSig[:], name)
+	return errors.Wrap(err, "error updating k8s environment")
+}
+
+// GetK8sEnvCountsByCluster returns a map of cluster name to the number of k8s environments placed in that cluster
+func (pg *PGLayer) GetK8sEnvCountsByCluster(ctx context.Context) (map[string]uint, error) {
+	if isCancelled(ctx) {
+		return nil, errors.Wrap(ctx.Err(), "error getting k8s env counts by cluster")
+	}
+	q := `SELECT cluster, count(*) FROM kubernetes_environments GROUP BY cluster;`
+	rows, err := pg.db.QueryContext(ctx, q)
+	if err != nil {
+		return nil, errors.Wrap(err, "error querying")
+	}
+	defer rows.Close()
+	out := map[string]uint{}
+	for rows.Next() {
+		var cluster string
+		var n uint
+		if err := rows.Scan(&cluster, &n); err != nil {
+			return nil, errors.Wrap(err, "error scanning row")
+		}
+		out[cluster] = n
+	}
+	return out, nil
+}
+
 func collectK8sEnvRows(rows *sql.Rows, err error) ([]models.KubernetesEnvironment, error) {
 	var k8senvs []models.KubernetesEnvironment
 	if err != nil {
@@ -127,6 +127,14 @@ func (_mr *_MockCollectorRecorder) Reaped(arg0, arg1, arg2, arg3 interface{}) *g
 	return _mr.mock.ctrl.RecordCall(_mr.mock, "Reaped", arg0, arg1, arg2, arg3)
 }
 
+func (_m *MockCollector) ResetEnvironmentCounts() {
+	_m.ctrl.Call(_m, "ResetEnvironmentCounts")
+}
+
+func (_mr *_MockCollectorRecorder) ResetEnvironmentCounts() *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "ResetEnvironmentCounts")
+}
+
 func (_m *MockCollector) Success(_param0 string, _param1 string, _param2 string) {
 	_m.ctrl.Call(_m, "Success", _param0, _param1, _param2)
 }
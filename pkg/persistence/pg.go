@@ -55,8 +55,10 @@ func PGClient(config *config.PGConfig) (*sqlx.DB, error) {
 // PGLayer contains the data layer implementation for a Postgres
 // database.
 type PGLayer struct {
-	db     *sqlx.DB
-	logger *log.Logger
+	db          *sqlx.DB
+	logger      *log.Logger
+	postgresURI string
+	elsubs      eventLogSubscriptions
 }
 
 // NewPGLayer instantiates a new PGLayer.
@@ -66,8 +68,9 @@ func NewPGLayer(cfg *config.PGConfig, logger *log.Logger) (*PGLayer, error) {
 		return nil, err
 	}
 	return &PGLayer{
-		db:     db,
-		logger: logger,
+		db:          db,
+		logger:      logger,
+		postgresURI: cfg.PostgresURI,
 	}, nil
 }
 
@@ -346,7 +349,8 @@ func (p *PGLayer) SetQAEnvironmentRepoData(ctx context.Context, name string, rep
 	if isCancelled(ctx) {
 		return errors.Wrap(ctx.Err(), "error setting qa environment repo data")
 	}
-	_, err := p.db.ExecContext(ctx, `UPDATE qa_environments SET repo = $1, source_sha = $2, pull_request = $3, base_sha = $4, source_branch = $5, base_branch = $6, username = $7 WHERE name = $8;`, repo.Repo, repo.SourceSHA, repo.PullRequest, repo.BaseSHA, repo.SourceBranch, repo.BaseBranch, repo.User, name)
+	// is_fork is never unset so that an environment for a fork PR can't become privileged
+	_, err := p.db.ExecContext(ctx, `UPDATE qa_environments SET repo = $1, source_sha = $2, pull_request = $3, base_sha = $4, source_branch = $5, base_branch = $6, username = $7, is_fork = (is_fork OR $8) WHERE name = $9;`, repo.Repo, repo.SourceSHA, repo.PullRequest, repo.BaseSHA, repo.SourceBranch, repo.BaseBranch, repo.User, repo.IsFork, name)
 	return err
 }
 
@@ -380,6 +384,44 @@ func (p *PGLayer) SetQAEnvironmentCreated(ctx context.Context, name string, crea
 	return err
 }
 
+// SetQAEnvironmentPinnedUntil pins an environment until a specific time, or unpins it if until is nil.
+func (p *PGLayer) SetQAEnvironmentPinnedUntil(ctx context.Context, name string, until *time.Time) error {
+	if isCancelled(ctx) {
+		return errors.Wrap(ctx.Err(), "error setting qa environment pinned until")
+	}
+	_, err := p.db.ExecContext(ctx, `UPDATE qa_environments SET pinned_until = $1 WHERE name = $2;`, until, name)
+	return err
+}
+
+// SetQAEnvironmentLastAccessed records the time an environment was accessed through the UI or API.
+// The timestamp is never moved backwards.
+func (p *PGLayer) SetQAEnvironmentLastAccessed(ctx context.Context, name string, accessed time.Time) error {
+	if isCancelled(ctx) {
+		return errors.Wrap(ctx.Err(), "error setting qa environment last accessed")
+	}
+	_, err := p.db.ExecContext(ctx, `UPDATE qa_environments SET last_accessed = $1 WHERE name = $2 AND (last_accessed IS NULL OR last_accessed < $1);`, accessed, name)
+	return err
+}
+
+// SetQAEnvironmentExpires sets the time an environment expires, or removes the expiry if expires is nil.
+// The expiry warning is reset so that it is sent again before the new expiry.
+func (p *PGLayer) SetQAEnvironmentExpires(ctx context.Context, name string, expires *time.Time) error {
+	if isCancelled(ctx) {
+		return errors.Wrap(ctx.Err(), "error setting qa environment expires")
+	}
+	_, err := p.db.ExecContext(ctx, `UPDATE qa_environments SET expires = $1, expiry_warned = false WHERE name = $2;`, expires, name)
+	return err
+}
+
+// SetQAEnvironmentExpiryWarned records that the expiry warning has been sent for an environment.
+func (p *PGLayer) SetQAEnvironmentExpiryWarned(ctx context.Context, name string) error {
+	if isCancelled(ctx) {
+		return errors.Wrap(ctx.Err(), "error setting qa environment expiry warned")
+	}
+	_, err := p.db.ExecContext(ctx, `UPDATE qa_environments SET expiry_warned = true WHERE name = $1;`, name)
+	return err
+}
+
 // GetExtantQAEnvironments finds any environments for the given repo/PR combination that
 // are not status Destroyed
 func (p *PGLayer) GetExtantQAEnvironments(ctx context.Context, repo string, pr uint) ([]QAEnvironment, error) {
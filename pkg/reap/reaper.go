@@ -4,12 +4,13 @@ import (
 	"context"
 	"fmt"
 	"log"
-	"sort"
 	"strings"
 	"time"
 
 	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/tracer"
 
+	"github.com/dollarshaveclub/acyl/pkg/config"
+	"github.com/dollarshaveclub/acyl/pkg/eviction"
 	"github.com/dollarshaveclub/acyl/pkg/ghclient"
 	"github.com/dollarshaveclub/acyl/pkg/locker"
 	"github.com/dollarshaveclub/acyl/pkg/models"
@@ -30,6 +31,7 @@ type ReaperMetricsCollector interface {
 	Pruned(int)
 	Reaped(string, string, models.QADestroyReason, error)
 	EnvironmentCount(string, models.EnvironmentStatus, uint)
+	ResetEnvironmentCounts()
 }
 
 // Reaper is an object that does periodic cleanup
@@ -40,12 +42,19 @@ type Reaper struct {
 	rc          ghclient.RepoClient
 	mc          ReaperMetricsCollector
 	globalLimit uint
+	quotas      config.EnvironmentQuotas
+	policy      eviction.Policy
+	suspendIdle time.Duration
+	warnExpiry  time.Duration
 	logger      *log.Logger
 	lockKey     int64
 }
 
-// NewReaper returns a Reaper object using the supplied dependencies
-func NewReaper(lp locker.LockProvider, dl persistence.DataLayer, es spawner.EnvironmentSpawner, rc ghclient.RepoClient, mc ReaperMetricsCollector, globalLimit uint, logger *log.Logger, lockKey int64) *Reaper {
+// NewReaper returns a Reaper object using the supplied dependencies.
+// Successful environments idle for longer than suspendIdle will be suspended (set to zero to disable).
+// Environments exceeding the global limit, or a quota if quotas evict, are chosen for destruction by policy.
+// Expired environments are destroyed, and the expiry warning is sent warnExpiry before they expire (set to zero to disable the warning).
+func NewReaper(lp locker.LockProvider, dl persistence.DataLayer, es spawner.EnvironmentSpawner, rc ghclient.RepoClient, mc ReaperMetricsCollector, globalLimit uint, quotas config.EnvironmentQuotas, policy eviction.Policy, suspendIdle, warnExpiry time.Duration, logger *log.Logger, lockKey int64) *Reaper {
 	return &Reaper{
 		lp:          lp,
 		dl:          dl,
@@ -53,6 +62,10 @@ func NewReaper(lp locker.LockProvider, dl persistence.DataLayer, es spawner.Envi
 		rc:          rc,
 		mc:          mc,
 		globalLimit: globalLimit,
+		quotas:      quotas,
+		policy:      policy,
+		suspendIdle: suspendIdle,
+		warnExpiry:  warnExpiry,
 		lockKey:     lockKey,
 		logger:      logger,
 	}
@@ -93,6 +106,18 @@ func (r *Reaper) Reap() {
 	if err != nil {
 		r.logger.Printf("error destroying environments associated with closed PRs: %v", err)
 	}
+	err = r.expireEnvironments(ctx)
+	if err != nil {
+		r.logger.Printf("error expiring environments: %v", err)
+	}
+	err = r.suspendIdleEnvironments(ctx)
+	if err != nil {
+		r.logger.Printf("error suspending idle environments: %v", err)
+	}
+	err = r.enforceQuotas(ctx)
+	if err != nil {
+		r.logger.Printf("error enforcing environment quotas: %v", err)
+	}
 	err = r.enforceGlobalLimit(ctx)
 	if err != nil {
 		r.logger.Printf("error enforcing global limit (%v): %v", r.globalLimit, err)
@@ -117,6 +142,8 @@ func (r *Reaper) auditQaEnvs(ctx context.Context) error {
 		repoMap[qa.Repo][qa.Status]++
 	}
 
+	// clear counts from the previous pass so repos and statuses with no remaining environments aren't reported
+	r.mc.ResetEnvironmentCounts()
 	for repoName, repoStatuses := range repoMap {
 		for status, num := range repoStatuses {
 			r.mc.EnvironmentCount(repoName, status, num)
@@ -133,6 +160,10 @@ func (r *Reaper) destroyClosedPRs(ctx context.Context) error {
 	}
 	var prs string
 	for _, qa := range qas {
+		// tracked branch environments have no PR and are never reaped for PR closure
+		if qa.PullRequest == 0 {
+			continue
+		}
 		if qa.Status != models.Destroyed {
 			prs, err = r.rc.GetPRStatus(context.Background(), qa.Repo, qa.PullRequest)
 			if err != nil {
@@ -221,29 +252,162 @@ func (r *Reaper) destroyFailedOrStuckEnvironments(ctx context.Context) error {
 	return nil
 }
 
+// expireEnvironments destroys environments whose lifetime has expired, and sends the expiry warning for environments that will expire soon.
+// If the warning is enabled, an expired environment that wasn't warned has its expiry postponed by the warning duration and is warned instead of being destroyed.
+// Pinned environments and environments with an operation in progress are skipped until a later pass.
+func (r *Reaper) expireEnvironments(ctx context.Context) error {
+	qas, err := r.dl.GetQAEnvironments(ctx)
+	if err != nil {
+		return fmt.Errorf("error getting QA environments: %v", err)
+	}
+	now := time.Now().UTC()
+	for _, qa := range qas {
+		switch qa.Status {
+		case models.Success, models.Failure, models.Suspended:
+		default:
+			continue
+		}
+		if qa.Expires == nil || qa.Pinned(now) {
+			continue
+		}
+		if qa.Expired(now) {
+			if r.warnExpiry > 0 && !qa.ExpiryWarned {
+				// never destroy without warning (eg, if the lifetime is shorter than the warning or the reaper wasn't running), so warn and postpone the expiry instead
+				expires := now.Add(r.warnExpiry)
+				r.logger.Printf("reaper: postponing expiry of environment that was not warned: %v (expired %v, now expires %v)", qa.Name, qa.Expires, expires)
+				if err := r.dl.SetQAEnvironmentExpires(ctx, qa.Name, &expires); err != nil {
+					r.logger.Printf("error setting expires for %v: %v", qa.Name, err)
+					continue
+				}
+				r.dl.AddEvent(context.Background(), qa.Name, "expiry postponed until "+expires.Format(time.RFC3339)+" to send the expiry warning")
+				qa.Expires = &expires
+				r.sendExpiryWarning(ctx, &qa)
+				continue
+			}
+			r.logger.Printf("reaper: destroying expired environment: %v (expired %v)", qa.Name, qa.Expires)
+			r.dl.AddEvent(context.Background(), qa.Name, "lifetime expired at "+qa.Expires.UTC().Format(time.RFC3339))
+			err := r.es.DestroyExplicitly(context.Background(), &qa, models.ReapEnvironmentExpired)
+			if err != nil {
+				r.logger.Printf("error destroying %v: %v", qa.Name, err)
+			}
+			r.mc.Reaped(qa.Name, qa.Repo, models.ReapEnvironmentExpired, err)
+			continue
+		}
+		if r.warnExpiry == 0 || qa.ExpiryWarned || qa.Expires.Sub(now) > r.warnExpiry {
+			continue
+		}
+		r.sendExpiryWarning(ctx, &qa)
+	}
+	return nil
+}
+
+// sendExpiryWarning sends the expiry warning for qa and records that it was sent
+func (r *Reaper) sendExpiryWarning(ctx context.Context, qa *models.QAEnvironment) {
+	r.logger.Printf("reaper: sending expiry warning: %v (expires %v)", qa.Name, qa.Expires)
+	if err := r.es.WarnExpiry(context.Background(), qa); err != nil {
+		r.logger.Printf("error sending expiry warning for %v: %v", qa.Name, err)
+		return
+	}
+	if err := r.dl.SetQAEnvironmentExpiryWarned(ctx, qa.Name); err != nil {
+		r.logger.Printf("error setting expiry warned for %v: %v", qa.Name, err)
+	}
+}
+
+// suspendIdleEnvironments suspends successful environments that have had no activity for longer than the configured duration.
+// Pinned environments are never suspended.
+func (r *Reaper) suspendIdleEnvironments(ctx context.Context) error {
+	if r.suspendIdle == 0 {
+		return nil
+	}
+	qas, err := r.dl.GetQAEnvironmentsByStatus(ctx, strings.ToLower(models.Success.String()))
+	if err != nil {
+		return fmt.Errorf("error getting successful environments: %v", err)
+	}
+	for _, qa := range qas {
+		if time.Since(qa.LastActivity()) <= r.suspendIdle || qa.Pinned(time.Now().UTC()) {
+			continue
+		}
+		r.logger.Printf("reaper: suspending environment idle for more than %v: %v (last activity %v)", r.suspendIdle, qa.Name, qa.LastActivity())
+		if err := r.es.Suspend(context.Background(), *qa.RepoRevisionDataFromQA()); err != nil {
+			r.logger.Printf("error suspending %v: %v", qa.Name, err)
+		}
+	}
+	return nil
+}
+
 func (r *Reaper) enforceGlobalLimit(ctx context.Context) error {
 	if r.globalLimit == 0 {
 		return nil
 	}
-	qae, err := r.dl.GetRunningQAEnvironments(ctx)
+	qae, err := eviction.Candidates(ctx, r.dl, r.policy)
 	if err != nil {
-		return fmt.Errorf("error getting running environments: %v", err)
+		return fmt.Errorf("error getting eviction candidates: %v", err)
 	}
-	if len(qae) > int(r.globalLimit) {
-		kc := len(qae) - int(r.globalLimit)
-		sort.Slice(qae, func(i int, j int) bool { return qae[i].Created.Before(qae[j].Created) })
-		kenvs := qae[0:kc]
-		r.logger.Printf("reaper: enforcing global limit: extant: %v, limit: %v, destroying: %v", len(qae), r.globalLimit, kc)
-		for _, e := range kenvs {
-			env := e
-			r.logger.Printf("reaper: destroying: %v (created %v)", env.Name, env.Created)
-			err := r.es.DestroyExplicitly(context.Background(), &env, models.ReapEnvironmentLimitExceeded)
-			if err != nil {
-				r.logger.Printf("error destroying environment for exceeding limit: %v", err)
-			}
+	// only running environments count towards the limit, but the policy may prefer to evict others first
+	extant := eviction.CountRunning(qae)
+	if extant > int(r.globalLimit) {
+		kc := extant - int(r.globalLimit)
+		victims, running := eviction.Select(r.policy, qae, kc, time.Now().UTC())
+		r.logger.Printf("reaper: enforcing global limit with %v eviction policy: extant: %v, limit: %v, destroying: %v", r.policy.Name(), extant, r.globalLimit, len(victims))
+		if running < kc {
+			r.logger.Printf("reaper: not enough environments are eligible for eviction (the others are pinned), global limit remains exceeded")
 		}
+		r.evict(victims, models.ReapEnvironmentLimitExceeded)
 	} else {
-		r.logger.Printf("global limit not exceeded: running: %v, limit: %v", len(qae), r.globalLimit)
+		r.logger.Printf("global limit not exceeded: extant: %v, limit: %v", extant, r.globalLimit)
+	}
+	return nil
+}
+
+// evict destroys victims chosen by the eviction policy, recording the policy and the reason each was chosen
+func (r *Reaper) evict(victims []eviction.Victim, reason models.QADestroyReason) {
+	for _, v := range victims {
+		env := v.Env
+		ev := eviction.Eviction{Policy: r.policy.Name(), Reason: v.Reason}
+		r.logger.Printf("reaper: destroying: %v (%v)", env.Name, ev)
+		r.dl.AddEvent(context.Background(), env.Name, ev.String())
+		if err := r.es.DestroyExplicitly(eviction.NewEvictionContext(context.Background(), ev), &env, reason); err != nil {
+			r.logger.Printf("error destroying evicted environment: %v", err)
+		}
+	}
+}
+
+// enforceQuotas destroys environments chosen by the eviction policy within each repo, org and user quota scope that exceeds its quota (for example, after quotas are lowered).
+// Quotas are only enforced if they are configured to evict.
+func (r *Reaper) enforceQuotas(ctx context.Context) error {
+	if !r.quotas.Evict {
+		return nil
+	}
+	qae, err := eviction.Candidates(ctx, r.dl, r.policy)
+	if err != nil {
+		return fmt.Errorf("error getting eviction candidates: %v", err)
+	}
+	checked := map[string]struct{}{}
+	destroyed := map[string]struct{}{}
+	for _, e := range qae {
+		for _, q := range r.quotas.Quotas(e.Repo, e.User) {
+			if _, ok := checked[q.String()]; ok {
+				continue
+			}
+			checked[q.String()] = struct{}{}
+			scoped := []models.QAEnvironment{}
+			for _, e2 := range qae {
+				if _, ok := destroyed[e2.Name]; !ok && q.Matches(e2) {
+					scoped = append(scoped, e2)
+				}
+			}
+			extant := eviction.CountRunning(scoped)
+			if extant <= int(q.Limit) {
+				continue
+			}
+			kc := extant - int(q.Limit)
+			victims, _ := eviction.Select(r.policy, scoped, kc, time.Now().UTC())
+			r.logger.Printf("reaper: enforcing %v quota with %v eviction policy: extant: %v, limit: %v, destroying: %v", q, r.policy.Name(), extant, q.Limit, len(victims))
+			r.evict(victims, models.ReapEnvironmentQuotaExceeded)
+			for _, v := range victims {
+				destroyed[v.Env.Name] = struct{}{}
+			}
+		}
 	}
 	return nil
 }
@@ -31,6 +31,7 @@ const (
 	tlsKeyid                   = "tls/key"
 	dbURIid                    = "db/uri"
 	furan2apikey               = "furan2/api_key"
+	webhookNotificationsSecret = "notifications/webhook_secret"
 )
 
 type SecretFetcher interface {
@@ -202,5 +203,12 @@ func (psf *PVCSecretsFetcher) PopulateServer(srv *config.ServerConfig) error {
 		return errors.Wrap(err, "error getting Furan 2 API key")
 	}
 	srv.Furan2APIKey = string(s)
+	if srv.WebhookNotifications {
+		s, err = psf.sc.Get(webhookNotificationsSecret)
+		if err != nil {
+			return errors.Wrap(err, "error getting webhook notifications signing secret")
+		}
+		srv.WebhookNotificationsSecret = s
+	}
 	return nil
 }
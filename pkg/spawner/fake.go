@@ -15,6 +15,9 @@ type FakeEnvironmentSpawner struct {
 	DestroyExplicitlyFunc func(ctx context.Context, env *models.QAEnvironment, reason models.QADestroyReason) error
 	SuccessFunc           func(ctx context.Context, name string) error
 	FailureFunc           func(ctx context.Context, name, msg string) error
+	SuspendFunc           func(ctx context.Context, rd models.RepoRevisionData) error
+	ResumeFunc            func(ctx context.Context, rd models.RepoRevisionData) error
+	WarnExpiryFunc        func(ctx context.Context, env *models.QAEnvironment) error
 }
 
 func (fes *FakeEnvironmentSpawner) Create(ctx context.Context, rd models.RepoRevisionData) (string, error) {
@@ -35,3 +38,12 @@ func (fes *FakeEnvironmentSpawner) Success(ctx context.Context, name string) err
 func (fes *FakeEnvironmentSpawner) Failure(ctx context.Context, name, msg string) error {
 	return fes.FailureFunc(ctx, name, msg)
 }
+func (fes *FakeEnvironmentSpawner) Suspend(ctx context.Context, rd models.RepoRevisionData) error {
+	return fes.SuspendFunc(ctx, rd)
+}
+func (fes *FakeEnvironmentSpawner) Resume(ctx context.Context, rd models.RepoRevisionData) error {
+	return fes.ResumeFunc(ctx, rd)
+}
+func (fes *FakeEnvironmentSpawner) WarnExpiry(ctx context.Context, env *models.QAEnvironment) error {
+	return fes.WarnExpiryFunc(ctx, env)
+}
@@ -13,4 +13,7 @@ type EnvironmentSpawner interface {
 	DestroyExplicitly(context.Context, *models.QAEnvironment, models.QADestroyReason) error
 	Success(context.Context, string) error
 	Failure(context.Context, string, string) error
+	Suspend(context.Context, models.RepoRevisionData) error
+	Resume(context.Context, models.RepoRevisionData) error
+	WarnExpiry(context.Context, *models.QAEnvironment) error
 }
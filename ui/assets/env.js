@@ -47,7 +47,12 @@ function renderDetailTable(env) {
     document.getElementById("status-badge").innerHTML = envstatlabel;
     document.getElementById("status-badge").className = envstatclasses;
     document.getElementById("env-repo").innerHTML = `<a href="https://github.com/${env.repo}">https://github.com/${env.repo}</a>`;
-    document.getElementById("env-pr-link").innerHTML = `<a href="https://github.com/${env.repo}/pull/${env.pull_request}">https://github.com/${env.repo}/pull/${env.pull_request}</a>`;
+    if (env.pull_request === 0) {
+        // environments without a PR link to the ref they were created from
+        document.getElementById("env-pr-link").innerHTML = `<a href="https://github.com/${env.repo}/tree/${env.source_ref}">https://github.com/${env.repo}/tree/${env.source_ref}</a>`;
+    } else {
+        document.getElementById("env-pr-link").innerHTML = `<a href="https://github.com/${env.repo}/pull/${env.pull_request}">https://github.com/${env.repo}/pull/${env.pull_request}</a>`;
+    }
     document.getElementById("env-user-link").innerHTML = `<a href="https://github.com/${env.github_user}">${env.github_user}</a>`;
     document.getElementById("trepo-branch").innerHTML = env.pr_head_branch;
     updateNSCopyBtn(env.k8s_namespace);
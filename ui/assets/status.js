@@ -98,7 +98,8 @@ function updateConfig(cfg) {
     document.getElementById("status-link-btn").className = `btn btn-sm ${slinkbtnclass}`;
     document.getElementById("status-link-btn").href = cfg.rendered_status.link_target_url;
     document.getElementById("status-link-title").innerHTML = `${cfg.rendered_status.description}`;
-    const prurl = `https://github.com/${cfg.triggering_repo}/pull/${cfg.pull_request}`;
+    // environments without a PR link to the ref they were created from
+    const prurl = cfg.pull_request === 0 ? `https://github.com/${cfg.triggering_repo}/tree/${cfg.branch}` : `https://github.com/${cfg.triggering_repo}/pull/${cfg.pull_request}`;
     document.getElementById("trepo-pr-link").text = prurl;
     document.getElementById("trepo-pr-link").href = prurl;
     const userurl = `https://github.com/${cfg.github_user}`;
@@ -262,6 +263,12 @@ function updateTree(treedata) {
                 .attr("class", "tt-chart-btn btn btn-secondary btn-sm")
                 .html("Chart: Waiting");
 
+            nttd.append("button")
+                .attr("type", "button")
+                .style("display", "none")
+                .style("pointer-events", "none")
+                .attr("class", "tt-hc-btn btn btn-secondary btn-sm");
+
             // image build details modal
             let mcnt = d3.select("body")
                 .append("div")
@@ -512,7 +519,7 @@ function updateTree(treedata) {
             let verb = "Building";
             let icon = "fa-spinner fa-pulse";
             if (d.data.image.completed != null) {
-                verb = (d.data.image.error) ? "Error" : "Done";
+                verb = (d.data.image.error) ? "Error" : (d.data.image.cached) ? "Cached" : "Done";
                 icon = (d.data.image.error) ? "fa-exclamation-circle" : "fa-check-circle";
             }
             const info = `<i class="fas ${icon}"></i>`;
@@ -572,6 +579,35 @@ function updateTree(treedata) {
             }
         });
 
+    tt.select(".tt-hc-btn")
+        .style("display", function(d) {
+            return (d.data.health_checks) ? "block" : "none";
+        })
+        .attr("class", function(d) {
+            const hcs = Object.values(d.data.health_checks || {});
+            if (hcs.some(hc => hc.status === "failed")) {
+                return "tt-hc-btn btn btn-danger btn-sm";
+            }
+            if (hcs.length > 0 && hcs.every(hc => hc.status === "passed")) {
+                return "tt-hc-btn btn btn-success btn-sm";
+            }
+            if (hcs.some(hc => hc.status === "running")) {
+                return "tt-hc-btn btn btn-warning btn-sm";
+            }
+            return "tt-hc-btn btn btn-secondary btn-sm";
+        })
+        .attr("title", function(d) {
+            return Object.entries(d.data.health_checks || {})
+                .map(([name, hc]) => `${name} (${hc.type}): ${hc.status}${hc.message ? ": " + hc.message : ""}`)
+                .join("\n");
+        })
+        .text(function(d) {
+            const hcs = Object.values(d.data.health_checks || {});
+            const passed = hcs.filter(hc => hc.status === "passed").length;
+            const failed = hcs.filter(hc => hc.status === "failed").length;
+            return failed > 0 ? `Health Checks: ${failed} failed` : `Health Checks: ${passed}/${hcs.length} passed`;
+        });
+
     // Nodes should never be removed, but if they go missing from the model remove them
     node.exit().remove();
 